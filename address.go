@@ -0,0 +1,179 @@
+package serverlib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrInvalidAddress is wrapped by the errors of ServerConfig.Validate for
+	// the addresses that cannot be bound.
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrAddrInUse is wrapped by the BindError of an address another socket
+	// listens on already.
+	ErrAddrInUse = errors.New("address already in use")
+	// ErrPermissionDenied is wrapped by the BindError of an address the
+	// process may not bind, e.g. a port below 1024 without the privileges.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// BindError is the error of Start, and the other ways to start the server,
+// when an address cannot be bound. It wraps the error of the system, and
+// ErrAddrInUse or ErrPermissionDenied when recognized:
+//
+//	if errors.Is(err, serverlib.ErrPermissionDenied) {
+//		log.Fatal("run as root, or set a port above 1024: ", err)
+//	}
+type BindError struct {
+	// Address is the configured address, e.g. "localhost:80".
+	Address string
+	// Resolved is the address actually bound, e.g. "127.0.0.1:80", empty
+	// when it cannot be resolved.
+	Resolved string
+	// Kind is ErrAddrInUse, ErrPermissionDenied or nil.
+	Kind error
+	Err  error
+}
+
+func (e *BindError) Error() string {
+	var b strings.Builder
+	b.WriteString("bind ")
+	b.WriteString(e.Address)
+	if e.Resolved != "" && e.Resolved != e.Address {
+		b.WriteString(" (" + e.Resolved + ")")
+	}
+	switch {
+	case e.Kind == ErrPermissionDenied && privilegedPort(e.Address):
+		_, port, _ := net.SplitHostPort(e.Address)
+		b.WriteString(": port " + port + " requires elevated privileges")
+	case e.Kind == ErrAddrInUse:
+		b.WriteString(": address already in use by another process or server")
+	case e.Kind != nil:
+		b.WriteString(": " + e.Kind.Error())
+	}
+	b.WriteString(": " + e.Err.Error())
+	return b.String()
+}
+
+// Unwrap returns the kind of the error and the error of the system.
+func (e *BindError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newBindError wraps err, binding address failed.
+func newBindError(address string, err error) error {
+	e := &BindError{Address: address, Err: err}
+	if path, ok := strings.CutPrefix(address, UnixAddressPrefix); ok {
+		e.Resolved = path
+	} else if resolved, resolveErr := net.ResolveTCPAddr("tcp", address); resolveErr == nil {
+		e.Resolved = resolved.String()
+	}
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		e.Kind = ErrAddrInUse
+	case errors.Is(err, os.ErrPermission):
+		e.Kind = ErrPermissionDenied
+	}
+	return e
+}
+
+// privilegedPort reports whether the port of the TCP address is below 1024.
+func privilegedPort(address string) bool {
+	if strings.HasPrefix(address, UnixAddressPrefix) {
+		return false
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	number, err := net.LookupPort("tcp", port)
+	return err == nil && number > 0 && number < 1024
+}
+
+// Validate checks the addresses of the configuration, ServerConfig.Address
+// and ServerConfig.Listeners, without binding them, so that a typo fails at
+// startup rather than at Start: a port missing or out of range, an unknown
+// service name, a malformed host or an empty unix socket path. An empty
+// Address is valid, NewServer defaulting it. It does not tell whether the
+// addresses are free, see BindError.
+//
+// Returns:
+//   - error: The errors of the invalid addresses, joined, each wrapping
+//     ErrInvalidAddress, or nil.
+func (config ServerConfig) Validate() error {
+	var errs []error
+	if config.Address != "" {
+		errs = append(errs, validateAddress(config.Address))
+	}
+	for _, listener := range config.Listeners {
+		if listener.Address == "" {
+			errs = append(errs, fmt.Errorf("%w: empty listener address", ErrInvalidAddress))
+			continue
+		}
+		errs = append(errs, validateAddress(listener.Address))
+	}
+	return errors.Join(errs...)
+}
+
+// validateAddress checks a TCP address or a unix socket.
+func validateAddress(address string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidAddress, address, reason)
+	}
+	if path, ok := strings.CutPrefix(address, UnixAddressPrefix); ok {
+		if path == "" {
+			return invalid("empty unix socket path")
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		if _, numeric := strconv.Atoi(address); numeric == nil {
+			return invalid(`missing ":" before the port, e.g. ":` + address + `"`)
+		}
+		return invalid(`expected "host:port", e.g. ":8080" or "localhost:8080"`)
+	}
+	if number, err := strconv.Atoi(port); err == nil {
+		if number < 0 || number > 65535 {
+			return invalid("port " + port + " out of the range 0-65535")
+		}
+	} else if port == "" {
+		return invalid("missing port")
+	} else if _, err := net.LookupPort("tcp", port); err != nil {
+		return invalid("unknown port " + strconv.Quote(port))
+	}
+	if host != "" && !validHost(host) {
+		return invalid("malformed host " + strconv.Quote(host))
+	}
+	return nil
+}
+
+// validHost reports whether host is an IP address or a host name.
+func validHost(host string) bool {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package serverlib
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ServerConfig
+		wantErr   bool
+		wantCause string
+	}{
+		{"empty", ServerConfig{}, false, ""},
+		{"port", ServerConfig{Address: ":8080"}, false, ""},
+		{"host and port", ServerConfig{Address: "localhost:8080"}, false, ""},
+		{"IPv4", ServerConfig{Address: "127.0.0.1:0"}, false, ""},
+		{"IPv6", ServerConfig{Address: "[::1]:443"}, false, ""},
+		{"service name", ServerConfig{Address: "localhost:https"}, false, ""},
+		{"unix socket", ServerConfig{Address: UnixAddressPrefix + "/run/app.sock"}, false, ""},
+		{"listeners", ServerConfig{Address: ":8080", Listeners: []ListenAddress{{Address: ":8443", TLS: true}}}, false, ""},
+		{"port alone", ServerConfig{Address: "8080"}, true, `missing ":" before the port, e.g. ":8080"`},
+		{"no port", ServerConfig{Address: "localhost"}, true, `expected "host:port"`},
+		{"empty port", ServerConfig{Address: "localhost:"}, true, "missing port"},
+		{"port out of range", ServerConfig{Address: ":65536"}, true, "port 65536 out of the range 0-65535"},
+		{"unknown service", ServerConfig{Address: ":htp"}, true, `unknown port "htp"`},
+		{"malformed host", ServerConfig{Address: "local host:80"}, true, `malformed host "local host"`},
+		{"empty label", ServerConfig{Address: "example..com:80"}, true, "malformed host"},
+		{"label starting with a hyphen", ServerConfig{Address: "-example.com:80"}, true, "malformed host"},
+		{"empty unix socket", ServerConfig{Address: UnixAddressPrefix}, true, "empty unix socket path"},
+		{"empty listener", ServerConfig{Listeners: []ListenAddress{{TLS: true}}}, true, "empty listener address"},
+		{"invalid listener", ServerConfig{Address: ":8080", Listeners: []ListenAddress{{Address: "8443"}}}, true, `"8443"`},
+	}
+	for _, tt := range tests {
+		err := tt.config.Validate()
+		if (err != nil) != tt.wantErr || (err != nil && (!errors.Is(err, ErrInvalidAddress) || !strings.Contains(err.Error(), tt.wantCause))) {
+			t.Errorf("%s: %v, want error %v with %q", tt.name, err, tt.wantErr, tt.wantCause)
+		}
+	}
+	// Every invalid address is reported.
+	err := ServerConfig{Address: "8080", Listeners: []ListenAddress{{Address: ":99999"}}}.Validate()
+	if err == nil || !strings.Contains(err.Error(), `"8080"`) || !strings.Contains(err.Error(), `":99999"`) {
+		t.Errorf("two invalid addresses: %v", err)
+	}
+}
+
+func TestNewServerE(t *testing.T) {
+	if s, err := NewServerE(ServerConfig{Address: "8080"}); s != nil || !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("invalid address: %v %v", s, err)
+	}
+	if s, err := NewServerE(ServerConfig{Address: "127.0.0.1:0"}); s == nil || err != nil {
+		t.Errorf("valid address: %v %v", s, err)
+	}
+	if s, err := NewServerE(); s == nil || err != nil {
+		t.Errorf("no configuration: %v %v", s, err)
+	}
+}
+
+func TestBindError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	inUse := NewServer(ServerConfig{Address: "localhost:" + port}).Start()
+	var bindErr *BindError
+	if !errors.As(inUse, &bindErr) || !errors.Is(inUse, ErrAddrInUse) || !errors.Is(inUse, syscall.EADDRINUSE) {
+		t.Fatalf("address in use: %v", inUse)
+	}
+	if bindErr.Address != "localhost:"+port || bindErr.Resolved == "" {
+		t.Errorf("address in use: %+v", bindErr)
+	}
+	if !strings.Contains(inUse.Error(), "address already in use by another process or server") {
+		t.Errorf("address in use: %q", inUse.Error())
+	}
+
+	denied := &os.SyscallError{Syscall: "bind", Err: syscall.EACCES}
+	tests := []struct {
+		name     string
+		address  string
+		err      error
+		wantKind error
+		wantText string
+	}{
+		{"privileged port", "127.0.0.1:80", denied, ErrPermissionDenied, "bind 127.0.0.1:80: port 80 requires elevated privileges: bind: permission denied"},
+		{"privileged service", "127.0.0.1:http", denied, ErrPermissionDenied, "bind 127.0.0.1:http (127.0.0.1:80): port http requires elevated privileges: bind: permission denied"},
+		{"unix socket denied", UnixAddressPrefix + "/run/app.sock", denied, ErrPermissionDenied, "bind unix:/run/app.sock (/run/app.sock): permission denied: bind: permission denied"},
+		{"other error", "127.0.0.1:8080", errors.New("boom"), nil, "bind 127.0.0.1:8080: boom"},
+	}
+	for _, tt := range tests {
+		err := newBindError(tt.address, tt.err)
+		if !errors.As(err, &bindErr) || bindErr.Kind != tt.wantKind || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %#v", tt.name, err)
+		}
+		if err.Error() != tt.wantText {
+			t.Errorf("%s: %q, want %q", tt.name, err.Error(), tt.wantText)
+		}
+	}
+}
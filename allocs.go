@@ -0,0 +1,141 @@
+package serverlib
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// AllocDiagnosticsOptions configures AllocDiagnostics.
+type AllocDiagnosticsOptions struct {
+	// QueryFlag samples the requests having this query parameter, e.g. "_allocs".
+	QueryFlag string
+	// EveryN samples one request out of N. Zero disables this sampling.
+	EveryN int
+	// ServerTiming adds an "allocs" Server-Timing entry to the sampled responses.
+	ServerTiming bool
+}
+
+// AllocStats are the approximate allocation numbers of a sampled request.
+type AllocStats struct {
+	Mallocs    uint64
+	Bytes      uint64
+	GCs        uint32
+	Goroutines int
+}
+
+// String implements fmt.Stringer.
+func (a AllocStats) String() string {
+	return fmt.Sprintf("~%d allocs, ~%d bytes, %d GC, %+d goroutines (approximate)", a.Mallocs, a.Bytes, a.GCs, a.Goroutines)
+}
+
+// AllocDiagnostics returns a middleware logging at Debug the allocations made
+// while the sampled requests are handled, in the Development profile only: in
+// any other profile it returns the handlers unchanged, at no cost.
+//
+// The numbers are approximate. runtime.MemStats is process wide, so the
+// allocations of the requests served concurrently, on every GOMAXPROCS
+// processor, and of the background goroutines are counted as well; reading it
+// also stops the world briefly. Only the requests selected by the options are
+// sampled, to keep the skew low; with no sampling option nothing is sampled.
+// The Server-Timing entry is written with the response headers and only
+// covers the allocations made until then.
+//
+// Parameters:
+//   - options: The sampling options.
+//
+// Returns:
+//   - Middleware: The diagnostics middleware.
+func (s *Server) AllocDiagnostics(options AllocDiagnosticsOptions) Middleware {
+	if s.profile != Development {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	var count atomic.Uint64
+	sampled := func(r *http.Request) bool {
+		if options.QueryFlag != "" && r.URL.RawQuery != "" && r.URL.Query().Has(options.QueryFlag) {
+			return true
+		}
+		return options.EveryN > 0 && count.Add(1)%uint64(options.EveryN) == 0
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !sampled(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sample := newAllocSample()
+			if options.ServerTiming {
+				hooked := &headerHookWriter{ResponseWriter: w, hook: func(header http.Header) {
+					header.Add("Server-Timing", fmt.Sprintf("allocs;desc=%q", sample.stats().String()))
+				}}
+				defer hooked.flushHook()
+				w = hooked
+			}
+			next.ServeHTTP(w, r)
+			s.LogDebug("Request allocations", r.Method+" "+r.URL.Path+": "+sample.stats().String())
+		})
+	}
+}
+
+// allocSample holds the memory statistics at the start of a sampled request.
+type allocSample struct {
+	memStats   runtime.MemStats
+	goroutines int
+}
+
+func newAllocSample() *allocSample {
+	sample := &allocSample{goroutines: runtime.NumGoroutine()}
+	runtime.ReadMemStats(&sample.memStats)
+	return sample
+}
+
+// stats returns the differences since the start of the request.
+func (a *allocSample) stats() AllocStats {
+	var now runtime.MemStats
+	runtime.ReadMemStats(&now)
+	return AllocStats{
+		Mallocs:    now.Mallocs - a.memStats.Mallocs,
+		Bytes:      now.TotalAlloc - a.memStats.TotalAlloc,
+		GCs:        now.NumGC - a.memStats.NumGC,
+		Goroutines: runtime.NumGoroutine() - a.goroutines,
+	}
+}
+
+// headerHookWriter calls hook with the response headers just before they are written.
+type headerHookWriter struct {
+	http.ResponseWriter
+	hook        func(http.Header)
+	wroteHeader bool
+}
+
+func (w *headerHookWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.hook(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// flushHook calls the hook when the handler wrote nothing: the headers are
+// then written by net/http once the handler returns.
+func (w *headerHookWriter) flushHook() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.hook(w.Header())
+	}
+}
+
+func (w *headerHookWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *headerHookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
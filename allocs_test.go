@@ -0,0 +1,125 @@
+package serverlib
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countingHandler counts its requests.
+type countingHandler struct {
+	requests int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.requests++
+	if r.URL.Query().Has("write") {
+		w.Write(make([]byte, 1024))
+	}
+}
+
+func TestAllocDiagnosticsSampling(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		options AllocDiagnosticsOptions
+		targets []string
+		want    []bool
+	}{
+		{"production", Production, AllocDiagnosticsOptions{QueryFlag: "_allocs", EveryN: 1, ServerTiming: true},
+			[]string{"/?_allocs", "/"}, []bool{false, false}},
+		{"no sampling option", Development, AllocDiagnosticsOptions{ServerTiming: true},
+			[]string{"/", "/?_allocs"}, []bool{false, false}},
+		{"query flag", Development, AllocDiagnosticsOptions{QueryFlag: "_allocs", ServerTiming: true},
+			[]string{"/", "/?_allocs", "/?_allocs=1&write", "/?other"}, []bool{false, true, true, false}},
+		{"one in three", Development, AllocDiagnosticsOptions{EveryN: 3, ServerTiming: true},
+			[]string{"/", "/", "/?write", "/", "/", "/"}, []bool{false, false, true, false, false, true}},
+		// The flagged requests don't count in the 1-in-N sampling.
+		{"query flag and one in two", Development, AllocDiagnosticsOptions{QueryFlag: "_allocs", EveryN: 2, ServerTiming: true},
+			[]string{"/?_allocs", "/", "/"}, []bool{true, false, true}},
+	}
+	for _, tt := range tests {
+		logs := &syncBuffer{}
+		s := NewServer(ServerConfig{Profile: tt.profile, ErrorLog: log.New(logs, "", 0), LogLevel: Debug})
+		next := &countingHandler{}
+		handler := s.AllocDiagnostics(tt.options)(next)
+		logged := 0
+		for i, target := range tt.targets {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+			timing := w.Result().Header.Get("Server-Timing")
+			if got := timing != ""; got != tt.want[i] {
+				t.Errorf("%s: request %d %s sampled %v, want %v", tt.name, i, target, got, tt.want[i])
+			}
+			if timing != "" && !strings.Contains(timing, "(approximate)") {
+				t.Errorf("%s: Server-Timing %q not labeled approximate", tt.name, timing)
+			}
+			if tt.want[i] {
+				logged++
+			}
+		}
+		if got := strings.Count(logs.String(), "Request allocations"); got != logged {
+			t.Errorf("%s: %d requests logged, want %d", tt.name, got, logged)
+		}
+		if next.requests != len(tt.targets) {
+			t.Errorf("%s: %d requests served, want %d", tt.name, next.requests, len(tt.targets))
+		}
+	}
+}
+
+// TestAllocDiagnosticsDisabled checks that the middleware costs nothing
+// outside of the Development profile: the handler is returned as is.
+func TestAllocDiagnosticsDisabled(t *testing.T) {
+	next := &countingHandler{}
+	s := NewServer(ServerConfig{})
+	if handler := s.AllocDiagnostics(AllocDiagnosticsOptions{EveryN: 1, ServerTiming: true})(next); handler != http.Handler(next) {
+		t.Errorf("handler wrapped in the Production profile")
+	}
+}
+
+func TestAllocStats(t *testing.T) {
+	sample := newAllocSample()
+	var sink [][]byte
+	for range 100 {
+		sink = append(sink, make([]byte, 1024))
+	}
+	stats := sample.stats()
+	if stats.Mallocs < 100 || stats.Bytes < 100*1024 {
+		t.Errorf("%s for 100 allocations of 1 KiB", stats)
+	}
+	_ = sink
+}
+
+// BenchmarkAllocDiagnostics serves a request through the middleware disabled
+// by the profile, unsampled and sampled, next to the bare handler.
+func BenchmarkAllocDiagnostics(b *testing.B) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	next := &countingHandler{}
+	production := NewServer(ServerConfig{})
+	development := NewServer(ServerConfig{Profile: Development, ErrorLog: log.New(io.Discard, "", 0)})
+	benchmarks := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"bare", next},
+		{"disabled", production.AllocDiagnostics(AllocDiagnosticsOptions{EveryN: 1})(next)},
+		{"unsampled", development.AllocDiagnostics(AllocDiagnosticsOptions{QueryFlag: "_allocs"})(next)},
+		{"sampled", development.AllocDiagnostics(AllocDiagnosticsOptions{EveryN: 1, ServerTiming: true})(next)},
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := &discardWriter{header: http.Header{}}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				clear(w.header)
+				bm.handler.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package serverlib
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultAutoTLSCacheDir is the directory of the certificates obtained by
+// StartAutoTLS when AutoTLSConfig.CacheDir is not set.
+const DefaultAutoTLSCacheDir = "autocert-cache"
+
+// AutoTLSConfig configures the certificates obtained from an ACME certificate
+// authority, Let's Encrypt by default, see ServerConfig.AutoTLS and
+// StartAutoTLS.
+type AutoTLSConfig struct {
+	// Hosts are the host names the certificates are requested for; the TLS
+	// handshakes for other names fail. Required.
+	Hosts []string
+	// CacheDir is the directory the certificates and the account key are
+	// kept in across restarts, not to hit the rate limits of the authority.
+	// Defaults to DefaultAutoTLSCacheDir.
+	CacheDir string
+	// Email, if not empty, is the contact of the account, notified of the
+	// problems with the certificates.
+	Email string
+	// HTTPAddress is the address of the HTTP-01 challenge listener, which
+	// redirects the other requests to HTTPS like ServerConfig.RedirectHTTP.
+	// Defaults to DefaultRedirectHTTPAddr.
+	HTTPAddress string
+	// DirectoryURL is the ACME directory of the authority, e.g. the staging
+	// environment of Let's Encrypt. Defaults to the production one.
+	DirectoryURL string
+}
+
+// manager returns the certificate manager of the configuration.
+func (c *AutoTLSConfig) manager() *autocert.Manager {
+	cacheDir := c.CacheDir
+	if cacheDir == "" {
+		cacheDir = DefaultAutoTLSCacheDir
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return manager
+}
+
+// StartAutoTLS starts the server over HTTPS on ServerConfig.Address, usually
+// ":443", with certificates obtained and renewed automatically as configured
+// by ServerConfig.AutoTLS. A second listener on AutoTLSConfig.HTTPAddress
+// answers the HTTP-01 challenges and redirects the other requests to HTTPS
+// like ServerConfig.RedirectHTTP; it is a component of the server (see Go),
+// stopped by Shutdown and Stop. The other settings of ServerConfig.TLSConfig
+// apply.
+//
+// Returns:
+//   - error: ErrNoCertificate when ServerConfig.AutoTLS is not set or has no
+//     hosts, otherwise like Start.
+func (s *Server) StartAutoTLS() error {
+	return s.running(s.startAutoTLS)
+}
+
+func (s *Server) startAutoTLS() error {
+	config := s.config.AutoTLS
+	if config == nil || len(config.Hosts) == 0 {
+		return fmt.Errorf("%w: ServerConfig.AutoTLS has no hosts", ErrNoCertificate)
+	}
+	manager := config.manager()
+	s.useTLS(func(config *tls.Config) *tls.Config {
+		tlsConfig := &tls.Config{}
+		if config != nil {
+			tlsConfig = config.Clone()
+		}
+		tlsConfig.GetCertificate = manager.GetCertificate
+		if len(tlsConfig.NextProtos) == 0 {
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+		if !slices.Contains(tlsConfig.NextProtos, acme.ALPNProto) {
+			// The TLS-ALPN-01 challenges.
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		}
+		return tlsConfig
+	})
+
+	listeners, err := s.listenAll(":https", true)
+	if err != nil {
+		return err
+	}
+	httpAddress := config.HTTPAddress
+	if httpAddress == "" {
+		httpAddress = DefaultRedirectHTTPAddr
+	}
+	// The requests other than the ACME challenges are redirected to HTTPS.
+	handler := manager.HTTPHandler(redirectHandler(httpsPort(listeners)))
+	if err := s.serveRedirector("autotls-challenges", httpAddress, handler); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	slog.Info("ACME challenges answered", "address", httpAddress, "hosts", config.Hosts)
+	return s.serveAll(listeners, "", "")
+}
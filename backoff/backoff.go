@@ -0,0 +1,201 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Jitter selects how the delays are randomized, so that clients failing
+// together don't retry together.
+type Jitter int
+
+const (
+	// NoJitter uses the exponential delays as is.
+	NoJitter Jitter = iota
+	// FullJitter picks a delay between 0 and the exponential delay.
+	FullJitter
+	// EqualJitter picks a delay between half the exponential delay and the
+	// exponential delay.
+	EqualJitter
+)
+
+// Defaults of the options.
+const (
+	DefaultInitial    = 100 * time.Millisecond
+	DefaultMax        = 30 * time.Second
+	DefaultMultiplier = 2
+)
+
+// Options configures a Backoff.
+type Options struct {
+	// Initial is the delay before the first retry. Defaults to DefaultInitial.
+	Initial time.Duration
+	// Max caps the delays before the jitter is applied. Defaults to DefaultMax.
+	Max time.Duration
+	// Multiplier is the growth factor of the delays. Defaults to DefaultMultiplier.
+	Multiplier float64
+	// Jitter randomizes the delays, NoJitter by default.
+	Jitter Jitter
+	// MaxAttempts is the number of attempts of Retry and Delays, the first one
+	// included. Zero means no limit.
+	MaxAttempts int
+	// Retryable tells whether Retry tries again after an error. Defaults to
+	// IsRetryable.
+	Retryable func(error) bool
+	// Rand is the source of the jitter, e.g. a seeded generator for
+	// reproducible delays. It is not safe for concurrent use: a Backoff with a
+	// Rand must not be shared between goroutines. Defaults to the
+	// concurrency-safe global source.
+	Rand *rand.Rand
+}
+
+// Backoff computes exponential delays. It is immutable and, unless its
+// options have a Rand, safe for concurrent use.
+type Backoff struct {
+	opts Options
+}
+
+// New creates a Backoff with the given options.
+func New(opts Options) *Backoff {
+	if opts.Initial <= 0 {
+		opts.Initial = DefaultInitial
+	}
+	if opts.Max <= 0 {
+		opts.Max = DefaultMax
+	}
+	if opts.Multiplier < 1 {
+		opts.Multiplier = DefaultMultiplier
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	return &Backoff{opts: opts}
+}
+
+// Delay returns the delay before the given retry, 1 for the first one.
+func (b *Backoff) Delay(retry int) time.Duration {
+	delay := float64(b.opts.Initial) * math.Pow(b.opts.Multiplier, float64(max(retry, 1)-1))
+	delay = min(delay, float64(b.opts.Max))
+	switch b.opts.Jitter {
+	case FullJitter:
+		delay *= b.float64()
+	case EqualJitter:
+		delay = delay/2 + delay/2*b.float64()
+	}
+	return time.Duration(delay)
+}
+
+// float64 returns a random number in [0, 1).
+func (b *Backoff) float64() float64 {
+	if b.opts.Rand != nil {
+		return b.opts.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+// Delays returns an iterator for manual retry loops: the first iteration
+// yields 0 right away, the next ones wait for and yield the delay of their
+// retry. The iteration stops after MaxAttempts iterations or when ctx is done
+// while waiting.
+//
+//	for range b.Delays(ctx) {
+//		if err = call(); err == nil {
+//			break
+//		}
+//	}
+func (b *Backoff) Delays(ctx context.Context) iter.Seq[time.Duration] {
+	return func(yield func(time.Duration) bool) {
+		var delay time.Duration
+		for attempt := 0; b.opts.MaxAttempts <= 0 || attempt < b.opts.MaxAttempts; attempt++ {
+			if attempt > 0 {
+				delay = b.Delay(attempt)
+				if !Sleep(ctx, delay) {
+					return
+				}
+			}
+			if !yield(delay) {
+				return
+			}
+		}
+	}
+}
+
+// Retry calls fn until it succeeds, returns an error that is not retryable,
+// MaxAttempts is reached or ctx is done, waiting between the attempts.
+//
+// Parameters:
+//   - ctx: The context cancelling the retries, passed to fn.
+//   - fn: The function to call.
+//
+// Returns:
+//   - error: nil if fn succeeded, its last error otherwise, joined with the
+//     context error when ctx ended the retries.
+func (b *Backoff) Retry(ctx context.Context, fn func(context.Context) error) error {
+	var err error
+	for range b.Delays(ctx) {
+		if err = fn(ctx); err == nil || !b.opts.Retryable(err) {
+			return err
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return errors.Join(ctxErr, err)
+	}
+	return err
+}
+
+// Sleep waits for d or until ctx is done. It reports whether the delay elapsed.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// RetryableError is implemented by the errors classifying themselves as
+// worth retrying or not.
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// permanentError marks an error as not retryable.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Retryable() bool { return false }
+
+// Permanent wraps err so that Retry returns it without trying again.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable is the default classification of Retry: the context errors are
+// not retryable, the errors implementing RetryableError decide, the other
+// errors are retryable.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var retryable RetryableError
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+	return true
+}
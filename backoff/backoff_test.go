@@ -0,0 +1,235 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []time.Duration
+	}{
+		{"defaults", Options{}, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}},
+		{"multiplier", Options{Initial: time.Second, Multiplier: 3}, []time.Duration{time.Second, 3 * time.Second, 9 * time.Second}},
+		{"capped", Options{Initial: time.Second, Max: 5 * time.Second}, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}},
+		{"constant", Options{Initial: time.Second, Multiplier: 1}, []time.Duration{time.Second, time.Second, time.Second}},
+		{"invalid multiplier", Options{Initial: time.Second, Multiplier: 0.5}, []time.Duration{time.Second, 2 * time.Second}},
+	}
+	for _, tt := range tests {
+		b := New(tt.opts)
+		var got []time.Duration
+		for retry := range len(tt.want) {
+			got = append(got, b.Delay(retry+1))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	b := New(Options{Initial: time.Second, Max: time.Minute})
+	if got := b.Delay(0); got != time.Second {
+		t.Errorf("retry 0: %v, want the first delay", got)
+	}
+	if got := b.Delay(10000); got != time.Minute {
+		t.Errorf("retry 10000: %v, want the cap", got)
+	}
+}
+
+// TestJitterBounds checks the jittered delays stay within their bounds and
+// spread over them.
+func TestJitterBounds(t *testing.T) {
+	const samples = 10000
+	tests := []struct {
+		name     string
+		jitter   Jitter
+		min, max time.Duration
+	}{
+		{"none", NoJitter, time.Second, time.Second},
+		{"full", FullJitter, 0, time.Second},
+		{"equal", EqualJitter, 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		b := New(Options{Initial: 250 * time.Millisecond, Max: time.Second, Jitter: tt.jitter})
+		var sum time.Duration
+		for i := range samples {
+			// The fourth retry reaches the cap: the jitter applies after it.
+			delay := b.Delay(3 + i%3)
+			if delay < tt.min || delay > tt.max {
+				t.Fatalf("%s: delay %v out of [%v, %v]", tt.name, delay, tt.min, tt.max)
+			}
+			sum += delay
+		}
+		mean, want := sum/samples, (tt.min+tt.max)/2
+		if diff := mean - want; diff < -20*time.Millisecond || diff > 20*time.Millisecond {
+			t.Errorf("%s: mean delay %v, want about %v", tt.name, mean, want)
+		}
+	}
+}
+
+// TestSeededRand checks the delays are reproducible with a seeded Rand.
+func TestSeededRand(t *testing.T) {
+	delays := func(seed uint64, jitter Jitter) []time.Duration {
+		b := New(Options{Initial: time.Second, Jitter: jitter, Rand: rand.New(rand.NewPCG(seed, 0))})
+		var delays []time.Duration
+		for retry := 1; retry <= 5; retry++ {
+			delays = append(delays, b.Delay(retry))
+		}
+		return delays
+	}
+	for _, jitter := range []Jitter{FullJitter, EqualJitter} {
+		if first, again := delays(1, jitter), delays(1, jitter); !slices.Equal(first, again) {
+			t.Errorf("jitter %d: %v, then %v with the same seed", jitter, first, again)
+		}
+		if first, other := delays(1, jitter), delays(2, jitter); slices.Equal(first, other) {
+			t.Errorf("jitter %d: %v with two seeds", jitter, first)
+		}
+	}
+}
+
+// retryable is an error classifying itself.
+type retryable bool
+
+func (r retryable) Error() string   { return fmt.Sprintf("retryable=%v", bool(r)) }
+func (r retryable) Retryable() bool { return bool(r) }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"plain", errors.New("connection refused"), true},
+		{"permanent", Permanent(errors.New("bad request")), false},
+		{"wrapped permanent", fmt.Errorf("calling: %w", Permanent(errors.New("bad request"))), false},
+		{"classified retryable", retryable(true), true},
+		{"classified permanent", fmt.Errorf("calling: %w", retryable(false)), false},
+		{"cancelled", context.Canceled, false},
+		{"deadline", fmt.Errorf("calling: %w", context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) is not nil")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	failure := errors.New("unavailable")
+	permanent := Permanent(errors.New("rejected"))
+	tests := []struct {
+		name string
+		opts Options
+		// errs are the errors of the successive calls, nil once exhausted.
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"first attempt", Options{}, nil, 1, nil},
+		{"after failures", Options{}, []error{failure, failure}, 3, nil},
+		{"permanent", Options{}, []error{failure, permanent, failure}, 2, permanent},
+		{"attempts exhausted", Options{MaxAttempts: 3}, []error{failure, failure, failure, failure}, 3, failure},
+		{"single attempt", Options{MaxAttempts: 1}, []error{failure}, 1, failure},
+		{"classified", Options{Retryable: func(err error) bool { return err != failure }}, []error{failure}, 1, failure},
+	}
+	for _, tt := range tests {
+		tt.opts.Initial = time.Millisecond
+		calls := 0
+		err := New(tt.opts).Retry(context.Background(), func(context.Context) error {
+			calls++
+			if calls <= len(tt.errs) {
+				return tt.errs[calls-1]
+			}
+			return nil
+		})
+		if calls != tt.wantCalls || err != tt.wantErr {
+			t.Errorf("%s: %d calls, %v, want %d calls, %v", tt.name, calls, err, tt.wantCalls, tt.wantErr)
+		}
+	}
+}
+
+// TestRetryCancelled checks the context ends the retries while waiting, with
+// both the context and the last error returned.
+func TestRetryCancelled(t *testing.T) {
+	failure := errors.New("unavailable")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	start := time.Now()
+	err := New(Options{Initial: time.Hour}).Retry(ctx, func(context.Context) error {
+		calls++
+		return failure
+	})
+	if calls != 1 || !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, failure) {
+		t.Errorf("%d calls, %v, want one call, the deadline and the failure", calls, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %v, want at the deadline", elapsed)
+	}
+}
+
+func TestDelays(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		// stop is the number of iterations after which the loop breaks, 0 for none.
+		stop int
+		want []time.Duration
+	}{
+		{"attempts", Options{Initial: time.Millisecond, MaxAttempts: 4}, 0, []time.Duration{0, time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}},
+		{"break", Options{Initial: time.Millisecond}, 2, []time.Duration{0, time.Millisecond}},
+		{"capped", Options{Initial: time.Millisecond, Max: 2 * time.Millisecond, MaxAttempts: 4}, 0, []time.Duration{0, time.Millisecond, 2 * time.Millisecond, 2 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		var got []time.Duration
+		for delay := range New(tt.opts).Delays(context.Background()) {
+			got = append(got, delay)
+			if len(got) == tt.stop {
+				break
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []time.Duration
+	for delay := range New(Options{Initial: time.Hour}).Delays(ctx) {
+		got = append(got, delay)
+		cancel()
+	}
+	if !slices.Equal(got, []time.Duration{0}) {
+		t.Errorf("cancelled: %v, want the first attempt only", got)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		delay time.Duration
+		want  bool
+	}{
+		{"elapsed", context.Background(), time.Millisecond, true},
+		{"no delay", context.Background(), 0, true},
+		{"cancelled", cancelled, time.Hour, false},
+		{"cancelled, no delay", cancelled, 0, false},
+	}
+	for _, tt := range tests {
+		if got := Sleep(tt.ctx, tt.delay); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
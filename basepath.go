@@ -0,0 +1,35 @@
+package serverlib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BasePath returns the path prefix the application is served under, or an
+// empty string when it is served at the root.
+func (s *Server) BasePath() string {
+	return s.basePath
+}
+
+// Path returns the public URL path of an application path, prefixed with the
+// base path. The path is always joined, even when it starts like the base
+// path: with the base path "/api", "/api/x" becomes "/api/api/x". URLs with a
+// scheme or a host and relative paths are returned unchanged.
+//
+// Parameters:
+//   - path: An application path such as "/users/42".
+//
+// Returns:
+//   - string: The path with the base path prefix, e.g. "/tools/myapp/users/42".
+func (s *Server) Path(path string) string {
+	if s.basePath == "" || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return path
+	}
+	return s.basePath + path
+}
+
+// Redirect replies to the request with a redirect to the given application
+// path, prefixed with the base path.
+func (s *Server) Redirect(w http.ResponseWriter, r *http.Request, path string, code int) {
+	http.Redirect(w, r, s.Path(path), code)
+}
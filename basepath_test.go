@@ -0,0 +1,65 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		path     string
+		want     string
+	}{
+		{"", "/users/42", "/users/42"},
+		{"/api", "/users/42", "/api/users/42"},
+		{"/api", "/", "/api/"},
+		{"/api", "/api/x", "/api/api/x"},
+		{"/api", "/api", "/api/api"},
+		{"/api", "/apix", "/api/apix"},
+		{"/api", "relative", "relative"},
+		{"/api", "//cdn.example.com/app.js", "//cdn.example.com/app.js"},
+		{"/api", "https://example.com/x", "https://example.com/x"},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{BasePath: tt.basePath})
+		if got := s.Path(tt.path); got != tt.want {
+			t.Errorf("BasePath %q: Path(%q) = %q, want %q", tt.basePath, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestBasePathEmittedURLs runs the same handlers with and without a base
+// path: every URL and cookie emitted carries the prefix exactly once.
+func TestBasePathEmittedURLs(t *testing.T) {
+	for _, basePath := range []string{"", "/tools/myapp"} {
+		s := NewServer(ServerConfig{BasePath: basePath})
+		s.GET("/users/{id}", func(w http.ResponseWriter, r *http.Request) {}).Name("user.show")
+		s.GET("/login", func(w http.ResponseWriter, r *http.Request) {
+			s.GetSession(w, r)
+			s.Redirect(w, r, "/home", http.StatusFound)
+		})
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+		if got, want := w.Header().Get("Location"), basePath+"/home"; got != want {
+			t.Errorf("BasePath %q: Location = %q, want %q", basePath, got, want)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			t.Fatalf("BasePath %q: no session cookie", basePath)
+		}
+		if got := cookies[0].Path; got != basePath {
+			t.Errorf("BasePath %q: cookie Path = %q, want %q", basePath, got, basePath)
+		}
+
+		url, err := s.URL("user.show", "id", 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := basePath + "/users/42"; url != want {
+			t.Errorf("BasePath %q: URL = %q, want %q", basePath, url, want)
+		}
+	}
+}
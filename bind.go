@@ -0,0 +1,211 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Morditux/serverlib/i18n"
+	"github.com/Morditux/serverlib/units"
+)
+
+// DefaultMaxBodyBytes is the maximum size of a request body decoded by BindJSON.
+const DefaultMaxBodyBytes = 1 << 20 // 1 MiB
+
+// BindJSON decodes the JSON body of the request into v.
+// Bodies larger than DefaultMaxBodyBytes are rejected with a 413, malformed
+// bodies and unknown fields with a 400.
+func BindJSON(r *http.Request, v any) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			return NewHTTPError(http.StatusUnsupportedMediaType, "request body must be JSON")
+		}
+	}
+	decoder := json.NewDecoder(&limitedReader{r: r.Body, n: DefaultMaxBodyBytes})
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return nil
+	case errors.Is(err, errBodyTooLarge):
+		return NewHTTPError(http.StatusRequestEntityTooLarge, "request body larger than "+units.FormatBytes(DefaultMaxBodyBytes))
+	default:
+		return &HTTPError{Status: http.StatusBadRequest, Message: "invalid JSON body", Err: err}
+	}
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedReader reads at most n bytes, then fails with errBodyTooLarge.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// Bind fills the struct pointed to by v from the request: first from the JSON
+// body, then from the query parameters of the fields tagged `query:"name"`,
+// then from the path parameters of the fields tagged `path:"name"`. A later
+// source overrides an earlier one, so path parameters always win. A tag may
+// give the default of a missing parameter, e.g. `query:"page,default=1"`.
+// Conversion errors are reported together as ValidationErrors.
+func Bind(r *http.Request, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return BindJSON(r, v)
+	}
+	if err := BindJSON(r, v); err != nil {
+		return err
+	}
+	var errs ValidationErrors
+	catalog := serverOf(r).Messages()
+	query := r.URL.Query()
+	bindFields(rv.Elem(), "query", func(name string) ([]string, bool) {
+		values, ok := query[name]
+		return values, ok
+	}, catalog, &errs)
+	bindFields(rv.Elem(), "path", func(name string) ([]string, bool) {
+		value := r.PathValue(name)
+		return []string{value}, value != ""
+	}, catalog, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// bindFields sets the fields of the struct rv tagged with tag from the values
+// returned by lookup, or else from the default of the tag, e.g.
+// `query:"page,default=1"`, for the fields still zero. Embedded structs are
+// walked recursively. The messages of the errors are set from catalog.
+func bindFields(rv reflect.Value, tag string, lookup func(string) ([]string, bool), catalog *i18n.Catalog, errs *ValidationErrors) {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindFields(rv.Field(i), tag, lookup, catalog, errs)
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		values, ok := lookup(name)
+		if !ok {
+			// The default doesn't override a value of an earlier source.
+			value, hasDefault := strings.CutPrefix(options, "default=")
+			if !hasDefault || !rv.Field(i).IsZero() {
+				continue
+			}
+			values = []string{value}
+		}
+		if err := setField(rv.Field(i), values); err != nil {
+			errs.addError(catalog, name, err)
+		}
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// setField converts values to the type of the field and sets it.
+// Slices take every value, the other types the first one.
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return setValue(field, values[0])
+}
+
+// setValue converts a single string value to the type of v and sets it.
+func setValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return &fieldMessage{key: "validation.time"}
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return &fieldMessage{key: "validation.boolean"}
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return &fieldMessage{key: "validation.duration"}
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return &fieldMessage{key: "validation.integer"}
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return &fieldMessage{key: "validation.positive_integer"}
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return &fieldMessage{key: "validation.number"}
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
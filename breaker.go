@@ -0,0 +1,342 @@
+package serverlib
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default circuit breaker settings, see BreakerOptions.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerWindow    = time.Minute
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// BreakerState is the state of the circuit breaker of a route.
+type BreakerState int
+
+const (
+	// BreakerClosed lets the requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen answers the requests with a 503 without calling the handler.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe request through after the cooldown:
+	// its success closes the breaker, its failure opens it again.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (b BreakerState) String() string {
+	switch b {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(b))
+}
+
+// MarshalText implements encoding.TextMarshaler, for Server.Info.
+func (b BreakerState) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// BreakerOptions configures the circuit breakers of the routes, see
+// ServerConfig.CircuitBreaker.
+type BreakerOptions struct {
+	// Threshold is the number of failures (panics and 5xx responses) within
+	// Window opening the breaker. Defaults to DefaultBreakerThreshold.
+	Threshold int
+	// Window is the period the failures are counted over. Defaults to DefaultBreakerWindow.
+	Window time.Duration
+	// Cooldown is how long the breaker stays open before a probe request is
+	// let through. Defaults to DefaultBreakerCooldown.
+	Cooldown time.Duration
+	// OnTransition, if not nil, is called on every change of state, after the
+	// transition has been logged. It is called with the breakers locked and
+	// must not call their methods.
+	OnTransition func(route string, from, to BreakerState)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (o BreakerOptions) withDefaults() BreakerOptions {
+	if o.Threshold <= 0 {
+		o.Threshold = DefaultBreakerThreshold
+	}
+	if o.Window <= 0 {
+		o.Window = DefaultBreakerWindow
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = DefaultBreakerCooldown
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// CircuitOpenError is the error of the requests rejected by an open breaker.
+// It is answered with a 503 and a Retry-After header.
+type CircuitOpenError struct {
+	Route   string
+	RetryIn time.Duration
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+	return "circuit open for " + e.Route
+}
+
+// StatusCode returns 503.
+func (e *CircuitOpenError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// RetryAfter returns the time left before the probe request.
+func (e *CircuitOpenError) RetryAfter() time.Duration {
+	return e.RetryIn
+}
+
+// breaker is the circuit breaker of a route.
+type breaker struct {
+	options  BreakerOptions
+	state    BreakerState
+	failures []time.Time
+	openedAt time.Time
+	probing  bool
+}
+
+// CircuitBreakers holds the circuit breakers of the routes: a route whose
+// handler keeps panicking or failing is answered with a fast 503 (see
+// CircuitOpenError) instead of flooding the logs, until it recovers.
+type CircuitBreakers struct {
+	defaults BreakerOptions
+	routes   map[string]BreakerOptions
+	breakers map[string]*breaker
+	mut      *sync.Mutex
+}
+
+// NewCircuitBreakers creates the circuit breakers, with the default options
+// of the routes.
+func NewCircuitBreakers(opts BreakerOptions) *CircuitBreakers {
+	return &CircuitBreakers{
+		defaults: opts.withDefaults(),
+		routes:   map[string]BreakerOptions{},
+		breakers: map[string]*breaker{},
+		mut:      &sync.Mutex{},
+	}
+}
+
+// Configure sets the options of the breaker of a route, e.g. a higher
+// threshold for a flaky upstream. The breaker is reset.
+//
+// Parameters:
+//   - route: The pattern of the route, as registered, e.g. "GET /users/{id}".
+//   - opts: The options of its breaker.
+func (c *CircuitBreakers) Configure(route string, opts BreakerOptions) {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.routes[route] = opts.withDefaults()
+	delete(c.breakers, route)
+}
+
+// Reset closes the breaker of a route, e.g. after deploying a fix.
+func (c *CircuitBreakers) Reset(route string) {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if b, ok := c.breakers[route]; ok {
+		c.transition(route, b, BreakerClosed)
+		b.failures, b.probing = nil, false
+	}
+}
+
+// State returns the state of the breaker of a route.
+func (c *CircuitBreakers) State(route string) BreakerState {
+	if c == nil {
+		return BreakerClosed
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if b, ok := c.breakers[route]; ok {
+		return b.state
+	}
+	return BreakerClosed
+}
+
+// States returns the states of the breakers which are not closed, by route.
+func (c *CircuitBreakers) States() map[string]BreakerState {
+	if c == nil {
+		return nil
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	states := map[string]BreakerState{}
+	for route, b := range c.breakers {
+		if b.state != BreakerClosed {
+			states[route] = b.state
+		}
+	}
+	return states
+}
+
+// breaker returns the breaker of a route, created on first use. It is called
+// with the lock held.
+func (c *CircuitBreakers) breaker(route string) *breaker {
+	b, ok := c.breakers[route]
+	if !ok {
+		options, ok := c.routes[route]
+		if !ok {
+			options = c.defaults
+		}
+		b = &breaker{options: options}
+		c.breakers[route] = b
+	}
+	return b
+}
+
+// allow reports whether a request to the route can be served, and whether it
+// is the probe request of a half-open breaker, or else the time left before
+// the probe request.
+func (c *CircuitBreakers) allow(route string) (ok, probe bool, retryIn time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	b := c.breaker(route)
+	switch b.state {
+	case BreakerOpen:
+		left := b.openedAt.Add(b.options.Cooldown).Sub(b.options.Now())
+		if left > 0 {
+			return false, false, left
+		}
+		c.transition(route, b, BreakerHalfOpen)
+		b.probing = true
+		return true, true, 0
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, false, b.options.Cooldown
+		}
+		b.probing = true
+		return true, true, 0
+	}
+	return true, false, 0
+}
+
+// record records the outcome of a request to the route. Only the probe
+// request decides the state of a half-open breaker, not the requests let
+// through before the breaker opened completing meanwhile.
+func (c *CircuitBreakers) record(route string, probe, failed bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	b := c.breaker(route)
+	now := b.options.Now()
+	switch b.state {
+	case BreakerHalfOpen:
+		if !probe {
+			return
+		}
+		b.probing = false
+		if failed {
+			b.openedAt = now
+			c.transition(route, b, BreakerOpen)
+			return
+		}
+		b.failures = nil
+		c.transition(route, b, BreakerClosed)
+	case BreakerClosed:
+		if !failed {
+			return
+		}
+		recent := b.failures[:0]
+		for _, at := range b.failures {
+			if now.Sub(at) < b.options.Window {
+				recent = append(recent, at)
+			}
+		}
+		b.failures = append(recent, now)
+		if len(b.failures) >= b.options.Threshold {
+			b.openedAt = now
+			c.transition(route, b, BreakerOpen)
+		}
+	}
+}
+
+// transition changes the state of a breaker, with the lock held.
+func (c *CircuitBreakers) transition(route string, b *breaker, to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	if to == BreakerOpen {
+		slog.Error("Circuit breaker opened", "route", route, "failures", len(b.failures), "cooldown", b.options.Cooldown)
+	} else {
+		slog.Info("Circuit breaker "+to.String(), "route", route, "from", from.String())
+	}
+	if b.options.OnTransition != nil {
+		b.options.OnTransition(route, from, to)
+	}
+}
+
+// breakerWriter records the status of the response of a route.
+type breakerWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *breakerWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *breakerWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *breakerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// CircuitBreakers returns the circuit breakers of the routes, or nil when
+// ServerConfig.CircuitBreaker is not set; the methods of a nil
+// CircuitBreakers do nothing.
+func (s *Server) CircuitBreakers() *CircuitBreakers {
+	return s.injector.breakers
+}
+
+// guard serves the request through the breaker of its route: rejected while
+// open, its panics and 5xx responses counted as failures.
+func (c *CircuitBreakers) guard(w http.ResponseWriter, r *http.Request, route string, next func(http.ResponseWriter)) {
+	ok, probe, retryIn := c.allow(route)
+	if !ok {
+		serverOf(r).Error(w, r, &CircuitOpenError{Route: route, RetryIn: retryIn})
+		return
+	}
+	bw := &breakerWriter{ResponseWriter: w}
+	defer func() {
+		value := recover()
+		panicked := value != nil && value != http.ErrAbortHandler
+		c.record(route, probe, panicked || bw.status >= http.StatusInternalServerError)
+		if value != nil {
+			// Answered by recoverPanic.
+			panic(value)
+		}
+	}()
+	next(bw)
+}
@@ -0,0 +1,207 @@
+package serverlib
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a clock advanced by the tests.
+type testClock struct {
+	mut sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// breakerServer returns a server whose routes fail while broken is set: by
+// panicking, answering a server error, or a client error which doesn't count.
+func breakerServer(clock *testClock, transitions *[]string) (*Server, *bool) {
+	broken := new(bool)
+	s := NewServer(ServerConfig{CircuitBreaker: &BreakerOptions{
+		Threshold: 3,
+		Window:    time.Minute,
+		Cooldown:  30 * time.Second,
+		Now:       clock.Now,
+		OnTransition: func(route string, from, to BreakerState) {
+			*transitions = append(*transitions, route+": "+from.String()+" -> "+to.String())
+		},
+	}})
+	s.GET("/panic", func(w http.ResponseWriter, r *http.Request) {
+		if *broken {
+			var m map[string]int
+			m["nil map"]++
+		}
+	})
+	s.GET("/error", func(w http.ResponseWriter, r *http.Request) {
+		if *broken {
+			s.Error(w, r, NewHTTPError(http.StatusBadGateway, "upstream down"))
+		}
+	})
+	s.GET("/client", func(w http.ResponseWriter, r *http.Request) {
+		if *broken {
+			s.Error(w, r, NewHTTPError(http.StatusNotFound, "no such item"))
+		}
+	})
+	return s, broken
+}
+
+// TestCircuitBreaker drives the breaker of a route through its states with a
+// fake clock. Each step is "ok" or "fail" for a request, "wait <duration>" to
+// advance the clock, "reset" to reset the breaker manually; the states are
+// the ones seen after each step.
+func TestCircuitBreaker(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tests := []struct {
+		name  string
+		route string
+		steps []string
+		// want are the statuses of the requests, 0 for the other steps.
+		want            []int
+		wantTransitions []string
+	}{
+		{"opened by panics", "/panic", []string{"fail", "fail", "fail", "ok"},
+			[]int{500, 500, 500, 503}, []string{"open"}},
+		{"opened by server errors", "/error", []string{"fail", "fail", "fail", "ok"},
+			[]int{502, 502, 502, 503}, []string{"open"}},
+		{"client errors don't count", "/client", []string{"fail", "fail", "fail", "fail"},
+			[]int{404, 404, 404, 404}, nil},
+		{"failures out of the window", "/panic", []string{"fail", "fail", "wait 61s", "fail", "ok"},
+			[]int{500, 500, 0, 500, 200}, nil},
+		{"still open in the cooldown", "/panic", []string{"fail", "fail", "fail", "wait 29s", "ok"},
+			[]int{500, 500, 500, 0, 503}, []string{"open"}},
+		{"closed by a successful probe", "/panic", []string{"fail", "fail", "fail", "wait 30s", "ok", "ok"},
+			[]int{500, 500, 500, 0, 200, 200}, []string{"open", "half-open", "closed"}},
+		{"opened again by a failed probe", "/panic", []string{"fail", "fail", "fail", "wait 30s", "fail", "ok", "wait 30s", "ok"},
+			[]int{500, 500, 500, 0, 500, 503, 0, 200}, []string{"open", "half-open", "open", "half-open", "closed"}},
+		{"manual reset", "/error", []string{"fail", "fail", "fail", "reset", "ok"},
+			[]int{502, 502, 502, 0, 200}, []string{"open", "closed"}},
+		{"failures forgotten by the reset", "/error", []string{"fail", "fail", "reset", "fail", "fail", "ok"},
+			[]int{502, 502, 0, 502, 502, 200}, nil},
+	}
+	for _, tt := range tests {
+		clock := &testClock{now: time.Unix(1000, 0)}
+		var transitions []string
+		s, broken := breakerServer(clock, &transitions)
+		route := "GET " + tt.route
+		var got []int
+		for _, step := range tt.steps {
+			switch {
+			case step == "reset":
+				s.CircuitBreakers().Reset(route)
+				got = append(got, 0)
+			case strings.HasPrefix(step, "wait "):
+				d, _ := time.ParseDuration(strings.TrimPrefix(step, "wait "))
+				clock.Advance(d)
+				got = append(got, 0)
+			default:
+				*broken = step == "fail"
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.route, nil))
+				got = append(got, w.Code)
+				if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+					t.Errorf("%s: 503 without Retry-After", tt.name)
+				}
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		var want []string
+		from := "closed"
+		for _, to := range tt.wantTransitions {
+			want = append(want, route+": "+from+" -> "+to)
+			from = to
+		}
+		if !slices.Equal(transitions, want) {
+			t.Errorf("%s: transitions %v, want %v", tt.name, transitions, want)
+		}
+		if state := s.CircuitBreakers().State(route); state.String() != from {
+			t.Errorf("%s: state %s, want %s", tt.name, state, from)
+		}
+	}
+}
+
+// TestCircuitBreakerProbe checks a half-open breaker lets a single probe
+// through, decided by the probe only, not by a request let through before
+// the breaker opened.
+func TestCircuitBreakerProbe(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	c := NewCircuitBreakers(BreakerOptions{Threshold: 1, Cooldown: time.Second, Now: clock.Now})
+	const route = "GET /slow"
+	if ok, probe, _ := c.allow(route); !ok || probe {
+		t.Fatalf("closed: allowed %v, probe %v", ok, probe)
+	}
+	// A second request fails and opens the breaker while the first one runs.
+	c.allow(route)
+	c.record(route, false, true)
+	clock.Advance(time.Second)
+	ok, probe, _ := c.allow(route)
+	if !ok || !probe || c.State(route) != BreakerHalfOpen {
+		t.Fatalf("after the cooldown: allowed %v, probe %v, %s", ok, probe, c.State(route))
+	}
+	if ok, _, retryIn := c.allow(route); ok || retryIn != time.Second {
+		t.Errorf("second request while probing: allowed %v, retry in %v", ok, retryIn)
+	}
+	// The first request completes with a failure, during the probe.
+	c.record(route, false, true)
+	if state := c.State(route); state != BreakerHalfOpen {
+		t.Errorf("state %s after a request older than the probe, want half-open", state)
+	}
+	c.record(route, true, false)
+	if state := c.State(route); state != BreakerClosed {
+		t.Errorf("state %s after the probe, want closed", state)
+	}
+}
+
+func TestCircuitBreakersConfigure(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	c := NewCircuitBreakers(BreakerOptions{Threshold: 2, Now: clock.Now})
+	c.Configure("GET /flaky", BreakerOptions{Threshold: 4, Now: clock.Now})
+	tests := []struct {
+		route string
+		// opensAfter is the number of failures opening the breaker.
+		opensAfter int
+	}{
+		{"GET /users", 2},
+		{"GET /flaky", 4},
+	}
+	for _, tt := range tests {
+		for i := 1; i <= tt.opensAfter; i++ {
+			c.allow(tt.route)
+			c.record(tt.route, false, true)
+			if open := c.State(tt.route) == BreakerOpen; open != (i == tt.opensAfter) {
+				t.Errorf("%s: open %v after %d failures", tt.route, open, i)
+			}
+		}
+	}
+	if states := c.States(); len(states) != 2 || states["GET /flaky"] != BreakerOpen {
+		t.Errorf("States %v", states)
+	}
+	var none *CircuitBreakers
+	none.Reset("GET /users")
+	none.Configure("GET /users", BreakerOptions{})
+	if none.State("GET /users") != BreakerClosed || none.States() != nil {
+		t.Error("a nil CircuitBreakers isn't closed")
+	}
+	if got := BreakerState(7).String(); got != "BreakerState(7)" {
+		t.Errorf("String %q", got)
+	}
+}
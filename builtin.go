@@ -0,0 +1,94 @@
+package serverlib
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// builtinPrefix is the namespace of the built-in templates.
+const builtinPrefix = "serverlib/"
+
+//go:embed builtin/*.html
+var builtinFS embed.FS
+
+// builtinTemplates returns the file system of the built-in templates.
+func builtinTemplates() fs.FS {
+	templates, _ := fs.Sub(builtinFS, "builtin")
+	return templates
+}
+
+// OverridableTemplates returns the names of the built-in templates, e.g.
+// "serverlib/404.html". They render the pages of the library, such as the
+// error pages, and are overridden by the templates of the sources defining
+// the same name:
+//
+//	{{define "serverlib/404.html"}}...{{end}}
+//
+// The error pages are rendered from "serverlib/<status>.html" when defined,
+// "serverlib/error.html" otherwise, with the Status, Title, Detail and
+// RequestID of the error; "serverlib/style.html" holds their style.
+func (s *Server) OverridableTemplates() []string {
+	var names []string
+	fs.WalkDir(builtinTemplates(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			names = append(names, builtinPrefix+name)
+		}
+		return err
+	})
+	sort.Strings(names)
+	return names
+}
+
+// acceptsHTML reports whether the client asks for an HTML response.
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// renderErrorPage answers with the built-in error page of the status, or
+// its override. It reports false, writing nothing, when the templates are
+// not parsed or the page fails to render.
+func (s *Server) renderErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) bool {
+	if s == nil || !acceptsHTML(r) {
+		return false
+	}
+	name := builtinPrefix + strconv.Itoa(status) + ".html"
+	if !s.t.Has(name) {
+		name = builtinPrefix + "error.html"
+	}
+	return s.renderStatusPage(w, r, name, status, message)
+}
+
+// renderStatusPage answers with the template name, rendered with the Status,
+// Title, Detail and RequestID of the error. It reports false, writing
+// nothing, when the page fails to render.
+func (s *Server) renderStatusPage(w http.ResponseWriter, r *http.Request, name string, status int, message string) bool {
+	data := map[string]interface{}{
+		"Status":    status,
+		"Title":     http.StatusText(status),
+		"Detail":    message,
+		"RequestID": reqctx.RequestID(r.Context()),
+	}
+	if message == http.StatusText(status) {
+		data["Detail"] = ""
+	}
+	var buf bytes.Buffer
+	if err := s.t.ExecuteContext(r.Context(), &buf, name, data); err != nil {
+		if err != r.Context().Err() {
+			s.LogError("Rendering error page", err.Error())
+		}
+		return false
+	}
+	s.minifyRendered(&buf, name, "text/html")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+	return true
+}
@@ -0,0 +1,134 @@
+package serverlib
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+func TestOverridableTemplates(t *testing.T) {
+	want := []string{"serverlib/404.html", "serverlib/error.html", "serverlib/requests.html", "serverlib/style.html"}
+	if got := NewServer(ServerConfig{}).OverridableTemplates(); !slices.Equal(got, want) {
+		t.Errorf("%v, want %v", got, want)
+	}
+}
+
+// TestBuiltinErrorPages renders the error pages of a server without any
+// template source, and compares them to testdata/builtin. Run with -update
+// to rewrite the golden files.
+func TestBuiltinErrorPages(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	if err := s.Templates().Parse(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		err  error
+		// requestID is the ID of the request, none when empty.
+		requestID string
+		wantCode  int
+	}{
+		{"404", NewHTTPError(http.StatusNotFound, "Not Found"), "", http.StatusNotFound},
+		{"403-detail", NewHTTPError(http.StatusForbidden, "Members <only>"), "", http.StatusForbidden},
+		{"500-request", errors.New("database password in the message"), "req-42", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", "text/html,application/xhtml+xml")
+		if tt.requestID != "" {
+			r = r.WithContext(reqctx.WithRequestID(r.Context(), tt.requestID))
+		}
+		w := httptest.NewRecorder()
+		s.Error(w, r, tt.err)
+		if w.Code != tt.wantCode || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("%s: %d %q, want %d and HTML", tt.name, w.Code, w.Header().Get("Content-Type"), tt.wantCode)
+		}
+		got := w.Body.String()
+		golden := filepath.Join("testdata", "builtin", tt.name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s:\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+// TestBuiltinOverride overrides the 404 page and the style from a user
+// source: they take precedence over the built-in templates, the other pages
+// keep the built-in ones.
+func TestBuiltinOverride(t *testing.T) {
+	s := newTemplateServer(t, map[string]string{
+		"errors.html": `{{define "serverlib/404.html"}}<p>Lost: {{.Detail}}</p>{{end}}` +
+			`{{define "serverlib/style.html"}}<link rel="stylesheet" href="/site.css">{{end}}`,
+	}, nil)
+	s.GET("/known", func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		accept   string
+		wantCode int
+		want     string
+		// wantNot must not be in the body.
+		wantNot string
+	}{
+		{"overridden 404", http.MethodGet, "/missing", "text/html", http.StatusNotFound, "<p>Lost: </p>", "<main>"},
+		{"overridden style", http.MethodPost, "/known", "text/html", http.StatusMethodNotAllowed, `<link rel="stylesheet" href="/site.css">`, "<style>"},
+		{"plain text", http.MethodGet, "/missing", "", http.StatusNotFound, "404 page not found", "Lost"},
+		{"JSON", http.MethodGet, "/missing", "application/json", http.StatusNotFound, `"status":404`, "Lost"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		body := w.Body.String()
+		if w.Code != tt.wantCode || !strings.Contains(body, tt.want) || strings.Contains(body, tt.wantNot) {
+			t.Errorf("%s: %d %q, want %d with %q", tt.name, w.Code, body, tt.wantCode, tt.want)
+		}
+	}
+}
+
+// TestBuiltinNotParsed checks the error pages fall back to plain text before
+// the templates are parsed, or when the override fails to render.
+func TestBuiltinNotParsed(t *testing.T) {
+	broken := newTemplateServer(t, map[string]string{
+		"errors.html": `{{define "serverlib/error.html"}}{{template "missing"}}{{end}}`,
+	}, nil)
+	tests := []struct {
+		name string
+		s    *Server
+	}{
+		{"not parsed", NewServer(ServerConfig{})},
+		{"broken override", broken},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		tt.s.Error(w, r, NewHTTPError(http.StatusTeapot, "short and stout"))
+		if w.Code != http.StatusTeapot || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || w.Body.String() != "short and stout\n" {
+			t.Errorf("%s: %d %q %q, want plain text", tt.name, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
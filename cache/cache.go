@@ -0,0 +1,449 @@
+// Package cache is an in-memory, concurrency-safe LRU cache. The keys are
+// spread by hash over independently locked shards, each one evicting its
+// least recently used entries past its part of the MaxEntries and MaxCost
+// limits.
+//
+// An entry may have a time to live, the default TTL of the cache or its own.
+// Expired entries are not served but stay in memory, counted by Len, until
+// Get meets them or Purge removes them: call Purge periodically on a cache of
+// short-lived entries. Purge keeps the live entries; Clear removes every one.
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionReason tells why an entry left the cache.
+type EvictionReason int
+
+const (
+	// Evicted means the entry was the least recently used one when the cache was full.
+	Evicted EvictionReason = iota
+	// Expired means the entry TTL elapsed.
+	Expired
+	// Removed means the entry was deleted or replaced.
+	Removed
+)
+
+// Options configures a Cache.
+type Options[K comparable, V any] struct {
+	// MaxEntries is the maximum number of entries. Zero means no limit.
+	MaxEntries int
+	// MaxCost is the maximum total cost of the entries. Zero means no limit.
+	MaxCost int64
+	// Cost returns the cost of an entry. Defaults to 1 for every entry.
+	Cost func(key K, value V) int64
+	// TTL is the default time to live of the entries. Zero means no expiry.
+	TTL time.Duration
+	// Shards is the number of independently locked shards. Defaults to 16,
+	// lowered to the limits so that each shard holds an entry at least. The
+	// limits are split evenly between the shards, their parts summing to them.
+	Shards int
+	// OnEvict is called, outside of the cache locks, when an entry leaves the cache.
+	OnEvict func(key K, value V, reason EvictionReason)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Stats holds the counters of a Cache.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Cost      int64
+}
+
+// Cache is a sharded, concurrency-safe LRU cache with optional TTL and cost limits.
+type Cache[K comparable, V any] struct {
+	shards  []*shard[K, V]
+	seed    maphash.Seed
+	cost    func(K, V) int64
+	ttl     time.Duration
+	onEvict func(K, V, EvictionReason)
+	now     func() time.Time
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+
+	flights    map[K]*flight[V]
+	flightsMut sync.Mutex
+}
+
+type shard[K comparable, V any] struct {
+	mut        sync.Mutex
+	entries    map[K]*list.Element
+	lru        *list.List
+	maxEntries int
+	maxCost    int64
+	cost       int64
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	cost    int64
+	expires time.Time
+}
+
+type eviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictionReason
+}
+
+// flight is an in-progress GetOrCompute call.
+type flight[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// New creates a new Cache with the given options.
+func New[K comparable, V any](opts Options[K, V]) *Cache[K, V] {
+	if opts.Shards <= 0 {
+		opts.Shards = 16
+	}
+	if opts.MaxEntries > 0 {
+		opts.Shards = min(opts.Shards, opts.MaxEntries)
+	}
+	if opts.MaxCost > 0 {
+		opts.Shards = int(min(int64(opts.Shards), opts.MaxCost))
+	}
+	if opts.Cost == nil {
+		opts.Cost = func(K, V) int64 { return 1 }
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	c := &Cache[K, V]{
+		shards:  make([]*shard[K, V], opts.Shards),
+		seed:    maphash.MakeSeed(),
+		cost:    opts.Cost,
+		ttl:     opts.TTL,
+		onEvict: opts.OnEvict,
+		now:     opts.Now,
+		flights: make(map[K]*flight[V]),
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard[K, V]{
+			entries:    make(map[K]*list.Element),
+			lru:        list.New(),
+			maxEntries: int(split(int64(opts.MaxEntries), opts.Shards, i)),
+			maxCost:    split(opts.MaxCost, opts.Shards, i),
+		}
+	}
+	return c
+}
+
+// split returns the part of a limit of the shard i out of n: the remainder of
+// the division goes to the first shards, so that the parts sum to the limit.
+func split(limit int64, n, i int) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	part := limit / int64(n)
+	if int64(i) < limit%int64(n) {
+		part++
+	}
+	return part
+}
+
+func (c *Cache[K, V]) shardFor(key K) *shard[K, V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[c.hash(key)%uint64(len(c.shards))]
+}
+
+// hash hashes the common key types directly and falls back to their
+// printed form for the others.
+func (c *Cache[K, V]) hash(key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return maphash.String(c.seed, k)
+	case int:
+		return mix(uint64(k))
+	case int64:
+		return mix(uint64(k))
+	case uint64:
+		return mix(k)
+	case uint32:
+		return mix(uint64(k))
+	default:
+		return maphash.String(c.seed, fmt.Sprintf("%#v", key))
+	}
+}
+
+// mix scrambles the bits of an integer key (splitmix64 finalizer).
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Get returns the value stored for key and whether it was found.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	s := c.shardFor(key)
+	now := c.now()
+	s.mut.Lock()
+	element, ok := s.entries[key]
+	if ok {
+		e := element.Value.(*entry[K, V])
+		if e.expired(now) {
+			s.remove(element)
+			s.mut.Unlock()
+			c.misses.Add(1)
+			c.evicted([]eviction[K, V]{{e.key, e.value, Expired}})
+			var zero V
+			return zero, false
+		}
+		s.lru.MoveToFront(element)
+		value := e.value
+		s.mut.Unlock()
+		c.hits.Add(1)
+		return value, true
+	}
+	s.mut.Unlock()
+	c.misses.Add(1)
+	var zero V
+	return zero, false
+}
+
+// Set stores value for key with the default TTL.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores value for key with the given time to live. Zero means no expiry.
+// Entries costing more than the limit of their shard are not stored.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	s := c.shardFor(key)
+	e := &entry[K, V]{key: key, value: value, cost: c.cost(key, value)}
+	if ttl > 0 {
+		e.expires = c.now().Add(ttl)
+	}
+	var evictions []eviction[K, V]
+	s.mut.Lock()
+	if element, ok := s.entries[key]; ok {
+		old := element.Value.(*entry[K, V])
+		s.remove(element)
+		evictions = append(evictions, eviction[K, V]{old.key, old.value, Removed})
+	}
+	if s.maxCost > 0 && e.cost > s.maxCost {
+		s.mut.Unlock()
+		evictions = append(evictions, eviction[K, V]{key, value, Evicted})
+		c.evicted(evictions)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(e)
+	s.cost += e.cost
+	for s.overflow() {
+		back := s.lru.Back()
+		old := back.Value.(*entry[K, V])
+		s.remove(back)
+		evictions = append(evictions, eviction[K, V]{old.key, old.value, Evicted})
+		c.evictions.Add(1)
+	}
+	s.mut.Unlock()
+	c.evicted(evictions)
+}
+
+// Delete removes the entry stored for key, if any.
+func (c *Cache[K, V]) Delete(key K) bool {
+	s := c.shardFor(key)
+	s.mut.Lock()
+	element, ok := s.entries[key]
+	if !ok {
+		s.mut.Unlock()
+		return false
+	}
+	e := element.Value.(*entry[K, V])
+	s.remove(element)
+	s.mut.Unlock()
+	c.evicted([]eviction[K, V]{{e.key, e.value, Removed}})
+	return true
+}
+
+// GetOrCompute returns the value stored for key, computing and storing it with
+// compute when it is missing. Concurrent calls for the same missing key wait
+// for a single compute call and share its result. Errors are not cached.
+func (c *Cache[K, V]) GetOrCompute(key K, compute func() (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+	c.flightsMut.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.flightsMut.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	// A flight for key may have stored its value and completed since Get.
+	if value, ok := c.cached(key); ok {
+		c.flightsMut.Unlock()
+		return value, nil
+	}
+	f := &flight[V]{done: make(chan struct{})}
+	c.flights[key] = f
+	c.flightsMut.Unlock()
+
+	defer func() {
+		c.flightsMut.Lock()
+		delete(c.flights, key)
+		c.flightsMut.Unlock()
+		close(f.done)
+	}()
+	f.value, f.err = c.compute(compute)
+	if f.err == nil {
+		c.Set(key, f.value)
+	}
+	return f.value, f.err
+}
+
+// cached returns the live value stored for key, like Get but without
+// counting a hit or a miss nor removing the entry when it expired.
+func (c *Cache[K, V]) cached(key K) (V, bool) {
+	s := c.shardFor(key)
+	now := c.now()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if element, ok := s.entries[key]; ok {
+		if e := element.Value.(*entry[K, V]); !e.expired(now) {
+			s.lru.MoveToFront(element)
+			return e.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// compute calls fn, converting a panic into an error so the waiters are released.
+func (c *Cache[K, V]) compute(fn func() (V, error)) (value V, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("cache: compute panicked: %v", p)
+		}
+	}()
+	return fn()
+}
+
+// Range calls fn for every live entry until fn returns false.
+// The entries are not touched: the LRU order does not change.
+func (c *Cache[K, V]) Range(fn func(key K, value V) bool) {
+	now := c.now()
+	for _, s := range c.shards {
+		s.mut.Lock()
+		entries := make([]*entry[K, V], 0, len(s.entries))
+		for element := s.lru.Front(); element != nil; element = element.Next() {
+			if e := element.Value.(*entry[K, V]); !e.expired(now) {
+				entries = append(entries, e)
+			}
+		}
+		s.mut.Unlock()
+		for _, e := range entries {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// Purge removes the expired entries.
+func (c *Cache[K, V]) Purge() {
+	now := c.now()
+	for _, s := range c.shards {
+		var evictions []eviction[K, V]
+		s.mut.Lock()
+		for element := s.lru.Back(); element != nil; {
+			prev := element.Prev()
+			if e := element.Value.(*entry[K, V]); e.expired(now) {
+				s.remove(element)
+				evictions = append(evictions, eviction[K, V]{e.key, e.value, Expired})
+			}
+			element = prev
+		}
+		s.mut.Unlock()
+		c.evicted(evictions)
+	}
+}
+
+// Clear removes every entry, OnEvict being called with Removed. The
+// GetOrCompute calls in progress still store their value.
+func (c *Cache[K, V]) Clear() {
+	for _, s := range c.shards {
+		var evictions []eviction[K, V]
+		s.mut.Lock()
+		for element := s.lru.Back(); element != nil; element = element.Prev() {
+			e := element.Value.(*entry[K, V])
+			evictions = append(evictions, eviction[K, V]{e.key, e.value, Removed})
+		}
+		s.entries = make(map[K]*list.Element)
+		s.lru.Init()
+		s.cost = 0
+		s.mut.Unlock()
+		c.evicted(evictions)
+	}
+}
+
+// Len returns the number of entries, expired entries not yet removed included.
+func (c *Cache[K, V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mut.Lock()
+		n += len(s.entries)
+		s.mut.Unlock()
+	}
+	return n
+}
+
+// Stats returns the counters of the cache.
+func (c *Cache[K, V]) Stats() Stats {
+	stats := Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+	for _, s := range c.shards {
+		s.mut.Lock()
+		stats.Entries += len(s.entries)
+		stats.Cost += s.cost
+		s.mut.Unlock()
+	}
+	return stats
+}
+
+func (c *Cache[K, V]) evicted(evictions []eviction[K, V]) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, e := range evictions {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+}
+
+func (s *shard[K, V]) remove(element *list.Element) {
+	e := element.Value.(*entry[K, V])
+	s.lru.Remove(element)
+	delete(s.entries, e.key)
+	s.cost -= e.cost
+}
+
+func (s *shard[K, V]) overflow() bool {
+	if s.lru.Len() <= 1 {
+		return false
+	}
+	return (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxCost > 0 && s.cost > s.maxCost)
+}
+
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
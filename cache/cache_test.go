@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// keys returns the keys of c from the most to the least recently used.
+func keys[V any](c *Cache[string, V]) []string {
+	var keys []string
+	for _, s := range c.shards {
+		for element := s.lru.Front(); element != nil; element = element.Next() {
+			keys = append(keys, element.Value.(*entry[string, V]).key)
+		}
+	}
+	return keys
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		limit  int64
+		shards int
+	}{
+		{100, 16},
+		{16, 16},
+		{17, 16},
+		{1000, 7},
+		{5, 16},
+		{1, 16},
+	}
+	for _, tt := range tests {
+		c := New(Options[string, int]{MaxEntries: int(tt.limit), MaxCost: tt.limit, Shards: tt.shards})
+		var entries, cost int64
+		for _, s := range c.shards {
+			if s.maxEntries < 1 || s.maxCost < 1 {
+				t.Errorf("limit %d over %d shards: a shard limited to %d entries and %d cost", tt.limit, tt.shards, s.maxEntries, s.maxCost)
+			}
+			entries += int64(s.maxEntries)
+			cost += s.maxCost
+		}
+		if entries != tt.limit || cost != tt.limit {
+			t.Errorf("limit %d over %d shards: shards sum to %d entries and %d cost", tt.limit, tt.shards, entries, cost)
+		}
+	}
+}
+
+func TestEvictionOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions func(c *Cache[string, int])
+		want    []string
+		evicted []string
+	}{
+		{"least recently set", func(c *Cache[string, int]) {
+			for _, key := range []string{"a", "b", "c", "d"} {
+				c.Set(key, 0)
+			}
+		}, []string{"d", "c", "b"}, []string{"a"}},
+		{"get refreshes", func(c *Cache[string, int]) {
+			c.Set("a", 0)
+			c.Set("b", 0)
+			c.Set("c", 0)
+			c.Get("a")
+			c.Set("d", 0)
+		}, []string{"d", "a", "c"}, []string{"b"}},
+		{"replace refreshes", func(c *Cache[string, int]) {
+			c.Set("a", 0)
+			c.Set("b", 0)
+			c.Set("c", 0)
+			c.Set("a", 1)
+			c.Set("d", 0)
+		}, []string{"d", "a", "c"}, []string{"b"}},
+		{"range doesn't refresh", func(c *Cache[string, int]) {
+			c.Set("a", 0)
+			c.Set("b", 0)
+			c.Set("c", 0)
+			c.Range(func(string, int) bool { return true })
+			c.Set("d", 0)
+		}, []string{"d", "c", "b"}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		var evicted []string
+		c := New(Options[string, int]{MaxEntries: 3, Shards: 1, OnEvict: func(key string, _ int, reason EvictionReason) {
+			if reason == Evicted {
+				evicted = append(evicted, key)
+			}
+		}})
+		tt.actions(c)
+		if got := keys(c); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: entries %q, want %q", tt.name, got, tt.want)
+		}
+		if !slices.Equal(evicted, tt.evicted) {
+			t.Errorf("%s: evicted %q, want %q", tt.name, evicted, tt.evicted)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	now := time.Now()
+	var reasons []EvictionReason
+	c := New(Options[string, int]{TTL: time.Minute, Now: func() time.Time { return now }, OnEvict: func(_ string, _ int, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	}})
+	c.Set("default", 1)
+	c.SetWithTTL("short", 2, time.Second)
+	c.SetWithTTL("forever", 3, 0)
+
+	now = now.Add(2 * time.Second)
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"default", true},
+		{"short", false},
+		{"forever", true},
+	}
+	for _, tt := range tests {
+		if _, ok := c.Get(tt.key); ok != tt.want {
+			t.Errorf("after 2s: Get(%q) found %v, want %v", tt.key, ok, tt.want)
+		}
+	}
+	if !slices.Equal(reasons, []EvictionReason{Expired}) {
+		t.Errorf("evictions %v, want one Expired", reasons)
+	}
+
+	now = now.Add(time.Hour)
+	c.Purge()
+	if got := c.Len(); got != 1 {
+		t.Errorf("after an hour and Purge: %d entries, want the one without expiry", got)
+	}
+	if _, ok := c.Get("forever"); !ok {
+		t.Error("the entry without expiry expired")
+	}
+}
+
+func TestClear(t *testing.T) {
+	var removed []string
+	c := New(Options[string, string]{MaxCost: 100, Cost: func(_, value string) int64 { return int64(len(value)) }, OnEvict: func(key, _ string, reason EvictionReason) {
+		if reason == Removed {
+			removed = append(removed, key)
+		}
+	}})
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, key+key)
+	}
+	c.Get("a")
+	c.Clear()
+	slices.Sort(removed)
+	if stats := c.Stats(); stats.Entries != 0 || stats.Cost != 0 || !slices.Equal(removed, []string{"a", "b", "c"}) {
+		t.Errorf("after Clear: %+v, removed %q", stats, removed)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("entry found after Clear")
+	}
+	// The cache is usable again.
+	c.Set("d", "dd")
+	if got, ok := c.Get("d"); !ok || got != "dd" || c.Stats().Cost != 2 {
+		t.Errorf("after Clear: Get(\"d\") = %q %v", got, ok)
+	}
+}
+
+func TestCost(t *testing.T) {
+	c := New(Options[string, string]{MaxCost: 10, Shards: 1, Cost: func(_, value string) int64 { return int64(len(value)) }})
+	tests := []struct {
+		key, value string
+		wantCost   int64
+		wantKeys   []string
+	}{
+		{"a", "1234", 4, []string{"a"}},
+		{"b", "123456", 10, []string{"b", "a"}},
+		{"c", "12", 8, []string{"c", "b"}},
+		{"b", "1", 3, []string{"b", "c"}},
+		{"huge", "12345678901", 3, []string{"b", "c"}},
+		{"d", "1234567890", 10, []string{"d"}},
+	}
+	for _, tt := range tests {
+		c.Set(tt.key, tt.value)
+		if got := c.Stats().Cost; got != tt.wantCost {
+			t.Errorf("after Set(%q, %q): cost %d, want %d", tt.key, tt.value, got, tt.wantCost)
+		}
+		if got := keys(c); !slices.Equal(got, tt.wantKeys) {
+			t.Errorf("after Set(%q, %q): entries %q, want %q", tt.key, tt.value, got, tt.wantKeys)
+		}
+	}
+	c.Delete("d")
+	if stats := c.Stats(); stats.Cost != 0 || stats.Entries != 0 {
+		t.Errorf("after Delete: %+v, want empty", stats)
+	}
+}
+
+func TestGetOrCompute(t *testing.T) {
+	c := New(Options[string, int]{})
+	failure := errors.New("failure")
+	if _, err := c.GetOrCompute("k", func() (int, error) { return 0, failure }); !errors.Is(err, failure) {
+		t.Errorf("GetOrCompute: %v, want the compute error", err)
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Error("the error was cached")
+	}
+	if _, err := c.GetOrCompute("k", func() (int, error) { panic("boom") }); err == nil {
+		t.Error("GetOrCompute: no error for a panicking compute")
+	}
+	value, err := c.GetOrCompute("k", func() (int, error) { return 42, nil })
+	if err != nil || value != 42 {
+		t.Errorf("GetOrCompute = %d, %v, want 42", value, err)
+	}
+	if value, _ := c.GetOrCompute("k", func() (int, error) { return 0, nil }); value != 42 {
+		t.Errorf("GetOrCompute computed a cached value again: %d", value)
+	}
+}
+
+// TestGetOrComputeOnce calls GetOrCompute concurrently for the same key,
+// round after round: compute runs once per key.
+func TestGetOrComputeOnce(t *testing.T) {
+	c := New(Options[string, int]{})
+	for round := range 200 {
+		key := strconv.Itoa(round)
+		var computes atomic.Int32
+		var wg sync.WaitGroup
+		for range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := c.GetOrCompute(key, func() (int, error) {
+					computes.Add(1)
+					return round, nil
+				})
+				if err != nil || value != round {
+					t.Errorf("GetOrCompute(%q) = %d, %v", key, value, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := computes.Load(); n != 1 {
+			t.Fatalf("key %q computed %d times", key, n)
+		}
+	}
+}
+
+// TestGetOrComputeAfterFlight completes a flight between the miss of a
+// caller and its check of the flights: the caller finds the stored value
+// instead of computing it again.
+func TestGetOrComputeAfterFlight(t *testing.T) {
+	c := New(Options[string, int]{})
+	c.flightsMut.Lock()
+	result := make(chan int)
+	go func() {
+		value, _ := c.GetOrCompute("k", func() (int, error) { return 0, nil })
+		result <- value
+	}()
+	for c.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The flight stores its value, then leaves the flights.
+	c.Set("k", 42)
+	c.flightsMut.Unlock()
+	if value := <-result; value != 42 {
+		t.Errorf("GetOrCompute = %d, want the value stored by the flight", value)
+	}
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	c := New(Options[string, int]{MaxEntries: 10000})
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		c.Set(keys[i], i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkSetParallel(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := New(Options[int, int]{MaxEntries: 1000, Shards: shards})
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1) << 20)
+				for pb.Next() {
+					c.Set(i, i)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetSetParallel(b *testing.B) {
+	c := New(Options[int, int]{MaxEntries: 1000})
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%4 == 0 {
+				c.Set(i%2000, i)
+			} else {
+				c.Get(i % 2000)
+			}
+			i++
+		}
+	})
+}
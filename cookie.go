@@ -0,0 +1,164 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// DefaultCookieMaxAge is the lifetime of the session cookie when none is configured.
+const DefaultCookieMaxAge = 3600 * 24 * 7 // 1 week
+
+// CookieAttributes describes the attributes of the session cookie.
+// The cookie is always HttpOnly.
+type CookieAttributes struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge is the cookie lifetime in seconds. Defaults to DefaultCookieMaxAge.
+	MaxAge int
+}
+
+// CookieConfig configures the session cookie.
+//
+// Changing the attributes between deployments can leave browsers with several
+// cookies of the same name (a different Path or Domain makes a different cookie).
+// To migrate cleanly, bump Version and list the attributes used so far in Previous:
+// the cookies written under an older version are re-issued with the current
+// attributes, keeping the same session, and the cookies matching the previous
+// attributes are expired so they can't shadow the new one.
+type CookieConfig struct {
+	CookieAttributes
+	// Version is the version of the attributes, embedded in the cookie value.
+	Version int
+	// Previous lists the attributes of the cookies written by older versions.
+	Previous []CookieAttributes
+}
+
+// encodeSessionCookie returns the value of a session cookie.
+// Version 0 cookies hold the bare session ID, newer versions are prefixed with "v<version>~".
+func encodeSessionCookie(id string, version int) string {
+	if version <= 0 {
+		return id
+	}
+	return "v" + strconv.Itoa(version) + "~" + id
+}
+
+// decodeSessionCookie returns the session ID and attributes version of a session cookie value.
+func decodeSessionCookie(value string) (string, int) {
+	prefix, id, ok := strings.Cut(value, "~")
+	if !ok || len(prefix) < 2 || prefix[0] != 'v' || prefix[1] == '0' {
+		return value, 0
+	}
+	// Only the form written by encodeSessionCookie is a version: "v01" or
+	// "v+1" are part of a bare ID.
+	for _, digit := range prefix[1:] {
+		if digit < '0' || digit > '9' {
+			return value, 0
+		}
+	}
+	version, err := strconv.Atoi(prefix[1:])
+	if err != nil {
+		return value, 0
+	}
+	return id, version
+}
+
+// cookieValues calls fn with the value of each cookie name of the request, in
+// their order, and returns their number. Unlike http.Request.CookiesNamed, it
+// doesn't allocate, the session cookie being read on every request.
+func cookieValues(r *http.Request, name string, fn func(value string)) int {
+	n := 0
+	for _, line := range r.Header["Cookie"] {
+		for line != "" {
+			var part string
+			part, line, _ = strings.Cut(line, ";")
+			part = textproto.TrimString(part)
+			if part == "" {
+				continue
+			}
+			// Like net/http, a cookie without "=" has an empty value.
+			cookieName, value, _ := strings.Cut(part, "=")
+			if textproto.TrimString(cookieName) != name {
+				continue
+			}
+			if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			if !validCookieValue(value) {
+				continue
+			}
+			n++
+			fn(value)
+		}
+	}
+	return n
+}
+
+// validCookieValue reports whether the value only has the bytes net/http
+// accepts: the ones of RFC 6265, plus the space and the comma.
+func validCookieValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if b := value[i]; b < 0x20 || b >= 0x7f || b == '"' || b == ';' || b == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionCookie returns the session cookie for the given session ID with the current attributes.
+func (s *Server) sessionCookie(id string) *http.Cookie {
+	maxAge := s.cookie.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultCookieMaxAge
+	}
+	return &http.Cookie{
+		Name:     s.sessionKey,
+		Value:    encodeSessionCookie(id, s.cookie.Version),
+		Path:     s.cookie.Path,
+		Domain:   s.cookie.Domain,
+		Secure:   s.cookie.Secure,
+		SameSite: s.cookie.SameSite,
+		HttpOnly: true,
+		MaxAge:   maxAge,
+	}
+}
+
+// migrateSessionCookie re-issues the session cookie with the current attributes.
+// The cookies of the previous attributes that differ by Path or Domain are
+// expired first, then the current cookie is set last.
+func (s *Server) migrateSessionCookie(w http.ResponseWriter, session sessions.Session) {
+	// The session ID is a credential: never logged.
+	s.LogDebug("Migrating session cookie", "to version "+strconv.Itoa(s.cookie.Version))
+	s.expirePreviousCookies(w, CookieSourceMigration)
+	s.setCookie(w, s.sessionCookie(session.Id()), CookieSourceMigration, CookiePriorityRequired)
+}
+
+// expirePreviousCookies expires the session cookies of the previous attributes
+// that the current cookie doesn't overwrite.
+func (s *Server) expirePreviousCookies(w http.ResponseWriter, source CookieSource) {
+	for _, previous := range s.cookie.Previous {
+		if previous.Path == s.cookie.Path && previous.Domain == s.cookie.Domain {
+			// Overwritten by the current cookie.
+			continue
+		}
+		s.expireSessionCookie(w, previous, source)
+	}
+}
+
+// expireSessionCookie expires the session cookie of the given attributes.
+func (s *Server) expireSessionCookie(w http.ResponseWriter, attributes CookieAttributes, source CookieSource) {
+	s.setCookie(w, &http.Cookie{
+		Name:     s.sessionKey,
+		Path:     attributes.Path,
+		Domain:   attributes.Domain,
+		Secure:   attributes.Secure,
+		SameSite: attributes.SameSite,
+		HttpOnly: true,
+		MaxAge:   -1,
+	}, source, CookiePriorityLow)
+}
@@ -0,0 +1,178 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// cookieJar is a browser cookie jar reduced to what matters for the session
+// cookie: a cookie is identified by its name, path and domain, and the
+// cookies of longer paths are sent first.
+type cookieJar struct {
+	cookies []*http.Cookie
+}
+
+// set applies the Set-Cookie lines of a response, in their order.
+func (j *cookieJar) set(lines []string) {
+	for _, line := range lines {
+		cookie, err := http.ParseSetCookie(line)
+		if err != nil {
+			continue
+		}
+		j.cookies = slices.DeleteFunc(j.cookies, func(held *http.Cookie) bool {
+			return held.Name == cookie.Name && held.Path == cookie.Path && held.Domain == cookie.Domain
+		})
+		if cookie.MaxAge >= 0 {
+			j.cookies = append(j.cookies, cookie)
+		}
+	}
+}
+
+// header returns the Cookie header a browser would send.
+func (j *cookieJar) header() string {
+	sent := append([]*http.Cookie(nil), j.cookies...)
+	sort.SliceStable(sent, func(a, b int) bool { return len(sent[a].Path) > len(sent[b].Path) })
+	pairs := make([]string, len(sent))
+	for i, cookie := range sent {
+		pairs[i] = cookie.Name + "=" + cookie.Value
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// TestSessionCookieMigration moves the session cookie from SameSite=Lax on
+// /app to SameSite=Strict on / of example.com: the jar of every client ends
+// up with the single current cookie of the session it should keep.
+func TestSessionCookieMigration(t *testing.T) {
+	current := CookieAttributes{Path: "/", Domain: "example.com", SameSite: http.SameSiteStrictMode}
+	previous := CookieAttributes{Path: "/app", SameSite: http.SameSiteLaxMode}
+	tests := []struct {
+		name string
+		// held returns the cookies of the jar for the sessions old and new.
+		held      func(s *Server, old, new string) []*http.Cookie
+		wantLines []string
+		wantID    string
+	}{
+		{"current cookie", func(s *Server, _, new string) []*http.Cookie {
+			return []*http.Cookie{{Name: s.sessionKey, Value: "v1~" + new, Path: "/", Domain: "example.com", SameSite: http.SameSiteStrictMode}}
+		}, nil, "new"},
+		{"old cookie", func(s *Server, old, _ string) []*http.Cookie {
+			return []*http.Cookie{{Name: s.sessionKey, Value: old, Path: "/app"}}
+		}, []string{"Path=/app; Max-Age=0", "v1~old; Path=/; Domain=example.com"}, "old"},
+		{"old cookie shadowing the current one", func(s *Server, old, new string) []*http.Cookie {
+			return []*http.Cookie{
+				{Name: s.sessionKey, Value: "v1~" + new, Path: "/", Domain: "example.com", SameSite: http.SameSiteStrictMode},
+				{Name: s.sessionKey, Value: old, Path: "/app"},
+			}
+		}, []string{"Path=/app; Max-Age=0", "v1~new; Path=/; Domain=example.com"}, "new"},
+		{"old cookie of an expired session", func(s *Server, _, new string) []*http.Cookie {
+			return []*http.Cookie{
+				{Name: s.sessionKey, Value: "v1~" + new, Path: "/", Domain: "example.com", SameSite: http.SameSiteStrictMode},
+				{Name: s.sessionKey, Value: "expired", Path: "/app"},
+			}
+		}, []string{"Path=/app; Max-Age=0", "v1~new; Path=/; Domain=example.com"}, "new"},
+		{"current cookie of an expired session", func(s *Server, old, _ string) []*http.Cookie {
+			return []*http.Cookie{
+				{Name: s.sessionKey, Value: "v1~expired", Path: "/", Domain: "example.com", SameSite: http.SameSiteStrictMode},
+				{Name: s.sessionKey, Value: old, Path: "/app"},
+			}
+		}, []string{"Path=/app; Max-Age=0", "v1~old; Path=/; Domain=example.com"}, "old"},
+		{"no session", func(s *Server, _, _ string) []*http.Cookie {
+			return []*http.Cookie{{Name: s.sessionKey, Value: "expired", Path: "/app"}}
+		}, []string{"Path=/app; Max-Age=0", "Path=/; Domain=example.com"}, ""},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{SessionCookie: CookieConfig{CookieAttributes: current, Version: 1, Previous: []CookieAttributes{previous}}})
+		ids := map[string]string{}
+		for _, name := range []string{"old", "new"} {
+			session := s.sessionManager.New()
+			session.Set("name", name)
+			ids[name] = session.Id()
+		}
+		var gotID string
+		s.GET("/app/page", func(w http.ResponseWriter, r *http.Request) {
+			session, _ := s.GetSession(w, r)
+			gotID, _ = session.Get("name").(string)
+		})
+		jar := &cookieJar{cookies: tt.held(s, ids["old"], ids["new"])}
+
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/app/page", nil)
+		r.Header.Set("Cookie", jar.header())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		lines := w.Result().Header["Set-Cookie"]
+		if len(lines) != len(tt.wantLines) {
+			t.Errorf("%s: Set-Cookie %q, want %d lines", tt.name, lines, len(tt.wantLines))
+		}
+		for i := 0; i < len(lines) && i < len(tt.wantLines); i++ {
+			want := strings.NewReplacer("old", ids["old"], "new", ids["new"]).Replace(tt.wantLines[i])
+			if !strings.Contains(lines[i], want) {
+				t.Errorf("%s: Set-Cookie %d is %q, want %q", tt.name, i, lines[i], want)
+			}
+		}
+		if gotID != tt.wantID {
+			t.Errorf("%s: session %q, want %q", tt.name, gotID, tt.wantID)
+		}
+
+		// The next request only sends the current cookie, left as is.
+		jar.set(lines)
+		held := jar.cookies
+		if len(held) != 1 || held[0].Path != "/" || held[0].Domain != "example.com" || held[0].SameSite != http.SameSiteStrictMode {
+			t.Errorf("%s: jar holds %v, want the single current cookie", tt.name, held)
+			continue
+		}
+		r = httptest.NewRequest(http.MethodGet, "http://example.com/app/page", nil)
+		r.Header.Set("Cookie", jar.header())
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if lines := w.Result().Header["Set-Cookie"]; len(lines) != 0 || gotID != tt.wantID {
+			t.Errorf("%s: next request wrote %q for the session %q, want nothing for %q", tt.name, lines, gotID, tt.wantID)
+		}
+	}
+}
+
+func TestSessionCookieValue(t *testing.T) {
+	tests := []struct {
+		value       string
+		wantID      string
+		wantVersion int
+	}{
+		{"abc", "abc", 0},
+		{"v1~abc", "abc", 1},
+		{"v12~abc", "abc", 12},
+		{"v0~abc", "v0~abc", 0},
+		{"v01~abc", "v01~abc", 0},
+		{"v+1~abc", "v+1~abc", 0},
+		{"v~abc", "v~abc", 0},
+		{"x1~abc", "x1~abc", 0},
+		{"v1~a~b", "a~b", 1},
+	}
+	for _, tt := range tests {
+		if id, version := decodeSessionCookie(tt.value); id != tt.wantID || version != tt.wantVersion {
+			t.Errorf("decodeSessionCookie(%q) = %q, %d, want %q, %d", tt.value, id, version, tt.wantID, tt.wantVersion)
+		}
+	}
+	for _, tt := range tests {
+		if tt.wantVersion == 0 && tt.wantID != tt.value {
+			continue
+		}
+		if got := encodeSessionCookie(tt.wantID, tt.wantVersion); got != tt.value {
+			t.Errorf("encodeSessionCookie(%q, %d) = %q, want %q", tt.wantID, tt.wantVersion, got, tt.value)
+		}
+	}
+}
+
+func TestCookieValuesOrder(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Add("Cookie", `sid=a; other=x; sid="b"`)
+	r.Header.Add("Cookie", "sid=c;sid=bad\\value; sid")
+	var got []string
+	n := cookieValues(r, "sid", func(value string) { got = append(got, value) })
+	if want := []string{"a", "b", "c", ""}; n != len(want) || !reflect.DeepEqual(got, want) {
+		t.Errorf("cookieValues = %d %q, want %q", n, got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/tasks"
+)
+
+// TestRequestCorrelation follows a request into the task it starts, which
+// runs once the response is sent: the log lines of the request, of the task
+// and of its failure carry the same correlation.
+func TestRequestCorrelation(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	s := NewServer(ServerConfig{})
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var requestID string
+	var id tasks.TaskID
+	release := make(chan struct{})
+	s.POST("/orders", func(w http.ResponseWriter, r *http.Request) {
+		requestID = reqctx.RequestID(r.Context())
+		s.GetSession(w, r)
+		ctx := reqctx.WithPrincipal(r.Context(), "alice")
+		reqctx.Logger(ctx).Info("Order published")
+		id = s.Tasks().Start(ctx, func(ctx context.Context, _ func(int)) (any, error) {
+			<-release
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			reqctx.Logger(ctx).Info("Webhook attempt", "attempt", 1)
+			return nil, errors.New("webhook refused")
+		})
+	})
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.Header.Set("Traceparent", traceParent)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	// The request is over, its context cancelled.
+	close(release)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if task, _ := s.Tasks().Get(id); task.Status == tasks.Failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the task didn't fail")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"Order published", []string{"request_id=" + requestID}},
+		{"Webhook attempt", []string{"request_id=" + requestID, "principal=alice", "traceparent=" + traceParent, "session_hash="}},
+		{"Task failed", []string{"request_id=" + requestID, "principal=alice", "traceparent=" + traceParent, "session_hash=", "webhook refused"}},
+	}
+	lines := strings.Split(logs.String(), "\n")
+	for _, tt := range tests {
+		var line string
+		for _, l := range lines {
+			if strings.Contains(l, `msg="`+tt.message+`"`) {
+				line = l
+			}
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(line, want) {
+				t.Errorf("%s: logged %q, want %q", tt.message, line, want)
+			}
+		}
+	}
+}
+
+func TestValidTraceParent(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		// A later version may append fields.
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g", false},
+	}
+	for _, tt := range tests {
+		if got := validTraceParent(tt.value); got != tt.want {
+			t.Errorf("%q: %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
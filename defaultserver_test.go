@@ -0,0 +1,175 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Morditux/serverlib/scratch"
+	"github.com/Morditux/serverlib/tasks"
+)
+
+// syncBuffer is a log output safe for concurrent writes.
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.String()
+}
+
+func (b *syncBuffer) Reset() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.buf.Reset()
+}
+
+// newLoggedServer returns a server logging everything to its own buffer, with
+// its own message for "validation.integer".
+func newLoggedServer(name string) (*Server, *syncBuffer) {
+	logs := &syncBuffer{}
+	s := NewServer(ServerConfig{
+		Address:  "127.0.0.1:0",
+		ErrorLog: log.New(logs, "", 0),
+		LogLevel: Error,
+	})
+	s.Messages().Add(s.Messages().Fallback(), map[string]string{"validation.integer": name + " wants an integer"})
+	return s, logs
+}
+
+// TestServersAreIndependent runs two servers side by side: each logs,
+// translates and runs its tasks through itself, never through the default
+// server.
+func TestServersAreIndependent(t *testing.T) {
+	first, firstLogs := newLoggedServer("first")
+	second, secondLogs := newLoggedServer("second")
+	servers := []struct {
+		name string
+		s    *Server
+		logs *syncBuffer
+		// other is the log of the other server.
+		other *syncBuffer
+	}{
+		{"first", first, firstLogs, secondLogs},
+		{"second", second, secondLogs, firstLogs},
+	}
+	for _, tt := range servers {
+		tt.s.GET("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			SetCookie(w, &http.Cookie{Name: tt.name + "_cookie", Value: "1"}, CookiePriorityNormal)
+			_, err := ParamInt(r, "id")
+			Query(r).Int("page", 1)
+			var errs ValidationErrors
+			errors.As(err, &errs)
+			for _, fieldError := range errs {
+				w.Write([]byte(fieldError.Message + "\n"))
+			}
+			var query struct {
+				Page int `query:"page"`
+			}
+			if err := BindQuery(r, &query); errors.As(err, &errs) {
+				for _, fieldError := range errs {
+					w.Write([]byte(fieldError.Message + "\n"))
+				}
+			}
+		})
+	}
+
+	for _, tt := range servers {
+		w := httptest.NewRecorder()
+		tt.s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/x?page=y", nil))
+		body := w.Body.String()
+		if want := tt.name + " wants an integer\n" + tt.name + " wants an integer\n"; body != want {
+			t.Errorf("%s: messages %q, want %q", tt.name, body, want)
+		}
+		if logs := tt.logs.String(); !strings.Contains(logs, tt.name+"_cookie") {
+			t.Errorf("%s: cookie not logged by its server: %q", tt.name, logs)
+		}
+		if logs := tt.other.String(); strings.Contains(logs, tt.name+"_cookie") {
+			t.Errorf("%s: cookie logged by the other server: %q", tt.name, logs)
+		}
+	}
+
+	if first.Tasks() == second.Tasks() || first.Tasks() == tasks.Default {
+		t.Error("the servers share a task runner")
+	}
+
+	for _, tt := range servers {
+		tt.s.OnShutdown(func(ctx context.Context) error {
+			return errors.New(tt.name + " hook")
+		})
+		done := startServer(tt.s)
+		<-tt.s.Ready()
+		tt.s.Shutdown(context.Background())
+		waitStart(t, done)
+		if logs := tt.logs.String(); !strings.Contains(logs, tt.name+" hook") {
+			t.Errorf("%s: hook failure not logged by its server: %q", tt.name, logs)
+		}
+		if logs := tt.other.String(); strings.Contains(logs, tt.name+" hook") {
+			t.Errorf("%s: hook failure logged by the other server: %q", tt.name, logs)
+		}
+	}
+}
+
+func TestServerOfWriter(t *testing.T) {
+	s := newTestServer()
+	var got *Server
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		got = serverOfWriter(w)
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got != s {
+		t.Errorf("serverOfWriter = %p, want the server answering %p", got, s)
+	}
+	if got := serverOfWriter(httptest.NewRecorder()); got != ServerInstance {
+		t.Errorf("serverOfWriter outside of a server = %p, want the default server %p", got, ServerInstance)
+	}
+}
+
+// TestTempDirPerServer gives two servers their own TempDir root: the request
+// and task directories of each are created under its root, and the process
+// defaults are left alone.
+func TestTempDirPerServer(t *testing.T) {
+	defaults := scratch.Defaults()
+	for _, name := range []string{"first", "second"} {
+		root := filepath.Join(t.TempDir(), name)
+		s := NewServer(ServerConfig{TempDir: &scratch.Options{Root: root}})
+		var requestDir string
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			requestDir, _ = TempDir(r)
+		})
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if !strings.HasPrefix(requestDir, root) {
+			t.Errorf("%s: request directory %q, want under %q", name, requestDir, root)
+		}
+
+		taskDir := make(chan string, 1)
+		s.Tasks().Start(context.Background(), func(ctx context.Context, report func(int)) (any, error) {
+			dir, err := tasks.TempDir(ctx)
+			taskDir <- dir
+			return nil, err
+		})
+		if dir := <-taskDir; !strings.HasPrefix(dir, root) {
+			t.Errorf("%s: task directory %q, want under %q", name, dir, root)
+		}
+		s.Tasks().Close(context.Background())
+	}
+	if got := scratch.Defaults(); got != defaults {
+		t.Errorf("scratch defaults changed to %+v", got)
+	}
+}
@@ -0,0 +1,137 @@
+package serverlib
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// discardWriter is a response writer discarding the response, its header
+// reset for each request.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// defaultStack returns a server with the default middleware stack and a GET
+// with a session cookie and a path parameter to serve with it.
+func defaultStack(t testing.TB) (*Server, *http.Request) {
+	t.Helper()
+	s := NewServer(ServerConfig{})
+	s.GET("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s.GetSession(w, r)
+		w.Write([]byte(r.PathValue("id")))
+	})
+	// A first request creates the session.
+	first := httptest.NewRecorder()
+	s.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	// The context of the requests of a started server holds it, see
+	// newHTTPServer.
+	r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	r = r.WithContext(context.WithValue(r.Context(), serverKey{}, s))
+	for _, cookie := range first.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return s, r
+}
+
+// BenchmarkDefaultStack serves a GET with a session cookie and a path
+// parameter through the default middleware stack of a server. The numbers
+// before and after the pooled request state are in
+// testdata/defaultstack-before.txt and testdata/defaultstack-after.txt, for
+// benchstat.
+func BenchmarkDefaultStack(b *testing.B) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s, r := defaultStack(b)
+	w := &discardWriter{header: http.Header{}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		clear(w.header)
+		s.ServeHTTP(w, r)
+	}
+}
+
+// TestDefaultStackAllocs guards the allocations of the default stack: at
+// most half of the 20 made before the pooled request state.
+func TestDefaultStackAllocs(t *testing.T) {
+	s, r := defaultStack(t)
+	w := &discardWriter{header: http.Header{}}
+	allocs := testing.AllocsPerRun(100, func() {
+		clear(w.header)
+		s.ServeHTTP(w, r)
+	})
+	if allocs > 10 {
+		t.Errorf("%.0f allocations per request, want 10 at most", allocs)
+	}
+}
+
+func TestCookieValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []string
+	}{
+		{"none", nil, nil},
+		{"single", []string{"sid=abc"}, []string{"abc"}},
+		{"among others", []string{"theme=dark; sid=abc; lang=fr"}, []string{"abc"}},
+		{"several, in order", []string{"sid=v2~new; sid=old"}, []string{"v2~new", "old"}},
+		{"several lines", []string{"sid=first", "theme=dark; sid=second"}, []string{"first", "second"}},
+		{"quoted", []string{`sid="abc"`}, []string{"abc"}},
+		{"spaces", []string{"  sid=abc  ;theme=dark"}, []string{"abc"}},
+		{"invalid value", []string{`sid=a\b; sid=ok`}, []string{"ok"}},
+		{"name prefix", []string{"sidx=abc; xsid=def"}, nil},
+		{"empty value", []string{"sid="}, []string{""}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, header := range tt.headers {
+			r.Header.Add("Cookie", header)
+		}
+		var got []string
+		n := cookieValues(r, "sid", func(value string) {
+			got = append(got, value)
+		})
+		if !slices.Equal(got, tt.want) || n != len(tt.want) {
+			t.Errorf("%s: %q (%d), want %q", tt.name, got, n, tt.want)
+		}
+		var stdlib []string
+		for _, cookie := range r.CookiesNamed("sid") {
+			stdlib = append(stdlib, cookie.Value)
+		}
+		if !slices.Equal(got, stdlib) {
+			t.Errorf("%s: %q, net/http reads %q", tt.name, got, stdlib)
+		}
+	}
+}
+
+// TestGetSessionCreated reads the session of the first request of a client
+// from the handler: it is the one the server created for the request, with a
+// single cookie.
+func TestGetSessionCreated(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	var created sessions.Session
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		created, _ = s.GetSession(w, r)
+		created.Set("user", "alice")
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("%d session cookies set, want 1", len(cookies))
+	}
+	if id, _ := decodeSessionCookie(cookies[0].Value); id != created.Id() {
+		t.Errorf("cookie of the session %s, the handler read %s", id, created.Id())
+	}
+}
@@ -0,0 +1,136 @@
+package serverlib
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Morditux/serverlib/sse"
+)
+
+// DevReloadPath is the path of the server-sent events endpoint notifying the
+// browsers to reload, registered in the Development profile only.
+const DevReloadPath = "/_dev/reload"
+
+// devReloadDebounce is the delay without changes after which a burst of file
+// changes triggers a single reload.
+const devReloadDebounce = 100 * time.Millisecond
+
+// devReloadKeepAlive is the interval of the keep-alive comments of the reload stream.
+const devReloadKeepAlive = 30 * time.Second
+
+// devReloader broadcasts reload events to the connected browsers.
+type devReloader struct {
+	mut      sync.Mutex
+	clients  map[chan struct{}]struct{}
+	debounce *debouncer
+	// done ends the streams when the server shuts down.
+	done <-chan struct{}
+}
+
+func newDevReloader(done <-chan struct{}) *devReloader {
+	d := &devReloader{clients: make(map[chan struct{}]struct{}), done: done}
+	d.debounce = newDebouncer(devReloadDebounce, d.broadcast)
+	return d
+}
+
+// notify schedules a reload event, bursts of notifications are coalesced.
+func (d *devReloader) notify() {
+	d.debounce.trigger()
+}
+
+func (d *devReloader) broadcast() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	for client := range d.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+			// A reload is already pending for this client.
+		}
+	}
+}
+
+func (d *devReloader) subscribe() (chan struct{}, func()) {
+	client := make(chan struct{}, 1)
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.clients[client] = struct{}{}
+	return client, func() {
+		d.mut.Lock()
+		defer d.mut.Unlock()
+		delete(d.clients, client)
+	}
+}
+
+// ServeHTTP streams the reload events to a browser.
+func (d *devReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stream, err := sse.NewSSEStream(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client, unsubscribe := d.subscribe()
+	defer unsubscribe()
+	keepAlive := time.NewTicker(devReloadKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case <-d.done:
+			return
+		case <-client:
+			if err := stream.Send(sse.Event{Event: "reload", Data: "reload"}); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := stream.Comment("keep-alive"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// DevReload notifies the connected browsers to reload the page. The template
+// watcher calls it on every change; custom watchers (assets, translations, ...)
+// can call it too. It does nothing outside of the Development profile.
+func (s *Server) DevReload() {
+	if s.devReload != nil {
+		s.devReload.notify()
+	}
+}
+
+// devReloadScript returns the script subscribing the browser to the reload
+// events, or nothing outside of the Development profile. It is available to the
+// templates as the devReloadScript function.
+func (s *Server) devReloadScript() template.HTML {
+	if s.devReload == nil {
+		return ""
+	}
+	url := template.JSEscapeString(s.Path(DevReloadPath))
+	return template.HTML(`<script>new EventSource("` + url + `").addEventListener("reload", function () { location.reload(); });</script>`)
+}
+
+// debouncer calls fn once no trigger happened for delay.
+type debouncer struct {
+	mut   sync.Mutex
+	delay time.Duration
+	timer *time.Timer
+	fn    func()
+}
+
+func newDebouncer(delay time.Duration, fn func()) *debouncer {
+	return &debouncer{delay: delay, fn: fn}
+}
+
+func (d *debouncer) trigger() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.delay, d.fn)
+		return
+	}
+	d.timer.Reset(d.delay)
+}
@@ -0,0 +1,156 @@
+package serverlib
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	tests := []struct {
+		name string
+		// gaps are the delays before each trigger.
+		gaps []time.Duration
+		want int32
+	}{
+		{"single", []time.Duration{0}, 1},
+		{"burst", []time.Duration{0, time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond}, 1},
+		{"separate", []time.Duration{0, 5 * delay}, 2},
+		{"burst then single", []time.Duration{0, 0, 0, 5 * delay}, 2},
+	}
+	for _, tt := range tests {
+		var calls atomic.Int32
+		d := newDebouncer(delay, func() { calls.Add(1) })
+		for _, gap := range tt.gaps {
+			time.Sleep(gap)
+			d.trigger()
+		}
+		time.Sleep(5 * delay)
+		if got := calls.Load(); got != tt.want {
+			t.Errorf("%s: %d calls, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDevReloadProfile(t *testing.T) {
+	tests := []struct {
+		profile    Profile
+		wantRoute  int
+		wantScript string
+	}{
+		{Production, http.StatusNotFound, "<p></p>"},
+		{Development, http.StatusOK, `<p><script>new EventSource("/_dev/reload")`},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{Profile: tt.profile})
+		s.AddTemplateFS(fstest.MapFS{"page.html": {Data: []byte("<p>{{devReloadScript}}</p>")}}, "*")
+		if err := s.Templates().Parse(); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.Render(w, "page.html", nil)
+		if got := w.Body.String(); !strings.HasPrefix(got, tt.wantScript) {
+			t.Errorf("%s: page %q, want %q", tt.profile, got, tt.wantScript)
+		}
+		registered := false
+		for _, route := range s.Routes() {
+			registered = registered || strings.HasSuffix(route.Pattern, DevReloadPath)
+		}
+		if registered != (tt.wantRoute == http.StatusOK) {
+			t.Errorf("%s: reload route registered %v", tt.profile, registered)
+		}
+		// DevReload is a no-op without the endpoint.
+		s.DevReload()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		r := httptest.NewRequest(http.MethodGet, DevReloadPath, nil).WithContext(ctx)
+		w = httptest.NewRecorder()
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+			s.ServeHTTP(w, r)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		<-served
+		if w.Code != tt.wantRoute {
+			t.Errorf("%s: GET %s = %d, want %d", tt.profile, DevReloadPath, w.Code, tt.wantRoute)
+		}
+	}
+}
+
+// TestDevReloadStream edits a template of a Development server: the watcher
+// reparses it and the connected browser gets a single reload event for the
+// burst of changes.
+func TestDevReloadStream(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(page, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", Profile: Development})
+	s.AddTemplateSource(dir)
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+
+	resp, err := http.Get("http://" + s.Addr() + DevReloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type %q", got)
+	}
+	events := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if event, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+				events <- event
+			}
+		}
+	}()
+
+	next := func(what string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event != "reload" {
+				t.Errorf("%s: event %q, want reload", what, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no reload event", what)
+		}
+		select {
+		case event := <-events:
+			t.Errorf("%s: second event %q", what, event)
+		case <-time.After(3 * devReloadDebounce):
+		}
+	}
+	for range 5 {
+		s.DevReload()
+	}
+	next("burst of DevReload")
+	if err := os.WriteFile(page, []byte("v2, a longer version"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next("template change")
+	w := httptest.NewRecorder()
+	s.Render(w, "page.html", nil)
+	if got := w.Body.String(); got != "v2, a longer version" {
+		t.Errorf("page %q after the reload", got)
+	}
+}
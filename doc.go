@@ -0,0 +1,20 @@
+// Package serverlib is a small library to quickly create an HTTP server with
+// sessions and templates.
+//
+// # Panics
+//
+// The library reports failures as errors. The few remaining panics are
+// programmer errors detected when the server is set up, before it serves:
+//
+//   - Handle, HandleFunc, HandleTyped and the method helpers such as GET
+//     panic, like http.ServeMux, on an invalid pattern or a pattern
+//     conflicting with a registered one, and Host on an invalid host pattern.
+//   - Route.Name panics on a name given to another route already.
+//   - BindQuery panics when not given a pointer to a struct, like the
+//     misuses of reflect.
+//   - The panics of the handlers are recovered and answered with a 500, except
+//     http.ErrAbortHandler which is re-panicked to abort the response.
+//
+// Without a server, the Log helpers do nothing, GetSession returns no session
+// and the central error handler answers with DefaultErrorHandler.
+package serverlib
@@ -0,0 +1,67 @@
+package serverlib
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Drain puts the server in the draining state ahead of a rolling deploy:
+// the server keeps serving, but Draining reports true so that the readiness
+// checks fail and the load balancer pulls the instance, the keep-alives are
+// disabled, the responses closing their connection, and the idle connections
+// are closed, so that the clients move to the other instances. The requests
+// in progress complete as usual. Undrain reverts it.
+func (s *Server) Drain() {
+	if s.draining.Swap(true) {
+		return
+	}
+	slog.Info("Server draining", "address", s.Addr())
+	s.httpServer.Load().SetKeepAlivesEnabled(false)
+}
+
+// Undrain takes the server out of the draining state, e.g. when the deploy is
+// rolled back.
+func (s *Server) Undrain() {
+	if !s.draining.Swap(false) {
+		return
+	}
+	slog.Info("Server undrained", "address", s.Addr())
+	s.httpServer.Load().SetKeepAlivesEnabled(!s.keepAlivesOff.Load())
+}
+
+// SetKeepAlivesEnabled enables or disables the HTTP keep-alives, see
+// http.Server.SetKeepAlivesEnabled: once disabled, the responses close their
+// connection and the idle connections are closed, e.g. for the clients to
+// move to the other instances. Enabled by default; Drain disables them too,
+// and Undrain restores this setting. It is kept across the restarts.
+//
+// Parameters:
+//   - enabled: Whether the connections are kept alive.
+func (s *Server) SetKeepAlivesEnabled(enabled bool) {
+	s.keepAlivesOff.Store(!enabled)
+	s.httpServer.Load().SetKeepAlivesEnabled(enabled && !s.draining.Load())
+}
+
+// Draining reports whether the server is draining, see Drain. It is true from
+// the start of Shutdown.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// drainFor drains the server and waits for ServerConfig.DrainGracePeriod,
+// bounded by ctx, so that the load balancer stops sending requests before the
+// listeners are closed.
+func (s *Server) drainFor(ctx context.Context) {
+	s.Drain()
+	grace := s.config.DrainGracePeriod
+	if grace <= 0 {
+		return
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,89 @@
+package serverlib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// keepAlive sends a request on conn and reports whether the server keeps
+// the connection alive after the response.
+func keepAlive(t *testing.T, conn net.Conn, reader *bufio.Reader) bool {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return !resp.Close
+}
+
+// TestSetKeepAlivesEnabled disables the keep-alives of a running server:
+// the responses close their connection, Undrain restores the setting
+// rather than enabling them, and the setting is kept across the restarts.
+func TestSetKeepAlivesEnabled(t *testing.T) {
+	s := newTestServer()
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	tests := []struct {
+		name   string
+		change func()
+		want   bool
+	}{
+		{"default", func() {}, true},
+		{"disabled", func() { s.SetKeepAlivesEnabled(false) }, false},
+		{"undrained", func() {
+			s.Drain()
+			s.Undrain()
+		}, false},
+		{"restarted", func() {
+			if err := s.Restart(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"enabled", func() { s.SetKeepAlivesEnabled(true) }, true},
+		{"draining", func() { s.Drain() }, false},
+		{"enabled while draining", func() { s.SetKeepAlivesEnabled(true) }, false},
+		{"undrained, enabled", func() { s.Undrain() }, true},
+	}
+	for _, tt := range tests {
+		tt.change()
+		conn, err := net.Dial("tcp", s.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keepAlive(t, conn, bufio.NewReader(conn)); got != tt.want {
+			t.Errorf("%s: kept alive %v, want %v", tt.name, got, tt.want)
+		}
+		conn.Close()
+	}
+
+	// Disabling them closes the idle connections.
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if !keepAlive(t, conn, reader) {
+		t.Fatal("connection not kept alive")
+	}
+	s.SetKeepAlivesEnabled(false)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("idle connection not closed: %v", err)
+	}
+}
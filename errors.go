@@ -0,0 +1,186 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Morditux/serverlib/i18n"
+)
+
+// FieldError describes why the value of a single field or parameter was rejected.
+// The errors of the built-in rules carry the key of their message in the
+// catalog of the server (see Server.Messages) and its parameters, so that
+// they can be translated (see Server.Localize) and recognized by the clients.
+type FieldError struct {
+	Field   string         `json:"field"`
+	Message string         `json:"message"`
+	Key     string         `json:"key,omitempty"`
+	Params  map[string]any `json:"params,omitempty"`
+	// Label is the name of the field for the users, the key or the text of
+	// its label struct tag, translated by Localize.
+	Label string `json:"label,omitempty"`
+}
+
+// params returns the parameters of the message, with the label of the field
+// as "field".
+func (e FieldError) params() map[string]any {
+	params := make(map[string]any, len(e.Params)+1)
+	for name, value := range e.Params {
+		params[name] = value
+	}
+	params["field"] = e.Field
+	if e.Label != "" {
+		params["field"] = e.Label
+	}
+	return params
+}
+
+// ValidationErrors collects every field error of a request so that a single
+// 400 response can name all of them.
+type ValidationErrors []FieldError
+
+// Error implements the error interface.
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// StatusCode returns the HTTP status code of a validation failure.
+func (e ValidationErrors) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// Add appends a field error with a literal message, which is not translated.
+func (e *ValidationErrors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// AddKey appends a field error whose message is the one of key in the
+// built-in catalog, as no server is known here. The message is set in the
+// fallback locale and translated with the catalog of the server answering
+// the error by Server.Localize and Server.Error.
+//
+// Parameters:
+//   - field: The name of the field.
+//   - key: The key of the message, e.g. "validation.required".
+//   - params: The values of its placeholders, e.g. {"min": 3}; the label of
+//     the field is added as "field".
+func (e *ValidationErrors) AddKey(field, key string, params map[string]any) {
+	e.addKey(builtinMessages, field, "", key, params)
+}
+
+// addKey appends a field error with its label, its message set from catalog.
+func (e *ValidationErrors) addKey(catalog *i18n.Catalog, field, label, key string, params map[string]any) {
+	fieldError := FieldError{Field: field, Key: key, Params: params, Label: label}
+	labeled := fieldError
+	labeled.Label = fieldLabel(catalog, catalog.Fallback(), field, label)
+	fieldError.Message = catalog.Translate(catalog.Fallback(), key, labeled.params())
+	*e = append(*e, fieldError)
+}
+
+// HTTPError is an error carrying the HTTP status code it should be answered with.
+type HTTPError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+// NewHTTPError creates an HTTPError with the given status code and message.
+// An empty message defaults to the status text.
+func NewHTTPError(status int, message string) *HTTPError {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &HTTPError{Status: status, Message: message}
+}
+
+// Error implements the error interface.
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// StatusCode returns the HTTP status code of the error.
+func (e *HTTPError) StatusCode() int {
+	return e.Status
+}
+
+// Unwrap returns the underlying error.
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// statusCoder is implemented by errors knowing their HTTP status code.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// ErrorStatus returns the HTTP status code for err: the code of the first error
+// in its chain implementing StatusCode() int, or 500.
+func ErrorStatus(err error) int {
+	var coder statusCoder
+	if errors.As(err, &coder) {
+		return coder.StatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
+// Error answers the request with the given error. This is the central error
+// handler of the server: it uses the ErrorHandler of the configuration when one
+// is set, DefaultErrorHandler otherwise. A nil server answers with
+// DefaultErrorHandler, for the middlewares used without a server.
+func (s *Server) Error(w http.ResponseWriter, r *http.Request, err error) {
+	recordRequestError(w, err)
+	if s != nil && s.errorHandler != nil {
+		s.errorHandler(w, r, err)
+		return
+	}
+	s.DefaultErrorHandler(w, r, err)
+}
+
+// DefaultErrorHandler answers with the status code of the error (see ErrorStatus).
+// Clients accepting JSON get an RFC 9457 problem document (see
+// ProblemForRequest), browsers the built-in error page (see
+// OverridableTemplates), the others plain text. The validation errors are
+// translated to the locale of the request, see Server.Localize. The message of server errors is not sent to the client,
+// only logged. Custom error handlers can delegate to it.
+func (s *Server) DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := ErrorStatus(err)
+	if status >= http.StatusInternalServerError {
+		s.LogError("Request failed", r.Method+" "+r.URL.Path+": "+err.Error())
+	}
+	setRetryAfter(w, err)
+	if acceptsJSON(r) {
+		s.ProblemForRequest(r, err).Write(w)
+		return
+	}
+	message := err.Error()
+	var validationErrors ValidationErrors
+	var httpError *HTTPError
+	switch {
+	case errors.As(err, &httpError):
+		message = httpError.Message
+	case errors.As(err, &validationErrors):
+		catalog, locale := s.Messages(), s.Locale(r)
+		title := catalog.Translate(locale, "validation.failed", nil)
+		message = validationErrors.localize(catalog, locale).message(title)
+	case status >= http.StatusInternalServerError:
+		message = http.StatusText(status)
+	}
+	if s.renderErrorPage(w, r, status, message) {
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// acceptsJSON reports whether the client asks for a JSON response.
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "/json") || strings.Contains(accept, "+json")
+}
@@ -0,0 +1,314 @@
+package serverlib
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// ExperimentCookieName is the cookie bucketing the visitors without a session
+// into the experiments, see ExperimentOptions.Persist.
+const ExperimentCookieName = "serverlib_experiments"
+
+// experimentCookieMaxAge is the lifetime of the experiment cookie.
+const experimentCookieMaxAge = 3600 * 24 * 365 // 1 year
+
+// experimentSessionPrefix prefixes the session keys of the assigned variants.
+const experimentSessionPrefix = "serverlib.experiment."
+
+// CookieSourceExperiment is the cookie of the experiment assignments of the
+// visitors without a session.
+const CookieSourceExperiment CookieSource = "experiment"
+
+// ExperimentVariant is a variant of an experiment. The visitors are assigned
+// the variants in proportion to their Weight; a variant with a zero weight is
+// not assigned anymore, the visitors already in it stay there.
+type ExperimentVariant struct {
+	Name   string
+	Weight int
+}
+
+// ExperimentOptions configures an experiment.
+type ExperimentOptions struct {
+	// Persist creates a session for the visitors without one, e.g. on the
+	// mounted handlers, instead of bucketing them with the ExperimentCookieName
+	// cookie.
+	Persist bool
+	// Exclude selects the requests kept out of the experiment, e.g.
+	// ExcludeBots: they get the control variant and nothing is stored.
+	Exclude func(r *http.Request) bool
+}
+
+// RunningExperiment is an experiment registered with Experiment.
+type RunningExperiment struct {
+	name     string
+	variants []ExperimentVariant
+	total    int
+	options  ExperimentOptions
+	killed   atomic.Bool
+}
+
+var (
+	experiments    = map[string]*RunningExperiment{}
+	experimentsMut = &sync.RWMutex{}
+	// hasExperiments avoids a lock per request until an experiment is registered.
+	hasExperiments atomic.Bool
+)
+
+// Experiment registers an A/B experiment, replacing the experiment of the same
+// name. The first variant is the control. A visitor gets the same variant on
+// every request: it is chosen by hashing the experiment name with the session
+// ID (or the ID of the experiment cookie), then stored in the session (or the
+// cookie) so that changing the weights never moves the visitors already
+// assigned. Read the variants with Variant or the experiment template function.
+//
+// Parameters:
+//   - name: The name of the experiment.
+//   - variants: The variants, the control first.
+//   - options: Optional variadic parameter of type ExperimentOptions.
+//
+// Returns:
+//   - *RunningExperiment: The experiment, to kill or resume it.
+func Experiment(name string, variants []ExperimentVariant, options ...ExperimentOptions) *RunningExperiment {
+	e := &RunningExperiment{name: name, variants: append([]ExperimentVariant(nil), variants...)}
+	if len(options) > 0 {
+		e.options = options[0]
+	}
+	for i := range e.variants {
+		e.variants[i].Weight = max(e.variants[i].Weight, 0)
+		e.total += e.variants[i].Weight
+	}
+	if e.total == 0 && len(e.variants) > 0 {
+		slog.Error("Experiment without weights, variants equally weighted", "name", name)
+		for i := range e.variants {
+			e.variants[i].Weight = 1
+		}
+		e.total = len(e.variants)
+	}
+	if len(e.variants) == 0 {
+		slog.Error("Experiment without variants", "name", name)
+	}
+	experimentsMut.Lock()
+	defer experimentsMut.Unlock()
+	experiments[name] = e
+	hasExperiments.Store(true)
+	slog.Info("Registred experiment", "name", name, "variants", len(e.variants))
+	return e
+}
+
+// lookupExperiment returns the registered experiment of the given name, or nil.
+func lookupExperiment(name string) *RunningExperiment {
+	experimentsMut.RLock()
+	defer experimentsMut.RUnlock()
+	return experiments[name]
+}
+
+// Name returns the name of the experiment.
+func (e *RunningExperiment) Name() string {
+	return e.name
+}
+
+// Kill is the kill switch of the experiment: every visitor gets the control
+// variant until Resume. The assignments are kept.
+func (e *RunningExperiment) Kill() {
+	e.killed.Store(true)
+	slog.Info("Experiment killed", "name", e.name)
+}
+
+// Resume ends Kill, the visitors get their variant again.
+func (e *RunningExperiment) Resume() {
+	e.killed.Store(false)
+	slog.Info("Experiment resumed", "name", e.name)
+}
+
+// Killed reports whether the experiment is killed.
+func (e *RunningExperiment) Killed() bool {
+	return e.killed.Load()
+}
+
+// control returns the name of the control variant.
+func (e *RunningExperiment) control() string {
+	if len(e.variants) == 0 {
+		return ""
+	}
+	return e.variants[0].Name
+}
+
+// has reports whether the experiment has the variant.
+func (e *RunningExperiment) has(variant string) bool {
+	for _, v := range e.variants {
+		if v.Name == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket returns the variant of the bucketing ID, from the weights.
+func (e *RunningExperiment) bucket(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(e.name))
+	h.Write([]byte{0})
+	h.Write([]byte(id))
+	// The low bit of FNV-1a is the parity of the low bits of the bytes: taken
+	// modulo an even total, every experiment would split the visitors the
+	// same way. The finalizer of MurmurHash3 mixes every bit into the others.
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	n := int(x % uint32(e.total))
+	for _, v := range e.variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return e.control()
+}
+
+// assign returns the variant of the request, assigning and storing it on the
+// first request of the visitor. w is nil when nothing can be written.
+func (e *RunningExperiment) assign(w http.ResponseWriter, r *http.Request, session sessions.Session, jar *experimentJar) string {
+	if len(e.variants) == 0 || e.killed.Load() || (e.options.Exclude != nil && e.options.Exclude(r)) {
+		return e.control()
+	}
+	if session == nil && e.options.Persist && w != nil {
+		if s := serverOf(r); s != nil {
+			session, _ = s.GetSession(w, r)
+		}
+	}
+	if session != nil {
+		key := experimentSessionPrefix + e.name
+		if stored, ok := session.Get(key).(string); ok && e.has(stored) {
+			return stored
+		}
+		variant := e.bucket(session.Id())
+		session.Set(key, variant)
+		e.logAssigned(r.Context(), variant)
+		return variant
+	}
+	if w == nil {
+		return e.control()
+	}
+	values := jar.load(r)
+	if stored := values.Get(e.name); e.has(stored) {
+		return stored
+	}
+	id := values.Get("id")
+	if id == "" {
+		id = newRequestID()
+		values.Set("id", id)
+	}
+	variant := e.bucket(id)
+	values.Set(e.name, variant)
+	jar.save(w, r)
+	e.logAssigned(r.Context(), variant)
+	return variant
+}
+
+func (e *RunningExperiment) logAssigned(ctx context.Context, variant string) {
+	reqctx.Logger(ctx).Info("Experiment assigned", "experiment", e.name, "variant", variant)
+}
+
+// experimentJar holds the experiment cookie of a request.
+type experimentJar struct {
+	values url.Values
+}
+
+func (j *experimentJar) load(r *http.Request) url.Values {
+	if j.values == nil {
+		j.values = url.Values{}
+		if cookie, err := r.Cookie(ExperimentCookieName); err == nil {
+			if values, err := url.ParseQuery(cookie.Value); err == nil {
+				j.values = values
+			}
+		}
+	}
+	return j.values
+}
+
+// save writes the cookie; it is lost when the headers were already sent.
+func (j *experimentJar) save(w http.ResponseWriter, r *http.Request) {
+	cookie := &http.Cookie{
+		Name:     ExperimentCookieName,
+		Value:    j.values.Encode(),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   experimentCookieMaxAge,
+	}
+	s := serverOf(r)
+	if s == nil {
+		http.SetCookie(w, cookie)
+		return
+	}
+	if s.basePath != "" {
+		cookie.Path = s.basePath
+	}
+	cookie.Secure = s.cookie.Secure
+	s.setCookie(w, cookie, CookieSourceExperiment, CookiePriorityLow)
+}
+
+// withExperiments returns a copy of the request context assigning the
+// variants of the experiments read by the request, once per experiment.
+func withExperiments(w http.ResponseWriter, r *http.Request, session sessions.Session) context.Context {
+	jar := &experimentJar{}
+	return reqctx.WithExperiments(r.Context(), func(name string) string {
+		e := lookupExperiment(name)
+		if e == nil {
+			return ""
+		}
+		return e.assign(w, r, session, jar)
+	})
+}
+
+// Variant returns the variant of the experiment assigned to the request, the
+// control when the experiment is killed or the request excluded, or an empty
+// string for an unknown experiment. The variants read by a request are
+// reported by reqctx.Experiments, to label the logs and the metrics.
+func Variant(r *http.Request, name string) string {
+	if variant, ok := reqctx.Experiment(r.Context(), name); ok {
+		return variant
+	}
+	// Not served by the server: nothing can be stored.
+	e := lookupExperiment(name)
+	if e == nil {
+		return ""
+	}
+	return e.assign(nil, r, reqctx.Session(r.Context()), nil)
+}
+
+// experimentVariant is the experiment template function:
+// {{if eq (experiment .Request "checkout") "one-page"}}.
+func experimentVariant(r *http.Request, name string) string {
+	return Variant(r, name)
+}
+
+// botAgents are the User-Agent fragments of the crawlers and the tools.
+var botAgents = []string{"bot", "crawler", "spider", "slurp", "headless", "curl", "wget", "python-requests", "lighthouse"}
+
+// ExcludeBots is an ExperimentOptions.Exclude predicate keeping the crawlers,
+// identified by their User-Agent, out of the experiments.
+func ExcludeBots(r *http.Request) bool {
+	agent := strings.ToLower(r.UserAgent())
+	if agent == "" {
+		return true
+	}
+	for _, bot := range botAgents {
+		if strings.Contains(agent, bot) {
+			return true
+		}
+	}
+	return false
+}
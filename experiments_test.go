@@ -0,0 +1,194 @@
+package serverlib
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// TestExperimentBucket checks a bucketing ID gets the same variant on every
+// call and from a new registration, and the IDs are spread by the weights.
+func TestExperimentBucket(t *testing.T) {
+	const ids = 20000
+	tests := []struct {
+		name     string
+		variants []ExperimentVariant
+		// want are the expected shares of the variants.
+		want map[string]float64
+	}{
+		{"even", []ExperimentVariant{{"control", 1}, {"treatment", 1}}, map[string]float64{"control": 0.5, "treatment": 0.5}},
+		{"weighted", []ExperimentVariant{{"control", 1}, {"a", 1}, {"b", 2}}, map[string]float64{"control": 0.25, "a": 0.25, "b": 0.5}},
+		{"zero weight", []ExperimentVariant{{"control", 9}, {"closed", 0}, {"new", 1}}, map[string]float64{"control": 0.9, "new": 0.1}},
+		{"negative weight", []ExperimentVariant{{"control", 1}, {"closed", -5}}, map[string]float64{"control": 1}},
+		{"no weights", []ExperimentVariant{{"control", 0}, {"treatment", 0}}, map[string]float64{"control": 0.5, "treatment": 0.5}},
+	}
+	for _, tt := range tests {
+		e := Experiment("test-bucket-"+tt.name, tt.variants)
+		again := Experiment("test-bucket-"+tt.name, tt.variants)
+		counts := map[string]int{}
+		for i := range ids {
+			id := "session-" + strconv.Itoa(i)
+			variant := e.bucket(id)
+			if e.bucket(id) != variant || again.bucket(id) != variant {
+				t.Errorf("%s: %s moved from %s", tt.name, id, variant)
+			}
+			counts[variant]++
+		}
+		for variant, n := range counts {
+			if _, ok := tt.want[variant]; !ok {
+				t.Errorf("%s: %d IDs in %s", tt.name, n, variant)
+			}
+		}
+		for variant, share := range tt.want {
+			if got := float64(counts[variant]) / ids; math.Abs(got-share) > 0.02 {
+				t.Errorf("%s: %.3f of the IDs in %s, want %.2f", tt.name, got, variant, share)
+			}
+		}
+	}
+	// The name of the experiment is hashed with the ID: the experiments are
+	// not all assigned the same way.
+	first := Experiment("test-bucket-first", []ExperimentVariant{{"control", 1}, {"treatment", 1}})
+	second := Experiment("test-bucket-second", []ExperimentVariant{{"control", 1}, {"treatment", 1}})
+	same := 0
+	for i := range 1000 {
+		id := "session-" + strconv.Itoa(i)
+		if first.bucket(id) == second.bucket(id) {
+			same++
+		}
+	}
+	if same < 400 || same > 600 {
+		t.Errorf("%d of 1000 IDs in the same variant of both experiments", same)
+	}
+}
+
+// variantServer returns a server answering with the variant of the experiment,
+// on a route and on a handler mounted without the middlewares, the requests
+// of which have no session.
+func variantServer(experiment string) *Server {
+	s := NewServer(ServerConfig{})
+	variant := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Variant(r, experiment)))
+	}
+	s.GET("/page", variant)
+	s.MountHandler("/mounted", http.HandlerFunc(variant), WithoutMiddleware())
+	return s
+}
+
+// TestExperimentControl checks the killed, excluded and empty experiments
+// always give the control, to the assigned visitors too, and an unknown
+// experiment nothing.
+func TestExperimentControl(t *testing.T) {
+	treatment := []ExperimentVariant{{"control", 0}, {"treatment", 1}}
+	tests := []struct {
+		name   string
+		setup  func(name string)
+		target string
+		agent  string
+		want   string
+	}{
+		{"running", func(name string) { Experiment(name, treatment) }, "/page", "Mozilla/5.0", "treatment"},
+		{"running, mounted", func(name string) { Experiment(name, treatment) }, "/mounted", "Mozilla/5.0", "treatment"},
+		{"killed", func(name string) { Experiment(name, treatment).Kill() }, "/page", "Mozilla/5.0", "control"},
+		{"killed, mounted", func(name string) { Experiment(name, treatment).Kill() }, "/mounted", "Mozilla/5.0", "control"},
+		{"resumed", func(name string) {
+			e := Experiment(name, treatment)
+			e.Kill()
+			e.Resume()
+		}, "/page", "Mozilla/5.0", "treatment"},
+		{"excluded", func(name string) { Experiment(name, treatment, ExperimentOptions{Exclude: ExcludeBots}) }, "/page", "Googlebot/2.1", "control"},
+		{"excluded, no agent", func(name string) { Experiment(name, treatment, ExperimentOptions{Exclude: ExcludeBots}) }, "/page", "", "control"},
+		{"not excluded", func(name string) { Experiment(name, treatment, ExperimentOptions{Exclude: ExcludeBots}) }, "/page", "Mozilla/5.0", "treatment"},
+		{"without variants", func(name string) { Experiment(name, nil) }, "/page", "Mozilla/5.0", ""},
+		{"unknown", func(string) {}, "/page", "Mozilla/5.0", ""},
+	}
+	for _, tt := range tests {
+		name := "test-control-" + tt.name
+		tt.setup(name)
+		s := variantServer(name)
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Header.Set("User-Agent", tt.agent)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A visitor assigned the treatment gets the control while the experiment
+	// is killed, and the treatment back once resumed.
+	e := Experiment("test-control-assigned", treatment)
+	s := variantServer(e.Name())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	cookies := w.Result().Cookies()
+	for _, step := range []struct {
+		kill bool
+		want string
+	}{{true, "control"}, {false, "treatment"}} {
+		if step.kill {
+			e.Kill()
+		} else {
+			e.Resume()
+		}
+		r := httptest.NewRequest(http.MethodGet, "/page", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := w.Body.String(); got != step.want || e.Killed() != step.kill {
+			t.Errorf("killed %v: %q, want %q", step.kill, got, step.want)
+		}
+	}
+}
+
+// TestExperimentAssignment checks the variant assigned is kept in the session,
+// or the experiment cookie on a mounted handler, when the weights change.
+func TestExperimentAssignment(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		// session tells whether the variant is kept by the session cookie.
+		session bool
+	}{
+		{"session", "/page", true},
+		{"experiment cookie", "/mounted", false},
+	}
+	for _, tt := range tests {
+		name := "test-assignment-" + tt.name
+		Experiment(name, []ExperimentVariant{{"control", 0}, {"treatment", 1}})
+		s := variantServer(name)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		cookieName := ExperimentCookieName
+		if tt.session {
+			cookieName = s.SessionKey()
+		}
+		var kept *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == cookieName {
+				kept = cookie
+			}
+		}
+		if w.Body.String() != "treatment" || kept == nil {
+			t.Errorf("%s: %q assigned, cookie %v", tt.name, w.Body.String(), kept)
+			continue
+		}
+		// The treatment is closed to the new visitors only.
+		Experiment(name, []ExperimentVariant{{"control", 1}, {"treatment", 0}})
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.AddCookie(kept)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := w.Body.String(); got != "treatment" {
+			t.Errorf("%s: assigned visitor moved to %q", tt.name, got)
+		}
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if got := w.Body.String(); got != "control" {
+			t.Errorf("%s: new visitor assigned %q", tt.name, got)
+		}
+	}
+}
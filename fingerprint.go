@@ -0,0 +1,197 @@
+package serverlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// fingerprintVersion is the version of the fingerprint format.
+const fingerprintVersion = 1
+
+// fingerprintConfig are the configuration fields of the fingerprint. The
+// others are left out: they hold secrets, random defaults or functions.
+var fingerprintConfig = []string{
+	"Address", "ReadTimeout", "ReadHeaderTimeout", "WriteTimeout", "IdleTimeout",
+	"MaxHeaderBytes", "SessionCookie", "BasePath", "StripBasePath", "Profile",
+	"ProblemTypeBase", "ConcurrencyLimit", "ResponseCache", "MissingKeys",
+	"ShutdownTimeout", "SocketMode",
+}
+
+// fingerprint is the canonical description of the server. Maps are
+// serialized with sorted keys and every slice is sorted, so that two servers
+// configured alike have the same serialization.
+type fingerprint struct {
+	Version     int                 `json:"version"`
+	Routes      map[string]Security `json:"routes"`
+	Mounts      []string            `json:"mounts"`
+	Middlewares []string            `json:"middlewares"`
+	Config      map[string]any      `json:"config"`
+}
+
+// ChangeDescription is a difference between two fingerprints.
+type ChangeDescription struct {
+	// Kind is "added", "removed" or "changed".
+	Kind string
+	// Path names what changed, e.g. "route GET /users" or "config.ReadTimeout".
+	Path string
+	Old  string
+	New  string
+}
+
+// String returns the human-readable form of the change.
+func (c ChangeDescription) String() string {
+	switch c.Kind {
+	case "added":
+		return fmt.Sprintf("added %s: %s", c.Path, c.New)
+	case "removed":
+		return fmt.Sprintf("removed %s: %s", c.Path, c.Old)
+	}
+	return fmt.Sprintf("changed %s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// ConfigFingerprint returns a deterministic serialization of the routes with
+// their security requirements, the mounted handlers, the request pipeline and
+// the configuration values shaping the behavior of the server (timeouts,
+// cookie attributes, profile, limits), and its SHA-256 hash. Secrets and
+// functions are left out. Comparing the fingerprints of two releases, e.g.
+// in CI, detects the accidental route and configuration changes (see
+// DiffFingerprint).
+//
+// Returns:
+//   - string: The hex encoded hash of the serialization.
+//   - []byte: The serialization, indented JSON.
+func (s *Server) ConfigFingerprint() (string, []byte) {
+	data, _ := json.MarshalIndent(s.fingerprint(), "", "  ")
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), data
+}
+
+func (s *Server) fingerprint() fingerprint {
+	f := fingerprint{
+		Version: fingerprintVersion,
+		Routes:  make(map[string]Security),
+		Mounts:  []string{},
+		Config:  make(map[string]any),
+	}
+	for _, route := range s.Routes() {
+		security := route.Security
+		security.Schemes = sorted(security.Schemes)
+		security.Roles = sorted(security.Roles)
+		security.RateLimits = sorted(security.RateLimits)
+		f.Routes[route.Pattern] = security
+	}
+	s.injector.mut.RLock()
+	for _, m := range s.injector.mounts {
+		f.Mounts = append(f.Mounts, m.prefix)
+	}
+	s.injector.mut.RUnlock()
+	sort.Strings(f.Mounts)
+	f.Middlewares = s.pipeline()
+	config := reflect.ValueOf(s.config)
+	for _, name := range fingerprintConfig {
+		if field := config.FieldByName(name); field.IsValid() {
+			f.Config[name] = redactValue(field)
+		}
+	}
+	return f
+}
+
+// pipeline returns the names of the stages serving a request, in order.
+func (s *Server) pipeline() []string {
+	stages := []string{"request-id", "recover"}
+	if s.injector.stripped != nil {
+		stages = append([]string{"strip-base-path"}, stages...)
+	}
+	if s.injector.limiter != nil {
+		stages = append(stages, "concurrency-limit")
+	}
+	stages = append(stages, "mounts", "session")
+	if s.injector.flags != nil {
+		stages = append(stages, "feature-flags")
+	}
+	return append(stages, "router")
+}
+
+// sorted returns a sorted copy of values, nil when empty: an empty list
+// serializes alike whether the route declared it or not.
+func sorted(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	values = slices.Clone(values)
+	sort.Strings(values)
+	return values
+}
+
+// DiffFingerprint compares the fingerprint of the server with an older
+// serialization returned by ConfigFingerprint and describes the differences,
+// sorted by path. It returns nil when they match, and a single change when
+// old can't be decoded.
+func (s *Server) DiffFingerprint(old []byte) []ChangeDescription {
+	var previous fingerprint
+	if err := json.Unmarshal(old, &previous); err != nil {
+		return []ChangeDescription{{Kind: "changed", Path: "fingerprint", Old: "invalid: " + err.Error(), New: "version " + fmt.Sprint(fingerprintVersion)}}
+	}
+	// The current fingerprint goes through JSON too, so that both sides
+	// compare the same representation.
+	_, data := s.ConfigFingerprint()
+	var current fingerprint
+	json.Unmarshal(data, &current)
+	before, after := previous.flatten(), current.flatten()
+	paths := make([]string, 0, len(before)+len(after))
+	for path := range before {
+		paths = append(paths, path)
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	var changes []ChangeDescription
+	for _, path := range paths {
+		oldValue, inOld := before[path]
+		newValue, inNew := after[path]
+		switch {
+		case !inOld:
+			changes = append(changes, ChangeDescription{Kind: "added", Path: path, New: newValue})
+		case !inNew:
+			changes = append(changes, ChangeDescription{Kind: "removed", Path: path, Old: oldValue})
+		case oldValue != newValue:
+			changes = append(changes, ChangeDescription{Kind: "changed", Path: path, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+// flatten returns the leaves of the fingerprint by path.
+func (f fingerprint) flatten() map[string]string {
+	leaves := map[string]string{"version": fmt.Sprint(f.Version)}
+	for pattern, security := range f.Routes {
+		data, _ := json.Marshal(security)
+		leaves["route "+pattern] = string(data)
+	}
+	for _, prefix := range f.Mounts {
+		leaves["mount "+prefix] = prefix
+	}
+	leaves["middlewares"] = strings.Join(f.Middlewares, ", ")
+	flattenValue(leaves, "config", f.Config)
+	return leaves
+}
+
+func flattenValue(leaves map[string]string, path string, value any) {
+	if object, ok := value.(map[string]any); ok {
+		for name, field := range object {
+			flattenValue(leaves, path+"."+name, field)
+		}
+		return
+	}
+	data, _ := json.Marshal(value)
+	leaves[path] = string(data)
+}
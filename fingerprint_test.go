@@ -0,0 +1,133 @@
+package serverlib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fingerprintServer returns a server with the routes of a small API,
+// registered in order or in reverse, changed by the options of the test.
+func fingerprintServer(config ServerConfig, reverse bool, register ...func(*Server)) *Server {
+	s := NewServer(config)
+	noop := func(http.ResponseWriter, *http.Request) {}
+	routes := []func(){
+		func() { s.GET("/users", noop) },
+		func() { s.POST("/users", noop, RequireRole("admin", "editor")) },
+		func() { s.DELETE("/users/{id}", noop, RequireAuth()) },
+		func() { s.MountHandler("/static/", http.NotFoundHandler()) },
+		func() { s.MountHandler("/assets/", http.NotFoundHandler()) },
+	}
+	if reverse {
+		for i := len(routes) - 1; i >= 0; i-- {
+			routes[i]()
+		}
+	} else {
+		for _, route := range routes {
+			route()
+		}
+	}
+	for _, r := range register {
+		r(s)
+	}
+	return s
+}
+
+// TestConfigFingerprintDeterministic checks two servers configured alike, in
+// a different order and with random secrets, have the same fingerprint.
+func TestConfigFingerprintDeterministic(t *testing.T) {
+	config := ServerConfig{Address: "127.0.0.1:0", ReadTimeout: 5 * time.Second}
+	noop := func(http.ResponseWriter, *http.Request) {}
+	tests := []struct {
+		name string
+		a, b *Server
+	}{
+		{"same server", fingerprintServer(config, false), fingerprintServer(config, false)},
+		{"reverse order", fingerprintServer(config, false), fingerprintServer(config, true)},
+		{"roles in another order",
+			fingerprintServer(config, false, func(s *Server) { s.PUT("/roles", noop, RequireRole("b", "a")) }),
+			fingerprintServer(config, false, func(s *Server) { s.PUT("/roles", noop, RequireRole("a", "b")) })},
+		{"empty roles",
+			fingerprintServer(config, false, func(s *Server) {
+				s.PUT("/roles", noop, func(next http.Handler) http.Handler {
+					return Secured(next, next, Security{Roles: []string{}, Schemes: []string{}})
+				})
+			}),
+			fingerprintServer(config, false, func(s *Server) { s.PUT("/roles", noop) })},
+		{"other session key",
+			fingerprintServer(ServerConfig{Address: "127.0.0.1:0", ReadTimeout: 5 * time.Second, SessionKey: "another secret"}, false),
+			fingerprintServer(config, false)},
+	}
+	for _, tt := range tests {
+		hashA, a := tt.a.ConfigFingerprint()
+		hashB, b := tt.b.ConfigFingerprint()
+		if hashA != hashB || string(a) != string(b) {
+			t.Errorf("%s: %s, want %s\n%s\nwant\n%s", tt.name, hashA, hashB, a, b)
+		}
+		if len(hashA) != 64 {
+			t.Errorf("%s: hash %q", tt.name, hashA)
+		}
+		if strings.Contains(string(a), "another secret") || strings.Contains(string(a), "SessionKey") {
+			t.Errorf("%s: the session key is in the fingerprint", tt.name)
+		}
+	}
+}
+
+// TestDiffFingerprint changes a route, a requirement, a mount or a
+// configuration value, and checks the changes described.
+func TestDiffFingerprint(t *testing.T) {
+	config := ServerConfig{Address: "127.0.0.1:0", ReadTimeout: 5 * time.Second}
+	_, old := fingerprintServer(config, false).ConfigFingerprint()
+	noop := func(http.ResponseWriter, *http.Request) {}
+	tests := []struct {
+		name     string
+		config   ServerConfig
+		register func(*Server)
+		want     []string
+	}{
+		{"unchanged", config, nil, nil},
+		{"route added", config, func(s *Server) { s.GET("/health", noop) },
+			[]string{`added route GET /health: {"Auth":false,"Schemes":null,"Roles":null,"CSRF":false,"RateLimits":null}`}},
+		{"timeout changed", ServerConfig{Address: "127.0.0.1:0", ReadTimeout: 10 * time.Second}, nil,
+			[]string{`changed config.ReadTimeout: "5s" -> "10s"`}},
+		{"cookie attribute and profile", ServerConfig{Address: "127.0.0.1:0", ReadTimeout: 5 * time.Second, SessionCookie: CookieConfig{CookieAttributes: CookieAttributes{Secure: true}}, Profile: Development}, nil,
+			[]string{`changed config.Profile: 0 -> 1`, `changed config.SessionCookie.CookieAttributes.Secure: false -> true`,
+				`added route GET /_dev/reload: {"Auth":false,"Schemes":null,"Roles":null,"CSRF":false,"RateLimits":null}`,
+				`added route GET /_dev/requests: {"Auth":false,"Schemes":null,"Roles":null,"CSRF":false,"RateLimits":null}`}},
+		{"mount added", config, func(s *Server) { s.MountHandler("/docs/", http.NotFoundHandler()) },
+			[]string{`added route /docs/: {"Auth":false,"Schemes":null,"Roles":null,"CSRF":false,"RateLimits":null}`}},
+		{"mount outside the middlewares", config, func(s *Server) { s.MountHandler("/raw/", http.NotFoundHandler(), WithoutMiddleware()) },
+			[]string{`added mount /raw: /raw`, `added route /raw/: {"Auth":false,"Schemes":null,"Roles":null,"CSRF":false,"RateLimits":null}`}},
+		{"role added", config, func(s *Server) { s.PATCH("/users/{id}", noop, RequireRole("admin")) },
+			[]string{`added route PATCH /users/{id}: {"Auth":true,"Schemes":null,"Roles":["admin"],"CSRF":false,"RateLimits":null}`}},
+	}
+	for _, tt := range tests {
+		var register []func(*Server)
+		if tt.register != nil {
+			register = append(register, tt.register)
+		}
+		var got []string
+		for _, change := range fingerprintServer(tt.config, true, register...).DiffFingerprint(old) {
+			got = append(got, change.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s:\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+func TestDiffFingerprintRemoved(t *testing.T) {
+	config := ServerConfig{Address: "127.0.0.1:0"}
+	_, old := fingerprintServer(config, false, func(s *Server) {
+		s.GET("/legacy", func(http.ResponseWriter, *http.Request) {})
+	}).ConfigFingerprint()
+	changes := fingerprintServer(config, false).DiffFingerprint(old)
+	if len(changes) != 1 || changes[0].Kind != "removed" || changes[0].Path != "route GET /legacy" {
+		t.Errorf("changes %v", changes)
+	}
+	changes = fingerprintServer(config, false).DiffFingerprint([]byte("not json"))
+	if len(changes) != 1 || changes[0].Path != "fingerprint" || !strings.HasPrefix(changes[0].Old, "invalid: ") {
+		t.Errorf("invalid fingerprint: %v", changes)
+	}
+}
@@ -0,0 +1,124 @@
+package serverlib
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// FlagProvider resolves the feature flags of a request, see ServerConfig.FlagProvider.
+type FlagProvider interface {
+	Flags(ctx context.Context, r *http.Request, session sessions.Session) map[string]bool
+}
+
+// StaticFlags is a FlagProvider giving the same flags to every request.
+type StaticFlags map[string]bool
+
+// Flags implements FlagProvider.
+func (f StaticFlags) Flags(ctx context.Context, r *http.Request, session sessions.Session) map[string]bool {
+	return f
+}
+
+// PercentageRollout is a FlagProvider enabling each flag for a percentage of
+// the sessions, e.g. {"new-checkout": 10}. A session gets the same flags on
+// every request: the decision hashes the flag name with the session ID.
+type PercentageRollout map[string]int
+
+// Flags implements FlagProvider.
+func (p PercentageRollout) Flags(ctx context.Context, r *http.Request, session sessions.Session) map[string]bool {
+	flags := make(map[string]bool, len(p))
+	for name, percent := range p {
+		flags[name] = session != nil && rolloutBucket(name, session.Id()) < percent
+	}
+	return flags
+}
+
+// rolloutBucket returns the bucket, from 0 to 99, of a session for a flag.
+func rolloutBucket(flag, sessionID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(sessionID))
+	return int(h.Sum32() % 100)
+}
+
+// PrincipalAllowlist is a FlagProvider enabling each flag for the listed
+// principals only (see reqctx.Principal), e.g. {"beta": {"alice", "bob"}}.
+type PrincipalAllowlist map[string][]string
+
+// Flags implements FlagProvider.
+func (a PrincipalAllowlist) Flags(ctx context.Context, r *http.Request, session sessions.Session) map[string]bool {
+	principal, ok := reqctx.Principal(ctx)
+	flags := make(map[string]bool, len(a))
+	for name, principals := range a {
+		flags[name] = ok && slices.Contains(principals, principal)
+	}
+	return flags
+}
+
+// CombinedFlags is a FlagProvider merging the flags of several providers;
+// for a flag given by several providers, the last one wins.
+type CombinedFlags []FlagProvider
+
+// Flags implements FlagProvider.
+func (c CombinedFlags) Flags(ctx context.Context, r *http.Request, session sessions.Session) map[string]bool {
+	flags := map[string]bool{}
+	for _, provider := range c {
+		for name, enabled := range provider.Flags(ctx, r, session) {
+			flags[name] = enabled
+		}
+	}
+	return flags
+}
+
+// withFlags returns a copy of the request context resolving its flags with
+// the provider, at most once and only when a flag is read: the provider gets
+// the context of the first read, e.g. with the principal set by the
+// middlewares of the route.
+func withFlags(r *http.Request, provider FlagProvider, session sessions.Session) context.Context {
+	return reqctx.WithFlags(r.Context(), func(ctx context.Context) map[string]bool {
+		flags := provider.Flags(ctx, r.WithContext(ctx), session)
+		if logger := reqctx.Logger(ctx); logger.Enabled(ctx, slog.LevelDebug) {
+			logger.Debug("Feature flags resolved", "flags", formatFlags(flags))
+		}
+		return flags
+	})
+}
+
+// formatFlags formats the flags as "name=true name=false", sorted by name.
+func formatFlags(flags map[string]bool) string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%t", name, flags[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// FlagEnabled reports whether the feature flag is enabled for the request of ctx.
+// The flags are resolved by the FlagProvider of the configuration once per
+// request, on the first call. Unknown flags are disabled.
+func FlagEnabled(ctx context.Context, name string) bool {
+	return reqctx.Flags(ctx)[name]
+}
+
+// featureFlag is the feature template function: {{if feature .Request "name"}}.
+// The flags are disabled for a template rendered without a request.
+func featureFlag(r *http.Request, name string) bool {
+	if r == nil {
+		return false
+	}
+	return FlagEnabled(r.Context(), name)
+}
@@ -0,0 +1,197 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+func TestFlagProviders(t *testing.T) {
+	// A session in the first quarter of the rollout buckets of the flag, and
+	// one in the second half, see rolloutBucket.
+	var early, late string
+	for i := 0; early == "" || late == ""; i++ {
+		id := "session-" + strconv.Itoa(i)
+		switch bucket := rolloutBucket("checkout", id); {
+		case bucket < 25 && early == "":
+			early = id
+		case bucket >= 50 && late == "":
+			late = id
+		}
+	}
+	alice := reqctx.WithPrincipal(context.Background(), "alice")
+	mallory := reqctx.WithPrincipal(context.Background(), "mallory")
+	tests := []struct {
+		name     string
+		provider FlagProvider
+		ctx      context.Context
+		session  sessions.Session
+		want     map[string]bool
+	}{
+		{"static", StaticFlags{"beta": true, "dark": false}, context.Background(), nil, map[string]bool{"beta": true, "dark": false}},
+		{"rollout in", PercentageRollout{"checkout": 25}, context.Background(), sessions.NewMemorySession(early), map[string]bool{"checkout": true}},
+		{"rollout out", PercentageRollout{"checkout": 25}, context.Background(), sessions.NewMemorySession(late), map[string]bool{"checkout": false}},
+		{"rollout to everyone", PercentageRollout{"checkout": 100}, context.Background(), sessions.NewMemorySession(late), map[string]bool{"checkout": true}},
+		{"rollout to nobody", PercentageRollout{"checkout": 0}, context.Background(), sessions.NewMemorySession(early), map[string]bool{"checkout": false}},
+		{"rollout without session", PercentageRollout{"checkout": 100}, context.Background(), nil, map[string]bool{"checkout": false}},
+		{"allowlisted", PrincipalAllowlist{"beta": {"alice", "bob"}, "ops": {"carol"}}, alice, nil, map[string]bool{"beta": true, "ops": false}},
+		{"not allowlisted", PrincipalAllowlist{"beta": {"alice", "bob"}}, mallory, nil, map[string]bool{"beta": false}},
+		{"anonymous", PrincipalAllowlist{"beta": {""}}, context.Background(), nil, map[string]bool{"beta": false}},
+		{"combined, last wins", CombinedFlags{StaticFlags{"beta": false, "dark": true}, PrincipalAllowlist{"beta": {"alice"}}}, alice, nil, map[string]bool{"beta": true, "dark": true}},
+		{"combined, none", CombinedFlags{}, alice, nil, map[string]bool{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tt.ctx)
+		if got := tt.provider.Flags(tt.ctx, r, tt.session); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestPercentageRolloutStable checks a session gets the same flags on every
+// request, and the rollout reaches about its percentage of the sessions.
+func TestPercentageRolloutStable(t *testing.T) {
+	const sessionCount = 2000
+	tests := []struct {
+		percent  int
+		min, max int
+	}{
+		{0, 0, 0},
+		{10, 140, 260},
+		{50, 900, 1100},
+		{100, sessionCount, sessionCount},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{FlagProvider: PercentageRollout{"checkout": tt.percent}})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strconv.FormatBool(FlagEnabled(r.Context(), "checkout"))))
+		})
+		enabled := 0
+		for i := range sessionCount {
+			var cookie *http.Cookie
+			var first string
+			for request := range 3 {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if cookie != nil {
+					r.AddCookie(cookie)
+				}
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+				if request == 0 {
+					cookie, first = w.Result().Cookies()[0], w.Body.String()
+				} else if got := w.Body.String(); got != first {
+					t.Fatalf("%d%%: session %d enabled %s, then %s", tt.percent, i, first, got)
+				}
+				// Only a few sessions are checked more than once.
+				if i > 50 {
+					break
+				}
+			}
+			if first == "true" {
+				enabled++
+			}
+		}
+		if enabled < tt.min || enabled > tt.max {
+			t.Errorf("%d%%: enabled for %d sessions out of %d, want %d to %d", tt.percent, enabled, sessionCount, tt.min, tt.max)
+		}
+	}
+}
+
+// countingFlags counts its calls.
+type countingFlags struct {
+	calls atomic.Int32
+}
+
+func (c *countingFlags) Flags(ctx context.Context, r *http.Request, session sessions.Session) map[string]bool {
+	c.calls.Add(1)
+	principal, _ := reqctx.Principal(ctx)
+	return map[string]bool{"beta": principal == "alice", "dark": true}
+}
+
+// TestFlagResolution checks the flags are resolved once per request, on the
+// first read, from handlers and templates, with the principal of the route,
+// and logged at Debug.
+func TestFlagResolution(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	provider := &countingFlags{}
+	s := NewServer(ServerConfig{FlagProvider: provider})
+	s.AddTemplateFS(fstest.MapFS{
+		"page.html": {Data: []byte(`{{if feature .Request "beta"}}beta{{else}}stable{{end}} {{feature .Request "dark"}} {{feature .Request "unknown"}}`)},
+	}, "*")
+	if err := s.Templates().Parse(); err != nil {
+		t.Fatal(err)
+	}
+	s.Use(identity)
+	s.GET("/page", func(w http.ResponseWriter, r *http.Request) {
+		FlagEnabled(r.Context(), "beta")
+		s.RenderRequest(w, r, "page.html", nil)
+	})
+	s.GET("/handler", func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"beta", "dark", "beta", "unknown"} {
+			w.Write([]byte(strconv.FormatBool(FlagEnabled(r.Context(), name)) + " "))
+		}
+	})
+	s.GET("/none", func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		path      string
+		user      string
+		want      string
+		wantCalls int32
+	}{
+		{"/page", "alice", "beta true false", 1},
+		{"/page", "bob", "stable true false", 1},
+		{"/handler", "alice", "true true true false ", 1},
+		{"/none", "alice", "", 0},
+	}
+	for _, tt := range tests {
+		provider.calls.Store(0)
+		logs.Reset()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("X-User", tt.user)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("GET %s as %s: %q, want %q", tt.path, tt.user, got, tt.want)
+		}
+		if got := provider.calls.Load(); got != tt.wantCalls {
+			t.Errorf("GET %s as %s: the provider called %d times, want %d", tt.path, tt.user, got, tt.wantCalls)
+		}
+		if logged := strings.Contains(logs.String(), "Feature flags resolved"); logged != (tt.wantCalls > 0) ||
+			logged && !strings.Contains(logs.String(), "flags=\"beta="+strconv.FormatBool(tt.user == "alice")+" dark=true\"") {
+			t.Errorf("GET %s as %s: logged %q", tt.path, tt.user, logs.String())
+		}
+	}
+}
+
+// TestFlagsWithoutProvider checks the flags are disabled without a provider,
+// and outside of a request.
+func TestFlagsWithoutProvider(t *testing.T) {
+	s := newTemplateServer(t, map[string]string{"page.html": `{{feature .Request "beta"}}`}, nil)
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.FormatBool(FlagEnabled(r.Context(), "beta")) + " "))
+		s.RenderRequest(w, r, "page.html", nil)
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := w.Body.String(); got != "false false" {
+		t.Errorf("%q, want false false", got)
+	}
+	w = httptest.NewRecorder()
+	s.Render(w, "page.html", nil)
+	if got := w.Body.String(); got != "false" {
+		t.Errorf("rendered without a request: %q, want false", got)
+	}
+}
@@ -0,0 +1,31 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Morditux/serverlib/templates"
+)
+
+// FormState returns the state to re-render the form of the request with, for
+// the form helpers (see templates.FormHelpers): the submitted values, and the
+// field errors when err holds ValidationErrors, in the locale of the request
+// (see Server.Localize).
+//
+// Parameters:
+//   - r: The request submitting the form.
+//   - err: The error of the form processing, or nil.
+//
+// Returns:
+//   - *templates.FormState: The form state.
+func FormState(r *http.Request, err error) *templates.FormState {
+	r.ParseForm()
+	form := templates.NewFormState(r.PostForm)
+	var validationErrors ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, fieldError := range serverOf(r).Localize(r, validationErrors) {
+			form.AddError(fieldError.Field, fieldError.Message)
+		}
+	}
+	return form
+}
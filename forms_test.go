@@ -0,0 +1,48 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormState(t *testing.T) {
+	validation := func() error {
+		var errs ValidationErrors
+		errs.AddKey("email", "validation.required", nil)
+		errs.Add("email", "is taken")
+		return errs
+	}
+	tests := []struct {
+		name       string
+		language   string
+		err        error
+		wantErrors map[string][]string
+	}{
+		{"no error", "", nil, map[string][]string{}},
+		{"other error", "", errors.New("database down"), map[string][]string{}},
+		{"validation", "", validation(), map[string][]string{"email": {"is required", "is taken"}}},
+		{"validation, localized", "fr", validation(), map[string][]string{"email": {"est obligatoire", "is taken"}}},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		var got map[string][]string
+		var value string
+		s.POST("/signup", func(w http.ResponseWriter, r *http.Request) {
+			form := FormState(r, tt.err)
+			got, value = form.Errors, form.Value("name")
+		})
+		r := httptest.NewRequest(http.MethodPost, "/signup?name=query", strings.NewReader("name=Ann&email="))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.language != "" {
+			r.Header.Set("Accept-Language", tt.language)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(got, tt.wantErrors) || value != "Ann" {
+			t.Errorf("%s: errors %q, name %q, want %q and the posted name", tt.name, got, value, tt.wantErrors)
+		}
+	}
+}
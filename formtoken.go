@@ -0,0 +1,152 @@
+package serverlib
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+const (
+	// FormTokenField is the name of the hidden field holding the submit token.
+	FormTokenField = "form_token"
+	// DefaultFormTokenTTL is the lifetime of a submit token.
+	DefaultFormTokenTTL = time.Hour
+	// DefaultFormTokenLimit is the number of outstanding submit tokens kept per
+	// session; issuing more drops the oldest ones.
+	DefaultFormTokenLimit = 20
+	// formTokensKey is the session key of the outstanding tokens.
+	formTokensKey = "serverlib.formTokens"
+)
+
+var (
+	// ErrDuplicateSubmission is returned by ConsumeFormToken when the token has
+	// already been consumed, i.e. the form was submitted twice.
+	ErrDuplicateSubmission = NewHTTPError(http.StatusConflict, "form already submitted")
+	// ErrFormTokenExpired is returned by ConsumeFormToken for tokens older than DefaultFormTokenTTL.
+	ErrFormTokenExpired = NewHTTPError(http.StatusBadRequest, "form expired, please submit it again")
+	// ErrMissingFormToken is returned by ConsumeFormToken when the request has no token.
+	ErrMissingFormToken = NewHTTPError(http.StatusBadRequest, "missing form token")
+)
+
+// formToken is an outstanding submit token.
+type formToken struct {
+	Token   string
+	Expires time.Time
+}
+
+// updateMuts serialize the updates of the sessions not implementing
+// sessions.Updater, by session ID: the sessions sharing a mutex wait for each
+// other, within the process only.
+var updateMuts [64]sync.Mutex
+
+// updateSession replaces the value of the key by the result of fn atomically,
+// through sessions.Updater when the session implements it.
+func updateSession(session sessions.Session, key string, fn func(value any) any) {
+	if updater, ok := session.(sessions.Updater); ok {
+		updater.Update(key, fn)
+		return
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(session.Id()))
+	mut := &updateMuts[hash.Sum32()%uint32(len(updateMuts))]
+	mut.Lock()
+	defer mut.Unlock()
+	session.Set(key, fn(session.Get(key)))
+}
+
+// NewFormToken issues a single-use submit token stored in the session.
+// Unlike a CSRF token it protects from duplicate submissions of a form,
+// see ConsumeFormToken.
+//
+// Returns:
+//   - string: The token, for the FormTokenField field of the form.
+//   - error: The error of the random generator; no token is stored then.
+func NewFormToken(session sessions.Session) (string, error) {
+	raw := make([]byte, 18)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("form token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now()
+	updateSession(session, formTokensKey, func(value any) any {
+		tokens, _ := value.([]formToken)
+		kept := make([]formToken, 0, len(tokens)+1)
+		for _, t := range tokens {
+			if now.Before(t.Expires) {
+				kept = append(kept, t)
+			}
+		}
+		kept = append(kept, formToken{Token: token, Expires: now.Add(DefaultFormTokenTTL)})
+		if len(kept) > DefaultFormTokenLimit {
+			kept = kept[len(kept)-DefaultFormTokenLimit:]
+		}
+		return kept
+	})
+	return token, nil
+}
+
+// ConsumeFormToken validates and removes the submit token of the request, in
+// a single atomic session update: of two concurrent submissions of the same
+// form, only one succeeds.
+//
+// Parameters:
+//   - r: The request submitting the form, with the FormTokenField field.
+//
+// Returns:
+//   - error: ErrDuplicateSubmission if the token was already consumed (or
+//     dropped by the limit of outstanding tokens), ErrFormTokenExpired or
+//     ErrMissingFormToken; nil if the submission can be processed.
+func ConsumeFormToken(r *http.Request) error {
+	token := r.PostFormValue(FormTokenField)
+	if token == "" {
+		token = r.FormValue(FormTokenField)
+	}
+	session := reqctx.Session(r.Context())
+	if token == "" || session == nil {
+		return ErrMissingFormToken
+	}
+	err := error(ErrDuplicateSubmission)
+	now := time.Now()
+	updateSession(session, formTokensKey, func(value any) any {
+		tokens, _ := value.([]formToken)
+		kept := make([]formToken, 0, len(tokens))
+		for _, t := range tokens {
+			if t.Token == token {
+				err = nil
+				if !now.Before(t.Expires) {
+					err = ErrFormTokenExpired
+				}
+				continue
+			}
+			kept = append(kept, t)
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return kept
+	})
+	return err
+}
+
+// formTokenField is the formToken template function: it writes a hidden field
+// with a new submit token, for the request passed as argument
+// (`{{formToken .Request}}`, see RenderRequest).
+func formTokenField(r *http.Request) (template.HTML, error) {
+	session := reqctx.Session(r.Context())
+	if session == nil {
+		return "", ErrMissingFormToken
+	}
+	token, err := NewFormToken(session)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<input type="hidden" name="` + FormTokenField + `" value="` + token + `">`), nil
+}
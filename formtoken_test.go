@@ -0,0 +1,137 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// plainSession is a session without sessions.Updater.
+type plainSession struct {
+	sessions.Session
+}
+
+func submitForm(session sessions.Session, token string) *http.Request {
+	form := url.Values{FormTokenField: {token}}
+	r := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r.WithContext(reqctx.WithSession(r.Context(), session))
+}
+
+func TestConsumeFormToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		submit func(t *testing.T, session sessions.Session) error
+		want   error
+	}{
+		{"single submission", func(t *testing.T, session sessions.Session) error {
+			token := mustFormToken(t, session)
+			return ConsumeFormToken(submitForm(session, token))
+		}, nil},
+		{"double submission", func(t *testing.T, session sessions.Session) error {
+			token := mustFormToken(t, session)
+			if err := ConsumeFormToken(submitForm(session, token)); err != nil {
+				t.Fatalf("first submission: %v", err)
+			}
+			return ConsumeFormToken(submitForm(session, token))
+		}, ErrDuplicateSubmission},
+		{"expired token", func(t *testing.T, session sessions.Session) error {
+			session.Set(formTokensKey, []formToken{{Token: "old", Expires: time.Now().Add(-time.Second)}})
+			return ConsumeFormToken(submitForm(session, "old"))
+		}, ErrFormTokenExpired},
+		{"missing token", func(t *testing.T, session sessions.Session) error {
+			return ConsumeFormToken(submitForm(session, ""))
+		}, ErrMissingFormToken},
+		{"unknown token", func(t *testing.T, session sessions.Session) error {
+			mustFormToken(t, session)
+			return ConsumeFormToken(submitForm(session, "forged"))
+		}, ErrDuplicateSubmission},
+		{"oldest token evicted by the cap", func(t *testing.T, session sessions.Session) error {
+			oldest := mustFormToken(t, session)
+			for range DefaultFormTokenLimit {
+				mustFormToken(t, session)
+			}
+			return ConsumeFormToken(submitForm(session, oldest))
+		}, ErrDuplicateSubmission},
+		{"newest token kept by the cap", func(t *testing.T, session sessions.Session) error {
+			var newest string
+			for range DefaultFormTokenLimit + 5 {
+				newest = mustFormToken(t, session)
+			}
+			return ConsumeFormToken(submitForm(session, newest))
+		}, nil},
+	}
+	for _, tt := range tests {
+		for _, session := range []sessions.Session{
+			sessions.NewMemorySession("updater"),
+			plainSession{sessions.NewMemorySession("plain")},
+		} {
+			if err := tt.submit(t, session); !errors.Is(err, tt.want) {
+				t.Errorf("%s (%T): got %v, want %v", tt.name, session, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestFormTokenLimit(t *testing.T) {
+	session := sessions.NewMemorySession("limit")
+	for range DefaultFormTokenLimit * 2 {
+		mustFormToken(t, session)
+	}
+	tokens, _ := session.Get(formTokensKey).([]formToken)
+	if len(tokens) != DefaultFormTokenLimit {
+		t.Errorf("%d outstanding tokens, want %d", len(tokens), DefaultFormTokenLimit)
+	}
+}
+
+// TestConsumeFormTokenConcurrent submits the same form concurrently: only
+// one submission succeeds.
+func TestConsumeFormTokenConcurrent(t *testing.T) {
+	for _, session := range []sessions.Session{
+		sessions.NewMemorySession("updater"),
+		plainSession{sessions.NewMemorySession("plain")},
+	} {
+		token := mustFormToken(t, session)
+		const submissions = 32
+		errs := make(chan error, submissions)
+		var wg sync.WaitGroup
+		for range submissions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- ConsumeFormToken(submitForm(session, token))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		succeeded := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, ErrDuplicateSubmission):
+				t.Errorf("%T: unexpected error %v", session, err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%T: %d submissions succeeded, want 1", session, succeeded)
+		}
+	}
+}
+
+func mustFormToken(t *testing.T, session sessions.Session) string {
+	t.Helper()
+	token, err := NewFormToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,191 @@
+package serverlib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/i18n"
+)
+
+// FuzzSessionCookie decodes arbitrary cookie values: a versioned value is
+// encoded back to itself, and an encoded ID decodes to itself.
+func FuzzSessionCookie(f *testing.F) {
+	for _, seed := range []string{"", "abc", "v1~abc", "v12~a~b", "v0~abc", "v01~abc", "v+1~abc", "v-1~abc", "v~abc", "x1~abc"} {
+		f.Add(seed, 3)
+	}
+	f.Fuzz(func(t *testing.T, value string, version int) {
+		id, decoded := decodeSessionCookie(value)
+		if decoded < 0 {
+			t.Fatalf("decodeSessionCookie(%q) = version %d", value, decoded)
+		}
+		if decoded == 0 && id != value {
+			t.Fatalf("decodeSessionCookie(%q) = %q without a version", value, id)
+		}
+		if decoded > 0 && encodeSessionCookie(id, decoded) != value {
+			t.Fatalf("decodeSessionCookie(%q) = %q, %d, encoded back to %q", value, id, decoded, encodeSessionCookie(id, decoded))
+		}
+		if version > 0 {
+			if gotID, gotVersion := decodeSessionCookie(encodeSessionCookie(value, version)); gotID != value || gotVersion != version {
+				t.Fatalf("encoding %q, %d decodes to %q, %d", value, version, gotID, gotVersion)
+			}
+		}
+	})
+}
+
+// FuzzCookieValues reads the session cookies of arbitrary Cookie headers
+// like net/http does.
+func FuzzCookieValues(f *testing.F) {
+	for _, seed := range []string{"sid=abc", "theme=dark; sid=abc; lang=fr", `sid="abc"`, `sid=""`, `sid="`, "sid=a b", "sid=a,b", "\tsid=abc\t", "sid=abc ", "sid=é", ";;sid=;", " sid = abc"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Cookie", header)
+		var got []string
+		n := cookieValues(r, "sid", func(value string) {
+			got = append(got, value)
+		})
+		var want []string
+		for _, cookie := range r.CookiesNamed("sid") {
+			want = append(want, cookie.Value)
+		}
+		if !slices.Equal(got, want) || n != len(got) {
+			t.Fatalf("Cookie %q: %q (%d), net/http reads %q", header, got, n, want)
+		}
+	})
+}
+
+// FuzzAcceptEncoding checks that an explicit entry placed first decides, and
+// that a header not naming the coding nor "*" doesn't accept it.
+func FuzzAcceptEncoding(f *testing.F) {
+	for _, seed := range []string{"", "gzip", "br;q=0.5, gzip", "*;q=0", "gzip;q=0, *", "GZIP ; q = 0.1", "gzip;q=abc", "gzip;q=-1", "*;q=NaN"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, header string) {
+		accepts := func(header string) bool {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", header)
+			return acceptsEncoding(r, "gzip")
+		}
+		got := accepts(header)
+		if lower := strings.ToLower(header); got && !strings.Contains(lower, "gzip") && !strings.Contains(lower, "*") {
+			t.Fatalf("Accept-Encoding %q accepts gzip", header)
+		}
+		if accepts("gzip;q=0, " + header) {
+			t.Fatalf("Accept-Encoding %q accepts gzip after an explicit q=0", "gzip;q=0, "+header)
+		}
+		if !accepts("gzip, " + header) {
+			t.Fatalf("Accept-Encoding %q refuses gzip after an explicit entry", "gzip, "+header)
+		}
+	})
+}
+
+// FuzzAcceptLanguage matches arbitrary Accept-Language headers: the match is
+// always a locale of the catalog or its fallback.
+func FuzzAcceptLanguage(f *testing.F) {
+	for _, seed := range []string{"", "fr-CH, fr;q=0.9, en;q=0.8", "*", "de;q=0", "pt", "PT_br", "en;q=NaN, fr;q=Inf", "fr;q=0x1p-2", ",,;;q=", "es-419;q=1.5"} {
+		f.Add(seed)
+	}
+	catalog := i18n.NewCatalog("en")
+	for _, locale := range []string{"en", "fr", "fr-ch", "pt-br", "de"} {
+		catalog.Add(locale, map[string]string{"hello": locale})
+	}
+	locales := catalog.Locales()
+	f.Fuzz(func(t *testing.T, header string) {
+		if got := catalog.Match(header); !slices.Contains(locales, got) {
+			t.Fatalf("Match(%q) = %q, not a locale of the catalog", header, got)
+		}
+		for _, locale := range locales {
+			if got := catalog.Match(locale + ", " + header); got != locale {
+				t.Fatalf("Match(%q) = %q, want the first locale %q", locale+", "+header, got, locale)
+			}
+		}
+	})
+}
+
+// FuzzReadProxyHeader reads arbitrary connection prefaces: a connection
+// without a header is left untouched, a header read leaves the bytes after it,
+// and its address is written back as the same address.
+func FuzzReadProxyHeader(f *testing.F) {
+	f.Add([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	f.Add([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"))
+	f.Add([]byte("PROXY UNKNOWN\r\n"))
+	f.Add([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 99999 443\r\n"))
+	f.Add([]byte("PROXY TCP4 2001:db8::1 198.51.100.1 1 443\r\n"))
+	f.Add([]byte("GET / HTTP/1.1\r\n"))
+	f.Add(proxyV2Header(0x1, 0x11, []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdb, 0x04, 0x01, 0xbb}))
+	f.Add(proxyV2Header(0x1, 0x21, make([]byte, 36)))
+	f.Add(proxyV2Header(0x0, 0x00, nil))
+	f.Add(proxyV2Header(0x1, 0x11, []byte{1, 2, 3}))
+	f.Add(proxyV2Header(0x1, 0x11, make([]byte, proxyV2MaxLength+1)))
+	f.Add(proxyV2Signature)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bufio.NewReader(bytes.NewReader(data))
+		addr, found, err := readProxyHeader(r)
+		if !found {
+			if err != nil || addr != nil {
+				t.Fatalf("no header, but %v, %v", addr, err)
+			}
+			if rest, _ := io.ReadAll(r); !bytes.Equal(rest, data) {
+				t.Fatalf("no header, but %q of %q left", rest, data)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		if rest, _ := io.ReadAll(r); !bytes.HasSuffix(data, rest) {
+			t.Fatalf("%q left after the header of %q", rest, data)
+		}
+		if addr == nil {
+			return
+		}
+		tcp, ok := addr.(*net.TCPAddr)
+		if !ok {
+			t.Fatalf("address %T, want a *net.TCPAddr", addr)
+		}
+		family := "TCP4"
+		if tcp.IP.To4() == nil {
+			family = "TCP6"
+		}
+		header := fmt.Sprintf("PROXY %s %s %s %d 443\r\n", family, tcp.IP, tcp.IP, tcp.Port)
+		again, _, err := readProxyHeader(bufio.NewReader(strings.NewReader(header)))
+		if err != nil || again.String() != addr.String() {
+			t.Fatalf("%v written as %q reads %v, %v", addr, header, again, err)
+		}
+	})
+}
+
+// proxyV2Header returns a binary header with the command, family and payload.
+func proxyV2Header(command, family byte, payload []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// FuzzAddrPrefix parses arbitrary addresses and networks of the trusted
+// proxies: a prefix parsed is valid and contains its address.
+func FuzzAddrPrefix(f *testing.F) {
+	for _, seed := range []string{"10.0.0.5", "10.0.0.0/8", "::ffff:10.0.0.5", "2001:db8::/32", "10.0.0.0/33", "fe80::1%eth0", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		prefix, err := parseAddrPrefix(s)
+		if err != nil {
+			return
+		}
+		if !prefix.IsValid() || !prefix.Contains(prefix.Addr()) {
+			t.Fatalf("parseAddrPrefix(%q) = %v, not containing its address", s, prefix)
+		}
+	})
+}
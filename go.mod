@@ -2,4 +2,10 @@ module github.com/Morditux/serverlib
 
 go 1.23.0
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/net v0.43.0
+)
+
+require golang.org/x/text v0.28.0 // indirect
@@ -0,0 +1,186 @@
+package serverlib
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// startH2CServer starts a server with EnableH2C answering GET /proto with
+// the protocol of the request, and GET /wait with handler when not nil.
+func startH2CServer(t *testing.T, handler http.HandlerFunc) *Server {
+	t.Helper()
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", EnableH2C: true})
+	s.GET("/proto", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	if handler != nil {
+		s.GET("/wait", handler)
+	}
+	done := startServer(s)
+	<-s.Ready()
+	t.Cleanup(func() {
+		s.Stop()
+		waitStart(t, done)
+	})
+	return s
+}
+
+// h2cClient returns a prior knowledge h2c client and its count of dials.
+func h2cClient() (*http.Client, *atomic.Int32) {
+	dials := &atomic.Int32{}
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			dials.Add(1)
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, dials
+}
+
+func TestH2C(t *testing.T) {
+	s := startH2CServer(t, nil)
+	h2c, _ := h2cClient()
+	tests := []struct {
+		name   string
+		client *http.Client
+		want   string
+	}{
+		{"prior knowledge", h2c, "HTTP/2.0"},
+		{"HTTP/1.1", &http.Client{Timeout: 10 * time.Second}, "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		resp, err := tt.client.Get("http://" + s.Addr() + "/proto")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != tt.want {
+			t.Errorf("%s: %d %q, want 200 %q", tt.name, resp.StatusCode, body, tt.want)
+		}
+	}
+}
+
+// TestH2CMultiplexing holds concurrent requests open until all of them
+// reached the handler: they can only complete if they are multiplexed over
+// the single connection of the client.
+func TestH2CMultiplexing(t *testing.T) {
+	const streams = 8
+	var arrived sync.WaitGroup
+	arrived.Add(streams)
+	s := startH2CServer(t, func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.Write([]byte(r.Proto))
+	})
+	client, dials := h2cClient()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, streams)
+	for range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("http://" + s.Addr() + "/wait")
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("request: %v", err)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("%d connections for %d concurrent streams, want 1", n, streams)
+	}
+}
+
+// TestH2CUpgrade upgrades an HTTP/1.1 connection with Upgrade: h2c: the
+// response to the upgraded request comes in HTTP/2 frames on stream 1, the
+// request itself keeping its HTTP/1.1 protocol.
+func TestH2CUpgrade(t *testing.T) {
+	s := startH2CServer(t, nil)
+	conn, err := net.DialTimeout("tcp", s.Addr(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	request := "GET /proto HTTP/1.1\r\n" +
+		"Host: " + s.Addr() + "\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		// An empty SETTINGS payload.
+		"HTTP2-Settings: \r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(resp.Header.Get("Upgrade"), "h2c") {
+		t.Fatalf("response %s, Upgrade %q, want 101 h2c", resp.Status, resp.Header.Get("Upgrade"))
+	}
+
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	framer := http2.NewFramer(conn, reader)
+	if err := framer.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	var status, body string
+	decoder := hpack.NewDecoder(4096, func(field hpack.HeaderField) {
+		if field.Name == ":status" {
+			status = field.Value
+		}
+	})
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatalf("reading the frames: %v", err)
+		}
+		switch frame := frame.(type) {
+		case *http2.SettingsFrame:
+			if !frame.IsAck() {
+				framer.WriteSettingsAck()
+			}
+		case *http2.HeadersFrame:
+			if frame.StreamID != 1 {
+				t.Fatalf("headers on stream %d, want 1", frame.StreamID)
+			}
+			if _, err := decoder.Write(frame.HeaderBlockFragment()); err != nil {
+				t.Fatal(err)
+			}
+		case *http2.DataFrame:
+			body += string(frame.Data())
+			if frame.StreamEnded() {
+				if status != "200" || body != "HTTP/1.1" {
+					t.Errorf("upgraded response %s %q, want 200 %q", status, body, "HTTP/1.1")
+				}
+				return
+			}
+		}
+	}
+}
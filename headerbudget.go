@@ -0,0 +1,244 @@
+package serverlib
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Morditux/serverlib/units"
+)
+
+// MaxCookieSize is the practical size limit of a cookie, its name, value and
+// attributes included: browsers ignore larger cookies.
+const MaxCookieSize = 4096
+
+// DefaultHeaderSoftLimit is the response header size over which a warning is
+// logged when HeaderBudgetOptions.SoftLimit is not set, below the 8 KiB limit
+// of many proxies and clients.
+const DefaultHeaderSoftLimit = 6 << 10 // 6 KiB
+
+// CookieSourceApplication is a cookie written by the application with SetCookie.
+const CookieSourceApplication CookieSource = "application"
+
+// CookiePriority orders the cookies of a response over the header budget: the
+// lowest priorities are dropped first.
+type CookiePriority int
+
+const (
+	// CookiePriorityLow is a cookie the response can do without, such as the
+	// experiment assignments or the expiry of a legacy duplicate.
+	CookiePriorityLow CookiePriority = iota - 1
+	// CookiePriorityNormal is the priority of the cookies not written by
+	// setCookie, e.g. with http.SetCookie.
+	CookiePriorityNormal
+	// CookiePriorityHigh is a cookie dropped only after the others, such as a
+	// remember-me cookie.
+	CookiePriorityHigh
+	// CookiePriorityRequired is a cookie never dropped, such as the session cookie.
+	CookiePriorityRequired
+)
+
+// CookieTooLargeError is returned for a cookie larger than MaxCookieSize,
+// which is not written.
+type CookieTooLargeError struct {
+	Name   string
+	Source CookieSource
+	Size   int
+}
+
+// Error implements the error interface.
+func (e *CookieTooLargeError) Error() string {
+	return fmt.Sprintf("cookie %s from %s is %s, over the %s limit", e.Name, e.Source, units.FormatBytes(int64(e.Size)), units.FormatBytes(MaxCookieSize))
+}
+
+// SetCookie writes a cookie of the application through the cookie chokepoint
+// of the library: it is logged at Debug, recorded by a ResponseRecorder, and
+// dropped according to its priority over the header budget.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - cookie: The cookie to write.
+//   - priority: The priority of the cookie over the header budget.
+//
+// Returns:
+//   - error: A *CookieTooLargeError when the cookie is larger than MaxCookieSize.
+func (s *Server) SetCookie(w http.ResponseWriter, cookie *http.Cookie, priority CookiePriority) error {
+	return s.setCookie(w, cookie, CookieSourceApplication, priority)
+}
+
+// SetCookie writes a cookie of the application with the server answering
+// with w, or else the default server, see Server.SetCookie.
+func SetCookie(w http.ResponseWriter, cookie *http.Cookie, priority CookiePriority) error {
+	return serverOfWriter(w).SetCookie(w, cookie, priority)
+}
+
+// HeaderBudgetOptions configures the response header budget, see
+// ServerConfig.HeaderBudget. The headers of every response are measured just
+// before being sent; over SoftLimit, a warning is logged with the size of each
+// header and cookie.
+type HeaderBudgetOptions struct {
+	// SoftLimit is the size of the response headers, in bytes, over which the
+	// warning is logged. Defaults to DefaultHeaderSoftLimit.
+	SoftLimit int
+	// DropCookies drops the cookies of the lowest priorities, but never the
+	// CookiePriorityRequired ones, until the headers fit in SoftLimit, rather
+	// than risk a client or a proxy rejecting the response.
+	DropCookies bool
+	// OnDrop, if not nil, is called with the names of the cookies dropped from
+	// a response, e.g. to count them in a metric.
+	OnDrop func(r *http.Request, dropped []string)
+}
+
+// headerBudget checks the response headers against the budget.
+type headerBudget struct {
+	options HeaderBudgetOptions
+	dropped atomic.Uint64
+}
+
+func newHeaderBudget(options HeaderBudgetOptions) *headerBudget {
+	if options.SoftLimit <= 0 {
+		options.SoftLimit = DefaultHeaderSoftLimit
+	}
+	return &headerBudget{options: options}
+}
+
+// wrap returns the writer checking the headers of the response to r.
+func (b *headerBudget) wrap(w http.ResponseWriter, r *http.Request) *headerBudgetWriter {
+	return &headerBudgetWriter{ResponseWriter: w, budget: b, r: r, priorities: map[string]CookiePriority{}}
+}
+
+// stats returns the counters of the budget, for Server.Info.
+func (b *headerBudget) stats() map[string]any {
+	return map[string]any{
+		"soft_limit":      units.FormatBytes(int64(b.options.SoftLimit)),
+		"dropped_cookies": b.dropped.Load(),
+	}
+}
+
+// headerSize returns the size of a header line on the wire.
+func headerSize(name, value string) int {
+	return len(name) + len(": ") + len(value) + len("\r\n")
+}
+
+// check measures the headers, logs the warning and drops the cookies.
+func (b *headerBudget) check(header http.Header, r *http.Request, priorities map[string]CookiePriority) {
+	total := 0
+	sizes := map[string]int{}
+	for name, values := range header {
+		for _, value := range values {
+			size := headerSize(name, value)
+			sizes[name] += size
+			total += size
+		}
+	}
+	if total <= b.options.SoftLimit {
+		return
+	}
+	cookies := header["Set-Cookie"]
+	cookieSizes := make(map[string]int, len(cookies))
+	for _, line := range cookies {
+		cookieSizes[cookieName(line)] += headerSize("Set-Cookie", line)
+	}
+	slog.Warn("Response headers over budget",
+		"path", r.URL.Path, "size", units.FormatBytes(int64(total)), "limit", units.FormatBytes(int64(b.options.SoftLimit)),
+		"cookies", len(cookies), "headers", formatSizes(sizes), "cookie_sizes", formatSizes(cookieSizes))
+	if !b.options.DropCookies {
+		return
+	}
+	// The lowest priorities first, then the largest cookies.
+	candidates := slices.Clone(cookies)
+	slices.SortStableFunc(candidates, func(a, c string) int {
+		if n := cmp.Compare(priorities[a], priorities[c]); n != 0 {
+			return n
+		}
+		return cmp.Compare(len(c), len(a))
+	})
+	drop := map[string]bool{}
+	var dropped []string
+	for _, line := range candidates {
+		if total <= b.options.SoftLimit || priorities[line] >= CookiePriorityRequired {
+			break
+		}
+		drop[line] = true
+		dropped = append(dropped, cookieName(line))
+		total -= headerSize("Set-Cookie", line)
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	header["Set-Cookie"] = slices.DeleteFunc(cookies, func(line string) bool { return drop[line] })
+	if len(header["Set-Cookie"]) == 0 {
+		delete(header, "Set-Cookie")
+	}
+	b.dropped.Add(uint64(len(dropped)))
+	slog.Warn("Dropped cookies over the header budget", "path", r.URL.Path, "cookies", dropped, "size", units.FormatBytes(int64(total)))
+	if b.options.OnDrop != nil {
+		b.options.OnDrop(r, dropped)
+	}
+}
+
+// formatSizes formats the sizes of a breakdown with units.FormatBytes.
+func formatSizes(sizes map[string]int) map[string]string {
+	formatted := make(map[string]string, len(sizes))
+	for name, size := range sizes {
+		formatted[name] = units.FormatBytes(int64(size))
+	}
+	return formatted
+}
+
+// cookieName returns the name of the cookie of a Set-Cookie line.
+func cookieName(line string) string {
+	name, _, _ := strings.Cut(line, "=")
+	return name
+}
+
+// headerBudgetWriter checks the headers against the budget just before they
+// are written. It holds the priorities of the cookies written by setCookie.
+type headerBudgetWriter struct {
+	http.ResponseWriter
+	budget      *headerBudget
+	r           *http.Request
+	priorities  map[string]CookiePriority
+	wroteHeader bool
+}
+
+// finish checks the headers of a response the handler didn't write to, sent
+// by net/http once the handler returns.
+func (w *headerBudgetWriter) finish() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.budget.check(w.Header(), w.r, w.priorities)
+	}
+}
+
+func (w *headerBudgetWriter) WriteHeader(status int) {
+	if status >= 200 {
+		w.finish()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerBudgetWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush checks the headers of a response flushed before its first write, e.g.
+// an event stream, then sends them.
+func (w *headerBudgetWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *headerBudgetWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
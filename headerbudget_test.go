@@ -0,0 +1,214 @@
+package serverlib
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// budgetCookie is a cookie written by the handlers of the budget tests.
+type budgetCookie struct {
+	name string
+	// size is the size of the value.
+	size int
+	// priority is the priority given to Server.SetCookie, or withoutPriority
+	// for the cookie to be written with http.SetCookie.
+	priority CookiePriority
+}
+
+const withoutPriority CookiePriority = -2
+
+// writeCookies writes the cookies of a budget test.
+func writeCookies(s *Server, w http.ResponseWriter, cookies []budgetCookie) {
+	for _, c := range cookies {
+		cookie := &http.Cookie{Name: c.name, Value: strings.Repeat("v", c.size)}
+		if c.priority == withoutPriority {
+			http.SetCookie(w, cookie)
+			continue
+		}
+		s.SetCookie(w, cookie, c.priority)
+	}
+}
+
+// responseCookies returns the names of the cookies of a response, but the
+// session cookie written to every response.
+func responseCookies(s *Server, header http.Header) []string {
+	var names []string
+	for _, line := range header["Set-Cookie"] {
+		if name := cookieName(line); name != s.sessionKey {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestHeaderBudget writes oversized header sets and checks the warning and
+// the cookies dropped, lowest priority and then largest first.
+func TestHeaderBudget(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	tests := []struct {
+		name        string
+		drop        bool
+		header      int
+		cookies     []budgetCookie
+		wantWarn    bool
+		wantCookies []string
+		wantDropped []string
+	}{
+		{"under the limit", true, 0, []budgetCookie{{"a", 100, CookiePriorityNormal}, {"b", 100, CookiePriorityLow}}, false, []string{"a", "b"}, nil},
+		{"warned only", false, 0, []budgetCookie{{"a", 600, CookiePriorityLow}, {"b", 600, CookiePriorityNormal}}, true, []string{"a", "b"}, nil},
+		{"lowest priorities first", true, 0, []budgetCookie{
+			{"session", 500, CookiePriorityRequired},
+			{"experiment", 300, CookiePriorityLow},
+			{"prefs", 300, CookiePriorityNormal},
+			{"remember", 300, CookiePriorityHigh},
+		}, true, []string{"remember", "session"}, []string{"experiment", "prefs"}},
+		{"largest first", true, 0, []budgetCookie{
+			{"session", 400, CookiePriorityRequired},
+			{"small", 200, CookiePriorityLow},
+			{"large", 500, CookiePriorityLow},
+		}, true, []string{"session", "small"}, []string{"large"}},
+		{"required kept over the limit", true, 0, []budgetCookie{
+			{"session", 1200, CookiePriorityRequired},
+			{"experiment", 100, CookiePriorityLow},
+		}, true, []string{"session"}, []string{"experiment"}},
+		{"http.SetCookie is normal", true, 0, []budgetCookie{
+			{"session", 300, CookiePriorityRequired},
+			{"remember", 400, CookiePriorityHigh},
+			{"plain", 400, withoutPriority},
+		}, true, []string{"remember", "session"}, []string{"plain"}},
+		{"other headers", true, 1200, []budgetCookie{{"experiment", 10, CookiePriorityLow}}, true, nil, []string{"experiment"}},
+	}
+	for _, tt := range tests {
+		var dropped []string
+		s := NewServer(ServerConfig{HeaderBudget: &HeaderBudgetOptions{SoftLimit: 1000, DropCookies: tt.drop, OnDrop: func(r *http.Request, names []string) {
+			dropped = append(dropped, names...)
+		}}})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			if tt.header > 0 {
+				w.Header().Set("X-Debug", strings.Repeat("d", tt.header))
+			}
+			writeCookies(s, w, tt.cookies)
+			w.Write([]byte("ok"))
+		})
+		logs.Reset()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := responseCookies(s, w.Result().Header); !slices.Equal(got, tt.wantCookies) {
+			t.Errorf("%s: cookies %v, want %v", tt.name, got, tt.wantCookies)
+		}
+		slices.Sort(dropped)
+		if wantDropped := slices.Sorted(slices.Values(tt.wantDropped)); !slices.Equal(dropped, wantDropped) {
+			t.Errorf("%s: OnDrop %v, want %v", tt.name, dropped, wantDropped)
+		}
+		got := logs.String()
+		if warned := strings.Contains(got, "Response headers over budget"); warned != tt.wantWarn {
+			t.Errorf("%s: warned %v, want %v: %q", tt.name, warned, tt.wantWarn, got)
+		}
+		if tt.wantWarn {
+			// The breakdown lists each header and each cookie.
+			for _, c := range tt.cookies {
+				if !strings.Contains(got, c.name+":") {
+					t.Errorf("%s: no size of the cookie %s in %q", tt.name, c.name, got)
+				}
+			}
+			if tt.header > 0 && !strings.Contains(got, "X-Debug:") {
+				t.Errorf("%s: no size of X-Debug in %q", tt.name, got)
+			}
+		}
+		if logged := strings.Contains(got, "Dropped cookies over the header budget"); logged != (len(tt.wantDropped) > 0) {
+			t.Errorf("%s: drop logged %v: %q", tt.name, logged, got)
+		}
+		stats := s.Info().Features["header_budget"].(map[string]any)
+		if stats["dropped_cookies"] != uint64(len(tt.wantDropped)) || stats["soft_limit"] != "1000 B" {
+			t.Errorf("%s: stats %v", tt.name, stats)
+		}
+	}
+}
+
+// TestHeaderBudgetSent checks the headers are measured however the response
+// starts: written, flushed before the first write, or not written at all.
+func TestHeaderBudgetSent(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+	cookies := []budgetCookie{{"session", 400, CookiePriorityRequired}, {"experiment", 800, CookiePriorityLow}}
+	tests := []struct {
+		name  string
+		start func(w http.ResponseWriter)
+	}{
+		{"written", func(w http.ResponseWriter) { w.Write([]byte("ok")) }},
+		{"status written", func(w http.ResponseWriter) { w.WriteHeader(http.StatusAccepted) }},
+		{"early hints", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.Write([]byte("ok"))
+		}},
+		{"flushed", func(w http.ResponseWriter) {
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("flushed: %v", err)
+			}
+		}},
+		{"not written", func(http.ResponseWriter) {}},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{HeaderBudget: &HeaderBudgetOptions{SoftLimit: 1000, DropCookies: true}})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			writeCookies(s, w, cookies)
+			tt.start(w)
+		})
+		// Served over HTTP, for the early hints and the flush to be sent.
+		server := httptest.NewServer(s)
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		server.Close()
+		if got := responseCookies(s, resp.Header); !slices.Equal(got, []string{"session"}) {
+			t.Errorf("%s: cookies %v, want the session cookie only", tt.name, got)
+		}
+	}
+}
+
+// TestCookieTooLarge checks the cookies over MaxCookieSize, attributes
+// included, are refused with a typed error and not written.
+func TestCookieTooLarge(t *testing.T) {
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		wantSize int
+	}{
+		{"under the limit", &http.Cookie{Name: "a", Value: strings.Repeat("v", 4000)}, 0},
+		{"at the limit", &http.Cookie{Name: "a", Value: strings.Repeat("v", MaxCookieSize-len("a="))}, 0},
+		{"over the limit", &http.Cookie{Name: "a", Value: strings.Repeat("v", MaxCookieSize-len("a=")+1)}, MaxCookieSize + 1},
+		{"attributes over the limit", &http.Cookie{Name: "a", Value: strings.Repeat("v", 4090), Path: "/account"}, len("a=") + 4090 + len("; Path=/account")},
+	}
+	for _, tt := range tests {
+		var logs bytes.Buffer
+		s := NewServer(ServerConfig{ErrorLog: log.New(&logs, "", 0), LogLevel: Error})
+		w := httptest.NewRecorder()
+		err := s.SetCookie(w, tt.cookie, CookiePriorityNormal)
+		written := len(w.Header()["Set-Cookie"]) == 1
+		if tt.wantSize == 0 {
+			if err != nil || !written || strings.Contains(logs.String(), "Cookie not written") {
+				t.Errorf("%s: %v, written %v, logged %q", tt.name, err, written, logs.String())
+			}
+			continue
+		}
+		var tooLarge *CookieTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Name != "a" || tooLarge.Size != tt.wantSize || tooLarge.Source != CookieSourceApplication {
+			t.Errorf("%s: %#v, want a *CookieTooLargeError of %d bytes", tt.name, err, tt.wantSize)
+		}
+		if written || !strings.Contains(logs.String(), "Cookie not written") {
+			t.Errorf("%s: written %v, logged %q", tt.name, written, logs.String())
+		}
+	}
+}
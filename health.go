@@ -0,0 +1,161 @@
+package serverlib
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTimeout bounds each check of the readiness endpoint.
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// The default paths of EnableHealthEndpoints.
+const (
+	DefaultLivenessPath  = "/healthz"
+	DefaultReadinessPath = "/readyz"
+)
+
+// healthCheck is a check registered with AddHealthCheck.
+type healthCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// healthChecks holds the checks of the server.
+type healthChecks struct {
+	checks []healthCheck
+	mut    *sync.Mutex
+}
+
+// HealthCheckResult is the outcome of a check in the readiness response.
+type HealthCheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// LatencyMs is the duration of the check, in milliseconds.
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthResponse is the body of the health endpoints.
+type HealthResponse struct {
+	// Status is "ok", or "unavailable" with a 503.
+	Status string `json:"status"`
+	// Reason tells why the server is not ready, e.g. "draining".
+	Reason string              `json:"reason,omitempty"`
+	Checks []HealthCheckResult `json:"checks,omitempty"`
+}
+
+// AddHealthCheck registers a check of a dependency, e.g. pinging the
+// database, run by the readiness endpoint (see EnableHealthEndpoints) on each
+// request, concurrently with the other checks: a check failing, or not
+// returning within DefaultHealthCheckTimeout, makes the server unready.
+//
+// Parameters:
+//   - name: The name of the check in the response, e.g. "database".
+//   - fn: The check; it must return once ctx is done.
+func (s *Server) AddHealthCheck(name string, fn func(ctx context.Context) error) {
+	s.health.mut.Lock()
+	defer s.health.mut.Unlock()
+	s.health.checks = append(s.health.checks, healthCheck{name: name, fn: fn})
+}
+
+// EnableHealthEndpoints registers the liveness and the readiness endpoints of
+// the orchestrators and the load balancers. The liveness endpoint answers 200
+// as long as the process serves. The readiness endpoint answers 200 once the
+// server is ready (see Ready), unless it is draining or stopping (see Drain)
+// or a check of AddHealthCheck fails, a 503 otherwise; its JSON body reports
+// each check with its status and latency:
+//
+//	{"status":"unavailable","checks":[{"name":"database","status":"failing","latency_ms":5000,"error":"context deadline exceeded"}]}
+//
+// Parameters:
+//   - path: Optional paths of the liveness then the readiness endpoints,
+//     DefaultLivenessPath and DefaultReadinessPath by default.
+func (s *Server) EnableHealthEndpoints(path ...string) {
+	paths := []string{DefaultLivenessPath, DefaultReadinessPath}
+	copy(paths, path)
+	for i, handler := range []http.HandlerFunc{s.serveLiveness, s.serveReadiness} {
+		pattern := "GET /" + strings.Trim(paths[i], "/")
+		slog.Info("Registred health endpoint", "pattern", pattern)
+		s.router.HandleFunc(pattern, handler)
+		s.addHandlerRoute(pattern, nil, handler, nil)
+	}
+}
+
+// serveLiveness serves the liveness endpoint.
+func (s *Server) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	JSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// serveReadiness serves the readiness endpoint.
+func (s *Server) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	response := HealthResponse{Status: "ok"}
+	select {
+	case <-s.Ready():
+		if state := s.State(); state != StateRunning {
+			response.Reason = state.String()
+		}
+	default:
+		response.Reason = "starting"
+	}
+	if response.Reason == "" {
+		response.Checks = s.runHealthChecks(r.Context())
+		for _, check := range response.Checks {
+			if check.Error != "" {
+				response.Reason = "check failing"
+				break
+			}
+		}
+	}
+	if response.Reason != "" {
+		response.Status = "unavailable"
+		JSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	JSON(w, http.StatusOK, response)
+}
+
+// runHealthChecks runs the checks concurrently.
+func (s *Server) runHealthChecks(ctx context.Context) []HealthCheckResult {
+	s.health.mut.Lock()
+	checks := append([]healthCheck(nil), s.health.checks...)
+	s.health.mut.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, DefaultHealthCheckTimeout)
+	defer cancel()
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started := time.Now()
+			done := make(chan error, 1)
+			go func() {
+				done <- check.fn(ctx)
+			}()
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				// A check ignoring ctx doesn't hold the response.
+				err = ctx.Err()
+			}
+			results[i] = HealthCheckResult{
+				Name:      check.name,
+				Status:    "ok",
+				LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = "failing"
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,160 @@
+package serverlib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// health serves a request of path and decodes the response.
+func health(t *testing.T, s *Server, ctx context.Context, path string) (int, HealthResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+	if cache := w.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("%s: Cache-Control %q", path, cache)
+	}
+	var response HealthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: %v: %s", path, err, w.Body.String())
+	}
+	return w.Code, response
+}
+
+// TestHealthEndpoints checks the liveness endpoint answers as long as the
+// process serves, and the readiness endpoint only once the server is ready,
+// not draining, and its checks pass.
+func TestHealthEndpoints(t *testing.T) {
+	s := newTestServer()
+	s.EnableHealthEndpoints()
+	var failing error
+	s.AddHealthCheck("database", func(ctx context.Context) error { return failing })
+	s.AddHealthCheck("cache", func(ctx context.Context) error { return nil })
+	ctx := context.Background()
+	if code, response := health(t, s, ctx, DefaultLivenessPath); code != http.StatusOK || response.Status != "ok" {
+		t.Errorf("liveness before the start: %d %+v", code, response)
+	}
+	if code, response := health(t, s, ctx, DefaultReadinessPath); code != http.StatusServiceUnavailable || response.Reason != "starting" {
+		t.Errorf("readiness before the start: %d %+v", code, response)
+	}
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	code, response := health(t, s, ctx, DefaultReadinessPath)
+	if code != http.StatusOK || response.Status != "ok" || len(response.Checks) != 2 {
+		t.Fatalf("readiness: %d %+v", code, response)
+	}
+	for i, name := range []string{"database", "cache"} {
+		if check := response.Checks[i]; check.Name != name || check.Status != "ok" || check.Error != "" {
+			t.Errorf("check %d: %+v, want %s ok", i, check, name)
+		}
+	}
+
+	failing = errors.New("connection refused")
+	code, response = health(t, s, ctx, DefaultReadinessPath)
+	if code != http.StatusServiceUnavailable || response.Status != "unavailable" || response.Reason != "check failing" {
+		t.Errorf("failing check: %d %+v", code, response)
+	}
+	if check := response.Checks[0]; check.Status != "failing" || check.Error != "connection refused" {
+		t.Errorf("failing check: %+v", check)
+	}
+	failing = nil
+
+	s.Drain()
+	if code, response := health(t, s, ctx, DefaultReadinessPath); code != http.StatusServiceUnavailable || response.Reason != "draining" || response.Checks != nil {
+		t.Errorf("draining: %d %+v", code, response)
+	}
+	if code, _ := health(t, s, ctx, DefaultLivenessPath); code != http.StatusOK {
+		t.Errorf("liveness while draining: %d", code)
+	}
+	s.Undrain()
+}
+
+// TestHealthCheckTimeout checks a check ignoring its context doesn't hold
+// the readiness response past the context of the request.
+func TestHealthCheckTimeout(t *testing.T) {
+	s := newTestServer()
+	s.EnableHealthEndpoints()
+	release := make(chan struct{})
+	defer close(release)
+	s.AddHealthCheck("stuck", func(context.Context) error {
+		<-release
+		return nil
+	})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	code, response := health(t, s, ctx, DefaultReadinessPath)
+	if code != http.StatusServiceUnavailable || len(response.Checks) != 1 || response.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("stuck check: %d %+v", code, response)
+	}
+	if latency := response.Checks[0].LatencyMs; latency < 50 {
+		t.Errorf("latency %vms, want at least the 50ms of the context", latency)
+	}
+}
+
+// TestHealthChecksConcurrent checks the checks run concurrently: each one
+// waits for the other.
+func TestHealthChecksConcurrent(t *testing.T) {
+	s := newTestServer()
+	s.EnableHealthEndpoints()
+	cache, queue := make(chan struct{}), make(chan struct{})
+	rendezvous := func(own, other chan struct{}) func(context.Context) error {
+		return func(ctx context.Context) error {
+			close(own)
+			select {
+			case <-other:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	s.AddHealthCheck("cache", rendezvous(cache, queue))
+	s.AddHealthCheck("queue", rendezvous(queue, cache))
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if code, response := health(t, s, ctx, DefaultReadinessPath); code != http.StatusOK {
+		t.Errorf("concurrent checks: %d %+v", code, response)
+	}
+}
+
+// TestHealthEndpointsPaths registers the endpoints on other paths.
+func TestHealthEndpointsPaths(t *testing.T) {
+	s := newTestServer()
+	s.EnableHealthEndpoints("/live", "ready/")
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	for _, path := range []string{"/live", "/ready"} {
+		if code, _ := health(t, s, context.Background(), path); code != http.StatusOK {
+			t.Errorf("%s: %d", path, code)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DefaultLivenessPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("default path: %d", w.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package serverlib
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Router registers the routes of a host, see Server.Host. Its routes are
+// served like the default ones, through the middlewares of Use, the sessions
+// and the templates of the server.
+type Router struct {
+	server *Server
+	// host is the host pattern, e.g. "admin.example.com" or "*.example.com",
+	// whose wildcard is stripped in suffix, e.g. ".example.com".
+	host   string
+	suffix string
+	mux    *http.ServeMux
+}
+
+// Host returns the router of the routes matching the host pattern only, the
+// requests of the other hosts, or without a route of the router, being
+// served by the default routes:
+//
+//	admin := s.Host("admin.example.com")
+//	admin.GET("/", dashboard)
+//	s.Host("*.example.com").GET("/", tenantHome)
+//
+// The port of the Host header is ignored and the names are compared without
+// the case. A pattern starting with "*." matches every subdomain, of any
+// depth, but not the domain itself; the exact hosts are matched first, then
+// the longest wildcards. It panics on an empty pattern, or one with a port,
+// a path or a wildcard elsewhere.
+//
+// Parameters:
+//   - hostPattern: The host, e.g. "admin.example.com", or "*.example.com".
+//
+// Returns:
+//   - *Router: The router of the host, the same one for the same pattern.
+func (s *Server) Host(hostPattern string) *Router {
+	host := strings.TrimSuffix(strings.ToLower(hostPattern), ".")
+	suffix := ""
+	if rest, ok := strings.CutPrefix(host, "*"); ok {
+		suffix = rest
+	}
+	name := strings.TrimPrefix(suffix, ".")
+	if suffix == "" {
+		name = host
+	}
+	if name == "" || (suffix != "" && !strings.HasPrefix(suffix, ".")) || strings.ContainsAny(name, "*:/?#@ \t") {
+		panic(fmt.Sprintf("serverlib: invalid host pattern %q: expected a host, e.g. \"admin.example.com\", or \"*.example.com\"", hostPattern))
+	}
+
+	i := s.injector
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	for _, router := range i.hosts {
+		if router.host == host {
+			return router
+		}
+	}
+	slog.Info("Registred host", "host", host)
+	router := &Router{server: s, host: host, suffix: suffix, mux: http.NewServeMux()}
+	hosts := append(i.hosts[:len(i.hosts):len(i.hosts)], router)
+	// The exact hosts first, then the longest wildcards.
+	for j := len(hosts) - 1; j > 0 && hosts[j].before(hosts[j-1]); j-- {
+		hosts[j], hosts[j-1] = hosts[j-1], hosts[j]
+	}
+	i.hosts = hosts
+	return router
+}
+
+// before reports whether r is matched before other.
+func (r *Router) before(other *Router) bool {
+	if (r.suffix == "") != (other.suffix == "") {
+		return r.suffix == ""
+	}
+	return len(r.suffix) > len(other.suffix)
+}
+
+// matches reports whether the normalized host matches the pattern.
+func (r *Router) matches(host string) bool {
+	if r.suffix == "" {
+		return host == r.host
+	}
+	return len(host) > len(r.suffix) && strings.HasSuffix(host, r.suffix)
+}
+
+// hostMux returns the mux of the router of the host of r having a route for
+// r, or the default one.
+func (i *contextInjector) hostMux(r *http.Request) *http.ServeMux {
+	i.mut.RLock()
+	hosts := i.hosts
+	i.mut.RUnlock()
+	if len(hosts) == 0 {
+		return i.mux
+	}
+	host := redirectHost(r.Host)
+	for _, router := range hosts {
+		if !router.matches(host) {
+			continue
+		}
+		if _, pattern := router.mux.Handler(r); pattern != "" {
+			return router.mux
+		}
+	}
+	return i.mux
+}
+
+// route records a route of the router.
+func (r *Router) route(route *Route) *Route {
+	route.update(func(info *RouteInfo) { info.Host = r.host })
+	return route
+}
+
+// HandleFunc registers the function handling the requests of the host with
+// the given pattern, see Server.HandleFunc.
+func (r *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	slog.Info("Registred HandleFunc", "host", r.host, "pattern", pattern)
+	return r.route(r.server.handleFunc(r.mux, pattern, handler, mw))
+}
+
+// Handle registers the handler of the requests of the host with the given
+// pattern, see Server.Handle.
+func (r *Router) Handle(pattern string, handler http.Handler, mw ...Middleware) *Route {
+	slog.Info("Registred handle", "host", r.host, "pattern", pattern)
+	wrapped := wrapMiddlewares(handler, mw)
+	r.mux.Handle(pattern, wrapped)
+	return r.route(r.server.addHandlerRoute(pattern, wrapped, handler, mw))
+}
+
+// GET registers the handler of the GET requests of the host, see Server.GET.
+func (r *Router) GET(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodGet, pattern), handler, mw...)
+}
+
+// POST registers the handler of the POST requests of the host, see Server.GET.
+func (r *Router) POST(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodPost, pattern), handler, mw...)
+}
+
+// PUT registers the handler of the PUT requests of the host, see Server.GET.
+func (r *Router) PUT(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodPut, pattern), handler, mw...)
+}
+
+// PATCH registers the handler of the PATCH requests of the host, see Server.GET.
+func (r *Router) PATCH(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodPatch, pattern), handler, mw...)
+}
+
+// DELETE registers the handler of the DELETE requests of the host, see Server.GET.
+func (r *Router) DELETE(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodDelete, pattern), handler, mw...)
+}
+
+// HEAD registers the handler of the HEAD requests of the host, see Server.HEAD.
+func (r *Router) HEAD(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodHead, pattern), handler, mw...)
+}
+
+// OPTIONS registers the handler of the OPTIONS requests of the host, see
+// Server.OPTIONS.
+func (r *Router) OPTIONS(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return r.HandleFunc(methodPattern(http.MethodOptions, pattern), handler, mw...)
+}
@@ -0,0 +1,85 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHost routes the requests to the routers of their host, the exact
+// hosts first, or else to the default routes.
+func TestHost(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Use(tag("use"))
+	answer := func(name string) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}
+	}
+	s.GET("/", answer("default"))
+	s.GET("/shared", answer("default shared"))
+	s.Host("*.example.com").GET("/{$}", answer("tenant"))
+	s.Host("*.example.com").GET("/shop", answer("tenant shop"))
+	s.Host("admin.example.com").GET("/{$}", answer("admin"))
+	s.Host("*.eu.example.com").GET("/", answer("eu tenant"))
+	s.Host("API.example.org.").GET("/", answer("api"))
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{"admin.example.com", "/", "admin"},
+		{"ADMIN.Example.com:8443", "/", "admin"},
+		{"admin.example.com.", "/", "admin"},
+		{"shop.example.com", "/", "tenant"},
+		{"a.b.example.com", "/", "tenant"},
+		{"shop.eu.example.com", "/", "eu tenant"},
+		{"example.com", "/", "default"},
+		{"api.example.org", "/", "api"},
+		{"other.org", "/", "default"},
+		{"admin.example.com", "/shared", "default shared"},
+		{"admin.example.com", "/shop", "tenant shop"},
+		{"example.com", "/shop", "default"},
+		{"evil.com/x", "/", "default"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Body.String() != tt.want || w.Header().Get("X-Trace") != "use" {
+			t.Errorf("%s%s: %q trace %q, want %q", tt.host, tt.path, w.Body.String(), w.Header().Get("X-Trace"), tt.want)
+		}
+	}
+}
+
+// TestHostRouter checks a pattern returns the same router, and its routes
+// are described with their host.
+func TestHostRouter(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	if s.Host("admin.example.com") != s.Host("Admin.Example.com.") {
+		t.Error("routers differ for the same pattern")
+	}
+	s.Host("admin.example.com").GET("/settings", listItems).Name("settings")
+	routes := s.Routes()
+	if len(routes) != 1 || routes[0].Host != "admin.example.com" || routes[0].Path != "/settings" {
+		t.Errorf("routes %+v", routes)
+	}
+	if url, err := s.URL("settings"); err != nil || url != "/settings" {
+		t.Errorf("URL %q, %v", url, err)
+	}
+}
+
+// TestHostInvalidPattern checks the invalid host patterns panic.
+func TestHostInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"", ".", "*", "*.", "*example.com", "a.*.example.com", "example.com:80", "example.com/path", "user@example.com", "exa mple.com"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: no panic", pattern)
+				}
+			}()
+			NewServer(ServerConfig{}).Host(pattern)
+		}()
+	}
+}
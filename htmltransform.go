@@ -0,0 +1,245 @@
+package serverlib
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DefaultHTMLTransformBytes is the size of the largest HTML response transformed
+// by HTMLPostProcess, larger responses pass through untouched.
+const DefaultHTMLTransformBytes = 2 << 20 // 2 MiB
+
+// HTMLTransformer rewrites an HTML document. Returning an error leaves the
+// document untouched.
+type HTMLTransformer func(document []byte) ([]byte, error)
+
+// InjectBeforeTag returns a transformer inserting the snippet before the first
+// occurrence of tag, e.g. "</body>" or "<main>". Documents without the tag are
+// left untouched. The snippet is inserted as is, it must be trusted HTML.
+func InjectBeforeTag(tag, snippet string) HTMLTransformer {
+	end := strings.HasPrefix(tag, "</")
+	name := strings.ToLower(strings.Trim(tag, "</>"))
+	return func(document []byte) ([]byte, error) {
+		var out bytes.Buffer
+		z := html.NewTokenizer(bytes.NewReader(document))
+		injected := false
+		consumed := 0
+		for {
+			tokenType := z.Next()
+			if tokenType == html.ErrorToken {
+				return tokenizerEnd(z, &out, document, consumed)
+			}
+			raw := z.Raw()
+			consumed += len(raw)
+			if !injected && ((end && tokenType == html.EndTagToken) || (!end && (tokenType == html.StartTagToken || tokenType == html.SelfClosingTagToken))) {
+				// TagName lowercases the raw bytes in place.
+				raw = append([]byte(nil), raw...)
+				if tagName, _ := z.TagName(); string(tagName) == name {
+					out.WriteString(snippet)
+					injected = true
+				}
+			}
+			out.Write(raw)
+		}
+	}
+}
+
+// RewriteAttributeHost returns a transformer replacing the host of the URLs
+// of the attribute attr (e.g. "src") equal to fromHost by toHost, e.g. to
+// serve the assets from a CDN. Relative URLs are left untouched.
+func RewriteAttributeHost(attr, fromHost, toHost string) HTMLTransformer {
+	attr = strings.ToLower(attr)
+	return func(document []byte) ([]byte, error) {
+		var out bytes.Buffer
+		z := html.NewTokenizer(bytes.NewReader(document))
+		consumed := 0
+		for {
+			tokenType := z.Next()
+			if tokenType == html.ErrorToken {
+				return tokenizerEnd(z, &out, document, consumed)
+			}
+			consumed += len(z.Raw())
+			if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+				out.Write(z.Raw())
+				continue
+			}
+			raw := append([]byte(nil), z.Raw()...)
+			token := z.Token()
+			rewritten := false
+			for i, attribute := range token.Attr {
+				if attribute.Namespace != "" || attribute.Key != attr {
+					continue
+				}
+				u, err := url.Parse(strings.TrimSpace(attribute.Val))
+				if err != nil || !strings.EqualFold(u.Host, fromHost) {
+					continue
+				}
+				u.Host = toHost
+				token.Attr[i].Val = u.String()
+				rewritten = true
+			}
+			if rewritten {
+				out.WriteString(token.String())
+			} else {
+				out.Write(raw)
+			}
+		}
+	}
+}
+
+// tokenizerEnd completes the output of a transformer when the tokenizer stops.
+// The tokenizer drops a truncated tag at the end of malformed documents:
+// the bytes it did not return are copied as is.
+func tokenizerEnd(z *html.Tokenizer, out *bytes.Buffer, document []byte, consumed int) ([]byte, error) {
+	if err := z.Err(); err != io.EOF {
+		return nil, err
+	}
+	if consumed < len(document) {
+		out.Write(document[consumed:])
+	}
+	return out.Bytes(), nil
+}
+
+// HTMLPostProcess returns a middleware applying the transformers, in order,
+// to the HTML responses of the handlers it wraps, mounted handlers included.
+// Only uncompressed text/html responses up to maxBytes (DefaultHTMLTransformBytes
+// when zero or less) are buffered and transformed, with a corrected
+// Content-Length; other responses are streamed untouched.
+//
+// Parameters:
+//   - maxBytes: The size of the largest response transformed.
+//   - transformers: The transformers, see InjectBeforeTag and RewriteAttributeHost.
+//
+// Returns:
+//   - Middleware: The post-processing middleware.
+func HTMLPostProcess(maxBytes int64, transformers ...HTMLTransformer) Middleware {
+	if maxBytes <= 0 {
+		maxBytes = DefaultHTMLTransformBytes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tw := &transformWriter{ResponseWriter: w, maxBytes: maxBytes, status: http.StatusOK}
+			next.ServeHTTP(tw, r)
+			tw.finish(transformers, serverOf(r))
+		})
+	}
+}
+
+// transformWriter buffers the HTML responses to transform. Until the handler
+// writes the headers or the body, nothing is sent.
+type transformWriter struct {
+	http.ResponseWriter
+	maxBytes int64
+	status   int
+	decided  bool
+	// buffering is set when the response is an HTML document to transform.
+	buffering bool
+	buf       bytes.Buffer
+}
+
+// decide chooses between buffering and passing through, from the headers and
+// the first bytes of the body.
+func (w *transformWriter) decide(first []byte) {
+	w.decided = true
+	header := w.Header()
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(first) > 0 {
+		contentType = http.DetectContentType(first)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	w.buffering = mediaType == "text/html" && header.Get("Content-Encoding") == "" &&
+		(err != nil || length <= w.maxBytes) &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *transformWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.status = status
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		// Informational responses are sent right away.
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.Header().Get("Content-Type") != "" || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(nil)
+	}
+}
+
+func (w *transformWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decide(p)
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(p)
+	}
+	if int64(w.buf.Len()+len(p)) > w.maxBytes {
+		// Too large: send what was buffered and stream the rest untouched.
+		w.buffering = false
+		w.ResponseWriter.WriteHeader(w.status)
+		if _, err := w.buf.WriteTo(w.ResponseWriter); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// finish transforms and sends the buffered document, the failed
+// transformations logged by s.
+func (w *transformWriter) finish(transformers []HTMLTransformer, s *Server) {
+	if !w.decided {
+		w.decide(nil)
+	}
+	if !w.buffering {
+		return
+	}
+	document := w.buf.Bytes()
+	for _, transform := range transformers {
+		transformed, err := transform(document)
+		if err != nil {
+			s.LogDebug("HTML transformation skipped", err.Error())
+			continue
+		}
+		document = transformed
+	}
+	if w.Header().Get("Trailer") == "" {
+		// A response with a length can't carry trailers over HTTP/1.1.
+		w.Header().Set("Content-Length", strconv.Itoa(len(document)))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(document)
+}
+
+// FlushError flushes the responses passed through. A document being
+// buffered is sent whole once transformed, flushing it is a no-op.
+func (w *transformWriter) FlushError() error {
+	if !w.decided {
+		w.decide(nil)
+	}
+	if w.buffering {
+		return nil
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *transformWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,150 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHTMLTransformers(t *testing.T) {
+	banner := `<div id="consent"></div>`
+	tests := []struct {
+		name        string
+		transformer HTMLTransformer
+		document    string
+		want        string
+	}{
+		{"before </body>", InjectBeforeTag("</body>", banner), "<html><body><p>hi</p></body></html>", "<html><body><p>hi</p>" + banner + "</body></html>"},
+		{"before <main>", InjectBeforeTag("<main>", banner), "<body><MAIN class=x>a</MAIN><main>b</main></body>", "<body>" + banner + "<MAIN class=x>a</MAIN><main>b</main></body>"},
+		{"first occurrence only", InjectBeforeTag("</p>", "!"), "<p>a</p><p>b</p>", "<p>a!</p><p>b</p>"},
+		{"uppercase end tag", InjectBeforeTag("</body>", banner), "<BODY>x</BODY>", "<BODY>x" + banner + "</BODY>"},
+		{"no tag", InjectBeforeTag("</body>", banner), "<p>fragment</p>", "<p>fragment</p>"},
+		{"in a comment", InjectBeforeTag("</body>", banner), "<!-- </body> --><body></body>", "<!-- </body> --><body>" + banner + "</body>"},
+		{"in a script", InjectBeforeTag("</body>", banner), `<script>var s = "</body>";</script></body>`, `<script>var s = "</body>";</script>` + banner + `</body>`},
+		{"host", RewriteAttributeHost("src", "example.com", "cdn.example.net"),
+			`<img src="https://example.com/a.png" alt=x><img src="/b.png"><img src="https://other.com/c.png">`,
+			`<img src="https://cdn.example.net/a.png" alt="x"><img src="/b.png"><img src="https://other.com/c.png">`},
+		{"host case", RewriteAttributeHost("SRC", "Example.com", "cdn.example.net"), `<script SRC="https://EXAMPLE.com/app.js?v=1"></script>`, `<script src="https://cdn.example.net/app.js?v=1"></script>`},
+		{"protocol relative", RewriteAttributeHost("href", "example.com", "cdn.example.net"), `<link href="//example.com/s.css" rel=stylesheet>`, `<link href="//cdn.example.net/s.css" rel="stylesheet">`},
+		{"self-closing", RewriteAttributeHost("src", "example.com", "cdn.example.net"), `<img src="http://example.com/a.png"/>`, `<img src="http://cdn.example.net/a.png"/>`},
+		{"other attribute", RewriteAttributeHost("src", "example.com", "cdn.example.net"), `<a href="https://example.com/">home</a>`, `<a href="https://example.com/">home</a>`},
+		{"untouched tokens", RewriteAttributeHost("src", "example.com", "cdn.example.net"), "<!DOCTYPE html>\n<P CLASS='x'>a &amp; b</P>", "<!DOCTYPE html>\n<P CLASS='x'>a &amp; b</P>"},
+		{"malformed", InjectBeforeTag("</body>", banner), "<body><p>unclosed <b>tags</body><div", "<body><p>unclosed <b>tags" + banner + "</body><div"},
+		{"truncated tag", RewriteAttributeHost("src", "example.com", "cdn.example.net"), `<p>x</p><img src="https://example.com/a`, `<p>x</p><img src="https://example.com/a`},
+	}
+	for _, tt := range tests {
+		got, err := tt.transformer([]byte(tt.document))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: %q %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// TestHTMLPostProcess serves responses through the middleware: only the HTML
+// responses under the cap are transformed, with their Content-Length.
+func TestHTMLPostProcess(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("x", 50) + "</body></html>"
+	injected := "<html><body>" + strings.Repeat("x", 50) + "<b>!</b></body></html>"
+	failing := func([]byte) ([]byte, error) { return nil, errors.New("broken transformer") }
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		method       string
+		transformers []HTMLTransformer
+		wantCode     int
+		wantBody     string
+		wantLength   string
+	}{
+		{"HTML", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		}, http.MethodGet, nil, http.StatusOK, injected, strconv.Itoa(len(injected))},
+		{"sniffed HTML", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(page))
+		}, http.MethodGet, nil, http.StatusOK, injected, strconv.Itoa(len(injected))},
+		{"status and stale Content-Length", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(page[:20]))
+			w.Write([]byte(page[20:]))
+		}, http.MethodGet, nil, http.StatusNotFound, injected, strconv.Itoa(len(injected))},
+		{"JSON", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"html":"</body>"}`))
+		}, http.MethodGet, nil, http.StatusOK, `{"html":"</body>"}`, ""},
+		{"compressed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(page))
+		}, http.MethodGet, nil, http.StatusOK, page, ""},
+		{"declared too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "1000")
+			w.Write([]byte(page))
+		}, http.MethodGet, nil, http.StatusOK, page, "1000"},
+		{"written too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			for range 10 {
+				w.Write([]byte(page))
+			}
+		}, http.MethodGet, nil, http.StatusOK, strings.Repeat(page, 10), ""},
+		{"HEAD", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+		}, http.MethodHead, nil, http.StatusOK, "", ""},
+		{"no content", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, http.MethodGet, nil, http.StatusNoContent, "", ""},
+		{"failing transformer", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+		}, http.MethodGet, []HTMLTransformer{failing}, http.StatusOK, injected, strconv.Itoa(len(injected))},
+		{"flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(page[:20]))
+			http.NewResponseController(w).Flush()
+			w.Write([]byte(page[20:]))
+		}, http.MethodGet, nil, http.StatusNotFound, injected, strconv.Itoa(len(injected))},
+		{"flushed JSON", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("[1,"))
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("flushed JSON: %v", err)
+			}
+			w.Write([]byte("2]"))
+		}, http.MethodGet, nil, http.StatusOK, "[1,2]", ""},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		transformers := append(tt.transformers, InjectBeforeTag("</body>", "<b>!</b>"))
+		s.HandleFunc("/", tt.handler, HTMLPostProcess(200, transformers...))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, "/", nil))
+		if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+		if got := w.Header().Get("Content-Length"); got != tt.wantLength {
+			t.Errorf("%s: Content-Length %q, want %q", tt.name, got, tt.wantLength)
+		}
+	}
+}
+
+// TestHTMLPostProcessMounted checks the middleware of Use transforms the
+// pages of a mounted handler.
+func TestHTMLPostProcessMounted(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Use(HTMLPostProcess(0, RewriteAttributeHost("src", "vendor.example.com", "cdn.example.net"), InjectBeforeTag("</body>", "<p>consent</p>")))
+	s.Mount("/vendor", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<body><img src="https://vendor.example.com/logo.png"></body>`))
+	}))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vendor/", nil))
+	if want := `<body><img src="https://cdn.example.net/logo.png"><p>consent</p></body>`; w.Body.String() != want {
+		t.Errorf("%q, want %q", w.Body.String(), want)
+	}
+}
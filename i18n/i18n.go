@@ -0,0 +1,187 @@
+// Package i18n holds the translated messages of an application, by locale,
+// and picks the locale of a request from its Accept-Language header.
+package i18n
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Catalog holds messages by locale and key. The locales are language tags
+// such as "fr" or "fr-CA", compared case-insensitively; a message missing
+// from a regional locale is looked up in its base language, then in the
+// fallback locale.
+type Catalog struct {
+	fallback string
+	messages map[string]map[string]string
+	mut      *sync.RWMutex
+}
+
+// NewCatalog creates an empty catalog.
+//
+// Parameters:
+//   - fallback: The locale of the messages missing from the other locales,
+//     e.g. "en".
+func NewCatalog(fallback string) *Catalog {
+	return &Catalog{
+		fallback: normalize(fallback),
+		messages: map[string]map[string]string{},
+		mut:      &sync.RWMutex{},
+	}
+}
+
+// normalize returns the canonical form of a locale: lower case, with dashes.
+func normalize(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// Fallback returns the fallback locale of the catalog.
+func (c *Catalog) Fallback() string {
+	return c.fallback
+}
+
+// Add adds the messages of a locale, replacing the ones with the same keys.
+// The messages may hold {name} placeholders, see Interpolate.
+func (c *Catalog) Add(locale string, messages map[string]string) {
+	locale = normalize(locale)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.messages[locale] == nil {
+		c.messages[locale] = map[string]string{}
+	}
+	for key, message := range messages {
+		c.messages[locale][key] = message
+	}
+}
+
+// Locales returns the locales having messages, sorted.
+func (c *Catalog) Locales() []string {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// Lookup returns the message of key in locale, its base language or the
+// fallback locale, and whether one was found.
+func (c *Catalog) Lookup(locale, key string) (string, bool) {
+	locale = normalize(locale)
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	candidates := []string{locale}
+	if base, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, base)
+	}
+	candidates = append(candidates, c.fallback)
+	for _, candidate := range candidates {
+		if message, ok := c.messages[candidate][key]; ok {
+			return message, true
+		}
+	}
+	return "", false
+}
+
+// Translate returns the message of key in locale (see Lookup) with its
+// placeholders replaced by params, or the key itself when no message is
+// found, so that a literal text can stand for a key.
+func (c *Catalog) Translate(locale, key string, params map[string]any) string {
+	message, ok := c.Lookup(locale, key)
+	if !ok {
+		message = key
+	}
+	return Interpolate(message, params)
+}
+
+// Match returns the locale of the catalog best matching an Accept-Language
+// header, e.g. "fr-CH, fr;q=0.9, en;q=0.8", or the fallback locale. A
+// regional tag matches its base language, and a base language the first of
+// its regional locales.
+func (c *Catalog) Match(acceptLanguage string) string {
+	locales := c.Locales()
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = normalize(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			// A quality is between 0 and 1, NaN being neither.
+			if err != nil || !(parsed >= 0 && parsed <= 1) {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	// The order of the header breaks the ties.
+	slices.SortStableFunc(tags, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	for _, tag := range tags {
+		if slices.Contains(locales, tag.tag) {
+			return tag.tag
+		}
+		base, _, _ := strings.Cut(tag.tag, "-")
+		if slices.Contains(locales, base) {
+			return base
+		}
+		for _, locale := range locales {
+			if strings.HasPrefix(locale, base+"-") {
+				return locale
+			}
+		}
+	}
+	return c.fallback
+}
+
+// Interpolate replaces the {name} placeholders of message with the values of
+// params, formatted with fmt.Sprint. The unknown placeholders are left as is.
+func Interpolate(message string, params map[string]any) string {
+	if len(params) == 0 || !strings.Contains(message, "{") {
+		return message
+	}
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(message, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(message[start:], '}')
+		if end < 0 {
+			break
+		}
+		name := message[start+1 : start+end]
+		value, ok := params[name]
+		b.WriteString(message[:start])
+		if ok {
+			b.WriteString(fmt.Sprint(value))
+		} else {
+			b.WriteString(message[start : start+end+1])
+		}
+		message = message[start+end+1:]
+	}
+	b.WriteString(message)
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package i18n
+
+import (
+	"slices"
+	"testing"
+)
+
+func newTestCatalog() *Catalog {
+	c := NewCatalog("en")
+	c.Add("en", map[string]string{"greeting": "Hello {name}", "bye": "Bye"})
+	c.Add("fr", map[string]string{"greeting": "Bonjour {name}"})
+	c.Add("pt-BR", map[string]string{"greeting": "Olá {name}"})
+	return c
+}
+
+func TestLookup(t *testing.T) {
+	c := newTestCatalog()
+	tests := []struct {
+		locale string
+		key    string
+		want   string
+		wantOK bool
+	}{
+		{"fr", "greeting", "Bonjour {name}", true},
+		{"FR", "greeting", "Bonjour {name}", true},
+		{"fr-CA", "greeting", "Bonjour {name}", true},
+		{"fr_CA", "greeting", "Bonjour {name}", true},
+		{"pt-BR", "greeting", "Olá {name}", true},
+		{"fr", "bye", "Bye", true},
+		{"de", "greeting", "Hello {name}", true},
+		{"fr", "missing", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := c.Lookup(tt.locale, tt.key)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s %s: %q %v, want %q %v", tt.locale, tt.key, got, ok, tt.want, tt.wantOK)
+		}
+	}
+	if got := c.Locales(); !slices.Equal(got, []string{"en", "fr", "pt-br"}) {
+		t.Errorf("Locales %v", got)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	c := newTestCatalog()
+	tests := []struct {
+		locale string
+		key    string
+		params map[string]any
+		want   string
+	}{
+		{"fr", "greeting", map[string]any{"name": "Ana"}, "Bonjour Ana"},
+		{"en", "greeting", nil, "Hello {name}"},
+		{"fr", "is taken", nil, "is taken"},
+		{"fr", "{n} left", map[string]any{"n": 3}, "3 left"},
+	}
+	for _, tt := range tests {
+		if got := c.Translate(tt.locale, tt.key, tt.params); got != tt.want {
+			t.Errorf("%s %s: %q, want %q", tt.locale, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	c := newTestCatalog()
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"fr", "fr"},
+		{"fr-CH, fr;q=0.9, en;q=0.8", "fr"},
+		{"en;q=0.5, fr;q=0.8", "fr"},
+		{"fr;q=0.8, en;q=0.8", "fr"},
+		{"pt", "pt-br"},
+		{"pt-PT", "pt-br"},
+		{"de, ja", "en"},
+		{"fr;q=0", "en"},
+		{"fr;q=2, en", "en"},
+		{"fr;q=NaN", "en"},
+		{"*", "en"},
+	}
+	for _, tt := range tests {
+		if got := c.Match(tt.header); got != tt.want {
+			t.Errorf("%q: %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	tests := []struct {
+		message string
+		params  map[string]any
+		want    string
+	}{
+		{"at least {min} characters", map[string]any{"min": 8}, "at least 8 characters"},
+		{"{field} and {field}", map[string]any{"field": "email"}, "email and email"},
+		{"{unknown} stays", map[string]any{"min": 8}, "{unknown} stays"},
+		{"unclosed {min", map[string]any{"min": 8}, "unclosed {min"},
+		{"no placeholder", map[string]any{"min": 8}, "no placeholder"},
+		{"{min}", nil, "{min}"},
+	}
+	for _, tt := range tests {
+		if got := Interpolate(tt.message, tt.params); got != tt.want {
+			t.Errorf("%q: %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
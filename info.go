@@ -0,0 +1,221 @@
+package serverlib
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Morditux/serverlib/sessions"
+	"github.com/Morditux/serverlib/units"
+)
+
+// modulePath is the module path of the library, used to find its version in the build info.
+const modulePath = "github.com/Morditux/serverlib"
+
+// redacted replaces the values of the sensitive configuration fields.
+const redacted = "[REDACTED]"
+
+// RuntimeInfo describes the running server, see EnableInfoEndpoint.
+type RuntimeInfo struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"go_version"`
+	Build     map[string]string `json:"build,omitempty"`
+	Profile   string            `json:"profile"`
+	Features  map[string]any    `json:"features"`
+	Routes    int               `json:"routes"`
+	Uptime    string            `json:"uptime"`
+	Config    map[string]any    `json:"config"`
+}
+
+// Info returns the runtime information of the server. The configuration is
+// redacted: fields tagged `sensitive:"true"` are masked, functions and
+// interfaces are reported by type only, and the TLS settings are summarized
+// without their keys.
+func (s *Server) Info() RuntimeInfo {
+	info := RuntimeInfo{
+		Version:   "(devel)",
+		GoVersion: runtime.Version(),
+		Profile:   s.profile.String(),
+		Features: map[string]any{
+			"sessions":           fmt.Sprintf("%T", s.sessionManager),
+			"tls":                s.httpServer.Load().TLSConfig != nil,
+			"auto_tls":           s.config.AutoTLS != nil,
+			"h2c":                s.config.EnableH2C,
+			"maintenance":        s.InMaintenance(),
+			"reuse_port":         s.config.SocketOptions != nil && s.config.SocketOptions.ReusePort,
+			"proxy_protocol":     s.config.AcceptProxyProtocol != nil,
+			"draining":           s.Draining(),
+			"state":              s.State().String(),
+			"temp_dir":           s.config.TempDir != nil,
+			"client_auth":        s.httpServer.Load().TLSConfig != nil && s.httpServer.Load().TLSConfig.ClientAuth != tls.NoClientCert,
+			"metrics":            false,
+			"dev_reload":         s.devReload != nil,
+			"template_overrides": s.config.TemplateOverrides != nil,
+			"custom_errors":      s.errorHandler != nil,
+			"base_path":          s.basePath,
+		},
+		Routes: s.routeCount(),
+		Config: redactConfig(reflect.ValueOf(s.config)),
+	}
+	if s.devReload != nil {
+		info.Features["template_watcher"] = s.t.WatcherStats()
+	}
+	if s.injector.breakers != nil {
+		info.Features["circuit_breakers"] = s.injector.breakers.States()
+	}
+	if s.injector.requests != nil {
+		info.Features["request_log"] = s.injector.requests.Capacity()
+	}
+	if s.injector.budget != nil {
+		info.Features["header_budget"] = s.injector.budget.stats()
+	}
+	if reporter, ok := s.sessionManager.(sessions.HealthReporter); ok {
+		info.Features["session_health"] = reporter.Health().String()
+	}
+	if started := s.started.Load(); started != nil {
+		info.Uptime = time.Since(*started).Round(time.Second).String()
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Build = map[string]string{"main": build.Main.Path}
+		if build.Main.Path == modulePath {
+			info.Version = build.Main.Version
+		}
+		for _, dep := range build.Deps {
+			if dep.Path == modulePath {
+				info.Version = dep.Version
+			}
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified", "GOOS", "GOARCH", "-race":
+				info.Build[setting.Key] = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// redactConfig returns the exported fields of the configuration struct v.
+func redactConfig(v reflect.Value) map[string]any {
+	config := make(map[string]any)
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Tag.Get("sensitive") == "true" {
+			config[field.Name] = redacted
+			continue
+		}
+		if field.Tag.Get("unit") == "bytes" && v.Field(i).CanInt() {
+			config[field.Name] = units.FormatBytes(v.Field(i).Int())
+			continue
+		}
+		config[field.Name] = redactValue(v.Field(i))
+	}
+	return config
+}
+
+// redactValue returns the reportable form of a configuration value.
+func redactValue(v reflect.Value) any {
+	switch value := v.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case *tls.Config:
+		if value == nil {
+			return nil
+		}
+		return map[string]any{
+			"certificates": len(value.Certificates),
+			"min_version":  tls.VersionName(value.MinVersion),
+			"client_auth":  value.ClientAuth.String(),
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.Interface()
+	case reflect.Struct:
+		return redactConfig(v)
+	case reflect.Slice, reflect.Array:
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = redactValue(v.Index(i))
+		}
+		return values
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Elem().Kind() == reflect.Struct && hasExportedFields(v.Elem().Type()) {
+			return redactValue(v.Elem())
+		}
+		return v.Type().String()
+	default:
+		// Functions, interfaces, maps, channels: their content may hold secrets.
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			return v.Elem().Type().String()
+		}
+		return v.Type().String()
+	}
+}
+
+// hasExportedFields reports whether the struct type t has exported fields.
+func hasExportedFields(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableInfoEndpoint registers a GET endpoint answering with the runtime
+// information of the server as JSON, see Info.
+//
+// Parameters:
+//   - path: The path of the endpoint, e.g. "/_info".
+//   - authorize: Reports whether the request may see the information; other
+//     requests get a 404 so the endpoint is not disclosed. A nil function
+//     denies every request.
+func (s *Server) EnableInfoEndpoint(path string, authorize func(*http.Request) bool) {
+	s.Handle("GET "+path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			s.Error(w, r, NewHTTPError(http.StatusNotFound, ""))
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if err := JSON(w, http.StatusOK, s.Info()); err != nil {
+			s.LogError("Writing info", err.Error())
+		}
+	}))
+}
+
+// logBanner logs a summary of the server information at startup.
+func (s *Server) logBanner() {
+	info := s.Info()
+	var b strings.Builder
+	fmt.Fprintf(&b, "serverlib %s (%s), profile %s, listening on %s\n", info.Version, info.GoVersion, info.Profile, strings.Join(s.Addrs(), ", "))
+	fmt.Fprintf(&b, "  routes: %d\n", info.Routes)
+	names := make([]string, 0, len(info.Features))
+	for name := range info.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s: %v\n", name, info.Features[name])
+	}
+	s.LogInfo("Server configuration", "\n"+strings.TrimSuffix(b.String(), "\n"))
+}
@@ -0,0 +1,212 @@
+package serverlib
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// dsnStringer holds a secret behind an interface.
+type dsnStringer struct {
+	DSN string
+}
+
+func (s dsnStringer) String() string { return s.DSN }
+
+type redactedOptions struct {
+	Name  string
+	Token string `sensitive:"true"`
+}
+
+type redactedConfig struct {
+	Address  string
+	Password string `sensitive:"true"`
+	Timeout  time.Duration
+	Size     int `unit:"bytes"`
+	Options  redactedOptions
+	Pointer  *redactedOptions
+	Nil      *redactedOptions
+	Mutex    *sync.Mutex
+	Hook     func() string
+	Store    fmt.Stringer
+	Headers  map[string]string
+	List     []redactedOptions
+	secret   string
+}
+
+func TestRedactConfig(t *testing.T) {
+	config := redactedConfig{
+		Address:  ":8080",
+		Password: "secret-password",
+		Timeout:  90 * time.Second,
+		Size:     2 << 20,
+		Options:  redactedOptions{Name: "a", Token: "secret-token"},
+		Pointer:  &redactedOptions{Name: "b", Token: "secret-pointer"},
+		Mutex:    &sync.Mutex{},
+		Hook:     func() string { return "secret-hook" },
+		Store:    dsnStringer{DSN: "postgres://user:secret-dsn@db"},
+		Headers:  map[string]string{"Authorization": "secret-header"},
+		List:     []redactedOptions{{Name: "c", Token: "secret-list"}},
+		secret:   "secret-unexported",
+	}
+	got := redactConfig(reflect.ValueOf(config))
+	want := map[string]any{
+		"Address":  ":8080",
+		"Password": redacted,
+		"Timeout":  "1m30s",
+		"Size":     "2 MiB",
+		"Options":  map[string]any{"Name": "a", "Token": redacted},
+		"Pointer":  map[string]any{"Name": "b", "Token": redacted},
+		"Nil":      nil,
+		"Mutex":    "*sync.Mutex",
+		"Hook":     "func() string",
+		"Store":    "serverlib.dsnStringer",
+		"Headers":  "map[string]string",
+		"List":     []any{map[string]any{"Name": "c", "Token": redacted}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactConfig:\n%v\nwant\n%v", got, want)
+	}
+	encoded, _ := json.Marshal(got)
+	if strings.Contains(string(encoded), "secret") {
+		t.Errorf("secret leaked: %s", encoded)
+	}
+}
+
+func TestInfoFeatures(t *testing.T) {
+	config := ServerConfig{
+		TLSConfig:    &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{[]byte("cert")}, PrivateKey: "secret-key"}}},
+		ClientAuth:   &ClientAuth{Mode: tls.VerifyClientCertIfGiven},
+		EnableH2C:    true,
+		Profile:      Development,
+		ErrorHandler: func(http.ResponseWriter, *http.Request, error) {},
+		BasePath:     "/app",
+	}
+	tests := []struct {
+		name   string
+		server func() *Server
+		want   map[string]any
+	}{
+		{"defaults", func() *Server { return NewServer(ServerConfig{}) }, map[string]any{
+			"tls": false, "auto_tls": false, "h2c": false, "client_auth": false, "dev_reload": false,
+			"template_overrides": false, "custom_errors": false, "proxy_protocol": false, "reuse_port": false,
+			"temp_dir": false, "maintenance": false, "draining": false, "metrics": false, "base_path": "",
+			"state": "created", "sessions": "*sessions.MemorySessions",
+		}},
+		{"enabled", func() *Server { return NewServer(config) }, map[string]any{
+			"tls": true, "client_auth": true, "h2c": true, "dev_reload": true, "custom_errors": true, "base_path": "/app",
+		}},
+		{"maintenance", func() *Server {
+			s := NewServer(ServerConfig{})
+			s.SetMaintenance(true)
+			return s
+		}, map[string]any{"maintenance": true}},
+		{"probed sessions", func() *Server {
+			return NewServer(ServerConfig{SessionManager: sessions.NewProbedSessions(sessions.NewMemorySessions(), sessions.ProbeOptions{})})
+		}, map[string]any{"sessions": "*sessions.ProbedSessions", "session_health": "healthy"}},
+	}
+	for _, tt := range tests {
+		info := tt.server().Info()
+		for name, want := range tt.want {
+			if got, ok := info.Features[name]; !ok || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: feature %s %v, want %v", tt.name, name, got, want)
+			}
+		}
+	}
+	info := NewServer(ServerConfig{}).Info()
+	if _, ok := info.Features["template_watcher"]; ok {
+		t.Error("template watcher reported outside of the Development profile")
+	}
+	if info.GoVersion == "" || info.Version == "" || info.Profile != "production" {
+		t.Errorf("info %+v", info)
+	}
+}
+
+func TestInfoEndpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		authorize func(*http.Request) bool
+		want      int
+	}{
+		{"no authorization", nil, http.StatusNotFound},
+		{"denied", func(*http.Request) bool { return false }, http.StatusNotFound},
+		{"authorized", func(r *http.Request) bool { return r.Header.Get("X-Ops") == "1" }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{[]byte("cert")}, PrivateKey: "secret-key"}}},
+		})
+		s.GET("/a", func(http.ResponseWriter, *http.Request) {})
+		s.EnableInfoEndpoint("/_info", tt.authorize)
+		r := httptest.NewRequest(http.MethodGet, "/_info", nil)
+		r.Header.Set("X-Ops", "1")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.want)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var info RuntimeInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(w.Body.String(), "secret") || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: %s %s", tt.name, w.Header().Get("Cache-Control"), w.Body.String())
+		}
+		tlsConfig, _ := info.Config["TLSConfig"].(map[string]any)
+		if tlsConfig["certificates"] != 1.0 || info.Routes != 2 {
+			t.Errorf("%s: TLS %v, %d routes, want a certificate and 2 routes", tt.name, tlsConfig, info.Routes)
+		}
+	}
+}
+
+func TestInfoBanner(t *testing.T) {
+	logs := &syncBuffer{}
+	s := NewServer(ServerConfig{ErrorLog: log.New(logs, "", 0), LogLevel: Info, EnableH2C: true})
+	s.logBanner()
+	for _, want := range []string{"serverlib ", "profile production", "routes: 0", "h2c: true", "tls: false"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("banner %q without %q", logs.String(), want)
+		}
+	}
+}
+
+// uncountedSessions is a store not counting its sessions.
+type uncountedSessions struct {
+	sessions.Sessions
+}
+
+// TestStatsSessions checks the sessions are counted through the decorators.
+func TestStatsSessions(t *testing.T) {
+	memory := sessions.NewMemorySessions()
+	memory.New()
+	memory.New()
+	tests := []struct {
+		name  string
+		store sessions.Sessions
+		want  int
+	}{
+		{"memory", memory, 2},
+		{"probed", sessions.NewProbedSessions(memory, sessions.ProbeOptions{}), 2},
+		{"uncounted", uncountedSessions{memory}, -1},
+		{"probed, uncounted", sessions.NewProbedSessions(uncountedSessions{memory}, sessions.ProbeOptions{}), -1},
+	}
+	for _, tt := range tests {
+		if got := NewServer(ServerConfig{SessionManager: tt.store}).Stats().Sessions; got != tt.want {
+			t.Errorf("%s: %d sessions, want %d", tt.name, got, tt.want)
+		}
+	}
+}
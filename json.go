@@ -0,0 +1,48 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JSONOption configures a call to JSON.
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	contentType string
+}
+
+// AsProblem makes JSON send the body as an RFC 9457 problem document,
+// with the application/problem+json content type.
+func AsProblem() JSONOption {
+	return func(o *jsonOptions) {
+		o.contentType = "application/problem+json; charset=utf-8"
+	}
+}
+
+// JSON writes v as a JSON response with the given status code.
+// The Content-Type is always set to application/json; charset=utf-8,
+// unless an option selects another JSON media type.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - status: The HTTP status code of the response.
+//   - v: The value to encode.
+//   - opts: Optional JSON options.
+//
+// Returns:
+//   - error: An error if v could not be encoded or written.
+func JSON(w http.ResponseWriter, status int, v any, opts ...JSONOption) error {
+	options := jsonOptions{contentType: "application/json; charset=utf-8"}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", options.contentType)
+	w.WriteHeader(status)
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
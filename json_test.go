@@ -0,0 +1,42 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          int
+		v               any
+		opts            []JSONOption
+		wantContentType string
+		wantBody        string
+		wantErr         bool
+	}{
+		{"object", http.StatusOK, map[string]int{"n": 1}, nil, "application/json; charset=utf-8", "{\"n\":1}\n", false},
+		{"status", http.StatusCreated, []string{"a"}, nil, "application/json; charset=utf-8", "[\"a\"]\n", false},
+		{"problem", http.StatusNotFound, map[string]string{"title": "Not Found"}, []JSONOption{AsProblem()}, "application/problem+json; charset=utf-8", "{\"title\":\"Not Found\"}\n", false},
+		{"not encodable", http.StatusOK, func() {}, nil, "", "", true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		// The Content-Type of the handler is replaced, the body being JSON.
+		w.Header().Set("Content-Type", "text/html")
+		err := JSON(w, tt.status, tt.v, tt.opts...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want an error %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "text/html" {
+				t.Errorf("%s: the failed encoding wrote %q with the Content-Type %q", tt.name, w.Body.String(), w.Header().Get("Content-Type"))
+			}
+			continue
+		}
+		if w.Code != tt.status || w.Header().Get("Content-Type") != tt.wantContentType || w.Body.String() != tt.wantBody {
+			t.Errorf("%s: %d %q %q, want %d %q %q", tt.name, w.Code, w.Header().Get("Content-Type"), w.Body.String(), tt.status, tt.wantContentType, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package serverlib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultJSONStreamFlushInterval is the longest time the elements written to a
+// JSONStreamWriter wait in its buffer before being sent to the client.
+const DefaultJSONStreamFlushInterval = time.Second
+
+// jsonStreamBufferSize is the size of the buffer of a JSONStreamWriter.
+const jsonStreamBufferSize = 32 << 10 // 32 KiB
+
+// ErrJSONStreamState is returned by the JSONStreamWriter methods called out of
+// order, e.g. Write outside of an array.
+var ErrJSONStreamState = errors.New("json stream: call out of order")
+
+// jsonFrame is an array or an object open in a JSONStreamWriter.
+type jsonFrame struct {
+	object bool
+	count  int
+}
+
+// JSONStreamWriter writes a JSON document element by element, without holding
+// it in memory, see JSONStream.
+type JSONStreamWriter struct {
+	w         http.ResponseWriter
+	status    int
+	ctx       context.Context
+	buf       *bufio.Writer
+	frames    []jsonFrame
+	lastFlush time.Time
+	err       error
+}
+
+// JSONStream returns a writer streaming a large JSON document, e.g. an export
+// of many rows, as application/json with the given status. The status is sent
+// with the first element: an encoding error past that point can't be answered
+// with an error status anymore, the connection is aborted with
+// http.ErrAbortHandler instead, after logging the index of the element.
+//
+//	stream := serverlib.JSONStream(w, http.StatusOK).WithContext(r.Context())
+//	stream.BeginObject()
+//	stream.Field("meta", meta)
+//	stream.BeginArrayField("items")
+//	for row := range rows {
+//		if err := stream.Write(row); err != nil {
+//			return
+//		}
+//	}
+//	stream.EndArray()
+//	stream.EndObject()
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - status: The HTTP status code of the response.
+//
+// Returns:
+//   - *JSONStreamWriter: The stream writer.
+func JSONStream(w http.ResponseWriter, status int) *JSONStreamWriter {
+	return &JSONStreamWriter{w: w, status: status, ctx: context.Background()}
+}
+
+// WithContext sets the context checked between the elements, usually the
+// request context: once it is done, the writes fail with its error.
+func (s *JSONStreamWriter) WithContext(ctx context.Context) *JSONStreamWriter {
+	s.ctx = ctx
+	return s
+}
+
+// BeginArray opens an array, the document itself or an element of the
+// enclosing array.
+func (s *JSONStreamWriter) BeginArray() error {
+	return s.open('[', false)
+}
+
+// BeginObject opens an object, the document itself or an element of the
+// enclosing array.
+func (s *JSONStreamWriter) BeginObject() error {
+	return s.open('{', true)
+}
+
+// BeginArrayField opens an array as the field name of the enclosing object,
+// e.g. the items of a {"meta": ..., "items": [...]} envelope.
+func (s *JSONStreamWriter) BeginArrayField(name string) error {
+	if err := s.key(name); err != nil {
+		return err
+	}
+	s.frames = append(s.frames, jsonFrame{})
+	return s.writeByte('[')
+}
+
+// Field writes v as the field name of the enclosing object.
+func (s *JSONStreamWriter) Field(name string, v any) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.inObject() {
+		return fmt.Errorf("%w: Field outside of an object", ErrJSONStreamState)
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		s.abort("field "+name, err)
+	}
+	if err := s.key(name); err != nil {
+		return err
+	}
+	return s.write(value)
+}
+
+// Write writes v as the next element of the enclosing array, once the context
+// is checked. An error from the client connection or the context is returned,
+// and by every later call; an encoding error aborts the connection.
+func (s *JSONStreamWriter) Write(v any) error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.frames) == 0 || s.inObject() {
+		return fmt.Errorf("%w: Write outside of an array", ErrJSONStreamState)
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
+	frame := &s.frames[len(s.frames)-1]
+	value, err := json.Marshal(v)
+	if err != nil {
+		s.abort(fmt.Sprintf("element %d", frame.count), err)
+	}
+	if frame.count > 0 {
+		if err := s.writeByte(','); err != nil {
+			return err
+		}
+	}
+	frame.count++
+	if err := s.write(value); err != nil {
+		return err
+	}
+	return s.flushPeriodically()
+}
+
+// EndArray closes the enclosing array.
+func (s *JSONStreamWriter) EndArray() error {
+	return s.close(']', false)
+}
+
+// EndObject closes the enclosing object.
+func (s *JSONStreamWriter) EndObject() error {
+	return s.close('}', true)
+}
+
+// open writes the delimiter of a new array or object.
+func (s *JSONStreamWriter) open(delimiter byte, object bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.inObject() {
+		return fmt.Errorf("%w: value without a field name, see BeginArrayField", ErrJSONStreamState)
+	}
+	if len(s.frames) == 0 && s.started() {
+		return fmt.Errorf("%w: the document is complete", ErrJSONStreamState)
+	}
+	if len(s.frames) > 0 {
+		frame := &s.frames[len(s.frames)-1]
+		if frame.count > 0 {
+			if err := s.writeByte(','); err != nil {
+				return err
+			}
+		}
+		frame.count++
+	}
+	s.frames = append(s.frames, jsonFrame{object: object})
+	return s.writeByte(delimiter)
+}
+
+// close writes the delimiter of the enclosing array or object, and sends the
+// document once complete.
+func (s *JSONStreamWriter) close(delimiter byte, object bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.frames) == 0 || s.inObject() != object {
+		return fmt.Errorf("%w: %q doesn't close the enclosing value", ErrJSONStreamState, delimiter)
+	}
+	s.frames = s.frames[:len(s.frames)-1]
+	if err := s.writeByte(delimiter); err != nil {
+		return err
+	}
+	if len(s.frames) > 0 {
+		return nil
+	}
+	if err := s.writeByte('\n'); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// key writes the name of the next field of the enclosing object.
+func (s *JSONStreamWriter) key(name string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.inObject() {
+		return fmt.Errorf("%w: field %s outside of an object", ErrJSONStreamState, name)
+	}
+	frame := &s.frames[len(s.frames)-1]
+	if frame.count > 0 {
+		if err := s.writeByte(','); err != nil {
+			return err
+		}
+	}
+	frame.count++
+	key, _ := json.Marshal(name)
+	if err := s.write(key); err != nil {
+		return err
+	}
+	return s.writeByte(':')
+}
+
+// started reports whether the status was sent.
+func (s *JSONStreamWriter) started() bool {
+	return s.buf != nil
+}
+
+// complete reports whether the document was written to its end.
+func (s *JSONStreamWriter) complete() bool {
+	return s.buf != nil && len(s.frames) == 0 && s.err == nil
+}
+
+func (s *JSONStreamWriter) inObject() bool {
+	return len(s.frames) > 0 && s.frames[len(s.frames)-1].object
+}
+
+// start sends the headers on the first write.
+func (s *JSONStreamWriter) start() {
+	if s.started() {
+		return
+	}
+	s.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	s.w.Header().Del("Content-Length")
+	s.w.WriteHeader(s.status)
+	s.buf = bufio.NewWriterSize(s.w, jsonStreamBufferSize)
+	s.lastFlush = time.Now()
+}
+
+func (s *JSONStreamWriter) write(p []byte) error {
+	s.start()
+	if _, err := s.buf.Write(p); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+func (s *JSONStreamWriter) writeByte(c byte) error {
+	s.start()
+	if err := s.buf.WriteByte(c); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// flushPeriodically sends the buffered elements to the client when they
+// waited DefaultJSONStreamFlushInterval.
+func (s *JSONStreamWriter) flushPeriodically() error {
+	if time.Since(s.lastFlush) < DefaultJSONStreamFlushInterval {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *JSONStreamWriter) flush() error {
+	s.lastFlush = time.Now()
+	if err := s.buf.Flush(); err != nil {
+		s.err = err
+		return err
+	}
+	if err := http.NewResponseController(s.w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// abort logs the encoding error with the server answering, and aborts the
+// connection: the status is already sent, the client must not take the
+// truncated document for a complete one.
+func (s *JSONStreamWriter) abort(what string, err error) {
+	serverOfWriter(s.w).LogError("JSON stream aborted", fmt.Sprintf("encoding %s: %v", what, err))
+	panic(http.ErrAbortHandler)
+}
+
+// Streamer is a response of a typed handler (see HandleTyped) streamed with a
+// JSONStreamWriter instead of being encoded at once, see StreamFunc.
+type Streamer interface {
+	Stream(stream *JSONStreamWriter) error
+}
+
+// StreamFunc is a Streamer function, streaming a typed handler response:
+//
+//	serverlib.HandleTyped(s, "GET /export", func(ctx context.Context, req ExportRequest) (serverlib.StreamFunc, error) {
+//		return func(stream *serverlib.JSONStreamWriter) error { ... }, nil
+//	})
+type StreamFunc func(stream *JSONStreamWriter) error
+
+// Stream implements Streamer.
+func (f StreamFunc) Stream(stream *JSONStreamWriter) error {
+	return f(stream)
+}
@@ -0,0 +1,279 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type row struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// writeRows streams n rows as an array.
+func writeRows(stream *JSONStreamWriter, n int) error {
+	if err := stream.BeginArray(); err != nil {
+		return err
+	}
+	for i := range n {
+		if err := stream.Write(row{ID: i, Name: "row"}); err != nil {
+			return err
+		}
+	}
+	return stream.EndArray()
+}
+
+// rows returns the JSON array of n rows.
+func rows(n int) string {
+	elements := make([]string, n)
+	for i := range elements {
+		elements[i] = `{"id":` + strconv.Itoa(i) + `,"name":"row"}`
+	}
+	return "[" + strings.Join(elements, ",") + "]\n"
+}
+
+func TestJSONStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream func(stream *JSONStreamWriter) error
+		want   string
+	}{
+		{"no element", func(stream *JSONStreamWriter) error { return writeRows(stream, 0) }, "[]\n"},
+		{"one element", func(stream *JSONStreamWriter) error { return writeRows(stream, 1) }, rows(1)},
+		// More than the buffer of the stream.
+		{"many elements", func(stream *JSONStreamWriter) error { return writeRows(stream, 5000) }, rows(5000)},
+		{"envelope", func(stream *JSONStreamWriter) error {
+			stream.BeginObject()
+			stream.Field("meta", map[string]int{"total": 2})
+			stream.BeginArrayField("items")
+			stream.Write(row{ID: 1, Name: "a"})
+			stream.Write(row{ID: 2, Name: "b"})
+			stream.EndArray()
+			stream.Field("next", nil)
+			return stream.EndObject()
+		}, `{"meta":{"total":2},"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"next":null}` + "\n"},
+		{"envelope without items", func(stream *JSONStreamWriter) error {
+			stream.BeginObject()
+			stream.BeginArrayField("items")
+			stream.EndArray()
+			return stream.EndObject()
+		}, `{"items":[]}` + "\n"},
+		{"nested values", func(stream *JSONStreamWriter) error {
+			stream.BeginArray()
+			stream.Write(1)
+			stream.BeginArray()
+			stream.Write("<b>")
+			stream.EndArray()
+			stream.BeginObject()
+			stream.Field("k", true)
+			stream.EndObject()
+			stream.BeginArray()
+			stream.EndArray()
+			return stream.EndArray()
+		}, `[1,["\u003cb\u003e"],{"k":true},[]]` + "\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if err := tt.stream(JSONStream(w, http.StatusCreated)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := w.Body.String(); got != tt.want || !json.Valid(w.Body.Bytes()) {
+			t.Errorf("%s: %.200s, want %.200s", tt.name, got, tt.want)
+		}
+		if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json; charset=utf-8" || !w.Flushed {
+			t.Errorf("%s: %d %q, flushed %v", tt.name, w.Code, w.Header().Get("Content-Type"), w.Flushed)
+		}
+	}
+}
+
+// TestJSONStreamState calls the stream methods out of order: each call fails
+// with ErrJSONStreamState, without writing.
+func TestJSONStreamState(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare are the calls made before the failing one.
+		prepare func(stream *JSONStreamWriter)
+		call    func(stream *JSONStreamWriter) error
+	}{
+		{"Write outside of an array", nil, func(stream *JSONStreamWriter) error { return stream.Write(1) }},
+		{"Write in an object", func(stream *JSONStreamWriter) { stream.BeginObject() }, func(stream *JSONStreamWriter) error { return stream.Write(1) }},
+		{"Field outside of an object", func(stream *JSONStreamWriter) { stream.BeginArray() }, func(stream *JSONStreamWriter) error { return stream.Field("k", 1) }},
+		{"BeginArrayField in an array", func(stream *JSONStreamWriter) { stream.BeginArray() }, func(stream *JSONStreamWriter) error { return stream.BeginArrayField("k") }},
+		{"value without a field name", func(stream *JSONStreamWriter) { stream.BeginObject() }, func(stream *JSONStreamWriter) error { return stream.BeginArray() }},
+		{"EndObject closing an array", func(stream *JSONStreamWriter) { stream.BeginArray() }, func(stream *JSONStreamWriter) error { return stream.EndObject() }},
+		{"EndArray closing an object", func(stream *JSONStreamWriter) { stream.BeginObject() }, func(stream *JSONStreamWriter) error { return stream.EndArray() }},
+		{"EndArray without an array", nil, func(stream *JSONStreamWriter) error { return stream.EndArray() }},
+		{"second document", func(stream *JSONStreamWriter) { writeRows(stream, 1) }, func(stream *JSONStreamWriter) error { return stream.BeginArray() }},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		stream := JSONStream(w, http.StatusOK)
+		if tt.prepare != nil {
+			tt.prepare(stream)
+		}
+		flush := func() int {
+			if stream.buf != nil {
+				stream.buf.Flush()
+			}
+			return w.Body.Len()
+		}
+		written := flush()
+		if err := tt.call(stream); !errors.Is(err, ErrJSONStreamState) {
+			t.Errorf("%s: %v, want ErrJSONStreamState", tt.name, err)
+		}
+		if flush() != written {
+			t.Errorf("%s: wrote %q", tt.name, w.Body.String()[written:])
+		}
+	}
+}
+
+// TestJSONStreamEncodingError writes an element failing to encode in the
+// middle of the stream: the connection is aborted, the element logged.
+func TestJSONStreamEncodingError(t *testing.T) {
+	var logs syncBuffer
+	tests := []struct {
+		name   string
+		stream func(stream *JSONStreamWriter)
+		want   string
+	}{
+		{"element", func(stream *JSONStreamWriter) {
+			stream.BeginArray()
+			stream.Write(row{ID: 0})
+			stream.Write(row{ID: 1})
+			stream.Write(make(chan int))
+		}, "encoding element 2: json: unsupported type: chan int"},
+		{"field", func(stream *JSONStreamWriter) {
+			stream.BeginObject()
+			stream.Field("meta", func() {})
+		}, "encoding field meta: json: unsupported type: func()"},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{ErrorLog: log.New(&logs, "", 0), LogLevel: Error})
+		s.GET("/export", func(w http.ResponseWriter, r *http.Request) {
+			tt.stream(JSONStream(w, http.StatusOK).WithContext(r.Context()))
+			t.Errorf("%s: not aborted", tt.name)
+		})
+		ts := httptest.NewServer(s)
+		logs.Reset()
+		resp, err := http.Get(ts.URL + "/export")
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		ts.Close()
+		if err == nil {
+			t.Errorf("%s: the client got a complete response", tt.name)
+		}
+		if !strings.Contains(logs.String(), "JSON stream aborted") || !strings.Contains(logs.String(), tt.want) {
+			t.Errorf("%s: logged %q, want %q", tt.name, logs.String(), tt.want)
+		}
+	}
+}
+
+// TestJSONStreamCancelled checks the writes fail once the context is done,
+// and keep failing.
+func TestJSONStreamCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	stream := JSONStream(w, http.StatusOK).WithContext(ctx)
+	stream.BeginArray()
+	if err := stream.Write(1); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for _, call := range []func() error{func() error { return stream.Write(2) }, stream.EndArray} {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%v, want context.Canceled", err)
+		}
+	}
+	stream.buf.Flush()
+	if got := w.Body.String(); got != "[1" {
+		t.Errorf("wrote %q", got)
+	}
+}
+
+// TestHandleTypedStream streams the responses of a typed handler: the errors
+// returned before the first element are answered with their status, the
+// later ones and the incomplete documents abort the connection.
+func TestHandleTypedStream(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := NewServer(ServerConfig{})
+	type exportRequest struct {
+		Rows  int    `query:"rows"`
+		Fail  string `query:"fail"`
+		Empty bool   `query:"empty"`
+	}
+	HandleTyped(s, "GET /export", func(ctx context.Context, req exportRequest) (StreamFunc, error) {
+		if req.Empty {
+			return nil, nil
+		}
+		return func(stream *JSONStreamWriter) error {
+			switch req.Fail {
+			case "before":
+				return NewHTTPError(http.StatusNotFound, "no such export")
+			case "nothing":
+				return nil
+			}
+			stream.BeginArray()
+			for i := range req.Rows {
+				stream.Write(row{ID: i, Name: "row"})
+			}
+			switch req.Fail {
+			case "during":
+				return errors.New("database gone")
+			case "incomplete":
+				return nil
+			}
+			return stream.EndArray()
+		}, nil
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       string
+		// wantAbort is set when the client must not get a complete response.
+		wantAbort bool
+	}{
+		{"no element", "rows=0", http.StatusOK, "[]\n", false},
+		{"many elements", "rows=3000", http.StatusOK, rows(3000), false},
+		{"error before the first element", "fail=before", http.StatusNotFound, "no such export", false},
+		{"no streamer", "empty=true", http.StatusOK, "null", false},
+		{"nothing written", "fail=nothing", http.StatusInternalServerError, "Internal Server Error", false},
+		{"error during the stream", "rows=3&fail=during", http.StatusOK, "", true},
+		{"error after the first flush", "rows=3000&fail=during", http.StatusOK, "", true},
+		{"incomplete document", "rows=3&fail=incomplete", http.StatusOK, "", true},
+	}
+	for _, tt := range tests {
+		// Aborted before the buffer of the stream was sent, there is no response.
+		resp, err := http.Get(ts.URL + "/export?" + tt.query)
+		var body []byte
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if aborted := err != nil; aborted != tt.wantAbort || !aborted && status != tt.wantStatus {
+			t.Errorf("%s: %d %v, want %d, aborted %v", tt.name, status, err, tt.wantStatus, tt.wantAbort)
+			continue
+		}
+		if !tt.wantAbort && !bytes.Contains(body, []byte(tt.want)) {
+			t.Errorf("%s: %.200s, want %.200s", tt.name, body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDependencyCycle is returned by Server.Go when the dependencies of the
+// component would form a cycle.
+var ErrDependencyCycle = errors.New("component dependency cycle")
+
+// ErrComponentExists is returned by Server.Go for a name already registered.
+var ErrComponentExists = errors.New("component already registered")
+
+// ComponentOption configures a component started with Server.Go.
+type ComponentOption func(*component)
+
+// WithShutdownPhase sets the shutdown phase of the component: on Shutdown,
+// the components are stopped phase by phase, the lowest first. Defaults to 0.
+func WithShutdownPhase(phase int) ComponentOption {
+	return func(c *component) {
+		c.phase = phase
+	}
+}
+
+// DependsOn declares the components used by the component: it is stopped,
+// and waited for, before them. E.g. a webhook queue depends on the HTTP client
+// pool it sends with, so it drains before the pool closes. The dependencies
+// may be registered later; unknown names are ignored on Shutdown.
+func DependsOn(names ...string) ComponentOption {
+	return func(c *component) {
+		c.dependsOn = append(c.dependsOn, names...)
+	}
+}
+
+// component is a background component of the server.
+type component struct {
+	name      string
+	phase     int
+	dependsOn []string
+	// stopPhase is the phase the component is stopped in, see lifecycle.phases.
+	stopPhase int
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// lifecycle holds the components of the server.
+type lifecycle struct {
+	components map[string]*component
+	hooks      []func(context.Context) error
+	startHooks []func()
+	ready      chan struct{}
+	isReady    bool
+	closed     bool
+	mut        *sync.Mutex
+	server     *Server
+}
+
+func newLifecycle(s *Server) *lifecycle {
+	return &lifecycle{
+		server:     s,
+		components: map[string]*component{},
+		ready:      make(chan struct{}),
+		mut:        &sync.Mutex{},
+	}
+}
+
+// Go runs a background component of the server, such as a job scheduler, a
+// queue consumer or a snapshot writer, until Shutdown or Stop. fn must return
+// once its context is cancelled; on Shutdown, it is stopped in its phase (see
+// WithShutdownPhase and DependsOn) and given a share of the remaining time of
+// the shutdown. Stop cancels every component at once without waiting.
+//
+// Parameters:
+//   - name: The unique name of the component, used in the logs and by DependsOn.
+//   - fn: The component, running in its own goroutine.
+//   - opts: Optional component options.
+//
+// Returns:
+//   - error: ErrComponentExists or ErrDependencyCycle, wrapped, or
+//     http.ErrServerClosed after Shutdown or Stop.
+func (s *Server) Go(name string, fn func(ctx context.Context) error, opts ...ComponentOption) error {
+	c := &component{name: name, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(c)
+	}
+	l := s.components
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.closed {
+		return http.ErrServerClosed
+	}
+	if _, ok := l.components[name]; ok {
+		return fmt.Errorf("%w: %s", ErrComponentExists, name)
+	}
+	if path := l.cycle(c); path != nil {
+		return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(path, " -> "))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	l.components[name] = c
+	go func() {
+		defer close(c.done)
+		if err := fn(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			s.LogError("Component "+name+" failed", err.Error())
+		}
+	}()
+	slog.Info("Registred component", "name", name, "phase", c.phase, "depends_on", c.dependsOn)
+	return nil
+}
+
+// cycle returns the dependency path from the new component back to itself,
+// or nil. It is called with the lock held.
+func (l *lifecycle) cycle(c *component) []string {
+	visited := map[string]bool{}
+	var visit func(name string, path []string) []string
+	visit = func(name string, path []string) []string {
+		path = append(path, name)
+		if name == c.name && len(path) > 1 {
+			return path
+		}
+		if visited[name] {
+			return nil
+		}
+		visited[name] = true
+		dependsOn := c.dependsOn
+		if name != c.name {
+			dependency, ok := l.components[name]
+			if !ok {
+				return nil
+			}
+			dependsOn = dependency.dependsOn
+		}
+		for _, dependency := range dependsOn {
+			if found := visit(dependency, path); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return visit(c.name, nil)
+}
+
+// phases returns the components by stop order. A component is stopped in its
+// phase, or in a later one than the components depending on it.
+func (l *lifecycle) phases() [][]*component {
+	level := map[string]int{}
+	var resolve func(c *component) int
+	resolve = func(c *component) int {
+		if phase, ok := level[c.name]; ok {
+			return phase
+		}
+		phase := c.phase
+		for _, other := range l.components {
+			if slices.Contains(other.dependsOn, c.name) {
+				phase = max(phase, resolve(other)+1)
+			}
+		}
+		level[c.name] = phase
+		return phase
+	}
+	byPhase := map[int][]*component{}
+	for _, c := range l.components {
+		phase := resolve(c)
+		c.stopPhase = phase
+		byPhase[phase] = append(byPhase[phase], c)
+	}
+	order := make([]int, 0, len(byPhase))
+	for phase := range byPhase {
+		order = append(order, phase)
+	}
+	slices.Sort(order)
+	phases := make([][]*component, len(order))
+	for i, phase := range order {
+		phases[i] = byPhase[phase]
+		slices.SortFunc(phases[i], func(a, b *component) int { return strings.Compare(a.name, b.name) })
+	}
+	return phases
+}
+
+// close closes the lifecycle to new components and returns the stop order.
+func (l *lifecycle) close() [][]*component {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.closed = true
+	return l.phases()
+}
+
+// cancel cancels every component without waiting.
+func (l *lifecycle) cancel() {
+	for _, phase := range l.close() {
+		for _, c := range phase {
+			c.cancel()
+		}
+	}
+}
+
+// shutdown stops the components phase by phase. Each phase gets an equal share
+// of the time left before the deadline of ctx; the components exceeding it are
+// reported and left behind, the next phases still run.
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	phases := l.close()
+	var errs []error
+	for i, phase := range phases {
+		var budget time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			budget = time.Until(deadline) / time.Duration(len(phases)-i)
+		}
+		phaseCtx, cancel := context.WithCancel(ctx)
+		if budget > 0 {
+			cancel()
+			phaseCtx, cancel = context.WithTimeout(ctx, budget)
+		}
+		for _, c := range phase {
+			c.cancel()
+		}
+		for _, c := range phase {
+			select {
+			case <-c.done:
+			case <-phaseCtx.Done():
+			}
+		}
+		err := phaseCtx.Err()
+		cancel()
+		var late []string
+		for _, c := range phase {
+			select {
+			case <-c.done:
+			default:
+				late = append(late, c.name)
+				errs = append(errs, fmt.Errorf("component %s: %w", c.name, err))
+			}
+		}
+		if len(late) > 0 {
+			slog.Error("Components exceeded their shutdown budget", "phase", phase[0].stopPhase, "budget", budget, "components", late)
+			continue
+		}
+		l.server.LogDebug("Shutdown phase completed", fmt.Sprint(phase[0].stopPhase))
+	}
+	return errors.Join(errs...)
+}
+
+// OnShutdown registers a hook run by Shutdown, such as closing a database
+// pool, flushing the metrics or persisting the sessions. The hooks run once
+// the requests, the components and the background tasks completed, in the
+// reverse order of their registration like deferred calls: a hook registered
+// after the pool it uses runs before the hook closing the pool. Every hook
+// runs, even when the previous ones failed or the shutdown timed out. Stop
+// runs them too, but with a negative ServerConfig.ShutdownTimeout.
+//
+// Parameters:
+//   - hook: The hook, called with the context of Shutdown; a panic is
+//     reported as its error.
+func (s *Server) OnShutdown(hook func(ctx context.Context) error) {
+	l := s.components
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.hooks = append(l.hooks, hook)
+}
+
+// runHooks runs the shutdown hooks, once, and returns their errors joined.
+func (l *lifecycle) runHooks(ctx context.Context) error {
+	l.mut.Lock()
+	hooks := l.hooks
+	l.hooks = nil
+	l.mut.Unlock()
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := runHook(ctx, hooks[i]); err != nil {
+			l.server.LogError("Shutdown hook failed", err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runHook runs a shutdown hook, converting a panic into an error.
+func runHook(ctx context.Context, hook func(context.Context) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("shutdown hook panicked: %v", p)
+		}
+	}()
+	return hook(ctx)
+}
+
+// OnStart registers a hook run once the server is ready, see Ready, such as
+// registering the instance in a service discovery. The hooks run in the order
+// of their registration, in their own goroutine so that they can send
+// requests to the server. A hook registered after the server is ready runs
+// right away.
+func (s *Server) OnStart(hook func()) {
+	l := s.components
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.isReady {
+		go hook()
+		return
+	}
+	l.startHooks = append(l.startHooks, hook)
+}
+
+// Ready returns a channel closed once the listeners are bound and the
+// templates parsed, right before the connections are served, e.g. for the
+// tests to wait for the server instead of sleeping:
+//
+//	go server.Start()
+//	select {
+//	case <-server.Ready():
+//	case <-time.After(time.Second):
+//		t.Fatal("server not started")
+//	}
+//
+// Start, StartTLS, StartAutoTLS and Serve all close it the same way; when
+// they fail before, e.g. the address being in use, the channel stays open.
+func (s *Server) Ready() <-chan struct{} {
+	return s.components.ready
+}
+
+// markReady closes the ready channel and runs the start hooks, once.
+func (l *lifecycle) markReady() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.isReady {
+		return
+	}
+	l.isReady = true
+	close(l.ready)
+	hooks := l.startHooks
+	l.startHooks = nil
+	go func() {
+		for _, hook := range hooks {
+			hook()
+		}
+	}()
+}
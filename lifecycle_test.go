@@ -0,0 +1,366 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stopRecorder records the order the fake components stop in.
+type stopRecorder struct {
+	mut     sync.Mutex
+	stopped []string
+}
+
+// component returns a fake component recording its stop once cancelled.
+func (r *stopRecorder) component(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		<-ctx.Done()
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		r.stopped = append(r.stopped, name)
+		return ctx.Err()
+	}
+}
+
+// fakeComponent is the registration of a fake component.
+type fakeComponent struct {
+	name      string
+	phase     int
+	dependsOn []string
+}
+
+// TestShutdownPhases registers fake components and checks Shutdown stops
+// them phase by phase: each phase lists its components by name, stopped
+// after the ones of the previous phases.
+func TestShutdownPhases(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []fakeComponent
+		want       [][]string
+	}{
+		{"one phase", []fakeComponent{{"jobs", 0, nil}, {"hub", 0, nil}}, [][]string{{"hub", "jobs"}}},
+		{"explicit phases", []fakeComponent{{"snapshot", 2, nil}, {"janitor", 1, nil}, {"jobs", 0, nil}}, [][]string{{"jobs"}, {"janitor"}, {"snapshot"}}},
+		{"dependency", []fakeComponent{{"pool", 0, nil}, {"webhooks", 0, []string{"pool"}}}, [][]string{{"webhooks"}, {"pool"}}},
+		{"dependency registered later", []fakeComponent{{"webhooks", 0, []string{"pool"}}, {"pool", 0, nil}}, [][]string{{"webhooks"}, {"pool"}}},
+		{"dependency chain", []fakeComponent{
+			{"pool", 0, nil}, {"webhooks", 0, []string{"pool"}}, {"jobs", 0, []string{"webhooks"}}, {"hub", 0, nil},
+		}, [][]string{{"hub", "jobs"}, {"webhooks"}, {"pool"}}},
+		{"dependency in an earlier phase", []fakeComponent{{"snapshot", 0, nil}, {"janitor", 3, []string{"snapshot"}}}, [][]string{{"janitor"}, {"snapshot"}}},
+		{"unknown dependency", []fakeComponent{{"jobs", 0, []string{"missing"}}}, [][]string{{"jobs"}}},
+		{"shared dependency", []fakeComponent{
+			{"store", 0, nil}, {"janitor", 0, []string{"store"}}, {"snapshot", 1, []string{"store"}},
+		}, [][]string{{"janitor"}, {"snapshot"}, {"store"}}},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		recorder := &stopRecorder{}
+		for _, c := range tt.components {
+			if err := s.Go(c.name, recorder.component(c.name), WithShutdownPhase(c.phase), DependsOn(c.dependsOn...)); err != nil {
+				t.Fatalf("%s: %s: %v", tt.name, c.name, err)
+			}
+		}
+		done := startServer(s)
+		<-s.Ready()
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Errorf("%s: Shutdown: %v", tt.name, err)
+		}
+		waitStart(t, done)
+		stopped := recorder.stopped
+		for i, phase := range tt.want {
+			if len(stopped) < len(phase) {
+				t.Errorf("%s: phase %d stopped %v, want %v", tt.name, i, stopped, phase)
+				break
+			}
+			got := slices.Clone(stopped[:len(phase)])
+			slices.Sort(got)
+			if !slices.Equal(got, phase) {
+				t.Errorf("%s: phase %d stopped %v, want %v", tt.name, i, got, phase)
+			}
+			stopped = stopped[len(phase):]
+		}
+		var phases [][]string
+		for _, phase := range s.components.phases() {
+			var names []string
+			for _, c := range phase {
+				names = append(names, c.name)
+			}
+			phases = append(phases, names)
+		}
+		if !slices.EqualFunc(phases, tt.want, slices.Equal) {
+			t.Errorf("%s: phases %v, want %v", tt.name, phases, tt.want)
+		}
+	}
+}
+
+func TestGoErrors(t *testing.T) {
+	noop := func(ctx context.Context) error { <-ctx.Done(); return nil }
+	tests := []struct {
+		name       string
+		components []fakeComponent
+		// register is the component failing to register.
+		register fakeComponent
+		want     error
+		wantPath string
+	}{
+		{"duplicate", []fakeComponent{{"jobs", 0, nil}}, fakeComponent{"jobs", 1, nil}, ErrComponentExists, "jobs"},
+		{"self dependency", nil, fakeComponent{"jobs", 0, []string{"jobs"}}, ErrDependencyCycle, "jobs -> jobs"},
+		{"cycle", []fakeComponent{{"a", 0, []string{"b"}}}, fakeComponent{"b", 0, []string{"a"}}, ErrDependencyCycle, "b -> a -> b"},
+		{"indirect cycle", []fakeComponent{{"a", 0, []string{"b"}}, {"b", 0, []string{"c"}}}, fakeComponent{"c", 0, []string{"x", "a"}}, ErrDependencyCycle, "c -> a -> b -> c"},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		recorder := &stopRecorder{}
+		for _, c := range tt.components {
+			if err := s.Go(c.name, recorder.component(c.name), DependsOn(c.dependsOn...)); err != nil {
+				t.Fatalf("%s: %s: %v", tt.name, c.name, err)
+			}
+		}
+		err := s.Go(tt.register.name, noop, DependsOn(tt.register.dependsOn...))
+		if !errors.Is(err, tt.want) || !strings.HasSuffix(err.Error(), ": "+tt.wantPath) {
+			t.Errorf("%s: %v, want %v for %s", tt.name, err, tt.want, tt.wantPath)
+		}
+		if got := len(s.components.components); got != len(tt.components) {
+			t.Errorf("%s: %d components registered", tt.name, got)
+		}
+		// Stopped before Start, the components are cancelled.
+		s.Stop()
+		for _, c := range s.components.components {
+			<-c.done
+		}
+		if len(recorder.stopped) != len(tt.components) {
+			t.Errorf("%s: stopped %v", tt.name, recorder.stopped)
+		}
+		if err := s.Go("late", noop); !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("%s: Go after Stop: %v", tt.name, err)
+		}
+	}
+}
+
+// TestShutdownPhaseBudget runs a component ignoring the cancellation in the
+// first of two phases: it is reported once its half of the shutdown timeout
+// is exhausted, and the second phase still runs with the time left.
+func TestShutdownPhaseBudget(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	s := newTestServer()
+	release := make(chan struct{})
+	defer close(release)
+	s.Go("slow", func(ctx context.Context) error {
+		<-release
+		return nil
+	}, DependsOn("pool"))
+	recorder := &stopRecorder{}
+	s.Go("pool", recorder.component("pool"))
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.components.shutdown(ctx)
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "component slow") {
+		t.Errorf("error %v, want the slow component over budget", err)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > 350*time.Millisecond {
+		t.Errorf("stopped in %v, want about half of the timeout", elapsed)
+	}
+	if !slices.Equal(recorder.stopped, []string{"pool"}) {
+		t.Errorf("stopped %v, want the pool after the slow component", recorder.stopped)
+	}
+	if got := logs.String(); !strings.Contains(got, "Components exceeded their shutdown budget") || !strings.Contains(got, "components=[slow]") || !strings.Contains(got, "phase=0") {
+		t.Errorf("logs %q, want the slow component reported", got)
+	}
+}
+
+// TestShutdownWithoutDeadline checks the components are waited for when
+// the context of Shutdown has no deadline.
+func TestShutdownWithoutDeadline(t *testing.T) {
+	s := newTestServer()
+	var logs bytes.Buffer
+	stopped := make(chan struct{})
+	s.Go("flush", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		logs.WriteString("flushed")
+		close(stopped)
+		return nil
+	})
+	if err := s.components.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("shutdown returned before the component")
+	}
+	if logs.String() != "flushed" {
+		t.Errorf("logs %q", logs.String())
+	}
+}
+
+// TestOnShutdown registers hooks on a running server: Shutdown runs each of
+// them once, in the reverse order, after the components, even when some fail
+// or panic or the shutdown times out.
+func TestOnShutdown(t *testing.T) {
+	s := newTestServer()
+	var mut sync.Mutex
+	var ran []string
+	record := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			mut.Lock()
+			defer mut.Unlock()
+			ran = append(ran, name)
+			return err
+		}
+	}
+	recorder := &stopRecorder{}
+	s.Go("jobs", recorder.component("jobs"))
+	failure := errors.New("flush failed")
+	s.OnShutdown(record("pool", nil))
+	s.OnShutdown(record("metrics", failure))
+	s.OnShutdown(func(ctx context.Context) error {
+		record("components stopped: "+strings.Join(recorder.stopped, ","), nil)(ctx)
+		panic("boom")
+	})
+	s.OnShutdown(record("sessions", nil))
+	done := startServer(s)
+	<-s.Ready()
+	err := s.Shutdown(context.Background())
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "shutdown hook panicked: boom") {
+		t.Errorf("Shutdown: %v", err)
+	}
+	waitStart(t, done)
+	if want := []string{"sessions", "components stopped: jobs", "metrics", "pool"}; !slices.Equal(ran, want) {
+		t.Errorf("hooks %v, want %v", ran, want)
+	}
+	if err := s.Shutdown(context.Background()); err != nil || len(ran) != 4 {
+		t.Errorf("second Shutdown: %v, hooks %v", err, ran)
+	}
+
+	// The hooks run when the requests outlast the shutdown.
+	s = newTestServer()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s.GET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	ran = nil
+	s.OnShutdown(record("pool", nil))
+	done = startServer(s)
+	<-s.Ready()
+	go http.Get("http://" + s.Addr() + "/slow")
+	<-entered
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timed out Shutdown: %v", err)
+	}
+	if !slices.Equal(ran, []string{"pool"}) {
+		t.Errorf("hooks of the timed out Shutdown %v", ran)
+	}
+	s.Stop()
+	waitStart(t, done)
+
+	// Stop runs them but with a negative ShutdownTimeout.
+	for _, timeout := range []time.Duration{0, -1} {
+		s = NewServer(ServerConfig{Address: "127.0.0.1:0", ShutdownTimeout: timeout})
+		ran = nil
+		s.OnShutdown(record("pool", nil))
+		done = startServer(s)
+		<-s.Ready()
+		s.Stop()
+		waitStart(t, done)
+		if want := timeout >= 0; (len(ran) == 1) != want {
+			t.Errorf("Stop with ShutdownTimeout %v: hooks %v", timeout, ran)
+		}
+	}
+}
+
+// TestOnStart registers hooks before and after the start: they run once the
+// server is ready, in the order of their registration, and can send requests
+// to it.
+func TestOnStart(t *testing.T) {
+	s := newTestServer()
+	s.GET("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	ran := make(chan string, 4)
+	s.OnStart(func() {
+		select {
+		case <-s.Ready():
+			ran <- "ready"
+		default:
+			ran <- "not ready"
+		}
+	})
+	s.OnStart(func() {
+		resp, err := http.Get("http://" + s.Addr() + "/ping")
+		if err != nil {
+			ran <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		ran <- string(body)
+	})
+	select {
+	case name := <-ran:
+		t.Fatalf("hook %q run before the start", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+	done := startServer(s)
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	<-s.Ready()
+	// A hook registered once ready runs right away, alongside the others.
+	s.OnStart(func() { ran <- "late" })
+	var got []string
+	for range 3 {
+		select {
+		case name := <-ran:
+			got = append(got, name)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("hooks run %v", got)
+		}
+	}
+	late := slices.Index(got, "late")
+	if late < 0 || !slices.Equal(slices.Delete(got, late, late+1), []string{"ready", "pong"}) {
+		t.Errorf("hooks run %v", got)
+	}
+}
+
+// TestReadyBindError checks Ready stays open and the hooks don't run when
+// the server fails to start.
+func TestReadyBindError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := NewServer(ServerConfig{Address: l.Addr().String()})
+	ran := make(chan struct{}, 1)
+	s.OnStart(func() { ran <- struct{}{} })
+	if err := s.Start(); err == nil {
+		t.Fatal("started on an address in use")
+	}
+	select {
+	case <-s.Ready():
+		t.Error("ready after a failed start")
+	case <-ran:
+		t.Error("hook run after a failed start")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,296 @@
+package serverlib
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bypass is the class returned by a LimiterOptions.Classify function for the
+// requests that are never limited, such as health checks.
+const Bypass = -1
+
+// QueueClassHeader is the header naming the priority class of a shed request.
+const QueueClassHeader = "X-Queue-Class"
+
+// WaitBuckets are the upper bounds of the wait histograms of LimiterStats.
+// The last bucket counts the longer waits.
+var WaitBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// PriorityClass is a class of requests of the concurrency limiter.
+type PriorityClass struct {
+	Name string
+	// QueueDepth is the maximum number of requests of the class waiting for a
+	// slot. Zero sheds the requests of the class as soon as all slots are taken.
+	QueueDepth int
+	// MaxWait is the maximum time a request waits in the queue before it is shed.
+	MaxWait time.Duration
+}
+
+// DefaultPriorityClasses are the classes of the default classifier: the
+// interactive page requests first, then everything else.
+var DefaultPriorityClasses = []PriorityClass{
+	{Name: "interactive", QueueDepth: 64, MaxWait: 2 * time.Second},
+	{Name: "normal", QueueDepth: 32, MaxWait: time.Second},
+}
+
+// LimiterOptions configures the concurrency limiter, see ServerConfig.ConcurrencyLimit.
+type LimiterOptions struct {
+	// MaxConcurrent is the maximum number of requests served at once, at least one.
+	MaxConcurrent int
+	// Classes are the priority classes, by decreasing priority: a freed slot
+	// goes to the oldest waiter of the first non-empty class.
+	// Defaults to DefaultPriorityClasses.
+	Classes []PriorityClass
+	// Classify returns the index of the class of the request in Classes, or
+	// Bypass. Defaults to DefaultClassify.
+	Classify func(*http.Request) int
+	// RetryAfter is the delay advertised to the shed requests. Defaults to one second.
+	RetryAfter time.Duration
+}
+
+// DefaultClassify is the classifier of DefaultPriorityClasses: HTML GET
+// requests are interactive (0), health checks bypass the limiter, any other
+// request is normal (1).
+func DefaultClassify(r *http.Request) int {
+	switch r.URL.Path {
+	case "/healthz", "/livez", "/readyz":
+		return Bypass
+	}
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return 0
+	}
+	return 1
+}
+
+// OverloadError is the error of the requests shed by the concurrency limiter.
+type OverloadError struct {
+	// Class is the name of the priority class of the request.
+	Class string
+	// Retry is the delay advertised to the client.
+	Retry time.Duration
+}
+
+// Error implements the error interface.
+func (e *OverloadError) Error() string {
+	return "server overloaded, request of class " + e.Class + " shed"
+}
+
+// StatusCode returns the HTTP status code of a shed request.
+func (e *OverloadError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// RetryAfter returns the delay after which the client can retry.
+func (e *OverloadError) RetryAfter() time.Duration {
+	return e.Retry
+}
+
+// ClassStats describes a priority class of the concurrency limiter.
+type ClassStats struct {
+	Name     string
+	Queued   int
+	Admitted uint64
+	Shed     uint64
+	// Waits counts the admitted requests per wait, see WaitBuckets.
+	Waits []uint64
+}
+
+// LimiterStats describes the state of the concurrency limiter.
+type LimiterStats struct {
+	InFlight int
+	Classes  []ClassStats
+}
+
+// ConcurrencyLimiter limits the number of requests served at once, queueing
+// the others by priority class.
+type ConcurrencyLimiter struct {
+	options  LimiterOptions
+	inFlight int
+	queues   []*list.List
+	stats    []ClassStats
+	mut      *sync.Mutex
+}
+
+// waiter is a request waiting for a slot. granted is set under the limiter
+// lock when the slot of a finished request is handed over.
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewConcurrencyLimiter creates a concurrency limiter with the given options.
+func NewConcurrencyLimiter(options LimiterOptions) *ConcurrencyLimiter {
+	if len(options.Classes) == 0 {
+		options.Classes = DefaultPriorityClasses
+	}
+	if options.Classify == nil {
+		options.Classify = DefaultClassify
+	}
+	if options.RetryAfter <= 0 {
+		options.RetryAfter = time.Second
+	}
+	if options.MaxConcurrent <= 0 {
+		options.MaxConcurrent = 1
+	}
+	l := &ConcurrencyLimiter{
+		options: options,
+		queues:  make([]*list.List, len(options.Classes)),
+		stats:   make([]ClassStats, len(options.Classes)),
+		mut:     &sync.Mutex{},
+	}
+	for i, class := range options.Classes {
+		l.queues[i] = list.New()
+		l.stats[i] = ClassStats{Name: class.Name, Waits: make([]uint64, len(WaitBuckets)+1)}
+	}
+	return l
+}
+
+// Acquire waits for a slot for a request of the given class.
+// The returned function frees the slot.
+//
+// Parameters:
+//   - ctx: The request context, the wait ends when it is done.
+//   - class: The index of the priority class of the request.
+//
+// Returns:
+//   - func(): Frees the slot.
+//   - error: An *OverloadError if the request was shed, ctx.Err() if ctx was done first.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, class int) (func(), error) {
+	class = min(max(class, 0), len(l.queues)-1)
+	start := time.Now()
+	l.mut.Lock()
+	if l.inFlight < l.options.MaxConcurrent && l.queued() == 0 {
+		l.inFlight++
+		l.admitted(class, 0)
+		l.mut.Unlock()
+		return l.release, nil
+	}
+	if l.queues[class].Len() >= l.options.Classes[class].QueueDepth {
+		l.stats[class].Shed++
+		l.mut.Unlock()
+		return nil, l.overload(class)
+	}
+	w := &waiter{ready: make(chan struct{})}
+	element := l.queues[class].PushBack(w)
+	l.mut.Unlock()
+
+	timer := time.NewTimer(l.options.Classes[class].MaxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		err = l.overload(class)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if w.granted {
+		// The slot was handed over while the wait ended.
+		l.admitted(class, time.Since(start))
+		return l.release, nil
+	}
+	l.queues[class].Remove(element)
+	if _, ok := err.(*OverloadError); ok {
+		l.stats[class].Shed++
+	}
+	return nil, err
+}
+
+// release frees a slot, handing it over to the oldest waiter of the highest priority class.
+func (l *ConcurrencyLimiter) release() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	for _, queue := range l.queues {
+		if front := queue.Front(); front != nil {
+			w := queue.Remove(front).(*waiter)
+			w.granted = true
+			close(w.ready)
+			return
+		}
+	}
+	l.inFlight--
+}
+
+// queued returns the number of waiting requests. The lock must be held.
+func (l *ConcurrencyLimiter) queued() int {
+	n := 0
+	for _, queue := range l.queues {
+		n += queue.Len()
+	}
+	return n
+}
+
+// admitted records an admitted request. The lock must be held.
+func (l *ConcurrencyLimiter) admitted(class int, wait time.Duration) {
+	stats := &l.stats[class]
+	stats.Admitted++
+	bucket := len(WaitBuckets)
+	for i, bound := range WaitBuckets {
+		if wait <= bound {
+			bucket = i
+			break
+		}
+	}
+	stats.Waits[bucket]++
+}
+
+func (l *ConcurrencyLimiter) overload(class int) error {
+	return &OverloadError{Class: l.options.Classes[class].Name, Retry: l.options.RetryAfter}
+}
+
+// Stats returns the state of the limiter.
+func (l *ConcurrencyLimiter) Stats() LimiterStats {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	stats := LimiterStats{InFlight: l.inFlight, Classes: make([]ClassStats, len(l.stats))}
+	for i, class := range l.stats {
+		class.Queued = l.queues[i].Len()
+		class.Waits = append([]uint64(nil), class.Waits...)
+		stats.Classes[i] = class
+	}
+	return stats
+}
+
+// Wrap returns a handler serving next within the limits. Shed requests are
+// answered by the central error handler with a 503, a Retry-After header and
+// the QueueClassHeader naming their class. Wrap is a Middleware.
+func (l *ConcurrencyLimiter) Wrap(next http.Handler) http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, ok := l.admit(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+	limit := "concurrency:" + strconv.Itoa(l.options.MaxConcurrent)
+	return Secured(handler, next, Security{RateLimits: []string{limit}})
+}
+
+// admit waits for a slot for the request. If the request is shed, it is
+// answered and ok is false; the client going away gives false as well.
+func (l *ConcurrencyLimiter) admit(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	class := l.options.Classify(r)
+	if class == Bypass {
+		return func() {}, true
+	}
+	release, err := l.Acquire(r.Context(), class)
+	if err != nil {
+		if overload, isOverload := err.(*OverloadError); isOverload {
+			w.Header().Set(QueueClassHeader, overload.Class)
+			serverOf(r).Error(w, r, err)
+		}
+		return nil, false
+	}
+	return release, true
+}
@@ -0,0 +1,289 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n requests of the class are queued by l.
+func waitQueued(t *testing.T, l *ConcurrencyLimiter, class, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for l.Stats().Classes[class].Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests of class %d queued, want %d", l.Stats().Classes[class].Queued, class, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDefaultClassify(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		accept string
+		want   int
+	}{
+		{http.MethodGet, "/", "text/html,application/xhtml+xml", 0},
+		{http.MethodHead, "/page", "text/html", 0},
+		{http.MethodGet, "/api/poll", "application/json", 1},
+		{http.MethodGet, "/api/poll", "", 1},
+		{http.MethodPost, "/form", "text/html", 1},
+		{http.MethodGet, "/healthz", "text/html", Bypass},
+		{http.MethodGet, "/livez", "", Bypass},
+		{http.MethodPost, "/readyz", "", Bypass},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := DefaultClassify(r); got != tt.want {
+			t.Errorf("%s %s Accept %q: class %d, want %d", tt.method, tt.path, tt.accept, got, tt.want)
+		}
+	}
+}
+
+// TestLimiterPriority queues requests of both classes behind a single slot:
+// the slots go to the interactive requests first, in their order of arrival
+// within each class.
+func TestLimiterPriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		arrivals []int
+		want     []int
+	}{
+		{"high first", []int{0, 0, 1, 1}, []int{0, 1, 2, 3}},
+		{"low first", []int{1, 1, 0, 0}, []int{2, 3, 0, 1}},
+		{"interleaved", []int{1, 0, 1, 0, 1}, []int{1, 3, 0, 2, 4}},
+		{"single class", []int{1, 1, 1}, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		l := NewConcurrencyLimiter(LimiterOptions{
+			MaxConcurrent: 1,
+			Classes:       []PriorityClass{{Name: "high", QueueDepth: 8, MaxWait: 10 * time.Second}, {Name: "low", QueueDepth: 8, MaxWait: 10 * time.Second}},
+		})
+		release, err := l.Acquire(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mut sync.Mutex
+		var order []int
+		var wg sync.WaitGroup
+		queued := []int{0, 0}
+		for i, class := range tt.arrivals {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := l.Acquire(context.Background(), class)
+				if err != nil {
+					t.Errorf("%s: request %d: %v", tt.name, i, err)
+					return
+				}
+				mut.Lock()
+				order = append(order, i)
+				mut.Unlock()
+				release()
+			}()
+			queued[class]++
+			waitQueued(t, l, class, queued[class])
+		}
+		release()
+		wg.Wait()
+		if !slices.Equal(order, tt.want) {
+			t.Errorf("%s: admitted %v, want %v", tt.name, order, tt.want)
+		}
+		if stats := l.Stats(); stats.InFlight != 0 {
+			t.Errorf("%s: %d requests in flight once all released", tt.name, stats.InFlight)
+		}
+	}
+}
+
+// TestLimiterShed fills the single slot and queues requests of one class
+// until they are shed by the depth or the wait limit of their class.
+func TestLimiterShed(t *testing.T) {
+	tests := []struct {
+		name    string
+		class   PriorityClass
+		waiting int
+		cancel  bool
+		wantErr error
+		// wantShed is the shed count of the class once the last request is refused.
+		wantShed uint64
+	}{
+		{"no queue", PriorityClass{Name: "c", QueueDepth: 0, MaxWait: 10 * time.Second}, 0, false, &OverloadError{}, 1},
+		{"queue full", PriorityClass{Name: "c", QueueDepth: 2, MaxWait: 10 * time.Second}, 2, false, &OverloadError{}, 1},
+		{"max wait", PriorityClass{Name: "c", QueueDepth: 2, MaxWait: 10 * time.Millisecond}, 0, false, &OverloadError{}, 1},
+		{"client gone", PriorityClass{Name: "c", QueueDepth: 2, MaxWait: 10 * time.Second}, 0, true, context.Canceled, 0},
+	}
+	for _, tt := range tests {
+		l := NewConcurrencyLimiter(LimiterOptions{MaxConcurrent: 1, Classes: []PriorityClass{tt.class}, RetryAfter: 3 * time.Second})
+		release, _ := l.Acquire(context.Background(), 0)
+		var wg sync.WaitGroup
+		for range tt.waiting {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if release, err := l.Acquire(context.Background(), 0); err == nil {
+					release()
+				}
+			}()
+		}
+		waitQueued(t, l, 0, tt.waiting)
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			time.AfterFunc(10*time.Millisecond, cancel)
+		}
+		_, err := l.Acquire(ctx, 0)
+		cancel()
+		var overload *OverloadError
+		switch want := tt.wantErr.(type) {
+		case *OverloadError:
+			if !errors.As(err, &overload) || overload.Class != "c" || overload.RetryAfter() != 3*time.Second || overload.StatusCode() != http.StatusServiceUnavailable {
+				t.Errorf("%s: error %v, want an OverloadError of class c retrying after 3s", tt.name, err)
+			}
+		default:
+			if !errors.Is(err, want) {
+				t.Errorf("%s: error %v, want %v", tt.name, err, want)
+			}
+		}
+		stats := l.Stats().Classes[0]
+		if stats.Shed != tt.wantShed || stats.Queued != tt.waiting {
+			t.Errorf("%s: %d shed, %d queued, want %d and %d", tt.name, stats.Shed, stats.Queued, tt.wantShed, tt.waiting)
+		}
+		release()
+		wg.Wait()
+		if stats := l.Stats(); stats.InFlight != 0 || stats.Classes[0].Admitted != uint64(1+tt.waiting) {
+			t.Errorf("%s: %d in flight, %d admitted, want 0 and %d", tt.name, stats.InFlight, stats.Classes[0].Admitted, 1+tt.waiting)
+		}
+	}
+}
+
+// TestLimiterLoad runs many concurrent requests of both classes, to be run
+// with -race: no more than MaxConcurrent are served at once, and every
+// request is either admitted or shed.
+func TestLimiterLoad(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		depth         int
+		requests      int
+		wantShed      bool
+	}{
+		{"queued", 4, 64, 64, false},
+		{"shed", 2, 1, 64, true},
+	}
+	for _, tt := range tests {
+		l := NewConcurrencyLimiter(LimiterOptions{
+			MaxConcurrent: tt.maxConcurrent,
+			Classes:       []PriorityClass{{Name: "high", QueueDepth: tt.depth, MaxWait: 10 * time.Second}, {Name: "low", QueueDepth: tt.depth, MaxWait: 10 * time.Second}},
+		})
+		var current, highest, shed atomic.Int64
+		var wg sync.WaitGroup
+		for i := range tt.requests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := l.Acquire(context.Background(), i%2)
+				if err != nil {
+					shed.Add(1)
+					return
+				}
+				n := current.Add(1)
+				for {
+					if h := highest.Load(); n <= h || highest.CompareAndSwap(h, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				current.Add(-1)
+				release()
+			}()
+		}
+		wg.Wait()
+		if highest.Load() > int64(tt.maxConcurrent) {
+			t.Errorf("%s: %d requests served at once, want at most %d", tt.name, highest.Load(), tt.maxConcurrent)
+		}
+		if (shed.Load() > 0) != tt.wantShed {
+			t.Errorf("%s: %d requests shed, want shedding %v", tt.name, shed.Load(), tt.wantShed)
+		}
+		stats := l.Stats()
+		var admitted, counted, refused uint64
+		for _, class := range stats.Classes {
+			admitted += class.Admitted
+			refused += class.Shed
+			for _, n := range class.Waits {
+				counted += n
+			}
+		}
+		if stats.InFlight != 0 || admitted+refused != uint64(tt.requests) || refused != uint64(shed.Load()) || counted != admitted {
+			t.Errorf("%s: %+v, want %d requests admitted or shed, each admission in a wait bucket", tt.name, stats, tt.requests)
+		}
+	}
+}
+
+// TestLimiterServer saturates the limiter of a server with a slow page: the
+// other requests are shed with the name of their class, the health checks
+// bypass the limiter.
+func TestLimiterServer(t *testing.T) {
+	s := NewServer(ServerConfig{ConcurrencyLimit: &LimiterOptions{
+		MaxConcurrent: 1,
+		Classes:       []PriorityClass{{Name: "interactive"}, {Name: "normal"}},
+		RetryAfter:    2 * time.Second,
+	}})
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	s.GET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-unblock
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	s.GET("/page", ok)
+	s.POST("/api", ok)
+	s.GET("/healthz", ok)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-entered
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		accept    string
+		wantCode  int
+		wantClass string
+	}{
+		{"page", http.MethodGet, "/page", "text/html", http.StatusServiceUnavailable, "interactive"},
+		{"API", http.MethodPost, "/api", "application/json", http.StatusServiceUnavailable, "normal"},
+		{"health check", http.MethodGet, "/healthz", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantCode || w.Header().Get(QueueClassHeader) != tt.wantClass {
+			t.Errorf("%s: %d class %q, want %d class %q", tt.name, w.Code, w.Header().Get(QueueClassHeader), tt.wantCode, tt.wantClass)
+		}
+		if retry := w.Header().Get("Retry-After"); (tt.wantCode == http.StatusServiceUnavailable) != (retry == "2") {
+			t.Errorf("%s: Retry-After %q", tt.name, retry)
+		}
+	}
+	if stats := s.Stats().Limiter; stats == nil || stats.InFlight != 1 || stats.Classes[0].Shed != 1 || stats.Classes[1].Shed != 1 {
+		t.Errorf("limiter stats %+v, want the slow page in flight and one request of each class shed", stats)
+	}
+	close(unblock)
+	<-done
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("after the slow page: %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,251 @@
+package serverlib
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// InFlightRetryAfter is the delay advertised to the requests rejected by
+// ServerConfig.MaxInFlightRequests.
+const InFlightRetryAfter = time.Second
+
+// connLimiter counts the connections of the listeners of the server and
+// bounds them to ServerConfig.MaxConnections, like netutil.LimitListener:
+// once the limit is reached, the listeners stop accepting and the new
+// connections wait in the backlog of the kernel.
+type connLimiter struct {
+	max      int
+	slots    chan struct{}
+	active   atomic.Int64
+	accepted atomic.Uint64
+}
+
+func newConnLimiter(max int) *connLimiter {
+	c := &connLimiter{max: max}
+	if max > 0 {
+		c.slots = make(chan struct{}, max)
+	}
+	return c
+}
+
+// wrap returns the listener counting its connections.
+func (c *connLimiter) wrap(l net.Listener) net.Listener {
+	return &limitListener{Listener: l, limiter: c, done: make(chan struct{}), once: &sync.Once{}}
+}
+
+// limitListener is a listener counted by a connLimiter.
+type limitListener struct {
+	net.Listener
+	limiter *connLimiter
+	done    chan struct{}
+	once    *sync.Once
+}
+
+// Accept waits for a free slot, then for a connection.
+func (l *limitListener) Accept() (net.Conn, error) {
+	c := l.limiter
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	c.active.Add(1)
+	c.accepted.Add(1)
+	return &limitConn{Conn: conn, limiter: c, once: &sync.Once{}}, nil
+}
+
+// Close closes the listener, ending an Accept waiting for a slot.
+func (l *limitListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// release frees a slot.
+func (c *connLimiter) release() {
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+// limitConn is a connection of a limitListener, freeing its slot once closed.
+type limitConn struct {
+	net.Conn
+	limiter *connLimiter
+	once    *sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.limiter.active.Add(-1)
+		c.limiter.release()
+	})
+	return err
+}
+
+// inFlight counts the requests being served and bounds them to
+// ServerConfig.MaxInFlightRequests.
+type inFlight struct {
+	max      int64
+	current  atomic.Int64
+	rejected atomic.Uint64
+}
+
+// admit counts the request in, or answers it with a 503 and reports false
+// when the limit is reached. An admitted request is counted out by done.
+func (f *inFlight) admit(w http.ResponseWriter, r *http.Request) bool {
+	if n := f.current.Add(1); f.max > 0 && n > f.max {
+		f.current.Add(-1)
+		f.rejected.Add(1)
+		serverOf(r).Error(w, r, &OverloadError{Class: "in-flight", Retry: InFlightRetryAfter})
+		return false
+	}
+	return true
+}
+
+// done counts out a request admitted by admit.
+func (f *inFlight) done() {
+	f.current.Add(-1)
+}
+
+// ServerStats are the counters of the server, e.g. to alert on saturation.
+type ServerStats struct {
+	// Connections is the number of open connections.
+	Connections int64
+	// MaxConnections is ServerConfig.MaxConnections, 0 when unlimited.
+	MaxConnections int
+	// AcceptedConnections is the number of connections accepted since start.
+	AcceptedConnections uint64
+	// InFlightRequests is the number of requests being served.
+	InFlightRequests int64
+	// MaxInFlightRequests is ServerConfig.MaxInFlightRequests, 0 when unlimited.
+	MaxInFlightRequests int
+	// RejectedRequests is the number of requests answered with a 503 by
+	// MaxInFlightRequests.
+	RejectedRequests uint64
+	// Limiter is the state of ServerConfig.ConcurrencyLimit, when set.
+	Limiter *LimiterStats
+	// Uptime is the time since the server started, 0 before.
+	Uptime time.Duration
+	// Requests is the number of requests served since start.
+	Requests uint64
+	// Responses are the responses served by status class, the hijacked
+	// connections excluded.
+	Responses ResponseCounts
+	// BytesWritten is the size of the bodies of the responses served.
+	BytesWritten uint64
+	// Sessions is the number of sessions of the store, -1 when it doesn't
+	// count them, see sessions.Counter.
+	Sessions int
+	// ConnStates are the connections by state, see ConnStats.
+	ConnStates ConnStats
+}
+
+// Stats returns the counters of the connections and the requests of the
+// server. The connections are counted once the server is started, the ones
+// of a listener given to Serve included. The counters are atomics, cheap to
+// read and to update; the running servers are published under ExpvarName
+// too, see expvar.
+func (s *Server) Stats() ServerStats {
+	stats := ServerStats{
+		Requests:            s.injector.stats.requests.Load(),
+		Responses:           s.injector.stats.responses(),
+		BytesWritten:        s.injector.stats.bytes.Load(),
+		Sessions:            -1,
+		ConnStates:          s.ConnStats(),
+		Connections:         s.conns.active.Load(),
+		MaxConnections:      s.conns.max,
+		AcceptedConnections: s.conns.accepted.Load(),
+		InFlightRequests:    s.injector.inFlight.current.Load(),
+		MaxInFlightRequests: int(s.injector.inFlight.max),
+		RejectedRequests:    s.injector.inFlight.rejected.Load(),
+	}
+	if s.injector.limiter != nil {
+		limiter := s.injector.limiter.Stats()
+		stats.Limiter = &limiter
+	}
+	if started := s.started.Load(); started != nil {
+		stats.Uptime = time.Since(*started)
+	}
+	if counter, ok := s.sessionManager.(sessions.Counter); ok {
+		stats.Sessions = counter.Len()
+	}
+	return stats
+}
+
+// ConnStats are the connections of the server by state, see
+// http.ConnState.
+type ConnStats struct {
+	// New is the number of connections accepted that haven't sent a request yet.
+	New int64
+	// Active is the number of connections serving a request.
+	Active int64
+	// Idle is the number of keep-alive connections waiting for a request.
+	Idle int64
+	// Hijacked is the number of connections hijacked since start, e.g. by
+	// websockets, not tracked by the server anymore.
+	Hijacked uint64
+}
+
+// connTracker counts the connections by state, from the ConnState callback
+// of the http.Server.
+type connTracker struct {
+	states   sync.Map
+	new      atomic.Int64
+	active   atomic.Int64
+	idle     atomic.Int64
+	hijacked atomic.Uint64
+}
+
+// gauge returns the counter of state, or nil.
+func (t *connTracker) gauge(state http.ConnState) *atomic.Int64 {
+	switch state {
+	case http.StateNew:
+		return &t.new
+	case http.StateActive:
+		return &t.active
+	case http.StateIdle:
+		return &t.idle
+	}
+	return nil
+}
+
+// track moves conn to state. The transitions of a connection are sequential.
+func (t *connTracker) track(conn net.Conn, state http.ConnState) {
+	if previous, ok := t.states.Load(conn); ok {
+		t.gauge(previous.(http.ConnState)).Add(-1)
+	}
+	switch state {
+	case http.StateHijacked:
+		t.hijacked.Add(1)
+		t.states.Delete(conn)
+	case http.StateClosed:
+		t.states.Delete(conn)
+	default:
+		t.states.Store(conn, state)
+		t.gauge(state).Add(1)
+	}
+}
+
+// ConnStats returns the connections of the server by state, e.g. to follow
+// the idle connections going away while draining, see SetKeepAlivesEnabled.
+func (s *Server) ConnStats() ConnStats {
+	return ConnStats{
+		New:      s.connStates.new.Load(),
+		Active:   s.connStates.active.Load(),
+		Idle:     s.connStates.idle.Load(),
+		Hijacked: s.connStates.hijacked.Load(),
+	}
+}
@@ -0,0 +1,188 @@
+package serverlib
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMaxInFlightRequests serves more requests at once than
+// MaxInFlightRequests: the requests above it are answered with a 503 and a
+// Retry-After right away, and counted by Stats.
+func TestMaxInFlightRequests(t *testing.T) {
+	s := NewServer(ServerConfig{MaxInFlightRequests: 2})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	s.GET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	var served sync.WaitGroup
+	for range 2 {
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		}()
+		<-entered
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("over the limit: %d Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	stats := s.Stats()
+	if stats.InFlightRequests != 2 || stats.MaxInFlightRequests != 2 || stats.RejectedRequests != 1 {
+		t.Errorf("stats at the limit %+v", stats)
+	}
+	close(release)
+	served.Wait()
+	if stats := s.Stats(); stats.InFlightRequests != 0 || stats.RejectedRequests != 1 {
+		t.Errorf("stats once served %+v", stats)
+	}
+	// A request served once the others are done is admitted.
+	w = httptest.NewRecorder()
+	go func() { <-entered }()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("under the limit: %d", w.Code)
+	}
+
+	// Without a limit, the requests are counted only.
+	s = NewServer(ServerConfig{})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if stats := s.Stats(); stats.MaxInFlightRequests != 0 || stats.RejectedRequests != 0 || stats.InFlightRequests != 0 {
+		t.Errorf("unlimited: %+v", stats)
+	}
+}
+
+// rawGet sends a GET of path on conn, keeping it open, and returns the
+// status code of the response.
+func rawGet(conn net.Conn, path string) (int, error) {
+	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// TestMaxConnections opens more connections than MaxConnections: the
+// connection above it waits in the backlog until another one closes, and
+// Stop returns while the listener waits for a free slot.
+func TestMaxConnections(t *testing.T) {
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", MaxConnections: 1})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	done := startServer(s)
+	<-s.Ready()
+	first, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if code, err := rawGet(first, "/"); err != nil || code != http.StatusOK {
+		t.Fatalf("first connection: %d %v", code, err)
+	}
+	second, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if code, err := rawGet(second, "/"); err == nil {
+		t.Errorf("second connection served at the limit: %d", code)
+	}
+	if stats := s.Stats(); stats.Connections != 1 || stats.MaxConnections != 1 || stats.AcceptedConnections != 1 {
+		t.Errorf("stats at the limit %+v", stats)
+	}
+	first.Close()
+	// The request sent is served once the connection is accepted.
+	second.SetDeadline(time.Now().Add(10 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(second), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("second connection once the first closed: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if stats := s.Stats(); stats.Connections != 1 || stats.AcceptedConnections != 2 {
+		t.Errorf("stats once the first closed %+v", stats)
+	}
+	// A third connection leaves the listener waiting for a slot.
+	third, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	rawGet(third, "/")
+	s.Stop()
+	waitStart(t, done)
+	if stats := s.Stats(); stats.Connections != 0 {
+		t.Errorf("%d connections after Stop", stats.Connections)
+	}
+}
+
+// TestConnStats follows a connection of a running server through its
+// states: new until its first request, active while served, idle between
+// the requests, then hijacked.
+func TestConnStats(t *testing.T) {
+	s := newTestServer()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	s.GET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	s.GET("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	// waitStats polls ConnStats, the states changing in the goroutine of the
+	// connection.
+	waitStats := func(name string, want ConnStats) {
+		t.Helper()
+		var got ConnStats
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if got = s.ConnStats(); got == want {
+				return
+			}
+		}
+		t.Errorf("%s: %+v, want %+v", name, got, want)
+	}
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitStats("new", ConnStats{New: 1})
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	<-entered
+	waitStats("active", ConnStats{Active: 1})
+	close(release)
+	if resp, err := http.ReadResponse(reader, nil); err == nil {
+		resp.Body.Close()
+	}
+	waitStats("idle", ConnStats{Idle: 1})
+	conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	waitStats("hijacked", ConnStats{Hijacked: 1})
+	if stats := s.Stats(); stats.ConnStates != s.ConnStats() {
+		t.Errorf("Stats %+v, want ConnStats %+v", stats.ConnStates, s.ConnStats())
+	}
+}
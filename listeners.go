@@ -0,0 +1,155 @@
+package serverlib
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ListenAddress is an additional address of the server, see
+// ServerConfig.Listeners.
+type ListenAddress struct {
+	// Address is a TCP address or a unix socket, like ServerConfig.Address.
+	Address string
+	// TLS serves HTTPS on the address, with the certificate given to StartTLS
+	// or else the one of ServerConfig.TLSConfig.
+	TLS bool
+}
+
+// boundListener is a listener of the server.
+type boundListener struct {
+	net.Listener
+	tls bool
+	// address is the configured address bound, empty for a listener given to
+	// Serve.
+	address string
+}
+
+func (l boundListener) scheme() string {
+	if l.tls {
+		return "https"
+	}
+	return "http"
+}
+
+// listenerAddr returns the address of a listener, unix sockets prefixed with
+// UnixAddressPrefix.
+func listenerAddr(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return UnixAddressPrefix + l.Addr().String()
+	}
+	return l.Addr().String()
+}
+
+// Addrs returns the addresses the server listens on, ServerConfig.Address
+// first then ServerConfig.Listeners, with the actual ports once started.
+func (s *Server) Addrs() []string {
+	if addrs := s.addrs.Load(); addrs != nil {
+		return append([]string(nil), *addrs...)
+	}
+	addrs := []string{s.httpServer.Load().Addr}
+	for _, listener := range s.config.Listeners {
+		addrs = append(addrs, listener.Address)
+	}
+	return addrs
+}
+
+// listenAll binds the configured address, defaultAddr when it is empty, and
+// the addresses of ServerConfig.Listeners. Nothing stays bound on error.
+func (s *Server) listenAll(defaultAddr string, primaryTLS bool) ([]boundListener, error) {
+	addr := s.httpServer.Load().Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	addresses := append([]ListenAddress{{Address: addr, TLS: primaryTLS}}, s.config.Listeners...)
+	listeners := make([]boundListener, 0, len(addresses))
+	for _, address := range addresses {
+		l, err := s.listen(address.Address)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, boundListener{Listener: l, tls: address.TLS, address: address.Address})
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []boundListener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// serveAll serves the listeners, each in its own goroutine, until the server
+// stops. A listener failing stops the others; the errors are joined.
+func (s *Server) serveAll(listeners []boundListener, certFile, keyFile string) error {
+	for _, l := range listeners {
+		if l.tls && certFile == "" && !hasCertificates(s.httpServer.Load().TLSConfig) {
+			closeListeners(listeners)
+			return ErrNoCertificate
+		}
+	}
+	if err := s.start(listeners); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	// The listeners are bound: the connections accepted meanwhile wait in
+	// their backlog until served.
+	s.components.markReady()
+	UpgradeReady()
+	gen := &generation{server: s.httpServer.Load(), listeners: listeners, started: make(chan struct{})}
+	for {
+		err := s.serveGeneration(gen, certFile, keyFile)
+		// Restart hands the listeners over to the next generation.
+		if gen = s.nextGeneration(); gen == nil {
+			return err
+		}
+	}
+}
+
+// serveGeneration serves the listeners of gen until its server stops. The
+// listeners are closed on return, but the ones detached by Restart.
+func (s *Server) serveGeneration(gen *generation, certFile, keyFile string) error {
+	gen.detachables = make([]*detachableListener, len(gen.listeners))
+	served := make([]net.Listener, len(gen.listeners))
+	for i, l := range gen.listeners {
+		gen.detachables[i] = newDetachableListener(l.Listener)
+		served[i] = s.proxy.wrap(s.conns.wrap(s.config.SocketOptions.wrap(gen.detachables[i])))
+	}
+	defer func() {
+		for _, l := range served {
+			l.Close()
+		}
+	}()
+	s.useTicketRotation(gen)
+	if !s.attach(gen) {
+		return http.ErrServerClosed
+	}
+	serve := func(i int) error {
+		if gen.listeners[i].tls {
+			return gen.server.ServeTLS(served[i], certFile, keyFile)
+		}
+		return gen.server.Serve(served[i])
+	}
+	if len(served) == 1 {
+		return serve(0)
+	}
+	errc := make(chan error, len(served))
+	for i := range served {
+		go func() {
+			errc <- serve(i)
+		}()
+	}
+	var errs []error
+	for range served {
+		if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+			s.LogError("Listener failed", err.Error())
+			gen.server.Close()
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return http.ErrServerClosed
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,120 @@
+package serverlib
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dialAddr dials an address of Addrs, a TCP address or a unix socket.
+func dialAddr(ctx context.Context, addr string) (net.Conn, error) {
+	if path, ok := strings.CutPrefix(addr, UnixAddressPrefix); ok {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}
+	return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+}
+
+// addrClient returns a client of an address of Addrs.
+func addrClient(addr string, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialAddr(ctx, addr)
+		},
+		TLSClientConfig: tlsConfig,
+	}}
+}
+
+// TestListeners serves a TLS server on its address and on the addresses of
+// ServerConfig.Listeners, over HTTP or HTTPS each, and checks Addrs.
+func TestListeners(t *testing.T) {
+	ca := newTestCA(t, "CA")
+	certFile, keyFile := ca.writeServerCert(t, t.TempDir(), 1)
+	socket := filepath.Join(t.TempDir(), "http.sock")
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", Listeners: []ListenAddress{
+		{Address: "127.0.0.1:0"},
+		{Address: UnixAddressPrefix + socket, TLS: true},
+	}})
+	if addrs := s.Addrs(); len(addrs) != 3 || addrs[0] != "127.0.0.1:0" || addrs[2] != UnixAddressPrefix+socket {
+		t.Errorf("Addrs before the start %v", addrs)
+	}
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Write([]byte("https"))
+			return
+		}
+		w.Write([]byte("http"))
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StartTLS(certFile, keyFile)
+	}()
+	<-s.Ready()
+	addrs := s.Addrs()
+	if len(addrs) != 3 || addrs[0] != s.Addr() || strings.HasSuffix(addrs[1], ":0") || addrs[2] != UnixAddressPrefix+socket {
+		t.Fatalf("Addrs %v", addrs)
+	}
+	tlsConfig := &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}
+	for i, scheme := range []string{"https", "http", "https"} {
+		client := addrClient(addrs[i], tlsConfig)
+		resp, err := client.Get(scheme + "://localhost/")
+		if err != nil {
+			t.Errorf("%s: %v", addrs[i], err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		client.CloseIdleConnections()
+		if string(body) != scheme {
+			t.Errorf("%s: body %q, want %q", addrs[i], body, scheme)
+		}
+	}
+	s.Stop()
+	waitStart(t, done)
+	for _, addr := range addrs {
+		if conn, err := dialAddr(context.Background(), addr); err == nil {
+			conn.Close()
+			t.Errorf("%s: still listening after Stop", addr)
+		}
+	}
+}
+
+// TestListenersErrors checks nothing stays bound when an address cannot be
+// bound or an HTTPS listener has no certificate.
+func TestListenersErrors(t *testing.T) {
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inUse.Close()
+	tests := []struct {
+		name      string
+		listeners []ListenAddress
+		wantErr   error
+	}{
+		{"address in use", []ListenAddress{{Address: inUse.Addr().String()}}, &BindError{}},
+		{"HTTPS without certificate", []ListenAddress{{Address: "127.0.0.1:0", TLS: true}}, ErrNoCertificate},
+	}
+	for _, tt := range tests {
+		socket := filepath.Join(t.TempDir(), "http.sock")
+		s := NewServer(ServerConfig{Address: UnixAddressPrefix + socket, Listeners: tt.listeners})
+		err := s.Start()
+		var bindErr *BindError
+		if _, ok := tt.wantErr.(*BindError); ok {
+			if !errors.As(err, &bindErr) {
+				t.Errorf("%s: %v, want a BindError", tt.name, err)
+			}
+		} else if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if _, err := os.Lstat(socket); !os.IsNotExist(err) {
+			t.Errorf("%s: socket of the first address left: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Morditux/serverlib/i18n"
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// DefaultLocale is the fallback locale of the messages, see Server.Messages.
+const DefaultLocale = "en"
+
+// validationMessages are the built-in messages of the validation rules and
+// of the conversions of Bind and Query, by locale.
+var validationMessages = map[string]map[string]string{
+	"en": {
+		"validation.failed":           "Validation failed",
+		"validation.failed.detail":    "The request has invalid fields.",
+		"validation.required":         "is required",
+		"validation.min":              "must be at least {min}",
+		"validation.min.length":       "must be at least {min} characters long",
+		"validation.max":              "must be at most {max}",
+		"validation.max.length":       "must be at most {max} characters long",
+		"validation.email":            "must be a valid email",
+		"validation.oneof":            "must be one of {values}",
+		"validation.rule":             "invalid {rule} rule {param}",
+		"validation.once":             "must be given only once",
+		"validation.boolean":          "must be a boolean",
+		"validation.integer":          "must be an integer",
+		"validation.positive_integer": "must be a positive integer",
+		"validation.number":           "must be a number",
+		"validation.duration":         "must be a duration",
+		"validation.time":             "must be an RFC 3339 time",
+		"validation.time.layout":      "must be a time formatted as {layout}",
+		"validation.query":            "malformed query string: {error}",
+		"validation.uuid":             "must be a UUID",
+	},
+	"fr": {
+		"validation.failed":           "Échec de la validation",
+		"validation.failed.detail":    "La requête contient des champs invalides.",
+		"validation.required":         "est obligatoire",
+		"validation.min":              "doit être supérieur ou égal à {min}",
+		"validation.min.length":       "doit contenir au moins {min} caractères",
+		"validation.max":              "doit être inférieur ou égal à {max}",
+		"validation.max.length":       "doit contenir au plus {max} caractères",
+		"validation.email":            "doit être une adresse e-mail valide",
+		"validation.oneof":            "doit être l'une des valeurs {values}",
+		"validation.rule":             "règle {rule} invalide : {param}",
+		"validation.once":             "ne doit être donné qu'une fois",
+		"validation.boolean":          "doit être un booléen",
+		"validation.integer":          "doit être un nombre entier",
+		"validation.positive_integer": "doit être un nombre entier positif",
+		"validation.number":           "doit être un nombre",
+		"validation.duration":         "doit être une durée",
+		"validation.time":             "doit être une date RFC 3339",
+		"validation.time.layout":      "doit être une date au format {layout}",
+		"validation.query":            "chaîne de requête invalide : {error}",
+		"validation.uuid":             "doit être un UUID",
+	},
+}
+
+// newMessages returns a catalog holding the built-in messages.
+func newMessages() *i18n.Catalog {
+	catalog := i18n.NewCatalog(DefaultLocale)
+	for locale, messages := range validationMessages {
+		catalog.Add(locale, messages)
+	}
+	return catalog
+}
+
+// builtinMessages are the messages used without a server.
+var builtinMessages = newMessages()
+
+// Messages returns the message catalog of the server, holding the built-in
+// messages of the validation rules in English and French under the
+// "validation." keys. The applications add their locales, their own keys,
+// e.g. the ones of their Validator implementations (see
+// ValidationErrors.AddKey), and the labels of their fields, or override the
+// built-in messages:
+//
+//	server.Messages().Add("fr", map[string]string{
+//		"label.email":        "Adresse e-mail",
+//		"validation.invalid": "{field} n'est pas valide",
+//	})
+//
+// A nil server returns the built-in messages.
+func (s *Server) Messages() *i18n.Catalog {
+	if s == nil || s.messages == nil {
+		return builtinMessages
+	}
+	return s.messages
+}
+
+// Locale returns the locale of the request: the one set with
+// reqctx.WithLocale, e.g. by a middleware reading the preferences of the
+// user, or else the locale of the catalog best matching its Accept-Language
+// header, see i18n.Catalog.Match.
+func (s *Server) Locale(r *http.Request) string {
+	if locale := reqctx.Locale(r.Context()); locale != "" {
+		return locale
+	}
+	return s.Messages().Match(r.Header.Get("Accept-Language"))
+}
+
+// Localize returns a copy of errs with the messages and the labels of the
+// fields translated to the locale of the request (see Locale). The field
+// errors without a key are kept as is.
+func (s *Server) Localize(r *http.Request, errs ValidationErrors) ValidationErrors {
+	return errs.localize(s.Messages(), s.Locale(r))
+}
+
+// localize returns a copy of e translated to locale.
+func (e ValidationErrors) localize(catalog *i18n.Catalog, locale string) ValidationErrors {
+	localized := make(ValidationErrors, len(e))
+	for i, fieldError := range e {
+		fieldError.Label = fieldLabel(catalog, locale, fieldError.Field, fieldError.Label)
+		if fieldError.Key != "" {
+			fieldError.Message = catalog.Translate(locale, fieldError.Key, fieldError.params())
+		}
+		localized[i] = fieldError
+	}
+	return localized
+}
+
+// fieldLabel returns the label of a field in locale: the translation of its
+// label tag, which may also be a literal text, or of the "label.<field>" key,
+// or an empty string.
+func fieldLabel(catalog *i18n.Catalog, locale, field, label string) string {
+	if label != "" {
+		return catalog.Translate(locale, label, nil)
+	}
+	if message, ok := catalog.Lookup(locale, "label."+field); ok {
+		return message
+	}
+	return ""
+}
+
+// fieldMessage is the error of a conversion of Bind or Query, reported under
+// its message key.
+type fieldMessage struct {
+	key    string
+	params map[string]any
+}
+
+// Error implements the error interface, in the fallback locale of the
+// built-in catalog.
+func (e *fieldMessage) Error() string {
+	return builtinMessages.Translate(builtinMessages.Fallback(), e.key, e.params)
+}
+
+// addError appends the field error of err, under its key in catalog when it
+// is a fieldMessage.
+func (e *ValidationErrors) addError(catalog *i18n.Catalog, field string, err error) {
+	var message *fieldMessage
+	if errors.As(err, &message) {
+		e.addKey(catalog, field, "", message.key, message.params)
+		return
+	}
+	e.Add(field, err.Error())
+}
+
+// message returns the title and the field errors as a single line, with the
+// labels of the fields when they have one.
+func (e ValidationErrors) message(title string) string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		name := fieldError.Field
+		if fieldError.Label != "" {
+			name = fieldError.Label
+		}
+		messages[i] = name + ": " + fieldError.Message
+	}
+	return title + ": " + strings.Join(messages, "; ")
+}
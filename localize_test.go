@@ -0,0 +1,231 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// signup is a form failing a built-in rule with a label key, one with a
+// literal label, and a custom validator with its own key.
+type signup struct {
+	Email    string `json:"email" validate:"required,email" label:"label.email"`
+	Name     string `json:"name" validate:"min=3" label:"Full name"`
+	Age      int    `json:"age" validate:"max=120"`
+	Password string `json:"password"`
+}
+
+func (s signup) Validate() error {
+	var errs ValidationErrors
+	if len(s.Password) < 8 {
+		errs.AddKey("password", "validation.weak", map[string]any{"min": 8})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// localizedServer returns a server whose handler validates a signup form,
+// with the labels and the custom message in English and French.
+func localizedServer() *Server {
+	s := NewServer(ServerConfig{})
+	s.Messages().Add("en", map[string]string{
+		"label.email":     "Email address",
+		"label.age":       "Age",
+		"validation.weak": "{field} needs {min} characters or more",
+	})
+	s.Messages().Add("fr", map[string]string{
+		"label.email":     "Adresse e-mail",
+		"label.age":       "Âge",
+		"validation.weak": "{field} doit contenir au moins {min} caractères",
+	})
+	s.POST("/signup", func(w http.ResponseWriter, r *http.Request) {
+		form := signup{Email: "not-an-email", Name: "Al", Age: 130, Password: "short"}
+		err := Validate(form)
+		if r.URL.Query().Get("form") != "" {
+			for field, messages := range FormState(r, err).Errors {
+				w.Write([]byte(field + "=" + strings.Join(messages, "|") + "\n"))
+			}
+			return
+		}
+		s.Error(w, r, err)
+	})
+	return s
+}
+
+// TestLocalizedValidationJSON renders the same validation failure in two
+// locales as a problem document: each error keeps its key and parameters
+// next to its localized message and label.
+func TestLocalizedValidationJSON(t *testing.T) {
+	s := localizedServer()
+	type fieldError struct {
+		Field   string         `json:"field"`
+		Message string         `json:"message"`
+		Key     string         `json:"key"`
+		Params  map[string]any `json:"params"`
+		Label   string         `json:"label"`
+	}
+	tests := []struct {
+		language   string
+		wantTitle  string
+		wantDetail string
+		wantErrors []fieldError
+	}{
+		{"en-US,en;q=0.9", "Validation failed", "The request has invalid fields.", []fieldError{
+			{"email", "must be a valid email", "validation.email", nil, "Email address"},
+			{"name", "must be at least 3 characters long", "validation.min.length", map[string]any{"min": "3"}, "Full name"},
+			{"age", "must be at most 120", "validation.max", map[string]any{"max": "120"}, "Age"},
+			{"password", "password needs 8 characters or more", "validation.weak", map[string]any{"min": float64(8)}, ""},
+		}},
+		{"fr-CH, fr;q=0.9, en;q=0.8", "Échec de la validation", "La requête contient des champs invalides.", []fieldError{
+			{"email", "doit être une adresse e-mail valide", "validation.email", nil, "Adresse e-mail"},
+			{"name", "doit contenir au moins 3 caractères", "validation.min.length", map[string]any{"min": "3"}, "Full name"},
+			{"age", "doit être inférieur ou égal à 120", "validation.max", map[string]any{"max": "120"}, "Âge"},
+			{"password", "password doit contenir au moins 8 caractères", "validation.weak", map[string]any{"min": float64(8)}, ""},
+		}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/signup", nil)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("Accept-Language", tt.language)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
+			t.Errorf("%s: %d %q", tt.language, w.Code, w.Header().Get("Content-Type"))
+		}
+		var problem struct {
+			Title  string       `json:"title"`
+			Detail string       `json:"detail"`
+			Errors []fieldError `json:"errors"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: %v: %s", tt.language, err, w.Body.String())
+		}
+		if problem.Title != tt.wantTitle || problem.Detail != tt.wantDetail {
+			t.Errorf("%s: %q %q, want %q %q", tt.language, problem.Title, problem.Detail, tt.wantTitle, tt.wantDetail)
+		}
+		if !reflect.DeepEqual(problem.Errors, tt.wantErrors) {
+			t.Errorf("%s: errors\n%#v\nwant\n%#v", tt.language, problem.Errors, tt.wantErrors)
+		}
+	}
+}
+
+// TestLocalizedValidationHTML renders the same validation failure in two
+// locales for the browsers: on the error page, in plain text and in the
+// state of the form.
+func TestLocalizedValidationHTML(t *testing.T) {
+	s := localizedServer()
+	tests := []struct {
+		name     string
+		language string
+		accept   string
+		form     bool
+		want     []string
+	}{
+		{"page", "en", "text/html", false, []string{
+			"Validation failed: Email address: must be a valid email; Full name: must be at least 3 characters long; Age: must be at most 120; password: password needs 8 characters or more",
+		}},
+		{"page", "fr", "text/html", false, []string{
+			"Échec de la validation: Adresse e-mail: doit être une adresse e-mail valide; Full name: doit contenir au moins 3 caractères; Âge: doit être inférieur ou égal à 120; password: password doit contenir au moins 8 caractères",
+		}},
+		{"text", "en", "", false, []string{"Validation failed: Email address: must be a valid email;"}},
+		{"text", "fr", "", false, []string{"Échec de la validation: Adresse e-mail: doit être une adresse e-mail valide;"}},
+		{"form", "en", "text/html", true, []string{
+			"email=must be a valid email\n", "name=must be at least 3 characters long\n", "age=must be at most 120\n", "password=password needs 8 characters or more\n",
+		}},
+		{"form", "fr", "text/html", true, []string{
+			"email=doit être une adresse e-mail valide\n", "name=doit contenir au moins 3 caractères\n", "age=doit être inférieur ou égal à 120\n", "password=password doit contenir au moins 8 caractères\n",
+		}},
+	}
+	for _, tt := range tests {
+		target := "/signup"
+		if tt.form {
+			target += "?form=1"
+		}
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		r.Header.Set("Accept-Language", tt.language)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		got := w.Body.String()
+		if tt.accept == "text/html" && !tt.form {
+			// The error page escapes the message.
+			got = strings.ReplaceAll(got, "&#39;", "'")
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s %s: %q, want %q", tt.name, tt.language, got, want)
+			}
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Messages().Add("pt-BR", map[string]string{"validation.required": "é obrigatório"})
+	tests := []struct {
+		name     string
+		language string
+		locale   string
+		want     string
+	}{
+		{"no header", "", "", "en"},
+		{"exact", "fr", "", "fr"},
+		{"regional tag", "fr-CA", "", "fr"},
+		{"base language of a regional locale", "pt", "", "pt-br"},
+		{"quality", "en;q=0.5, fr;q=0.8", "", "fr"},
+		{"unknown", "de, ja", "", "en"},
+		{"refused", "fr;q=0", "", "en"},
+		{"set by a middleware", "fr", "pt-BR", "pt-BR"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", tt.language)
+		if tt.locale != "" {
+			r = r.WithContext(reqctx.WithLocale(r.Context(), tt.locale))
+		}
+		if got := s.Locale(r); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestLocalize checks the errors without a key keep their literal message,
+// and the missing translations fall back to English.
+func TestLocalize(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Messages().Add("en", map[string]string{"validation.custom": "{field} is custom"})
+	var errs ValidationErrors
+	errs.Add("email", "is taken")
+	errs.AddKey("code", "validation.custom", nil)
+	errs.AddKey("email", "validation.required", nil)
+	tests := []struct {
+		language string
+		want     []string
+	}{
+		{"en", []string{"is taken", "code is custom", "is required"}},
+		{"fr", []string{"is taken", "code is custom", "est obligatoire"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", tt.language)
+		var got []string
+		for _, fieldError := range s.Localize(r, errs) {
+			got = append(got, fieldError.Message)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.language, got, tt.want)
+		}
+	}
+	if errs[2].Message != "is required" {
+		t.Errorf("Localize changed the errors: %+v", errs)
+	}
+}
@@ -0,0 +1,190 @@
+package serverlib
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Morditux/serverlib/templates"
+)
+
+// DefaultMaintenanceRetryAfter is the delay advertised to the requests
+// refused in maintenance mode when MaintenanceRetryAfter is not given.
+const DefaultMaintenanceRetryAfter = 5 * time.Minute
+
+// MaintenanceError is the error of the requests refused in maintenance mode,
+// see Server.SetMaintenance.
+type MaintenanceError struct {
+	// Retry is the delay advertised to the client.
+	Retry time.Duration
+}
+
+// Error implements the error interface.
+func (e *MaintenanceError) Error() string {
+	return "server in maintenance"
+}
+
+// StatusCode returns the HTTP status code of a request refused in
+// maintenance mode.
+func (e *MaintenanceError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// RetryAfter returns the delay after which the client can retry.
+func (e *MaintenanceError) RetryAfter() time.Duration {
+	return e.Retry
+}
+
+// MaintenanceOption configures the maintenance mode, see SetMaintenance.
+type MaintenanceOption func(*maintenance)
+
+// MaintenanceAllowPaths serves the requests whose path is one of the
+// prefixes or below it, as usual: "/healthz" allows "/healthz" and
+// "/healthz/live" but not "/healthzx", and "/admin/" the paths under
+// "/admin/". The path is cleaned first, so "/healthz/../admin" is not
+// allowed by "/healthz".
+func MaintenanceAllowPaths(prefixes ...string) MaintenanceOption {
+	return func(m *maintenance) {
+		m.paths = append(m.paths, prefixes...)
+	}
+}
+
+// MaintenanceAllowIPs serves the requests of the clients whose address, e.g.
+// "10.0.0.5", or network, e.g. "10.0.0.0/8", is listed, as usual, e.g. to
+// check the site before reopening it. The invalid entries are logged and
+// ignored.
+func MaintenanceAllowIPs(addresses ...string) MaintenanceOption {
+	return func(m *maintenance) {
+		for _, address := range addresses {
+			prefix, err := parseAddrPrefix(address)
+			if err != nil {
+				slog.Error("Invalid maintenance address ignored", "address", address, "error", err)
+				continue
+			}
+			m.ips = append(m.ips, prefix)
+		}
+	}
+}
+
+// MaintenanceTemplate renders the template, e.g. "maintenance.html", with
+// data for the refused requests, instead of the error page. The clients
+// asking for JSON get a problem document, see ProblemForRequest.
+func MaintenanceTemplate(template string, data map[string]any) MaintenanceOption {
+	return func(m *maintenance) {
+		m.template = template
+		m.data = data
+	}
+}
+
+// MaintenanceRetryAfter sets the Retry-After of the refused requests.
+// Defaults to DefaultMaintenanceRetryAfter.
+func MaintenanceRetryAfter(retry time.Duration) MaintenanceOption {
+	return func(m *maintenance) {
+		m.retry = retry
+	}
+}
+
+// maintenance is the maintenance mode of the server.
+type maintenance struct {
+	paths    []string
+	ips      []netip.Prefix
+	template string
+	data     map[string]any
+	retry    time.Duration
+}
+
+// allows reports whether r is served despite the maintenance.
+func (m *maintenance) allows(r *http.Request) bool {
+	if len(m.paths) > 0 {
+		cleaned := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		for _, prefix := range m.paths {
+			if allowsPath(cleaned, prefix) {
+				return true
+			}
+		}
+	}
+	if len(m.ips) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(remoteIP(r))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range m.ips {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPath reports whether the cleaned path is prefix or below it.
+func allowsPath(cleaned, prefix string) bool {
+	if !strings.HasPrefix(cleaned, prefix) {
+		return false
+	}
+	return len(cleaned) == len(prefix) || strings.HasSuffix(prefix, "/") || cleaned[len(prefix)] == '/'
+}
+
+// SetMaintenance switches the maintenance mode, e.g. during a migration,
+// without restarting: once enabled, every request but the allowed ones (see
+// MaintenanceAllowPaths and MaintenanceAllowIPs) is answered with a 503 and
+// a Retry-After, through the error handler or with MaintenanceTemplate. The
+// requests in progress complete as usual. It is safe to call concurrently
+// with the requests; each call replaces the options of the previous one.
+//
+//	server.SetMaintenance(true,
+//		serverlib.MaintenanceAllowPaths("/healthz", "/readyz"),
+//		serverlib.MaintenanceTemplate("maintenance.html", nil))
+//
+// Parameters:
+//   - enabled: Whether the server is in maintenance.
+//   - opts: Optional options of the maintenance mode, when enabled.
+func (s *Server) SetMaintenance(enabled bool, opts ...MaintenanceOption) {
+	if !enabled {
+		if s.maintenance.Swap(nil) != nil {
+			slog.Info("Maintenance mode disabled")
+		}
+		return
+	}
+	m := &maintenance{retry: DefaultMaintenanceRetryAfter}
+	for _, opt := range opts {
+		opt(m)
+	}
+	s.maintenance.Store(m)
+	slog.Info("Maintenance mode enabled", "allowed_paths", m.paths, "template", m.template)
+}
+
+// InMaintenance reports whether the server is in maintenance, see
+// SetMaintenance.
+func (s *Server) InMaintenance() bool {
+	return s.maintenance.Load() != nil
+}
+
+// refuseInMaintenance answers r with a 503 and reports true when the server
+// is in maintenance and r is not allowed.
+func (s *Server) refuseInMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	m := s.maintenance.Load()
+	if m == nil || m.allows(r) {
+		return false
+	}
+	err := &MaintenanceError{Retry: m.retry}
+	if m.template == "" || acceptsJSON(r) {
+		s.Error(w, r, err)
+		return true
+	}
+	recordRequestError(w, err)
+	setRetryAfter(w, err)
+	w.Header().Set("Content-Type", templates.ContentType(m.template))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	s.Render(w, m.template, m.data)
+	return true
+}
@@ -0,0 +1,112 @@
+package serverlib
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMaintenance serves the requests of a server in maintenance: the
+// allowed paths and clients are served, the others refused with a 503 and
+// a Retry-After.
+func TestMaintenance(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []MaintenanceOption
+		target         string
+		remoteAddr     string
+		accept         string
+		wantCode       int
+		wantRetryAfter string
+		wantBody       string
+	}{
+		{"refused", nil, "/page", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"retry after", []MaintenanceOption{MaintenanceRetryAfter(90 * time.Second)}, "/page", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "90", ""},
+		{"allowed path", []MaintenanceOption{MaintenanceAllowPaths("/healthz")}, "/healthz", "192.0.2.1:1234", "", http.StatusOK, "", "/healthz"},
+		{"allowed prefix", []MaintenanceOption{MaintenanceAllowPaths("/admin/")}, "/admin/users", "192.0.2.1:1234", "", http.StatusOK, "", "/admin/users"},
+		{"below the allowed path", []MaintenanceOption{MaintenanceAllowPaths("/healthz")}, "/healthz/live", "192.0.2.1:1234", "", http.StatusOK, "", "/healthz/live"},
+		{"allowed directory", []MaintenanceOption{MaintenanceAllowPaths("/admin/")}, "/admin/", "192.0.2.1:1234", "", http.StatusOK, "", "/admin/"},
+		{"all allowed", []MaintenanceOption{MaintenanceAllowPaths("/")}, "/page", "192.0.2.1:1234", "", http.StatusOK, "", "/page"},
+		{"longer segment", []MaintenanceOption{MaintenanceAllowPaths("/healthz")}, "/healthzpage", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"dot segments", []MaintenanceOption{MaintenanceAllowPaths("/healthz")}, "/healthz/%2e%2e/page", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"escaped slashes", []MaintenanceOption{MaintenanceAllowPaths("/healthz")}, "/healthz%2F..%2Fpage", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"cleaned into the allowed path", []MaintenanceOption{MaintenanceAllowPaths("/healthz")}, "/page/%2e%2e/healthz", "192.0.2.1:1234", "", http.StatusOK, "", "/page/../healthz"},
+		{"other path", []MaintenanceOption{MaintenanceAllowPaths("/admin/")}, "/page", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"prefix not matched", []MaintenanceOption{MaintenanceAllowPaths("/admin/")}, "/admin", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"allowed address", []MaintenanceOption{MaintenanceAllowIPs("192.0.2.1")}, "/page", "192.0.2.1:1234", "", http.StatusOK, "", "/page"},
+		{"other address", []MaintenanceOption{MaintenanceAllowIPs("192.0.2.1")}, "/page", "192.0.2.2:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"allowed network", []MaintenanceOption{MaintenanceAllowIPs("10.0.0.0/8")}, "/page", "10.1.2.3:1234", "", http.StatusOK, "", "/page"},
+		{"other network", []MaintenanceOption{MaintenanceAllowIPs("10.0.0.0/8")}, "/page", "11.1.2.3:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"mapped IPv4", []MaintenanceOption{MaintenanceAllowIPs("192.0.2.1")}, "/page", "[::ffff:192.0.2.1]:1234", "", http.StatusOK, "", "/page"},
+		{"IPv6 network", []MaintenanceOption{MaintenanceAllowIPs("2001:db8::/32")}, "/page", "[2001:db8::1]:1234", "", http.StatusOK, "", "/page"},
+		{"zoned address", []MaintenanceOption{MaintenanceAllowIPs("fe80::/10")}, "/page", "[fe80::1%eth0]:1234", "", http.StatusServiceUnavailable, "300", ""},
+		{"invalid address ignored", []MaintenanceOption{MaintenanceAllowIPs("not an address", "192.0.2.1")}, "/page", "192.0.2.1:1234", "", http.StatusOK, "", "/page"},
+		{"invalid remote address", []MaintenanceOption{MaintenanceAllowIPs("192.0.2.1")}, "/page", "pipe", "", http.StatusServiceUnavailable, "300", ""},
+		{"template", []MaintenanceOption{MaintenanceTemplate("maintenance.html", map[string]any{"Back": "soon"})}, "/page", "192.0.2.1:1234", "", http.StatusServiceUnavailable, "300", "<p>back soon</p>"},
+		{"template, JSON client", []MaintenanceOption{MaintenanceTemplate("maintenance.html", nil)}, "/page", "192.0.2.1:1234", "application/json", http.StatusServiceUnavailable, "300", `"status":503`},
+	}
+	for _, tt := range tests {
+		s := newTemplateServer(t, map[string]string{"maintenance.html": "<p>back {{.Back}}</p>"}, nil)
+		s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path))
+		})
+		s.SetMaintenance(true, tt.opts...)
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantCode || w.Header().Get("Retry-After") != tt.wantRetryAfter {
+			t.Errorf("%s: %d Retry-After %q, want %d %q", tt.name, w.Code, w.Header().Get("Retry-After"), tt.wantCode, tt.wantRetryAfter)
+		}
+		if !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+// TestSetMaintenance switches the maintenance mode on and off: each call
+// replaces the options of the previous one.
+func TestSetMaintenance(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	s := NewServer(ServerConfig{})
+	s.GET("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	if s.InMaintenance() || get("/healthz") != http.StatusOK {
+		t.Fatal("in maintenance once created")
+	}
+	s.SetMaintenance(true, MaintenanceAllowPaths("/healthz"))
+	if !s.InMaintenance() || get("/healthz") != http.StatusOK {
+		t.Error("allowed path refused")
+	}
+	s.SetMaintenance(true)
+	if code := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("options of the previous call kept: %d", code)
+	}
+	s.SetMaintenance(false)
+	if s.InMaintenance() || get("/healthz") != http.StatusOK {
+		t.Error("in maintenance once disabled")
+	}
+	if strings.Count(logs.String(), "Maintenance mode enabled") != 2 || strings.Count(logs.String(), "Maintenance mode disabled") != 1 {
+		t.Errorf("logs %s", logs.String())
+	}
+	// Disabling twice logs once.
+	s.SetMaintenance(false)
+	if strings.Count(logs.String(), "Maintenance mode disabled") != 1 {
+		t.Errorf("logs %s", logs.String())
+	}
+}
@@ -0,0 +1,56 @@
+package serverlib
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// methodPattern returns the pattern of method, e.g. "GET /users/{id}". It
+// panics on a pattern with a method already, or not starting with a path or
+// a host, like http.ServeMux does for the invalid patterns.
+func methodPattern(method, pattern string) string {
+	if pattern == "" || strings.ContainsAny(pattern, " \t") {
+		panic(fmt.Sprintf("serverlib: invalid %s pattern %q: expected a path, e.g. \"/users/{id}\", without a method", method, pattern))
+	}
+	return method + " " + pattern
+}
+
+// GET registers the handler of the GET requests of the pattern, e.g.
+// "/users/{id}"; it serves the HEAD requests too, see http.ServeMux. The
+// middlewares wrap the route only, as for HandleFunc.
+func (s *Server) GET(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodGet, pattern), handler, mw...)
+}
+
+// POST registers the handler of the POST requests of the pattern, see GET.
+func (s *Server) POST(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodPost, pattern), handler, mw...)
+}
+
+// PUT registers the handler of the PUT requests of the pattern, see GET.
+func (s *Server) PUT(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodPut, pattern), handler, mw...)
+}
+
+// PATCH registers the handler of the PATCH requests of the pattern, see GET.
+func (s *Server) PATCH(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodPatch, pattern), handler, mw...)
+}
+
+// DELETE registers the handler of the DELETE requests of the pattern, see GET.
+func (s *Server) DELETE(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodDelete, pattern), handler, mw...)
+}
+
+// HEAD registers the handler of the HEAD requests of the pattern, instead of
+// the one of GET, see GET.
+func (s *Server) HEAD(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodHead, pattern), handler, mw...)
+}
+
+// OPTIONS registers the handler of the OPTIONS requests of the pattern, see
+// GET and ServerConfig.DisableGeneralOptionsHandler.
+func (s *Server) OPTIONS(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
+	return s.HandleFunc(methodPattern(http.MethodOptions, pattern), handler, mw...)
+}
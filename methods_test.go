@@ -0,0 +1,85 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMethods routes the requests to the handlers registered by method.
+func TestMethods(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	answer := func(name string) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", name)
+		}
+	}
+	s.GET("/items/{id}", answer("get"))
+	s.POST("/items", answer("post"))
+	s.PUT("/items/{id}", answer("put"))
+	s.PATCH("/items/{id}", answer("patch"))
+	s.DELETE("/items/{id}", answer("delete"))
+	s.OPTIONS("/items", answer("options"))
+	s.GET("/pages", answer("get pages"))
+	s.HEAD("/pages", answer("head pages"))
+	s.GET("example.com/hosted", answer("hosted"))
+	s.GET("/traced", answer("traced"), tag("route"))
+	tests := []struct {
+		method      string
+		target      string
+		host        string
+		wantCode    int
+		wantHandler string
+	}{
+		{http.MethodGet, "/items/1", "", http.StatusOK, "get"},
+		{http.MethodHead, "/items/1", "", http.StatusOK, "get"},
+		{http.MethodPost, "/items", "", http.StatusOK, "post"},
+		{http.MethodPut, "/items/1", "", http.StatusOK, "put"},
+		{http.MethodPatch, "/items/1", "", http.StatusOK, "patch"},
+		{http.MethodDelete, "/items/1", "", http.StatusOK, "delete"},
+		{http.MethodOptions, "/items", "", http.StatusOK, "options"},
+		{http.MethodHead, "/pages", "", http.StatusOK, "head pages"},
+		{http.MethodGet, "/pages", "", http.StatusOK, "get pages"},
+		{http.MethodGet, "/hosted", "example.com", http.StatusOK, "hosted"},
+		{http.MethodGet, "/hosted", "other.example.com", http.StatusNotFound, ""},
+		{http.MethodPost, "/items/1", "", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/items", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.host != "" {
+			r.Host = tt.host
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantCode || w.Header().Get("X-Handler") != tt.wantHandler {
+			t.Errorf("%s %s: %d %q, want %d %q", tt.method, tt.target, w.Code, w.Header().Get("X-Handler"), tt.wantCode, tt.wantHandler)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/traced", nil))
+	if w.Header().Get("X-Trace") != "route" {
+		t.Errorf("route middleware not run: %q", w.Header().Get("X-Trace"))
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/items/1", nil))
+	if allow := w.Header().Get("Allow"); !strings.Contains(allow, http.MethodPut) || !strings.Contains(allow, http.MethodDelete) {
+		t.Errorf("Allow %q", allow)
+	}
+}
+
+// TestMethodsInvalidPattern checks the patterns with a method, or without a
+// path, panic.
+func TestMethodsInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"", "GET /items", "/items list", "/items\t"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: no panic", pattern)
+				}
+			}()
+			NewServer(ServerConfig{}).GET(pattern, func(http.ResponseWriter, *http.Request) {})
+		}()
+	}
+}
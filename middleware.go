@@ -0,0 +1,60 @@
+package serverlib
+
+import "net/http"
+
+// Middleware wraps an http.Handler with additional behavior.
+type Middleware func(http.Handler) http.Handler
+
+// wrapMiddlewares wraps handler with the middlewares, the first one
+// outermost. The middlewares declaring no security requirements keep the
+// ones of the handlers they wrap, see RouteSecurity.
+func wrapMiddlewares(handler http.Handler, mw []Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		next := handler
+		handler = mw[i](next)
+		if _, ok := handler.(RouteSecurity); ok {
+			continue
+		}
+		if _, ok := next.(RouteSecurity); ok {
+			handler = Secured(handler, next, Security{})
+		}
+	}
+	return handler
+}
+
+// Use adds middlewares wrapping every route of the server, including the
+// ones registered before, e.g. a logger, an authentication or a recovery. The
+// middlewares run in the order they are added, the first one outermost, after
+// the server pipeline (the request ID, the session, the limits...) and before
+// the route is matched. They also wrap ServerConfig.Handler, and the handlers
+// mounted with MountHandler unless WithoutMiddleware. Use may be called while
+// the server is running: the new middlewares apply to the requests starting
+// from then on.
+//
+// Parameters:
+//   - mw: The middlewares to add.
+func (s *Server) Use(mw ...Middleware) {
+	s.injector.use(mw)
+}
+
+// use adds the middlewares and rebuilds the chain.
+func (i *contextInjector) use(mw []Middleware) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.middlewares = append(i.middlewares, mw...)
+	handler := wrapMiddlewares(http.HandlerFunc(i.route), i.middlewares)
+	i.routed.Store(&handler)
+}
+
+// chain returns the route wrapped by the middlewares of Use.
+func (i *contextInjector) chain() http.Handler {
+	if handler := i.routed.Load(); handler != nil {
+		return *handler
+	}
+	// Kept, not to allocate the method value on every request.
+	handler := http.Handler(http.HandlerFunc(i.route))
+	if i.routed.CompareAndSwap(nil, &handler) {
+		return handler
+	}
+	return *i.routed.Load()
+}
@@ -0,0 +1,147 @@
+package serverlib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tag is a middleware appending its name to the X-Trace header of the
+// response.
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TestUse wraps the routes registered before and after Use, the fallback
+// handler and the mounted handlers with the middlewares, the first one
+// outermost.
+func TestUse(t *testing.T) {
+	s := NewServer(ServerConfig{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fallback"))
+	})})
+	s.GET("/before", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("before"))
+	})
+	s.Use(tag("first"), tag("second"))
+	s.Use(tag("third"))
+	s.GET("/after", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("after"))
+	})
+	mounted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mounted"))
+	})
+	s.MountHandler("/wrapped/", mounted)
+	s.MountHandler("/raw/", mounted, WithoutMiddleware())
+	tests := []struct {
+		target    string
+		wantBody  string
+		wantTrace string
+	}{
+		{"/before", "before", "first,second,third"},
+		{"/after", "after", "first,second,third"},
+		{"/legacy", "fallback", "first,second,third"},
+		{"/wrapped/page", "mounted", "first,second,third"},
+		{"/raw/page", "mounted", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if trace := strings.Join(w.Header().Values("X-Trace"), ","); w.Body.String() != tt.wantBody || trace != tt.wantTrace {
+			t.Errorf("%s: %q trace %q, want %q %q", tt.target, w.Body.String(), trace, tt.wantBody, tt.wantTrace)
+		}
+	}
+}
+
+// TestUseSession checks the middlewares run after the server pipeline, with
+// the session of the request, and before the route is matched.
+func TestUseSession(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := GetSession(w, r)
+			fmt.Fprintf(w, "session %v pattern %q ", ok, r.Pattern)
+			next.ServeHTTP(w, r)
+		})
+	})
+	s.GET("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if want := `session true pattern "" page`; w.Body.String() != want {
+		t.Errorf("%q, want %q", w.Body.String(), want)
+	}
+}
+
+// TestUseConcurrent adds middlewares while requests are served: each request
+// runs a complete chain, and the requests starting once Use returned run the
+// new middleware.
+func TestUseConcurrent(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+	var served sync.WaitGroup
+	for range 4 {
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			for range 100 {
+				s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+		}()
+	}
+	for i := range 10 {
+		s.Use(tag(fmt.Sprint(i)))
+	}
+	served.Wait()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if trace := strings.Join(w.Header().Values("X-Trace"), ","); trace != "0,1,2,3,4,5,6,7,8,9" {
+		t.Errorf("trace %q once added", trace)
+	}
+}
+
+// TestRouteMiddlewares wraps single routes with the middlewares given to
+// Handle and HandleFunc, inside the ones of Use.
+func TestRouteMiddlewares(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Use(tag("use"))
+	page := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page"))
+	}
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		})
+	}
+	s.HandleFunc("GET /func", page, tag("first"), tag("second"))
+	s.Handle("GET /handler", http.HandlerFunc(page), tag("first"))
+	s.HandleFunc("GET /plain", page)
+	s.HandleFunc("GET /denied", page, tag("first"), deny, tag("second"))
+	tests := []struct {
+		target    string
+		wantCode  int
+		wantBody  string
+		wantTrace string
+	}{
+		{"/func", http.StatusOK, "page", "use,first,second"},
+		{"/handler", http.StatusOK, "page", "use,first"},
+		{"/plain", http.StatusOK, "page", "use"},
+		{"/denied", http.StatusForbidden, "forbidden\n", "use,first"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		trace := strings.Join(w.Header().Values("X-Trace"), ",")
+		if w.Code != tt.wantCode || w.Body.String() != tt.wantBody || trace != tt.wantTrace {
+			t.Errorf("%s: %d %q trace %q, want %d %q %q", tt.target, w.Code, w.Body.String(), trace, tt.wantCode, tt.wantBody, tt.wantTrace)
+		}
+	}
+}
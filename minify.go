@@ -0,0 +1,252 @@
+package serverlib
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"mime"
+
+	"golang.org/x/net/html"
+)
+
+// minifyBlockElements are the elements around which a whitespace-only text
+// doesn't render, and is dropped by MinifyHTML.
+var minifyBlockElements = map[string]bool{
+	"html": true, "head": true, "body": true, "title": true, "meta": true,
+	"link": true, "script": true, "style": true, "noscript": true, "base": true,
+	"div": true, "p": true, "ul": true, "ol": true, "li": true, "dl": true,
+	"dt": true, "dd": true, "table": true, "thead": true, "tbody": true,
+	"tfoot": true, "tr": true, "td": true, "th": true, "caption": true,
+	"colgroup": true, "col": true, "section": true, "article": true,
+	"aside": true, "header": true, "footer": true, "nav": true, "main": true,
+	"form": true, "fieldset": true, "legend": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "hr": true, "br": true,
+	"blockquote": true, "figure": true, "figcaption": true, "details": true,
+	"summary": true, "option": true, "optgroup": true, "template": true,
+	"pre": true, "textarea": true, "address": true, "menu": true, "dialog": true,
+}
+
+// minifyPreserved are the elements whose content is left untouched.
+var minifyPreserved = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+}
+
+// MinifyHTML is a conservative HTML minifier, an HTMLTransformer: the
+// whitespace between tags is dropped next to block elements and collapsed to
+// a single space elsewhere, the runs of whitespace of the texts are collapsed,
+// and the comments are stripped, the conditional ones excepted. The tags
+// themselves are copied as is, and the content of pre, textarea, script and
+// style is left untouched.
+//
+// Parameters:
+//   - document: The HTML document.
+//
+// Returns:
+//   - []byte: The minified document.
+//   - error: The error of the tokenizer; the document is to be sent as is.
+func MinifyHTML(document []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(document)))
+	z := html.NewTokenizer(bytes.NewReader(document))
+	consumed := 0
+	preserved := 0
+	// A whitespace-only text waiting for the next tag, and whether the tag
+	// before it is a block element.
+	pendingSpace := false
+	afterBlock := true
+	// Whether the output ends with the space of a text, for the texts around
+	// a stripped comment not to leave two spaces.
+	trailingSpace := false
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			return tokenizerEnd(z, out, document, consumed)
+		}
+		// The bytes of the document rather than z.Raw(), which TagName
+		// lowercases in place.
+		raw := document[consumed : consumed+len(z.Raw())]
+		consumed += len(raw)
+		switch tokenType {
+		case html.TextToken:
+			if preserved > 0 {
+				out.Write(raw)
+				continue
+			}
+			collapsed := collapseWhitespace(raw)
+			if len(bytes.TrimSpace(collapsed)) == 0 {
+				pendingSpace = true
+				continue
+			}
+			if pendingSpace && !afterBlock && !trailingSpace {
+				out.WriteByte(' ')
+			}
+			pendingSpace = false
+			if afterBlock || trailingSpace {
+				collapsed = bytes.TrimLeft(collapsed, " ")
+			}
+			out.Write(collapsed)
+			afterBlock = false
+			trailingSpace = bytes.HasSuffix(out.Bytes(), []byte(" "))
+		case html.CommentToken:
+			if preserved > 0 || isConditionalComment(z.Text()) {
+				out.Write(raw)
+				trailingSpace = false
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			block := minifyBlockElements[string(name)]
+			if pendingSpace && !afterBlock && !block && !trailingSpace {
+				out.WriteByte(' ')
+			}
+			pendingSpace = false
+			trailingSpace = false
+			if minifyPreserved[string(name)] {
+				switch tokenType {
+				case html.StartTagToken:
+					preserved++
+				case html.EndTagToken:
+					preserved = max(preserved-1, 0)
+				}
+			}
+			out.Write(raw)
+			afterBlock = block
+		default:
+			// The doctype.
+			pendingSpace = false
+			trailingSpace = false
+			out.Write(raw)
+			afterBlock = true
+		}
+	}
+}
+
+// isConditionalComment reports whether a comment is an Internet Explorer
+// conditional comment, such as <!--[if lt IE 9]>...<![endif]-->.
+func isConditionalComment(text []byte) bool {
+	return bytes.HasPrefix(text, []byte("[if ")) || bytes.HasPrefix(text, []byte("<![endif]")) || bytes.HasSuffix(text, []byte("<![endif]"))
+}
+
+// isHTMLSpace reports whether c is an ASCII whitespace of HTML.
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// collapseWhitespace replaces the runs of whitespace of text by a single space.
+func collapseWhitespace(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	space := false
+	for _, c := range text {
+		if isHTMLSpace(c) {
+			space = true
+			continue
+		}
+		if space {
+			out = append(out, ' ')
+			space = false
+		}
+		out = append(out, c)
+	}
+	if space {
+		out = append(out, ' ')
+	}
+	return out
+}
+
+// MinifyCSS is a conservative CSS minifier: the comments are stripped, the
+// ones starting with /*! (licenses) excepted, the runs of whitespace are
+// collapsed, and the whitespace around braces, semicolons and commas is
+// dropped along with the last semicolon of a block. Strings are copied as is.
+//
+// Parameters:
+//   - css: The style sheet.
+//
+// Returns:
+//   - []byte: The minified style sheet.
+//   - error: An unterminated comment or string; the style sheet is to be
+//     served as is.
+func MinifyCSS(css []byte) ([]byte, error) {
+	out := make([]byte, 0, len(css))
+	space := false
+	tight := func(c byte) bool {
+		return c == '{' || c == '}' || c == ';' || c == ','
+	}
+	last := func() byte {
+		if len(out) == 0 {
+			return '{'
+		}
+		return out[len(out)-1]
+	}
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := bytes.Index(css[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errMinifyUnterminated
+			}
+			if i+2 < len(css) && css[i+2] == '!' {
+				out = append(out, css[i:i+2+end+2]...)
+			} else {
+				// A comment separates like a whitespace.
+				space = true
+			}
+			i += 2 + end + 1
+		case isHTMLSpace(c):
+			space = true
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(css) && css[end] != c {
+				if css[end] == '\\' {
+					end++
+				} else if css[end] == '\n' {
+					return nil, errMinifyUnterminated
+				}
+				end++
+			}
+			if end >= len(css) {
+				return nil, errMinifyUnterminated
+			}
+			if space && !tight(last()) {
+				out = append(out, ' ')
+			}
+			space = false
+			out = append(out, css[i:end+1]...)
+			i = end
+		default:
+			if c == '}' && last() == ';' {
+				out = out[:len(out)-1]
+			}
+			if space && !tight(c) && !tight(last()) {
+				out = append(out, ' ')
+			}
+			space = false
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// errMinifyUnterminated is the error of MinifyCSS for an unterminated
+// comment or string.
+var errMinifyUnterminated = errors.New("minify: unterminated comment or string")
+
+// minifyRendered minifies the rendered template when ServerConfig.MinifyHTML
+// is set and its content type is HTML. A failure is logged and the rendering
+// is kept as is.
+func (s *Server) minifyRendered(buf *bytes.Buffer, template, contentType string) {
+	if !s.config.MinifyHTML || !isHTMLContentType(contentType) {
+		return
+	}
+	minified, err := MinifyHTML(buf.Bytes())
+	if err != nil {
+		slog.Warn("HTML minification skipped", "template", template, "error", err)
+		return
+	}
+	buf.Reset()
+	buf.Write(minified)
+}
+
+// isHTMLContentType reports whether contentType is text/html.
+func isHTMLContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "text/html"
+}
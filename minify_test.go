@@ -0,0 +1,195 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{"between blocks", "<div>\n  <p>a</p>\n  <p>b</p>\n</div>", "<div><p>a</p><p>b</p></div>"},
+		{"between inline elements", "<b>a</b>\n   <i>b</i>", "<b>a</b> <i>b</i>"},
+		{"text runs", "<p>a  \n\t b</p>", "<p>a b</p>"},
+		{"after a block", "<p>\n  text</p>", "<p>text</p>"},
+		{"comment", "<p>a<!-- note -->b</p>", "<p>ab</p>"},
+		{"comment between spaces", "<p>a <!-- note --> b</p>", "<p>a b</p>"},
+		{"comment before an inline element", "<p>a <!-- note -->\n <b>b</b></p>", "<p>a <b>b</b></p>"},
+		{"conditional comment", "<!--[if IE]><p>old</p><![endif]-->", "<!--[if IE]><p>old</p><![endif]-->"},
+		{"pre", "<div>\n<pre>  a\n\n  b <!-- c --></pre>\n</div>", "<div><pre>  a\n\n  b <!-- c --></pre></div>"},
+		{"nested in pre", "<pre><code>  a  </code>  <b> b </b></pre>", "<pre><code>  a  </code>  <b> b </b></pre>"},
+		{"textarea", "<textarea>\n  <b>  a  </b>\n</textarea>", "<textarea>\n  <b>  a  </b>\n</textarea>"},
+		{"script", "<script>\n  if (a <  b) { s = \"  </p>  \" }\n</script>", "<script>\n  if (a <  b) { s = \"  </p>  \" }\n</script>"},
+		{"style", "<style>\n  p   { margin: 0 }\n</style>", "<style>\n  p   { margin: 0 }\n</style>"},
+		{"after pre", "<pre> a </pre>\n  <p> b  c </p>", "<pre> a </pre><p>b c </p>"},
+		{"tags as is", "<P  CLASS='x'\n  id=y>a</P>", "<P  CLASS='x'\n  id=y>a</P>"},
+		{"entities", "<p>a &nbsp; &amp;  b</p>", "<p>a &nbsp; &amp; b</p>"},
+		{"doctype", "<!DOCTYPE html>\n<html>\n</html>", "<!DOCTYPE html><html></html>"},
+		{"truncated", "<p>a</p>\n<div class=\"x", "<p>a</p><div class=\"x"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		got, err := MinifyHTML([]byte(tt.document))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: %q %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// preservedRegions matches the elements whose content MinifyHTML leaves
+// untouched.
+var preservedRegions = regexp.MustCompile(`(?s)<(pre|textarea|script|style)\b[^>]*>.*?</(pre|textarea|script|style)>`)
+
+// TestMinifyHTMLGolden minifies a representative page and compares it to
+// testdata/minify/page.golden; the pre, textarea, script and style elements
+// are copied byte for byte. Run with -update to rewrite the golden file.
+func TestMinifyHTMLGolden(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "minify", "page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := MinifyHTML(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "minify", "page.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("minified page:\n%s\nwant:\n%s", got, want)
+	}
+	regions := preservedRegions.FindAll(page, -1)
+	if len(regions) != 5 {
+		t.Fatalf("%d preserved regions in the page, want 5", len(regions))
+	}
+	for _, region := range regions {
+		if !bytes.Contains(got, region) {
+			t.Errorf("%s changed", region)
+		}
+	}
+	if len(got) >= len(page)*4/5 {
+		t.Errorf("minified to %d of %d bytes", len(got), len(page))
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	tests := []struct {
+		name    string
+		css     string
+		want    string
+		wantErr bool
+	}{
+		{"rules", "body {\n  margin: 0;\n  padding: 0 1em;\n}\n", "body{margin: 0;padding: 0 1em}", false},
+		{"selectors", "h1,\nh2 > a ,  p + p {\n  color: red\n}", "h1,h2 > a,p + p{color: red}", false},
+		{"comments", "/* layout */\na { /* x */ color: blue; }", "a{color: blue}", false},
+		{"license", "/*! MIT */\na{}", "/*! MIT */ a{}", false},
+		{"comment separating", "a/**/b{}", "a b{}", false},
+		{"strings", `a::before { content: "  ;  {  "; }`, `a::before{content: "  ;  {  "}`, false},
+		{"escaped quote", `a { content: 'it\'s  ; ' }`, `a{content: 'it\'s  ; '}`, false},
+		{"calc", "a { width: calc(100%  -  2px) }", "a{width: calc(100% - 2px)}", false},
+		{"media", "@media (max-width: 600px) {\n  a { color: red; }\n}", "@media (max-width: 600px){a{color: red}}", false},
+		{"unterminated comment", "a { color: red } /* open", "", true},
+		{"unterminated string", "a::before { content: \"open }", "", true},
+		{"newline in a string", "a::before { content: \"a\nb\" }", "", true},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		got, err := MinifyCSS([]byte(tt.css))
+		if (err != nil) != tt.wantErr || string(got) != tt.want {
+			t.Errorf("%s: %q %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// TestMinifyRendered checks ServerConfig.MinifyHTML minifies the HTML
+// renderings, buffered, streamed and static, and the built-in error pages,
+// but not the other content types.
+func TestMinifyRendered(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	files := map[string]string{
+		"page.html": "<div>\n  <p>{{.Name}}</p>\n</div>\n",
+		"notes.txt": "<div>\n  {{.Name}}\n</div>\n",
+	}
+	data := map[string]any{"Name": "a"}
+	tests := []struct {
+		name   string
+		minify bool
+		render func(s *Server, w http.ResponseWriter, r *http.Request)
+		want   string
+	}{
+		{"Render", true, func(s *Server, w http.ResponseWriter, r *http.Request) { s.Render(w, "page.html", data) }, "<div><p>a</p></div>"},
+		{"RenderRequest", true, func(s *Server, w http.ResponseWriter, r *http.Request) { s.RenderRequest(w, r, "page.html", data) }, "<div><p>a</p></div>"},
+		{"streamed", true, func(s *Server, w http.ResponseWriter, r *http.Request) {
+			s.RenderRequest(w, r, "page.html", data, Streaming())
+		}, "<div>\n  <p>a</p>\n</div>\n"},
+		{"text", true, func(s *Server, w http.ResponseWriter, r *http.Request) { s.RenderRequest(w, r, "notes.txt", data) }, "<div>\n  a\n</div>\n"},
+		{"error page", true, func(s *Server, w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Accept", "text/html")
+			s.Error(w, r, NewHTTPError(http.StatusNotFound, "Not Found"))
+		}, ""},
+		{"disabled", false, func(s *Server, w http.ResponseWriter, r *http.Request) { s.Render(w, "page.html", data) }, "<div>\n  <p>a</p>\n</div>\n"},
+	}
+	for _, tt := range tests {
+		s := newTemplateServer(t, files, nil)
+		s.config.MinifyHTML = tt.minify
+		w := httptest.NewRecorder()
+		tt.render(s, w, httptest.NewRequest(http.MethodGet, "/", nil))
+		got := w.Body.String()
+		if tt.want == "" {
+			if !strings.Contains(got, "<html lang=\"en\"><head>") || strings.Contains(got, ">\n<") {
+				t.Errorf("%s: not minified: %q", tt.name, got)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	s := newTemplateServer(t, files, nil)
+	s.config.MinifyHTML = true
+	dir := t.TempDir()
+	if err := s.RenderStatic(context.Background(), dir, []StaticPage{{Path: "/", Template: "page.html", Data: data}}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "index.html")); !strings.HasPrefix(string(content), "<div><p>") {
+		t.Errorf("RenderStatic: %q", content)
+	}
+	if strings.Contains(logs.String(), "HTML minification skipped") {
+		t.Errorf("logged %q", logs.String())
+	}
+}
+
+// BenchmarkMinifyHTML minifies the representative page of testdata/minify.
+func BenchmarkMinifyHTML(b *testing.B) {
+	page, err := os.ReadFile(filepath.Join("testdata", "minify", "page.html"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(page)))
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := MinifyHTML(page); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package serverlib
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// MountOption configures how a handler is mounted with MountHandler.
+type MountOption func(*mountConfig)
+
+type mountConfig struct {
+	stripPrefix    bool
+	headers        map[string]func(sessions.Session) string
+	contextKeys    []any
+	skipMiddleware bool
+	isolatePanics  bool
+}
+
+// mount is a handler mounted under a path prefix.
+type mount struct {
+	prefix  string
+	handler http.Handler
+}
+
+// matches reports whether path is served by the mount.
+func (m *mount) matches(path string) bool {
+	if m.prefix == "/" {
+		return true
+	}
+	_, ok := trimPathPrefix(path, m.prefix)
+	return ok
+}
+
+// StripPrefix removes the mount prefix from the request path before the
+// mounted handler sees it, so "/metrics/foo" mounted on "/metrics" becomes "/foo".
+func StripPrefix() MountOption {
+	return func(c *mountConfig) {
+		c.stripPrefix = true
+	}
+}
+
+// WithIdentityHeader sets the header name on the request passed to the mounted
+// handler, with the value returned by identity for the current session. Any
+// value sent by the client for that header is removed first so it can't be spoofed.
+// An empty identity removes the header.
+func WithIdentityHeader(name string, identity func(sessions.Session) string) MountOption {
+	return func(c *mountConfig) {
+		if c.headers == nil {
+			c.headers = make(map[string]func(sessions.Session) string)
+		}
+		c.headers[http.CanonicalHeaderKey(name)] = identity
+	}
+}
+
+// WithSessionContextKey stores the current session in the request context under
+// the given key, for third-party code that expects its own context key.
+func WithSessionContextKey(key any) MountOption {
+	return func(c *mountConfig) {
+		c.contextKeys = append(c.contextKeys, key)
+	}
+}
+
+// WithoutMiddleware serves the mounted handler directly, skipping the server
+// request pipeline (session injection included). Identity options still work,
+// the session is then resolved by the mount itself.
+func WithoutMiddleware() MountOption {
+	return func(c *mountConfig) {
+		c.skipMiddleware = true
+	}
+}
+
+// IsolatePanics recovers panics raised by the mounted handler, logs them and
+// answers with a 500 instead of tearing the connection down.
+func IsolatePanics() MountOption {
+	return func(c *mountConfig) {
+		c.isolatePanics = true
+	}
+}
+
+// MountHandler mounts a standard http.Handler, such as a metrics handler or an
+// existing router, under the given path prefix.
+// The prefix is relative to the server base path: with StripBasePath the
+// mounted handler never sees the base path, and with StripPrefix it doesn't
+// see its mount prefix either. URLs generated by the mounted handler are not
+// rewritten, it must be configured with its public prefix on its own.
+//
+// Parameters:
+//   - prefix: The path prefix, e.g. "/metrics". Every path below it is routed to h.
+//   - h: The handler to mount.
+//   - opts: Optional mount options (prefix stripping, identity passthrough, ...).
+func (s *Server) MountHandler(prefix string, h http.Handler, opts ...MountOption) {
+	var config mountConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	handler := h
+	if config.stripPrefix && prefix != "/" {
+		handler = stripPrefix(prefix, handler)
+	}
+	if len(config.headers) > 0 || len(config.contextKeys) > 0 {
+		handler = s.identityPassthrough(&config, handler)
+	}
+	if config.isolatePanics {
+		handler = isolatePanics(prefix, handler)
+	}
+	// Escaped, a space or a brace of the prefix would be read as the method
+	// or a wildcard of the pattern.
+	pattern := strings.TrimSuffix((&url.URL{Path: prefix}).EscapedPath(), "/") + "/"
+	slog.Info("Mounted handler", "prefix", prefix)
+	if config.skipMiddleware {
+		s.injector.addMount(&mount{prefix: prefix, handler: handler})
+	} else {
+		s.router.Handle(pattern, handler)
+	}
+	// The wrappers of the options declare no security requirements.
+	route := s.addHandlerRoute(pattern, h, h, nil)
+	route.update(func(info *RouteInfo) { info.Mounted = true })
+}
+
+// Mount mounts a handler, e.g. another router or another Server, under the
+// path prefix, the prefix stripped: mounted on "/api/", the handler sees
+// "/api/users" as "/users". The prefix itself, "/api", is redirected to
+// "/api/", see http.ServeMux, and served as "/". The middlewares of Use wrap
+// the mounted handler, as the routes; it is listed once by Routes, as a
+// subtree.
+//
+//	s.Mount("/api/", apiRouter)
+//
+// Parameters:
+//   - prefix: The path prefix, e.g. "/api/".
+//   - h: The handler to mount.
+//   - opts: Optional mount options, see MountHandler.
+func (s *Server) Mount(prefix string, h http.Handler, opts ...MountOption) {
+	s.MountHandler(prefix, h, append([]MountOption{StripPrefix()}, opts...)...)
+}
+
+// identityPassthrough exposes the current session to the mounted handler
+// through the configured headers and context keys.
+func (s *Server) identityPassthrough(config *mountConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := reqctx.Session(r.Context())
+		if session == nil {
+			session, _ = s.GetSession(w, r)
+		}
+		r = r.Clone(r.Context())
+		for name, identity := range config.headers {
+			r.Header.Del(name)
+			if value := identity(session); value != "" {
+				r.Header.Set(name, value)
+			}
+		}
+		if len(config.contextKeys) > 0 {
+			ctx := r.Context()
+			for _, key := range config.contextKeys {
+				ctx = context.WithValue(ctx, key, session)
+			}
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isolatePanics recovers the panics of a mounted handler.
+func isolatePanics(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				slog.Error("Mounted handler panicked", "prefix", prefix, "path", r.URL.Path, "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stripPrefix removes prefix from both the decoded and the escaped request
+// paths. Unlike http.StripPrefix the escaped prefix is computed, so prefixes
+// containing characters that need escaping are stripped from RawPath correctly.
+func stripPrefix(prefix string, next http.Handler) http.Handler {
+	escaped := (&url.URL{Path: prefix}).EscapedPath()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := trimPathPrefix(r.URL.Path, prefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// The client may have escaped the prefix differently, in which case
+		// the raw path is dropped and the decoded one is authoritative.
+		rp, _ := trimPathPrefix(r.URL.RawPath, escaped)
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = p
+		r2.URL.RawPath = rp
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// trimPathPrefix removes prefix from path if path is the prefix itself or one
+// of its sub paths. The result always starts with a slash.
+func trimPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "/", true
+	}
+	if !strings.HasPrefix(path, prefix+"/") {
+		return "", false
+	}
+	return path[len(prefix):], true
+}
@@ -0,0 +1,178 @@
+package serverlib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// echo answers with what the mounted handler saw of the request.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "%s|%s", r.URL.Path, r.URL.RawPath)
+})
+
+func TestMountPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		mount  func(s *Server)
+		target string
+		want   string
+	}{
+		{"stripped", func(s *Server) { s.MountHandler("/metrics", echo, StripPrefix()) }, "/metrics/foo", "/foo|"},
+		{"kept", func(s *Server) { s.MountHandler("/metrics", echo) }, "/metrics/foo", "/metrics/foo|"},
+		{"Mount strips", func(s *Server) { s.Mount("/api/", echo) }, "/api/users/1", "/users/1|"},
+		{"escaped slash", func(s *Server) { s.Mount("/files", echo) }, "/files/a%2Fb", "/a/b|/a%2Fb"},
+		{"escaped prefix", func(s *Server) { s.Mount("/my files", echo) }, "/my%20files/x%2Fy", "/x/y|/x%2Fy"},
+		{"prefix escaped otherwise", func(s *Server) { s.Mount("/files", echo) }, "/fil%65s/a%2Fb", "/a/b|"},
+		{"without middleware, stripped", func(s *Server) { s.MountHandler("/raw", echo, StripPrefix(), WithoutMiddleware()) }, "/raw/x", "/x|"},
+		{"without middleware, the prefix itself", func(s *Server) { s.MountHandler("/raw", echo, StripPrefix(), WithoutMiddleware()) }, "/raw", "/|"},
+		{"without middleware, a longer segment", func(s *Server) {
+			s.MountHandler("/raw", echo, WithoutMiddleware())
+			s.GET("/rawr", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("route")) })
+		}, "/rawr", "route"},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		tt.mount(s)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if got := w.Body.String(); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("%s: GET %s = %d %q, want %q", tt.name, tt.target, w.Code, got, tt.want)
+		}
+	}
+}
+
+// contextKey is the context key of a third-party handler.
+type contextKey struct{}
+
+func TestMountIdentity(t *testing.T) {
+	user := func(session sessions.Session) string {
+		name, _ := session.Get("user").(string)
+		return name
+	}
+	seen := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := r.Context().Value(contextKey{}).(sessions.Session)
+		fromContext := ""
+		if session != nil {
+			fromContext = user(session)
+		}
+		fmt.Fprintf(w, "%s|%s", r.Header.Get("X-App-User"), fromContext)
+	})
+	tests := []struct {
+		name     string
+		opts     []MountOption
+		loggedIn bool
+		spoofed  string
+		want     string
+	}{
+		{"header", []MountOption{WithIdentityHeader("x-app-user", user)}, true, "", "alice|"},
+		{"context key", []MountOption{WithSessionContextKey(contextKey{})}, true, "", "|alice"},
+		{"spoofed header", []MountOption{WithIdentityHeader("X-App-User", user)}, true, "mallory", "alice|"},
+		{"spoofed header, anonymous", []MountOption{WithIdentityHeader("X-App-User", user)}, false, "mallory", "|"},
+		{"without middleware", []MountOption{WithIdentityHeader("X-App-User", user), WithoutMiddleware()}, true, "", "alice|"},
+		{"no identity option", nil, true, "mallory", "mallory|"},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		s.GET("/login", func(w http.ResponseWriter, r *http.Request) {
+			session, _ := s.GetSession(w, r)
+			session.Set("user", "alice")
+		})
+		s.MountHandler("/app", seen, tt.opts...)
+		r := httptest.NewRequest(http.MethodGet, "/app/page", nil)
+		if tt.loggedIn {
+			login := httptest.NewRecorder()
+			s.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/login", nil))
+			r.AddCookie(login.Result().Cookies()[0])
+		}
+		if tt.spoofed != "" {
+			r.Header.Set("X-App-User", tt.spoofed)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: the mounted handler saw %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMountIsolatePanics(t *testing.T) {
+	tests := []struct {
+		name      string
+		panicking http.HandlerFunc
+		wantPanic bool
+	}{
+		{"panic", func(http.ResponseWriter, *http.Request) { panic("boom") }, false},
+		{"ErrAbortHandler", func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) }, true},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		s.MountHandler("/vendor", tt.panicking, IsolatePanics(), WithoutMiddleware())
+		w := httptest.NewRecorder()
+		panicked := func() (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vendor/x", nil))
+			return false
+		}()
+		if panicked != tt.wantPanic {
+			t.Errorf("%s: panicked %v, want %v", tt.name, panicked, tt.wantPanic)
+		}
+		if !tt.wantPanic && w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status %d, want 500", tt.name, w.Code)
+		}
+	}
+}
+
+// TestMount mounts another server under a prefix: it serves the subtree
+// inside the middlewares of Use, the prefix itself redirected to it, and is
+// listed once by Routes.
+func TestMount(t *testing.T) {
+	api := NewServer(ServerConfig{})
+	api.GET("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "user %s", r.PathValue("id"))
+	})
+	api.GET("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api root"))
+	})
+	s := NewServer(ServerConfig{})
+	s.Use(tag("use"))
+	s.Mount("/api/", api)
+	tests := []struct {
+		target       string
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{"/api/users/1", http.StatusOK, "user 1", ""},
+		{"/api/", http.StatusOK, "api root", ""},
+		// The status of the redirect depends on the version of net/http.
+		{"/api", 0, "", "/api/"},
+		{"/api/missing", http.StatusNotFound, "", ""},
+		{"/apix", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		code := w.Code
+		if tt.wantCode == 0 && code/100 == 3 {
+			code = 0
+		}
+		if code != tt.wantCode || w.Header().Get("Location") != tt.wantLocation || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+			t.Errorf("%s: %d %q Location %q, want %d %q %q", tt.target, w.Code, w.Body.String(), w.Header().Get("Location"), tt.wantCode, tt.wantBody, tt.wantLocation)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("X-Trace") != "use" {
+			t.Errorf("%s: trace %q, want the middleware of Use", tt.target, w.Header().Get("X-Trace"))
+		}
+	}
+	routes := s.Routes()
+	if len(routes) != 1 || !routes[0].Mounted || routes[0].Path != "/api/" || routes[0].Handler != "serverlib.Server" {
+		t.Errorf("routes %+v", routes)
+	}
+	if table := s.RoutesTable(); !strings.Contains(table, "/api/*") {
+		t.Errorf("table without the subtree:\n%s", table)
+	}
+}
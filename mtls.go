@@ -0,0 +1,266 @@
+package serverlib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// ClientAuth configures the TLS client certificate authentication (mTLS) of
+// the server, see ServerConfig.ClientAuth.
+type ClientAuth struct {
+	// Mode is the client certificate policy, usually
+	// tls.RequireAndVerifyClientCert, or tls.VerifyClientCertIfGiven when
+	// only some routes require a certificate (see MTLSIdentity).
+	// Defaults to tls.RequireAndVerifyClientCert.
+	Mode tls.ClientAuthType
+	// CAs are the certificate authorities the client certificates are verified against.
+	CAs *x509.CertPool
+	// CAFile is a PEM file of certificate authorities, added to CAs. The
+	// client certificates are all rejected when it can't be loaded.
+	CAFile string
+	// VerifyPeerCertificate is an optional additional verification of the
+	// client certificates, see tls.Config.VerifyPeerCertificate.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	// Revocation rejects the revoked client certificates during the handshake.
+	Revocation RevocationChecker
+}
+
+// RevocationChecker tells whether a certificate has been revoked, e.g. from a
+// CRL or a denylist of serial numbers.
+type RevocationChecker interface {
+	IsRevoked(cert *x509.Certificate) (bool, error)
+}
+
+// SerialDenylist is a RevocationChecker rejecting the certificates whose
+// serial number, in decimal, is in the list.
+type SerialDenylist map[string]bool
+
+// IsRevoked implements RevocationChecker.
+func (d SerialDenylist) IsRevoked(cert *x509.Certificate) (bool, error) {
+	return d[cert.SerialNumber.String()], nil
+}
+
+// apply returns a copy of config with the client authentication settings,
+// the errors logged by s.
+func (c *ClientAuth) apply(config *tls.Config, s *Server) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.ClientAuth = c.Mode
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if c.CAs != nil {
+		config.ClientCAs = c.CAs
+	}
+	if c.CAFile != "" {
+		pool, err := loadCAFile(c.CAFile, c.CAs)
+		if err != nil {
+			s.LogError("Loading the client certificate authorities", err.Error())
+			// Fail closed: no client certificate verifies.
+			pool = x509.NewCertPool()
+		}
+		config.ClientCAs = pool
+	}
+	if c.VerifyPeerCertificate != nil {
+		config.VerifyPeerCertificate = c.VerifyPeerCertificate
+	}
+	if c.Revocation != nil {
+		// VerifyConnection also runs on resumed sessions, unlike VerifyPeerCertificate.
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if err := checkRevocation(c.Revocation, state); err != nil {
+				return err
+			}
+			if verify != nil {
+				return verify(state)
+			}
+			return nil
+		}
+	}
+	return config
+}
+
+// loadCAFile returns a copy of base, or a new pool, with the certificates of
+// the PEM file.
+func loadCAFile(path string, base *x509.CertPool) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if base != nil {
+		pool = base.Clone()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// checkRevocation fails if a certificate of the verified chains has been revoked.
+func checkRevocation(checker RevocationChecker, state tls.ConnectionState) error {
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			revoked, err := checker.IsRevoked(cert)
+			if err != nil {
+				return fmt.Errorf("checking certificate revocation: %w", err)
+			}
+			if revoked {
+				return fmt.Errorf("certificate %s of %q is revoked", cert.SerialNumber, cert.Subject.CommonName)
+			}
+		}
+	}
+	return nil
+}
+
+// MTLSOption configures MTLSIdentity.
+type MTLSOption func(*mtlsOptions)
+
+type mtlsOptions struct {
+	mapper     func(*x509.Certificate) (string, error)
+	optional   bool
+	sessionKey string
+}
+
+// WithPrincipalMapper sets the function mapping the verified client
+// certificate to a principal. Returning an error rejects the request with a 401.
+// Defaults to the subject common name, or the first DNS name of the certificate.
+func WithPrincipalMapper(mapper func(*x509.Certificate) (string, error)) MTLSOption {
+	return func(o *mtlsOptions) {
+		o.mapper = mapper
+	}
+}
+
+// MTLSOptional lets the requests without a verified client certificate
+// through, without a principal.
+func MTLSOptional() MTLSOption {
+	return func(o *mtlsOptions) {
+		o.optional = true
+	}
+}
+
+// StorePrincipalInSession stores the principal in the session under the given key, too.
+func StorePrincipalInSession(key string) MTLSOption {
+	return func(o *mtlsOptions) {
+		o.sessionKey = key
+	}
+}
+
+var errNoPrincipal = errors.New("client certificate has no principal")
+
+// defaultPrincipal returns the common name of the certificate, or its first DNS name.
+func defaultPrincipal(cert *x509.Certificate) (string, error) {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, nil
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0], nil
+	}
+	return "", errNoPrincipal
+}
+
+// MTLSIdentity returns a middleware authenticating the requests with their
+// verified TLS client certificate (see ServerConfig.ClientAuth). The principal
+// of the certificate is stored in the request context, see reqctx.Principal.
+// Requests without a verified certificate are answered with a 401, unless
+// MTLSOptional is given.
+//
+// Parameters:
+//   - opts: Optional mTLS options.
+//
+// Returns:
+//   - Middleware: The authentication middleware.
+func MTLSIdentity(opts ...MTLSOption) Middleware {
+	options := mtlsOptions{mapper: defaultPrincipal}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return func(next http.Handler) http.Handler {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := verifiedClientCert(r)
+			if cert == nil {
+				if options.optional {
+					next.ServeHTTP(w, r)
+					return
+				}
+				serverOf(r).Error(w, r, NewHTTPError(http.StatusUnauthorized, "client certificate required"))
+				return
+			}
+			principal, err := options.mapper(cert)
+			if err != nil {
+				serverOf(r).Error(w, r, &HTTPError{Status: http.StatusUnauthorized, Message: "client certificate rejected", Err: err})
+				return
+			}
+			if options.sessionKey != "" {
+				if session := reqctx.Session(r.Context()); session != nil {
+					session.Set(options.sessionKey, principal)
+				}
+			}
+			ctx := reqctx.WithPrincipal(r.Context(), principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+		security := Security{Schemes: []string{"mtls"}}
+		security.Auth = !options.optional
+		return Secured(handler, next, security)
+	}
+}
+
+// verifiedClientCert returns the verified client certificate of the request, or nil.
+// Certificates accepted without verification (tls.RequireAnyClientCert) don't count.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// ClientCert returns the verified TLS client certificate of the request, e.g.
+// to read its Subject, see ServerConfig.ClientAuth.
+//
+// Returns:
+//   - *x509.Certificate: The leaf certificate of the client.
+//   - bool: false when the request carries no verified certificate; the
+//     certificates accepted without verification (tls.RequireAnyClientCert)
+//     don't count.
+func ClientCert(r *http.Request) (*x509.Certificate, bool) {
+	cert := verifiedClientCert(r)
+	return cert, cert != nil
+}
+
+// RequireClientCert returns a middleware answering the requests with a 403
+// unless they carry a verified TLS client certificate (see
+// ServerConfig.ClientAuth) whose subject common name is one of allowedCNs.
+// Without allowedCNs, any verified certificate is accepted.
+//
+// Parameters:
+//   - allowedCNs: The common names allowed.
+//
+// Returns:
+//   - Middleware: The authorization middleware.
+func RequireClientCert(allowedCNs ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert, ok := ClientCert(r)
+			if !ok {
+				serverOf(r).Error(w, r, NewHTTPError(http.StatusForbidden, "client certificate required"))
+				return
+			}
+			if len(allowedCNs) > 0 && !slices.Contains(allowedCNs, cert.Subject.CommonName) {
+				serverOf(r).Error(w, r, NewHTTPError(http.StatusForbidden, "client certificate not allowed"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+		return Secured(handler, next, Security{Schemes: []string{"mtls"}, Auth: true})
+	}
+}
@@ -0,0 +1,335 @@
+package serverlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// testCA is a certificate authority issuing the client certificates of the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a client certificate of the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeServerCert writes a server certificate of the CA for localhost and
+// 127.0.0.1, with its key, as the PEM files cert.pem and key.pem of dir.
+func (ca *testCA) writeServerCert(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// startMTLSServer serves s over TLS with the client authentication of its configuration.
+func startMTLSServer(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = s.config.TLSConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// mtlsGet sends a GET with the client certificate, if any, and returns the
+// status and the body, or the error of the handshake.
+func mtlsGet(ts *httptest.Server, path string, cert *tls.Certificate) (int, string, error) {
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get(ts.URL + path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), nil
+}
+
+func TestMTLS(t *testing.T) {
+	ca, other := newTestCA(t, "internal CA"), newTestCA(t, "other CA")
+	billing := ca.issue(t, 10, "billing")
+	worker := ca.issue(t, 11, "", "worker.internal")
+	anonymous := ca.issue(t, 12, "")
+	revoked := ca.issue(t, 42, "billing")
+	foreign := other.issue(t, 10, "billing")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	principal := func(w http.ResponseWriter, r *http.Request) {
+		name, _ := reqctx.Principal(r.Context())
+		io.WriteString(w, name)
+	}
+	tests := []struct {
+		name          string
+		auth          ClientAuth
+		path          string
+		cert          *tls.Certificate
+		wantHandshake bool
+		wantStatus    int
+		wantBody      string
+	}{
+		{"common name", ClientAuth{CAs: ca.pool()}, "/identity", &billing, true, http.StatusOK, "billing"},
+		{"DNS name", ClientAuth{CAs: ca.pool()}, "/identity", &worker, true, http.StatusOK, "worker.internal"},
+		{"no principal", ClientAuth{CAs: ca.pool()}, "/identity", &anonymous, true, http.StatusUnauthorized, ""},
+		{"no certificate", ClientAuth{CAs: ca.pool()}, "/identity", nil, false, 0, ""},
+		{"other CA", ClientAuth{CAs: ca.pool()}, "/identity", &foreign, false, 0, ""},
+		{"revoked", ClientAuth{CAs: ca.pool(), Revocation: SerialDenylist{"42": true}}, "/identity", &revoked, false, 0, ""},
+		{"not revoked", ClientAuth{CAs: ca.pool(), Revocation: SerialDenylist{"42": true}}, "/identity", &billing, true, http.StatusOK, "billing"},
+		{"CA file", ClientAuth{CAFile: caFile}, "/identity", &billing, true, http.StatusOK, "billing"},
+		{"missing CA file", ClientAuth{CAFile: caFile + ".missing"}, "/identity", &billing, false, 0, ""},
+		{"VerifyPeerCertificate", ClientAuth{CAs: ca.pool(), VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+			return errors.New("denied")
+		}}, "/identity", &billing, false, 0, ""},
+		{"optional, without certificate", ClientAuth{Mode: tls.VerifyClientCertIfGiven, CAs: ca.pool()}, "/identity", nil, true, http.StatusUnauthorized, ""},
+		{"optional, with certificate", ClientAuth{Mode: tls.VerifyClientCertIfGiven, CAs: ca.pool()}, "/identity", &billing, true, http.StatusOK, "billing"},
+		{"MTLSOptional, without certificate", ClientAuth{Mode: tls.VerifyClientCertIfGiven, CAs: ca.pool()}, "/optional", nil, true, http.StatusOK, ""},
+		{"MTLSOptional, with certificate", ClientAuth{Mode: tls.VerifyClientCertIfGiven, CAs: ca.pool()}, "/optional", &billing, true, http.StatusOK, "billing"},
+		{"mapper", ClientAuth{CAs: ca.pool()}, "/mapped", &billing, true, http.StatusOK, "service:billing"},
+		{"mapper rejecting", ClientAuth{CAs: ca.pool()}, "/mapped", &worker, true, http.StatusUnauthorized, ""},
+		{"RequireClientCert", ClientAuth{CAs: ca.pool()}, "/billing", &billing, true, http.StatusOK, ""},
+		{"RequireClientCert, other name", ClientAuth{CAs: ca.pool()}, "/billing", &worker, true, http.StatusForbidden, ""},
+		{"RequireClientCert, without certificate", ClientAuth{Mode: tls.VerifyClientCertIfGiven, CAs: ca.pool()}, "/billing", nil, true, http.StatusForbidden, ""},
+		{"session", ClientAuth{CAs: ca.pool()}, "/session", &billing, true, http.StatusOK, "billing"},
+	}
+	for _, tt := range tests {
+		auth := tt.auth
+		s := NewServer(ServerConfig{ClientAuth: &auth})
+		s.GET("/identity", principal, MTLSIdentity())
+		s.GET("/optional", principal, MTLSIdentity(MTLSOptional()))
+		s.GET("/mapped", principal, MTLSIdentity(WithPrincipalMapper(func(cert *x509.Certificate) (string, error) {
+			if cert.Subject.CommonName == "" {
+				return "", errors.New("services only")
+			}
+			return "service:" + cert.Subject.CommonName, nil
+		})))
+		s.GET("/billing", func(http.ResponseWriter, *http.Request) {}, RequireClientCert("billing"))
+		s.GET("/session", func(w http.ResponseWriter, r *http.Request) {
+			session, _ := s.GetSession(w, r)
+			name, _ := session.Get("principal").(string)
+			io.WriteString(w, name)
+		}, MTLSIdentity(StorePrincipalInSession("principal")))
+		ts := startMTLSServer(t, s)
+		status, body, err := mtlsGet(ts, tt.path, tt.cert)
+		if (err == nil) != tt.wantHandshake {
+			t.Errorf("%s: error %v, want a handshake %v", tt.name, err, tt.wantHandshake)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if status != tt.wantStatus || (status == http.StatusOK && body != tt.wantBody) {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, status, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+// TestMTLSUnverified accepts any client certificate: the certificate isn't
+// verified, so it authenticates nobody.
+func TestMTLSUnverified(t *testing.T) {
+	ca := newTestCA(t, "self")
+	cert := ca.issue(t, 1, "billing")
+	s := NewServer(ServerConfig{ClientAuth: &ClientAuth{Mode: tls.RequireAnyClientCert}})
+	s.GET("/identity", func(http.ResponseWriter, *http.Request) {}, MTLSIdentity())
+	ts := startMTLSServer(t, s)
+	if status, _, err := mtlsGet(ts, "/identity", &cert); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("%d %v, want 401", status, err)
+	}
+}
+
+func TestLoadCAFile(t *testing.T) {
+	ca, other := newTestCA(t, "internal CA"), newTestCA(t, "other CA")
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	caFile := write("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+	empty := write("empty.pem", []byte("not a certificate"))
+	tests := []struct {
+		name    string
+		path    string
+		base    *x509.CertPool
+		wantErr bool
+		// want are the CAs of the pool.
+		want []*testCA
+	}{
+		{"file", caFile, nil, false, []*testCA{ca}},
+		{"added to the base", caFile, other.pool(), false, []*testCA{ca, other}},
+		{"missing", filepath.Join(dir, "missing.pem"), nil, true, nil},
+		{"without certificates", empty, nil, true, nil},
+	}
+	for _, tt := range tests {
+		pool, err := loadCAFile(tt.path, tt.base)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		for _, want := range tt.want {
+			cert := want.issue(t, 1, "client")
+			leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+				t.Errorf("%s: %s not in the pool: %v", tt.name, want.cert.Subject.CommonName, err)
+			}
+		}
+		if tt.base != nil && tt.base.Equal(pool) {
+			t.Errorf("%s: base pool modified", tt.name)
+		}
+	}
+}
+
+// TestClientCert checks only the verified certificates are returned.
+func TestClientCert(t *testing.T) {
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  *x509.Certificate
+	}{
+		{"plain HTTP", nil, nil},
+		{"no certificate", &tls.ConnectionState{}, nil},
+		{"unverified", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}, nil},
+		{"empty chain", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}, nil},
+		{"verified", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}, VerifiedChains: [][]*x509.Certificate{{leaf}}}, leaf},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.TLS = tt.state
+		got, ok := ClientCert(r)
+		if got != tt.want || ok != (tt.want != nil) {
+			t.Errorf("%s: %v %v, want %v", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+// TestRequireClientCertAny checks RequireClientCert without common names
+// accepts any verified certificate, but not an unverified one.
+func TestRequireClientCertAny(t *testing.T) {
+	ca := newTestCA(t, "internal CA")
+	worker := ca.issue(t, 1, "", "worker.internal")
+	tests := []struct {
+		name       string
+		auth       ClientAuth
+		cert       *tls.Certificate
+		wantStatus int
+	}{
+		{"verified", ClientAuth{CAs: ca.pool()}, &worker, http.StatusOK},
+		{"unverified", ClientAuth{Mode: tls.RequireAnyClientCert}, &worker, http.StatusForbidden},
+		{"without certificate", ClientAuth{Mode: tls.VerifyClientCertIfGiven, CAs: ca.pool()}, nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		auth := tt.auth
+		s := NewServer(ServerConfig{ClientAuth: &auth})
+		s.GET("/internal", func(http.ResponseWriter, *http.Request) {}, RequireClientCert())
+		ts := startMTLSServer(t, s)
+		status, _, err := mtlsGet(ts, "/internal", tt.cert)
+		if err != nil || status != tt.wantStatus {
+			t.Errorf("%s: %d %v, want %d", tt.name, status, err, tt.wantStatus)
+		}
+	}
+}
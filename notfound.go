@@ -0,0 +1,70 @@
+package serverlib
+
+import "net/http"
+
+// The templates rendered, when defined, for the requests matching no route,
+// see Server.NotFound and Server.MethodNotAllowed.
+const (
+	NotFoundTemplate         = "404.html"
+	MethodNotAllowedTemplate = "405.html"
+)
+
+// NotFound sets the handler of the requests matching no route, instead of
+// the 404 of the mux. By default, the template NotFoundTemplate is rendered
+// when defined, with the Status, Title, Detail and RequestID of the error
+// like the built-in error pages, otherwise the error goes through the error
+// handler, see Server.Error. ServerConfig.Handler, when set, serves these
+// requests first.
+//
+// Parameters:
+//   - handler: The handler, writing the status itself; nil restores the default.
+func (s *Server) NotFound(handler http.HandlerFunc) {
+	if handler == nil {
+		s.notFound.Store(nil)
+		return
+	}
+	s.notFound.Store(&handler)
+}
+
+// MethodNotAllowed sets the handler of the requests matching the path of a
+// route but none of its methods, instead of the 405 of the mux. The Allow
+// header listing the methods of the path is set already. By default, the
+// template MethodNotAllowedTemplate is rendered when defined, see NotFound.
+//
+// Parameters:
+//   - handler: The handler, writing the status itself; nil restores the default.
+func (s *Server) MethodNotAllowed(handler http.HandlerFunc) {
+	if handler == nil {
+		s.notAllowed.Store(nil)
+		return
+	}
+	s.notAllowed.Store(&handler)
+}
+
+// hasMissPages reports whether the requests matching no route are answered by
+// routeMissed even when they don't ask for an error page.
+func (s *Server) hasMissPages() bool {
+	return s.notFound.Load() != nil || s.notAllowed.Load() != nil ||
+		s.t.Has(NotFoundTemplate) || s.t.Has(MethodNotAllowedTemplate)
+}
+
+// routeMissed answers a request matching no route, status being the 404 or
+// the 405 of the mux.
+func (s *Server) routeMissed(w http.ResponseWriter, r *http.Request, status int) {
+	handler, name := s.notFound.Load(), NotFoundTemplate
+	if status == http.StatusMethodNotAllowed {
+		handler, name = s.notAllowed.Load(), MethodNotAllowedTemplate
+	}
+	if handler != nil {
+		(*handler)(w, r)
+		return
+	}
+	err := NewHTTPError(status, "")
+	if !acceptsJSON(r) && s.t.Has(name) {
+		recordRequestError(w, err)
+		if s.renderStatusPage(w, r, name, status, http.StatusText(status)) {
+			return
+		}
+	}
+	s.Error(w, r, err)
+}
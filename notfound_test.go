@@ -0,0 +1,108 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNotFound answers the requests matching no route with the handlers of
+// NotFound and MethodNotAllowed, the routes answering a 404 themselves
+// excepted.
+func TestNotFound(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.GET("/items", func(w http.ResponseWriter, r *http.Request) {})
+	s.GET("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	s.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("custom 404 " + r.URL.Path))
+	})
+	s.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("custom 405 allow " + w.Header().Get("Allow")))
+	})
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{"no route", http.MethodGet, "/missing", http.StatusNotFound, "custom 404 /missing"},
+		{"other method", http.MethodPost, "/items", http.StatusMethodNotAllowed, "custom 405 allow GET, HEAD"},
+		{"404 of a route", http.MethodGet, "/gone", http.StatusNotFound, "404 page not found\n"},
+		{"route", http.MethodGet, "/items", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+
+	// nil restores the default answers.
+	s.NotFound(nil)
+	s.MethodNotAllowed(nil)
+	for _, target := range []string{"/missing", "/items"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, nil))
+		if strings.Contains(w.Body.String(), "custom") {
+			t.Errorf("%s: %q once restored", target, w.Body.String())
+		}
+	}
+}
+
+// TestNotFoundTemplates renders NotFoundTemplate and MethodNotAllowedTemplate,
+// when defined, except for the JSON clients.
+func TestNotFoundTemplates(t *testing.T) {
+	s := newTemplateServer(t, map[string]string{
+		NotFoundTemplate:         "<p>{{.Status}} {{.Title}}</p>",
+		MethodNotAllowedTemplate: "<p>{{.Status}} not allowed</p>",
+	}, nil)
+	s.GET("/items", func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		accept    string
+		wantCode  int
+		wantBody  string
+		wantAllow string
+	}{
+		{"no route", http.MethodGet, "/missing", "", http.StatusNotFound, "<p>404 Not Found</p>", ""},
+		{"no route, HTML client", http.MethodGet, "/missing", "text/html", http.StatusNotFound, "<p>404 Not Found</p>", ""},
+		{"other method", http.MethodPost, "/items", "", http.StatusMethodNotAllowed, "<p>405 not allowed</p>", "GET, HEAD"},
+		{"no route, JSON client", http.MethodGet, "/missing", "application/json", http.StatusNotFound, `"status":404`, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) || w.Header().Get("Allow") != tt.wantAllow {
+			t.Errorf("%s: %d %q Allow %q, want %d %q %q", tt.name, w.Code, w.Body.String(), w.Header().Get("Allow"), tt.wantCode, tt.wantBody, tt.wantAllow)
+		}
+	}
+}
+
+// TestNotFoundFallback checks ServerConfig.Handler serves the requests
+// matching no route before NotFound.
+func TestNotFoundFallback(t *testing.T) {
+	s := NewServer(ServerConfig{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fallback"))
+	})})
+	s.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("custom 404"))
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Body.String() != "fallback" {
+		t.Errorf("%q, want the fallback", w.Body.String())
+	}
+}
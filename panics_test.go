@@ -0,0 +1,124 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Morditux/serverlib/templates"
+)
+
+// TestWithoutServer calls the package level helpers and the methods of a nil
+// server used by the middlewares without a server: none of them panics.
+func TestWithoutServer(t *testing.T) {
+	defer SetDefault(ServerInstance)
+	SetDefault(nil)
+	var s *Server
+	validation := ValidationErrors{}
+	validation.Add("email", "required")
+	tests := []struct {
+		name string
+		call func(w http.ResponseWriter, r *http.Request)
+		// wantCode is the status of the response, 0 when not answered.
+		wantCode int
+		wantBody string
+	}{
+		{"LogInfo", func(http.ResponseWriter, *http.Request) { LogInfo("message", "value") }, 0, ""},
+		{"LogDebug", func(http.ResponseWriter, *http.Request) { LogDebug("message", "value") }, 0, ""},
+		{"LogError", func(http.ResponseWriter, *http.Request) { LogError("message", "value") }, 0, ""},
+		{"GetSession", func(w http.ResponseWriter, r *http.Request) {
+			if session, ok := GetSession(w, r); session != nil || ok {
+				t.Errorf("GetSession: %v %v, want no session", session, ok)
+			}
+		}, 0, ""},
+		{"Error", func(w http.ResponseWriter, r *http.Request) {
+			s.Error(w, r, NewHTTPError(http.StatusNotFound, "no such page"))
+		}, http.StatusNotFound, "no such page"},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			s.Error(w, r, errors.New("database password in the message"))
+		}, http.StatusInternalServerError, "Internal Server Error"},
+		{"validation error", func(w http.ResponseWriter, r *http.Request) {
+			s.Error(w, r, validation)
+		}, http.StatusBadRequest, "email"},
+		{"problem", func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Accept", "application/json")
+			s.Error(w, r, NewHTTPError(http.StatusConflict, "taken"))
+		}, http.StatusConflict, `"type":"about:blank"`},
+		{"error page", func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Accept", "text/html")
+			s.Error(w, r, NewHTTPError(http.StatusForbidden, "private"))
+		}, http.StatusForbidden, "private"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					t.Errorf("%s: panicked with %v", tt.name, recovered)
+				}
+			}()
+			tt.call(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+		if tt.wantCode == 0 {
+			if w.Body.Len() != 0 || w.Code != http.StatusOK {
+				t.Errorf("%s: answered %d %q", tt.name, w.Code, w.Body.String())
+			}
+			continue
+		}
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s: %d %q, want %d with %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+// TestStartOwnTemplates checks Start parses the templates of its server, not
+// the ones of the default server.
+func TestStartOwnTemplates(t *testing.T) {
+	defer SetDefault(ServerInstance)
+	SetDefault(NewServer(ServerConfig{}))
+	s := newTestServer()
+	s.AddTemplateFS(fstest.MapFS{"page.html": {Data: []byte("own")}}, "*")
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	w := httptest.NewRecorder()
+	s.Render(w, "page.html", nil)
+	if w.Body.String() != "own" {
+		t.Errorf("%q, want the template of the server", w.Body.String())
+	}
+}
+
+// TestRenderBeforeParse checks rendering before the templates are parsed
+// fails with an error.
+func TestRenderBeforeParse(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	tests := []struct {
+		name   string
+		render func(w http.ResponseWriter) error
+	}{
+		{"Execute", func(w http.ResponseWriter) error { return s.Templates().Execute(w, "page.html", nil) }},
+		{"RenderRequest", func(w http.ResponseWriter) error {
+			return s.RenderRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "page.html", nil)
+		}},
+		{"RenderStatic", func(http.ResponseWriter) error {
+			return s.RenderStatic(context.Background(), t.TempDir(), []StaticPage{{Path: "/", Template: "page.html"}})
+		}},
+	}
+	for _, tt := range tests {
+		if err := tt.render(httptest.NewRecorder()); !errors.Is(err, templates.ErrNotParsed) {
+			t.Errorf("%s: %v, want ErrNotParsed", tt.name, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.Render(w, "page.html", nil)
+	if w.Body.Len() != 0 {
+		t.Errorf("Render: %q", w.Body.String())
+	}
+}
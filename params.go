@@ -0,0 +1,101 @@
+package serverlib
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// paramError returns the ValidationErrors of the path parameter name, with
+// the messages of the server of the request.
+func paramError(r *http.Request, name, key string) error {
+	var errs ValidationErrors
+	errs.addKey(serverOf(r).Messages(), name, "", key, nil)
+	return errs
+}
+
+// ParamInt returns the path parameter name, e.g. the {id} of "/users/{id}",
+// as an int.
+//
+// Returns:
+//   - int: The value of the parameter.
+//   - error: ValidationErrors, answered with a 400 by Server.Error, when the
+//     parameter is missing or not an integer.
+func ParamInt(r *http.Request, name string) (int, error) {
+	value := r.PathValue(name)
+	if value == "" {
+		return 0, paramError(r, name, "validation.required")
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, paramError(r, name, "validation.integer")
+	}
+	return i, nil
+}
+
+// ParamInt64 returns the path parameter name as an int64, see ParamInt.
+func ParamInt64(r *http.Request, name string) (int64, error) {
+	value := r.PathValue(name)
+	if value == "" {
+		return 0, paramError(r, name, "validation.required")
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, paramError(r, name, "validation.integer")
+	}
+	return i, nil
+}
+
+// ParamUUID returns the path parameter name as a UUID, see ParamInt.
+func ParamUUID(r *http.Request, name string) (uuid.UUID, error) {
+	value := r.PathValue(name)
+	if value == "" {
+		return uuid.UUID{}, paramError(r, name, "validation.required")
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.UUID{}, paramError(r, name, "validation.uuid")
+	}
+	return id, nil
+}
+
+// BindQuery fills the struct pointed to by v from the query parameters of the
+// fields tagged `query:"name"`, converted to the type of the field, or else
+// from the default of the tag:
+//
+//	var page struct {
+//		Page int      `query:"page,default=1"`
+//		Sort string   `query:"sort,default=name"`
+//		Tags []string `query:"tag"`
+//	}
+//	if err := serverlib.BindQuery(r, &page); err != nil {
+//		s.Error(w, r, err)
+//		return
+//	}
+//
+// Unlike Bind, the body is not read.
+//
+// Returns:
+//   - error: The conversion errors as ValidationErrors, answered with a 400
+//     by Server.Error.
+func BindQuery(r *http.Request, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic("serverlib: BindQuery needs a pointer to a struct")
+	}
+	var errs ValidationErrors
+	query := Query(r)
+	if err := query.Err(); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+	bindFields(rv.Elem(), "query", func(name string) ([]string, bool) {
+		values, ok := query.values[name]
+		return values, ok
+	}, query.catalog, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package serverlib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// errorKeys returns the fields and keys of the ValidationErrors err, nil
+// when it is nil.
+func errorKeys(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %T, want ValidationErrors", err)
+	}
+	var keys []string
+	for _, fieldError := range errs {
+		keys = append(keys, fieldError.Field+":"+fieldError.Key)
+	}
+	return keys
+}
+
+// TestParams converts the path parameters of a route.
+func TestParams(t *testing.T) {
+	id := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	tests := []struct {
+		name    string
+		target  string
+		param   string
+		get     func(r *http.Request, name string) (any, error)
+		want    any
+		wantErr []string
+	}{
+		{"ParamInt", "/items/42", "id", func(r *http.Request, name string) (any, error) { return ParamInt(r, name) }, 42, nil},
+		{"ParamInt negative", "/items/-3", "id", func(r *http.Request, name string) (any, error) { return ParamInt(r, name) }, -3, nil},
+		{"ParamInt invalid", "/items/abc", "id", func(r *http.Request, name string) (any, error) { return ParamInt(r, name) }, 0, []string{"id:validation.integer"}},
+		{"ParamInt missing", "/items/42", "other", func(r *http.Request, name string) (any, error) { return ParamInt(r, name) }, 0, []string{"other:validation.required"}},
+		{"ParamInt64", "/items/9223372036854775807", "id", func(r *http.Request, name string) (any, error) { return ParamInt64(r, name) }, int64(9223372036854775807), nil},
+		{"ParamInt64 overflow", "/items/9223372036854775808", "id", func(r *http.Request, name string) (any, error) { return ParamInt64(r, name) }, int64(0), []string{"id:validation.integer"}},
+		{"ParamInt64 missing", "/items/1", "other", func(r *http.Request, name string) (any, error) { return ParamInt64(r, name) }, int64(0), []string{"other:validation.required"}},
+		{"ParamUUID", "/items/" + id.String(), "id", func(r *http.Request, name string) (any, error) { return ParamUUID(r, name) }, id, nil},
+		{"ParamUUID invalid", "/items/42", "id", func(r *http.Request, name string) (any, error) { return ParamUUID(r, name) }, uuid.UUID{}, []string{"id:validation.uuid"}},
+		{"ParamUUID missing", "/items/42", "other", func(r *http.Request, name string) (any, error) { return ParamUUID(r, name) }, uuid.UUID{}, []string{"other:validation.required"}},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		var got any
+		var err error
+		s.GET("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			got, err = tt.get(r, tt.param)
+		})
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if got != tt.want {
+			t.Errorf("%s: %#v, want %#v", tt.name, got, tt.want)
+		}
+		if keys := errorKeys(t, err); !reflect.DeepEqual(keys, tt.wantErr) {
+			t.Errorf("%s: errors %q, want %q", tt.name, keys, tt.wantErr)
+		}
+	}
+}
+
+// TestParamError checks the errors of the path parameters are answered with
+// a 400 by Server.Error.
+func TestParamError(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.GET("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ParamInt(r, "id"); err != nil {
+			s.Error(w, r, err)
+		}
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", w.Code)
+	}
+}
+
+// TestBindQuery binds the query parameters to the tagged fields, with their
+// defaults.
+func TestBindQuery(t *testing.T) {
+	type Paging struct {
+		Page int `query:"page,default=1"`
+	}
+	type params struct {
+		Paging
+		Sort     string   `query:"sort,default=name"`
+		Tags     []string `query:"tag"`
+		Full     bool     `query:"full"`
+		Limit    *int     `query:"limit"`
+		Ignored  string   `query:"-"`
+		Untagged string
+	}
+	ten := 10
+	tests := []struct {
+		name    string
+		query   string
+		want    params
+		wantErr []string
+	}{
+		{"defaults", "", params{Paging: Paging{Page: 1}, Sort: "name"}, nil},
+		{"set", "page=3&sort=date&tag=a&tag=b&full=true&limit=10", params{Paging: Paging{Page: 3}, Sort: "date", Tags: []string{"a", "b"}, Full: true, Limit: &ten}, nil},
+		{"untagged ignored", "Ignored=x&Untagged=y&-=z", params{Paging: Paging{Page: 1}, Sort: "name"}, nil},
+		{"invalid", "page=x&full=maybe", params{Sort: "name"}, []string{"page:validation.integer", "full:validation.boolean"}},
+		{"malformed", "page=2&bad=%zz", params{Paging: Paging{Page: 2}, Sort: "name"}, []string{"query:validation.query"}},
+	}
+	for _, tt := range tests {
+		var got params
+		err := BindQuery(httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil), &got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+		if keys := errorKeys(t, err); !reflect.DeepEqual(keys, tt.wantErr) {
+			t.Errorf("%s: errors %q, want %q", tt.name, keys, tt.wantErr)
+		}
+	}
+}
+
+// TestBindQueryBody checks BindQuery leaves the body unread.
+func TestBindQueryBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/?page=2", strings.NewReader("page=3"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var got struct {
+		Page int `query:"page"`
+	}
+	if err := BindQuery(r, &got); err != nil || got.Page != 2 {
+		t.Errorf("page %d, %v, want 2", got.Page, err)
+	}
+	if body, _ := io.ReadAll(r.Body); string(body) != "page=3" {
+		t.Errorf("body %q read", body)
+	}
+}
+
+// TestBindQueryNotStruct checks BindQuery panics without a pointer to a
+// struct.
+func TestBindQueryNotStruct(t *testing.T) {
+	var page int
+	for _, v := range []any{page, &page, struct{}{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T: no panic", v)
+				}
+			}()
+			BindQuery(httptest.NewRequest(http.MethodGet, "/", nil), v)
+		}()
+	}
+}
@@ -0,0 +1,71 @@
+package serverlib
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// DefaultPprofPrefix is the prefix of the profiling endpoints when
+// EnablePprof is given none.
+const DefaultPprofPrefix = "/debug/pprof/"
+
+// ErrPprofRootPrefix is returned by EnablePprof for the root prefix.
+var ErrPprofRootPrefix = errors.New("pprof: refusing to mount the profiling endpoints on the root prefix")
+
+// EnablePprof registers the profiling endpoints of net/http/pprof under the
+// prefix: the index, the named profiles (heap, goroutine, allocs...), the CPU
+// profile, the trace, the command line and the symbols. They expose the
+// internals of the process and cost CPU while profiling: protect them with a
+// middleware, e.g. an authentication or an allowlist of addresses, a warning
+// being logged otherwise. The CPU profile and the trace last 30 seconds by
+// default (see the seconds parameter), mind ServerConfig.WriteTimeout.
+//
+//	server.EnablePprof("/debug/pprof/", serverlib.RequireRole("admin"))
+//
+// Parameters:
+//   - prefix: The prefix of the endpoints, DefaultPprofPrefix when empty.
+//   - middleware: Optional middlewares wrapping the endpoints, the first one
+//     outermost.
+//
+// Returns:
+//   - error: ErrPprofRootPrefix when prefix is "/", nothing being registered.
+func (s *Server) EnablePprof(prefix string, middleware ...Middleware) error {
+	if prefix == "" {
+		prefix = DefaultPprofPrefix
+	}
+	prefix = strings.TrimSuffix("/"+strings.Trim(prefix, "/"), "/")
+	if prefix == "" {
+		return ErrPprofRootPrefix
+	}
+	if len(middleware) == 0 {
+		slog.Warn("Profiling endpoints enabled without protection", "prefix", prefix)
+	}
+	routes := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"GET " + prefix + "/{$}", pprof.Index},
+		{"GET " + prefix + "/{name}", servePprofProfile},
+		{"GET " + prefix + "/cmdline", pprof.Cmdline},
+		{"GET " + prefix + "/profile", pprof.Profile},
+		{"GET " + prefix + "/symbol", pprof.Symbol},
+		{"POST " + prefix + "/symbol", pprof.Symbol},
+		{"GET " + prefix + "/trace", pprof.Trace},
+	}
+	for _, route := range routes {
+		handler := wrapMiddlewares(route.handler, middleware)
+		s.router.Handle(route.pattern, handler)
+		s.addHandlerRoute(route.pattern, handler, route.handler, middleware)
+	}
+	slog.Info("Registred profiling endpoints", "prefix", prefix)
+	return nil
+}
+
+// servePprofProfile serves a named profile, e.g. heap. pprof.Index only
+// serves them under /debug/pprof/.
+func servePprofProfile(w http.ResponseWriter, r *http.Request) {
+	pprof.Handler(r.PathValue("name")).ServeHTTP(w, r)
+}
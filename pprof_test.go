@@ -0,0 +1,104 @@
+package serverlib
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestEnablePprof serves the profiling endpoints under the default and a
+// custom prefix.
+func TestEnablePprof(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{"index", "", http.MethodGet, "/debug/pprof/", http.StatusOK, "goroutine"},
+		{"named profile", "", http.MethodGet, "/debug/pprof/heap?debug=1", http.StatusOK, "heap profile"},
+		{"unknown profile", "", http.MethodGet, "/debug/pprof/unknown", http.StatusNotFound, "Unknown profile"},
+		{"command line", "", http.MethodGet, "/debug/pprof/cmdline", http.StatusOK, ""},
+		{"symbol", "", http.MethodGet, "/debug/pprof/symbol", http.StatusOK, "num_symbols"},
+		{"symbol posted", "", http.MethodPost, "/debug/pprof/symbol", http.StatusOK, "num_symbols"},
+		{"custom prefix index", "/admin/pprof", http.MethodGet, "/admin/pprof/", http.StatusOK, "goroutine"},
+		{"custom prefix profile", "admin/pprof/", http.MethodGet, "/admin/pprof/goroutine?debug=1", http.StatusOK, "goroutine profile"},
+		{"default prefix unregistered", "/admin/pprof", http.MethodGet, "/debug/pprof/", http.StatusNotFound, ""},
+	}
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		if err := s.EnablePprof(tt.prefix); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s: %d %.100q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+	if !strings.Contains(logs.String(), "Profiling endpoints enabled without protection") {
+		t.Errorf("no warning logged: %s", logs.String())
+	}
+}
+
+// TestEnablePprofMiddleware checks the middlewares wrap every endpoint, no
+// warning being logged.
+func TestEnablePprofMiddleware(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	s := NewServer(ServerConfig{})
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Admin") == "" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	if err := s.EnablePprof("", deny); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "without protection") {
+		t.Errorf("warning logged with a middleware: %s", logs.String())
+	}
+	for _, target := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline", "/debug/pprof/symbol", "/debug/pprof/profile", "/debug/pprof/trace"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: %d, want 403", target, w.Code)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	r.Header.Set("X-Admin", "1")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("allowed: %d", w.Code)
+	}
+}
+
+// TestEnablePprofRootPrefix checks the root prefix is refused.
+func TestEnablePprofRootPrefix(t *testing.T) {
+	for _, prefix := range []string{"/", "//"} {
+		s := NewServer(ServerConfig{})
+		if err := s.EnablePprof(prefix); !errors.Is(err, ErrPprofRootPrefix) {
+			t.Errorf("%q: %v", prefix, err)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cmdline", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%q: /cmdline %d, want nothing registered", prefix, w.Code)
+		}
+	}
+}
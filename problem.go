@@ -0,0 +1,225 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/google/uuid"
+)
+
+// Problem is an RFC 9457 problem details object.
+// Extensions are serialized as top-level members next to the standard ones.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for name, value := range p.Extensions {
+		members[name] = value
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	members["type"] = p.Type
+	if p.Title != "" {
+		members["title"] = p.Title
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return json.Marshal(members)
+}
+
+// Write sends the problem as an application/problem+json response.
+func (p Problem) Write(w http.ResponseWriter) error {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	return JSON(w, status, p, AsProblem())
+}
+
+// retryAfterer is implemented by errors telling when the request can be retried,
+// such as rate limiting errors.
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// PanicError is the error the server answers the requests whose handler panicked with.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// ID is the ID of the request, logged with the panic; it is the instance
+	// of the problem sent to the client.
+	ID string
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic %s: %v", e.ID, e.Value)
+}
+
+// StatusCode returns the HTTP status code of a panic.
+func (e *PanicError) StatusCode() int {
+	return http.StatusInternalServerError
+}
+
+// ProblemFor returns the problem describing err.
+//   - ValidationErrors give a 400 listing the field errors in the "errors" extension.
+//   - Errors with a StatusCode() int method, such as HTTPError, give their status
+//     and message.
+//   - Errors with a RetryAfter() time.Duration method, such as rate limiting
+//     errors, get a "retry_after" extension in seconds.
+//   - Panics give an opaque 500 whose instance is the panic ID found in the logs.
+//   - Any other error gives an opaque 500.
+//
+// The problem types are the slug of the status text, e.g. "not-found", appended
+// to the ProblemTypeBase of the configuration, or "about:blank" without base.
+func (s *Server) ProblemFor(err error) Problem {
+	return s.problemFor(err, "")
+}
+
+// ProblemForRequest returns the problem describing err like ProblemFor, with
+// the messages of the validation errors, and the title and the detail of
+// their problem, in the locale of the request, see Server.Localize. Every
+// field error keeps its message key for the programmatic clients.
+func (s *Server) ProblemForRequest(r *http.Request, err error) Problem {
+	return s.problemFor(err, s.Locale(r))
+}
+
+// problemFor returns the problem describing err, its validation errors
+// translated to locale unless it is empty.
+func (s *Server) problemFor(err error, locale string) Problem {
+	status := ErrorStatus(err)
+	problem := Problem{
+		Type:   s.problemType(status),
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+	var validationErrors ValidationErrors
+	var panicError *PanicError
+	var httpError *HTTPError
+	switch {
+	case errors.As(err, &validationErrors):
+		problem.Type = s.problemType(0)
+		problem.Title = "Validation failed"
+		problem.Detail = "The request has invalid fields."
+		if locale != "" {
+			catalog := s.Messages()
+			problem.Title = catalog.Translate(locale, "validation.failed", nil)
+			problem.Detail = catalog.Translate(locale, "validation.failed.detail", nil)
+			validationErrors = validationErrors.localize(catalog, locale)
+		}
+		problem.Extensions = map[string]any{"errors": validationErrors}
+	case errors.As(err, &panicError):
+		problem.Instance = "urn:uuid:" + panicError.ID
+	case errors.As(err, &httpError):
+		if httpError.Message != problem.Title {
+			problem.Detail = httpError.Message
+		}
+	case status < http.StatusInternalServerError:
+		problem.Detail = err.Error()
+	}
+	var retry retryAfterer
+	if errors.As(err, &retry) {
+		if problem.Extensions == nil {
+			problem.Extensions = map[string]any{}
+		}
+		problem.Extensions["retry_after"] = int(retry.RetryAfter().Round(time.Second).Seconds())
+	}
+	return problem
+}
+
+// problemType returns the type URI of the problems with the given status.
+// Status 0 is the type of validation failures.
+func (s *Server) problemType(status int) string {
+	if s == nil || s.problemTypeBase == "" {
+		return "about:blank"
+	}
+	slug := "validation-error"
+	if status != 0 {
+		slug = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "-"))
+		slug = strings.NewReplacer("'", "", "(", "", ")", "").Replace(slug)
+	}
+	return strings.TrimSuffix(s.problemTypeBase, "/") + "/" + slug
+}
+
+// setRetryAfter sets the Retry-After header for errors telling when to retry.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var retry retryAfterer
+	if errors.As(err, &retry) {
+		seconds := int(retry.RetryAfter().Round(time.Second).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 0)))
+	}
+}
+
+// recoverPanic answers the request with a PanicError if the handler panicked.
+// http.ErrAbortHandler is re-raised so that net/http aborts the response.
+func recoverPanic(w http.ResponseWriter, r *http.Request) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	if value == http.ErrAbortHandler {
+		panic(value)
+	}
+	id := reqctx.RequestID(r.Context())
+	if id == "" {
+		id = uuid.New().String()
+	}
+	err := &PanicError{Value: value, ID: id}
+	serverOf(r).LogError("Panic recovered", fmt.Sprintf("%s %s: %s %v\n%s", r.Method, r.URL.Path, err, reqctx.Snapshot(r.Context()), debug.Stack()))
+	serverOf(r).Error(w, r, err)
+}
+
+// routerErrorWriter replaces the plain text 404 and 405 responses of the mux by
+// the responses of the central error handler.
+type routerErrorWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	handled bool
+}
+
+func (w *routerErrorWriter) WriteHeader(status int) {
+	if status != http.StatusNotFound && status != http.StatusMethodNotAllowed {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.handled = true
+	// The mux has already set its own Content-Type.
+	w.Header().Del("Content-Type")
+	w.Header().Del("X-Content-Type-Options")
+	serverOf(w.r).routeMissed(w.ResponseWriter, w.r, status)
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *routerErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *routerErrorWriter) Write(p []byte) (int, error) {
+	if w.handled {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
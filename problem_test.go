@@ -0,0 +1,146 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// TestProblemResponses answers each class of errors to a JSON client and
+// checks the exact members of the problem document.
+func TestProblemResponses(t *testing.T) {
+	var panicID string
+	handlers := map[string]func(w http.ResponseWriter, r *http.Request) error{
+		"http": func(http.ResponseWriter, *http.Request) error {
+			return NewHTTPError(http.StatusConflict, "name already taken")
+		},
+		"statustext": func(http.ResponseWriter, *http.Request) error { return NewHTTPError(http.StatusForbidden, "") },
+		"wrapped": func(http.ResponseWriter, *http.Request) error {
+			return &HTTPError{Status: http.StatusBadGateway, Message: "upstream failed", Err: errors.New("dial tcp: refused")}
+		},
+		"internal": func(http.ResponseWriter, *http.Request) error { return errors.New("database password wrong") },
+		"validation": func(http.ResponseWriter, *http.Request) error {
+			var errs ValidationErrors
+			errs.AddKey("name", "validation.required", nil)
+			errs.Add("age", "too young")
+			return errs
+		},
+		"overload": func(http.ResponseWriter, *http.Request) error {
+			return &OverloadError{Class: "batch", Retry: 1500 * time.Millisecond}
+		},
+		"panic": func(_ http.ResponseWriter, r *http.Request) error {
+			panicID = reqctx.RequestID(r.Context())
+			panic("boom")
+		},
+	}
+	tests := []struct {
+		name       string
+		base       string
+		method     string
+		target     string
+		wantStatus int
+		want       map[string]any
+	}{
+		{"HTTPError", "", http.MethodGet, "/fail/http", http.StatusConflict, map[string]any{
+			"type": "about:blank", "title": "Conflict", "status": 409.0, "detail": "name already taken",
+		}},
+		{"HTTPError with a type base", "https://errors.example.com/", http.MethodGet, "/fail/http", http.StatusConflict, map[string]any{
+			"type": "https://errors.example.com/conflict", "title": "Conflict", "status": 409.0, "detail": "name already taken",
+		}},
+		{"HTTPError of the status text", "", http.MethodGet, "/fail/statustext", http.StatusForbidden, map[string]any{
+			"type": "about:blank", "title": "Forbidden", "status": 403.0,
+		}},
+		{"wrapped error", "", http.MethodGet, "/fail/wrapped", http.StatusBadGateway, map[string]any{
+			"type": "about:blank", "title": "Bad Gateway", "status": 502.0, "detail": "upstream failed",
+		}},
+		{"internal error", "https://errors.example.com", http.MethodGet, "/fail/internal", http.StatusInternalServerError, map[string]any{
+			"type": "https://errors.example.com/internal-server-error", "title": "Internal Server Error", "status": 500.0,
+		}},
+		{"validation", "https://errors.example.com", http.MethodGet, "/fail/validation", http.StatusBadRequest, map[string]any{
+			"type": "https://errors.example.com/validation-error", "title": "Validation failed", "status": 400.0,
+			"detail": "The request has invalid fields.",
+			"errors": []any{
+				map[string]any{"field": "name", "message": "is required", "key": "validation.required"},
+				map[string]any{"field": "age", "message": "too young"},
+			},
+		}},
+		{"rate limit", "", http.MethodGet, "/fail/overload", http.StatusServiceUnavailable, map[string]any{
+			"type": "about:blank", "title": "Service Unavailable", "status": 503.0, "retry_after": 2.0,
+		}},
+		{"not found", "https://errors.example.com", http.MethodGet, "/missing", http.StatusNotFound, map[string]any{
+			"type": "https://errors.example.com/not-found", "title": "Not Found", "status": 404.0,
+		}},
+		{"method not allowed", "", http.MethodDelete, "/fail/http", http.StatusMethodNotAllowed, map[string]any{
+			"type": "about:blank", "title": "Method Not Allowed", "status": 405.0,
+		}},
+		{"panic", "", http.MethodGet, "/fail/panic", http.StatusInternalServerError, map[string]any{
+			"type": "about:blank", "title": "Internal Server Error", "status": 500.0, "instance": "urn:uuid:",
+		}},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{ProblemTypeBase: tt.base})
+		s.GET("/fail/{kind}", func(w http.ResponseWriter, r *http.Request) {
+			s.Error(w, r, handlers[r.PathValue("kind")](w, r))
+		})
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/problem+json; charset=utf-8" {
+			t.Errorf("%s: Content-Type %q", tt.name, got)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Errorf("%s: %v: %s", tt.name, err, w.Body.String())
+			continue
+		}
+		if tt.want["instance"] != nil {
+			tt.want["instance"] = "urn:uuid:" + panicID
+			if panicID == "" {
+				t.Errorf("%s: no request ID", tt.name)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %s, want %v", tt.name, w.Body.String(), tt.want)
+		}
+		if _, retry := tt.want["retry_after"]; retry != (w.Header().Get("Retry-After") != "") {
+			t.Errorf("%s: Retry-After %q", tt.name, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestProblemMarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		problem Problem
+		want    string
+	}{
+		{"empty", Problem{}, `{"type":"about:blank"}`},
+		{"standard members", Problem{Type: "https://example.com/out-of-credit", Title: "Out of credit", Status: 403, Detail: "Your balance is 30.", Instance: "/accounts/12"},
+			`{"detail":"Your balance is 30.","instance":"/accounts/12","status":403,"title":"Out of credit","type":"https://example.com/out-of-credit"}`},
+		{"extensions", Problem{Status: 403, Extensions: map[string]any{"balance": 30, "accounts": []string{"/accounts/12"}}},
+			`{"accounts":["/accounts/12"],"balance":30,"status":403,"type":"about:blank"}`},
+		{"extensions don't override the members", Problem{Status: 403, Extensions: map[string]any{"status": 200, "type": "x"}},
+			`{"status":403,"type":"about:blank"}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.problem)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: %s %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+	w := httptest.NewRecorder()
+	Problem{Title: "No status"}.Write(w)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/problem+json; charset=utf-8" {
+		t.Errorf("Write without a status: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
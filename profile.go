@@ -0,0 +1,28 @@
+package serverlib
+
+// Profile selects the defaults suited to an environment.
+type Profile int
+
+const (
+	// Production is the default profile.
+	Production Profile = iota
+	// Development enables the developer helpers: template reloading,
+	// browser auto-refresh, ...
+	Development
+)
+
+// String returns the name of the profile.
+func (p Profile) String() string {
+	switch p {
+	case Production:
+		return "production"
+	case Development:
+		return "development"
+	}
+	return "unknown"
+}
+
+// Profile returns the profile the server was configured with.
+func (s *Server) Profile() Profile {
+	return s.profile
+}
@@ -0,0 +1,319 @@
+package serverlib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is how long a connection has to send its PROXY
+// protocol header when ProxyProtocolOptions.HeaderTimeout is not set.
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+// ProxyProtocolOptions configures the PROXY protocol of the listeners, see
+// ServerConfig.AcceptProxyProtocol.
+type ProxyProtocolOptions struct {
+	// Allowed are the addresses, e.g. "10.0.0.5", or the networks, e.g.
+	// "10.0.0.0/8", of the load balancers allowed to send the header. A
+	// header sent by another source closes the connection. Empty allows
+	// every source: only do so when nothing but the load balancer reaches
+	// the listeners. The connections of a unix socket are always allowed,
+	// see ServerConfig.SocketMode.
+	Allowed []string
+	// Required closes the connections of the allowed sources that don't
+	// send the header, instead of serving them with their own address.
+	Required bool
+	// HeaderTimeout bounds the wait for the header. Defaults to
+	// DefaultProxyHeaderTimeout. The header is read by the first call to
+	// the RemoteAddr or the Read of the connection: a ServerConfig.ConnState
+	// callback calling RemoteAddr on http.StateNew, run by the accept loop,
+	// holds the accepting of the other connections for up to HeaderTimeout.
+	HeaderTimeout time.Duration
+}
+
+// proxyProtocol parses the PROXY protocol headers of the connections of a
+// server.
+type proxyProtocol struct {
+	allowed  []netip.Prefix
+	anyone   bool
+	required bool
+	timeout  time.Duration
+	server   *Server
+}
+
+func newProxyProtocol(options ProxyProtocolOptions, server *Server) *proxyProtocol {
+	p := &proxyProtocol{
+		anyone:   len(options.Allowed) == 0,
+		required: options.Required,
+		timeout:  options.HeaderTimeout,
+		server:   server,
+	}
+	if p.timeout <= 0 {
+		p.timeout = DefaultProxyHeaderTimeout
+	}
+	for _, allowed := range options.Allowed {
+		prefix, err := parseAddrPrefix(allowed)
+		if err != nil {
+			// Not trusted rather than trusting too much.
+			server.LogError("Invalid PROXY protocol source ignored", allowed)
+			continue
+		}
+		p.allowed = append(p.allowed, prefix)
+	}
+	if p.anyone {
+		slog.Warn("PROXY protocol headers accepted from every source")
+	}
+	return p
+}
+
+// parseAddrPrefix parses an address, e.g. "10.0.0.5", as the network of this
+// address only, or a network, e.g. "10.0.0.0/8".
+func parseAddrPrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// wrap returns the listener parsing the PROXY protocol header of its
+// connections.
+func (p *proxyProtocol) wrap(l net.Listener) net.Listener {
+	if p == nil {
+		return l
+	}
+	return &proxyListener{Listener: l, protocol: p}
+}
+
+// allows reports whether the peer may send a header.
+func (p *proxyProtocol) allows(peer net.Addr) bool {
+	tcp, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	if p.anyone {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyListener is a listener whose connections may start with a PROXY
+// protocol header.
+type proxyListener struct {
+	net.Listener
+	protocol *proxyProtocol
+}
+
+// Accept returns the connection right away: its header is read by the
+// goroutine serving it, on the first call to RemoteAddr or Read, not to
+// block the other connections.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), protocol: l.protocol, once: &sync.Once{}}, nil
+}
+
+// proxyConn is a connection whose RemoteAddr is the client of the PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	protocol *proxyProtocol
+	once     *sync.Once
+	remote   net.Addr
+	err      error
+}
+
+// init reads the header, once.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		peer := c.Conn.RemoteAddr()
+		c.remote = peer
+		c.Conn.SetReadDeadline(time.Now().Add(c.protocol.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+		remote, found, err := readProxyHeader(c.reader)
+		switch {
+		case err == nil && found && !c.protocol.allows(peer):
+			err = errors.New("source not allowed to send a PROXY protocol header")
+		case err == nil && !found && c.protocol.required && c.protocol.allows(peer):
+			err = errors.New("PROXY protocol header missing")
+		}
+		if err != nil {
+			c.err = fmt.Errorf("proxy protocol: %w", err)
+			c.protocol.server.LogError("Invalid PROXY protocol header", peer.String()+": "+err.Error())
+			c.Conn.Close()
+			return
+		}
+		if remote != nil {
+			c.remote = remote
+		}
+	})
+}
+
+// RemoteAddr returns the address of the client sent by the load balancer, or
+// the address of the peer without a header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// Read reads after the header.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// proxyV2Signature starts the headers of the version 2 of the protocol.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the maximum length of a version 1 header, CRLF included.
+const proxyV1MaxLength = 107
+
+// proxyV2MaxLength bounds the addresses and the TLVs of a version 2 header,
+// read before the source is checked: the load balancers send a few hundred
+// bytes at most.
+const proxyV2MaxLength = 4096
+
+// readProxyHeader reads the PROXY protocol header, version 1 or 2, at the
+// start of the connection.
+//
+// Returns:
+//   - net.Addr: The address of the client, nil for the health checks of the
+//     load balancer (LOCAL, UNKNOWN) and the unix sockets.
+//   - bool: Whether the connection starts with a header.
+//   - error: The error of a malformed header, or of the connection.
+func readProxyHeader(r *bufio.Reader) (net.Addr, bool, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+			return nil, false, nil
+		}
+		addr, err := readProxyV1(r)
+		return addr, true, err
+	case '\r':
+		if prefix, err := r.Peek(len(proxyV2Signature)); err != nil || !bytes.Equal(prefix, proxyV2Signature) {
+			return nil, false, nil
+		}
+		addr, err := readProxyV2(r)
+		return addr, true, err
+	}
+	return nil, false, nil
+}
+
+// readProxyV1 reads a text header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == proxyV1MaxLength {
+			return nil, errors.New("v1 header too long")
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("v1 header not terminated by CRLF")
+	}
+	fields := strings.Split(header, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header %q", header)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") || ip.Zone() != "" {
+		return nil, fmt.Errorf("malformed v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("malformed v1 source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip.AsSlice(), Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+	if length > proxyV2MaxLength {
+		return nil, fmt.Errorf("v2 header too long: %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch command {
+	case 0x0:
+		// LOCAL: a health check of the load balancer itself.
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+	switch family >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, errors.New("v2 IPv4 addresses truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, errors.New("v2 IPv6 addresses truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// AF_UNSPEC or AF_UNIX: the address of the peer is kept.
+	return nil, nil
+}
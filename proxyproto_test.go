@@ -0,0 +1,194 @@
+package serverlib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2IPv4 returns the payload of a TCP over IPv4 header.
+func proxyV2IPv4(src, dst string, srcPort, dstPort uint16) []byte {
+	payload := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+// proxyV2IPv6 returns the payload of a TCP over IPv6 header.
+func proxyV2IPv6(src, dst string, srcPort, dstPort uint16) []byte {
+	payload := append(net.ParseIP(src).To16(), net.ParseIP(dst).To16()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\n"
+	ipv4 := proxyV2IPv4("192.0.2.1", "198.51.100.1", 56324, 443)
+	withTLVs := append(proxyV2IPv4("192.0.2.1", "198.51.100.1", 56324, 443), 0x01, 0x00, 0x02, 'h', '2')
+	tests := []struct {
+		name      string
+		input     string
+		wantAddr  string
+		wantFound bool
+		wantErr   bool
+		// wantRest is what remains to read after the header.
+		wantRest string
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" + request, "192.0.2.1:56324", true, false, request},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n" + request, "[2001:db8::1]:56324", true, false, request},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n" + request, "", true, false, request},
+		{"v1 UNKNOWN with addresses", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n" + request, "", true, false, request},
+		{"v1 without CRLF", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", true, true, ""},
+		{"v1 truncated", "PROXY TCP4 192.0.2.1", "", true, true, ""},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", "", true, true, ""},
+		{"v1 missing port", "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "", true, true, ""},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n", "", true, true, ""},
+		{"v1 IPv4 as TCP6", "PROXY TCP6 192.0.2.1 198.51.100.1 56324 443\r\n", "", true, true, ""},
+		{"v1 zone", "PROXY TCP6 fe80::1%eth0 fe80::2 56324 443\r\n", "", true, true, ""},
+		{"v1 bad address", "PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n", "", true, true, ""},
+		{"v1 port out of range", "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", "", true, true, ""},
+		{"v1 UDP", "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", true, true, ""},
+		{"v1 double space", "PROXY TCP4  192.0.2.1 198.51.100.1 56324 443\r\n", "", true, true, ""},
+		{"v2 PROXY IPv4", string(proxyV2Header(0x1, 0x11, ipv4)) + request, "192.0.2.1:56324", true, false, request},
+		{"v2 PROXY IPv6", string(proxyV2Header(0x1, 0x21, proxyV2IPv6("2001:db8::1", "2001:db8::2", 1234, 443))) + request, "[2001:db8::1]:1234", true, false, request},
+		{"v2 TLVs skipped", string(proxyV2Header(0x1, 0x11, withTLVs)) + request, "192.0.2.1:56324", true, false, request},
+		{"v2 LOCAL", string(proxyV2Header(0x0, 0x11, ipv4)) + request, "", true, false, request},
+		{"v2 LOCAL without addresses", string(proxyV2Header(0x0, 0x00, nil)) + request, "", true, false, request},
+		{"v2 UNSPEC", string(proxyV2Header(0x1, 0x00, nil)) + request, "", true, false, request},
+		{"v2 unix", string(proxyV2Header(0x1, 0x31, make([]byte, 216))) + request, "", true, false, request},
+		{"v2 unknown command", string(proxyV2Header(0x2, 0x11, ipv4)), "", true, true, ""},
+		{"v2 version 1", string(append(append([]byte(nil), proxyV2Signature...), 0x11, 0x11, 0, 0)), "", true, true, ""},
+		{"v2 IPv4 truncated payload", string(proxyV2Header(0x1, 0x11, ipv4[:8])), "", true, true, ""},
+		{"v2 IPv6 truncated payload", string(proxyV2Header(0x1, 0x21, ipv4)), "", true, true, ""},
+		{"v2 shorter than its length", string(proxyV2Header(0x1, 0x11, ipv4)[:20]), "", true, true, ""},
+		{"v2 truncated header", string(proxyV2Header(0x1, 0x11, ipv4)[:14]), "", true, true, ""},
+		{"v2 oversized length", string(proxyV2Header(0x1, 0x11, make([]byte, proxyV2MaxLength+1))), "", true, true, ""},
+		{"v2 largest length", string(proxyV2Header(0x1, 0x11, append(ipv4, make([]byte, proxyV2MaxLength-len(ipv4))...))) + request, "192.0.2.1:56324", true, false, request},
+		{"no header", request, "", false, false, request},
+		{"empty", "", "", false, false, ""},
+		{"P but not PROXY", "PUT / HTTP/1.1\r\n", "", false, false, "PUT / HTTP/1.1\r\n"},
+		{"short P", "PRO", "", false, false, "PRO"},
+		{"bad v2 signature", "\r\n\r\n\x00\r\nQUIZ\n", "", false, false, "\r\n\r\n\x00\r\nQUIZ\n"},
+		{"CR only", "\r\n", "", false, false, "\r\n"},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		addr, found, err := readProxyHeader(r)
+		gotAddr := ""
+		if addr != nil {
+			gotAddr = addr.String()
+		}
+		if gotAddr != tt.wantAddr || found != tt.wantFound || (err != nil) != tt.wantErr {
+			t.Errorf("%s: %q %v %v, want %q %v error %v", tt.name, gotAddr, found, err, tt.wantAddr, tt.wantFound, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != tt.wantRest {
+			t.Errorf("%s: rest %q, want %q", tt.name, rest, tt.wantRest)
+		}
+	}
+}
+
+// proxyServer starts a server accepting the PROXY protocol, answering with
+// the RemoteAddr of the requests.
+func proxyServer(t *testing.T, options ProxyProtocolOptions) *Server {
+	t.Helper()
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", AcceptProxyProtocol: &options, ErrorLog: log.New(io.Discard, "", 0)})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})
+	done := startServer(s)
+	<-s.Ready()
+	t.Cleanup(func() {
+		s.Stop()
+		waitStart(t, done)
+	})
+	return s
+}
+
+// proxyRequest sends the header and a request on a new connection, and
+// returns the body of the response, or "closed" when the server closed the
+// connection.
+func proxyRequest(t *testing.T, s *Server, header []byte) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn.Write(append(header, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"...))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "closed"
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// TestProxyProtocol sends headers to a running server from an allowed and
+// an untrusted source, the test client being 127.0.0.1.
+func TestProxyProtocol(t *testing.T) {
+	v1 := []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	v2 := proxyV2Header(0x1, 0x11, proxyV2IPv4("192.0.2.7", "198.51.100.1", 4000, 443))
+	local := proxyV2Header(0x0, 0x00, nil)
+	tests := []struct {
+		name    string
+		options ProxyProtocolOptions
+		header  []byte
+		// want is the RemoteAddr of the request, "peer" for the address of
+		// the test client, or "closed".
+		want string
+	}{
+		{"v1 from an allowed address", ProxyProtocolOptions{Allowed: []string{"127.0.0.1"}}, v1, "192.0.2.1:56324"},
+		{"v2 from an allowed network", ProxyProtocolOptions{Allowed: []string{"127.0.0.0/8"}}, v2, "192.0.2.7:4000"},
+		{"every source allowed", ProxyProtocolOptions{}, v1, "192.0.2.1:56324"},
+		{"LOCAL", ProxyProtocolOptions{Allowed: []string{"127.0.0.1"}}, local, "peer"},
+		{"no header", ProxyProtocolOptions{Allowed: []string{"127.0.0.1"}}, nil, "peer"},
+		{"no header, required", ProxyProtocolOptions{Allowed: []string{"127.0.0.1"}, Required: true}, nil, "closed"},
+		{"untrusted source", ProxyProtocolOptions{Allowed: []string{"10.0.0.0/8"}}, v1, "closed"},
+		{"untrusted source, v2", ProxyProtocolOptions{Allowed: []string{"10.0.0.5"}}, v2, "closed"},
+		{"untrusted source without a header", ProxyProtocolOptions{Allowed: []string{"10.0.0.0/8"}, Required: true}, nil, "peer"},
+		{"invalid allowed source ignored", ProxyProtocolOptions{Allowed: []string{"not an address"}}, v1, "closed"},
+		{"malformed header", ProxyProtocolOptions{}, []byte("PROXY TCP4 192.0.2.1\r\n"), "closed"},
+	}
+	for _, tt := range tests {
+		s := proxyServer(t, tt.options)
+		got := proxyRequest(t, s, tt.header)
+		if tt.want == "peer" && strings.HasPrefix(got, "127.0.0.1:") {
+			got = "peer"
+		}
+		if got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestProxyHeaderTimeout checks a connection sending nothing is closed once
+// HeaderTimeout elapsed, without holding the other connections.
+func TestProxyHeaderTimeout(t *testing.T) {
+	s := proxyServer(t, ProxyProtocolOptions{HeaderTimeout: 100 * time.Millisecond, Required: true})
+	silent, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	start := time.Now()
+	if got := proxyRequest(t, s, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1 2\r\n")); got != "192.0.2.1:1" {
+		t.Errorf("other connection: %q", got)
+	}
+	silent.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := silent.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("silent connection: %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("closed after %s, want HeaderTimeout", elapsed)
+	}
+}
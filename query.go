@@ -0,0 +1,168 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Morditux/serverlib/i18n"
+)
+
+// QueryOption configures the query parameters helper returned by Query.
+type QueryOption func(*QueryParams)
+
+// StrictQuery makes the scalar accessors reject parameters given several
+// times instead of using their first value.
+func StrictQuery() QueryOption {
+	return func(q *QueryParams) {
+		q.strict = true
+	}
+}
+
+// QueryParams extracts typed values from the query string of a request.
+// Conversion errors don't stop the extraction: the accessors return their
+// default value and the errors are collected, retrievable with Err.
+type QueryParams struct {
+	values  url.Values
+	errs    ValidationErrors
+	strict  bool
+	catalog *i18n.Catalog
+}
+
+// Query returns a helper to extract typed query parameters from the request.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - opts: Optional query options.
+//
+// Returns:
+//   - *QueryParams: The query parameters helper.
+func Query(r *http.Request, opts ...QueryOption) *QueryParams {
+	q := &QueryParams{catalog: serverOf(r).Messages()}
+	for _, opt := range opts {
+		opt(q)
+	}
+	values, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		// ParseQuery keeps the well-formed pairs, only the broken ones are lost.
+		q.errs.addKey(q.catalog, "query", "", "validation.query", map[string]any{"error": err.Error()})
+	}
+	q.values = values
+	return q
+}
+
+// Has reports whether the parameter is present, even with an empty value.
+func (q *QueryParams) Has(name string) bool {
+	_, ok := q.values[name]
+	return ok
+}
+
+// value returns the value of a scalar parameter and whether it is set.
+// Missing and empty parameters are both reported as not set.
+func (q *QueryParams) value(name string) (string, bool) {
+	values := q.values[name]
+	if len(values) == 0 {
+		return "", false
+	}
+	if len(values) > 1 && q.strict {
+		q.errs.addKey(q.catalog, name, "", "validation.once", nil)
+		return "", false
+	}
+	if values[0] == "" {
+		return "", false
+	}
+	return values[0], true
+}
+
+// String returns the value of the parameter, or def when it is missing.
+// Unlike the other accessors, an empty value is returned as is.
+func (q *QueryParams) String(name string, def string) string {
+	if !q.Has(name) {
+		return def
+	}
+	values := q.values[name]
+	if len(values) > 1 && q.strict {
+		q.errs.addKey(q.catalog, name, "", "validation.once", nil)
+		return def
+	}
+	return values[0]
+}
+
+// Strings returns all the values of a repeated parameter.
+func (q *QueryParams) Strings(name string) []string {
+	return slices.Clone(q.values[name])
+}
+
+// Int returns the parameter as an integer, or def when it is missing or empty.
+func (q *QueryParams) Int(name string, def int) int {
+	value, ok := q.value(name)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		q.errs.addKey(q.catalog, name, "", "validation.integer", nil)
+		return def
+	}
+	return i
+}
+
+// Bool returns the parameter as a boolean, or def when it is missing or empty.
+// Besides the strconv.ParseBool forms, "on", "yes", "off" and "no" are accepted.
+func (q *QueryParams) Bool(name string, def bool) bool {
+	value, ok := q.value(name)
+	if !ok {
+		return def
+	}
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "on", "yes":
+		return true
+	case "off", "no":
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		q.errs.addKey(q.catalog, name, "", "validation.boolean", nil)
+		return def
+	}
+	return b
+}
+
+// Time returns the parameter parsed with the given layout, or def when it is missing or empty.
+func (q *QueryParams) Time(name string, layout string, def time.Time) time.Time {
+	value, ok := q.value(name)
+	if !ok {
+		return def
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		q.errs.addKey(q.catalog, name, "", "validation.time.layout", map[string]any{"layout": layout})
+		return def
+	}
+	return t
+}
+
+// Enum returns the parameter if it is one of the allowed values, or def when it is missing or empty.
+func (q *QueryParams) Enum(name string, def string, allowed ...string) string {
+	value, ok := q.value(name)
+	if !ok {
+		return def
+	}
+	if !slices.Contains(allowed, value) {
+		q.errs.addKey(q.catalog, name, "", "validation.oneof", map[string]any{"values": strings.Join(allowed, ", ")})
+		return def
+	}
+	return value
+}
+
+// Err returns the conversion errors as ValidationErrors, or nil if there were none.
+func (q *QueryParams) Err() error {
+	if len(q.errs) == 0 {
+		return nil
+	}
+	return q.errs
+}
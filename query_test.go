@@ -0,0 +1,108 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	epoch := time.Time{}
+	tests := []struct {
+		name    string
+		query   string
+		opts    []QueryOption
+		get     func(q *QueryParams) any
+		want    any
+		wantErr []string
+	}{
+		{"Int", "limit=20", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 20, nil},
+		{"Int missing", "", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 10, nil},
+		{"Int empty", "limit=", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 10, nil},
+		{"Int spaces", "limit=+20%20", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 20, nil},
+		{"Int invalid", "limit=ten", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 10, []string{"limit:validation.integer"}},
+		{"Int repeated", "limit=1&limit=2", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 1, nil},
+		{"Int repeated, strict", "limit=1&limit=2", []QueryOption{StrictQuery()}, func(q *QueryParams) any { return q.Int("limit", 10) }, 10, []string{"limit:validation.once"}},
+		{"Bool", "full=true", nil, func(q *QueryParams) any { return q.Bool("full", false) }, true, nil},
+		{"Bool on", "full=ON", nil, func(q *QueryParams) any { return q.Bool("full", false) }, true, nil},
+		{"Bool no", "full=no", nil, func(q *QueryParams) any { return q.Bool("full", true) }, false, nil},
+		{"Bool spaces", "full=%201%20", nil, func(q *QueryParams) any { return q.Bool("full", false) }, true, nil},
+		{"Bool invalid", "full=maybe", nil, func(q *QueryParams) any { return q.Bool("full", true) }, true, []string{"full:validation.boolean"}},
+		{"Bool empty", "full", nil, func(q *QueryParams) any { return q.Bool("full", true) }, true, nil},
+		{"String", "q=caf%C3%A9+cr%C3%A8me", nil, func(q *QueryParams) any { return q.String("q", "def") }, "café crème", nil},
+		{"String empty", "q=", nil, func(q *QueryParams) any { return q.String("q", "def") }, "", nil},
+		{"String missing", "", nil, func(q *QueryParams) any { return q.String("q", "def") }, "def", nil},
+		{"String plus and escaped plus", "q=a+b%2Bc", nil, func(q *QueryParams) any { return q.String("q", "") }, "a b+c", nil},
+		{"String repeated, strict", "q=a&q=b", []QueryOption{StrictQuery()}, func(q *QueryParams) any { return q.String("q", "def") }, "def", []string{"q:validation.once"}},
+		{"Strings", "tag=a&tag=&tag=b", nil, func(q *QueryParams) any { return q.Strings("tag") }, []string{"a", "", "b"}, nil},
+		{"Strings missing", "", nil, func(q *QueryParams) any { return q.Strings("tag") }, []string(nil), nil},
+		{"Strings repeated, strict", "tag=a&tag=b", []QueryOption{StrictQuery()}, func(q *QueryParams) any { return q.Strings("tag") }, []string{"a", "b"}, nil},
+		{"Time", "since=2024-05-01", nil, func(q *QueryParams) any { return q.Time("since", time.DateOnly, epoch) }, day, nil},
+		{"Time invalid", "since=yesterday", nil, func(q *QueryParams) any { return q.Time("since", time.DateOnly, epoch) }, epoch, []string{"since:validation.time.layout"}},
+		{"Enum", "sort=name", nil, func(q *QueryParams) any { return q.Enum("sort", "date", "date", "name") }, "name", nil},
+		{"Enum missing", "", nil, func(q *QueryParams) any { return q.Enum("sort", "date", "date", "name") }, "date", nil},
+		{"Enum not allowed", "sort=size", nil, func(q *QueryParams) any { return q.Enum("sort", "date", "date", "name") }, "date", []string{"sort:validation.oneof"}},
+		{"Has empty", "flag", nil, func(q *QueryParams) any { return q.Has("flag") }, true, nil},
+		{"Has missing", "", nil, func(q *QueryParams) any { return q.Has("flag") }, false, nil},
+		{"malformed pair", "limit=5&bad=%zz", nil, func(q *QueryParams) any { return q.Int("limit", 10) }, 5, []string{"query:validation.query"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		q := Query(r, tt.opts...)
+		if got := tt.get(q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %#v, want %#v", tt.name, got, tt.want)
+		}
+		var gotErr []string
+		var errs ValidationErrors
+		if errors.As(q.Err(), &errs) {
+			for _, fieldError := range errs {
+				gotErr = append(gotErr, fieldError.Field+":"+fieldError.Key)
+			}
+		} else if q.Err() != nil {
+			t.Errorf("%s: error %T, want ValidationErrors", tt.name, q.Err())
+		}
+		if !reflect.DeepEqual(gotErr, tt.wantErr) {
+			t.Errorf("%s: errors %q, want %q", tt.name, gotErr, tt.wantErr)
+		}
+	}
+}
+
+// TestQueryError answers the errors of several parameters with the error
+// handler: a single 400 names every one of them.
+func TestQueryError(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.GET("/items", func(w http.ResponseWriter, r *http.Request) {
+		q := Query(r)
+		q.Int("limit", 10)
+		q.Enum("sort", "date", "date", "name")
+		q.Bool("full", false)
+		if err := q.Err(); err != nil {
+			s.Error(w, r, err)
+		}
+	})
+	r := httptest.NewRequest(http.MethodGet, "/items?limit=x&sort=size&full=1", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", w.Code)
+	}
+	var problem struct {
+		Errors []FieldError `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	var fields []string
+	for _, fieldError := range problem.Errors {
+		fields = append(fields, fieldError.Field)
+	}
+	if want := []string{"limit", "sort"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("errors of %q, want %q: %s", fields, want, w.Body.String())
+	}
+}
@@ -0,0 +1,574 @@
+// Package queue is a durable local queue: an append-only log of records
+// split in segment files, consumed at least once. It buffers on disk the
+// records a bounded in-memory queue would drop under a burst or lose in a
+// crash, e.g. audit events or webhook deliveries.
+//
+// Each record is stored with its length and a CRC-32 checksum. A record torn
+// by a crash, or corrupted, is skipped on read; the records around it are
+// delivered: past a record failing its checksum or whose length can't be
+// right, the reading resumes at the next position framing a non-empty record
+// with a valid checksum. The consumer commits the offset of the records it
+// processed: on restart, the records appended after the last committed
+// offset are read again, and the segments fully consumed are deleted.
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Morditux/serverlib/units"
+)
+
+// DefaultSegmentSize is the size after which a segment is rotated when
+// Options.SegmentSize is not set.
+const DefaultSegmentSize = 16 << 20 // 16 MiB
+
+// DefaultMaxRecordSize is the size of the largest record when
+// Options.MaxRecordSize is not set.
+const DefaultMaxRecordSize = 1 << 20 // 1 MiB
+
+// DefaultSyncInterval is the interval of the SyncInterval policy when
+// Options.SyncInterval is not set.
+const DefaultSyncInterval = time.Second
+
+// headerSize is the size of the record header: the length and the CRC-32 of
+// the payload, big endian.
+const headerSize = 8
+
+// segmentExt is the extension of the segment files, named after their sequence number.
+const segmentExt = ".seg"
+
+// checkpointFile is the file holding the committed offset.
+const checkpointFile = "checkpoint"
+
+var (
+	// ErrClosed is returned by the operations on a closed queue.
+	ErrClosed = errors.New("queue: closed")
+	// ErrRecordTooLarge is returned by Append for the records larger than Options.MaxRecordSize.
+	ErrRecordTooLarge = errors.New("queue: record too large")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SyncPolicy selects when the appended records are flushed to the disk.
+type SyncPolicy int
+
+const (
+	// SyncInterval syncs at most once per Options.SyncInterval, on Append:
+	// a crash loses the records of the last interval at most.
+	SyncInterval SyncPolicy = iota
+	// SyncAlways syncs every Append, and every Commit.
+	SyncAlways
+	// SyncNever leaves the flushing to the operating system.
+	SyncNever
+)
+
+// Options configures a Queue.
+type Options struct {
+	// SegmentSize is the size after which the active segment is rotated.
+	// Defaults to DefaultSegmentSize.
+	SegmentSize int64
+	// MaxBytes bounds the size of the segments on disk: the oldest segments
+	// are evicted, unconsumed records included, to stay below it. Zero means
+	// no limit.
+	MaxBytes int64
+	// MaxRecordSize is the size of the largest record. Defaults to DefaultMaxRecordSize.
+	MaxRecordSize int
+	// Sync is the sync policy, SyncInterval by default.
+	Sync SyncPolicy
+	// SyncInterval is the interval of the SyncInterval policy. Defaults to DefaultSyncInterval.
+	SyncInterval time.Duration
+}
+
+// Offset is a position in the queue: a segment and a byte offset in it.
+type Offset struct {
+	Segment uint64
+	Pos     int64
+}
+
+// Record is a record read from the queue.
+type Record struct {
+	Data []byte
+	// Offset is the position following the record, to Commit once it is processed.
+	Offset Offset
+}
+
+// Stats are the counters of a Queue.
+type Stats struct {
+	// Segments is the number of segment files.
+	Segments int
+	// Bytes is the size of the segment files.
+	Bytes int64
+	// Appended is the number of records appended since Open.
+	Appended uint64
+	// Corrupted is the number of records skipped for a checksum mismatch or a
+	// torn write since Open.
+	Corrupted uint64
+	// EvictedBytes is the size of the segments evicted by MaxBytes since Open.
+	EvictedBytes int64
+}
+
+type segment struct {
+	id   uint64
+	size int64
+}
+
+// Queue is a durable queue of records, see the package documentation. It is
+// safe for concurrent use by producers and a single consumer.
+type Queue struct {
+	dir      string
+	options  Options
+	segments []segment
+	active   *os.File
+	lastSync time.Time
+	// read is the position of the consumer, reader the file it reads.
+	read   Offset
+	reader *os.File
+	stats  Stats
+	notify chan struct{}
+	closed bool
+	mut    *sync.Mutex
+}
+
+// Open opens the queue stored in dir, creating the directory if needed. The
+// consumer resumes from the last committed offset; the records are appended to
+// a new segment.
+//
+// Parameters:
+//   - dir: The directory of the segment files, owned by the queue.
+//   - opts: Optional variadic parameter of type Options.
+//
+// Returns:
+//   - *Queue: The queue, to Close.
+//   - error: An error if the directory can't be read or the segment created.
+func Open(dir string, opts ...Options) (*Queue, error) {
+	var options Options
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.MaxRecordSize <= 0 {
+		options.MaxRecordSize = DefaultMaxRecordSize
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, options: options, notify: make(chan struct{}), mut: &sync.Mutex{}}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		id, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), segmentExt), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.segments = append(q.segments, segment{id: id, size: info.Size()})
+	}
+	slices.SortFunc(q.segments, func(a, b segment) int { return compareID(a.id, b.id) })
+	q.read, err = q.loadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	q.deleteConsumed()
+	next := uint64(1)
+	if len(q.segments) > 0 {
+		next = q.segments[len(q.segments)-1].id + 1
+	}
+	if err := q.rotate(next); err != nil {
+		return nil, err
+	}
+	if q.read.Segment < q.segments[0].id {
+		q.read = Offset{Segment: q.segments[0].id}
+	}
+	slog.Info("Opened queue", "dir", dir, "segments", len(q.segments), "resume", q.read)
+	return q, nil
+}
+
+func compareID(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (q *Queue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// rotate closes the active segment and creates the segment id.
+func (q *Queue) rotate(id uint64) error {
+	if q.active != nil {
+		if q.options.Sync != SyncNever {
+			q.active.Sync()
+		}
+		q.active.Close()
+	}
+	file, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.active = file
+	q.segments = append(q.segments, segment{id: id})
+	syncDir(q.dir)
+	return nil
+}
+
+// Append appends a record. It is durable according to the sync policy.
+func (q *Queue) Append(data []byte) error {
+	if len(data) > q.options.MaxRecordSize {
+		return fmt.Errorf("%w: %s, over %s", ErrRecordTooLarge, units.FormatBytes(int64(len(data))), units.FormatBytes(int64(q.options.MaxRecordSize)))
+	}
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(data, crcTable))
+	copy(record[headerSize:], data)
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	last := &q.segments[len(q.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > q.options.SegmentSize {
+		if err := q.rotate(last.id + 1); err != nil {
+			return err
+		}
+		last = &q.segments[len(q.segments)-1]
+	}
+	if n, err := q.active.Write(record); err != nil {
+		q.discardPartial(last, n)
+		return err
+	}
+	last.size += int64(len(record))
+	q.stats.Appended++
+	if q.options.Sync == SyncAlways || (q.options.Sync == SyncInterval && time.Since(q.lastSync) >= q.options.SyncInterval) {
+		q.lastSync = time.Now()
+		if err := q.active.Sync(); err != nil {
+			return err
+		}
+	}
+	q.evict()
+	close(q.notify)
+	q.notify = make(chan struct{})
+	return nil
+}
+
+// discardPartial removes the n bytes of a record partially written at the
+// end of the active segment last, for the next records to follow the last
+// complete one. When the segment can't be truncated, the torn bytes are kept
+// in its size, for the reader to skip them, and a new segment is started.
+// It is called with the lock held.
+func (q *Queue) discardPartial(last *segment, n int) {
+	if n <= 0 {
+		return
+	}
+	err := q.active.Truncate(last.size)
+	if err == nil {
+		return
+	}
+	slog.Error("Truncating a torn queue record", "segment", last.id, "error", err)
+	last.size += int64(n)
+	if err := q.rotate(last.id + 1); err != nil {
+		slog.Error("Rotating the queue segment", "segment", last.id, "error", err)
+	}
+}
+
+// Sync flushes the appended records to the disk.
+func (q *Queue) Sync() error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	q.lastSync = time.Now()
+	return q.active.Sync()
+}
+
+// Read returns the next record, waiting for one until ctx is done. The
+// records are read again after a restart until their offset is committed.
+func (q *Queue) Read(ctx context.Context) (Record, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	for {
+		if q.closed {
+			return Record{}, ErrClosed
+		}
+		record, ok, err := q.next()
+		if err != nil || ok {
+			return record, err
+		}
+		notify := q.notify
+		q.mut.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			q.mut.Lock()
+			return Record{}, ctx.Err()
+		}
+		q.mut.Lock()
+	}
+}
+
+// next reads the record at the read offset; ok is false when the consumer
+// reached the end of the queue. It is called with the lock held.
+func (q *Queue) next() (record Record, ok bool, err error) {
+	for {
+		i := q.segmentIndex(q.read.Segment)
+		if i < 0 {
+			return Record{}, false, nil
+		}
+		current := q.segments[i]
+		if q.read.Segment != current.id {
+			q.moveTo(Offset{Segment: current.id})
+		}
+		active := i == len(q.segments)-1
+		if q.read.Pos+headerSize > current.size {
+			if active {
+				return Record{}, false, nil
+			}
+			if q.read.Pos < current.size {
+				q.stats.Corrupted++
+				slog.Warn("Torn record skipped", "segment", current.id, "pos", q.read.Pos)
+			}
+			q.moveTo(Offset{Segment: q.segments[i+1].id})
+			continue
+		}
+		if q.reader == nil {
+			if q.reader, err = os.Open(q.segmentPath(current.id)); err != nil {
+				return Record{}, false, err
+			}
+		}
+		var header [headerSize]byte
+		if _, err := q.reader.ReadAt(header[:], q.read.Pos); err != nil {
+			return Record{}, false, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:]))
+		end := q.read.Pos + headerSize + length
+		valid := length <= int64(q.options.MaxRecordSize) && end <= current.size
+		var data []byte
+		if valid {
+			data = make([]byte, length)
+			if _, err := q.reader.ReadAt(data, q.read.Pos+headerSize); err != nil && err != io.EOF {
+				return Record{}, false, err
+			}
+			valid = crc32.Checksum(data, crcTable) == binary.BigEndian.Uint32(header[4:])
+		}
+		if !valid {
+			// A torn write, or a corruption of the length or the payload:
+			// the length can't be trusted, the next record is searched for.
+			pos := q.read.Pos
+			q.read.Pos, err = q.resync(current, pos+1)
+			if err != nil {
+				return Record{}, false, err
+			}
+			q.stats.Corrupted++
+			slog.Warn("Corrupted record skipped", "segment", current.id, "pos", pos, "bytes", q.read.Pos-pos)
+			continue
+		}
+		q.read.Pos = end
+		return Record{Data: data, Offset: q.read}, true, nil
+	}
+}
+
+// resync returns the first position of the segment from from on framing a
+// non-empty record with a valid checksum, or the size of the segment. The
+// empty records are passed over, the zeros of a torn write framing them.
+// It is called with the lock held.
+func (q *Queue) resync(current segment, from int64) (int64, error) {
+	if from >= current.size {
+		return current.size, nil
+	}
+	tail := make([]byte, current.size-from)
+	if _, err := q.reader.ReadAt(tail, from); err != nil && err != io.EOF {
+		return 0, err
+	}
+	for i := 0; i+headerSize < len(tail); i++ {
+		length := int(binary.BigEndian.Uint32(tail[i:]))
+		end := i + headerSize + length
+		if length == 0 || length > q.options.MaxRecordSize || end > len(tail) {
+			continue
+		}
+		if crc32.Checksum(tail[i+headerSize:end], crcTable) == binary.BigEndian.Uint32(tail[i+4:]) {
+			return from + int64(i), nil
+		}
+	}
+	return current.size, nil
+}
+
+// segmentIndex returns the index of the first segment from id on, or -1.
+func (q *Queue) segmentIndex(id uint64) int {
+	for i, s := range q.segments {
+		if s.id >= id {
+			return i
+		}
+	}
+	return -1
+}
+
+// moveTo moves the consumer to the offset, in another segment.
+func (q *Queue) moveTo(offset Offset) {
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	q.read = offset
+}
+
+// Commit records that the records up to offset are processed: they aren't
+// read again after a restart, and their segments are deleted.
+func (q *Queue) Commit(offset Offset) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	tmp := filepath.Join(q.dir, checkpointFile+".tmp")
+	content := fmt.Sprintf("%d %d\n", offset.Segment, offset.Pos)
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(content)
+	if err == nil && q.options.Sync == SyncAlways {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(q.dir, checkpointFile))
+	}
+	if err != nil {
+		return err
+	}
+	q.deleteConsumedBefore(offset.Segment)
+	if len(q.segments) > 1 && q.segments[0].id == offset.Segment && offset.Pos >= q.segments[0].size {
+		q.remove()
+	}
+	return nil
+}
+
+// loadCheckpoint returns the committed offset.
+func (q *Queue) loadCheckpoint() (Offset, error) {
+	content, err := os.ReadFile(filepath.Join(q.dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Offset{}, nil
+	}
+	if err != nil {
+		return Offset{}, err
+	}
+	var offset Offset
+	if _, err := fmt.Sscanf(string(content), "%d %d", &offset.Segment, &offset.Pos); err != nil {
+		slog.Warn("Invalid queue checkpoint, reading from the start", "dir", q.dir, "error", err)
+		return Offset{}, nil
+	}
+	return offset, nil
+}
+
+// deleteConsumed deletes the segments before the read offset.
+func (q *Queue) deleteConsumed() {
+	q.deleteConsumedBefore(q.read.Segment)
+}
+
+// deleteConsumedBefore deletes the segments before id, the active one excepted.
+func (q *Queue) deleteConsumedBefore(id uint64) {
+	for len(q.segments) > 1 && q.segments[0].id < id {
+		q.remove()
+	}
+	if len(q.segments) == 1 && q.segments[0].id < id && q.active == nil {
+		q.remove()
+	}
+}
+
+// remove deletes the oldest segment.
+func (q *Queue) remove() {
+	oldest := q.segments[0]
+	if q.read.Segment <= oldest.id {
+		q.moveTo(Offset{Segment: oldest.id + 1})
+	}
+	if err := os.Remove(q.segmentPath(oldest.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Removing queue segment", "segment", oldest.id, "error", err)
+	}
+	q.segments = q.segments[1:]
+}
+
+// evict deletes the oldest segments above MaxBytes.
+func (q *Queue) evict() {
+	if q.options.MaxBytes <= 0 {
+		return
+	}
+	total := int64(0)
+	for _, s := range q.segments {
+		total += s.size
+	}
+	for total > q.options.MaxBytes && len(q.segments) > 1 {
+		oldest := q.segments[0]
+		total -= oldest.size
+		q.stats.EvictedBytes += oldest.size
+		slog.Warn("Queue segment evicted", "dir", q.dir, "segment", oldest.id, "size", units.FormatBytes(oldest.size))
+		q.remove()
+	}
+}
+
+// Stats returns the counters of the queue.
+func (q *Queue) Stats() Stats {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	stats := q.stats
+	stats.Segments = len(q.segments)
+	for _, s := range q.segments {
+		stats.Bytes += s.size
+	}
+	return stats
+}
+
+// Close syncs and closes the queue. The pending Read calls return ErrClosed.
+func (q *Queue) Close() error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.notify)
+	if q.reader != nil {
+		q.reader.Close()
+	}
+	err := q.active.Sync()
+	if closeErr := q.active.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir syncs the directory entries, for the created segments to survive a crash.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
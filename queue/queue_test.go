@@ -0,0 +1,294 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// appendAll appends the records to a new queue in dir and closes it.
+func appendAll(t *testing.T, dir string, records ...string) {
+	t.Helper()
+	q, err := Open(dir, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := q.Append([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAll reads the records of q until none is left.
+func readAll(t *testing.T, q *Queue) []string {
+	t.Helper()
+	var records []string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		record, err := q.Read(ctx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, string(record.Data))
+	}
+}
+
+// segmentFiles returns the segment files of dir, oldest first.
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+// TestCrashRecovery damages the segment of the first run like a crash or a
+// disk fault would, then checks that only the damaged record is lost.
+func TestCrashRecovery(t *testing.T) {
+	records := []string{"first", "second", "third"}
+	// offset is the position of the third record in the segment.
+	offset := int64(2*headerSize + len("first") + len("second"))
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, path string)
+		want   []string
+	}{
+		{"intact", func(t *testing.T, path string) {}, []string{"first", "second", "third", "after"}},
+		{"truncated in the header", func(t *testing.T, path string) {
+			truncate(t, path, offset+headerSize/2)
+		}, []string{"first", "second", "after"}},
+		{"truncated in the payload", func(t *testing.T, path string) {
+			truncate(t, path, offset+headerSize+2)
+		}, []string{"first", "second", "after"}},
+		{"torn write padded with zeros", func(t *testing.T, path string) {
+			truncate(t, path, offset+headerSize+2)
+			writeAt(t, path, int(offset)+headerSize+2, make([]byte, 64))
+		}, []string{"first", "second", "after"}},
+		{"corrupted payload", func(t *testing.T, path string) {
+			writeAt(t, path, headerSize+len("first")+headerSize, []byte("X"))
+		}, []string{"first", "third", "after"}},
+		{"corrupted length", func(t *testing.T, path string) {
+			writeAt(t, path, headerSize+len("first"), []byte{0xff, 0xff})
+		}, []string{"first", "third", "after"}},
+		{"corrupted checksum", func(t *testing.T, path string) {
+			writeAt(t, path, headerSize+len("first")+4, []byte{0xff})
+		}, []string{"first", "third", "after"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			appendAll(t, dir, records...)
+			tt.damage(t, segmentFiles(t, dir)[0])
+			appendAll(t, dir, "after")
+
+			q, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+			if got := readAll(t, q); !slices.Equal(got, tt.want) {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+			wantCorrupted := uint64(len(records) + 1 - len(tt.want))
+			if got := q.Stats().Corrupted; got != wantCorrupted {
+				t.Errorf("%d corrupted records, want %d", got, wantCorrupted)
+			}
+		})
+	}
+}
+
+func truncate(t *testing.T, path string, size int64) {
+	t.Helper()
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeAt(t *testing.T, path string, offset int, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(data, int64(offset)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDiscardPartial leaves the beginning of a record at the end of the
+// active segment, as a failed write would: the next records follow the last
+// complete one.
+func TestDiscardPartial(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err := q.Append([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	last := &q.segments[len(q.segments)-1]
+	n, err := q.active.Write([]byte{0, 0, 0, 9, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.discardPartial(last, n)
+	if err := q.Append([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, q), []string{"first", "second"}; !slices.Equal(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	info, err := os.Stat(segmentFiles(t, dir)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(2*headerSize + len("first") + len("second")); info.Size() != want {
+		t.Errorf("segment of %d bytes, want %d", info.Size(), want)
+	}
+}
+
+func TestCommitResume(t *testing.T) {
+	tests := []struct {
+		name      string
+		committed int
+		want      []string
+	}{
+		{"nothing committed", 0, []string{"a", "b", "c"}},
+		{"first committed", 1, []string{"b", "c"}},
+		{"all committed", 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			appendAll(t, dir, "a", "b", "c")
+			q, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range tt.committed {
+				record, err := q.Read(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := q.Commit(record.Offset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			q.Close()
+
+			q, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+			if got := readAll(t, q); !slices.Equal(got, tt.want) {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotationAndEviction(t *testing.T) {
+	dir := t.TempDir()
+	record := make([]byte, 100)
+	q, err := Open(dir, Options{SegmentSize: 250, MaxBytes: 500, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for range 10 {
+		if err := q.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := q.Stats()
+	if stats.Bytes > 500 {
+		t.Errorf("%d bytes on disk, over MaxBytes", stats.Bytes)
+	}
+	if stats.EvictedBytes == 0 {
+		t.Error("no segment evicted")
+	}
+	if got := len(segmentFiles(t, dir)); got != stats.Segments {
+		t.Errorf("%d segment files, Stats.Segments %d", got, stats.Segments)
+	}
+	if got := len(readAll(t, q)); got != int(stats.Bytes/(headerSize+100)) {
+		t.Errorf("%d records read, want the %d left on disk", got, stats.Bytes/(headerSize+100))
+	}
+}
+
+func TestAppendTooLarge(t *testing.T) {
+	q, err := Open(t.TempDir(), Options{MaxRecordSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if err := q.Append([]byte("too large")); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("Append: %v, want ErrRecordTooLarge", err)
+	}
+}
+
+func BenchmarkAppend(b *testing.B) {
+	for _, size := range []int{64, 1024, 16 << 10} {
+		for _, policy := range []struct {
+			name string
+			sync SyncPolicy
+		}{{"SyncNever", SyncNever}, {"SyncInterval", SyncInterval}} {
+			b.Run(fmt.Sprintf("%s/%dB", policy.name, size), func(b *testing.B) {
+				q, err := Open(b.TempDir(), Options{Sync: policy.sync})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer q.Close()
+				record := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for range b.N {
+					if err := q.Append(record); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkAppendReadCommit(b *testing.B) {
+	q, err := Open(b.TempDir(), Options{Sync: SyncNever})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer q.Close()
+	record := make([]byte, 1024)
+	b.SetBytes(int64(len(record)))
+	b.ResetTimer()
+	for range b.N {
+		if err := q.Append(record); err != nil {
+			b.Fatal(err)
+		}
+		read, err := q.Read(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := q.Commit(read.Offset); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
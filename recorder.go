@@ -0,0 +1,134 @@
+package serverlib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// CookieSource names the library component writing a cookie.
+type CookieSource string
+
+const (
+	// CookieSourceGetSession is the cookie of a session created by GetSession.
+	CookieSourceGetSession CookieSource = "GetSession"
+	// CookieSourceMigration is a session cookie re-issued or expired by the
+	// attribute migration (see CookieConfig).
+	CookieSourceMigration CookieSource = "migration"
+	// CookieSourceDestroySession is a session cookie expired by DestroySession.
+	CookieSourceDestroySession CookieSource = "DestroySession"
+)
+
+// CookieWrite is a Set-Cookie emitted by the library.
+type CookieWrite struct {
+	Source CookieSource
+	Cookie http.Cookie
+}
+
+// setCookie is the single place the library writes cookies from. Every write
+// is logged at Debug with its source, and recorded by a ResponseRecorder
+// found in the response writer chain. The priority of the cookie decides which
+// cookies are dropped first over the header budget (see HeaderBudgetOptions);
+// a cookie larger than MaxCookieSize is not written, a *CookieTooLargeError is
+// returned instead.
+func (s *Server) setCookie(w http.ResponseWriter, cookie *http.Cookie, source CookieSource, priority CookiePriority) error {
+	line := cookie.String()
+	if len(line) > MaxCookieSize {
+		err := &CookieTooLargeError{Name: cookie.Name, Source: source, Size: len(line)}
+		s.LogError("Cookie not written", err.Error())
+		return err
+	}
+	if line == "" {
+		// An invalid cookie, dropped like http.SetCookie does.
+		return nil
+	}
+	w.Header().Add("Set-Cookie", line)
+	if budget, ok := findWriter[*headerBudgetWriter](w); ok {
+		budget.priorities[line] = priority
+	}
+	if s.logLevel >= Debug {
+		logged := *cookie
+		if logged.Value != "" {
+			logged.Value = redacted
+		}
+		s.LogDebug("Set-Cookie from "+string(source), logged.String())
+	}
+	if recorder := findRecorder(w); recorder != nil {
+		recorder.recordCookie(CookieWrite{Source: source, Cookie: *cookie})
+	}
+	return nil
+}
+
+// findRecorder returns the ResponseRecorder of the response writer chain of w, or nil.
+func findRecorder(w io.Writer) *ResponseRecorder {
+	recorder, _ := findWriter[*ResponseRecorder](w)
+	return recorder
+}
+
+// findWriter returns the first writer of type T of the response writer chain of w.
+func findWriter[T any](w io.Writer) (T, bool) {
+	for w != nil {
+		if found, ok := w.(T); ok {
+			return found, true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = unwrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// recordTemplate records a template rendered to w by a ResponseRecorder, and
+// in the request log.
+func recordTemplate(w io.Writer, template string) {
+	if logged, ok := findWriter[*requestLogWriter](w); ok && logged.template == "" {
+		logged.template = template
+	}
+	if recorder := findRecorder(w); recorder != nil {
+		recorder.mut.Lock()
+		recorder.templates = append(recorder.templates, template)
+		recorder.mut.Unlock()
+	}
+}
+
+// ResponseRecorder is an httptest.ResponseRecorder recording the cookies the
+// library writes and which component wrote them, to debug lost sessions, and
+// the templates rendered.
+type ResponseRecorder struct {
+	*httptest.ResponseRecorder
+	writes    []CookieWrite
+	templates []string
+	mut       *sync.Mutex
+}
+
+// NewResponseRecorder creates a ResponseRecorder.
+func NewResponseRecorder() *ResponseRecorder {
+	return &ResponseRecorder{
+		ResponseRecorder: httptest.NewRecorder(),
+		mut:              &sync.Mutex{},
+	}
+}
+
+func (r *ResponseRecorder) recordCookie(write CookieWrite) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.writes = append(r.writes, write)
+}
+
+// CookieWrites returns the cookies written by the library, in order.
+func (r *ResponseRecorder) CookieWrites() []CookieWrite {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return append([]CookieWrite(nil), r.writes...)
+}
+
+// Templates returns the names of the templates rendered to the recorder, in order.
+func (r *ResponseRecorder) Templates() []string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return append([]string(nil), r.templates...)
+}
@@ -0,0 +1,98 @@
+package serverlib
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestCookieWrites follows the session cookie through the login, logout and
+// rotation flows: each Set-Cookie is attributed to the component writing it,
+// in order, and logged at Debug without its value.
+func TestCookieWrites(t *testing.T) {
+	current := CookieAttributes{Path: "/"}
+	previous := CookieAttributes{Path: "/app"}
+	type write struct {
+		source CookieSource
+		path   string
+		maxAge int
+	}
+	tests := []struct {
+		name   string
+		config CookieConfig
+		target string
+		// cookie returns the session cookie of the request, if any.
+		cookie func(s *Server) string
+		want   []write
+		// wantSession reports whether the session of the cookie still exists.
+		wantSession bool
+	}{
+		{"login", CookieConfig{CookieAttributes: current}, "/login", nil, []write{
+			{CookieSourceGetSession, "/", DefaultCookieMaxAge},
+		}, true},
+		{"request of a session", CookieConfig{CookieAttributes: current}, "/page", func(s *Server) string {
+			return s.sessionManager.New().Id()
+		}, nil, true},
+		{"application cookie", CookieConfig{CookieAttributes: current}, "/theme", func(s *Server) string {
+			return s.sessionManager.New().Id()
+		}, []write{{CookieSourceApplication, "", 0}}, true},
+		{"logout", CookieConfig{CookieAttributes: current}, "/logout", func(s *Server) string {
+			return s.sessionManager.New().Id()
+		}, []write{{CookieSourceDestroySession, "/", -1}}, false},
+		{"logout after a migration", CookieConfig{CookieAttributes: current, Version: 1, Previous: []CookieAttributes{previous}}, "/logout", func(s *Server) string {
+			return "v1~" + s.sessionManager.New().Id()
+		}, []write{{CookieSourceDestroySession, "/app", -1}, {CookieSourceDestroySession, "/", -1}}, false},
+		{"rotation", CookieConfig{CookieAttributes: current, Version: 1, Previous: []CookieAttributes{previous}}, "/page", func(s *Server) string {
+			return s.sessionManager.New().Id()
+		}, []write{{CookieSourceMigration, "/app", -1}, {CookieSourceMigration, "/", DefaultCookieMaxAge}}, true},
+	}
+	for _, tt := range tests {
+		logs := &syncBuffer{}
+		s := NewServer(ServerConfig{SessionCookie: tt.config, ErrorLog: log.New(logs, "", 0), LogLevel: Debug})
+		s.GET("/login", func(w http.ResponseWriter, r *http.Request) {
+			session, _ := s.GetSession(w, r)
+			session.Set("user", "ann")
+		})
+		s.GET("/page", func(w http.ResponseWriter, r *http.Request) { s.GetSession(w, r) })
+		s.GET("/theme", func(w http.ResponseWriter, r *http.Request) {
+			s.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark"}, CookiePriorityNormal)
+		})
+		s.GET("/logout", func(w http.ResponseWriter, r *http.Request) { s.DestroySession(w, r) })
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		var value string
+		if tt.cookie != nil {
+			value = tt.cookie(s)
+			r.AddCookie(&http.Cookie{Name: s.sessionKey, Value: value})
+		}
+		recorder := NewResponseRecorder()
+		s.ServeHTTP(recorder, r)
+
+		var got []write
+		for _, cookieWrite := range recorder.CookieWrites() {
+			got = append(got, write{cookieWrite.Source, cookieWrite.Cookie.Path, cookieWrite.Cookie.MaxAge})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: writes %v, want %v", tt.name, got, tt.want)
+		}
+		if lines := recorder.Result().Header["Set-Cookie"]; len(lines) != len(tt.want) {
+			t.Errorf("%s: %d Set-Cookie for %d writes recorded", tt.name, len(lines), len(tt.want))
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(logs.String(), "Set-Cookie from "+string(want.source)) {
+				t.Errorf("%s: write of %s not logged", tt.name, want.source)
+			}
+		}
+		if value != "" {
+			id, _ := decodeSessionCookie(value)
+			if strings.Contains(logs.String(), id) {
+				t.Errorf("%s: session ID logged", tt.name)
+			}
+			if _, found := s.sessionManager.Get(id); found != tt.wantSession {
+				t.Errorf("%s: session found %v, want %v", tt.name, found, tt.wantSession)
+			}
+		}
+	}
+}
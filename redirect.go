@@ -0,0 +1,118 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// DefaultRedirectHTTPAddr is the address of the HTTP to HTTPS redirector
+// when ServerConfig.RedirectHTTPAddr is not set.
+const DefaultRedirectHTTPAddr = ":80"
+
+// httpsPort returns the port of the first HTTPS listener, or an empty string
+// for 443 and the unix sockets, which the redirects don't name.
+func httpsPort(listeners []boundListener) string {
+	for _, l := range listeners {
+		if !l.tls || l.Addr().Network() == "unix" {
+			continue
+		}
+		_, port, err := net.SplitHostPort(l.Addr().String())
+		if err != nil || port == "443" {
+			return ""
+		}
+		return port
+	}
+	return ""
+}
+
+// redirectHandler redirects the requests to the same URL over HTTPS, on port
+// unless it is empty. The host is taken from the Host header, lowercased,
+// without its port and trailing dot; the requests without a valid one get a
+// 400. GET and HEAD are redirected with a 301, the other methods with a 308
+// so that they are replayed with their body.
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := redirectHost(r.Host)
+		if host == "" {
+			http.Error(w, "missing or invalid Host header", http.StatusBadRequest)
+			return
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			// An IPv6 address.
+			host = "[" + host + "]"
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		w.Header().Set("Connection", "close")
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// redirectHost returns the normalized host name of a Host header, or an
+// empty string when it is not valid: a name of letters, digits, hyphens,
+// underscores and dots, or an IP address.
+func redirectHost(header string) string {
+	host := header
+	if h, _, err := net.SplitHostPort(header); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	if strings.Contains(host, ":") {
+		if ip, err := netip.ParseAddr(host); err != nil || ip.Zone() != "" {
+			return ""
+		}
+		return host
+	}
+	if host == "" || strings.Trim(host, "abcdefghijklmnopqrstuvwxyz0123456789-_.") != "" {
+		return ""
+	}
+	return host
+}
+
+// serveRedirector binds addr and serves handler on it, as the component name
+// of the server (see Go), stopped by Shutdown and Stop.
+func (s *Server) serveRedirector(name, addr string, handler http.Handler) error {
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+	redirector := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          s.logger,
+	}
+	err = s.Go(name, func(ctx context.Context) error {
+		context.AfterFunc(ctx, func() {
+			redirector.Close()
+		})
+		slog.Info("HTTP redirector started", "address", listenerAddr(l), "component", name)
+		if err := redirector.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		l.Close()
+	}
+	return err
+}
+
+// startRedirectHTTP starts the redirector of ServerConfig.RedirectHTTP for
+// StartTLS, once the HTTPS listeners are bound.
+func (s *Server) startRedirectHTTP(listeners []boundListener) error {
+	addr := s.config.RedirectHTTPAddr
+	if addr == "" {
+		addr = DefaultRedirectHTTPAddr
+	}
+	return s.serveRedirector("https-redirect", addr, redirectHandler(httpsPort(listeners)))
+}
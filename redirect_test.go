@@ -0,0 +1,172 @@
+package serverlib
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRedirectHost(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"example.com.", "example.com"},
+		{"example.com.:80", "example.com"},
+		{"my_host.internal", "my_host.internal"},
+		{"192.0.2.1", "192.0.2.1"},
+		{"192.0.2.1:80", "192.0.2.1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:80", "2001:db8::1"},
+		{"2001:db8::1", "2001:db8::1"},
+
+		{"", ""},
+		{":80", ""},
+		{".", ""},
+		{"evil.com/path", ""},
+		{"evil.com?x", ""},
+		{"evil.com#x", ""},
+		{"user@evil.com", ""},
+		{"evil.com\\x", ""},
+		{"evil .com", ""},
+		{"evil.com%2f", ""},
+		{"example.com:80:90", ""},
+		{"[fe80::1%eth0]", ""},
+		{"[example.com]", "example.com"},
+		{"ex\r\nample.com", ""},
+	}
+	for _, tt := range tests {
+		if got := redirectHost(tt.header); got != tt.want {
+			t.Errorf("%q: %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		port      string
+		method    string
+		target    string
+		host      string
+		wantCode  int
+		wantLoc   string
+		wantClose bool
+	}{
+		{"GET", "", http.MethodGet, "/path?q=1", "example.com", http.StatusMovedPermanently, "https://example.com/path?q=1", true},
+		{"HEAD", "", http.MethodHead, "/", "example.com", http.StatusMovedPermanently, "https://example.com/", true},
+		{"POST replayed", "", http.MethodPost, "/form", "example.com", http.StatusPermanentRedirect, "https://example.com/form", true},
+		{"port of the Host dropped", "", http.MethodGet, "/", "example.com:80", http.StatusMovedPermanently, "https://example.com/", true},
+		{"HTTPS port", "8443", http.MethodGet, "/a", "Example.com:8080", http.StatusMovedPermanently, "https://example.com:8443/a", true},
+		{"IPv6", "", http.MethodGet, "/", "[2001:db8::1]:80", http.StatusMovedPermanently, "https://[2001:db8::1]/", true},
+		{"IPv6 with port", "8443", http.MethodGet, "/", "[2001:db8::1]", http.StatusMovedPermanently, "https://[2001:db8::1]:8443/", true},
+		{"escaped path kept", "", http.MethodGet, "/a%2Fb", "example.com", http.StatusMovedPermanently, "https://example.com/a%2Fb", true},
+		{"no Host", "", http.MethodGet, "/", "", http.StatusBadRequest, "", false},
+		{"invalid Host", "", http.MethodGet, "/", "evil.com/x", http.StatusBadRequest, "", false},
+		{"userinfo Host", "", http.MethodGet, "/", "user@evil.com", http.StatusBadRequest, "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectHandler(tt.port).ServeHTTP(w, r)
+		if w.Code != tt.wantCode || w.Header().Get("Location") != tt.wantLoc {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Header().Get("Location"), tt.wantCode, tt.wantLoc)
+		}
+		if closed := w.Header().Get("Connection") == "close"; closed != tt.wantClose {
+			t.Errorf("%s: Connection %q", tt.name, w.Header().Get("Connection"))
+		}
+	}
+}
+
+// fakeListener is a listener of the address, accepting nothing.
+type fakeListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l fakeListener) Addr() net.Addr {
+	return l.addr
+}
+
+func TestHTTPSPort(t *testing.T) {
+	tcp := func(address string, tls bool) boundListener {
+		addr, _ := net.ResolveTCPAddr("tcp", address)
+		return boundListener{Listener: fakeListener{addr: addr}, tls: tls}
+	}
+	unix := boundListener{Listener: fakeListener{addr: &net.UnixAddr{Name: "/run/http.sock", Net: "unix"}}, tls: true}
+	tests := []struct {
+		name      string
+		listeners []boundListener
+		want      string
+	}{
+		{"443", []boundListener{tcp("127.0.0.1:443", true)}, ""},
+		{"other port", []boundListener{tcp("127.0.0.1:8443", true)}, "8443"},
+		{"first HTTPS listener", []boundListener{tcp("127.0.0.1:8080", false), tcp("[::1]:9443", true), tcp("127.0.0.1:443", true)}, "9443"},
+		{"unix socket skipped", []boundListener{unix, tcp("127.0.0.1:8443", true)}, "8443"},
+		{"no HTTPS listener", []boundListener{tcp("127.0.0.1:8080", false)}, ""},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := httpsPort(tt.listeners); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRedirectHTTP starts a TLS server with RedirectHTTP: the redirector
+// answers on RedirectHTTPAddr with the port of the HTTPS listener, and stops
+// with the server.
+func TestRedirectHTTP(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	certFile, keyFile := newTestCA(t, "CA").writeServerCert(t, t.TempDir(), 1)
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", RedirectHTTP: true, RedirectHTTPAddr: "127.0.0.1:0"})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StartTLS(certFile, keyFile)
+	}()
+	<-s.Ready()
+	// The redirector logs its address once serving.
+	started := regexp.MustCompile(`HTTP redirector started" address=(\S+)`)
+	var match []string
+	for deadline := time.Now().Add(10 * time.Second); match == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		match = started.FindStringSubmatch(logs.String())
+	}
+	if match == nil {
+		t.Fatalf("redirector not started: %s", logs.String())
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get("http://" + match[1] + "/path?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	_, port, _ := net.SplitHostPort(s.Addr())
+	if want := "https://127.0.0.1:" + port + "/path?q=1"; resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != want {
+		t.Errorf("%d %q, want 301 %q", resp.StatusCode, resp.Header.Get("Location"), want)
+	}
+	s.Stop()
+	waitStart(t, done)
+	// The redirector is closed by the cancellation of the components.
+	var stopped bool
+	for deadline := time.Now().Add(10 * time.Second); !stopped && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", match[1])
+		if stopped = err != nil; !stopped {
+			conn.Close()
+		}
+	}
+	if !stopped {
+		t.Error("redirector still serving after Stop")
+	}
+}
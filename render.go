@@ -0,0 +1,131 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/Morditux/serverlib/templates"
+)
+
+// RenderOption configures a call to RenderRequest.
+type RenderOption func(*renderOptions)
+
+type renderOptions struct {
+	streaming   bool
+	contentType string
+	tenant      string
+}
+
+// ForTenant renders the override of the template for the tenant when the
+// TemplateOverrides provider of the configuration has one (see
+// templates.Templates.ExecuteTenant), the compiled template otherwise.
+func ForTenant(tenant string) RenderOption {
+	return func(o *renderOptions) {
+		o.tenant = tenant
+	}
+}
+
+// execute renders the template to w with the tenant override, if any.
+func (o *renderOptions) execute(ctx context.Context, t *templates.Templates, w io.Writer, template string, data interface{}) error {
+	if o.tenant != "" {
+		return t.ExecuteTenant(ctx, w, o.tenant, template, data)
+	}
+	return t.ExecuteContext(ctx, w, template, data)
+}
+
+// WithContentType overrides the Content-Type derived from the template name.
+// A Content-Type already set by the handler still takes precedence.
+func WithContentType(contentType string) RenderOption {
+	return func(o *renderOptions) {
+		o.contentType = contentType
+	}
+}
+
+// setContentType sets the Content-Type of the rendered template on w, unless
+// w is not an http.ResponseWriter or the handler already set one.
+func (o *renderOptions) setContentType(w io.Writer, template string) {
+	rw, ok := w.(http.ResponseWriter)
+	if !ok || rw.Header().Get("Content-Type") != "" {
+		return
+	}
+	contentType := o.contentType
+	if contentType == "" {
+		contentType = templates.ContentType(template)
+	}
+	rw.Header().Set("Content-Type", contentType)
+}
+
+// contentTypeOf returns the Content-Type of the rendered template: the one of
+// the response when w is an http.ResponseWriter, else the one derived from the
+// template name.
+func (o *renderOptions) contentTypeOf(w io.Writer, template string) string {
+	if rw, ok := w.(http.ResponseWriter); ok {
+		if contentType := rw.Header().Get("Content-Type"); contentType != "" {
+			return contentType
+		}
+	}
+	if o.contentType != "" {
+		return o.contentType
+	}
+	return templates.ContentType(template)
+}
+
+// Streaming makes RenderRequest write the template output directly to the
+// response instead of buffering it. If the request is cancelled mid-render the
+// client gets partial output; dealing with it is up to the caller.
+func Streaming() RenderOption {
+	return func(o *renderOptions) {
+		o.streaming = true
+	}
+}
+
+// RenderRequest renders the specified template for the given request.
+// The request is available to the template as .Request, unless data already
+// has a "Request" entry, for the template functions needing it such as formToken.
+// Unlike Render, the rendering honors the request context: when the client goes
+// away the template execution is aborted and the context error is returned.
+// By default the output is buffered and only written once the template has been
+// fully rendered, so an aborted or failed render writes nothing, and minified
+// when ServerConfig.MinifyHTML is set.
+// The Content-Type is derived from the template name extension (see Render).
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request whose context controls the rendering.
+//   - template: The name of the template to render.
+//   - data: The data passed to the template.
+//   - opts: Optional render options.
+//
+// Returns:
+//   - error: An error if the rendering failed or was cancelled.
+func (s *Server) RenderRequest(w http.ResponseWriter, r *http.Request, template string, data map[string]interface{}, opts ...RenderOption) error {
+	var options renderOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	slog.Info("Rendering template", "template", template)
+	recordTemplate(w, template)
+	if _, ok := data["Request"]; !ok {
+		// Copied, the map of the caller may be shared between requests.
+		withRequest := make(map[string]interface{}, len(data)+1)
+		for key, value := range data {
+			withRequest[key] = value
+		}
+		withRequest["Request"] = r
+		data = withRequest
+	}
+	options.setContentType(w, template)
+	if options.streaming {
+		return options.execute(r.Context(), s.t, w, template, data)
+	}
+	var buf bytes.Buffer
+	if err := options.execute(r.Context(), s.t, &buf, template, data); err != nil {
+		return err
+	}
+	s.minifyRendered(&buf, template, options.contentTypeOf(w, template))
+	_, err := buf.WriteTo(w)
+	return err
+}
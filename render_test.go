@@ -0,0 +1,243 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Morditux/serverlib/templates"
+)
+
+// newTemplateServer returns a server with the templates of files and funcs,
+// parsed like Start would.
+func newTemplateServer(t *testing.T, files map[string]string, funcs template.FuncMap) *Server {
+	t.Helper()
+	s := NewServer(ServerConfig{})
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	s.AddTemplateFS(fsys, "*")
+	s.Templates().AddFuncs(funcs)
+	if err := s.Templates().Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRenderRequest(t *testing.T) {
+	s := newTemplateServer(t, map[string]string{
+		"page.html":   "<p>{{.Name}}</p>",
+		"notes.txt":   "{{.Name}}",
+		"request.txt": "{{.Request.URL.Path}}",
+		"broken.html": "before{{.Name.Missing}}",
+	}, nil)
+	tests := []struct {
+		name            string
+		template        string
+		data            map[string]any
+		opts            []RenderOption
+		contentType     string
+		wantBody        string
+		wantContentType string
+		wantErr         bool
+	}{
+		{"html", "page.html", map[string]any{"Name": "a"}, nil, "", "<p>a</p>", "text/html; charset=utf-8", false},
+		{"txt", "notes.txt", map[string]any{"Name": "a"}, nil, "", "a", "text/plain; charset=utf-8", false},
+		{"WithContentType", "notes.txt", map[string]any{"Name": "a"}, []RenderOption{WithContentType("text/markdown")}, "", "a", "text/markdown", false},
+		{"Content-Type of the handler", "notes.txt", map[string]any{"Name": "a"}, []RenderOption{WithContentType("text/markdown")}, "text/csv", "a", "text/csv", false},
+		{"request", "request.txt", nil, nil, "", "/path", "text/plain; charset=utf-8", false},
+		{"request of the data", "request.txt", map[string]any{"Request": httptest.NewRequest(http.MethodGet, "/data", nil)}, nil, "", "/data", "text/plain; charset=utf-8", false},
+		{"buffered error", "broken.html", map[string]any{"Name": "a"}, nil, "", "", "text/html; charset=utf-8", true},
+		{"streamed error", "broken.html", map[string]any{"Name": "a"}, []RenderOption{Streaming()}, "", "before", "text/html; charset=utf-8", true},
+		{"unknown template", "missing.html", nil, nil, "", "", "text/html; charset=utf-8", true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if tt.contentType != "" {
+			w.Header().Set("Content-Type", tt.contentType)
+		}
+		err := s.RenderRequest(w, httptest.NewRequest(http.MethodGet, "/path", nil), tt.template, tt.data, tt.opts...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want an error %v", tt.name, err, tt.wantErr)
+		}
+		if got := w.Body.String(); got != tt.wantBody {
+			t.Errorf("%s: body %q, want %q", tt.name, got, tt.wantBody)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.name, got, tt.wantContentType)
+		}
+	}
+}
+
+// TestRenderRequestCancelled cancels the request from a slow template
+// function: the render stops at the next check of the context, well before
+// the end of the template, and the buffered render writes nothing.
+func TestRenderRequestCancelled(t *testing.T) {
+	const items, cancelAt = 100, 3
+	tests := []struct {
+		name     string
+		interval int
+		chunk    int
+		opts     []RenderOption
+		wantBody bool
+	}{
+		{"every write", 0, 10, nil, false},
+		{"default interval", -1, 5000, nil, false},
+		{"streamed", 0, 10, []RenderOption{Streaming()}, true},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		s := newTemplateServer(t, map[string]string{
+			"slow.html": "{{range .Items}}{{slow}}{{end}}",
+		}, template.FuncMap{"slow": func() string {
+			calls++
+			if calls == cancelAt {
+				cancel()
+			}
+			return strings.Repeat("x", tt.chunk)
+		}})
+		if tt.interval >= 0 {
+			s.Templates().SetCheckInterval(tt.interval)
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		err := s.RenderRequest(w, r, "slow.html", map[string]any{"Items": make([]int, items)}, tt.opts...)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: error %v, want context.Canceled", tt.name, err)
+		}
+		if calls > cancelAt+1 {
+			t.Errorf("%s: %d of the %d items rendered after the cancellation at %d", tt.name, calls, items, cancelAt)
+		}
+		if got := w.Body.Len() > 0; got != tt.wantBody {
+			t.Errorf("%s: %d bytes written, want output %v", tt.name, w.Body.Len(), tt.wantBody)
+		}
+	}
+}
+
+func TestRenderRequestCancelledBefore(t *testing.T) {
+	s := newTemplateServer(t, map[string]string{"page.html": "page"}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	err := s.RenderRequest(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx), "page.html", nil)
+	if !errors.Is(err, context.Canceled) || w.Body.Len() != 0 {
+		t.Errorf("error %v, %d bytes written, want context.Canceled and nothing", err, w.Body.Len())
+	}
+}
+
+func TestRenderContentType(t *testing.T) {
+	files := map[string]string{}
+	for _, name := range []string{"page.html", "page.htm", "sitemap.xml", "icon.svg", "snippet.js", "style.css", "notes.txt", "data.json", "NOTES.TXT", "page.tmpl"} {
+		files[name] = "content"
+	}
+	s := newTemplateServer(t, files, nil)
+	tests := []struct {
+		template    string
+		opts        []RenderOption
+		contentType string
+		want        string
+	}{
+		{"page.html", nil, "", "text/html; charset=utf-8"},
+		{"page.htm", nil, "", "text/html; charset=utf-8"},
+		{"sitemap.xml", nil, "", "application/xml; charset=utf-8"},
+		{"icon.svg", nil, "", "image/svg+xml; charset=utf-8"},
+		{"snippet.js", nil, "", "text/javascript; charset=utf-8"},
+		{"style.css", nil, "", "text/css; charset=utf-8"},
+		{"notes.txt", nil, "", "text/plain; charset=utf-8"},
+		{"data.json", nil, "", "application/json; charset=utf-8"},
+		{"NOTES.TXT", nil, "", "text/plain; charset=utf-8"},
+		{"page.tmpl", nil, "", "text/html; charset=utf-8"},
+		{"page.tmpl", []RenderOption{WithContentType("text/calendar")}, "", "text/calendar"},
+		{"sitemap.xml", nil, "application/rss+xml", "application/rss+xml"},
+		{"sitemap.xml", []RenderOption{WithContentType("text/xml")}, "application/rss+xml", "application/rss+xml"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if tt.contentType != "" {
+			w.Header().Set("Content-Type", tt.contentType)
+		}
+		s.Render(w, tt.template, nil, tt.opts...)
+		if got := w.Header().Get("Content-Type"); got != tt.want || w.Body.String() != "content" {
+			t.Errorf("Render(%q) with the Content-Type %q: %q %q, want %q", tt.template, tt.contentType, got, w.Body.String(), tt.want)
+		}
+	}
+}
+
+// TestRenderMissingKeys checks the profiles select the missing key mode: a
+// MissingKeyError in Development, an empty value in Production.
+func TestRenderMissingKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ServerConfig
+		want    string
+		wantErr bool
+	}{
+		{"development", ServerConfig{Profile: Development}, "", true},
+		{"production", ServerConfig{Profile: Production}, "<h1></h1>", false},
+		{"warned in production", ServerConfig{Profile: Production, MissingKeys: templates.MissingKeysWarn}, "<h1></h1>", false},
+		{"strict in production", ServerConfig{Profile: Production, MissingKeys: templates.MissingKeysError}, "", true},
+	}
+	for _, tt := range tests {
+		s := NewServer(tt.config)
+		s.AddTemplateFS(fstest.MapFS{"page.html": {Data: []byte("<h1>{{.Title}}</h1>")}}, "*")
+		if err := s.Templates().Parse(); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		err := s.RenderRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "page.html", map[string]interface{}{})
+		var missing *templates.MissingKeyError
+		if errors.As(err, &missing) != tt.wantErr || w.Body.String() != tt.want {
+			t.Errorf("%s: %q %v, want %q", tt.name, w.Body.String(), err, tt.want)
+		}
+		if tt.wantErr && missing.Key != "Title" {
+			t.Errorf("%s: missing key %q, want Title", tt.name, missing.Key)
+		}
+	}
+}
+
+// tenantOverrides overrides page.html for the tenant acme.
+type tenantOverrides struct{}
+
+func (tenantOverrides) Lookup(_ context.Context, tenant, name string) (string, string, bool, error) {
+	if tenant == "acme" && name == "page.html" {
+		return "<p>acme {{.Name}}</p>", "1", true, nil
+	}
+	return "", "", false, nil
+}
+
+func TestRenderTenant(t *testing.T) {
+	s := NewServer(ServerConfig{TemplateOverrides: tenantOverrides{}})
+	s.AddTemplateFS(fstest.MapFS{"page.html": {Data: []byte("<p>{{.Name}}</p>")}}, "*")
+	if err := s.Templates().Parse(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts []RenderOption
+		want string
+	}{
+		{"no tenant", nil, "<p>a</p>"},
+		{"tenant with an override", []RenderOption{ForTenant("acme")}, "<p>acme a</p>"},
+		{"tenant without override", []RenderOption{ForTenant("globex")}, "<p>a</p>"},
+		{"streamed", []RenderOption{ForTenant("acme"), Streaming()}, "<p>acme a</p>"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.Render(w, "page.html", map[string]any{"Name": "a"}, tt.opts...)
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: Render %q, want %q", tt.name, got, tt.want)
+		}
+		w = httptest.NewRecorder()
+		if err := s.RenderRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "page.html", map[string]any{"Name": "a"}, tt.opts...); err != nil || w.Body.String() != tt.want {
+			t.Errorf("%s: RenderRequest %q %v, want %q", tt.name, w.Body.String(), err, tt.want)
+		}
+	}
+}
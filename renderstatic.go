@@ -0,0 +1,191 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/Morditux/serverlib/units"
+)
+
+// StaticPage is a page pre-rendered by RenderStatic.
+type StaticPage struct {
+	// Path is the output path of the page relative to the output directory.
+	// Route paths without an extension, e.g. "/about" or "/", are written to
+	// their index.html file.
+	Path string
+	// Template is the name of the template to render.
+	Template string
+	// Data is the data passed to the template.
+	Data map[string]interface{}
+	// DataFunc, if not nil, provides the data instead of Data when the page is
+	// rendered, e.g. from the data providers of the live handlers.
+	DataFunc func(ctx context.Context) (map[string]interface{}, error)
+	// Options are the render options of the page, e.g. ForTenant.
+	Options []RenderOption
+}
+
+// StaticFailure is a page RenderStatic failed to render or write.
+type StaticFailure struct {
+	Path string
+	Err  error
+}
+
+// StaticSummary describes the outcome of RenderStatic.
+type StaticSummary struct {
+	Pages    int
+	Bytes    int64
+	Failures []StaticFailure
+}
+
+// StaticRenderError is returned by RenderStatic when pages failed, listed in
+// the order of the pages. The other pages are rendered regardless.
+type StaticRenderError struct {
+	Summary StaticSummary
+}
+
+// Error implements the error interface.
+func (e *StaticRenderError) Error() string {
+	messages := make([]string, len(e.Summary.Failures))
+	for i, failure := range e.Summary.Failures {
+		messages[i] = failure.Path + ": " + failure.Err.Error()
+	}
+	return fmt.Sprintf("static rendering: %d of %d pages failed: %s", len(e.Summary.Failures), len(e.Summary.Failures)+e.Summary.Pages, strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the failed pages.
+func (e *StaticRenderError) Unwrap() []error {
+	errs := make([]error, len(e.Summary.Failures))
+	for i, failure := range e.Summary.Failures {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// RenderStatic pre-renders pages to files with the templates of the live
+// server, e.g. at deploy time. The pages are rendered like RenderRequest does
+// but without a request: no session, no .Request and so no form tokens. Each
+// page is written once fully rendered, the missing directories are created.
+// The pages are rendered by a pool of GOMAXPROCS workers; the summary is logged.
+// The templates must have been parsed (see Server.Start or Templates().Parse).
+//
+// Parameters:
+//   - ctx: The context cancelling the rendering.
+//   - outDir: The directory the pages are written to.
+//   - pages: The pages to render.
+//
+// Returns:
+//   - error: A *StaticRenderError listing the failed pages, nil if every page was written.
+func (s *Server) RenderStatic(ctx context.Context, outDir string, pages []StaticPage) error {
+	var summary StaticSummary
+	var mut sync.Mutex
+	// The errors by page, for the failures to be listed in the order of the
+	// pages whatever the worker rendering them.
+	errs := make([]error, len(pages))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), max(len(pages), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				written, err := s.renderStaticPage(ctx, outDir, pages[i])
+				mut.Lock()
+				if err != nil {
+					errs[i] = err
+				} else {
+					summary.Pages++
+					summary.Bytes += written
+				}
+				mut.Unlock()
+			}
+		}()
+	}
+	for i := range pages {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			summary.Failures = append(summary.Failures, StaticFailure{Path: pages[i].Path, Err: err})
+		}
+	}
+	slog.Info("Static pages rendered", "pages", summary.Pages, "size", units.FormatBytes(summary.Bytes), "failures", len(summary.Failures))
+	if len(summary.Failures) > 0 {
+		return &StaticRenderError{Summary: summary}
+	}
+	return nil
+}
+
+// renderStaticPage renders a page and writes it, returning its size.
+func (s *Server) renderStaticPage(ctx context.Context, outDir string, page StaticPage) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	file, err := staticFile(page.Path)
+	if err != nil {
+		return 0, err
+	}
+	data := page.Data
+	if page.DataFunc != nil {
+		if data, err = page.DataFunc(ctx); err != nil {
+			return 0, err
+		}
+	}
+	var options renderOptions
+	for _, opt := range page.Options {
+		opt(&options)
+	}
+	var buf bytes.Buffer
+	if err := options.execute(ctx, s.t, &buf, page.Template, data); err != nil {
+		return 0, err
+	}
+	s.minifyRendered(&buf, page.Template, options.contentTypeOf(nil, page.Template))
+	target := filepath.Join(outDir, file)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return 0, err
+	}
+	// Written to a temporary file first, so that a failed write doesn't leave
+	// a truncated page behind.
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".static-*")
+	if err != nil {
+		return 0, err
+	}
+	size, err := buf.WriteTo(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return size, nil
+}
+
+// staticFile returns the file of a page path relative to the output directory.
+func staticFile(pagePath string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+pagePath), "/")
+	if cleaned == "" || strings.HasSuffix(pagePath, "/") || path.Ext(cleaned) == "" {
+		cleaned = path.Join(cleaned, "index.html")
+	}
+	file := filepath.FromSlash(cleaned)
+	if !filepath.IsLocal(file) {
+		return "", errors.New("invalid static page path " + pagePath)
+	}
+	return file, nil
+}
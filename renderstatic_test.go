@@ -0,0 +1,170 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Morditux/serverlib/templates"
+)
+
+// staticServer returns a server with the templates of the static pages.
+func staticServer(t *testing.T) *Server {
+	t.Helper()
+	return newTemplateServer(t, map[string]string{
+		"home.html":   "<h1>{{.Title}}</h1>",
+		"post.html":   "<article>{{.Title}}: {{.Body}}</article>",
+		"broken.html": "{{.Title.Missing}}",
+		"feed.xml":    "<feed>{{.Title}}</feed>",
+	}, nil)
+}
+
+// TestRenderStatic renders three pages, and checks their files and the
+// summary logged.
+func TestRenderStatic(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	s := staticServer(t)
+	dir := t.TempDir()
+	pages := []StaticPage{
+		{Path: "/", Template: "home.html", Data: map[string]interface{}{"Title": "Home"}},
+		{Path: "/blog/first", Template: "post.html", DataFunc: func(ctx context.Context) (map[string]interface{}, error) {
+			return map[string]interface{}{"Title": "First", "Body": "hello"}, nil
+		}},
+		{Path: "feed.xml", Template: "feed.xml", Data: map[string]interface{}{"Title": "News & more"}},
+	}
+	if err := s.RenderStatic(context.Background(), dir, pages); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"index.html", "<h1>Home</h1>"},
+		{"blog/first/index.html", "<article>First: hello</article>"},
+		{"feed.xml", "<feed>News &amp; more</feed>"},
+	}
+	size := 0
+	for _, tt := range tests {
+		content, err := os.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil || string(content) != tt.want {
+			t.Errorf("%s: %q %v, want %q", tt.file, content, err, tt.want)
+		}
+		size += len(content)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*", ".static-*")); len(leftovers) != 0 {
+		t.Errorf("temporary files left: %v", leftovers)
+	}
+	if !strings.Contains(logs.String(), "pages=3") || !strings.Contains(logs.String(), "failures=0") {
+		t.Errorf("summary %q, %d bytes", logs.String(), size)
+	}
+}
+
+// TestRenderStaticFailures checks the failed pages are reported together, in
+// the order of the pages, while the other pages are written.
+func TestRenderStaticFailures(t *testing.T) {
+	s := staticServer(t)
+	dir := t.TempDir()
+	unavailable := errors.New("data unavailable")
+	pages := []StaticPage{
+		{Path: "/ok", Template: "home.html", Data: map[string]interface{}{"Title": "OK"}},
+		{Path: "/broken", Template: "broken.html", Data: map[string]interface{}{"Title": "Broken"}},
+		{Path: "/unknown", Template: "missing.html"},
+		{Path: "/data", Template: "post.html", DataFunc: func(ctx context.Context) (map[string]interface{}, error) {
+			return nil, unavailable
+		}},
+		{Path: "/../../contained", Template: "home.html", Data: map[string]interface{}{"Title": "In"}},
+		{Path: "/also-ok", Template: "home.html", Data: map[string]interface{}{"Title": "Also"}},
+	}
+	err := s.RenderStatic(context.Background(), dir, pages)
+	var renderErr *StaticRenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("error %v, want a *StaticRenderError", err)
+	}
+	var failed []string
+	for _, failure := range renderErr.Summary.Failures {
+		failed = append(failed, failure.Path)
+	}
+	if got := strings.Join(failed, " "); got != "/broken /unknown /data" {
+		t.Errorf("failed pages %s", got)
+	}
+	if renderErr.Summary.Pages != 3 || renderErr.Summary.Bytes != int64(len("<h1>OK</h1><h1>In</h1><h1>Also</h1>")) {
+		t.Errorf("summary %+v", renderErr.Summary)
+	}
+	if !errors.Is(err, unavailable) || !strings.Contains(err.Error(), "3 of 6 pages failed") || !strings.Contains(err.Error(), "/data: data unavailable") {
+		t.Errorf("error %q", err)
+	}
+	for _, file := range []string{"ok/index.html", "contained/index.html", "also-ok/index.html"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+	for _, file := range []string{"broken", "unknown", "data"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			t.Errorf("%s written for a failed page", file)
+		}
+	}
+}
+
+// TestRenderStaticMissingKeys checks the pages render with the missing key
+// mode of the server, like the live pages.
+func TestRenderStaticMissingKeys(t *testing.T) {
+	s := NewServer(ServerConfig{Profile: Development})
+	s.AddTemplateFS(fstest.MapFS{"home.html": {Data: []byte("<h1>{{.Title}}</h1>")}}, "*")
+	if err := s.Templates().Parse(); err != nil {
+		t.Fatal(err)
+	}
+	err := s.RenderStatic(context.Background(), t.TempDir(), []StaticPage{{Path: "/", Template: "home.html", Data: map[string]interface{}{}}})
+	var missing *templates.MissingKeyError
+	if !errors.As(err, &missing) || missing.Key != "Title" {
+		t.Errorf("error %v, want the missing key Title", err)
+	}
+}
+
+func TestRenderStaticCancelled(t *testing.T) {
+	s := staticServer(t)
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := s.RenderStatic(ctx, dir, []StaticPage{{Path: "/", Template: "home.html"}, {Path: "/b", Template: "home.html"}})
+	var renderErr *StaticRenderError
+	if !errors.As(err, &renderErr) || len(renderErr.Summary.Failures) != 2 || !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want both pages cancelled", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("wrote %v", entries)
+	}
+	if err := s.RenderStatic(context.Background(), dir, nil); err != nil {
+		t.Errorf("no pages: %v", err)
+	}
+}
+
+func TestStaticFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "index.html"},
+		{"", "index.html"},
+		{"/about", "about/index.html"},
+		{"/docs/", "docs/index.html"},
+		{"/docs/guide.html", "docs/guide.html"},
+		{"sitemap.xml", "sitemap.xml"},
+		{"/v1.2/", "v1.2/index.html"},
+		{"/a/../b", "b/index.html"},
+		{"/../../etc/passwd", "etc/passwd/index.html"},
+	}
+	for _, tt := range tests {
+		got, err := staticFile(tt.path)
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("%q: %q %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
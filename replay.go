@@ -0,0 +1,317 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// DefaultCaptureBodyBytes is the size of the request bodies kept in a cassette.
+const DefaultCaptureBodyBytes = 64 << 10 // 64 KiB
+
+// Cassette is a captured request, to be replayed with Replay.
+type Cassette struct {
+	Recorded time.Time   `json:"recorded"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body,omitempty"`
+	// Truncated is set when the body was longer than the capture limit.
+	Truncated bool `json:"truncated,omitempty"`
+	// Session holds the values of the session, when captured.
+	Session map[string]any `json:"session,omitempty"`
+}
+
+// CassetteSink stores the captured cassettes.
+type CassetteSink interface {
+	Save(ctx context.Context, id string, cassette Cassette) error
+}
+
+// DirSink is a CassetteSink writing each cassette to <dir>/<id>.json.
+type DirSink string
+
+// Save implements CassetteSink.
+func (d DirSink) Save(ctx context.Context, id string, cassette Cassette) error {
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(string(d), id+".json"), data, 0o600)
+}
+
+// CaptureOptions configures Server.Capture.
+type CaptureOptions struct {
+	// Sink stores the cassettes.
+	Sink CassetteSink
+	// TriggerHeader restricts the capture to the requests with this header
+	// set to TriggerValue, e.g. a support token. Every request is captured
+	// when it is empty.
+	TriggerHeader string
+	TriggerValue  string
+	// MaxBodyBytes is the size of the bodies kept. Defaults to DefaultCaptureBodyBytes.
+	MaxBodyBytes int64
+	// IncludeHeaders lists the redacted headers to capture anyway, such as Cookie.
+	IncludeHeaders []string
+	// IncludeSession captures the session values, redacting the sensitive ones.
+	IncludeSession bool
+}
+
+// sensitiveHeaders are redacted from the cassettes unless explicitly included.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Auth-Token", "X-Csrf-Token"}
+
+// sensitiveNames are the fragments of the JSON fields and session keys redacted from the cassettes.
+var sensitiveNames = []string{"password", "passwd", "secret", "token", "apikey", "api_key", "authorization", "credit", "card", "cvv", "ssn"}
+
+// isSensitive reports whether a field or key name looks sensitive.
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, fragment := range sensitiveNames {
+		if strings.Contains(name, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Capture returns a middleware recording the requests as cassettes, to
+// replay them locally with Replay. The sensitive data is redacted by default:
+// the authentication headers and cookies, unless listed in IncludeHeaders,
+// the sensitive query parameters, fields of JSON and form bodies, and session
+// values.
+// The triggering header itself is never captured.
+//
+// Parameters:
+//   - options: The capture options.
+//
+// Returns:
+//   - Middleware: The capture middleware.
+func (s *Server) Capture(options CaptureOptions) Middleware {
+	if options.MaxBodyBytes <= 0 {
+		options.MaxBodyBytes = DefaultCaptureBodyBytes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if options.TriggerHeader != "" && (options.TriggerValue == "" || r.Header.Get(options.TriggerHeader) != options.TriggerValue) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cassette, body := capture(r, options)
+			r.Body = body
+			id := reqctx.RequestID(r.Context())
+			if id == "" {
+				id = time.Now().Format("20060102T150405.000000000")
+			}
+			if err := options.Sink.Save(r.Context(), id, cassette); err != nil {
+				s.LogError("Saving cassette", err.Error())
+			} else {
+				s.LogInfo("Request captured", id)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// capture returns the cassette of the request and a body replacing r.Body,
+// since the captured part of the body has been read.
+func capture(r *http.Request, options CaptureOptions) (Cassette, io.ReadCloser) {
+	cassette := Cassette{
+		Recorded: time.Now(),
+		Method:   r.Method,
+		URL:      redactQuery(r.URL).RequestURI(),
+		Header:   r.Header.Clone(),
+	}
+	cassette.Header.Del(options.TriggerHeader)
+	for _, name := range sensitiveHeaders {
+		if !containsFold(options.IncludeHeaders, name) && cassette.Header.Get(name) != "" {
+			cassette.Header.Set(name, redacted)
+		}
+	}
+	body := r.Body
+	if body != nil && body != http.NoBody {
+		captured, _ := io.ReadAll(io.LimitReader(r.Body, options.MaxBodyBytes+1))
+		if int64(len(captured)) > options.MaxBodyBytes {
+			cassette.Truncated = true
+			cassette.Body = captured[:options.MaxBodyBytes]
+		} else {
+			cassette.Body = captured
+		}
+		body = readCloser{io.MultiReader(bytes.NewReader(captured), r.Body), r.Body}
+		contentType := r.Header.Get("Content-Type")
+		form := strings.HasPrefix(contentType, "application/x-www-form-urlencoded")
+		switch {
+		case cassette.Truncated && (form || strings.Contains(contentType, "json")):
+			// A truncated body can't be parsed, hence can't be redacted.
+			cassette.Body = nil
+		case form:
+			cassette.Body = redactForm(cassette.Body)
+		case !cassette.Truncated:
+			cassette.Body = redactJSON(cassette.Body)
+		}
+	}
+	if options.IncludeSession {
+		if snapshotter, ok := reqctx.Session(r.Context()).(sessions.Snapshotter); ok {
+			cassette.Session = map[string]any{}
+			for key, value := range snapshotter.Snapshot() {
+				if isSensitive(key) {
+					value = redacted
+				} else if _, err := json.Marshal(value); err != nil {
+					continue
+				}
+				cassette.Session[key] = value
+			}
+		}
+	}
+	return cassette, body
+}
+
+// readCloser reads from a reader and closes a closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactQuery returns u with the sensitive query parameters redacted, u
+// itself when there are none.
+func redactQuery(u *url.URL) *url.URL {
+	query := u.Query()
+	found := false
+	for name := range query {
+		if isSensitive(name) {
+			query[name] = []string{redacted}
+			found = true
+		}
+	}
+	if !found {
+		return u
+	}
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+	return &redactedURL
+}
+
+// redactForm redacts the sensitive fields of a form body, an invalid body is
+// dropped.
+func redactForm(body []byte) []byte {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+	for name := range form {
+		if isSensitive(name) {
+			form[name] = []string{redacted}
+		}
+	}
+	return []byte(form.Encode())
+}
+
+// redactJSON redacts the sensitive fields of a JSON body, other bodies are returned as is.
+func redactJSON(body []byte) []byte {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return body
+	}
+	out, err := json.Marshal(redactJSONValue(value))
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func redactJSONValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if isSensitive(key) {
+				value[key] = redacted
+			} else {
+				value[key] = redactJSONValue(field)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactJSONValue(item)
+		}
+	}
+	return value
+}
+
+// TestResult is the response of a replayed request.
+type TestResult struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Templates are the names of the templates rendered, in order.
+	Templates []string
+	// Cookies are the cookies written by the library and their sources.
+	Cookies []CookieWrite
+}
+
+// Replay runs a captured request through the full handler stack of the
+// server, without listening. When the cassette has session values, the
+// request gets a new session holding them; redacted values are kept redacted.
+//
+// Parameters:
+//   - srv: The server to replay the request against, usually a dev server.
+//   - cassettePath: The path of the cassette file.
+//
+// Returns:
+//   - *TestResult: The response.
+//   - error: An error if the cassette could not be read.
+func Replay(srv *Server, cassettePath string) (*TestResult, error) {
+	data, err := os.ReadFile(cassettePath)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("reading cassette %s: %w", cassettePath, err)
+	}
+	r, err := http.NewRequest(cassette.Method, cassette.URL, bytes.NewReader(cassette.Body))
+	if err != nil {
+		return nil, fmt.Errorf("reading cassette %s: %w", cassettePath, err)
+	}
+	r.RequestURI = cassette.URL
+	r.RemoteAddr = "127.0.0.1:0"
+	r.Header = cassette.Header
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	if cassette.Session != nil {
+		session := srv.sessionManager.New()
+		for key, value := range cassette.Session {
+			session.Set(key, value)
+		}
+		srv.sessionManager.Set(session.Id(), session)
+		r.Header.Del("Cookie")
+		r.AddCookie(&http.Cookie{Name: srv.sessionKey, Value: encodeSessionCookie(session.Id(), srv.cookie.Version)})
+	}
+	recorder := NewResponseRecorder()
+	srv.injector.ServeHTTP(recorder, r)
+	return &TestResult{
+		Status:    recorder.Code,
+		Header:    recorder.Header(),
+		Body:      recorder.Body.Bytes(),
+		Templates: recorder.Templates(),
+		Cookies:   recorder.CookieWrites(),
+	}, nil
+}
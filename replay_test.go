@@ -0,0 +1,242 @@
+package serverlib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memorySink keeps the cassettes in memory.
+type memorySink struct {
+	mut       sync.Mutex
+	cassettes []Cassette
+}
+
+func (m *memorySink) Save(_ context.Context, _ string, cassette Cassette) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.cassettes = append(m.cassettes, cassette)
+	return nil
+}
+
+// captured serves the request through a capturing route, returning the
+// cassettes and the body read by the handler.
+func captured(t *testing.T, options CaptureOptions, r *http.Request) ([]Cassette, string) {
+	t.Helper()
+	sink := &memorySink{}
+	options.Sink = sink
+	s := NewServer(ServerConfig{})
+	var read string
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		read = string(body)
+	}, s.Capture(options))
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	return sink.cassettes, read
+}
+
+func TestCaptureRedaction(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	tests := []struct {
+		name        string
+		options     CaptureOptions
+		method      string
+		target      string
+		contentType string
+		body        string
+		header      http.Header
+		wantURL     string
+		wantBody    string
+		wantTrunc   bool
+		wantHeader  http.Header
+	}{
+		{"headers", CaptureOptions{}, http.MethodGet, "/", "", "", http.Header{
+			"Authorization": {"Bearer abc"}, "Cookie": {"a=b"}, "X-Api-Key": {"key"}, "Accept": {"text/html"},
+		}, "/", "", false, http.Header{
+			"Authorization": {redacted}, "Cookie": {redacted}, "X-Api-Key": {redacted}, "Accept": {"text/html"},
+		}},
+		{"included header", CaptureOptions{IncludeHeaders: []string{"cookie"}}, http.MethodGet, "/", "", "", http.Header{
+			"Authorization": {"Bearer abc"}, "Cookie": {"a=b"},
+		}, "/", "", false, http.Header{"Authorization": {redacted}, "Cookie": {"a=b"}}},
+		{"trigger header", CaptureOptions{TriggerHeader: "X-Debug-Capture", TriggerValue: "support"}, http.MethodGet, "/", "", "", http.Header{
+			"X-Debug-Capture": {"support"}, "Accept": {"*/*"},
+		}, "/", "", false, http.Header{"Accept": {"*/*"}}},
+		{"query", CaptureOptions{}, http.MethodGet, "/search?q=shoes&access_token=abc&page=2", "", "", nil,
+			"/search?access_token=%5BREDACTED%5D&page=2&q=shoes", "", false, http.Header{}},
+		{"query without sensitive parameter", CaptureOptions{}, http.MethodGet, "/search?q=shoes&page=2", "", "", nil,
+			"/search?q=shoes&page=2", "", false, http.Header{}},
+		{"JSON body", CaptureOptions{}, http.MethodPost, "/", "application/json", `{"user":"alice","password":"hunter2","card":{"number":"4242"},"items":[{"api_key":"k","id":1}]}`, nil,
+			"/", `{"card":"[REDACTED]","items":[{"api_key":"[REDACTED]","id":1}],"password":"[REDACTED]","user":"alice"}`, false, nil},
+		{"form body", CaptureOptions{}, http.MethodPost, "/", "application/x-www-form-urlencoded", "user=alice&password=hunter2&csrf_token=t", nil,
+			"/", "csrf_token=%5BREDACTED%5D&password=%5BREDACTED%5D&user=alice", false, nil},
+		{"text body", CaptureOptions{}, http.MethodPost, "/", "text/plain", "password=hunter2", nil,
+			"/", "password=hunter2", false, nil},
+		{"truncated text", CaptureOptions{MaxBodyBytes: 8}, http.MethodPost, "/", "text/plain", long, nil,
+			"/", long[:8], true, nil},
+		{"truncated JSON", CaptureOptions{MaxBodyBytes: 8}, http.MethodPost, "/", "application/json", `{"password":"hunter2"}`, nil,
+			"/", "", true, nil},
+		{"truncated form", CaptureOptions{MaxBodyBytes: 8}, http.MethodPost, "/", "application/x-www-form-urlencoded", "password=hunter2", nil,
+			"/", "", true, nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		cassettes, read := captured(t, tt.options, r)
+		if len(cassettes) != 1 {
+			t.Errorf("%s: %d cassettes, want 1", tt.name, len(cassettes))
+			continue
+		}
+		if read != tt.body {
+			t.Errorf("%s: the handler read %q, want %q", tt.name, read, tt.body)
+		}
+		cassette := cassettes[0]
+		if cassette.Method != tt.method || cassette.URL != tt.wantURL || string(cassette.Body) != tt.wantBody || cassette.Truncated != tt.wantTrunc {
+			t.Errorf("%s: %s %s body %q truncated %v, want %s %s body %q truncated %v", tt.name, cassette.Method, cassette.URL, cassette.Body, cassette.Truncated, tt.method, tt.wantURL, tt.wantBody, tt.wantTrunc)
+		}
+		if tt.wantHeader != nil {
+			header := cassette.Header.Clone()
+			header.Del("Content-Type")
+			if !reflect.DeepEqual(header, tt.wantHeader) {
+				t.Errorf("%s: header %v, want %v", tt.name, header, tt.wantHeader)
+			}
+		}
+	}
+}
+
+func TestCaptureTrigger(t *testing.T) {
+	tests := []struct {
+		name    string
+		options CaptureOptions
+		header  string
+		want    int
+	}{
+		{"no trigger", CaptureOptions{}, "", 1},
+		{"triggered", CaptureOptions{TriggerHeader: "X-Debug-Capture", TriggerValue: "support"}, "support", 1},
+		{"wrong value", CaptureOptions{TriggerHeader: "X-Debug-Capture", TriggerValue: "support"}, "guess", 0},
+		{"no header", CaptureOptions{TriggerHeader: "X-Debug-Capture", TriggerValue: "support"}, "", 0},
+		{"no trigger value", CaptureOptions{TriggerHeader: "X-Debug-Capture"}, "", 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Debug-Capture", tt.header)
+		}
+		if cassettes, _ := captured(t, tt.options, r); len(cassettes) != tt.want {
+			t.Errorf("%s: %d cassettes, want %d", tt.name, len(cassettes), tt.want)
+		}
+	}
+}
+
+// replayServer returns a server rendering the orders of the signed in user.
+func replayServer(t *testing.T, sink CassetteSink) *Server {
+	t.Helper()
+	s := newTemplateServer(t, map[string]string{
+		"order.html":  "<p>{{.User}} ordered {{.Item}}</p>",
+		"signin.html": "<p>sign in</p>",
+	}, nil)
+	s.GET("/login", func(w http.ResponseWriter, r *http.Request) {
+		session, _ := s.GetSession(w, r)
+		session.Set("user", "alice")
+		session.Set("refresh_token", "r-123")
+	})
+	capture := s.Capture(CaptureOptions{Sink: sink, IncludeSession: true})
+	s.POST("/orders", func(w http.ResponseWriter, r *http.Request) {
+		session, _ := s.GetSession(w, r)
+		user, _ := session.Get("user").(string)
+		if user == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			s.Render(w, "signin.html", nil)
+			return
+		}
+		r.ParseForm()
+		w.WriteHeader(http.StatusCreated)
+		s.Render(w, "order.html", map[string]any{"User": user, "Item": r.PostForm.Get("item")})
+	}, capture)
+	return s
+}
+
+// TestReplay records requests and replays their cassettes: the replay gets
+// the status, the templates and the body of the recorded response.
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		signedIn   bool
+		wantStatus int
+		wantBody   string
+	}{
+		{"signed in", true, http.StatusCreated, "<p>alice ordered book</p>"},
+		{"anonymous", false, http.StatusUnauthorized, "<p>sign in</p>"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		s := replayServer(t, DirSink(dir))
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("item=book&password=hunter2"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.signedIn {
+			login := httptest.NewRecorder()
+			s.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/login", nil))
+			r.AddCookie(login.Result().Cookies()[0])
+		}
+		recorded := NewResponseRecorder()
+		s.ServeHTTP(recorded, r)
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		if len(files) != 1 {
+			t.Fatalf("%s: cassettes %v, want one", tt.name, files)
+		}
+		data, _ := os.ReadFile(files[0])
+		for _, secret := range []string{"hunter2", "r-123"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s: the cassette holds %q: %s", tt.name, secret, data)
+			}
+		}
+
+		// Replayed against a fresh server, as a dev server would.
+		result, err := Replay(replayServer(t, &memorySink{}), files[0])
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if result.Status != recorded.Code || result.Status != tt.wantStatus {
+			t.Errorf("%s: replayed %d, recorded %d, want %d", tt.name, result.Status, recorded.Code, tt.wantStatus)
+		}
+		if !slices.Equal(result.Templates, recorded.Templates()) || len(result.Templates) != 1 {
+			t.Errorf("%s: replayed the templates %v, recorded %v", tt.name, result.Templates, recorded.Templates())
+		}
+		if string(result.Body) != recorded.Body.String() || string(result.Body) != tt.wantBody {
+			t.Errorf("%s: replayed %q, recorded %q, want %q", tt.name, result.Body, recorded.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte("{"), 0o600)
+	badURL := filepath.Join(dir, "url.json")
+	os.WriteFile(badURL, []byte(`{"method":"GET","url":"%zz"}`), 0o600)
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing", filepath.Join(dir, "missing.json")},
+		{"invalid JSON", invalid},
+		{"invalid URL", badURL},
+	}
+	for _, tt := range tests {
+		if result, err := Replay(NewServer(ServerConfig{}), tt.path); err == nil {
+			t.Errorf("%s: %+v, want an error", tt.name, result)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package reqctx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+)
+
+type (
+	traceParentKey struct{}
+	correlationKey struct{}
+)
+
+// WithTraceParent returns a copy of ctx holding the W3C trace context of the
+// request, the value of its traceparent header.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParent returns the W3C trace context of the request, or an empty string.
+func TraceParent(ctx context.Context) string {
+	traceParent, _ := ctx.Value(traceParentKey{}).(string)
+	return traceParent
+}
+
+// Correlation is the bundle of values relating the work done on behalf of a
+// request, such as its background tasks, to the request. The session ID is
+// hashed: the bundle ends up in logs.
+type Correlation struct {
+	RequestID   string
+	SessionHash string
+	Principal   string
+	TraceParent string
+}
+
+// CorrelationOf returns the correlation bundle of ctx: the one kept by Detach,
+// or the one of the request.
+func CorrelationOf(ctx context.Context) Correlation {
+	if correlation, ok := ctx.Value(correlationKey{}).(Correlation); ok {
+		return correlation
+	}
+	correlation := Correlation{
+		RequestID:   RequestID(ctx),
+		TraceParent: TraceParent(ctx),
+	}
+	correlation.Principal, _ = Principal(ctx)
+	if session := Session(ctx); session != nil {
+		sum := sha256.Sum256([]byte(session.Id()))
+		correlation.SessionHash = hex.EncodeToString(sum[:8])
+	}
+	return correlation
+}
+
+// Attrs returns the non-empty values of the bundle as slog attributes.
+func (c Correlation) Attrs() []any {
+	var attrs []any
+	add := func(name, value string) {
+		if value != "" {
+			attrs = append(attrs, slog.String(name, value))
+		}
+	}
+	add("request_id", c.RequestID)
+	add("session_hash", c.SessionHash)
+	add("principal", c.Principal)
+	add("traceparent", c.TraceParent)
+	return attrs
+}
+
+// Detach returns a copy of ctx for the work outliving the request, such as a
+// background task: the values of the request are kept (see Retain) but not
+// its cancellation nor its deadline. The logger of the returned context
+// carries the correlation bundle of the request, see CorrelationOf.
+func Detach(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(correlationKey{}).(Correlation); ok {
+		// Already detached, the logger carries the bundle.
+		return context.WithoutCancel(ctx)
+	}
+	correlation := CorrelationOf(ctx)
+	// The request logger already has the request ID.
+	logger := Logger(ctx).With(Correlation{
+		SessionHash: correlation.SessionHash,
+		Principal:   correlation.Principal,
+		TraceParent: correlation.TraceParent,
+	}.Attrs()...)
+	ctx = context.WithoutCancel(Retain(ctx))
+	ctx = context.WithValue(ctx, correlationKey{}, correlation)
+	return WithLogger(ctx, logger)
+}
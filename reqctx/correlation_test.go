@@ -0,0 +1,82 @@
+package reqctx
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestCorrelationOf(t *testing.T) {
+	request := WithRequestID(context.Background(), "request-1")
+	request = WithPrincipal(request, "alice")
+	request = WithSession(request, sessions.NewMemorySession("session-1"))
+	request = WithTraceParent(request, traceParent)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want Correlation
+	}{
+		{"empty", context.Background(), Correlation{}},
+		{"request ID only", WithRequestID(context.Background(), "request-1"), Correlation{RequestID: "request-1"}},
+		{"every value", request, Correlation{RequestID: "request-1", SessionHash: "84097828fc31a8c8", Principal: "alice", TraceParent: traceParent}},
+		// The bundle of the request is kept, whatever the values set later.
+		{"detached", WithRequestID(Detach(request), "request-2"), Correlation{RequestID: "request-1", SessionHash: "84097828fc31a8c8", Principal: "alice", TraceParent: traceParent}},
+	}
+	for _, tt := range tests {
+		if got := CorrelationOf(tt.ctx); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if strings.Contains(CorrelationOf(request).SessionHash, "session-1") {
+		t.Error("the session ID is not hashed")
+	}
+}
+
+// TestDetach checks a detached context keeps the values of the request but
+// neither its cancellation nor its deadline, and logs the correlation once.
+func TestDetach(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	request, cancel := context.WithTimeout(context.Background(), time.Hour)
+	request = WithRequestID(request, "request-1")
+	request = WithPrincipal(request, "alice")
+	request = WithTraceParent(request, traceParent)
+	detached := Detach(request)
+	cancel()
+	if err := detached.Err(); err != nil {
+		t.Errorf("cancelled with the request: %v", err)
+	}
+	if _, ok := detached.Deadline(); ok {
+		t.Error("the deadline of the request is kept")
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"detached", detached},
+		{"detached twice", Detach(detached)},
+	}
+	for _, tt := range tests {
+		if principal, _ := Principal(tt.ctx); RequestID(tt.ctx) != "request-1" || principal != "alice" {
+			t.Errorf("%s: values %q %q", tt.name, RequestID(tt.ctx), principal)
+		}
+		logs.Reset()
+		Logger(tt.ctx).Info("job ran")
+		for _, attr := range []string{"request_id=request-1", "principal=alice", "traceparent=" + traceParent} {
+			if n := strings.Count(logs.String(), attr); n != 1 {
+				t.Errorf("%s: %q logged %d times in %q", tt.name, attr, n, logs.String())
+			}
+		}
+		if strings.Contains(logs.String(), "session_hash") {
+			t.Errorf("%s: logged %q, no session", tt.name, logs.String())
+		}
+	}
+}
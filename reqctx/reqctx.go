@@ -0,0 +1,241 @@
+// Package reqctx holds the values serverlib stores in the request context.
+// The keys are unexported types, so they can't collide with the keys of the
+// application; read the values with the accessors, and set them with the With
+// functions when writing middlewares or tests.
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+type (
+	requestIDKey struct{}
+	sessionKey   struct{}
+	principalKey struct{}
+	rolesKey     struct{}
+	localeKey    struct{}
+	routeKey     struct{}
+	loggerKey    struct{}
+	clientIPKey  struct{}
+	nonceKey     struct{}
+	flagsKey     struct{}
+	variantsKey  struct{}
+)
+
+// Redacted replaces the sensitive values in Snapshot.
+const Redacted = "[REDACTED]"
+
+// WithRequestID returns a copy of ctx holding the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithSession returns a copy of ctx holding the session.
+func WithSession(ctx context.Context, session sessions.Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// Session returns the session of the request, or nil.
+func Session(ctx context.Context) sessions.Session {
+	session, _ := ctx.Value(sessionKey{}).(sessions.Session)
+	return session
+}
+
+// WithPrincipal returns a copy of ctx holding the authenticated principal.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns the authenticated principal of the request, e.g. from
+// its client certificate. ok is false when the request is not authenticated.
+func Principal(ctx context.Context) (principal string, ok bool) {
+	principal, ok = ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
+
+// WithRoles returns a copy of ctx holding the roles of the principal.
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, roles)
+}
+
+// Roles returns the roles of the authenticated principal of the request.
+func Roles(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey{}).([]string)
+	return roles
+}
+
+// WithLocale returns a copy of ctx holding the locale of the request.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// Locale returns the locale of the request, or an empty string.
+func Locale(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
+
+// WithRoute returns a copy of ctx holding the pattern of the matched route.
+func WithRoute(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, routeKey{}, pattern)
+}
+
+// Route returns the pattern of the route matching the request, e.g.
+// "GET /users/{id}", or an empty string when no route matched.
+func Route(ctx context.Context) string {
+	pattern, _ := ctx.Value(routeKey{}).(string)
+	return pattern
+}
+
+// WithLogger returns a copy of ctx holding the logger of the request.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger of the request: the one set with WithLogger, or
+// the default logger with the request ID attribute.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	if id := RequestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// WithClientIP returns a copy of ctx holding the IP address of the client.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the IP address of the client, or an empty string.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// WithNonce returns a copy of ctx holding the Content-Security-Policy nonce of the response.
+func WithNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, nonceKey{}, nonce)
+}
+
+// Nonce returns the Content-Security-Policy nonce of the response, or an empty string.
+func Nonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// flags resolves the feature flags of a request once.
+type flags struct {
+	once    sync.Once
+	resolve func(ctx context.Context) map[string]bool
+	values  map[string]bool
+}
+
+// WithFlags returns a copy of ctx resolving the feature flags of the request
+// with resolve, called at most once, on the first access, with the context of
+// the access: it holds the values set since, such as the principal.
+func WithFlags(ctx context.Context, resolve func(ctx context.Context) map[string]bool) context.Context {
+	return context.WithValue(ctx, flagsKey{}, &flags{resolve: resolve})
+}
+
+// Flags returns the feature flags of the request, or nil.
+func Flags(ctx context.Context) map[string]bool {
+	f, ok := ctx.Value(flagsKey{}).(*flags)
+	if !ok {
+		return nil
+	}
+	f.once.Do(func() {
+		f.values = f.resolve(ctx)
+	})
+	return f.values
+}
+
+// variants assigns the experiment variants of a request, once per experiment.
+type variants struct {
+	mut      sync.Mutex
+	assign   func(experiment string) string
+	assigned map[string]string
+}
+
+// WithExperiments returns a copy of ctx assigning the experiment variants of
+// the request with assign, called at most once per experiment, on the first access.
+func WithExperiments(ctx context.Context, assign func(experiment string) string) context.Context {
+	return context.WithValue(ctx, variantsKey{}, &variants{assign: assign, assigned: map[string]string{}})
+}
+
+// Experiment returns the variant of the experiment assigned to the request.
+// ok is false when the context doesn't assign variants.
+func Experiment(ctx context.Context, experiment string) (variant string, ok bool) {
+	v, ok := ctx.Value(variantsKey{}).(*variants)
+	if !ok {
+		return "", false
+	}
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	variant, ok = v.assigned[experiment]
+	if !ok {
+		variant = v.assign(experiment)
+		v.assigned[experiment] = variant
+	}
+	return variant, true
+}
+
+// Experiments returns the variants read so far by the request, by experiment.
+func Experiments(ctx context.Context) map[string]string {
+	v, ok := ctx.Value(variantsKey{}).(*variants)
+	if !ok {
+		return nil
+	}
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	assigned := make(map[string]string, len(v.assigned))
+	for experiment, variant := range v.assigned {
+		assigned[experiment] = variant
+	}
+	return assigned
+}
+
+// Snapshot returns the values of the request context, for debugging and
+// panic reports. The session ID and the nonce are redacted, the session
+// only reports whether there is one. Missing values are omitted.
+func Snapshot(ctx context.Context) map[string]any {
+	snapshot := map[string]any{}
+	add := func(name, value string) {
+		if value != "" {
+			snapshot[name] = value
+		}
+	}
+	add("request_id", RequestID(ctx))
+	add("route", Route(ctx))
+	add("locale", Locale(ctx))
+	add("client_ip", ClientIP(ctx))
+	add("traceparent", TraceParent(ctx))
+	if principal, ok := Principal(ctx); ok {
+		snapshot["principal"] = principal
+	}
+	if roles := Roles(ctx); len(roles) > 0 {
+		snapshot["roles"] = roles
+	}
+	if experiments := Experiments(ctx); len(experiments) > 0 {
+		snapshot["experiments"] = experiments
+	}
+	if Session(ctx) != nil {
+		snapshot["session"] = Redacted
+	}
+	if Nonce(ctx) != "" {
+		snapshot["nonce"] = Redacted
+	}
+	return snapshot
+}
@@ -0,0 +1,209 @@
+package reqctx
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+func TestAccessors(t *testing.T) {
+	session := sessions.NewMemorySession("session-1")
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	tests := []struct {
+		name string
+		with func(ctx context.Context) context.Context
+		get  func(ctx context.Context) any
+		want any
+		// zero is the value read from a context without the value.
+		zero any
+	}{
+		{"RequestID", func(ctx context.Context) context.Context { return WithRequestID(ctx, "request-1") },
+			func(ctx context.Context) any { return RequestID(ctx) }, "request-1", ""},
+		{"Session", func(ctx context.Context) context.Context { return WithSession(ctx, session) },
+			func(ctx context.Context) any { return Session(ctx) }, sessions.Session(session), sessions.Session(nil)},
+		{"Principal", func(ctx context.Context) context.Context { return WithPrincipal(ctx, "alice") },
+			func(ctx context.Context) any { p, ok := Principal(ctx); return [2]any{p, ok} }, [2]any{"alice", true}, [2]any{"", false}},
+		{"empty Principal", func(ctx context.Context) context.Context { return WithPrincipal(ctx, "") },
+			func(ctx context.Context) any { p, ok := Principal(ctx); return [2]any{p, ok} }, [2]any{"", true}, [2]any{"", false}},
+		{"Roles", func(ctx context.Context) context.Context { return WithRoles(ctx, "admin", "ops") },
+			func(ctx context.Context) any { return Roles(ctx) }, []string{"admin", "ops"}, []string(nil)},
+		{"Locale", func(ctx context.Context) context.Context { return WithLocale(ctx, "fr-FR") },
+			func(ctx context.Context) any { return Locale(ctx) }, "fr-FR", ""},
+		{"Route", func(ctx context.Context) context.Context { return WithRoute(ctx, "GET /users/{id}") },
+			func(ctx context.Context) any { return Route(ctx) }, "GET /users/{id}", ""},
+		{"Logger", func(ctx context.Context) context.Context { return WithLogger(ctx, logger) },
+			func(ctx context.Context) any { return Logger(ctx) }, logger, slog.Default()},
+		{"ClientIP", func(ctx context.Context) context.Context { return WithClientIP(ctx, "192.0.2.1") },
+			func(ctx context.Context) any { return ClientIP(ctx) }, "192.0.2.1", ""},
+		{"Nonce", func(ctx context.Context) context.Context { return WithNonce(ctx, "n0nce") },
+			func(ctx context.Context) any { return Nonce(ctx) }, "n0nce", ""},
+		{"TraceParent", func(ctx context.Context) context.Context {
+			return WithTraceParent(ctx, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		}, func(ctx context.Context) any { return TraceParent(ctx) }, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ""},
+		{"Flags", func(ctx context.Context) context.Context {
+			return WithFlags(ctx, func(context.Context) map[string]bool { return map[string]bool{"beta": true} })
+		}, func(ctx context.Context) any { return Flags(ctx) }, map[string]bool{"beta": true}, map[string]bool(nil)},
+		{"Experiment", func(ctx context.Context) context.Context {
+			return WithExperiments(ctx, func(experiment string) string { return experiment + "-b" })
+		}, func(ctx context.Context) any { v, ok := Experiment(ctx, "checkout"); return [2]any{v, ok} }, [2]any{"checkout-b", true}, [2]any{"", false}},
+	}
+	for _, tt := range tests {
+		ctx := tt.with(context.Background())
+		if got := tt.get(ctx); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		if got := tt.get(context.Background()); !reflect.DeepEqual(got, tt.zero) {
+			t.Errorf("%s: %v on an empty context, want %v", tt.name, got, tt.zero)
+		}
+		// A value set by the application under a key of the same name
+		// doesn't collide with the one of the package.
+		if got := tt.get(context.WithValue(ctx, tt.name, "application")); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v below a string key, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoggerRequestID(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"request ID", WithRequestID(context.Background(), "request-1"), "request_id=request-1"},
+		{"no request ID", context.Background(), ""},
+		{"logger of the request", WithLogger(WithRequestID(context.Background(), "request-1"), slog.Default().With("own", "yes")), "own=yes"},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		Logger(tt.ctx).Info("served")
+		if got := logs.String(); !strings.Contains(got, "msg=served") || (tt.want != "" && !strings.Contains(got, tt.want)) {
+			t.Errorf("%s: logged %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.want == "" && strings.Contains(logs.String(), "request_id") {
+			t.Errorf("%s: logged %q, want no request ID", tt.name, logs.String())
+		}
+	}
+}
+
+// TestLazyValues checks the feature flags are resolved once and each
+// experiment is assigned once, on the first access.
+func TestLazyValues(t *testing.T) {
+	resolved := 0
+	ctx := WithFlags(context.Background(), func(context.Context) map[string]bool {
+		resolved++
+		return map[string]bool{"beta": true}
+	})
+	assigned := map[string]int{}
+	ctx = WithExperiments(ctx, func(experiment string) string {
+		assigned[experiment]++
+		return "b"
+	})
+	if resolved != 0 || len(Experiments(ctx)) != 0 {
+		t.Fatalf("resolved %d times, assigned %v before any access", resolved, Experiments(ctx))
+	}
+	for range 3 {
+		Flags(ctx)
+		Experiment(ctx, "checkout")
+		Experiment(ctx, "search")
+	}
+	if resolved != 1 {
+		t.Errorf("flags resolved %d times, want 1", resolved)
+	}
+	if want := map[string]int{"checkout": 1, "search": 1}; !reflect.DeepEqual(assigned, want) {
+		t.Errorf("experiments assigned %v, want %v", assigned, want)
+	}
+	if got, want := Experiments(ctx), map[string]string{"checkout": "b", "search": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Experiments %v, want %v", got, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	full := context.Background()
+	full = WithRequestID(full, "request-1")
+	full = WithRoute(full, "GET /users/{id}")
+	full = WithLocale(full, "fr-FR")
+	full = WithClientIP(full, "192.0.2.1")
+	full = WithTraceParent(full, "00-trace-span-01")
+	full = WithPrincipal(full, "alice")
+	full = WithRoles(full, "admin")
+	full = WithSession(full, sessions.NewMemorySession("session-secret-id"))
+	full = WithNonce(full, "nonce-value")
+	full = WithExperiments(full, func(string) string { return "b" })
+	Experiment(full, "checkout")
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want map[string]any
+	}{
+		{"empty", context.Background(), map[string]any{}},
+		{"request ID only", WithRequestID(context.Background(), "request-1"), map[string]any{"request_id": "request-1"}},
+		{"every value", full, map[string]any{
+			"request_id":  "request-1",
+			"route":       "GET /users/{id}",
+			"locale":      "fr-FR",
+			"client_ip":   "192.0.2.1",
+			"traceparent": "00-trace-span-01",
+			"principal":   "alice",
+			"roles":       []string{"admin"},
+			"experiments": map[string]string{"checkout": "b"},
+			"session":     Redacted,
+			"nonce":       Redacted,
+		}},
+	}
+	for _, tt := range tests {
+		got := Snapshot(tt.ctx)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		for _, secret := range []string{"session-secret-id", "nonce-value"} {
+			if strings.Contains(slog.AnyValue(got).String(), secret) {
+				t.Errorf("%s: the snapshot %v leaks %q", tt.name, got, secret)
+			}
+		}
+	}
+}
+
+// TestNoStringKeys checks the library stores nothing in a context under a
+// string key, which the application could collide with.
+func TestNoStringKeys(t *testing.T) {
+	fset := token.NewFileSet()
+	err := filepath.WalkDir("..", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 3 {
+				return true
+			}
+			if fn, ok := call.Fun.(*ast.SelectorExpr); !ok || fn.Sel.Name != "WithValue" {
+				return true
+			}
+			if key, ok := call.Args[1].(*ast.BasicLit); ok && key.Kind == token.STRING {
+				t.Errorf("%s: context.WithValue with the string key %s", fset.Position(call.Pos()), key.Value)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,178 @@
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// State holds the values serverlib sets on every request in a single pooled
+// object, rather than one context layer per value. It is returned to its pool
+// once the response is complete: a context used past the handler, e.g. by a
+// goroutine, must be passed through Retain first. A context that wasn't reads
+// none of these values after the response, never the values of another request.
+type State struct {
+	mut       sync.RWMutex
+	ctx       *stateCtx
+	requestID string
+	clientIP  string
+	session   sessions.Session
+	route     string
+	request   *http.Request
+	logger    *slog.Logger
+}
+
+var statePool = sync.Pool{New: func() any { return &State{} }}
+
+// stateCtx is the context layer of a State. It is allocated per request so
+// that the contexts still referenced after the response can be detached from
+// the pooled state.
+type stateCtx struct {
+	context.Context
+	mut   sync.RWMutex
+	state *State
+}
+
+// Value returns the values of the state for its keys. The state can't be
+// released while it is read.
+func (c *stateCtx) Value(key any) any {
+	switch key.(type) {
+	case requestIDKey, clientIPKey, sessionKey, routeKey, loggerKey:
+	default:
+		return c.Context.Value(key)
+	}
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	if c.state == nil {
+		return c.Context.Value(key)
+	}
+	if value := c.state.value(key); value != nil {
+		return value
+	}
+	parent := c.Context.Value(key)
+	if _, ok := key.(loggerKey); ok && parent == nil {
+		return c.state.requestLogger()
+	}
+	return parent
+}
+
+// value returns the value of the key, or nil when it is not set.
+func (s *State) value(key any) any {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	switch key.(type) {
+	case requestIDKey:
+		if s.requestID != "" {
+			return s.requestID
+		}
+	case clientIPKey:
+		if s.clientIP != "" {
+			return s.clientIP
+		}
+	case sessionKey:
+		if s.session != nil {
+			return s.session
+		}
+	case routeKey:
+		if s.route != "" {
+			return s.route
+		}
+		if s.request != nil && s.request.Pattern != "" {
+			return s.request.Pattern
+		}
+	case loggerKey:
+		if s.logger != nil {
+			return s.logger
+		}
+	}
+	return nil
+}
+
+// requestLogger returns the default logger with the request ID attribute,
+// materialized on first use.
+func (s *State) requestLogger() any {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.requestID == "" {
+		return nil
+	}
+	if s.logger == nil {
+		s.logger = slog.Default().With("request_id", s.requestID)
+	}
+	return s.logger
+}
+
+// Acquire returns a copy of ctx holding a pooled State, to be released once
+// the response is complete.
+func Acquire(ctx context.Context) (context.Context, *State) {
+	state := statePool.Get().(*State)
+	state.ctx = &stateCtx{Context: ctx, state: state}
+	return state.ctx, state
+}
+
+// SetRequestID sets the request ID, see RequestID.
+func (s *State) SetRequestID(id string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.requestID = id
+}
+
+// SetClientIP sets the IP address of the client, see ClientIP.
+func (s *State) SetClientIP(ip string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.clientIP = ip
+}
+
+// SetSession sets the session, see Session.
+func (s *State) SetSession(session sessions.Session) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.session = session
+}
+
+// SetRoute sets the pattern of the matched route, see Route.
+func (s *State) SetRoute(pattern string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.route = pattern
+}
+
+// SetRequest sets the request routed by an http.ServeMux: without an explicit
+// route, Route returns its Pattern once the mux has matched it.
+func (s *State) SetRequest(r *http.Request) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.request = r
+}
+
+// Release detaches the state from the contexts of the request and returns it
+// to the pool. The state must not be used afterwards.
+func (s *State) Release() {
+	if s.ctx != nil {
+		s.ctx.mut.Lock()
+		s.ctx.state = nil
+		s.ctx.mut.Unlock()
+	}
+	s.mut.Lock()
+	s.ctx, s.requestID, s.clientIP, s.session, s.route, s.request, s.logger = nil, "", "", nil, "", nil, nil
+	s.mut.Unlock()
+	statePool.Put(s)
+}
+
+// Retain returns a copy of ctx keeping the values of the request once the
+// response is complete, for the goroutines outliving the handler. The
+// cancellation of ctx is kept.
+func Retain(ctx context.Context) context.Context {
+	retained := &State{
+		requestID: RequestID(ctx),
+		clientIP:  ClientIP(ctx),
+		session:   Session(ctx),
+		route:     Route(ctx),
+	}
+	retained.ctx = &stateCtx{Context: ctx, state: retained}
+	return retained.ctx
+}
@@ -0,0 +1,102 @@
+package reqctx
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+// request acquires a state holding the values of request n.
+func request(n int) (context.Context, *State) {
+	ctx, state := Acquire(context.Background())
+	state.SetRequestID("request-" + strconv.Itoa(n))
+	state.SetClientIP("192.0.2." + strconv.Itoa(n))
+	state.SetSession(sessions.NewMemorySession("session-" + strconv.Itoa(n)))
+	state.SetRoute("GET /" + strconv.Itoa(n))
+	return ctx, state
+}
+
+// values returns the values of the request read from ctx.
+func values(ctx context.Context) []string {
+	session := ""
+	if s := Session(ctx); s != nil {
+		session = s.Id()
+	}
+	return []string{RequestID(ctx), ClientIP(ctx), session, Route(ctx)}
+}
+
+func want(n int) []string {
+	return []string{"request-" + strconv.Itoa(n), "192.0.2." + strconv.Itoa(n), "session-" + strconv.Itoa(n), "GET /" + strconv.Itoa(n)}
+}
+
+// TestStateRetention keeps the context of a released request while the pool
+// serves other requests: the context never reads the values of another
+// request, and the contexts passed through Retain or Detach keep their own.
+func TestStateRetention(t *testing.T) {
+	tests := []struct {
+		name string
+		// keep returns the context a goroutine would keep past the response.
+		keep func(ctx context.Context) context.Context
+		want func(n int) []string
+	}{
+		{"kept as is", func(ctx context.Context) context.Context { return ctx }, func(int) []string {
+			return []string{"", "", "", ""}
+		}},
+		{"Retain", Retain, want},
+		{"Detach", Detach, want},
+	}
+	for _, tt := range tests {
+		ctx, state := request(1)
+		kept := tt.keep(ctx)
+		state.Release()
+		for n := 2; n < 100; n++ {
+			_, other := request(n)
+			if got := values(kept); !slices.Equal(got, tt.want(1)) {
+				t.Fatalf("%s: during request %d, the released context reads %q, want %q", tt.name, n, got, tt.want(1))
+			}
+			other.Release()
+		}
+	}
+}
+
+// TestStateRetentionConcurrent reads retained and released contexts from
+// goroutines while the pool is reused, to be run with -race.
+func TestStateRetentionConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for n := range 200 {
+		ctx, state := request(n)
+		retained := Retain(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if got := values(retained); !slices.Equal(got, want(n)) {
+					t.Errorf("retained context of request %d reads %q", n, got)
+					return
+				}
+				if id := RequestID(ctx); id != "" && id != want(n)[0] {
+					t.Errorf("context of request %d reads the request ID %q of another request", n, id)
+					return
+				}
+			}
+		}()
+		state.Release()
+	}
+	wg.Wait()
+}
+
+func TestStateLogger(t *testing.T) {
+	ctx, state := request(1)
+	logger := Logger(ctx)
+	if Logger(ctx) != logger {
+		t.Error("the request logger is materialized again on each use")
+	}
+	state.Release()
+	if Logger(ctx) == logger {
+		t.Error("the released context still reads the request logger")
+	}
+}
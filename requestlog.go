@@ -0,0 +1,250 @@
+package serverlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// DefaultRequestLogCapacity is the number of requests kept by the request log
+// when RequestLogOptions.Capacity is not set.
+const DefaultRequestLogCapacity = 200
+
+// DevRequestsPath is the path of the page listing the last requests, see
+// ServerConfig.RequestLog. With ?format=json, or when the client accepts
+// JSON, the requests are listed as JSON.
+const DevRequestsPath = "/_dev/requests"
+
+// requestLogFieldMax bounds the length of the text fields of a summary, so
+// that the memory of the log stays bounded.
+const requestLogFieldMax = 256
+
+// RequestLogOptions configures the in-memory log of the last requests, see
+// ServerConfig.RequestLog.
+type RequestLogOptions struct {
+	// Capacity is the number of requests kept, the oldest being overwritten.
+	// Defaults to DefaultRequestLogCapacity.
+	Capacity int
+	// Authorize reports whether a request may see the DevRequestsPath page;
+	// other requests get a 404. In the Development profile, a nil function
+	// allows every request; otherwise, it denies them all.
+	Authorize func(*http.Request) bool
+}
+
+// RequestSummary is the compact summary of a served request. Neither the
+// headers, nor the query, nor the bodies are kept; the session is identified
+// by the hash of its ID (see reqctx.Correlation).
+type RequestSummary struct {
+	Time        time.Time     `json:"time"`
+	RequestID   string        `json:"request_id"`
+	Method      string        `json:"method"`
+	Path        string        `json:"path"`
+	Route       string        `json:"route,omitempty"`
+	Status      int           `json:"status"`
+	Duration    time.Duration `json:"-"`
+	Template    string        `json:"template,omitempty"`
+	SessionHash string        `json:"session_hash,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, with the duration in milliseconds.
+func (s RequestSummary) MarshalJSON() ([]byte, error) {
+	type summary RequestSummary
+	return json.Marshal(struct {
+		summary
+		DurationMS float64 `json:"duration_ms"`
+	}{summary(s), float64(s.Duration) / float64(time.Millisecond)})
+}
+
+// StatusClass returns the class of the status, e.g. "4xx".
+func (s RequestSummary) StatusClass() string {
+	return strconv.Itoa(s.Status/100) + "xx"
+}
+
+// RequestLog is a ring buffer of the summaries of the last requests, for the
+// developers to see what was served without tailing the logs. Its methods are
+// safe for concurrent use; the methods of a nil RequestLog do nothing.
+type RequestLog struct {
+	entries []RequestSummary
+	next    int
+	count   int
+	mut     *sync.Mutex
+}
+
+// NewRequestLog creates a request log keeping the last capacity requests,
+// DefaultRequestLogCapacity when zero or less.
+func NewRequestLog(capacity int) *RequestLog {
+	if capacity <= 0 {
+		capacity = DefaultRequestLogCapacity
+	}
+	return &RequestLog{entries: make([]RequestSummary, capacity), mut: &sync.Mutex{}}
+}
+
+// Add adds a summary, overwriting the oldest one once the log is full.
+func (l *RequestLog) Add(summary RequestSummary) {
+	if l == nil {
+		return
+	}
+	summary.Path = truncate(summary.Path, requestLogFieldMax)
+	summary.Route = truncate(summary.Route, requestLogFieldMax)
+	summary.Template = truncate(summary.Template, requestLogFieldMax)
+	summary.Error = truncate(summary.Error, requestLogFieldMax)
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.entries[l.next] = summary
+	l.next = (l.next + 1) % len(l.entries)
+	l.count = min(l.count+1, len(l.entries))
+}
+
+// Capacity returns the number of requests kept.
+func (l *RequestLog) Capacity() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.entries)
+}
+
+// Entries returns the summaries, the most recent first.
+func (l *RequestLog) Entries() []RequestSummary {
+	if l == nil {
+		return nil
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	entries := make([]RequestSummary, 0, l.count)
+	for i := 1; i <= l.count; i++ {
+		entries = append(entries, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return entries
+}
+
+// truncate returns s cut to max bytes, on a rune boundary.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+// RequestLog returns the log of the last requests, or nil when it is not
+// enabled, see ServerConfig.RequestLog.
+func (s *Server) RequestLog() *RequestLog {
+	return s.injector.requests
+}
+
+// requestLogWriter records the status, the template and the error of a
+// response for the request log.
+type requestLogWriter struct {
+	http.ResponseWriter
+	status   int
+	template string
+	err      string
+}
+
+func (w *requestLogWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *requestLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *requestLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// record adds the summary of the request served to w, once complete.
+func (l *RequestLog) record(w *requestLogWriter, r *http.Request, start time.Time) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	route := r.Pattern
+	if route == "" {
+		route = reqctx.Route(r.Context())
+	}
+	l.Add(RequestSummary{
+		Time:        start,
+		RequestID:   reqctx.RequestID(r.Context()),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Route:       route,
+		Status:      status,
+		Duration:    time.Since(start),
+		Template:    w.template,
+		SessionHash: reqctx.CorrelationOf(r.Context()).SessionHash,
+		Error:       w.err,
+	})
+}
+
+// recordRequestError records the error answered to w in the request log.
+func recordRequestError(w http.ResponseWriter, err error) {
+	if logged, ok := findWriter[*requestLogWriter](w); ok && logged.err == "" {
+		logged.err = err.Error()
+	}
+}
+
+// serveRequestLog serves the DevRequestsPath page, filtered by ?status=4xx
+// and ?route=, a substring of the route or the path.
+func (s *Server) serveRequestLog(authorize func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil && s.profile != Development || authorize != nil && !authorize(r) {
+			s.Error(w, r, NewHTTPError(http.StatusNotFound, ""))
+			return
+		}
+		query := r.URL.Query()
+		status, route := query.Get("status"), query.Get("route")
+		var entries []RequestSummary
+		for _, entry := range s.injector.requests.Entries() {
+			if status != "" && entry.StatusClass() != status {
+				continue
+			}
+			if route != "" && !strings.Contains(entry.Route, route) && !strings.Contains(entry.Path, route) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if query.Get("format") == "json" || acceptsJSON(r) {
+			if entries == nil {
+				entries = []RequestSummary{}
+			}
+			if err := JSON(w, http.StatusOK, map[string]any{"capacity": s.injector.requests.Capacity(), "requests": entries}); err != nil {
+				s.LogError("Writing request log", err.Error())
+			}
+			return
+		}
+		var buf bytes.Buffer
+		data := map[string]interface{}{
+			"Requests": entries,
+			"Capacity": s.injector.requests.Capacity(),
+			"Status":   status,
+			"Route":    route,
+			"Path":     s.Path(DevRequestsPath),
+		}
+		if err := s.t.ExecuteContext(r.Context(), &buf, builtinPrefix+"requests.html", data); err != nil {
+			s.Error(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		buf.WriteTo(w)
+	})
+}
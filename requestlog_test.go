@@ -0,0 +1,296 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"unicode/utf8"
+)
+
+func TestRequestLogWraparound(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		adds     int
+		want     []string
+	}{
+		{"empty", 3, 0, []string{}},
+		{"not full", 3, 2, []string{"r1", "r0"}},
+		{"full", 3, 3, []string{"r2", "r1", "r0"}},
+		{"wrapped", 3, 5, []string{"r4", "r3", "r2"}},
+		{"wrapped twice", 3, 7, []string{"r6", "r5", "r4"}},
+		{"capacity of one", 1, 4, []string{"r3"}},
+		{"default capacity", 0, DefaultRequestLogCapacity + 1, nil},
+	}
+	for _, tt := range tests {
+		l := NewRequestLog(tt.capacity)
+		for i := range tt.adds {
+			l.Add(RequestSummary{RequestID: fmt.Sprintf("r%d", i)})
+		}
+		var got []string
+		for _, entry := range l.Entries() {
+			got = append(got, entry.RequestID)
+		}
+		if tt.want == nil {
+			if len(got) != DefaultRequestLogCapacity || got[0] != fmt.Sprintf("r%d", tt.adds-1) || l.Capacity() != DefaultRequestLogCapacity {
+				t.Errorf("%s: %d entries, the first %s", tt.name, len(got), got[0])
+			}
+			continue
+		}
+		if !slices.Equal(got, tt.want) || l.Capacity() != tt.capacity {
+			t.Errorf("%s: %v, capacity %d, want %v", tt.name, got, l.Capacity(), tt.want)
+		}
+	}
+	var l *RequestLog
+	l.Add(RequestSummary{})
+	if l.Entries() != nil || l.Capacity() != 0 {
+		t.Error("a nil request log kept a request")
+	}
+}
+
+// TestRequestLogConcurrent adds from several goroutines: the log holds the
+// last requests of each writer, in their order.
+func TestRequestLogConcurrent(t *testing.T) {
+	const writers, adds, capacity = 8, 500, 64
+	l := NewRequestLog(capacity)
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range adds {
+				l.Add(RequestSummary{RequestID: fmt.Sprintf("w%d", w), Status: i})
+				if i%50 == 0 {
+					l.Entries()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	entries := l.Entries()
+	if len(entries) != capacity {
+		t.Fatalf("%d entries, want %d", len(entries), capacity)
+	}
+	last := map[string]int{}
+	for _, entry := range entries {
+		if previous, ok := last[entry.RequestID]; ok && entry.Status >= previous {
+			t.Errorf("%s: request %d listed after %d", entry.RequestID, entry.Status, previous)
+		}
+		last[entry.RequestID] = entry.Status
+	}
+	// The most recent request is the last of one of the writers.
+	if entries[0].Status != adds-1 {
+		t.Errorf("most recent %+v", entries[0])
+	}
+}
+
+func TestRequestLogTruncate(t *testing.T) {
+	l := NewRequestLog(1)
+	long := strings.Repeat("é", requestLogFieldMax)
+	l.Add(RequestSummary{Path: "/" + long, Route: long, Template: long, Error: long})
+	entry := l.Entries()[0]
+	for name, field := range map[string]string{"path": entry.Path, "route": entry.Route, "template": entry.Template, "error": entry.Error} {
+		if len(field) > requestLogFieldMax+len("…") || !utf8.ValidString(field) || !strings.HasSuffix(field, "…") {
+			t.Errorf("%s: %d bytes %q", name, len(field), field)
+		}
+	}
+}
+
+// requestLogServer returns a server logging its requests, its page shown to
+// the requests with the X-Dev header.
+func requestLogServer(t *testing.T) *Server {
+	t.Helper()
+	s := NewServer(ServerConfig{RequestLog: &RequestLogOptions{Capacity: 10, Authorize: func(r *http.Request) bool {
+		return r.Header.Get("X-Dev") == "1"
+	}}})
+	s.AddTemplateFS(fstest.MapFS{"user.html": {Data: []byte("<p>{{.ID}}</p>")}}, "*")
+	if err := s.Templates().Parse(); err != nil {
+		t.Fatal(err)
+	}
+	s.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s.GetSession(w, r)
+		s.RenderRequest(w, r, "user.html", map[string]any{"ID": r.PathValue("id")})
+	})
+	s.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		s.Error(w, r, errors.New("database down"))
+	})
+	s.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.WriteHeader(http.StatusSeeOther)
+	})
+	return s
+}
+
+// TestRequestLogPage serves requests, then lists them on the DevRequestsPath
+// page, filtered, as HTML and as JSON.
+func TestRequestLogPage(t *testing.T) {
+	s := requestLogServer(t)
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/users/7", nil),
+		httptest.NewRequest(http.MethodGet, "/fail", nil),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+		httptest.NewRequest(http.MethodPost, "/login?token=query-secret", strings.NewReader("password=body-secret")),
+	} {
+		request.Header.Set("Authorization", "Bearer header-secret")
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		s.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	tests := []struct {
+		name     string
+		query    string
+		dev      bool
+		wantCode int
+		// wantPaths are the paths listed, the most recent first.
+		wantPaths []string
+	}{
+		{"all", "?format=json", true, http.StatusOK, []string{"/login", "/missing", "/fail", "/users/7"}},
+		{"status class", "?format=json&status=4xx", true, http.StatusOK, []string{"/missing"}},
+		{"route", "?format=json&route=/users/", true, http.StatusOK, []string{"/users/7"}},
+		{"route pattern", "?format=json&route={id}", true, http.StatusOK, []string{"/users/7"}},
+		{"no match", "?format=json&status=1xx", true, http.StatusOK, []string{}},
+		{"HTML", "?status=5xx", true, http.StatusOK, []string{"/fail"}},
+		{"not authorized", "?format=json", false, http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, DevRequestsPath+tt.query, nil)
+		if tt.dev {
+			r.Header.Set("X-Dev", "1")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantPaths == nil {
+			continue
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: Cache-Control %q", tt.name, w.Header().Get("Cache-Control"))
+		}
+		if !strings.Contains(tt.query, "format=json") {
+			for _, path := range tt.wantPaths {
+				if !strings.Contains(w.Body.String(), "<td>"+path+"</td>") {
+					t.Errorf("%s: %s not listed in %q", tt.name, path, w.Body.String())
+				}
+			}
+			if !strings.Contains(w.Body.String(), `<option value="5xx" selected>`) {
+				t.Errorf("%s: no selected status in %q", tt.name, w.Body.String())
+			}
+			continue
+		}
+		var page struct {
+			Capacity int
+			Requests []RequestSummary
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		paths := []string{}
+		for _, request := range page.Requests {
+			paths = append(paths, request.Path)
+		}
+		if !slices.Equal(paths, tt.wantPaths) || page.Capacity != 10 {
+			t.Errorf("%s: %v, capacity %d, want %v", tt.name, paths, page.Capacity, tt.wantPaths)
+		}
+	}
+	// The page itself isn't logged, nor any secret of the requests.
+	entries := s.RequestLog().Entries()
+	if len(entries) != 4 {
+		t.Errorf("%d requests logged, want 4", len(entries))
+	}
+	logged, _ := json.Marshal(entries)
+	for _, secret := range []string{"header-secret", "query-secret", "body-secret"} {
+		if strings.Contains(string(logged), secret) {
+			t.Errorf("%s logged: %s", secret, logged)
+		}
+	}
+}
+
+// TestRequestLogJSON checks the shape of the JSON form of the page.
+func TestRequestLogJSON(t *testing.T) {
+	s := requestLogServer(t)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/7", nil))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	r := httptest.NewRequest(http.MethodGet, DevRequestsPath, nil)
+	r.Header.Set("X-Dev", "1")
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var page map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if len(page) != 2 || page["capacity"] != float64(10) {
+		t.Errorf("page %v", page)
+	}
+	requests, _ := page["requests"].([]any)
+	if len(requests) != 2 {
+		t.Fatalf("requests %v", page["requests"])
+	}
+	tests := []struct {
+		name       string
+		request    map[string]any
+		wantKeys   []string
+		wantValues map[string]any
+	}{
+		{"rendered", requests[1].(map[string]any),
+			[]string{"duration_ms", "method", "path", "request_id", "route", "session_hash", "status", "template", "time"},
+			map[string]any{"method": "GET", "path": "/users/7", "route": "GET /users/{id}", "status": float64(200), "template": "user.html"}},
+		{"failed", requests[0].(map[string]any),
+			[]string{"duration_ms", "error", "method", "path", "request_id", "route", "session_hash", "status", "time"},
+			map[string]any{"path": "/fail", "route": "GET /fail", "status": float64(500), "error": "database down"}},
+	}
+	for _, tt := range tests {
+		var keys []string
+		for key := range tt.request {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, tt.wantKeys) {
+			t.Errorf("%s: keys %v, want %v", tt.name, keys, tt.wantKeys)
+		}
+		for key, want := range tt.wantValues {
+			if tt.request[key] != want {
+				t.Errorf("%s: %s %v, want %v", tt.name, key, tt.request[key], want)
+			}
+		}
+		if _, ok := tt.request["duration_ms"].(float64); !ok {
+			t.Errorf("%s: duration_ms %v", tt.name, tt.request["duration_ms"])
+		}
+		if id, _ := tt.request["request_id"].(string); id == "" {
+			t.Errorf("%s: no request ID", tt.name)
+		}
+	}
+}
+
+// TestRequestLogProfiles checks the page of the Development profile allows
+// every request, and the other profiles need RequestLogOptions.Authorize.
+func TestRequestLogProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ServerConfig
+		wantCode int
+	}{
+		{"development", ServerConfig{Profile: Development}, http.StatusOK},
+		{"production", ServerConfig{RequestLog: &RequestLogOptions{}}, http.StatusNotFound},
+		{"authorized", ServerConfig{RequestLog: &RequestLogOptions{Authorize: func(*http.Request) bool { return true }}}, http.StatusOK},
+		{"disabled", ServerConfig{}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		s := NewServer(tt.config)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DevRequestsPath+"?format=json", nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Morditux/serverlib/cache"
+)
+
+const (
+	// DefaultResponseCacheEntries is the default maximum number of cached responses.
+	DefaultResponseCacheEntries = 10000
+	// DefaultResponseCacheBytes is the default maximum size of the cached bodies.
+	DefaultResponseCacheBytes = 64 << 20 // 64 MiB
+	// DefaultResponseCacheTTL is the default lifetime of a cached response.
+	DefaultResponseCacheTTL = 5 * time.Minute
+	// MaxCacheTags is the maximum number of tags of a cached response, the
+	// extra tags are ignored.
+	MaxCacheTags = 32
+	// CacheStatusHeader tells whether a response was served from the cache, "HIT" or "MISS".
+	CacheStatusHeader = "X-Cache"
+)
+
+// ResponseCacheOptions configures the response cache, see ServerConfig.ResponseCache.
+type ResponseCacheOptions struct {
+	// MaxEntries is the maximum number of cached responses. Defaults to DefaultResponseCacheEntries.
+	MaxEntries int
+	// MaxBytes is the maximum size of the cached bodies. Defaults to DefaultResponseCacheBytes.
+	MaxBytes int64 `unit:"bytes"`
+	// TTL is the lifetime of a cached response. Defaults to DefaultResponseCacheTTL.
+	TTL time.Duration
+}
+
+// cachedResponse is a response stored by the response cache.
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	tags   []string
+}
+
+// ResponseCache caches the successful GET responses of the handlers it wraps,
+// by request URI, and purges them by tag or URL.
+type ResponseCache struct {
+	store *cache.Cache[string, *cachedResponse]
+	// index maps the tags to the keys of the responses carrying them. It only
+	// holds the responses in the store, evictions remove them.
+	index   map[string]map[string]struct{}
+	indexed map[string]*cachedResponse
+	mut     *sync.Mutex
+}
+
+// NewResponseCache creates a response cache with the given options.
+func NewResponseCache(options ResponseCacheOptions) *ResponseCache {
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = DefaultResponseCacheEntries
+	}
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = DefaultResponseCacheBytes
+	}
+	if options.TTL <= 0 {
+		options.TTL = DefaultResponseCacheTTL
+	}
+	c := &ResponseCache{
+		index:   make(map[string]map[string]struct{}),
+		indexed: make(map[string]*cachedResponse),
+		mut:     &sync.Mutex{},
+	}
+	c.store = cache.New(cache.Options[string, *cachedResponse]{
+		MaxEntries: options.MaxEntries,
+		MaxCost:    options.MaxBytes,
+		Cost: func(key string, response *cachedResponse) int64 {
+			return int64(len(key) + len(response.body))
+		},
+		TTL: options.TTL,
+		OnEvict: func(key string, response *cachedResponse, _ cache.EvictionReason) {
+			c.unindex(key, response)
+		},
+	})
+	return c
+}
+
+// cacheTagsKey is the context key of the tags of the response being rendered.
+type cacheTagsKey struct{}
+
+// cacheTags collects the tags of a response being cached.
+type cacheTags struct {
+	tags []string
+	mut  sync.Mutex
+}
+
+// CacheTag associates tags with the response of the request, if it ends up in
+// the response cache, so that it can be purged with Server.PurgeCacheTags.
+// It does nothing for requests not served through the cache.
+func CacheTag(r *http.Request, tags ...string) {
+	collector, ok := r.Context().Value(cacheTagsKey{}).(*cacheTags)
+	if !ok {
+		return
+	}
+	collector.mut.Lock()
+	defer collector.mut.Unlock()
+	for _, tag := range tags {
+		if len(collector.tags) < MaxCacheTags && tag != "" && !containsString(collector.tags, tag) {
+			collector.tags = append(collector.tags, tag)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap returns a handler serving the GET and HEAD requests from the cache.
+// Only 200 responses are stored, unless the handler sets Cache-Control
+// no-store or private, sets a Vary or Trailer header or writes cookies; the session
+// cookie written by the server for new visitors is never stored. Requests
+// with an Authorization header are not cached. Wrap is a Middleware.
+func (c *ResponseCache) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.URL.RequestURI()
+		if response, ok := c.store.Get(key); ok {
+			header := w.Header()
+			for name, values := range response.header {
+				header[name] = values
+			}
+			header.Set(CacheStatusHeader, "HIT")
+			w.WriteHeader(response.status)
+			if r.Method != http.MethodHead {
+				w.Write(response.body)
+			}
+			return
+		}
+		w.Header().Set(CacheStatusHeader, "MISS")
+		// Headers set before the handler, such as the session cookie, are not stored.
+		before := w.Header().Clone()
+		collector := &cacheTags{}
+		recorder := &cacheRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), cacheTagsKey{}, collector)))
+		// The cookies written by the handler belong to this client only.
+		cookies := len(w.Header().Values("Set-Cookie")) > len(before.Values("Set-Cookie"))
+		if r.Method != http.MethodGet || !recorder.cacheable() || cookies {
+			return
+		}
+		header := w.Header().Clone()
+		for name := range before {
+			if strings.EqualFold(name, "Set-Cookie") || before.Get(name) == header.Get(name) {
+				delete(header, name)
+			}
+		}
+		header.Del(CacheStatusHeader)
+		collector.mut.Lock()
+		tags := collector.tags
+		collector.mut.Unlock()
+		c.add(key, &cachedResponse{status: recorder.status, header: header, body: recorder.body.Bytes(), tags: tags})
+	})
+}
+
+// add stores a response and indexes its tags. The index is updated first,
+// so that an immediate eviction of the response removes it again.
+func (c *ResponseCache) add(key string, response *cachedResponse) {
+	c.mut.Lock()
+	if previous, ok := c.indexed[key]; ok {
+		c.removeTags(key, previous)
+	}
+	c.indexed[key] = response
+	for _, tag := range response.tags {
+		keys, ok := c.index[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.index[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	c.mut.Unlock()
+	c.store.Set(key, response)
+}
+
+// unindex removes an evicted response from the tag index, unless it has
+// already been replaced by a newer response.
+func (c *ResponseCache) unindex(key string, response *cachedResponse) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.indexed[key] != response {
+		return
+	}
+	delete(c.indexed, key)
+	c.removeTags(key, response)
+}
+
+// removeTags removes the key from the index entries of the tags of response.
+// The lock must be held.
+func (c *ResponseCache) removeTags(key string, response *cachedResponse) {
+	for _, tag := range response.tags {
+		delete(c.index[tag], key)
+		if len(c.index[tag]) == 0 {
+			delete(c.index, tag)
+		}
+	}
+}
+
+// PurgeTags removes the cached responses carrying any of the tags and
+// returns the number of removed responses.
+func (c *ResponseCache) PurgeTags(tags ...string) int {
+	c.mut.Lock()
+	keys := make(map[string]struct{})
+	for _, tag := range tags {
+		for key := range c.index[tag] {
+			keys[key] = struct{}{}
+		}
+	}
+	c.mut.Unlock()
+	purged := 0
+	for key := range keys {
+		if c.store.Delete(key) {
+			purged++
+		}
+	}
+	return purged
+}
+
+// PurgeURLs removes the cached responses of the URLs, absolute or not (only
+// the path and query are used), and returns the number of removed responses.
+func (c *ResponseCache) PurgeURLs(urls ...string) int {
+	purged := 0
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if c.store.Delete(u.RequestURI()) {
+			purged++
+		}
+	}
+	return purged
+}
+
+// Len returns the number of cached responses.
+func (c *ResponseCache) Len() int {
+	return c.store.Len()
+}
+
+// cacheRecorder copies the response of the handler while writing it.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (w *cacheRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheRecorder) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cacheable reports whether the recorded response can be stored.
+func (w *cacheRecorder) cacheable() bool {
+	if w.status != http.StatusOK {
+		return false
+	}
+	header := w.Header()
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	// The trailers are computed per response, e.g. by ChecksumTrailer.
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private") &&
+		header.Get("Vary") == "" && header.Get("Trailer") == ""
+}
+
+// ResponseCache returns the response cache of the server, see ServerConfig.ResponseCache.
+func (s *Server) ResponseCache() *ResponseCache {
+	return s.responseCache
+}
+
+// PurgeCacheTags removes the cached responses carrying any of the tags (see
+// CacheTag) and returns the number of removed responses.
+func (s *Server) PurgeCacheTags(tags ...string) int {
+	return s.responseCache.PurgeTags(tags...)
+}
+
+// purgeRequest is the body of the purge endpoint.
+type purgeRequest struct {
+	Tags []string `json:"tags"`
+	URLs []string `json:"urls"`
+}
+
+// EnableCachePurgeEndpoint registers a POST endpoint purging the response
+// cache, for invalidation webhooks. The JSON body lists the tags and URLs to
+// purge, e.g. {"tags": ["article:42"], "urls": ["/news"]}; the response gives
+// the number of purged responses, e.g. {"purged": 3}.
+//
+// Parameters:
+//   - path: The path of the endpoint, e.g. "/_cache/purge".
+//   - authorize: Reports whether the request may purge the cache; other
+//     requests get a 401. A nil function denies every request.
+func (s *Server) EnableCachePurgeEndpoint(path string, authorize func(*http.Request) bool) {
+	s.Handle("POST "+path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize == nil || !authorize(r) {
+			s.Error(w, r, NewHTTPError(http.StatusUnauthorized, ""))
+			return
+		}
+		var purge purgeRequest
+		if err := BindJSON(r, &purge); err != nil {
+			s.Error(w, r, err)
+			return
+		}
+		purged := s.responseCache.PurgeTags(purge.Tags...) + s.responseCache.PurgeURLs(purge.URLs...)
+		s.LogInfo("Cache purged", strconv.Itoa(purged)+" responses")
+		if err := JSON(w, http.StatusOK, map[string]int{"purged": purged}); err != nil {
+			s.LogError("Writing purge response", err.Error())
+		}
+	}))
+}
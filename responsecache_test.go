@@ -0,0 +1,269 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cachedServer returns a server serving its routes through its response cache.
+func cachedServer(options ResponseCacheOptions) *Server {
+	s := NewServer(ServerConfig{ResponseCache: &options})
+	s.Use(s.ResponseCache().Wrap)
+	return s
+}
+
+// get serves a GET request of target by s, with the cookie if not nil.
+func get(s *Server, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// tagged answers with its path, tagged with the tags query parameter.
+func tagged(w http.ResponseWriter, r *http.Request) {
+	if tags := r.URL.Query().Get("tags"); tags != "" {
+		CacheTag(r, strings.Split(tags, ",")...)
+	}
+	w.Write([]byte(r.URL.RequestURI()))
+}
+
+// TestResponseCacheStore serves a route twice: the second response comes
+// from the cache only when the first one could be stored.
+func TestResponseCacheStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		method  string
+		header  string
+		want    string
+	}{
+		{"ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("page")) }, http.MethodGet, "", "HIT"},
+		{"empty", func(w http.ResponseWriter, r *http.Request) {}, http.MethodGet, "", "HIT"},
+		{"HEAD", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("page")) }, http.MethodHead, "", "MISS"},
+		{"not found", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }, http.MethodGet, "", "MISS"},
+		{"no-store", func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Cache-Control", "no-store") }, http.MethodGet, "", "MISS"},
+		{"private", func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Cache-Control", "Private, max-age=60") }, http.MethodGet, "", "MISS"},
+		{"Vary", func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Vary", "Accept-Language") }, http.MethodGet, "", "MISS"},
+		{"Trailer", func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Trailer", "X-Checksum") }, http.MethodGet, "", "MISS"},
+		{"cookie", func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "cart", Value: "42"})
+		}, http.MethodGet, "", "MISS"},
+		{"Authorization", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("page")) }, http.MethodGet, "Bearer token", ""},
+	}
+	for _, tt := range tests {
+		s := cachedServer(ResponseCacheOptions{})
+		s.HandleFunc("/page", tt.handler)
+		var last *httptest.ResponseRecorder
+		for range 2 {
+			r := httptest.NewRequest(tt.method, "/page", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			last = httptest.NewRecorder()
+			s.ServeHTTP(last, r)
+		}
+		if got := last.Header().Get(CacheStatusHeader); got != tt.want {
+			t.Errorf("%s: second response %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.want == "HIT" && slices.ContainsFunc(last.Header().Values("Set-Cookie"), func(c string) bool { return !strings.HasPrefix(c, s.sessionKey+"=") }) {
+			t.Errorf("%s: cookies %q replayed from the cache", tt.name, last.Header().Values("Set-Cookie"))
+		}
+	}
+}
+
+// TestResponseCacheSessionCookie checks the session cookie written for the
+// visitor caching the response is not replayed to the next ones.
+func TestResponseCacheSessionCookie(t *testing.T) {
+	s := cachedServer(ResponseCacheOptions{})
+	s.GET("/page", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("page")) })
+	first := get(s, "/page", nil)
+	cookies := first.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie for a new visitor")
+	}
+	second := get(s, "/page", cookies[0])
+	if second.Header().Get(CacheStatusHeader) != "HIT" || second.Body.String() != "page" {
+		t.Fatalf("second response %q %q, want a HIT", second.Header().Get(CacheStatusHeader), second.Body.String())
+	}
+	for _, cookie := range second.Result().Cookies() {
+		if cookie.Value == cookies[0].Value {
+			t.Errorf("the session cookie of the first visitor replayed from the cache")
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	targets := []string{"/a?tags=article:1,news", "/b?tags=news", "/c?tags=article:2", "/d"}
+	tests := []struct {
+		name   string
+		tags   []string
+		urls   []string
+		want   int
+		remain []string
+	}{
+		{"one tag", []string{"article:1"}, nil, 1, []string{"/b?tags=news", "/c?tags=article:2", "/d"}},
+		{"shared tag", []string{"news"}, nil, 2, []string{"/c?tags=article:2", "/d"}},
+		{"overlapping tags", []string{"news", "article:1"}, nil, 2, []string{"/c?tags=article:2", "/d"}},
+		{"unknown tag", []string{"article:3"}, nil, 0, targets},
+		{"path", nil, []string{"/d"}, 1, targets[:3]},
+		{"absolute URL", nil, []string{"https://example.com/b?tags=news"}, 1, []string{"/a?tags=article:1,news", "/c?tags=article:2", "/d"}},
+		{"invalid URL", nil, []string{"%zz", "/nowhere"}, 0, targets},
+		{"tags and URLs", []string{"article:2"}, []string{"/d"}, 2, targets[:2]},
+	}
+	for _, tt := range tests {
+		s := cachedServer(ResponseCacheOptions{})
+		s.GET("/{page}", tagged)
+		for _, target := range targets {
+			get(s, target, nil)
+		}
+		got := s.PurgeCacheTags(tt.tags...) + s.ResponseCache().PurgeURLs(tt.urls...)
+		if got != tt.want {
+			t.Errorf("%s: %d purged, want %d", tt.name, got, tt.want)
+		}
+		var remain []string
+		for _, target := range targets {
+			if get(s, target, nil).Header().Get(CacheStatusHeader) == "HIT" {
+				remain = append(remain, target)
+			}
+		}
+		if !slices.Equal(remain, tt.remain) {
+			t.Errorf("%s: %q still cached, want %q", tt.name, remain, tt.remain)
+		}
+	}
+}
+
+// indexed returns the tags of the index of c and the number of keys of each.
+func indexed(c *ResponseCache) map[string]int {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	tags := map[string]int{}
+	for tag, keys := range c.index {
+		tags[tag] = len(keys)
+	}
+	return tags
+}
+
+// TestResponseCacheIndex checks the tag index only holds the tags of the
+// cached responses, once evicted, purged or replaced.
+func TestResponseCacheIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ResponseCacheOptions
+		targets []string
+		purge   []string
+		want    map[string]int
+	}{
+		{"cached", ResponseCacheOptions{}, []string{"/a?tags=x,y", "/b?tags=y"}, nil, map[string]int{"x": 1, "y": 2}},
+		{"evicted by count", ResponseCacheOptions{MaxEntries: 1}, []string{"/a?tags=x,y", "/b?tags=y"}, nil, map[string]int{"y": 1}},
+		{"too large", ResponseCacheOptions{MaxBytes: 10}, []string{"/a?tags=x"}, nil, map[string]int{}},
+		{"purged by tag", ResponseCacheOptions{}, []string{"/a?tags=x,y", "/b?tags=y"}, []string{"x"}, map[string]int{"y": 1}},
+		{"purged by URL", ResponseCacheOptions{}, []string{"/a?tags=x,y", "/b?tags=y"}, []string{"/a?tags=x,y"}, map[string]int{"y": 1}},
+		{"everything purged", ResponseCacheOptions{}, []string{"/a?tags=x,y", "/b?tags=y"}, []string{"y"}, map[string]int{}},
+	}
+	for _, tt := range tests {
+		s := cachedServer(tt.options)
+		s.GET("/{page}", tagged)
+		for _, target := range tt.targets {
+			get(s, target, nil)
+		}
+		for _, purge := range tt.purge {
+			if strings.HasPrefix(purge, "/") {
+				s.ResponseCache().PurgeURLs(purge)
+			} else {
+				s.PurgeCacheTags(purge)
+			}
+		}
+		if got := indexed(s.ResponseCache()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: index %v, want %v", tt.name, got, tt.want)
+		}
+		c := s.ResponseCache()
+		c.mut.Lock()
+		if len(c.indexed) != c.Len() {
+			t.Errorf("%s: %d responses indexed, %d cached", tt.name, len(c.indexed), c.Len())
+		}
+		c.mut.Unlock()
+	}
+}
+
+// TestResponseCacheReplaced checks a response cached again is indexed under
+// its new tags only, and the tags of a response are capped.
+func TestResponseCacheReplaced(t *testing.T) {
+	c := NewResponseCache(ResponseCacheOptions{})
+	c.add("/a", &cachedResponse{status: http.StatusOK, tags: []string{"x", "y"}})
+	c.add("/a", &cachedResponse{status: http.StatusOK, tags: []string{"y", "z"}})
+	if got, want := indexed(c), map[string]int{"y": 1, "z": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index %v, want %v", got, want)
+	}
+	if got := c.PurgeTags("x"); got != 0 {
+		t.Errorf("%d purged by a tag of the replaced response, want 0", got)
+	}
+
+	s := cachedServer(ResponseCacheOptions{})
+	s.GET("/many", func(w http.ResponseWriter, r *http.Request) {
+		for i := range MaxCacheTags + 10 {
+			CacheTag(r, "tag:"+strconv.Itoa(i), "tag:0", "")
+		}
+	})
+	get(s, "/many", nil)
+	if got := len(indexed(s.ResponseCache())); got != MaxCacheTags {
+		t.Errorf("%d tags indexed, want %d", got, MaxCacheTags)
+	}
+}
+
+func TestCachePurgeEndpoint(t *testing.T) {
+	token := func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer purge-token" }
+	tests := []struct {
+		name      string
+		authorize func(*http.Request) bool
+		token     string
+		body      string
+		wantCode  int
+		wantPurge int
+	}{
+		{"authorized tags", token, "Bearer purge-token", `{"tags": ["news"]}`, http.StatusOK, 2},
+		{"authorized URLs", token, "Bearer purge-token", `{"urls": ["/d", "http://example.com/c?tags=article:2"]}`, http.StatusOK, 2},
+		{"nothing to purge", token, "Bearer purge-token", `{}`, http.StatusOK, 0},
+		{"wrong token", token, "Bearer guess", `{"tags": ["news"]}`, http.StatusUnauthorized, 0},
+		{"no token", token, "", `{"tags": ["news"]}`, http.StatusUnauthorized, 0},
+		{"no authorization function", nil, "Bearer purge-token", `{"tags": ["news"]}`, http.StatusUnauthorized, 0},
+		{"invalid body", token, "Bearer purge-token", `{"tags": "news"}`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		s := cachedServer(ResponseCacheOptions{})
+		s.GET("/{page}", tagged)
+		s.EnableCachePurgeEndpoint("/_cache/purge", tt.authorize)
+		for _, target := range []string{"/a?tags=news", "/b?tags=news", "/c?tags=article:2", "/d"} {
+			get(s, target, nil)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/_cache/purge", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		if tt.token != "" {
+			r.Header.Set("Authorization", tt.token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode == http.StatusOK {
+			var got map[string]int
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got["purged"] != tt.wantPurge {
+				t.Errorf("%s: %s %v, want %d purged", tt.name, w.Body.String(), err, tt.wantPurge)
+			}
+		}
+		if got := s.ResponseCache().Len(); got != 4-tt.wantPurge {
+			t.Errorf("%s: %d responses cached, want %d", tt.name, got, 4-tt.wantPurge)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package serverlib
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotRunning is returned by Restart when the server is not running.
+var ErrNotRunning = errors.New("server not running")
+
+// restartSettleTimeout bounds the wait of Restart for the connections
+// accepted by the current server to send their first request, see settle.
+const restartSettleTimeout = time.Second
+
+// generation is the http.Server serving the listeners between two restarts.
+type generation struct {
+	server    *http.Server
+	listeners []boundListener
+	// detachables wrap the listeners while served, see serveGeneration.
+	detachables []*detachableListener
+	// started is closed once the generation is served, or aborted with err.
+	started chan struct{}
+	err     error
+}
+
+// detachableListener is a listener whose server can be stopped without
+// closing it, so that Restart hands it over to the next generation: once
+// detached, Close unblocks Accept only. Once parked, Accept stops accepting
+// until Close.
+type detachableListener struct {
+	net.Listener
+	detached atomic.Bool
+	parking  atomic.Bool
+	// parked is closed once Accept waits for Close, closed by Close.
+	parked, closed      chan struct{}
+	parkOnce, closeOnce *sync.Once
+}
+
+// deadliner is implemented by the TCP and unix listeners.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+func newDetachableListener(l net.Listener) *detachableListener {
+	if d, ok := l.(deadliner); ok {
+		// Reset the deadline of the previous generation.
+		d.SetDeadline(time.Time{})
+	}
+	return &detachableListener{
+		Listener:  l,
+		parked:    make(chan struct{}),
+		closed:    make(chan struct{}),
+		parkOnce:  &sync.Once{},
+		closeOnce: &sync.Once{},
+	}
+}
+
+// detachable reports whether l can be detached.
+func detachable(l net.Listener) bool {
+	_, ok := l.(deadliner)
+	return ok
+}
+
+// Accept accepts the connections until the listener is closed, detached or
+// parked.
+func (l *detachableListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil && l.parking.Load() {
+		// The connections accepted before are known to the server now.
+		l.parkOnce.Do(func() { close(l.parked) })
+		<-l.closed
+		return nil, net.ErrClosed
+	}
+	if err != nil && l.detached.Load() {
+		return nil, net.ErrClosed
+	}
+	return conn, err
+}
+
+// park stops accepting the connections, which wait in the backlog of the
+// kernel, until Close. It reports false for a listener that can't be parked.
+func (l *detachableListener) park() bool {
+	d, ok := l.Listener.(deadliner)
+	if !ok {
+		return false
+	}
+	l.parking.Store(true)
+	d.SetDeadline(time.Now())
+	return true
+}
+
+// Close closes the listener, or unblocks Accept once detached.
+func (l *detachableListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	if l.detached.Load() {
+		return l.Listener.(deadliner).SetDeadline(time.Now())
+	}
+	return l.Listener.Close()
+}
+
+// settle stops the listeners of gen from accepting, then waits for the
+// connections they accepted to send their first request, within
+// restartSettleTimeout: net/http closes without an answer the connections
+// whose request is read once Shutdown started.
+func (s *Server) settle(ctx context.Context, gen *generation) {
+	timer := time.NewTimer(restartSettleTimeout)
+	defer timer.Stop()
+	var parked []*detachableListener
+	for _, l := range gen.detachables {
+		if l.park() {
+			parked = append(parked, l)
+		}
+	}
+	for _, l := range parked {
+		select {
+		case <-l.parked:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for s.connStates.new.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// useTLS applies setup, the certificate of StartTLS or StartAutoTLS, to the
+// TLS configuration of the server, and again to the configuration applied by
+// Restart.
+func (s *Server) useTLS(setup func(*tls.Config) *tls.Config) {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	s.tlsSetup = setup
+	server := s.httpServer.Load()
+	server.TLSConfig = setup(server.TLSConfig)
+}
+
+// SetAddress sets ServerConfig.Address: before Start, the address it binds,
+// once running, the address bound by the next Restart.
+func (s *Server) SetAddress(addr string) {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	slog.Info("Address set", "address", addr)
+	s.config.Address = addr
+	if s.state == StateCreated {
+		s.httpServer.Load().Addr = addr
+	}
+}
+
+// SetTLSConfig sets ServerConfig.TLSConfig, ServerConfig.ClientAuth applying
+// to it: before Start, the configuration it serves with, once running, the
+// one applied by the next Restart.
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	if s.config.ClientAuth != nil {
+		config = s.config.ClientAuth.apply(config, s)
+	}
+	s.config.TLSConfig = config
+	if s.state == StateCreated {
+		s.httpServer.Load().TLSConfig = config
+	}
+}
+
+// Restart rebinds the listeners with the current configuration, e.g. after
+// SetAddress or SetTLSConfig, without rebuilding the routes, the sessions or
+// the templates: the new listeners are bound first, then the current server
+// stops accepting, lets the connections it accepted send their first request
+// for up to a second, and shuts down gracefully (see Shutdown); the new one
+// takes over, the requests in progress completing on the old one. The
+// addresses unchanged keep their listener, so that no connection is refused
+// meanwhile; the listeners given to Serve are always kept. Start keeps
+// blocking across the restarts. The components, the tasks and the redirector
+// of ServerConfig.RedirectHTTP keep running.
+//
+// Parameters:
+//   - ctx: The context bounding the graceful shutdown of the current server,
+//     whose connections still open are then closed.
+//
+// Returns:
+//   - error: ErrNotRunning when the server is not running, the error binding
+//     the new addresses, the current server then serving on, or the error of
+//     the graceful shutdown.
+func (s *Server) Restart(ctx context.Context) error {
+	s.restartMut.Lock()
+	defer s.restartMut.Unlock()
+	s.stateMut.Lock()
+	current, config, setup := s.current, s.config, s.tlsSetup
+	running := s.state == StateRunning && !s.shuttingDown.Load() && current != nil
+	s.stateMut.Unlock()
+	if !running {
+		return ErrNotRunning
+	}
+
+	server := s.newHTTPServer(config, current.server.Handler)
+	if setup != nil {
+		server.TLSConfig = setup(server.TLSConfig)
+	}
+	if server.Addr == "" {
+		server.Addr = current.server.Addr
+	}
+	listeners, reused, err := s.rebind(current, config)
+	if err != nil {
+		return err
+	}
+	closeNew := func() {
+		for i, l := range listeners {
+			if reused[i] < 0 {
+				l.Close()
+			}
+		}
+	}
+	for _, l := range listeners {
+		if l.tls && !hasCertificates(server.TLSConfig) {
+			closeNew()
+			return ErrNoCertificate
+		}
+	}
+
+	next := &generation{server: server, listeners: listeners, started: make(chan struct{})}
+	s.stateMut.Lock()
+	if s.shuttingDown.Load() {
+		s.stateMut.Unlock()
+		closeNew()
+		return http.ErrServerClosed
+	}
+	s.pending = next
+	for _, i := range reused {
+		if i >= 0 {
+			current.detachables[i].detached.Store(true)
+		}
+	}
+	s.stateMut.Unlock()
+
+	slog.Info("Server restarting", "address", s.Addr())
+	s.settle(ctx, current)
+	err = current.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		current.server.Close()
+	}
+	select {
+	case <-next.started:
+		err = errors.Join(err, next.err)
+	case <-ctx.Done():
+		err = errors.Join(err, ctx.Err())
+	}
+	return err
+}
+
+// rebind binds the addresses of config, keeping the listeners of current
+// bound to the same address.
+//
+// Returns:
+//   - []boundListener: The listeners of the next generation.
+//   - []int: For each listener, the index of the listener of current it
+//     keeps, or -1 when bound anew.
+//   - error: The error binding an address; nothing new stays bound then.
+func (s *Server) rebind(current *generation, config ServerConfig) ([]boundListener, []int, error) {
+	var addresses []ListenAddress
+	first := current.listeners[0]
+	if first.address == "" {
+		// Given to Serve: kept as is.
+		addresses = []ListenAddress{{TLS: first.tls}}
+	} else {
+		addr := config.Address
+		if addr == "" {
+			addr = first.address
+		}
+		addresses = append([]ListenAddress{{Address: addr, TLS: first.tls}}, config.Listeners...)
+	}
+	listeners := make([]boundListener, 0, len(addresses))
+	reused := make([]int, 0, len(addresses))
+	kept := map[int]bool{}
+	closeNew := func() {
+		for i, bound := range listeners {
+			if reused[i] < 0 {
+				bound.Close()
+			}
+		}
+	}
+	for _, address := range addresses {
+		index := -1
+		for i, l := range current.listeners {
+			if !kept[i] && l.address == address.Address && detachable(l.Listener) {
+				index = i
+				break
+			}
+		}
+		if index >= 0 {
+			kept[index] = true
+			listeners = append(listeners, boundListener{Listener: current.listeners[index].Listener, tls: address.TLS, address: address.Address})
+			reused = append(reused, index)
+			continue
+		}
+		if address.Address == "" {
+			closeNew()
+			return nil, nil, errors.New("the listener given to Serve cannot be kept")
+		}
+		l, err := s.listen(address.Address)
+		if err != nil {
+			closeNew()
+			return nil, nil, err
+		}
+		listeners = append(listeners, boundListener{Listener: l, tls: address.TLS, address: address.Address})
+		reused = append(reused, -1)
+	}
+	return listeners, reused, nil
+}
+
+// attach makes gen the generation served, unless the server is shutting
+// down: gen is then aborted.
+func (s *Server) attach(gen *generation) bool {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	defer close(gen.started)
+	if s.current != nil && s.shuttingDown.Load() {
+		gen.err = http.ErrServerClosed
+		return false
+	}
+	if s.current != nil {
+		addrs := make([]string, len(gen.listeners))
+		for i, l := range gen.listeners {
+			addrs[i] = listenerAddr(l)
+			slog.Info("Server restarted", "address", addrs[i], "scheme", l.scheme())
+		}
+		s.addrs.Store(&addrs)
+		gen.server.SetKeepAlivesEnabled(!s.draining.Load() && !s.keepAlivesOff.Load())
+		s.httpServer.Store(gen.server)
+	}
+	s.current = gen
+	return true
+}
+
+// nextGeneration returns the generation handed over by Restart, or nil.
+func (s *Server) nextGeneration() *generation {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	gen := s.pending
+	s.pending = nil
+	return gen
+}
@@ -0,0 +1,154 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestRestartAddress restarts a running server, then moves it to another
+// address with SetAddress: the new address serves, the old one is closed,
+// and a request in progress completes on the previous server.
+func TestRestartAddress(t *testing.T) {
+	s := newTestServer()
+	if err := s.Restart(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Restart before the start: %v", err)
+	}
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	s.GET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("completed"))
+	})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	done := startServer(s)
+	<-s.Ready()
+	old := s.Addr()
+	// An address unchanged keeps its listener.
+	if err := s.Restart(context.Background()); err != nil || s.Addr() != old {
+		t.Fatalf("Restart unchanged: %v, Addr %q, want %q", err, s.Addr(), old)
+	}
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + old + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-entered
+	s.SetAddress("localhost:0")
+	restarted := make(chan error, 1)
+	go func() {
+		restarted <- s.Restart(context.Background())
+	}()
+	// The restart waits for the request in progress.
+	select {
+	case err := <-restarted:
+		t.Errorf("Restart returned %v during a request", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-restarted; err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	if body := <-slow; body != "completed" {
+		t.Errorf("request in progress: %q", body)
+	}
+	if s.Addr() == old {
+		t.Fatalf("Addr %q unchanged", old)
+	}
+	resp, err := http.Get("http://" + s.Addr() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if conn, err := net.Dial("tcp", old); err == nil {
+		conn.Close()
+		t.Errorf("%s still listening after the restart", old)
+	}
+	if state := s.State(); state != StateRunning {
+		t.Errorf("state %v after the restart", state)
+	}
+	s.Stop()
+	if err := waitStart(t, done); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Start: %v", err)
+	}
+	if err := s.Restart(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Restart after Stop: %v", err)
+	}
+}
+
+// TestRestartBindError restarts a server on an address in use: Restart
+// fails with the BindError, the server serving on its current address.
+func TestRestartBindError(t *testing.T) {
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inUse.Close()
+	s := newTestServer()
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	addr := s.Addr()
+	s.SetAddress(inUse.Addr().String())
+	var bindErr *BindError
+	if err := s.Restart(context.Background()); !errors.As(err, &bindErr) {
+		t.Errorf("Restart: %v, want a BindError", err)
+	}
+	if s.Addr() != addr || s.State() != StateRunning {
+		t.Errorf("Addr %q state %v after a failed restart", s.Addr(), s.State())
+	}
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("current address after a failed restart: %v", err)
+	}
+	resp.Body.Close()
+}
+
+// TestRestartServe restarts a server given a listener by Serve: the
+// listener is kept.
+func TestRestartServe(t *testing.T) {
+	s := newTestServer()
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	s.SetAddress("127.0.0.1:0")
+	if err := s.Restart(context.Background()); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	if s.Addr() != l.Addr().String() {
+		t.Errorf("Addr %q, want the listener of Serve %s", s.Addr(), l.Addr())
+	}
+	resp, err := http.Get("http://" + l.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,158 @@
+package serverlib
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	// Pattern is the pattern the route was registered with, e.g. "GET /users/{id}".
+	Pattern string
+	// Method is the method of the pattern, e.g. "GET", empty for every method.
+	Method string
+	// Path is the pattern without its method, e.g. "/users/{id}", host included.
+	Path string
+	// Host is the host pattern of the router of the route, see Server.Host,
+	// empty for the default routes.
+	Host string
+	// Handler is the name of the handler, e.g. "main.(*API).getUser", or its
+	// type when it is not a function.
+	Handler string
+	// Mounted reports a handler mounted under Path, serving the whole
+	// subtree, see Server.Mount.
+	Mounted bool
+	// Name is the name of the route, see Route.Name.
+	Name string
+	// Middlewares are the names of the middlewares of the route, outermost
+	// first, see HandleFunc; the ones of Use wrap every route.
+	Middlewares []string
+	// Security lists the security requirements declared by the middlewares
+	// wrapping the handler, see RouteSecurity.
+	Security Security
+}
+
+// addRoute records a registered route with the security requirements of its handler.
+func (s *Server) addRoute(pattern string, handler http.Handler) {
+	s.addHandlerRoute(pattern, handler, handler, nil)
+}
+
+// addHandlerRoute records a registered route: handler is the handler
+// registered, inner the handler or the function wrapped by the middlewares mw.
+func (s *Server) addHandlerRoute(pattern string, handler http.Handler, inner any, mw []Middleware) *Route {
+	route := RouteInfo{Pattern: pattern, Path: pattern, Security: RouteSecurityOf(handler)}
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		route.Method, route.Path = method, strings.TrimLeft(path, " \t")
+	}
+	if inner != nil {
+		route.Handler = handlerName(inner)
+	}
+	for _, m := range mw {
+		route.Middlewares = append(route.Middlewares, handlerName(m))
+	}
+	s.routesMut.Lock()
+	defer s.routesMut.Unlock()
+	s.routes = append(s.routes, route)
+	return &Route{server: s, index: len(s.routes) - 1}
+}
+
+// closureSuffix matches the suffixes of the names of the closures and of the
+// method values, e.g. ".func1" or "-fm".
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$|-fm$`)
+
+// handlerName returns the name of the function v without the closure
+// suffix, e.g. "serverlib.RequireRole", or the type of v.
+func handlerName(v any) string {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Func {
+		return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+	}
+	if value.IsNil() {
+		return ""
+	}
+	fn := runtime.FuncForPC(value.Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := closureSuffix.ReplaceAllString(fn.Name(), "")
+	// The import path, but for the last element.
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		name = name[slash+1:]
+	}
+	return name
+}
+
+// routeCount returns the number of registered routes.
+func (s *Server) routeCount() int {
+	s.routesMut.RLock()
+	defer s.routesMut.RUnlock()
+	return len(s.routes)
+}
+
+// Routes returns the registered routes, in registration order.
+func (s *Server) Routes() []RouteInfo {
+	s.routesMut.RLock()
+	defer s.routesMut.RUnlock()
+	routes := make([]RouteInfo, len(s.routes))
+	copy(routes, s.routes)
+	for i := range routes {
+		routes[i].Middlewares = append([]string(nil), routes[i].Middlewares...)
+		routes[i].Security = routes[i].Security.clone()
+	}
+	return routes
+}
+
+// RoutesTable returns the registered routes as an aligned text table, e.g.
+// to log them at startup:
+//
+//	METHOD  PATH         HANDLER       MIDDLEWARES
+//	GET     /users/{id}  main.getUser  serverlib.RequireAuth
+func (s *Server) RoutesTable() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER\tMIDDLEWARES")
+	for _, route := range s.Routes() {
+		method := route.Method
+		if method == "" {
+			method = "*"
+		}
+		path := route.Host + route.Path
+		if route.Mounted {
+			path += "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", method, path, route.Handler, strings.Join(route.Middlewares, ", "))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// AuditRoutes checks every registered route against the policy, e.g. "every
+// route outside /public requires authentication", and returns the failures.
+// It is meant to run at startup or in a test:
+//
+//	errs := server.AuditRoutes(func(route serverlib.RouteInfo) error {
+//		if !route.Security.Auth && !strings.Contains(route.Pattern, "/public/") {
+//			return errors.New("no authentication")
+//		}
+//		return nil
+//	})
+//
+// Parameters:
+//   - policy: Returns an error for the routes breaking the rules.
+//
+// Returns:
+//   - []error: The failures, each naming its route pattern; nil if every route complies.
+func (s *Server) AuditRoutes(policy func(RouteInfo) error) []error {
+	var errs []error
+	for _, route := range s.Routes() {
+		if err := policy(route); err != nil {
+			errs = append(errs, fmt.Errorf("route %q: %w", route.Pattern, err))
+		}
+	}
+	return errs
+}
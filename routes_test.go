@@ -0,0 +1,83 @@
+package serverlib
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func listItems(w http.ResponseWriter, r *http.Request) {}
+
+// itemsAPI has the handlers of the routes as methods.
+type itemsAPI struct{}
+
+func (itemsAPI) get(w http.ResponseWriter, r *http.Request) {}
+
+// itemsHandler is a handler which is not a function.
+type itemsHandler struct{}
+
+func (*itemsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+// TestRoutes describes the registered routes, with the names of their
+// handlers and middlewares.
+func TestRoutes(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Use(tag("use"))
+	var api itemsAPI
+	s.GET("/items", listItems, RequireAuth(), tag("audit"))
+	s.GET("/items/{id}", api.get)
+	s.HandleFunc("/closure", func(w http.ResponseWriter, r *http.Request) {})
+	s.Handle("POST /items", &itemsHandler{})
+	s.Handle("PUT  /items/{id}", http.HandlerFunc(listItems), RequireRole("admin"))
+	want := []RouteInfo{
+		{Pattern: "GET /items", Method: "GET", Path: "/items", Handler: "serverlib.listItems", Middlewares: []string{"serverlib.RequireAuth", "serverlib.tag"}, Security: Security{Auth: true}},
+		{Pattern: "GET /items/{id}", Method: "GET", Path: "/items/{id}", Handler: "serverlib.itemsAPI.get"},
+		{Pattern: "/closure", Path: "/closure", Handler: "serverlib.TestRoutes"},
+		{Pattern: "POST /items", Method: "POST", Path: "/items", Handler: "serverlib.itemsHandler"},
+		{Pattern: "PUT  /items/{id}", Method: "PUT", Path: "/items/{id}", Handler: "serverlib.listItems", Middlewares: []string{"serverlib.RequireRole"}, Security: Security{Auth: true, Roles: []string{"admin"}}},
+	}
+	if got := s.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("routes:\n%+v\nwant\n%+v", got, want)
+	}
+	// The slices of the routes returned are copies.
+	s.Routes()[0].Middlewares[0] = "changed"
+	if got := s.Routes()[0].Middlewares[0]; got != "serverlib.RequireAuth" {
+		t.Errorf("middleware %q once the copy changed", got)
+	}
+}
+
+// TestRoutesEndpoints checks the endpoints registered by the server are
+// described with their handlers.
+func TestRoutesEndpoints(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.EnableHealthEndpoints()
+	s.EnablePprof("", tag("admin"))
+	handlers := map[string]string{}
+	for _, route := range s.Routes() {
+		handlers[route.Pattern] = route.Handler + " " + strings.Join(route.Middlewares, ",")
+	}
+	for pattern, want := range map[string]string{
+		"GET /healthz":             "serverlib.(*Server).serveLiveness ",
+		"GET /debug/pprof/{$}":     "pprof.Index serverlib.tag",
+		"GET /debug/pprof/{name}":  "serverlib.servePprofProfile serverlib.tag",
+		"POST /debug/pprof/symbol": "pprof.Symbol serverlib.tag",
+	} {
+		if got := handlers[pattern]; got != want {
+			t.Errorf("%s: %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+// TestRoutesTable renders the routes as an aligned table.
+func TestRoutesTable(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.GET("/items/{id}", listItems, RequireAuth(), tag("audit"))
+	s.HandleFunc("/any", listItems)
+	want := "METHOD  PATH         HANDLER              MIDDLEWARES\n" +
+		"GET     /items/{id}  serverlib.listItems  serverlib.RequireAuth, serverlib.tag\n" +
+		"*       /any         serverlib.listItems  \n"
+	if got := s.RoutesTable(); got != want {
+		t.Errorf("table:\n%s\nwant\n%s", got, want)
+	}
+}
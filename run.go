@@ -0,0 +1,127 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is the graceful shutdown timeout of Stop,
+// StartContext and Run when ServerConfig.ShutdownTimeout is not set.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// ShutdownTimeoutError is returned by Stop, StartContext and Run when the
+// graceful shutdown exceeded ServerConfig.ShutdownTimeout and the connections
+// still open were closed.
+type ShutdownTimeoutError struct {
+	Timeout time.Duration
+	// Connections is the number of connections closed, their requests dropped.
+	Connections int
+	// Err is the error of Shutdown.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ShutdownTimeoutError) Error() string {
+	return fmt.Sprintf("graceful shutdown exceeded %s, %d connections cut off: %v", e.Timeout, e.Connections, e.Err)
+}
+
+// Unwrap returns the error of Shutdown, wrapping context.DeadlineExceeded.
+func (e *ShutdownTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// shutdownWithin shuts the server down gracefully within timeout, then
+// closes the connections still open, once claimed by beginStop.
+func (s *Server) shutdownWithin(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	cut := int(s.conns.active.Load())
+	slog.Error("Graceful shutdown timed out, closing the connections", "timeout", timeout, "connections", cut)
+	if closeErr := s.close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return &ShutdownTimeoutError{Timeout: timeout, Connections: cut, Err: err}
+}
+
+// Run starts the server and blocks until SIGINT or SIGTERM, then shuts it
+// down gracefully, see RunContext.
+func (s *Server) Run() error {
+	return s.RunContext(context.Background())
+}
+
+// RunContext warms the server up (see Warmup), starts it and blocks until
+// SIGINT, SIGTERM or the end of ctx, then shuts it down gracefully (see
+// Shutdown) within ServerConfig.ShutdownTimeout. A second signal during the
+// shutdown kills the process.
+//
+// Parameters:
+//   - ctx: The context ending the server, in addition to the signals.
+//
+// Returns:
+//   - error: The error of Warmup, of Start, e.g. when the address is in use,
+//     or of Shutdown; nil after a clean shutdown.
+func (s *Server) RunContext(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Restore the default behavior on the first signal: a second one kills the process.
+	context.AfterFunc(ctx, stop)
+	err := s.running(func() error {
+		if err := s.warmupBeforeStart(ctx); err != nil {
+			return err
+		}
+		return s.startContext(ctx)
+	})
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveUntil runs serve until it returns, or until ctx is done to shut the
+// server down gracefully.
+func (s *Server) serveUntil(ctx context.Context, serve func() error) error {
+	if ctx.Done() == nil {
+		return serve()
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- serve()
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	timeout := s.config.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	if !s.beginStop() {
+		// Stopped meanwhile by Shutdown or Stop.
+		return <-served
+	}
+	defer s.stopped()
+	slog.Info("Shutdown requested", "cause", context.Cause(ctx), "timeout", timeout)
+	// A negative timeout closes the connections right away, as Stop does.
+	var err error
+	if timeout < 0 {
+		err = s.close()
+	} else {
+		err = s.shutdownWithin(timeout)
+	}
+	if serveErr := <-served; serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
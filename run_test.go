@@ -0,0 +1,49 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// TestRunContext ends RunContext with its context and with Stop: both
+// return nil once the server is stopped, and a failed bind is returned.
+func TestRunContext(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(s *Server, cancel context.CancelFunc)
+	}{
+		{"context done", func(_ *Server, cancel context.CancelFunc) { cancel() }},
+		{"Stop", func(s *Server, _ context.CancelFunc) { s.Stop() }},
+		{"Shutdown", func(s *Server, _ context.CancelFunc) { s.Shutdown(context.Background()) }},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.RunContext(ctx)
+		}()
+		<-s.Ready()
+		tt.stop(s, cancel)
+		if err := waitStart(t, done); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if state := s.State(); state != StateStopped {
+			t.Errorf("%s: state %v", tt.name, state)
+		}
+		cancel()
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := NewServer(ServerConfig{Address: l.Addr().String()})
+	var bindErr *BindError
+	if err := s.RunContext(context.Background()); !errors.As(err, &bindErr) || bindErr.Address != l.Addr().String() {
+		t.Errorf("address in use: %v", err)
+	}
+}
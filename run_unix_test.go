@@ -0,0 +1,32 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serverlib
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestRunSignal sends SIGTERM and SIGINT to the process: Run shuts the
+// server down gracefully and returns nil.
+func TestRunSignal(t *testing.T) {
+	for _, signal := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		s := newTestServer()
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Run()
+		}()
+		// The signals are caught before the server is ready.
+		<-s.Ready()
+		if err := syscall.Kill(os.Getpid(), signal); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitStart(t, done); err != nil {
+			t.Errorf("%v: %v", signal, err)
+		}
+		if state := s.State(); state != StateStopped {
+			t.Errorf("%v: state %v", signal, state)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package serverlib
+
+import (
+	"bufio"
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ExpvarName is the expvar variable publishing the stats of the running
+// servers, by address, see Server.Stats.
+const ExpvarName = "serverlib"
+
+// ResponseCounts are the responses served by status class.
+type ResponseCounts struct {
+	Informational uint64 `json:"1xx"`
+	Successful    uint64 `json:"2xx"`
+	Redirection   uint64 `json:"3xx"`
+	ClientError   uint64 `json:"4xx"`
+	ServerError   uint64 `json:"5xx"`
+}
+
+// requestStats counts the requests and the responses of the server.
+type requestStats struct {
+	requests atomic.Uint64
+	classes  [5]atomic.Uint64
+	bytes    atomic.Uint64
+}
+
+// responses returns the counts of the responses by class.
+func (st *requestStats) responses() ResponseCounts {
+	return ResponseCounts{
+		Informational: st.classes[0].Load(),
+		Successful:    st.classes[1].Load(),
+		Redirection:   st.classes[2].Load(),
+		ClientError:   st.classes[3].Load(),
+		ServerError:   st.classes[4].Load(),
+	}
+}
+
+// record counts the response written to w, once complete.
+func (st *requestStats) record(w *statsWriter) {
+	st.requests.Add(1)
+	st.bytes.Add(w.bytes)
+	if w.hijacked {
+		return
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if class := status/100 - 1; class >= 0 && class < len(st.classes) {
+		st.classes[class].Add(1)
+	}
+}
+
+// statsWriter records the status and the size of a response for the stats.
+type statsWriter struct {
+	http.ResponseWriter
+	server   *Server
+	status   int
+	bytes    uint64
+	hijacked bool
+}
+
+func (w *statsWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statsWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += uint64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher for the handlers asserting it.
+func (w *statsWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for the handlers asserting it, e.g. the
+// websockets.
+func (w *statsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *statsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// published are the running servers published under ExpvarName.
+var published = struct {
+	once    sync.Once
+	servers sync.Map
+}{}
+
+// publish adds the stats of the server to ExpvarName, once started.
+func (s *Server) publish() {
+	published.once.Do(func() {
+		expvar.Publish(ExpvarName, expvar.Func(func() any {
+			stats := map[string]ServerStats{}
+			published.servers.Range(func(key, value any) bool {
+				server := key.(*Server)
+				stats[server.Addr()] = server.Stats()
+				return true
+			})
+			return stats
+		}))
+	})
+	published.servers.Store(s, struct{}{})
+}
+
+// unpublish removes the stats of the server from ExpvarName, once stopped.
+func (s *Server) unpublish() {
+	published.servers.Delete(s)
+}
@@ -0,0 +1,119 @@
+package serverlib
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStatsResponses counts the responses served by status class, and the
+// size of their bodies.
+func TestStatsResponses(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := NewServer(ServerConfig{})
+	s.GET("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	s.GET("/empty", func(w http.ResponseWriter, r *http.Request) {})
+	s.GET("/hints", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.GET("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	s.GET("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	targets := []string{"/ok", "/ok", "/empty", "/hints", "/moved", "/missing", "/panic"}
+	var written uint64
+	for _, target := range targets {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		written += uint64(w.Body.Len())
+	}
+	stats := s.Stats()
+	want := ResponseCounts{Successful: 4, Redirection: 1, ClientError: 1, ServerError: 1}
+	if stats.Requests != uint64(len(targets)) || stats.Responses != want {
+		t.Errorf("requests %d responses %+v, want %d %+v", stats.Requests, stats.Responses, len(targets), want)
+	}
+	if stats.BytesWritten != written {
+		t.Errorf("%d bytes written, want %d", stats.BytesWritten, written)
+	}
+}
+
+// TestStatsHijacked checks a hijacked connection counts as a request, but
+// not as a response.
+func TestStatsHijacked(t *testing.T) {
+	s := newTestServer()
+	s.GET("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		rw.Flush()
+		conn.Close()
+	})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// The request is recorded once the handler returned, after the response.
+	var stats ServerStats
+	for deadline := time.Now().Add(10 * time.Second); stats.Requests == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		stats = s.Stats()
+	}
+	if stats.Requests != 1 || stats.Responses != (ResponseCounts{}) {
+		t.Errorf("requests %d responses %+v, want 1 and none", stats.Requests, stats.Responses)
+	}
+}
+
+// TestExpvar publishes the stats of the running servers by address, until
+// they stop.
+func TestExpvar(t *testing.T) {
+	s := newTestServer()
+	if uptime := s.Stats().Uptime; uptime != 0 {
+		t.Errorf("uptime %s before the start", uptime)
+	}
+	done := startServer(s)
+	<-s.Ready()
+	if uptime := s.Stats().Uptime; uptime <= 0 {
+		t.Errorf("uptime %s once started", uptime)
+	}
+	v := expvar.Get(ExpvarName)
+	if v == nil {
+		t.Fatal("not published once started")
+	}
+	if published := v.String(); !strings.Contains(published, `"`+s.Addr()+`"`) || !strings.Contains(published, `"2xx"`) {
+		t.Errorf("running server not published: %s", published)
+	}
+	addr := s.Addr()
+	s.Stop()
+	waitStart(t, done)
+	if published := v.String(); strings.Contains(published, `"`+addr+`"`) {
+		t.Errorf("stopped server published: %s", published)
+	}
+}
@@ -0,0 +1,292 @@
+// Package scratch allocates temporary directories tied to the lifetime of a
+// request or of a background task: created on first use, bounded by a size
+// quota, and removed once the request or the task completes.
+package scratch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Morditux/serverlib/backoff"
+	"github.com/Morditux/serverlib/units"
+)
+
+// Defaults of the options.
+const (
+	DefaultCheckInterval = time.Second
+	DefaultOrphanAge     = 24 * time.Hour
+)
+
+// dirPrefix prefixes the names of the directories of the scopes, so that the
+// orphan sweep leaves the other entries of the root alone.
+const dirPrefix = "scratch-"
+
+// removeBackoff spaces the attempts to remove a directory, whose files may
+// still be busy, e.g. on Windows.
+var removeBackoff = backoff.New(backoff.Options{Initial: 50 * time.Millisecond, MaxAttempts: 5})
+
+// ErrNoScope is returned by Dir for a context without a scope.
+var ErrNoScope = errors.New("scratch: no temporary directory scope in the context")
+
+// QuotaError is returned by Dir, and Scope.Err, once the directory grew over
+// its quota.
+type QuotaError struct {
+	Dir   string
+	Size  int64
+	Quota int64
+}
+
+// Error implements the error interface.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("scratch: %s holds %s, over its quota of %s", e.Dir, units.FormatBytes(e.Size), units.FormatBytes(e.Quota))
+}
+
+// Options configures the temporary directories.
+type Options struct {
+	// Root is the directory the temporary directories are created in.
+	// Defaults to the "serverlib" directory of os.TempDir.
+	Root string
+	// Quota, if positive, is the size in bytes a directory may hold.
+	Quota int64
+	// CheckInterval is the interval the size of a directory is checked at
+	// against Quota. Defaults to DefaultCheckInterval.
+	CheckInterval time.Duration
+	// OrphanAge is the age of the directories removed by Sweep, left behind
+	// by a crash. Defaults to DefaultOrphanAge.
+	OrphanAge time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Root == "" {
+		o.Root = filepath.Join(os.TempDir(), "serverlib")
+	}
+	if o.CheckInterval <= 0 {
+		o.CheckInterval = DefaultCheckInterval
+	}
+	if o.OrphanAge <= 0 {
+		o.OrphanAge = DefaultOrphanAge
+	}
+	return o
+}
+
+var (
+	defaults    = Options{}.withDefaults()
+	defaultsMut sync.RWMutex
+)
+
+// SetDefaults sets the options of the scopes created by NewScope without
+// options, such as the ones of the background tasks.
+func SetDefaults(options Options) {
+	defaultsMut.Lock()
+	defer defaultsMut.Unlock()
+	defaults = options.withDefaults()
+}
+
+// Defaults returns the options set by SetDefaults.
+func Defaults() Options {
+	defaultsMut.RLock()
+	defer defaultsMut.RUnlock()
+	return defaults
+}
+
+// Scope is the temporary directory of a request or of a task, created on the
+// first call to Dir and removed by Close.
+type Scope struct {
+	options Options
+	name    string
+	dir     string
+	err     error
+	closed  bool
+	stop    chan struct{}
+	mut     *sync.Mutex
+}
+
+// NewScope creates a scope; its directory is not created yet.
+//
+// Parameters:
+//   - name: The kind of the scope, e.g. "request" or "task", in the name of
+//     the directory.
+//   - options: Optional options, Defaults when omitted.
+func NewScope(name string, options ...Options) *Scope {
+	opts := Defaults()
+	if len(options) > 0 {
+		opts = options[0].withDefaults()
+	}
+	return &Scope{options: opts, name: name, mut: &sync.Mutex{}}
+}
+
+// Dir returns the directory of the scope, created on the first call.
+//
+// Returns:
+//   - string: The path of the directory.
+//   - error: The error creating it, a *QuotaError once it grew over its
+//     quota, or fs.ErrClosed after Close.
+func (s *Scope) Dir() (string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.closed {
+		return "", fs.ErrClosed
+	}
+	if s.err != nil {
+		return s.dir, s.err
+	}
+	if s.dir != "" {
+		return s.dir, nil
+	}
+	if err := os.MkdirAll(s.options.Root, 0o700); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(s.options.Root, dirPrefix+s.name+"-")
+	if err != nil {
+		return "", err
+	}
+	s.dir = dir
+	if s.options.Quota > 0 {
+		s.stop = make(chan struct{})
+		go s.checkQuota(dir, s.stop)
+	}
+	return dir, nil
+}
+
+// Err returns the *QuotaError of a directory grown over its quota, or nil.
+func (s *Scope) Err() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.err
+}
+
+// checkQuota checks the size of the directory periodically until Close.
+func (s *Scope) checkQuota(dir string, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.options.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		size := dirSize(dir)
+		if size <= s.options.Quota {
+			continue
+		}
+		err := &QuotaError{Dir: dir, Size: size, Quota: s.options.Quota}
+		slog.Warn("Temporary directory over quota", "dir", dir, "size", units.FormatBytes(size), "quota", units.FormatBytes(s.options.Quota))
+		s.mut.Lock()
+		s.err = err
+		s.mut.Unlock()
+		return
+	}
+}
+
+// dirSize returns the size of the files of dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// Close removes the directory, if created, in the background: a file still
+// busy is retried a few times before the failure is logged, the directory
+// being left to the orphan sweep.
+func (s *Scope) Close() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.stop != nil {
+		close(s.stop)
+	}
+	if s.dir == "" {
+		return
+	}
+	go remove(s.dir)
+}
+
+// remove removes dir, retrying with growing delays.
+func remove(dir string) {
+	err := removeBackoff.Retry(context.Background(), func(context.Context) error {
+		return os.RemoveAll(dir)
+	})
+	if err != nil {
+		slog.Error("Temporary directory not removed", "dir", dir, "error", err)
+	}
+}
+
+type scopeKey struct{}
+
+// WithScope returns a copy of ctx holding the scope, see Dir.
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// FromContext returns the scope of ctx, or nil.
+func FromContext(ctx context.Context) *Scope {
+	scope, _ := ctx.Value(scopeKey{}).(*Scope)
+	return scope
+}
+
+// Dir returns the temporary directory of the request or the task of ctx,
+// see Scope.Dir.
+func Dir(ctx context.Context) (string, error) {
+	scope := FromContext(ctx)
+	if scope == nil {
+		return "", ErrNoScope
+	}
+	return scope.Dir()
+}
+
+// Sweep removes the directories of the root older than Options.OrphanAge,
+// left behind by a previous process that crashed.
+//
+// Parameters:
+//   - options: The options of the directories.
+//
+// Returns:
+//   - int: The number of directories removed.
+//   - error: The error reading the root; a missing root is not an error.
+func Sweep(options Options) (int, error) {
+	options = options.withDefaults()
+	entries, err := os.ReadDir(options.Root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), dirPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < options.OrphanAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(options.Root, entry.Name())); err != nil {
+			slog.Warn("Orphaned temporary directory not removed", "dir", entry.Name(), "error", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,171 @@
+package scratch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// removed waits for the background removal of dir.
+func removed(t *testing.T, dir string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not removed", dir)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScope(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	scope := NewScope("request", Options{Root: root})
+	if _, err := os.Stat(root); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("root created before Dir: %v", err)
+	}
+	dir, err := scope.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != root || !strings.HasPrefix(filepath.Base(dir), "scratch-request-") {
+		t.Errorf("directory %s, want a scratch-request- directory of %s", dir, root)
+	}
+	if again, err := scope.Dir(); again != dir || err != nil {
+		t.Errorf("second Dir: %s %v, want %s", again, err, dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "nested"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "nested", "converted.pdf"), []byte("pdf"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	scope.Close()
+	scope.Close()
+	removed(t, dir)
+	if _, err := scope.Dir(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Dir after Close: %v, want fs.ErrClosed", err)
+	}
+	// A scope whose directory was never asked for creates nothing.
+	unused := NewScope("task", Options{Root: filepath.Join(t.TempDir(), "unused")})
+	unused.Close()
+	if _, err := os.Stat(unused.options.Root); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unused scope: %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, err := Dir(context.Background()); !errors.Is(err, ErrNoScope) {
+		t.Errorf("without scope: %v, want ErrNoScope", err)
+	}
+	scope := NewScope("task", Options{Root: t.TempDir()})
+	defer scope.Close()
+	ctx := WithScope(context.Background(), scope)
+	if FromContext(ctx) != scope {
+		t.Error("FromContext: not the scope")
+	}
+	dir, err := Dir(ctx)
+	if want, _ := scope.Dir(); err != nil || dir != want {
+		t.Errorf("Dir: %s %v, want %s", dir, err, want)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   int64
+		write   int
+		wantErr bool
+	}{
+		{"under the quota", 100, 100, false},
+		{"over the quota", 100, 101, true},
+		{"no quota", 0, 1000, false},
+	}
+	for _, tt := range tests {
+		scope := NewScope("request", Options{Root: t.TempDir(), Quota: tt.quota, CheckInterval: time.Millisecond})
+		dir, err := scope.Dir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "out"), make([]byte, tt.write), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if !tt.wantErr {
+			time.Sleep(20 * time.Millisecond)
+			if err := scope.Err(); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			scope.Close()
+			continue
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for scope.Err() == nil && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		var quotaErr *QuotaError
+		if !errors.As(scope.Err(), &quotaErr) || quotaErr.Dir != dir || quotaErr.Size != int64(tt.write) || quotaErr.Quota != tt.quota {
+			t.Errorf("%s: %v, want a *QuotaError", tt.name, scope.Err())
+		}
+		if got, err := scope.Dir(); got != dir || !errors.As(err, &quotaErr) {
+			t.Errorf("%s: Dir %s %v, want the directory and the *QuotaError", tt.name, got, err)
+		}
+		scope.Close()
+		removed(t, dir)
+	}
+}
+
+// TestSweep removes the scratch directories older than the orphan age,
+// leaving the recent ones and the other entries of the root alone.
+func TestSweep(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		name string
+		dir  bool
+		// old is false for an entry modified now.
+		old         bool
+		wantRemoved bool
+	}{
+		{"scratch-request-old", true, true, true},
+		{"scratch-task-old", true, true, true},
+		{"scratch-request-recent", true, false, false},
+		{"other-old", true, true, false},
+		{"scratch-file-old", false, true, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(root, tt.name)
+		if tt.dir {
+			if err := os.MkdirAll(filepath.Join(path, "nested"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if tt.old {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	n, err := Sweep(Options{Root: root, OrphanAge: time.Hour})
+	if err != nil || n != 2 {
+		t.Errorf("Sweep: %d %v, want 2 removed", n, err)
+	}
+	for _, tt := range tests {
+		_, err := os.Stat(filepath.Join(root, tt.name))
+		if gotRemoved := errors.Is(err, fs.ErrNotExist); gotRemoved != tt.wantRemoved {
+			t.Errorf("%s: removed %v, want %v", tt.name, gotRemoved, tt.wantRemoved)
+		}
+	}
+	if n, err := Sweep(Options{Root: filepath.Join(root, "missing")}); n != 0 || err != nil {
+		t.Errorf("missing root: %d %v", n, err)
+	}
+}
@@ -0,0 +1,129 @@
+package serverlib
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// Security describes the security requirements of a route. The middlewares
+// enforcing them declare them, so the route registry reflects what actually
+// wraps each handler (see Server.Routes and Server.AuditRoutes).
+type Security struct {
+	// Auth is set when the route requires an authenticated principal.
+	Auth bool
+	// Schemes names the authentication schemes, e.g. "mtls".
+	Schemes []string
+	// Roles are the roles accepted by the route; any of them grants access.
+	Roles []string
+	// CSRF is set when the route checks CSRF tokens.
+	CSRF bool
+	// RateLimits names the rate and concurrency limits applied to the route.
+	RateLimits []string
+}
+
+// merge returns the requirements of s and other combined.
+func (s Security) merge(other Security) Security {
+	return Security{
+		Auth:       s.Auth || other.Auth,
+		Schemes:    union(s.Schemes, other.Schemes),
+		Roles:      union(s.Roles, other.Roles),
+		CSRF:       s.CSRF || other.CSRF,
+		RateLimits: union(s.RateLimits, other.RateLimits),
+	}
+}
+
+// clone returns a copy of s not sharing its slices.
+func (s Security) clone() Security {
+	s.Schemes = slices.Clone(s.Schemes)
+	s.Roles = slices.Clone(s.Roles)
+	s.RateLimits = slices.Clone(s.RateLimits)
+	return s
+}
+
+// union returns the values of a then the values of b not in a.
+func union(a, b []string) []string {
+	result := slices.Clone(a)
+	for _, value := range b {
+		if !slices.Contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// RouteSecurity is implemented by the handlers declaring security requirements.
+type RouteSecurity interface {
+	RouteSecurity() Security
+}
+
+// RouteSecurityOf returns the security requirements declared by handler, none for nil.
+func RouteSecurityOf(handler http.Handler) Security {
+	if secured, ok := handler.(RouteSecurity); ok {
+		return secured.RouteSecurity()
+	}
+	return Security{}
+}
+
+// securedHandler is a handler declaring the requirements of a middleware on
+// top of the ones of the handler it wraps.
+type securedHandler struct {
+	http.Handler
+	security Security
+	next     http.Handler
+}
+
+// RouteSecurity implements RouteSecurity.
+func (h *securedHandler) RouteSecurity() Security {
+	return h.security.merge(RouteSecurityOf(h.next))
+}
+
+// Secured wraps handler so that it declares the given security requirements,
+// in addition to the ones of next. Middlewares enforcing requirements use it
+// to declare them. The returned handler is served by handler.
+//
+// Parameters:
+//   - handler: The handler enforcing the requirements.
+//   - next: The handler wrapped by the middleware.
+//   - security: The requirements enforced by handler.
+func Secured(handler, next http.Handler, security Security) http.Handler {
+	return &securedHandler{Handler: handler, security: security, next: next}
+}
+
+// RequireAuth returns a middleware answering 401 to the requests without an
+// authenticated principal (see reqctx.Principal).
+func RequireAuth() Middleware {
+	return func(next http.Handler) http.Handler {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := reqctx.Principal(r.Context()); !ok {
+				serverOf(r).Error(w, r, NewHTTPError(http.StatusUnauthorized, ""))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+		return Secured(handler, next, Security{Auth: true})
+	}
+}
+
+// RequireRole returns a middleware answering 401 to the requests without an
+// authenticated principal and 403 to the principals having none of the
+// roles (see reqctx.Roles).
+func RequireRole(roles ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := reqctx.Principal(r.Context()); !ok {
+				serverOf(r).Error(w, r, NewHTTPError(http.StatusUnauthorized, ""))
+				return
+			}
+			for _, role := range reqctx.Roles(r.Context()) {
+				if slices.Contains(roles, role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			serverOf(r).Error(w, r, NewHTTPError(http.StatusForbidden, ""))
+		})
+		return Secured(handler, next, Security{Auth: true, Roles: roles})
+	}
+}
@@ -0,0 +1,172 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// plain is a middleware declaring no security requirement.
+func plain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Plain", "1")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identity authenticates the requests from their X-User and X-Roles headers.
+func identity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if user := r.Header.Get("X-User"); user != "" {
+			ctx = reqctx.WithPrincipal(ctx, user)
+		}
+		if roles := r.Header.Get("X-Roles"); roles != "" {
+			ctx = reqctx.WithRoles(ctx, strings.Split(roles, ",")...)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TestRouteSecurity registers routes wrapped in various ways: the registry
+// reports the requirements of every declaring middleware, however deep.
+func TestRouteSecurity(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	limiter := NewConcurrencyLimiter(LimiterOptions{MaxConcurrent: 8})
+	tests := []struct {
+		name     string
+		register func(s *Server)
+		want     Security
+	}{
+		{"no middleware", func(s *Server) { s.GET("/", ok) }, Security{}},
+		{"plain middleware", func(s *Server) { s.GET("/", ok, plain) }, Security{}},
+		{"RequireAuth", func(s *Server) { s.GET("/", ok, RequireAuth()) }, Security{Auth: true}},
+		{"RequireRole", func(s *Server) { s.GET("/", ok, RequireRole("admin")) }, Security{Auth: true, Roles: []string{"admin"}}},
+		{"nested roles", func(s *Server) {
+			s.GET("/", ok, RequireAuth(), RequireRole("admin", "ops"), RequireRole("ops", "audit"))
+		}, Security{Auth: true, Roles: []string{"admin", "ops", "audit"}}},
+		{"below a plain middleware", func(s *Server) { s.GET("/", ok, plain, RequireRole("admin")) }, Security{Auth: true, Roles: []string{"admin"}}},
+		{"between plain middlewares", func(s *Server) {
+			s.GET("/", ok, plain, RequireAuth(), plain, limiter.Wrap, plain)
+		}, Security{Auth: true, RateLimits: []string{"concurrency:8"}}},
+		{"mTLS", func(s *Server) { s.GET("/", ok, MTLSIdentity()) }, Security{Auth: true, Schemes: []string{"mtls"}}},
+		{"optional mTLS", func(s *Server) { s.GET("/", ok, MTLSIdentity(MTLSOptional())) }, Security{Schemes: []string{"mtls"}}},
+		{"client certificate and role", func(s *Server) {
+			s.GET("/", ok, RequireClientCert("ops"), RequireRole("admin"))
+		}, Security{Auth: true, Schemes: []string{"mtls"}, Roles: []string{"admin"}}},
+		{"Handle", func(s *Server) { s.Handle("/", http.HandlerFunc(ok), plain, RequireAuth()) }, Security{Auth: true}},
+		{"secured handler", func(s *Server) { s.Handle("/", RequireRole("admin")(http.HandlerFunc(ok)), RequireAuth()) }, Security{Auth: true, Roles: []string{"admin"}}},
+		{"host route", func(s *Server) { s.Host("admin.example.com").GET("/", ok, plain, RequireRole("admin")) }, Security{Auth: true, Roles: []string{"admin"}}},
+		{"typed", func(s *Server) {
+			HandleTyped(s, "GET /", func(context.Context, struct{}) (string, error) { return "", nil }, WithMiddleware(plain, RequireRole("admin")))
+		}, Security{Auth: true, Roles: []string{"admin"}}},
+		{"mounted", func(s *Server) { s.Mount("/app", RequireAuth()(http.HandlerFunc(ok))) }, Security{Auth: true}},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		tt.register(s)
+		routes := s.Routes()
+		if len(routes) != 1 {
+			t.Fatalf("%s: %d routes, want 1", tt.name, len(routes))
+		}
+		if got := routes[0].Security; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRouteSecurityCopy checks the routes returned by Routes don't share the
+// requirements of the registry.
+func TestRouteSecurityCopy(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.GET("/", func(http.ResponseWriter, *http.Request) {}, RequireRole("admin"))
+	s.Routes()[0].Security.Roles[0] = "guest"
+	if got := s.Routes()[0].Security.Roles; !reflect.DeepEqual(got, []string{"admin"}) {
+		t.Errorf("roles %v after a change of a copy, want [admin]", got)
+	}
+}
+
+// TestRouteSecurityEnforced checks the declaring middlewares enforce what
+// they declare, below the plain middlewares too.
+func TestRouteSecurityEnforced(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.Use(identity)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	s.GET("/account", ok, plain, RequireAuth())
+	s.GET("/admin", ok, RequireRole("admin", "ops"), plain)
+	tests := []struct {
+		path  string
+		user  string
+		roles string
+		want  int
+	}{
+		{"/account", "", "", http.StatusUnauthorized},
+		{"/account", "alice", "", http.StatusOK},
+		{"/admin", "", "admin", http.StatusUnauthorized},
+		{"/admin", "alice", "", http.StatusForbidden},
+		{"/admin", "alice", "audit", http.StatusForbidden},
+		{"/admin", "alice", "audit,ops", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header.Set("X-User", tt.user)
+		r.Header.Set("X-Roles", tt.roles)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want || w.Header().Get("X-Plain") != "1" && w.Code == http.StatusOK {
+			t.Errorf("GET %s as %q with the roles %q: %d, want %d", tt.path, tt.user, tt.roles, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAuditRoutes(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	errPublic := errors.New("no authentication")
+	policy := func(route RouteInfo) error {
+		if !route.Security.Auth && !strings.HasPrefix(route.Path, "/public/") {
+			return errPublic
+		}
+		return nil
+	}
+	tests := []struct {
+		name     string
+		register func(s *Server)
+		want     []string
+	}{
+		{"compliant", func(s *Server) {
+			s.GET("/public/", ok)
+			s.GET("/account", ok, RequireAuth())
+			s.POST("/admin", ok, plain, RequireRole("admin"))
+		}, nil},
+		{"unprotected route", func(s *Server) {
+			s.GET("/public/", ok)
+			s.GET("/account", ok, plain)
+			s.POST("/admin", ok, RequireRole("admin"))
+		}, []string{`route "GET /account": no authentication`}},
+		{"every failure", func(s *Server) {
+			s.GET("/account", ok)
+			s.Handle("/files/", http.FileServer(http.Dir(".")))
+			s.GET("/optional", ok, MTLSIdentity(MTLSOptional()))
+		}, []string{`route "GET /account": no authentication`, `route "/files/": no authentication`, `route "GET /optional": no authentication`}},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		tt.register(s)
+		var got []string
+		for _, err := range s.AuditRoutes(policy) {
+			if !errors.Is(err, errPublic) {
+				t.Errorf("%s: %v doesn't wrap the error of the policy", tt.name, err)
+			}
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
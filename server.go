@@ -1,19 +1,34 @@
 package serverlib
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Morditux/serverlib/i18n"
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/scratch"
 	"github.com/Morditux/serverlib/sessions"
+	"github.com/Morditux/serverlib/tasks"
 	"github.com/Morditux/serverlib/templates"
 	"github.com/google/uuid"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type LogLevel int
@@ -25,60 +40,440 @@ const (
 	Error
 )
 
-// ServerInstance represents the singleton instance of the server.
+// ServerInstance is the default server, the first one created by NewServer
+// unless set by SetDefault. The package level functions, such as GetSession
+// and LogInfo, delegate to it; the requests are always handled by the server
+// that received them, whatever the default.
 var ServerInstance *Server
 
+// defaultMut guards the assignments of ServerInstance.
+var defaultMut sync.Mutex
+
+// SetDefault makes s the default server, see ServerInstance, e.g. the public
+// server of a process also running an admin server.
+func SetDefault(s *Server) {
+	defaultMut.Lock()
+	defer defaultMut.Unlock()
+	ServerInstance = s
+}
+
+type serverKey struct{}
+
+// FromContext returns the server handling the request of ctx, e.g. to reach
+// its sessions, templates or logger from a handler without the default
+// server. The contexts of the connections, see ServerConfig.ConnContext,
+// hold it too.
+//
+// Returns:
+//   - *Server: The server.
+//   - bool: false for a context that didn't come from a server.
+func FromContext(ctx context.Context) (*Server, bool) {
+	s, ok := ctx.Value(serverKey{}).(*Server)
+	return s, ok
+}
+
+// serverOf returns the server handling the request, or the default server
+// for a request that didn't go through one.
+func serverOf(r *http.Request) *Server {
+	if s, ok := FromContext(r.Context()); ok {
+		return s
+	}
+	return ServerInstance
+}
+
+// serverOfWriter returns the server answering with w, found in its response
+// writer chain, or else the default server, see SetDefault.
+func serverOfWriter(w http.ResponseWriter) *Server {
+	if counted, ok := findWriter[*statsWriter](w); ok {
+		return counted.server
+	}
+	return ServerInstance
+}
+
 // Server represents an HTTP server with routing and session management capabilities.
 // It includes an HTTP server, a router for handling HTTP requests, a session manager
 // for managing user sessions, a session key for session security, and a template
 // engine for rendering HTML templates.
 type Server struct {
-	httpServer     *http.Server
-	router         *http.ServeMux
-	sessionManager sessions.Sessions
-	sessionKey     string
-	logger         *log.Logger
-	dateFormat     func(time.Time) string
-	t              *templates.Templates
-	logLevel       LogLevel
+	httpServer      atomic.Pointer[http.Server]
+	router          *http.ServeMux
+	injector        *contextInjector
+	sessionManager  sessions.Sessions
+	sessionKey      string
+	cookie          CookieConfig
+	basePath        string
+	logger          *log.Logger
+	dateFormat      func(time.Time) string
+	t               *templates.Templates
+	logLevel        LogLevel
+	tasks           *tasks.Runner
+	errorHandler    func(http.ResponseWriter, *http.Request, error)
+	profile         Profile
+	devReload       *devReloader
+	problemTypeBase string
+	background      context.Context
+	stopBackground  context.CancelFunc
+	config          ServerConfig
+	started         atomic.Pointer[time.Time]
+	addrs           atomic.Pointer[[]string]
+	certs           atomic.Pointer[certReloader]
+	tickets         *ticketRotator
+	maintenance     atomic.Pointer[maintenance]
+	notFound        atomic.Pointer[http.HandlerFunc]
+	notAllowed      atomic.Pointer[http.HandlerFunc]
+	shuttingDown    atomic.Bool
+	draining        atomic.Bool
+	templatesParsed atomic.Bool
+	warmups         *warmups
+	health          *healthChecks
+	routes          []RouteInfo
+	routesMut       *sync.RWMutex
+	routeNames      map[string]int
+	responseCache   *ResponseCache
+	components      *lifecycle
+	messages        *i18n.Catalog
+	conns           *connLimiter
+	state           State
+	stateMut        *sync.Mutex
+	current         *generation
+	pending         *generation
+	tlsSetup        func(*tls.Config) *tls.Config
+	restartMut      *sync.Mutex
+	proxy           *proxyProtocol
+	connStates      *connTracker
+	keepAlivesOff   atomic.Bool
 }
 
 type ServerConfig struct {
-	Address                      string
+	// Address is the TCP address to listen on, or the path of a unix socket
+	// prefixed with UnixAddressPrefix, e.g. "unix:/run/myapp/http.sock".
+	Address string
+	// DisableGeneralOptionsHandler passes the "OPTIONS *" requests to the
+	// routes instead of answering them with a 200, see
+	// http.Server.DisableGeneralOptionsHandler.
 	DisableGeneralOptionsHandler bool
 	TLSConfig                    *tls.Config
 	ReadTimeout                  time.Duration
 	ReadHeaderTimeout            time.Duration
 	WriteTimeout                 time.Duration
 	IdleTimeout                  time.Duration
-	MaxHeaderBytes               int
+	MaxHeaderBytes               int `unit:"bytes"`
 	ConnState                    func(net.Conn, http.ConnState)
 	ErrorLog                     *log.Logger
 	BaseContext                  func(net.Listener) context.Context
 	ConnContext                  func(ctx context.Context, c net.Conn) context.Context
 	SessionManager               sessions.Sessions
 	SessionKey                   string
+	SessionCookie                CookieConfig
 	DateFormat                   func(time.Time) string
 	LogLevel                     LogLevel
+	Tasks                        *tasks.Runner
+	// BasePath is the path prefix the application is served under, e.g.
+	// "/tools/myapp" behind a reverse proxy. It is added once to the URLs the
+	// server generates (Path, Redirect, the "path" template function, the
+	// task status URLs) and is the default session cookie Path. Routes are
+	// always registered without it.
+	BasePath string
+	// StripBasePath removes BasePath from the incoming request paths before
+	// routing, for proxies forwarding the full path. Requests outside of
+	// BasePath get a 404. Mounted handlers see the stripped path as well.
+	StripBasePath bool
+	// ErrorHandler answers the requests failing with an error, see Server.Error.
+	// Defaults to Server.DefaultErrorHandler.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+	// Profile selects the environment defaults, Production by default.
+	// The Development profile reloads the templates when they change and
+	// refreshes the browsers through the DevReloadPath endpoint.
+	Profile Profile
+	// ProblemTypeBase is the base URL of the problem types of the error
+	// responses, e.g. "https://example.com/problems/". Defaults to "about:blank" types.
+	ProblemTypeBase string
+	// TemplateOverrides provides the tenant specific templates rendered with
+	// the ForTenant render option.
+	TemplateOverrides templates.OverrideProvider
+	// ClientAuth enables the TLS client certificate authentication, applied
+	// to a copy of TLSConfig. See MTLSIdentity and RequireClientCert for the
+	// per-route checks, and ClientCert to read the certificate of a request.
+	ClientAuth *ClientAuth
+	// ConcurrencyLimit limits the number of requests served at once, queueing
+	// the others by priority (see ConcurrencyLimiter). Mounted handlers
+	// bypassing the middlewares are limited too.
+	ConcurrencyLimit *LimiterOptions
+	// ResponseCache configures the response cache wrapping the handlers with
+	// Server.ResponseCache().Wrap. Defaults to the default cache limits.
+	ResponseCache *ResponseCacheOptions
+	// FlagProvider resolves the feature flags of the requests, see FlagEnabled
+	// and the feature template function.
+	FlagProvider FlagProvider
+	// MissingKeys selects how the templates handle references to keys absent
+	// from map data. Defaults to templates.MissingKeysError in the Development
+	// profile, templates.MissingKeysIgnore otherwise; templates.MissingKeysWarn
+	// logs them in production without failing the pages.
+	MissingKeys templates.MissingKeyMode
+	// ShutdownTimeout bounds the graceful shutdown of Stop, StartContext and
+	// Run, the connections still open then being closed. Defaults to
+	// DefaultShutdownTimeout; a negative timeout closes the connections right
+	// away.
+	ShutdownTimeout time.Duration
+	// CircuitBreaker enables the circuit breakers of the routes: a route
+	// failing Threshold times within Window is answered with a 503 until a
+	// probe request succeeds. See Server.CircuitBreakers for the per-route
+	// options and the manual reset.
+	CircuitBreaker *BreakerOptions
+	// SocketMode is the file mode of the unix socket of an Address of the
+	// form "unix:/path/to.sock". Defaults to DefaultSocketMode.
+	SocketMode os.FileMode
+	// HeaderBudget, if not nil, measures the response headers before they are
+	// sent, warns over its soft limit and optionally drops the low priority
+	// cookies, see HeaderBudgetOptions.
+	HeaderBudget *HeaderBudgetOptions
+	// MinifyHTML minifies the HTML templates rendered by Render, RenderRequest
+	// (unless Streaming), RenderStatic and the built-in error pages, see
+	// MinifyHTML.
+	MinifyHTML bool
+	// Listeners are the addresses the server listens on besides Address, with
+	// the same routes, sessions and templates, e.g. ":8443" over TLS next to
+	// ":8080". Shutdown and Stop close them all.
+	Listeners []ListenAddress
+	// AutoTLS configures the certificates obtained automatically by
+	// StartAutoTLS, e.g. from Let's Encrypt.
+	AutoTLS *AutoTLSConfig
+	// RequestLog keeps the summaries of the last requests in memory, listed
+	// by the DevRequestsPath page. It is enabled by default in the
+	// Development profile.
+	RequestLog *RequestLogOptions
+	// TLSReloadInterval, if positive, is the interval the certificate files
+	// given to StartTLS are re-read at, see ReloadTLS.
+	TLSReloadInterval time.Duration
+	// TLSReloadOnSIGHUP re-reads the certificate files given to StartTLS on
+	// SIGHUP, see ReloadTLS.
+	TLSReloadOnSIGHUP bool
+	// EnableH2C serves HTTP/2 over cleartext connections (h2c), e.g. behind a
+	// load balancer terminating TLS, along with HTTP/1.1.
+	EnableH2C bool
+	// TempDir enables the temporary directories of the requests and the
+	// background tasks, see TempDir and tasks.TempDir. The directories older
+	// than OrphanAge are removed on start.
+	TempDir *scratch.Options
+	// MaxConnections, if positive, bounds the open connections of the
+	// server, all listeners together: at the limit, the new connections wait
+	// in the backlog until others close. See Server.Stats.
+	MaxConnections int
+	// MaxInFlightRequests, if positive, bounds the requests served at once:
+	// the requests above it are answered with a 503 and a Retry-After header
+	// right away. See ConcurrencyLimit to queue them by priority instead.
+	MaxInFlightRequests int
+	// DrainGracePeriod is how long Shutdown keeps serving in the draining
+	// state (see Server.Drain) before closing the listeners, for the load
+	// balancer to pull the instance. Zero closes them right away.
+	DrainGracePeriod time.Duration
+	// WarmupConcurrency is the number of warm-up tasks run at once, see
+	// Server.Warmup. Defaults to DefaultWarmupConcurrency.
+	WarmupConcurrency int
+	// WarmupInBackground makes Run start serving without waiting for the
+	// warm-up tasks.
+	WarmupInBackground bool
+	// RedirectHTTP makes StartTLS listen on RedirectHTTPAddr too, redirecting
+	// the requests to the same URL over HTTPS. The redirector is a component
+	// of the server (see Server.Go), stopped with it. StartAutoTLS always
+	// redirects, on AutoTLSConfig.HTTPAddress.
+	RedirectHTTP bool
+	// RedirectHTTPAddr is the address of the redirector of RedirectHTTP.
+	// Defaults to DefaultRedirectHTTPAddr.
+	RedirectHTTPAddr string
+	// Handler, if not nil, serves the requests matching none of the routes
+	// registered with HandleFunc and Handle, behind the middlewares and with
+	// the session of the request, e.g. a chi router, a wrapped http.ServeMux
+	// or an http.HandlerFunc.
+	Handler http.Handler
+	// AcceptProxyProtocol, if not nil, reads the PROXY protocol header, version
+	// 1 or 2, sent by a load balancer such as HAProxy with send-proxy, at the
+	// start of the connections: their RemoteAddr, and Request.RemoteAddr, are
+	// the ones of the clients. A malformed header closes the connection.
+	AcceptProxyProtocol *ProxyProtocolOptions
+	// UpgradeTimeout is how long Server.Upgrade waits for the new process to
+	// be ready. Defaults to DefaultUpgradeTimeout.
+	UpgradeTimeout time.Duration
+	// SocketOptions, if not nil, sets the options of the sockets of the TCP
+	// listeners, e.g. SO_REUSEPORT to run several processes on the same
+	// port. The unix sockets and the inherited listeners are left as is.
+	SocketOptions *SocketOptions
+	// TicketRotation, if not nil, rotates the TLS session ticket keys in
+	// memory, for the forward secrecy of the resumed sessions, instead of
+	// the keys of TLSConfig. The rotation stops with the server.
+	TicketRotation *TicketRotationOptions
+	// TrailingSlash is the policy of the paths matching a route but for a
+	// trailing slash, e.g. "/foo/" for "/foo". Defaults to the redirects of
+	// http.ServeMux, see TrailingSlashPolicy.
+	TrailingSlash TrailingSlashPolicy
 }
 
 type contextInjector struct {
-	mux *http.ServeMux
-	key string
+	mux      *http.ServeMux
+	key      string
+	mounts   []*mount
+	stripped http.Handler
+	limiter  *ConcurrencyLimiter
+	flags    FlagProvider
+	breakers *CircuitBreakers
+	budget   *headerBudget
+	requests *RequestLog
+	scratch  *scratch.Options
+	inFlight inFlight
+	stats    requestStats
+	fallback http.Handler
+	// trailingSlash is ServerConfig.TrailingSlash.
+	trailingSlash TrailingSlashPolicy
+	server        *Server
+	// hosts are the routers of Server.Host, the exact hosts first.
+	hosts []*Router
+	// middlewares are the middlewares of Use, wrapping route in routed.
+	middlewares []Middleware
+	routed      atomic.Pointer[http.Handler]
+	mut         *sync.RWMutex
 }
 
 func newContextInjector(mux *http.ServeMux) *contextInjector {
 	return &contextInjector{
 		mux: mux,
+		mut: &sync.RWMutex{},
+	}
+}
+
+// addMount adds a handler bypassing the session middleware.
+// Like the mux routes, mounts can be added while the server is running.
+func (i *contextInjector) addMount(m *mount) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.mounts = append(i.mounts, m)
+}
+
+// mount returns the mount serving path, or nil.
+func (i *contextInjector) mount(path string) *mount {
+	i.mut.RLock()
+	defer i.mut.RUnlock()
+	for _, m := range i.mounts {
+		if m.matches(path) {
+			return m
+		}
 	}
+	return nil
 }
 
 func (i *contextInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	session, _ := ServerInstance.GetSession(w, r)
-	ctx := r.Context()
-	ctx = context.WithValue(ctx, "session", session)
+	if i.stripped != nil {
+		i.stripped.ServeHTTP(w, r)
+		return
+	}
+	i.serve(w, r)
+}
+
+func (i *contextInjector) serve(w http.ResponseWriter, r *http.Request) {
+	// The values of the request are held by a single pooled state rather
+	// than one context layer each, released once the response is complete.
+	ctx, state := reqctx.Acquire(r.Context())
+	defer state.Release()
+	if s, _ := ctx.Value(serverKey{}).(*Server); s != i.server {
+		// The requests of a started server already hold it, see newHTTPServer.
+		ctx = context.WithValue(ctx, serverKey{}, i.server)
+	}
+	if i.scratch != nil {
+		// Removed once the response is complete, after recoverPanic.
+		scope := scratch.NewScope("request", *i.scratch)
+		defer scope.Close()
+		ctx = scratch.WithScope(ctx, scope)
+	}
+	state.SetRequestID(newRequestID())
+	state.SetClientIP(remoteIP(r))
+	if traceParent := r.Header.Get("Traceparent"); validTraceParent(traceParent) {
+		ctx = reqctx.WithTraceParent(ctx, traceParent)
+	}
 	r = r.WithContext(ctx)
-	i.mux.ServeHTTP(w, r)
+	state.SetRequest(r)
+	if i.budget != nil {
+		budget := i.budget.wrap(w, r)
+		defer budget.finish()
+		w = budget
+	}
+	if i.requests != nil && !strings.HasPrefix(r.URL.Path, "/_dev/") {
+		logged := &requestLogWriter{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			// r is the request as last updated, with the route set by the mux.
+			i.requests.record(logged, r, start)
+		}()
+		w = logged
+	}
+	counted := &statsWriter{ResponseWriter: w, server: i.server}
+	defer i.stats.record(counted)
+	w = counted
+	defer recoverPanic(w, r)
+	if i.server.refuseInMaintenance(w, r) {
+		return
+	}
+	if !i.inFlight.admit(w, r) {
+		return
+	}
+	defer i.inFlight.done()
+	if i.limiter != nil {
+		release, ok := i.limiter.admit(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	if m := i.mount(r.URL.Path); m != nil {
+		if hasExperiments.Load() {
+			r = r.WithContext(withExperiments(w, r, nil))
+			state.SetRequest(r)
+		}
+		m.handler.ServeHTTP(w, r)
+		return
+	}
+	session, _ := i.server.GetSession(w, r)
+	state.SetSession(session)
+	if i.flags != nil {
+		r = r.WithContext(withFlags(r, i.flags, session))
+		state.SetRequest(r)
+	}
+	if hasExperiments.Load() {
+		r = r.WithContext(withExperiments(w, r, session))
+		state.SetRequest(r)
+	}
+	i.chain().ServeHTTP(w, r)
+}
+
+// route serves the request with its route, inside the middlewares of Use.
+func (i *contextInjector) route(w http.ResponseWriter, r *http.Request) {
+	// The route of the request is the Pattern set by the mux, see reqctx.Route.
+	if i.trailingSlash != TrailingSlashDefault && r.URL.Path != "/" {
+		var done bool
+		if r, done = i.normalizeSlash(w, r); done {
+			return
+		}
+	}
+	mux := i.hostMux(r)
+	errorPages := acceptsJSON(r) || acceptsHTML(r) || i.server.hasMissPages()
+	if errorPages || i.breakers != nil || i.fallback != nil {
+		_, pattern := mux.Handler(r)
+		if pattern == "" && i.fallback != nil {
+			// No route matched: ServerConfig.Handler serves the request.
+			i.fallback.ServeHTTP(w, r)
+			return
+		}
+		if pattern == "" && errorPages {
+			// No route matched: the mux answers with a 404 or a 405,
+			// answered by routeMissed instead.
+			w = &routerErrorWriter{ResponseWriter: w, r: r}
+		}
+		if pattern != "" && i.breakers != nil {
+			i.breakers.guard(w, r, pattern, func(w http.ResponseWriter) {
+				mux.ServeHTTP(w, r)
+			})
+			return
+		}
+	}
+	mux.ServeHTTP(w, r)
 }
 
 // NewServer creates a new instance of Server with the provided configuration.
@@ -113,64 +508,486 @@ func NewServer(config ...ServerConfig) *Server {
 	if serverConfig.ErrorLog == nil {
 		serverConfig.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	serverConfig.BasePath = strings.TrimSuffix("/"+strings.Trim(serverConfig.BasePath, "/"), "/")
+	if serverConfig.SessionCookie.Path == "" && serverConfig.BasePath != "" {
+		serverConfig.SessionCookie.Path = serverConfig.BasePath
+	}
+	if serverConfig.Tasks == nil {
+		serverConfig.Tasks = tasks.NewRunner(tasks.Options{TempDir: serverConfig.TempDir})
+	}
 	if serverConfig.DateFormat == nil {
 		serverConfig.DateFormat = func(t time.Time) string {
 			return t.Format(time.ANSIC)
 		}
 	}
-	ServerInstance = &Server{
-		t: templates.NewTemplates(),
-		httpServer: &http.Server{
-			Addr:              serverConfig.Address,
-			Handler:           mux,
-			TLSConfig:         serverConfig.TLSConfig,
-			ReadTimeout:       serverConfig.ReadTimeout,
-			ReadHeaderTimeout: serverConfig.ReadHeaderTimeout,
-			WriteTimeout:      serverConfig.WriteTimeout,
-			IdleTimeout:       serverConfig.IdleTimeout,
-			MaxHeaderBytes:    serverConfig.MaxHeaderBytes,
-			ConnState:         serverConfig.ConnState,
-			ErrorLog:          serverConfig.ErrorLog,
-			BaseContext:       serverConfig.BaseContext,
-			ConnContext:       serverConfig.ConnContext,
-		},
-		router:         mux.mux,
-		sessionManager: serverConfig.SessionManager,
-		sessionKey:     serverConfig.SessionKey,
-		logger:         serverConfig.ErrorLog,
-		dateFormat:     serverConfig.DateFormat,
-		logLevel:       serverConfig.LogLevel,
+	s := &Server{
+		t:               templates.NewTemplates(),
+		router:          mux.mux,
+		injector:        mux,
+		sessionManager:  serverConfig.SessionManager,
+		sessionKey:      serverConfig.SessionKey,
+		cookie:          serverConfig.SessionCookie,
+		basePath:        serverConfig.BasePath,
+		logger:          serverConfig.ErrorLog,
+		dateFormat:      serverConfig.DateFormat,
+		logLevel:        serverConfig.LogLevel,
+		tasks:           serverConfig.Tasks,
+		errorHandler:    serverConfig.ErrorHandler,
+		profile:         serverConfig.Profile,
+		problemTypeBase: serverConfig.ProblemTypeBase,
+		config:          serverConfig,
+		routesMut:       &sync.RWMutex{},
+		routeNames:      map[string]int{},
+		messages:        newMessages(),
+		conns:           newConnLimiter(serverConfig.MaxConnections),
+		stateMut:        &sync.Mutex{},
+		restartMut:      &sync.Mutex{},
+		connStates:      &connTracker{},
+		warmups:         &warmups{mut: &sync.Mutex{}},
+		health:          &healthChecks{mut: &sync.Mutex{}},
+	}
+	s.components = newLifecycle(s)
+	if serverConfig.ClientAuth != nil {
+		serverConfig.TLSConfig = serverConfig.ClientAuth.apply(serverConfig.TLSConfig, s)
+		s.config.TLSConfig = serverConfig.TLSConfig
 	}
+	s.httpServer.Store(s.newHTTPServer(serverConfig, mux))
+	s.background, s.stopBackground = context.WithCancel(context.Background())
+	s.OnWarmup("templates", s.warmTemplates)
 
-	return ServerInstance
+	if serverConfig.ResponseCache == nil {
+		serverConfig.ResponseCache = &ResponseCacheOptions{}
+	}
+	s.responseCache = NewResponseCache(*serverConfig.ResponseCache)
+	mux.flags = serverConfig.FlagProvider
+	if serverConfig.CircuitBreaker != nil {
+		mux.breakers = NewCircuitBreakers(*serverConfig.CircuitBreaker)
+	}
+	if serverConfig.HeaderBudget != nil {
+		mux.budget = newHeaderBudget(*serverConfig.HeaderBudget)
+	}
+	mux.inFlight.max = int64(serverConfig.MaxInFlightRequests)
+	mux.fallback = serverConfig.Handler
+	mux.trailingSlash = serverConfig.TrailingSlash
+	if serverConfig.TicketRotation != nil {
+		s.tickets = newTicketRotator(*serverConfig.TicketRotation)
+	}
+	if serverConfig.AcceptProxyProtocol != nil {
+		s.proxy = newProxyProtocol(*serverConfig.AcceptProxyProtocol, s)
+	}
+	if serverConfig.ConcurrencyLimit != nil {
+		mux.limiter = NewConcurrencyLimiter(*serverConfig.ConcurrencyLimit)
+	}
+	if serverConfig.StripBasePath && serverConfig.BasePath != "" {
+		mux.stripped = stripPrefix(serverConfig.BasePath, http.HandlerFunc(mux.serve))
+	}
+	if serverConfig.TempDir != nil {
+		mux.scratch = serverConfig.TempDir
+	}
+	if serverConfig.EnableH2C {
+		// Both the prior knowledge and the Upgrade: h2c connections are
+		// served over HTTP/2, the other requests over HTTP/1.1 as usual.
+		s.httpServer.Load().Handler = h2c.NewHandler(mux, &http2.Server{
+			IdleTimeout: serverConfig.IdleTimeout,
+		})
+	}
+	if serverConfig.Profile == Development {
+		s.devReload = newDevReloader(s.background.Done())
+		mux.mux.Handle("GET "+DevReloadPath, s.devReload)
+		s.addRoute("GET "+DevReloadPath, nil)
+	}
+	if serverConfig.Profile == Development || serverConfig.RequestLog != nil {
+		var options RequestLogOptions
+		if serverConfig.RequestLog != nil {
+			options = *serverConfig.RequestLog
+		}
+		mux.requests = NewRequestLog(options.Capacity)
+		mux.mux.Handle("GET "+DevRequestsPath, s.serveRequestLog(options.Authorize))
+		s.addRoute("GET "+DevRequestsPath, nil)
+	}
+	if serverConfig.MissingKeys == templates.MissingKeysDefault {
+		serverConfig.MissingKeys = templates.MissingKeysIgnore
+		if serverConfig.Profile == Development {
+			serverConfig.MissingKeys = templates.MissingKeysError
+		}
+	}
+	s.t.SetMissingKeys(serverConfig.MissingKeys)
+	s.t.SetDefaults(builtinTemplates(), builtinPrefix)
+	if serverConfig.TemplateOverrides != nil {
+		s.t.SetOverrideProvider(serverConfig.TemplateOverrides)
+	}
+	s.t.AddFuncs(template.FuncMap{
+		"path":            s.Path,
+		"url":             s.URL,
+		"devReloadScript": s.devReloadScript,
+		"formToken":       formTokenField,
+		"feature":         featureFlag,
+		"experiment":      experimentVariant,
+	})
+
+	mux.server = s
+	defaultMut.Lock()
+	defer defaultMut.Unlock()
+	if ServerInstance == nil {
+		ServerInstance = s
+	}
+	return s
+}
+
+// NewServerE is NewServer checking the configuration first, see
+// ServerConfig.Validate, so that an invalid address fails here rather than at
+// Start.
+//
+// Parameters:
+//   - config: Optional variadic parameter of type ServerConfig, as for NewServer.
+//
+// Returns:
+//   - *Server: The new server, nil on error.
+//   - error: The error of ServerConfig.Validate.
+func NewServerE(config ...ServerConfig) (*Server, error) {
+	if len(config) > 0 {
+		if err := config[0].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return NewServer(config...), nil
+}
+
+// newHTTPServer returns the http.Server of the configuration, see
+// NewServer and Restart. Its base context holds the server, see FromContext,
+// on top of the one of ServerConfig.BaseContext, and its connections are
+// counted, see ConnStats, before ServerConfig.ConnState is called.
+func (s *Server) newHTTPServer(config ServerConfig, handler http.Handler) *http.Server {
+	connState := func(conn net.Conn, state http.ConnState) {
+		s.connStates.track(conn, state)
+		if config.ConnState != nil {
+			config.ConnState(conn, state)
+		}
+	}
+	baseContext := func(l net.Listener) context.Context {
+		ctx := context.Background()
+		if config.BaseContext != nil {
+			ctx = config.BaseContext(l)
+		}
+		return context.WithValue(ctx, serverKey{}, s)
+	}
+	return &http.Server{
+		Addr:                         config.Address,
+		Handler:                      handler,
+		DisableGeneralOptionsHandler: config.DisableGeneralOptionsHandler,
+		TLSConfig:                    config.TLSConfig,
+		ReadTimeout:                  config.ReadTimeout,
+		ReadHeaderTimeout:            config.ReadHeaderTimeout,
+		WriteTimeout:                 config.WriteTimeout,
+		IdleTimeout:                  config.IdleTimeout,
+		MaxHeaderBytes:               config.MaxHeaderBytes,
+		ConnState:                    connState,
+		ErrorLog:                     config.ErrorLog,
+		BaseContext:                  baseContext,
+		ConnContext:                  config.ConnContext,
+	}
 }
 
-// Start starts the server.
+// ErrNoCertificate is returned by StartTLS when neither the certificate files
+// nor the certificates of ServerConfig.TLSConfig are provided.
+var ErrNoCertificate = errors.New("no TLS certificate: provide the certificate and key files or the TLSConfig certificates")
+
+// Start starts the server. It blocks until the server stops, and returns
+// http.ErrServerClosed after Stop or Shutdown, or ErrAlreadyRunning when the
+// server runs already, see State.
 func (s *Server) Start() error {
-	slog.Info("Server started", "address", s.httpServer.Addr)
-	err := ServerInstance.t.Parse()
+	return s.StartContext(context.Background())
+}
+
+// StartContext starts the server like Start, and shuts it down gracefully
+// (see Shutdown) within ServerConfig.ShutdownTimeout once ctx is done, e.g.
+// to run it in an errgroup with other components.
+//
+// Parameters:
+//   - ctx: The context ending the server.
+//
+// Returns:
+//   - error: nil after a clean shutdown on the end of ctx, otherwise the error
+//     of Start or of Shutdown.
+func (s *Server) StartContext(ctx context.Context) error {
+	return s.running(func() error {
+		return s.startContext(ctx)
+	})
+}
+
+func (s *Server) startContext(ctx context.Context) error {
+	listeners, err := s.listenAll(":http", false)
 	if err != nil {
 		return err
 	}
-	return s.httpServer.ListenAndServe()
+	return s.serveUntil(ctx, func() error {
+		return s.serveAll(listeners, "", "")
+	})
 }
 
-// Stop stops the server.
+// Serve starts the server on a listener bound by the caller, e.g. on
+// "127.0.0.1:0" in tests or inherited from a socket activation, like Start.
+// The listener is closed when the server stops.
+//
+// Parameters:
+//   - l: The listener accepting the connections.
+//
+// Returns:
+//   - error: Like Start.
+func (s *Server) Serve(l net.Listener) error {
+	return s.running(func() error {
+		return s.serveAll([]boundListener{{Listener: l}}, "", "")
+	})
+}
+
+// Addr returns the address the server listens on, with the actual port once
+// started on port 0, or the configured address before. With
+// ServerConfig.Listeners, it is the first one, see Addrs.
+func (s *Server) Addr() string {
+	if addrs := s.addrs.Load(); addrs != nil {
+		return (*addrs)[0]
+	}
+	return s.httpServer.Load().Addr
+}
+
+// listen binds a TCP address or a unix socket, unless passed by the parent
+// process of Upgrade. Its errors are BindError.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	if l := takeInherited(addr); l != nil {
+		slog.Info("Listener inherited", "address", addr)
+		return l, nil
+	}
+	l, err := s.bind(addr)
+	if err != nil {
+		return nil, newBindError(addr, err)
+	}
+	return l, nil
+}
+
+// bind binds addr, a TCP address or a unix socket.
+func (s *Server) bind(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, UnixAddressPrefix); ok {
+		return listenUnix(path, s.config.SocketMode)
+	}
+	if s.config.SocketOptions != nil {
+		return s.config.SocketOptions.listen(addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// StartTLS starts the server over HTTPS, like Start. The certificate comes
+// from the files, or from ServerConfig.TLSConfig when both paths are empty:
+// its Certificates, GetCertificate or GetConfigForClient. The other settings
+// of TLSConfig apply in both cases. The certificate of the files can be
+// reloaded without a restart, see ReloadTLS. With ServerConfig.RedirectHTTP,
+// the HTTP requests are redirected to HTTPS.
+//
+// Parameters:
+//   - certFile: The PEM certificate file, the intermediate certificates following the leaf.
+//   - keyFile: The PEM private key file.
+//
+// Returns:
+//   - error: ErrNoCertificate when no certificate is provided, otherwise like Start.
+func (s *Server) StartTLS(certFile, keyFile string) error {
+	return s.running(func() error {
+		return s.startTLS(certFile, keyFile)
+	})
+}
+
+func (s *Server) startTLS(certFile, keyFile string) error {
+	if certFile == "" && keyFile == "" && !hasCertificates(s.httpServer.Load().TLSConfig) {
+		return ErrNoCertificate
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("%w: both the certificate and the key files are required", ErrNoCertificate)
+	}
+	if certFile != "" {
+		if err := s.useCertificateFiles(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+	listeners, err := s.listenAll(":https", true)
+	if err != nil {
+		return err
+	}
+	if s.config.RedirectHTTP {
+		if err := s.startRedirectHTTP(listeners); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+	}
+	return s.serveAll(listeners, "", "")
+}
+
+// hasCertificates reports whether the TLS configuration provides certificates.
+func hasCertificates(config *tls.Config) bool {
+	return config != nil && (len(config.Certificates) > 0 || config.GetCertificate != nil || config.GetConfigForClient != nil)
+}
+
+// start prepares the server before it listens: the templates are parsed and
+// watched in the Development profile.
+func (s *Server) start(listeners []boundListener) error {
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = listenerAddr(l)
+		slog.Info("Server started", "address", addrs[i], "scheme", l.scheme())
+	}
+	s.addrs.Store(&addrs)
+	if s.config.TempDir != nil {
+		go s.sweepTempDirs(*s.config.TempDir)
+	}
+	// Parsed already by Warmup, unless it ran in the background.
+	if !s.templatesParsed.Swap(false) {
+		if err := s.t.Parse(); err != nil {
+			return err
+		}
+	}
+	started := time.Now()
+	s.started.Store(&started)
+	s.publish()
+	s.logBanner()
+	if s.devReload != nil {
+		go s.t.Watch(s.background, 0, func(err error) {
+			if err != nil {
+				s.LogError("Reloading templates", err.Error())
+				return
+			}
+			s.LogDebug("Templates reloaded", "")
+			s.DevReload()
+		})
+	}
+	return nil
+}
+
+// Stop stops the server gracefully within ServerConfig.ShutdownTimeout (see
+// Shutdown), then closes the connections still open: their requests are
+// dropped and the background tasks cancelled, their results staying available
+// in the store. A negative ShutdownTimeout closes the connections right away.
+//
+// Stop on a server not running is a no-op, but for a server not started yet:
+// it is stopped, Start then returning http.ErrServerClosed.
+//
+// Returns:
+//   - error: A *ShutdownTimeoutError when connections had to be closed,
+//     otherwise the error of Shutdown.
 func (s *Server) Stop() error {
-	slog.Info("Server stopped", "address", s.httpServer.Addr)
-	return s.httpServer.Close()
+	if !s.beginStop() {
+		return nil
+	}
+	defer s.stopped()
+	timeout := s.config.ShutdownTimeout
+	if timeout < 0 {
+		return s.close()
+	}
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	return s.shutdownWithin(timeout)
+}
+
+// close stops the server, closing the connections right away.
+func (s *Server) close() error {
+	slog.Info("Server stopped", "address", s.Addr())
+	s.tasks.Cancel()
+	s.stopBackground()
+	s.components.cancel()
+	return s.httpServer.Load().Close()
+}
+
+// Shutdown stops the server gracefully: the server drains first (see Drain)
+// for ServerConfig.DrainGracePeriod, then the listeners are closed, the idle
+// connections too, and Shutdown waits for the requests in progress to
+// complete before cancelling the background tasks and waiting for them. The
+// long-lived responses of the server, such as the server-sent events hubs and
+// the reload stream, are ended first. The requests served meanwhile don't
+// create sessions anymore. The components started with Go are stopped once
+// the requests completed, phase by phase, each phase getting an equal share of
+// the time left; the components exceeding their share are reported and the
+// next phases still run. The hooks registered with OnShutdown run last, their
+// errors joined to the returned error. Start returns http.ErrServerClosed as
+// soon as the listeners are closed, wait for Shutdown to return before exiting.
+// Shutdown can be called concurrently with Start: called first, Start returns
+// http.ErrServerClosed. On a server stopped or being stopped already, it is a
+// no-op.
+//
+// Parameters:
+//   - ctx: The context bounding the wait, e.g. with the grace period of the
+//     load balancer.
+//
+// Returns:
+//   - error: ctx.Err() if the requests or the tasks didn't complete in time,
+//     joined with the errors of the shutdown hooks.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.beginStop() {
+		return nil
+	}
+	defer s.stopped()
+	return s.shutdown(ctx)
+}
+
+// shutdown stops the server gracefully once claimed by beginStop.
+func (s *Server) shutdown(ctx context.Context) error {
+	s.drainFor(ctx)
+	slog.Info("Server shutting down", "address", s.Addr())
+	s.stopBackground()
+	if err := s.httpServer.Load().Shutdown(ctx); err != nil {
+		s.components.cancel()
+		return errors.Join(err, s.components.runHooks(ctx))
+	}
+	componentsErr := s.components.shutdown(ctx)
+	if err := s.tasks.Close(ctx); err != nil {
+		return errors.Join(err, s.components.runHooks(ctx))
+	}
+	if err := errors.Join(componentsErr, s.components.runHooks(ctx)); err != nil {
+		return err
+	}
+	slog.Info("Server stopped", "address", s.Addr())
+	return nil
 }
 
 // HandleFunc registers a function to handle HTTP requests with the given pattern.
-func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+// The middlewares wrap the route only, inside the ones of Use, the first one
+// outermost:
+//
+//	s.HandleFunc("GET /admin", admin, serverlib.RequireRole("admin"), auditLog)
+//
+// The route returned names it, see Route.Name.
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request), mw ...Middleware) *Route {
 	slog.Info("Registred HandleFunc", "pattern", pattern)
-	s.router.HandleFunc(pattern, handler)
+	return s.handleFunc(s.router, pattern, handler, mw)
+}
+
+// handleFunc registers handler on mux, see HandleFunc.
+func (s *Server) handleFunc(mux *http.ServeMux, pattern string, handler func(http.ResponseWriter, *http.Request), mw []Middleware) *Route {
+	if len(mw) == 0 {
+		mux.HandleFunc(pattern, handler)
+		return s.addHandlerRoute(pattern, nil, handler, nil)
+	}
+	wrapped := wrapMiddlewares(http.HandlerFunc(handler), mw)
+	mux.Handle(pattern, wrapped)
+	return s.addHandlerRoute(pattern, wrapped, handler, mw)
 }
 
 // Handle registers a handler to handle HTTP requests with the given pattern.
-func (s *Server) Handle(pattern string, handler http.Handler) {
+// The middlewares wrap the route only, as for HandleFunc.
+func (s *Server) Handle(pattern string, handler http.Handler, mw ...Middleware) *Route {
 	slog.Info("Registred handle", "pattern", pattern)
-	s.router.Handle(pattern, handler)
+	wrapped := wrapMiddlewares(handler, mw)
+	s.router.Handle(pattern, wrapped)
+	return s.addHandlerRoute(pattern, wrapped, handler, mw)
+}
+
+// ServeHTTP serves the request like the listeners of the server do, through
+// the middlewares then the routes, so that the server can be tested without
+// binding a port, or mounted in another server:
+//
+//	ts := httptest.NewServer(server)
+//	defer ts.Close()
+//
+// The templates are parsed by Start; call Warmup first to render them
+// without starting the server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.injector.ServeHTTP(w, r)
 }
 
 // AddTemplateSource adds a new template source to the server's template manager.
@@ -180,10 +997,44 @@ func (s *Server) AddTemplateSource(source string) {
 	s.t.AddSource(source)
 }
 
+// AddTemplateFS adds the templates of fsys matching glob, e.g. an embed.FS
+// and "templates/*.html", to the server's template manager, see
+// templates.Templates.AddFS.
+func (s *Server) AddTemplateFS(fsys fs.FS, glob string) {
+	slog.Info("Adding template file system", "glob", glob)
+	s.t.AddFS(fsys, glob)
+}
+
 // Render renders the specified template with the given data and writes the result to the response writer.
-func (s *Server) Render(w io.Writer, template string, data map[string]interface{}) {
+// When w is an http.ResponseWriter without a Content-Type, the Content-Type is derived from the
+// template name extension (".html", ".xml", ".svg", ".js", ".txt", ...) unless WithContentType overrides it.
+// With ServerConfig.MinifyHTML, the HTML templates are rendered to a buffer and minified.
+func (s *Server) Render(w io.Writer, template string, data map[string]interface{}, opts ...RenderOption) {
+	var options renderOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	slog.Info("Rendering template", "template", template)
-	s.t.Execute(w, template, data)
+	recordTemplate(w, template)
+	options.setContentType(w, template)
+	out := w
+	var buf bytes.Buffer
+	if s.config.MinifyHTML {
+		out = &buf
+	}
+	var err error
+	if options.tenant != "" {
+		err = s.t.ExecuteTenant(context.Background(), out, options.tenant, template, data)
+	} else {
+		err = s.t.Execute(out, template, data)
+	}
+	if err != nil {
+		s.LogError("Rendering template", err.Error())
+	}
+	if out == &buf {
+		s.minifyRendered(&buf, template, options.contentTypeOf(w, template))
+		buf.WriteTo(w)
+	}
 }
 
 // Templates returns the server's templates.
@@ -204,21 +1055,26 @@ func (s *Server) SessionKey() string {
 	return s.sessionKey
 }
 
-func createSession(w http.ResponseWriter) sessions.Session {
-	session := ServerInstance.sessionManager.New()
+func (s *Server) createSession(w http.ResponseWriter) sessions.Session {
+	if s.shuttingDown.Load() {
+		// Neither stored nor sent: the server is going away.
+		return sessions.NewMemorySession(uuid.New().String())
+	}
+	session := s.sessionManager.New()
 	sessionID := session.Id()
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     ServerInstance.sessionKey,
-		Value:    sessionID,
-		HttpOnly: true,
-		MaxAge:   3600 * 24 * 7, // 1 week
-	})
+	s.setCookie(w, s.sessionCookie(sessionID), CookieSourceGetSession, CookiePriorityRequired)
 	return session
 }
 
 // GetSession retrieves the session associated with the request's cookie.
 // If the session does not exist, a new session is created and a new cookie is set.
+// When the browser sends several session cookies, the one written with the current
+// cookie attributes version wins; a session found under an older version gets its
+// cookie re-issued with the current attributes (see CookieConfig).
+// In a request served by s, it returns the session the server resolved for the
+// request, even if it was created by the request: the cookie of a new session is
+// only in the response.
 //
 // Parameters:
 //   - w: The HTTP response writer.
@@ -228,24 +1084,106 @@ func createSession(w http.ResponseWriter) sessions.Session {
 //   - sessions.Session: The session associated with the request.
 //   - bool: A boolean indicating whether the session was retrieved (true) or newly created (false).
 func (s *Server) GetSession(w http.ResponseWriter, r *http.Request) (sessions.Session, bool) {
-	cookie, err := r.Cookie(s.sessionKey)
-	if err != nil {
-		session := createSession(w)
-		return session, false
+	if session := reqctx.Session(r.Context()); session != nil && serverOf(r) == s {
+		return session, true
+	}
+	var session sessions.Session
+	version := -1
+	cookies := cookieValues(r, s.sessionKey, func(value string) {
+		id, cookieVersion := decodeSessionCookie(value)
+		if cookieVersion <= version {
+			return
+		}
+		if found, ok := s.sessionManager.Get(id); ok {
+			session, version = found, cookieVersion
+		}
+	})
+	if session == nil {
+		if cookies > 0 {
+			// Cookies of unknown sessions, which a previous one
+			// would keep shadowing the new cookie.
+			s.expirePreviousCookies(w, CookieSourceMigration)
+		}
+		// Create a new session if no session ID is found
+		return s.createSession(w), false
 	}
-	sessionID := cookie.Value
-	session, ok := s.sessionManager.Get(sessionID)
-	if !ok {
-		// Create a new session if the session ID is not found
-		session = createSession(w)
+	if version != s.cookie.Version || (cookies > 1 && len(s.cookie.Previous) > 0) {
+		s.migrateSessionCookie(w, session)
 	}
-	return session, ok
+	return session, true
+}
+
+// DestroySession deletes the session of the request and expires its cookie,
+// e.g. to log the user out. The cookies of the previous attributes (see
+// CookieConfig) are expired too, and every session they name is deleted. The
+// next request gets a new session.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (s *Server) DestroySession(w http.ResponseWriter, r *http.Request) {
+	if session := reqctx.Session(r.Context()); session != nil && serverOf(r) == s {
+		s.sessionManager.Delete(session.Id())
+	}
+	cookieValues(r, s.sessionKey, func(value string) {
+		id, _ := decodeSessionCookie(value)
+		s.sessionManager.Delete(id)
+	})
+	s.LogDebug("Destroying session", "")
+	s.expirePreviousCookies(w, CookieSourceDestroySession)
+	s.expireSessionCookie(w, s.cookie.CookieAttributes, CookieSourceDestroySession)
+}
+
+// validTraceParent reports whether the value is a W3C traceparent header,
+// "version-trace_id-parent_id-flags" in lowercase hexadecimal. The IDs can't
+// be all zeros, and only the versions after 00 may append fields.
+func validTraceParent(value string) bool {
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' || value[:2] == "ff" {
+		return false
+	}
+	for i := 0; i < 55; i++ {
+		if i == 2 || i == 35 || i == 52 {
+			continue
+		}
+		if c := value[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	if value[3:35] == strings.Repeat("0", 32) || value[36:52] == strings.Repeat("0", 16) {
+		return false
+	}
+	return len(value) == 55 || value[:2] != "00" && value[55] == '-'
+}
+
+// newRequestID returns a random UUID. Unlike uuid.New, the UUID is built on
+// the stack: only its string is allocated.
+func newRequestID() string {
+	var id uuid.UUID
+	rand.Read(id[:])
+	id[6] = (id[6] & 0x0f) | 0x40 // Version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // Variant RFC 4122
+	return id.String()
+}
+
+// remoteIP returns the IP address of the peer of the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // GetSession retrieves the session associated with the request's cookie.
-// shorthand for ServerInstance.GetSession(w, r)
+// shorthand for Server.GetSession(w, r) with the server handling the request,
+// or the default server, see ServerInstance.
+// It returns no session when no server has been created.
 func GetSession(w http.ResponseWriter, r *http.Request) (sessions.Session, bool) {
-	return ServerInstance.GetSession(w, r)
+	s := serverOf(r)
+	if s == nil {
+		return nil, false
+	}
+	return s.GetSession(w, r)
 }
 
 // SetLogLevel sets the logging level for the server.
@@ -265,9 +1203,9 @@ func (s *Server) SetLogLevel(level LogLevel) {
 // It takes two parameters:
 // - message: A string representing the message to be logged.
 // - value: A string representing additional information to be logged alongside the message.
-func LogInfo(message string, value string) {
-	if ServerInstance.logLevel >= Info {
-		ServerInstance.logger.Printf("INFO - %s: %s\n", message, value)
+func (s *Server) LogInfo(message string, value string) {
+	if s != nil && s.logLevel >= Info {
+		s.logger.Printf("INFO - %s: %s\n", message, value)
 	}
 }
 
@@ -275,9 +1213,9 @@ func LogInfo(message string, value string) {
 // It takes two parameters:
 // - message: A string representing the debug message.
 // - value: A string representing additional information to log with the message.
-func LogDebug(message string, value string) {
-	if ServerInstance.logLevel >= Debug {
-		ServerInstance.logger.Printf("DEBUG - %s: %s\n", message, value)
+func (s *Server) LogDebug(message string, value string) {
+	if s != nil && s.logLevel >= Debug {
+		s.logger.Printf("DEBUG - %s: %s\n", message, value)
 	}
 }
 
@@ -285,8 +1223,32 @@ func LogDebug(message string, value string) {
 // Parameters:
 //   - message: A string representing the error message to be logged.
 //   - value: A string representing additional information or context about the error.
-func LogError(message string, value string) {
-	if ServerInstance.logLevel >= Error {
-		ServerInstance.logger.Printf("ERROR - %s: %s\n", message, value)
+func (s *Server) LogError(message string, value string) {
+	if s != nil && s.logLevel >= Error {
+		s.logger.Printf("ERROR - %s: %s\n", message, value)
 	}
 }
+
+// LogInfo logs an informational message with the default server, see
+// Server.LogInfo.
+// shorthand for ServerInstance.LogInfo(message, value)
+// It does nothing when no server has been created.
+func LogInfo(message string, value string) {
+	ServerInstance.LogInfo(message, value)
+}
+
+// LogDebug logs a debug message with the default server, see
+// Server.LogDebug.
+// shorthand for ServerInstance.LogDebug(message, value)
+// It does nothing when no server has been created.
+func LogDebug(message string, value string) {
+	ServerInstance.LogDebug(message, value)
+}
+
+// LogError logs an error message with the default server, see
+// Server.LogError.
+// shorthand for ServerInstance.LogError(message, value)
+// It does nothing when no server has been created.
+func LogError(message string, value string) {
+	ServerInstance.LogError(message, value)
+}
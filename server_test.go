@@ -0,0 +1,425 @@
+package serverlib
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/Morditux/serverlib/scratch"
+	"github.com/Morditux/serverlib/sessions"
+	"github.com/Morditux/serverlib/tasks"
+	"github.com/Morditux/serverlib/templates"
+)
+
+type configKey struct{}
+
+// fullConfig returns a ServerConfig with every field set.
+func fullConfig(t *testing.T) ServerConfig {
+	return ServerConfig{
+		Address:                      "127.0.0.1:0",
+		DisableGeneralOptionsHandler: true,
+		TLSConfig:                    &tls.Config{ServerName: "example.com"},
+		ReadTimeout:                  1 * time.Second,
+		ReadHeaderTimeout:            2 * time.Second,
+		WriteTimeout:                 3 * time.Second,
+		IdleTimeout:                  4 * time.Second,
+		MaxHeaderBytes:               5000,
+		ConnState:                    func(net.Conn, http.ConnState) {},
+		ErrorLog:                     log.New(os.Stderr, "test ", 0),
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), configKey{}, "base")
+		},
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, configKey{}, "conn")
+		},
+		SessionManager:      sessions.NewMemorySessions(),
+		SessionKey:          "session",
+		SessionCookie:       CookieConfig{CookieAttributes: CookieAttributes{Path: "/app", Secure: true}, Version: 2},
+		DateFormat:          func(time.Time) string { return "date" },
+		LogLevel:            Debug,
+		Tasks:               tasks.NewRunner(tasks.Options{}),
+		BasePath:            "/app",
+		StripBasePath:       true,
+		ErrorHandler:        func(http.ResponseWriter, *http.Request, error) {},
+		Profile:             Development,
+		ProblemTypeBase:     "https://example.com/problems/",
+		TemplateOverrides:   tenantOverrides{},
+		ClientAuth:          &ClientAuth{Mode: tls.VerifyClientCertIfGiven},
+		ConcurrencyLimit:    &LimiterOptions{MaxConcurrent: 10},
+		ResponseCache:       &ResponseCacheOptions{MaxEntries: 10},
+		FlagProvider:        StaticFlags{"beta": true},
+		MissingKeys:         templates.MissingKeysWarn,
+		ShutdownTimeout:     5 * time.Second,
+		CircuitBreaker:      &BreakerOptions{Threshold: 3},
+		SocketMode:          0o660,
+		HeaderBudget:        &HeaderBudgetOptions{SoftLimit: 1000},
+		MinifyHTML:          true,
+		Listeners:           []ListenAddress{{Address: "127.0.0.1:0"}},
+		AutoTLS:             &AutoTLSConfig{Hosts: []string{"example.com"}},
+		RequestLog:          &RequestLogOptions{Capacity: 10},
+		TLSReloadInterval:   time.Minute,
+		TLSReloadOnSIGHUP:   true,
+		EnableH2C:           true,
+		TempDir:             &scratch.Options{Root: t.TempDir()},
+		MaxConnections:      100,
+		MaxInFlightRequests: 50,
+		DrainGracePeriod:    time.Second,
+		WarmupConcurrency:   2,
+		WarmupInBackground:  true,
+		RedirectHTTP:        true,
+		RedirectHTTPAddr:    "127.0.0.1:0",
+		Handler:             http.NotFoundHandler(),
+		AcceptProxyProtocol: &ProxyProtocolOptions{Allowed: []string{"127.0.0.1/32"}},
+		UpgradeTimeout:      time.Minute,
+		SocketOptions:       &SocketOptions{ReusePort: true},
+		TicketRotation:      &TicketRotationOptions{Interval: time.Hour},
+		TrailingSlash:       RedirectTrailingSlash,
+	}
+}
+
+// sameValue reports whether a and b are equal, the functions being the same.
+func sameValue(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface && !a.IsNil() && !b.IsNil() {
+		a, b = a.Elem(), b.Elem()
+	}
+	if a.Kind() == reflect.Func && b.Kind() == reflect.Func {
+		return a.Pointer() == b.Pointer()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// TestServerConfigFields constructs a server from a ServerConfig with every
+// field set: the http.Server of NewServer and of Restart reflect the fields
+// passed through, and the server keeps the others. A field added to
+// ServerConfig must be added to fullConfig.
+func TestServerConfigFields(t *testing.T) {
+	config := fullConfig(t)
+	var states []http.ConnState
+	config.ConnState = func(_ net.Conn, state http.ConnState) {
+		states = append(states, state)
+	}
+	fields := reflect.ValueOf(config)
+	for i := range fields.NumField() {
+		if fields.Field(i).IsZero() {
+			t.Errorf("%s not set by fullConfig", fields.Type().Field(i).Name)
+		}
+	}
+	s := NewServer(config)
+	// The fields but TLSConfig, a copy applying ClientAuth, are kept as is.
+	kept := reflect.ValueOf(s.config)
+	for i := range fields.NumField() {
+		name := fields.Type().Field(i).Name
+		if name != "TLSConfig" && !sameValue(kept.Field(i), fields.Field(i)) {
+			t.Errorf("ServerConfig.%s not kept", name)
+		}
+	}
+	if s.config.TLSConfig.ServerName != "example.com" || s.config.TLSConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("TLSConfig %+v, want ClientAuth applied to a copy", s.config.TLSConfig)
+	}
+
+	current := s.httpServer.Load()
+	tests := []struct {
+		name   string
+		server *http.Server
+	}{
+		{"NewServer", current},
+		{"Restart", s.newHTTPServer(s.config, current.Handler)},
+	}
+	for _, tt := range tests {
+		got := tt.server
+		passed := []struct {
+			field     string
+			got, want any
+		}{
+			{"Address", got.Addr, config.Address},
+			{"DisableGeneralOptionsHandler", got.DisableGeneralOptionsHandler, config.DisableGeneralOptionsHandler},
+			{"TLSConfig", got.TLSConfig, s.config.TLSConfig},
+			{"ReadTimeout", got.ReadTimeout, config.ReadTimeout},
+			{"ReadHeaderTimeout", got.ReadHeaderTimeout, config.ReadHeaderTimeout},
+			{"WriteTimeout", got.WriteTimeout, config.WriteTimeout},
+			{"IdleTimeout", got.IdleTimeout, config.IdleTimeout},
+			{"MaxHeaderBytes", got.MaxHeaderBytes, config.MaxHeaderBytes},
+			{"ErrorLog", got.ErrorLog, config.ErrorLog},
+		}
+		for _, p := range passed {
+			if p.got != p.want {
+				t.Errorf("%s: http.Server %s %v, want %v", tt.name, p.field, p.got, p.want)
+			}
+		}
+		if got.Handler == nil || got.Handler == http.Handler(s.injector) {
+			t.Errorf("%s: Handler %T, want the h2c handler", tt.name, got.Handler)
+		}
+		// BaseContext holds the server on top of the configured context.
+		base := got.BaseContext(nil)
+		if base.Value(configKey{}) != "base" || base.Value(serverKey{}) != s {
+			t.Errorf("%s: BaseContext without the configured values", tt.name)
+		}
+		if got.ConnContext(context.Background(), nil).Value(configKey{}) != "conn" {
+			t.Errorf("%s: ConnContext not passed through", tt.name)
+		}
+		// ConnState is called once the connection is counted.
+		client, server := net.Pipe()
+		states = nil
+		got.ConnState(server, http.StateNew)
+		got.ConnState(server, http.StateClosed)
+		client.Close()
+		if len(states) != 2 || states[0] != http.StateNew {
+			t.Errorf("%s: ConnState called with %v", tt.name, states)
+		}
+	}
+}
+
+// TestDisableGeneralOptionsHandler sends "OPTIONS *" to a running server:
+// http.Server answers it unless the general handler is disabled, the request
+// then reaching the handler of the server.
+func TestDisableGeneralOptionsHandler(t *testing.T) {
+	tests := []struct {
+		disable    bool
+		wantCode   int
+		wantHeader string
+	}{
+		{false, http.StatusOK, ""},
+		{true, http.StatusNoContent, "handled"},
+	}
+	for _, tt := range tests {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI == "*" {
+				w.Header().Set("X-Handled", "handled")
+				w.WriteHeader(http.StatusNoContent)
+			}
+		})
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", DisableGeneralOptionsHandler: tt.disable, Handler: handler})
+		done := startServer(s)
+		<-s.Ready()
+		conn, err := net.Dial("tcp", s.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(conn, "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		conn.Close()
+		if resp.StatusCode != tt.wantCode || resp.Header.Get("X-Handled") != tt.wantHeader {
+			t.Errorf("disabled %v: %d %q, want %d %q", tt.disable, resp.StatusCode, resp.Header.Get("X-Handled"), tt.wantCode, tt.wantHeader)
+		}
+		s.Stop()
+		waitStart(t, done)
+	}
+}
+
+// TestStartTLS starts servers over HTTPS with the certificate of the files
+// or of ServerConfig.TLSConfig, the other settings of TLSConfig applying in
+// both cases, and checks StartTLS fails without a certificate.
+func TestStartTLS(t *testing.T) {
+	ca := newTestCA(t, "CA")
+	certFile, keyFile := ca.writeServerCert(t, t.TempDir(), 1)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		tlsConfig *tls.Config
+		certFile  string
+		keyFile   string
+		wantErr   error
+		// wantRefused tells whether a TLS 1.2 client is refused.
+		wantRefused bool
+	}{
+		{"files", nil, certFile, keyFile, nil, false},
+		{"files, settings of TLSConfig", &tls.Config{MinVersion: tls.VersionTLS13}, certFile, keyFile, nil, true},
+		{"Certificates", &tls.Config{Certificates: []tls.Certificate{cert}}, "", "", nil, false},
+		{"GetCertificate", &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil }}, "", "", nil, false},
+		{"GetConfigForClient", &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}, nil
+		}}, "", "", nil, true},
+		{"no certificate", nil, "", "", ErrNoCertificate, false},
+		{"TLSConfig without certificate", &tls.Config{MinVersion: tls.VersionTLS13}, "", "", ErrNoCertificate, false},
+		{"no key file", nil, certFile, "", ErrNoCertificate, false},
+		{"missing files", nil, certFile + ".missing", keyFile, os.ErrNotExist, false},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", TLSConfig: tt.tlsConfig})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		done := make(chan error, 1)
+		go func() {
+			done <- s.StartTLS(tt.certFile, tt.keyFile)
+		}()
+		if tt.wantErr != nil {
+			if err := waitStart(t, done); !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		<-s.Ready()
+		_, port, _ := net.SplitHostPort(s.Addr())
+		url := "https://localhost:" + port + "/"
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool()}}}
+		resp, err := client.Get(url)
+		if err != nil || resp.TLS == nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else {
+			resp.Body.Close()
+		}
+		client.CloseIdleConnections()
+		old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool(), MaxVersion: tls.VersionTLS12}}}
+		if resp, err := old.Get(url); (err != nil) != tt.wantRefused {
+			t.Errorf("%s: TLS 1.2 client: %v, want refused %v", tt.name, err, tt.wantRefused)
+		} else if err == nil {
+			resp.Body.Close()
+		}
+		old.CloseIdleConnections()
+		s.Stop()
+		waitStart(t, done)
+	}
+}
+
+// TestServeHTTP serves a server like the listeners do, through httptest and
+// mounted in another mux: the middlewares, the session and the templates
+// parsed by Warmup apply.
+func TestServeHTTP(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	s.AddTemplateFS(fstest.MapFS{"page.html": &fstest.MapFile{Data: []byte("<p>{{.Name}}</p>")}}, "*")
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Middleware", "used")
+			next.ServeHTTP(w, r)
+		})
+	})
+	s.GET("/page", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.GetSession(w, r); !ok {
+			http.Error(w, "no session", http.StatusInternalServerError)
+			return
+		}
+		s.Render(w, "page.html", map[string]any{"Name": "served"})
+	})
+	if err := s.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	outer := http.NewServeMux()
+	outer.Handle("/app/", http.StripPrefix("/app", s))
+	mounted := httptest.NewServer(outer)
+	defer mounted.Close()
+	for _, url := range []string{ts.URL + "/page", mounted.URL + "/app/page"} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var cookie bool
+		for _, c := range resp.Cookies() {
+			cookie = cookie || c.Name == s.SessionKey()
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "<p>served</p>" || resp.Header.Get("X-Middleware") != "used" || !cookie {
+			t.Errorf("%s: %d %q, middleware %q, session cookie %v", url, resp.StatusCode, body, resp.Header.Get("X-Middleware"), cookie)
+		}
+	}
+}
+
+// TestHandler serves the requests matching no route with
+// ServerConfig.Handler, behind the middlewares and with a session.
+func TestHandler(t *testing.T) {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := GetSession(w, r)
+		fmt.Fprintf(w, "fallback %s session %v", r.URL.Path, ok)
+	})
+	s := NewServer(ServerConfig{Handler: fallback})
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Middleware", "used")
+			next.ServeHTTP(w, r)
+		})
+	})
+	s.GET("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("route"))
+	})
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		accept   string
+		wantBody string
+	}{
+		{"route", http.MethodGet, "/items", "", "route"},
+		{"no route", http.MethodGet, "/legacy/page", "", "fallback /legacy/page session true"},
+		{"no route, HTML client", http.MethodGet, "/legacy/page", "text/html", "fallback /legacy/page session true"},
+		{"no route, JSON client", http.MethodGet, "/legacy/api", "application/json", "fallback /legacy/api session true"},
+		{"other method", http.MethodPost, "/items", "", "fallback /items session true"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != tt.wantBody || w.Header().Get("X-Middleware") != "used" {
+			t.Errorf("%s: %d %q, middleware %q, want %q", tt.name, w.Code, w.Body.String(), w.Header().Get("X-Middleware"), tt.wantBody)
+		}
+	}
+}
+
+// TestFromContext serves two servers: the requests, and the connections of
+// ServerConfig.ConnContext, find the server serving them, whether through
+// a listener or ServeHTTP.
+func TestFromContext(t *testing.T) {
+	if s, ok := FromContext(context.Background()); ok || s != nil {
+		t.Errorf("server %v of a context that didn't come from a server", s)
+	}
+	var servers [2]*Server
+	conns := make(chan bool, 2)
+	for i := range servers {
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			got, _ := FromContext(ctx)
+			conns <- got == servers[i]
+			return ctx
+		}})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			if got, ok := FromContext(r.Context()); !ok || got != s {
+				http.Error(w, "other server", http.StatusInternalServerError)
+			}
+		})
+		servers[i] = s
+	}
+	for i, s := range servers {
+		done := startServer(s)
+		<-s.Ready()
+		client := &http.Client{Transport: &http.Transport{}}
+		if resp, err := client.Get("http://" + s.Addr() + "/"); err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("server %d: %v %v", i, resp, err)
+		} else {
+			resp.Body.Close()
+		}
+		client.CloseIdleConnections()
+		if !<-conns {
+			t.Errorf("server %d: other server in the context of the connection", i)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("server %d: ServeHTTP %d", i, w.Code)
+		}
+		s.Stop()
+		waitStart(t, done)
+	}
+}
@@ -0,0 +1,186 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+// DefaultSessionLockWait is the maximum time a request waits for the lock of its session.
+const DefaultSessionLockWait = 10 * time.Second
+
+// SessionLocker acquires exclusive locks on session IDs.
+// MemorySessionLocker serves a single process; deployments with several nodes
+// sharing a session store need a distributed implementation, e.g. on Redis.
+type SessionLocker interface {
+	// Lock blocks until the lock of the session is acquired or ctx is done, in
+	// which case it returns ctx.Err(). The returned function releases the lock.
+	Lock(ctx context.Context, sessionID string) (unlock func(), err error)
+}
+
+// MemorySessionLocker is an in-process SessionLocker.
+// The lock of a session is dropped as soon as no request holds or waits for it.
+type MemorySessionLocker struct {
+	locks map[string]*sessionLock
+	mut   *sync.Mutex
+}
+
+type sessionLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+// NewMemorySessionLocker creates an in-process SessionLocker.
+func NewMemorySessionLocker() *MemorySessionLocker {
+	return &MemorySessionLocker{
+		locks: make(map[string]*sessionLock),
+		mut:   &sync.Mutex{},
+	}
+}
+
+// Lock implements SessionLocker.
+func (l *MemorySessionLocker) Lock(ctx context.Context, sessionID string) (func(), error) {
+	l.mut.Lock()
+	lock, ok := l.locks[sessionID]
+	if !ok {
+		lock = &sessionLock{ch: make(chan struct{}, 1)}
+		l.locks[sessionID] = lock
+	}
+	lock.refs++
+	l.mut.Unlock()
+
+	select {
+	case lock.ch <- struct{}{}:
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				<-lock.ch
+				l.release(sessionID, lock)
+			})
+		}, nil
+	case <-ctx.Done():
+		l.release(sessionID, lock)
+		return nil, ctx.Err()
+	}
+}
+
+// release drops a reference to the lock of a session.
+func (l *MemorySessionLocker) release(sessionID string, lock *sessionLock) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, sessionID)
+	}
+}
+
+// SessionLockOptions configures a SessionLock.
+type SessionLockOptions struct {
+	// Locker acquires the locks. Defaults to a new MemorySessionLocker.
+	Locker SessionLocker
+	// MaxWait is the maximum time a request waits for the lock.
+	// Defaults to DefaultSessionLockWait.
+	MaxWait time.Duration
+	// Status is the status code answered when the lock could not be acquired
+	// in time, usually 409 or 429. Defaults to 409.
+	Status int
+	// ReadOnly reports whether a request can run without the lock, e.g. for
+	// the routes that never modify the session. Defaults to locking every request.
+	ReadOnly func(*http.Request) bool
+}
+
+// SessionLockStats describes the lock waits of a SessionLock.
+type SessionLockStats struct {
+	// Acquired is the number of requests that got the lock.
+	Acquired uint64
+	// Timeouts is the number of requests rejected after waiting MaxWait.
+	Timeouts uint64
+	// TotalWait is the time spent waiting by the requests that got the lock.
+	TotalWait time.Duration
+	// LongestWait is the longest wait of a request that got the lock.
+	LongestWait time.Duration
+}
+
+// SessionLock serializes the requests of a session: the read-modify-write of
+// a handler can span the whole request, so two concurrent requests of the same
+// browser would otherwise interleave their session updates.
+type SessionLock struct {
+	options     SessionLockOptions
+	acquired    atomic.Uint64
+	timeouts    atomic.Uint64
+	totalWait   atomic.Int64
+	longestWait atomic.Int64
+}
+
+// NewSessionLock creates a SessionLock with the given options.
+func NewSessionLock(options SessionLockOptions) *SessionLock {
+	if options.Locker == nil {
+		options.Locker = NewMemorySessionLocker()
+	}
+	if options.MaxWait <= 0 {
+		options.MaxWait = DefaultSessionLockWait
+	}
+	if options.Status == 0 {
+		options.Status = http.StatusConflict
+	}
+	return &SessionLock{options: options}
+}
+
+// Wrap returns a handler holding the lock of the request session while next runs.
+// The lock is released even if next panics. Requests without a session or
+// accepted by ReadOnly are not serialized. Wrap is a Middleware.
+func (l *SessionLock) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := reqctx.Session(r.Context())
+		if session == nil || (l.options.ReadOnly != nil && l.options.ReadOnly(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), l.options.MaxWait)
+		start := time.Now()
+		unlock, err := l.options.Locker.Lock(ctx, session.Id())
+		cancel()
+		if err != nil {
+			if r.Context().Err() != nil {
+				// The client went away.
+				return
+			}
+			l.timeouts.Add(1)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				serverOf(r).LogError("Acquiring session lock", err.Error())
+			}
+			serverOf(r).Error(w, r, NewHTTPError(l.options.Status, "another request of the session is in progress"))
+			return
+		}
+		defer unlock()
+		l.observe(time.Since(start))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observe records the wait of a request that got the lock.
+func (l *SessionLock) observe(wait time.Duration) {
+	l.acquired.Add(1)
+	l.totalWait.Add(int64(wait))
+	for {
+		longest := l.longestWait.Load()
+		if int64(wait) <= longest || l.longestWait.CompareAndSwap(longest, int64(wait)) {
+			return
+		}
+	}
+}
+
+// Stats returns the lock wait statistics.
+func (l *SessionLock) Stats() SessionLockStats {
+	return SessionLockStats{
+		Acquired:    l.acquired.Load(),
+		Timeouts:    l.timeouts.Load(),
+		TotalWait:   time.Duration(l.totalWait.Load()),
+		LongestWait: time.Duration(l.longestWait.Load()),
+	}
+}
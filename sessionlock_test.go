@@ -0,0 +1,204 @@
+package serverlib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSessionCookie returns the cookie of a new session of s.
+func newSessionCookie(t *testing.T, s *Server) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/new", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie")
+	}
+	return cookies[0]
+}
+
+// TestSessionLockSerialization sends two concurrent requests to a slow
+// handler incrementing a session counter in a read-modify-write.
+func TestSessionLockSerialization(t *testing.T) {
+	tests := []struct {
+		name           string
+		sameSession    bool
+		readOnly       bool
+		wantConcurrent int32
+		wantCount      int
+	}{
+		{"same session", true, false, 1, 2},
+		{"different sessions", false, false, 2, 1},
+		{"read-only route", true, true, 2, 1},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		var running, maxRunning atomic.Int32
+		both := make(chan struct{})
+		var arrived sync.WaitGroup
+		arrived.Add(2)
+		go func() {
+			arrived.Wait()
+			close(both)
+		}()
+		lock := NewSessionLock(SessionLockOptions{ReadOnly: func(*http.Request) bool { return tt.readOnly }})
+		s.GET("/new", func(w http.ResponseWriter, r *http.Request) { s.GetSession(w, r) })
+		s.POST("/cart", func(w http.ResponseWriter, r *http.Request) {
+			session, _ := s.GetSession(w, r)
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				if previous := maxRunning.Load(); n <= previous || maxRunning.CompareAndSwap(previous, n) {
+					break
+				}
+			}
+			count, _ := session.Get("count").(int)
+			// Both requests in the handler at once unless serialized.
+			select {
+			case <-both:
+			case <-time.After(50 * time.Millisecond):
+			}
+			session.Set("count", count+1)
+		}, lock.Wrap)
+		cookies := []*http.Cookie{newSessionCookie(t, s), newSessionCookie(t, s)}
+		if tt.sameSession {
+			cookies[1] = cookies[0]
+		}
+		var wg sync.WaitGroup
+		for _, cookie := range cookies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := httptest.NewRequest(http.MethodPost, "/cart", nil)
+				r.AddCookie(cookie)
+				arrived.Done()
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					t.Errorf("%s: status %d", tt.name, w.Code)
+				}
+			}()
+		}
+		wg.Wait()
+		if got := maxRunning.Load(); got != tt.wantConcurrent {
+			t.Errorf("%s: %d requests at once, want %d", tt.name, got, tt.wantConcurrent)
+		}
+		session, _ := s.sessionManager.Get(cookies[0].Value)
+		if got, _ := session.Get("count").(int); got != tt.wantCount {
+			t.Errorf("%s: count %d, want %d", tt.name, got, tt.wantCount)
+		}
+		wantAcquired := uint64(2)
+		if tt.readOnly {
+			wantAcquired = 0
+		}
+		if stats := lock.Stats(); stats.Acquired != wantAcquired || stats.Timeouts != 0 {
+			t.Errorf("%s: stats %+v, want %d acquired", tt.name, stats, wantAcquired)
+		}
+	}
+}
+
+func TestSessionLockTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStatus int
+	}{
+		{"default status", 0, http.StatusConflict},
+		{"Status", http.StatusTooManyRequests, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		lock := NewSessionLock(SessionLockOptions{MaxWait: 20 * time.Millisecond, Status: tt.status})
+		entered, release := make(chan struct{}), make(chan struct{})
+		s.GET("/new", func(w http.ResponseWriter, r *http.Request) { s.GetSession(w, r) })
+		s.POST("/slow", func(http.ResponseWriter, *http.Request) {
+			close(entered)
+			<-release
+		}, lock.Wrap)
+		s.POST("/fast", func(http.ResponseWriter, *http.Request) {}, lock.Wrap)
+		cookie := newSessionCookie(t, s)
+		request := func(path string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, path, nil)
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			return w
+		}
+		slow := make(chan int)
+		go func() { slow <- request("/slow").Code }()
+		<-entered
+		if w := request("/fast"); w.Code != tt.wantStatus {
+			t.Errorf("%s: waiting request %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		close(release)
+		if code := <-slow; code != http.StatusOK {
+			t.Errorf("%s: lock holder %d", tt.name, code)
+		}
+		if w := request("/fast"); w.Code != http.StatusOK {
+			t.Errorf("%s: request after the release %d", tt.name, w.Code)
+		}
+		stats := lock.Stats()
+		if stats.Acquired != 2 || stats.Timeouts != 1 {
+			t.Errorf("%s: stats %+v, want 2 acquired and a timeout", tt.name, stats)
+		}
+	}
+}
+
+// TestSessionLockPanic panics while holding the lock: the next request of
+// the session gets the lock.
+func TestSessionLockPanic(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	lock := NewSessionLock(SessionLockOptions{MaxWait: time.Second})
+	s.GET("/new", func(w http.ResponseWriter, r *http.Request) { s.GetSession(w, r) })
+	s.POST("/panic", func(http.ResponseWriter, *http.Request) { panic("boom") }, lock.Wrap)
+	s.POST("/cart", func(http.ResponseWriter, *http.Request) {}, lock.Wrap)
+	cookie := newSessionCookie(t, s)
+	for _, tt := range []struct {
+		path string
+		want int
+	}{{"/panic", http.StatusInternalServerError}, {"/cart", http.StatusOK}} {
+		r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("POST %s: %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestMemorySessionLocker(t *testing.T) {
+	l := NewMemorySessionLocker()
+	unlock, err := l.Lock(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("second Lock: %v, want context.DeadlineExceeded", err)
+	}
+	other, err := l.Lock(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+	unlock()
+	// A second call of the unlock function doesn't release the next holder.
+	again, _ := l.Lock(context.Background(), "a")
+	unlock()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("Lock after a double unlock: %v, want context.DeadlineExceeded", err)
+	}
+	again()
+	if n := len(l.locks); n != 0 {
+		t.Errorf("%d locks left once released", n)
+	}
+}
@@ -0,0 +1,317 @@
+package sessions
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HealthState is the health of a session store as seen by its prober.
+type HealthState int
+
+const (
+	// Healthy means the probes succeed in time.
+	Healthy HealthState = iota
+	// Degraded means the recent probes were slow or failed.
+	Degraded
+	// Down means the probes kept failing.
+	Down
+)
+
+// String returns the name of the state.
+func (h HealthState) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Down:
+		return "down"
+	}
+	return "unknown"
+}
+
+// HealthReporter is implemented by the session stores knowing their health,
+// e.g. ProbedSessions.
+type HealthReporter interface {
+	// Health returns the current health of the store.
+	Health() HealthState
+}
+
+// Defaults of the probe options.
+const (
+	DefaultProbeInterval  = 10 * time.Second
+	DefaultProbeTimeout   = 2 * time.Second
+	DefaultProbeSlow      = 250 * time.Millisecond
+	DefaultProbeKeyPrefix = "__probe:"
+	DefaultDegradeAfter   = 2
+	DefaultDownAfter      = 5
+	DefaultRecoverAfter   = 3
+)
+
+// probeKey is the session key written and read back by the probes.
+const probeKey = "probe"
+
+// ProbeOptions configures ProbedSessions.
+type ProbeOptions struct {
+	// Interval is the delay between two probes. Defaults to DefaultProbeInterval.
+	Interval time.Duration
+	// Timeout fails the probes taking longer. Defaults to DefaultProbeTimeout.
+	Timeout time.Duration
+	// Slow is the latency above which a successful probe counts as a bad one.
+	// Defaults to DefaultProbeSlow.
+	Slow time.Duration
+	// KeyPrefix prefixes the ID of the probe session, so that it can't clash
+	// with the user sessions. Defaults to DefaultProbeKeyPrefix.
+	KeyPrefix string
+	// DegradeAfter is the number of consecutive bad probes turning a healthy
+	// store degraded. Defaults to DefaultDegradeAfter.
+	DegradeAfter int
+	// DownAfter is the number of consecutive failed probes turning the store
+	// down. Defaults to DefaultDownAfter.
+	DownAfter int
+	// RecoverAfter is the number of consecutive good probes turning the store
+	// healthy again. Defaults to DefaultRecoverAfter.
+	RecoverAfter int
+	// OnTransition, if not nil, is called on every change of state, after the
+	// transition has been logged.
+	OnTransition func(from, to HealthState)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// ProbeStats holds the counters of a ProbedSessions.
+type ProbeStats struct {
+	State       HealthState
+	Since       time.Time
+	Probes      uint64
+	Failures    uint64
+	Slow        uint64
+	LastLatency time.Duration
+	LastError   string
+}
+
+// ProbedSessions decorates a session store with a background health prober:
+// a probe session is written and read back on an interval, and the store is
+// reported healthy, degraded or down from the outcome of the recent probes.
+// The thresholds give the states some hysteresis, a single slow probe doesn't
+// flip the state. Every other call is delegated to the store.
+type ProbedSessions struct {
+	Sessions
+	opts    ProbeOptions
+	probeID string
+	mut     *sync.RWMutex
+	stats   ProbeStats
+	bad     int
+	failed  int
+	good    int
+	// probing is set while a probe is running, a probe outliving its timeout
+	// must not be overlapped by the next one.
+	probing bool
+}
+
+// NewProbedSessions decorates the store with a health prober. The probes only
+// run once Start is called, or when Probe is.
+func NewProbedSessions(store Sessions, opts ProbeOptions) *ProbedSessions {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultProbeInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultProbeTimeout
+	}
+	if opts.Slow <= 0 {
+		opts.Slow = DefaultProbeSlow
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = DefaultProbeKeyPrefix
+	}
+	if opts.DegradeAfter <= 0 {
+		opts.DegradeAfter = DefaultDegradeAfter
+	}
+	if opts.DownAfter <= 0 {
+		opts.DownAfter = DefaultDownAfter
+	}
+	if opts.RecoverAfter <= 0 {
+		opts.RecoverAfter = DefaultRecoverAfter
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &ProbedSessions{
+		Sessions: store,
+		opts:     opts,
+		probeID:  opts.KeyPrefix + uuid.NewString(),
+		mut:      &sync.RWMutex{},
+		stats:    ProbeStats{State: Healthy, Since: opts.Now()},
+	}
+}
+
+// Start probes the store on the interval until ctx is done.
+func (p *ProbedSessions) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(p.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				p.Sessions.Delete(p.probeID)
+				return
+			case <-ticker.C:
+				p.Probe()
+			}
+		}
+	}()
+}
+
+// Probe runs a probe and updates the health of the store. It is called on
+// the interval once started and can be called directly, e.g. by tests.
+func (p *ProbedSessions) Probe() HealthState {
+	p.mut.Lock()
+	if p.probing {
+		// The previous probe is still stuck in the store.
+		p.mut.Unlock()
+		return p.record(0, errProbeTimeout)
+	}
+	p.probing = true
+	p.mut.Unlock()
+
+	start := p.opts.Now()
+	done := make(chan error, 1)
+	go func() {
+		err := p.roundTrip()
+		p.mut.Lock()
+		p.probing = false
+		p.mut.Unlock()
+		done <- err
+	}()
+	timer := time.NewTimer(p.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return p.record(p.opts.Now().Sub(start), err)
+	case <-timer.C:
+		return p.record(p.opts.Timeout, errProbeTimeout)
+	}
+}
+
+// roundTrip writes a token to the probe session and reads it back.
+func (p *ProbedSessions) roundTrip() (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &probeError{message: "store panicked"}
+		}
+	}()
+	token := uuid.NewString()
+	session, ok := p.Sessions.Get(p.probeID)
+	if !ok {
+		session = p.Sessions.New()
+		if session.Id() != p.probeID {
+			p.Sessions.Delete(session.Id())
+		}
+	}
+	session.Set(probeKey, token)
+	p.Sessions.Set(p.probeID, session)
+	read, ok := p.Sessions.Get(p.probeID)
+	if !ok {
+		return errProbeMissing
+	}
+	if read.Get(probeKey) != token {
+		return errProbeMismatch
+	}
+	return nil
+}
+
+type probeError struct {
+	message string
+}
+
+func (e *probeError) Error() string {
+	return "session store probe: " + e.message
+}
+
+var (
+	errProbeTimeout  = &probeError{message: "timeout"}
+	errProbeMissing  = &probeError{message: "probe session not found after write"}
+	errProbeMismatch = &probeError{message: "probe session read back a stale value"}
+)
+
+// record updates the counters and the state with the outcome of a probe.
+func (p *ProbedSessions) record(latency time.Duration, err error) HealthState {
+	p.mut.Lock()
+	p.stats.Probes++
+	p.stats.LastLatency = latency
+	p.stats.LastError = ""
+	slow := err == nil && latency > p.opts.Slow
+	switch {
+	case err != nil:
+		p.stats.Failures++
+		p.stats.LastError = err.Error()
+		p.failed++
+		p.bad++
+		p.good = 0
+	case slow:
+		p.stats.Slow++
+		p.failed = 0
+		p.bad++
+		p.good = 0
+	default:
+		p.failed = 0
+		p.bad = 0
+		p.good++
+	}
+	from := p.stats.State
+	to := from
+	switch {
+	case p.failed >= p.opts.DownAfter:
+		to = Down
+	case from == Healthy && p.bad >= p.opts.DegradeAfter:
+		to = Degraded
+	case from == Down && p.good > 0:
+		// A store coming back is degraded until it proved stable.
+		to = Degraded
+	case from != Healthy && p.good >= p.opts.RecoverAfter:
+		to = Healthy
+	}
+	if to != from {
+		p.stats.State = to
+		p.stats.Since = p.opts.Now()
+	}
+	p.mut.Unlock()
+	if to != from {
+		if to == Healthy {
+			slog.Info("Session store health changed", "from", from.String(), "to", to.String())
+		} else {
+			slog.Warn("Session store health changed", "from", from.String(), "to", to.String(), "latency", latency, "error", err)
+		}
+		if p.opts.OnTransition != nil {
+			p.opts.OnTransition(from, to)
+		}
+	}
+	return to
+}
+
+// Len implements Counter for the stores counting their sessions, the probe
+// session included; it returns -1 for the others.
+func (p *ProbedSessions) Len() int {
+	if counter, ok := p.Sessions.(Counter); ok {
+		return counter.Len()
+	}
+	return -1
+}
+
+// Health returns the current health of the store.
+func (p *ProbedSessions) Health() HealthState {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.stats.State
+}
+
+// Stats returns the probe counters.
+func (p *ProbedSessions) Stats() ProbeStats {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.stats
+}
@@ -0,0 +1,270 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced by the fake store.
+type fakeClock struct {
+	mut sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeStore is a store which can be made slow, erroring or stuck.
+type fakeStore struct {
+	*MemorySessions
+	clock *fakeClock
+	mut   sync.Mutex
+	// latency advances the clock on each Get.
+	latency time.Duration
+	// failure is "missing" for the writes to be lost, "stale" for them to be
+	// ignored once the session exists, "panic" for the reads to panic.
+	failure string
+	// stuck, when not nil, blocks the reads until closed.
+	stuck chan struct{}
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{MemorySessions: NewMemorySessions(), clock: &fakeClock{now: time.Unix(1000, 0)}}
+}
+
+func (f *fakeStore) set(latency time.Duration, failure string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.latency, f.failure = latency, failure
+}
+
+func (f *fakeStore) Get(id string) (Session, bool) {
+	f.mut.Lock()
+	latency, failure, stuck := f.latency, f.failure, f.stuck
+	f.mut.Unlock()
+	if stuck != nil {
+		<-stuck
+	}
+	f.clock.Advance(latency)
+	switch failure {
+	case "missing":
+		return nil, false
+	case "panic":
+		panic("connection reset")
+	}
+	return f.MemorySessions.Get(id)
+}
+
+func (f *fakeStore) Set(id string, session Session) {
+	f.mut.Lock()
+	failure := f.failure
+	f.mut.Unlock()
+	if failure == "stale" {
+		if _, ok := f.MemorySessions.Get(id); ok {
+			f.MemorySessions.Set(id, NewMemorySession(id))
+			return
+		}
+	}
+	f.MemorySessions.Set(id, session)
+}
+
+// TestProbeTransitions drives the probes through good, slow and failed
+// outcomes: the state only changes after the thresholds, and each change is
+// logged and reported once.
+func TestProbeTransitions(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	tests := []struct {
+		name string
+		// probes are the outcomes: "ok", "slow" or "fail".
+		probes []string
+		want   []HealthState
+	}{
+		{"healthy", []string{"ok", "ok"}, []HealthState{Healthy, Healthy}},
+		{"single blips", []string{"slow", "ok", "fail", "ok", "slow"}, []HealthState{Healthy, Healthy, Healthy, Healthy, Healthy}},
+		{"degraded by slow probes", []string{"slow", "slow", "slow", "ok", "ok"}, []HealthState{Healthy, Degraded, Degraded, Degraded, Healthy}},
+		{"degraded by mixed probes", []string{"slow", "fail", "ok", "fail", "ok", "ok"}, []HealthState{Healthy, Degraded, Degraded, Degraded, Degraded, Healthy}},
+		{"down", []string{"fail", "fail", "fail", "fail"}, []HealthState{Healthy, Degraded, Down, Down}},
+		{"down, slow probes", []string{"fail", "fail", "fail", "slow", "slow"}, []HealthState{Healthy, Degraded, Down, Down, Down}},
+		{"recovery", []string{"fail", "fail", "fail", "ok", "fail", "ok", "ok"}, []HealthState{Healthy, Degraded, Down, Degraded, Degraded, Degraded, Healthy}},
+		{"failures interrupted", []string{"fail", "fail", "slow", "fail", "fail"}, []HealthState{Healthy, Degraded, Degraded, Degraded, Degraded}},
+	}
+	for _, tt := range tests {
+		store := newFakeStore()
+		var transitions []HealthState
+		p := NewProbedSessions(store, ProbeOptions{
+			Slow:         100 * time.Millisecond,
+			DegradeAfter: 2,
+			DownAfter:    3,
+			RecoverAfter: 2,
+			Now:          store.clock.Now,
+			OnTransition: func(from, to HealthState) { transitions = append(transitions, from, to) },
+		})
+		logs.Reset()
+		var got []HealthState
+		var wantTransitions []HealthState
+		for i, probe := range tt.probes {
+			switch probe {
+			case "ok":
+				store.set(time.Millisecond, "")
+			case "slow":
+				// Two reads per probe.
+				store.set(time.Second, "")
+			case "fail":
+				store.set(0, "missing")
+			}
+			got = append(got, p.Probe())
+			if i > 0 && got[i] != got[i-1] {
+				wantTransitions = append(wantTransitions, got[i-1], got[i])
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		if !slices.Equal(transitions, wantTransitions) {
+			t.Errorf("%s: transitions %v, want %v", tt.name, transitions, wantTransitions)
+		}
+		if logged := strings.Count(logs.String(), "Session store health changed"); logged != len(wantTransitions)/2 {
+			t.Errorf("%s: %d transitions logged, want %d", tt.name, logged, len(wantTransitions)/2)
+		}
+		if p.Health() != tt.want[len(tt.want)-1] {
+			t.Errorf("%s: Health %v, want %v", tt.name, p.Health(), tt.want[len(tt.want)-1])
+		}
+	}
+}
+
+func TestProbeStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		latency     time.Duration
+		failure     string
+		wantLatency time.Duration
+		wantError   string
+		wantSlow    uint64
+	}{
+		{"good", 10 * time.Millisecond, "", 20 * time.Millisecond, "", 0},
+		{"slow", time.Second, "", 2 * time.Second, "", 1},
+		{"missing", 0, "missing", 0, "session store probe: probe session not found after write", 0},
+		{"stale", 0, "stale", 0, "session store probe: probe session read back a stale value", 0},
+		{"panic", 0, "panic", 0, "session store probe: store panicked", 0},
+	}
+	for _, tt := range tests {
+		store := newFakeStore()
+		p := NewProbedSessions(store, ProbeOptions{DegradeAfter: 1, Now: store.clock.Now})
+		// A first good probe writes the probe session.
+		p.Probe()
+		start := store.clock.Now()
+		store.set(tt.latency, tt.failure)
+		p.Probe()
+		stats := p.Stats()
+		var wantFailures uint64
+		if tt.wantError != "" {
+			wantFailures = 1
+		}
+		if stats.Probes != 2 || stats.Failures != wantFailures || stats.Slow != tt.wantSlow || stats.LastLatency != tt.wantLatency || stats.LastError != tt.wantError {
+			t.Errorf("%s: %+v", tt.name, stats)
+		}
+		if bad := tt.wantError != "" || tt.wantSlow > 0; bad != (stats.State == Degraded) || bad && !stats.Since.Equal(start.Add(tt.wantLatency)) {
+			t.Errorf("%s: %s since %v", tt.name, stats.State, stats.Since)
+		}
+	}
+}
+
+// TestProbeTimeout checks a store stuck in a read fails the probe after the
+// timeout, and the next probes too while it stays stuck.
+func TestProbeTimeout(t *testing.T) {
+	store := newFakeStore()
+	store.stuck = make(chan struct{})
+	p := NewProbedSessions(store, ProbeOptions{Timeout: 10 * time.Millisecond, DegradeAfter: 2})
+	for i, want := range []HealthState{Healthy, Degraded} {
+		if got := p.Probe(); got != want {
+			t.Errorf("probe %d: %v, want %v", i, got, want)
+		}
+	}
+	if stats := p.Stats(); stats.Failures != 2 || stats.LastError != errProbeTimeout.Error() || stats.LastLatency != 10*time.Millisecond && stats.LastLatency != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	close(store.stuck)
+	store.mut.Lock()
+	store.stuck = nil
+	store.mut.Unlock()
+	deadline := time.Now().Add(10 * time.Second)
+	for p.Stats().Failures == p.Stats().Probes {
+		if time.Now().After(deadline) {
+			t.Fatal("the store never recovered")
+		}
+		time.Sleep(time.Millisecond)
+		p.Probe()
+	}
+}
+
+// TestProbeStart runs the probes on the interval: the probe session has the
+// key prefix and is deleted once stopped.
+func TestProbeStart(t *testing.T) {
+	store := NewMemorySessions()
+	p := NewProbedSessions(store, ProbeOptions{Interval: time.Millisecond, KeyPrefix: "health:"})
+	if !strings.HasPrefix(p.probeID, "health:") {
+		t.Errorf("probe session %q, want the prefix", p.probeID)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	deadline := time.Now().Add(10 * time.Second)
+	for p.Stats().Probes < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("no probes")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := store.Get(p.probeID); !ok {
+		t.Error("no probe session")
+	}
+	if n := p.Len(); n != 1 {
+		t.Errorf("Len %d, want the probe session", n)
+	}
+	cancel()
+	for {
+		if _, ok := store.Get(p.probeID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the probe session outlived the prober")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats := p.Stats(); stats.Failures != 0 || stats.State != Healthy {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestHealthStateString(t *testing.T) {
+	tests := []struct {
+		state HealthState
+		want  string
+	}{
+		{Healthy, "healthy"},
+		{Degraded, "degraded"},
+		{Down, "down"},
+		{HealthState(9), "unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.state.String(); got != tt.want {
+			t.Errorf("%d: %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
@@ -43,14 +43,27 @@ func (s *MemorySessions) Get(id string) (Session, bool) {
 
 // Set stores a session in the MemorySessions map with the given id.
 // It locks the mutex to ensure thread safety before modifying the map.
+// Sessions of other implementations are stored as a MemorySession copy of
+// their values; only the values of the sessions implementing Snapshotter can be
+// copied, the later changes of the original session are not seen.
 //
 // Parameters:
 //   - id: A string representing the session ID.
-//   - session: A Session interface that will be type asserted to *MemorySession.
+//   - session: The session to store.
 func (s *MemorySessions) Set(id string, session Session) {
+	memorySession, ok := session.(*MemorySession)
+	if !ok || memorySession == nil {
+		memorySession = NewMemorySession(id)
+		// A nil *MemorySession is a Snapshotter too, with nothing to copy.
+		if snapshotter, isSnapshotter := session.(Snapshotter); isSnapshotter && !ok {
+			if snapshot := snapshotter.Snapshot(); snapshot != nil {
+				memorySession.data = snapshot
+			}
+		}
+	}
 	s.mut.Lock()
 	defer s.mut.Unlock()
-	s.sessions[id] = session.(*MemorySession)
+	s.sessions[id] = memorySession
 }
 
 // Delete removes a session from the memory store by its ID.
@@ -65,6 +78,13 @@ func (s *MemorySessions) Delete(id string) {
 	delete(s.sessions, id)
 }
 
+// Len returns the number of sessions of the store, see Counter.
+func (s *MemorySessions) Len() int {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return len(s.sessions)
+}
+
 // New creates a new MemorySession with a unique identifier.
 // It generates a new UUID string to use as the session ID.
 //
@@ -131,3 +151,26 @@ func (s *MemorySession) Exists(key string) bool {
 	_, ok := s.data[key]
 	return ok
 }
+
+// Update implements Updater: fn runs under the session lock.
+func (s *MemorySession) Update(key string, fn func(value any) any) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	value := fn(s.data[key])
+	if value == nil {
+		delete(s.data, key)
+		return
+	}
+	s.data[key] = value
+}
+
+// Snapshot implements Snapshotter.
+func (s *MemorySession) Snapshot() map[string]any {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	snapshot := make(map[string]any, len(s.data))
+	for key, value := range s.data {
+		snapshot[key] = value
+	}
+	return snapshot
+}
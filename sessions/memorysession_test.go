@@ -0,0 +1,85 @@
+package sessions
+
+import (
+	"testing"
+)
+
+// foreignSession is a Session of another implementation, wrapped in a
+// snapshotSession to be a Snapshotter.
+type foreignSession struct {
+	id   string
+	data map[string]any
+}
+
+func (f *foreignSession) Id() string                { return f.id }
+func (f *foreignSession) Get(key string) any        { return f.data[key] }
+func (f *foreignSession) Set(key string, value any) { f.data[key] = value }
+func (f *foreignSession) Exists(key string) bool    { _, ok := f.data[key]; return ok }
+
+type snapshotSession struct {
+	*foreignSession
+}
+
+func (s snapshotSession) Snapshot() map[string]any {
+	if s.data == nil {
+		return nil
+	}
+	snapshot := make(map[string]any, len(s.data))
+	for key, value := range s.data {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// TestMemorySessionsSetForeign checks the store accepts the sessions of any
+// implementation without panicking.
+func TestMemorySessionsSetForeign(t *testing.T) {
+	own := NewMemorySession("own")
+	own.Set("user", "alice")
+	tests := []struct {
+		name    string
+		session Session
+		// want is the user value read back, nil when not copied.
+		want any
+	}{
+		{"memory session", own, "alice"},
+		{"snapshotter", snapshotSession{&foreignSession{id: "snap", data: map[string]any{"user": "bob"}}}, "bob"},
+		{"nil snapshot", snapshotSession{&foreignSession{id: "empty"}}, nil},
+		{"no snapshot", &foreignSession{id: "plain", data: map[string]any{"user": "carol"}}, nil},
+		{"nil memory session", (*MemorySession)(nil), nil},
+		{"nil", nil, nil},
+	}
+	for _, tt := range tests {
+		store := NewMemorySessions()
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					t.Errorf("%s: panicked with %v", tt.name, recovered)
+				}
+			}()
+			store.Set("id", tt.session)
+		}()
+		got, ok := store.Get("id")
+		if !ok {
+			t.Errorf("%s: not stored", tt.name)
+			continue
+		}
+		if got.Id() != "id" && got != tt.session {
+			t.Errorf("%s: id %q", tt.name, got.Id())
+		}
+		if value := got.Get("user"); value != tt.want {
+			t.Errorf("%s: user %v, want %v", tt.name, value, tt.want)
+		}
+		if got != tt.session {
+			got.Set("visits", 1)
+		}
+	}
+	// The copy doesn't see the later changes of the original session.
+	foreign := snapshotSession{&foreignSession{id: "snap", data: map[string]any{"user": "bob"}}}
+	store := NewMemorySessions()
+	store.Set("id", foreign)
+	foreign.Set("user", "mallory")
+	if got, _ := store.Get("id"); got.Get("user") != "bob" {
+		t.Errorf("copy changed to %v", got.Get("user"))
+	}
+}
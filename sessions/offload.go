@@ -0,0 +1,439 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DefaultOffloadThreshold is the size above which the values are offloaded
+// when no threshold is configured.
+const DefaultOffloadThreshold = 16 << 10
+
+// ErrBlobNotFound is returned by the blob stores for a missing blob.
+var ErrBlobNotFound = errors.New("sessions: blob not found")
+
+// BlobStore stores the values offloaded from the sessions, see
+// OffloadingSessions. MemoryBlobs and FileBlobs implement it; an
+// implementation over an object storage such as S3 is left to the
+// applications.
+type BlobStore interface {
+	// Put stores data under key, replacing the previous blob.
+	Put(key string, data []byte) error
+	// Get returns the blob stored under key, or ErrBlobNotFound.
+	Get(key string) ([]byte, error)
+	// Delete deletes the blob stored under key; a missing blob is not an error.
+	Delete(key string) error
+}
+
+// MemoryBlobs is a BlobStore keeping the blobs in memory, e.g. for the tests.
+type MemoryBlobs struct {
+	blobs map[string][]byte
+	mut   *sync.RWMutex
+}
+
+// NewMemoryBlobs creates an empty MemoryBlobs.
+func NewMemoryBlobs() *MemoryBlobs {
+	return &MemoryBlobs{blobs: map[string][]byte{}, mut: &sync.RWMutex{}}
+}
+
+// Put implements BlobStore.
+func (m *MemoryBlobs) Put(key string, data []byte) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.blobs[key] = bytes.Clone(data)
+	return nil
+}
+
+// Get implements BlobStore.
+func (m *MemoryBlobs) Get(key string) ([]byte, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	data, ok := m.blobs[key]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return bytes.Clone(data), nil
+}
+
+// Delete implements BlobStore.
+func (m *MemoryBlobs) Delete(key string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+// Len returns the number of blobs.
+func (m *MemoryBlobs) Len() int {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return len(m.blobs)
+}
+
+// FileBlobs is a BlobStore keeping each blob in a file of a directory.
+type FileBlobs struct {
+	dir string
+}
+
+// NewFileBlobs creates a FileBlobs storing the blobs in dir, created if needed.
+func NewFileBlobs(dir string) (*FileBlobs, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileBlobs{dir: dir}, nil
+}
+
+// path returns the path of the file of key, escaped to a single file name.
+func (f *FileBlobs) path(key string) string {
+	return filepath.Join(f.dir, url.PathEscape(key))
+}
+
+// Put implements BlobStore. The blob is written to a temporary file renamed
+// over the previous one, a reader never sees a partial blob.
+func (f *FileBlobs) Put(key string, data []byte) error {
+	file, err := os.CreateTemp(f.dir, ".blob-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), f.path(key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// Get implements BlobStore.
+func (f *FileBlobs) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+// Delete implements BlobStore.
+func (f *FileBlobs) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// BlobRef is the value stored in the session in place of an offloaded value.
+// Its fields are exported for the stores serializing the sessions.
+type BlobRef struct {
+	// Key is the key of the blob in the BlobStore.
+	Key string
+	// Kind is how the value is encoded: "string", "bytes" or "gob".
+	Kind string
+	// Size is the size of the blob in bytes.
+	Size int
+}
+
+// OffloadOptions configures OffloadingSessions.
+type OffloadOptions struct {
+	// Threshold is the size in bytes above which a value is offloaded.
+	// Defaults to DefaultOffloadThreshold.
+	Threshold int
+}
+
+// OffloadingSessions decorates a session store so that the large values are
+// kept out of it: a value above the threshold is written to a BlobStore and
+// replaced in the session by a BlobRef, which Get resolves transparently.
+// Strings and byte slices are offloaded as is; maps, slices and structs are
+// encoded with encoding/gob, their types must be registered with gob.Register
+// or they are kept in the session. The values read are cached by the session
+// returned by Get, i.e. for the request.
+//
+// The blobs are deleted when their key is overwritten and when the session is
+// deleted, in which case the store must implement Snapshotter, as
+// MemorySession does. The stores evicting the sessions on their own, e.g. on
+// expiry, pass the values of the evicted sessions to Collect.
+//
+// A blob missing from the BlobStore reads as a missing key, with a warning
+// logged: the request goes on without the value.
+type OffloadingSessions struct {
+	Sessions
+	blobs     BlobStore
+	threshold int
+	// updateMut serializes the updates of the sessions not implementing
+	// Updater.
+	updateMut *sync.Mutex
+}
+
+// NewOffloadingSessions decorates the store with value offloading.
+//
+// Parameters:
+//   - store: The session store.
+//   - blobs: The store of the offloaded values.
+//   - opts: The offloading options.
+func NewOffloadingSessions(store Sessions, blobs BlobStore, opts OffloadOptions) *OffloadingSessions {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultOffloadThreshold
+	}
+	return &OffloadingSessions{Sessions: store, blobs: blobs, threshold: opts.Threshold, updateMut: &sync.Mutex{}}
+}
+
+// Len implements Counter for the stores counting their sessions; it returns
+// -1 for the others.
+func (o *OffloadingSessions) Len() int {
+	if counter, ok := o.Sessions.(Counter); ok {
+		return counter.Len()
+	}
+	return -1
+}
+
+// Get returns the session with the offloaded values resolved.
+func (o *OffloadingSessions) Get(id string) (Session, bool) {
+	session, ok := o.Sessions.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return o.wrap(session), true
+}
+
+// Set stores the session, unwrapped.
+func (o *OffloadingSessions) Set(id string, session Session) {
+	if wrapped, ok := session.(*offloadingSession); ok {
+		session = wrapped.Session
+	}
+	o.Sessions.Set(id, session)
+}
+
+// New creates a session with value offloading.
+func (o *OffloadingSessions) New() Session {
+	return o.wrap(o.Sessions.New())
+}
+
+// Delete deletes the session and its blobs.
+func (o *OffloadingSessions) Delete(id string) {
+	if session, ok := o.Sessions.Get(id); ok {
+		if snapshotter, ok := session.(Snapshotter); ok {
+			defer o.Collect(snapshotter.Snapshot())
+		}
+	}
+	o.Sessions.Delete(id)
+}
+
+// Collect deletes the blobs referenced by the values of a session gone from
+// the store, e.g. from the eviction hook of a store expiring its sessions.
+func (o *OffloadingSessions) Collect(values map[string]any) {
+	for _, value := range values {
+		if ref, ok := value.(BlobRef); ok {
+			o.deleteBlob(ref)
+		}
+	}
+}
+
+func (o *OffloadingSessions) deleteBlob(ref BlobRef) {
+	if err := o.blobs.Delete(ref.Key); err != nil {
+		slog.Warn("Offloaded session value not deleted", "blob", ref.Key, "error", err)
+	}
+}
+
+func (o *OffloadingSessions) wrap(session Session) *offloadingSession {
+	if wrapped, ok := session.(*offloadingSession); ok {
+		return wrapped
+	}
+	return &offloadingSession{Session: session, store: o, cache: map[string]any{}, mut: &sync.Mutex{}}
+}
+
+// sessionHash returns a short hash of a session ID, which identifies the
+// session in the logs and the blob keys without disclosing the ID,
+// like the session_hash of the request correlation.
+func sessionHash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// gobValue wraps the values encoded with gob, so that their type is encoded too.
+type gobValue struct {
+	Value any
+}
+
+// encode returns the blob of a value above the threshold, or ok false for the
+// values kept in the session.
+func (o *OffloadingSessions) encode(value any) (data []byte, kind string, ok bool) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), "string", len(v) > o.threshold
+	case []byte:
+		return v, "bytes", len(v) > o.threshold
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Pointer:
+	default:
+		return nil, "", false
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobValue{Value: value}); err != nil {
+		// Unregistered or unencodable types stay in the session.
+		return nil, "", false
+	}
+	return buf.Bytes(), "gob", buf.Len() > o.threshold
+}
+
+// decode returns the value of a blob.
+func decode(ref BlobRef, data []byte) (any, error) {
+	switch ref.Kind {
+	case "string":
+		return string(data), nil
+	case "bytes":
+		return data, nil
+	}
+	var value gobValue
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value.Value, nil
+}
+
+// offloadingSession is a session of OffloadingSessions, caching the values
+// read from the blob store.
+type offloadingSession struct {
+	Session
+	store *OffloadingSessions
+	cache map[string]any
+	mut   *sync.Mutex
+}
+
+// Get returns the value of the key, read from the blob store when offloaded.
+func (s *offloadingSession) Get(key string) any {
+	return s.resolve(key, s.Session.Get(key))
+}
+
+// Exists reports whether the key exists, an offloaded value whose blob is
+// missing reading as missing.
+func (s *offloadingSession) Exists(key string) bool {
+	stored := s.Session.Get(key)
+	if _, ok := stored.(BlobRef); ok {
+		return s.resolve(key, stored) != nil
+	}
+	return s.Session.Exists(key)
+}
+
+// resolve returns the value a stored value stands for.
+func (s *offloadingSession) resolve(key string, stored any) any {
+	ref, ok := stored.(BlobRef)
+	if !ok {
+		return stored
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if value, ok := s.cache[ref.Key]; ok {
+		return value
+	}
+	data, err := s.store.blobs.Get(ref.Key)
+	var value any
+	if err == nil {
+		value, err = decode(ref, data)
+	}
+	if err != nil {
+		slog.Warn("Offloaded session value unavailable", "session_hash", sessionHash(s.Id()), "key", key, "blob", ref.Key, "error", err)
+		// Missing for the rest of the request, logged once.
+		s.cache[ref.Key] = nil
+		return nil
+	}
+	s.cache[ref.Key] = value
+	return value
+}
+
+// Set stores the value, offloaded above the threshold. The blob of the
+// previous value is deleted: the value is swapped atomically when the session
+// implements Updater, so that the blob replaced by a concurrent Set is not
+// left behind.
+func (s *offloadingSession) Set(key string, value any) {
+	stored := s.offload(key, value)
+	var previous any
+	if updater, ok := s.Session.(Updater); ok && stored != nil {
+		updater.Update(key, func(current any) any {
+			previous = current
+			return stored
+		})
+	} else {
+		s.store.updateMut.Lock()
+		previous = s.Session.Get(key)
+		s.Session.Set(key, stored)
+		s.store.updateMut.Unlock()
+	}
+	if ref, ok := previous.(BlobRef); ok {
+		s.store.deleteBlob(ref)
+	}
+}
+
+// offload returns the value to store in the session for value.
+func (s *offloadingSession) offload(key string, value any) any {
+	data, kind, ok := s.store.encode(value)
+	if !ok {
+		return value
+	}
+	ref := BlobRef{Key: sessionHash(s.Id()) + "/" + uuid.NewString(), Kind: kind, Size: len(data)}
+	if err := s.store.blobs.Put(ref.Key, data); err != nil {
+		slog.Warn("Session value not offloaded", "session_hash", sessionHash(s.Id()), "key", key, "size", len(data), "error", err)
+		return value
+	}
+	s.mut.Lock()
+	s.cache[ref.Key] = value
+	s.mut.Unlock()
+	return ref
+}
+
+// Update implements Updater, atomically when the session does.
+func (s *offloadingSession) Update(key string, fn func(value any) any) {
+	var previous any
+	update := func(stored any) any {
+		previous = stored
+		value := fn(s.resolve(key, stored))
+		if value == nil {
+			return nil
+		}
+		return s.offload(key, value)
+	}
+	if updater, ok := s.Session.(Updater); ok {
+		updater.Update(key, update)
+	} else {
+		s.store.updateMut.Lock()
+		stored := update(s.Session.Get(key))
+		s.Session.Set(key, stored)
+		s.store.updateMut.Unlock()
+	}
+	if ref, ok := previous.(BlobRef); ok {
+		s.store.deleteBlob(ref)
+	}
+}
+
+// Snapshot implements Snapshotter, with the offloaded values resolved. It
+// returns nil when the session doesn't implement Snapshotter.
+func (s *offloadingSession) Snapshot() map[string]any {
+	snapshotter, ok := s.Session.(Snapshotter)
+	if !ok {
+		return nil
+	}
+	snapshot := snapshotter.Snapshot()
+	for key, value := range snapshot {
+		if value = s.resolve(key, value); value == nil {
+			delete(snapshot, key)
+			continue
+		}
+		snapshot[key] = value
+	}
+	return snapshot
+}
@@ -0,0 +1,245 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type draft struct {
+	Title string
+	Lines []string
+}
+
+func init() {
+	gob.Register(draft{})
+}
+
+// TestOffloadThreshold sets values around the threshold: the larger ones are
+// stored as a BlobRef, and every one reads back as set.
+func TestOffloadThreshold(t *testing.T) {
+	large := strings.Repeat("x", 200)
+	tests := []struct {
+		name        string
+		value       any
+		wantKind    string
+		wantOffload bool
+	}{
+		{"small string", "short", "", false},
+		{"string at the threshold", strings.Repeat("x", 100), "", false},
+		{"string above the threshold", strings.Repeat("x", 101), "string", true},
+		{"bytes", []byte(large), "bytes", true},
+		{"small bytes", []byte("short"), "", false},
+		{"registered struct", draft{Title: "report", Lines: []string{large}}, "gob", true},
+		{"unregistered struct", struct{ Text string }{large}, "", false},
+		{"number", 42, "", false},
+	}
+	for _, tt := range tests {
+		memory := NewMemorySessions()
+		blobs := NewMemoryBlobs()
+		store := NewOffloadingSessions(memory, blobs, OffloadOptions{Threshold: 100})
+		session := store.New()
+		session.Set("value", tt.value)
+		stored, _ := memory.Get(session.Id())
+		ref, offloaded := stored.Get("value").(BlobRef)
+		if offloaded != tt.wantOffload || ref.Kind != tt.wantKind {
+			t.Errorf("%s: stored %#v", tt.name, stored.Get("value"))
+		}
+		if offloaded && blobs.Len() != 1 {
+			t.Errorf("%s: %d blobs", tt.name, blobs.Len())
+		}
+		if offloaded && strings.Contains(ref.Key, session.Id()) {
+			t.Errorf("%s: the blob key %s holds the session ID", tt.name, ref.Key)
+		}
+		// Read back from a new request, without the cache of the session set.
+		again, ok := store.Get(session.Id())
+		if !ok || !reflect.DeepEqual(again.Get("value"), tt.value) || !again.Exists("value") {
+			t.Errorf("%s: read back %#v", tt.name, again.Get("value"))
+		}
+	}
+	if store := NewOffloadingSessions(NewMemorySessions(), NewMemoryBlobs(), OffloadOptions{}); store.threshold != DefaultOffloadThreshold {
+		t.Errorf("default threshold %d", store.threshold)
+	}
+}
+
+// countingBlobs counts the reads of its blobs.
+type countingBlobs struct {
+	*MemoryBlobs
+	gets int
+}
+
+func (c *countingBlobs) Get(key string) ([]byte, error) {
+	c.gets++
+	return c.MemoryBlobs.Get(key)
+}
+
+// TestOffloadCache checks a value is read from the blob store once per request.
+func TestOffloadCache(t *testing.T) {
+	blobs := &countingBlobs{MemoryBlobs: NewMemoryBlobs()}
+	store := NewOffloadingSessions(NewMemorySessions(), blobs, OffloadOptions{Threshold: 10})
+	id := store.New().Id()
+	session, _ := store.Get(id)
+	session.Set("report", strings.Repeat("r", 50))
+	session.Get("report")
+	if blobs.gets != 0 {
+		t.Errorf("%d reads of the value just set", blobs.gets)
+	}
+	session, _ = store.Get(id)
+	for range 3 {
+		session.Get("report")
+	}
+	session.Exists("report")
+	if blobs.gets != 1 {
+		t.Errorf("%d reads in a request, want 1", blobs.gets)
+	}
+}
+
+// TestOffloadCollect checks the blobs are deleted with their key, on
+// overwrite, with their session, or when a store evicting a session passes
+// its values to Collect.
+func TestOffloadCollect(t *testing.T) {
+	large := strings.Repeat("x", 50)
+	tests := []struct {
+		name string
+		// change acts on the session id, which holds the large "a" and "b".
+		change    func(store *OffloadingSessions, memory *MemorySessions, id string)
+		wantBlobs int
+	}{
+		{"nothing", func(*OffloadingSessions, *MemorySessions, string) {}, 2},
+		{"overwritten by a large value", func(store *OffloadingSessions, _ *MemorySessions, id string) {
+			session, _ := store.Get(id)
+			session.Set("a", large+"2")
+		}, 2},
+		{"overwritten by a small value", func(store *OffloadingSessions, _ *MemorySessions, id string) {
+			session, _ := store.Get(id)
+			session.Set("a", "small")
+		}, 1},
+		{"deleted by Update", func(store *OffloadingSessions, _ *MemorySessions, id string) {
+			session, _ := store.Get(id)
+			session.(Updater).Update("a", func(any) any { return nil })
+		}, 1},
+		{"updated", func(store *OffloadingSessions, _ *MemorySessions, id string) {
+			session, _ := store.Get(id)
+			session.(Updater).Update("a", func(value any) any { return value.(string) + "!" })
+		}, 2},
+		{"session deleted", func(store *OffloadingSessions, _ *MemorySessions, id string) {
+			store.Delete(id)
+		}, 0},
+		{"session evicted", func(store *OffloadingSessions, memory *MemorySessions, id string) {
+			evicted, _ := memory.Get(id)
+			memory.Delete(id)
+			store.Collect(evicted.(Snapshotter).Snapshot())
+		}, 0},
+	}
+	for _, tt := range tests {
+		memory := NewMemorySessions()
+		blobs := NewMemoryBlobs()
+		store := NewOffloadingSessions(memory, blobs, OffloadOptions{Threshold: 10})
+		session := store.New()
+		session.Set("a", large)
+		session.Set("b", large)
+		session.Set("small", "small")
+		tt.change(store, memory, session.Id())
+		if n := blobs.Len(); n != tt.wantBlobs {
+			t.Errorf("%s: %d blobs, want %d", tt.name, n, tt.wantBlobs)
+		}
+	}
+}
+
+// TestOffloadMissingBlob checks a missing blob reads as a missing key, with a
+// single warning per request not disclosing the session ID.
+func TestOffloadMissingBlob(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	blobs := NewMemoryBlobs()
+	store := NewOffloadingSessions(NewMemorySessions(), blobs, OffloadOptions{Threshold: 10})
+	id := store.New().Id()
+	session, _ := store.Get(id)
+	session.Set("report", strings.Repeat("r", 50))
+	session.Set("small", "kept")
+	clear(blobs.blobs)
+
+	session, _ = store.Get(id)
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"Get", session.Get("report"), nil},
+		{"Get again", session.Get("report"), nil},
+		{"Exists", session.Exists("report"), false},
+		{"other key", session.Get("small"), "kept"},
+		{"Snapshot", session.(Snapshotter).Snapshot(), map[string]any{"small": "kept"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: %#v, want %#v", tt.name, tt.got, tt.want)
+		}
+	}
+	if n := strings.Count(logs.String(), "Offloaded session value unavailable"); n != 1 {
+		t.Errorf("%d warnings, want 1: %s", n, logs.String())
+	}
+	if strings.Contains(logs.String(), id) || !strings.Contains(logs.String(), "session_hash="+sessionHash(id)) {
+		t.Errorf("log %s", logs.String())
+	}
+}
+
+func TestOffloadLen(t *testing.T) {
+	counted := NewOffloadingSessions(NewMemorySessions(), NewMemoryBlobs(), OffloadOptions{})
+	counted.New()
+	counted.New()
+	uncounted := NewOffloadingSessions(struct{ Sessions }{NewMemorySessions()}, NewMemoryBlobs(), OffloadOptions{})
+	tests := []struct {
+		name  string
+		store Sessions
+		want  int
+	}{
+		{"counting store", counted, 2},
+		{"other store", uncounted, -1},
+		{"probed", NewProbedSessions(counted, ProbeOptions{}), 2},
+	}
+	for _, tt := range tests {
+		counter, ok := tt.store.(Counter)
+		if !ok {
+			t.Errorf("%s: not a Counter", tt.name)
+			continue
+		}
+		if n := counter.Len(); n != tt.want {
+			t.Errorf("%s: Len %d, want %d", tt.name, n, tt.want)
+		}
+	}
+}
+
+func TestFileBlobs(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := NewFileBlobs(dir + "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewOffloadingSessions(NewMemorySessions(), blobs, OffloadOptions{Threshold: 10})
+	session := store.New()
+	session.Set("report", strings.Repeat("f", 50))
+	again, _ := store.Get(session.Id())
+	if again.Get("report") != strings.Repeat("f", 50) {
+		t.Errorf("read back %#v", again.Get("report"))
+	}
+	entries, _ := os.ReadDir(dir + "/blobs")
+	if len(entries) != 1 || strings.HasPrefix(entries[0].Name(), ".blob-") {
+		t.Errorf("files %v", entries)
+	}
+	store.Delete(session.Id())
+	if entries, _ := os.ReadDir(dir + "/blobs"); len(entries) != 0 {
+		t.Errorf("files %v left", entries)
+	}
+	if err := blobs.Delete("missing"); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+	if _, err := blobs.Get("missing"); err != ErrBlobNotFound {
+		t.Errorf("Get missing: %v", err)
+	}
+}
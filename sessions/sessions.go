@@ -38,3 +38,28 @@ type Sessions interface {
 	// Create a new session with a new ID.
 	New() Session
 }
+
+// Updater is implemented by the sessions able to read-modify-write a value
+// atomically. The server updates the sessions not implementing it, e.g. for
+// the form tokens, with Get then Set under a mutex of the process shared by
+// several sessions: the updates of unrelated sessions may wait for each
+// other, and the updates by other processes of an external store are not
+// excluded. Implement it on the stores shared across processes.
+type Updater interface {
+	// Update replaces the value of the key by the result of fn, called with
+	// the current value (nil when missing), without any concurrent change of
+	// the key in between. Returning nil deletes the key.
+	Update(key string, fn func(value any) any)
+}
+
+// Counter is implemented by the stores able to count their sessions.
+type Counter interface {
+	// Len returns the number of sessions of the store.
+	Len() int
+}
+
+// Snapshotter is implemented by the sessions able to list their values.
+type Snapshotter interface {
+	// Snapshot returns a copy of the values of the session.
+	Snapshot() map[string]any
+}
@@ -0,0 +1,205 @@
+package sessions
+
+import (
+	"flag"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var (
+	stressWorkers    = flag.Int("stress.workers", 16, "goroutines of the session store stress tests")
+	stressIterations = flag.Int("stress.iterations", 500, "operations of each goroutine of the session store stress tests")
+)
+
+// stack returns the stores decorating each other like in a server: a
+// ProbedSessions over an OffloadingSessions over a MemorySessions, the
+// offloading threshold low enough for the large values of the tests.
+func stack() (*ProbedSessions, *MemorySessions, *MemoryBlobs) {
+	memory := NewMemorySessions()
+	blobs := NewMemoryBlobs()
+	offloading := NewOffloadingSessions(memory, blobs, OffloadOptions{Threshold: 16})
+	return NewProbedSessions(offloading, ProbeOptions{}), memory, blobs
+}
+
+// value returns the value written by worker w at iteration i, large enough
+// to be offloaded when large.
+func value(w, i int, large bool) string {
+	v := strconv.Itoa(w) + "/" + strconv.Itoa(i)
+	if large {
+		v += strings.Repeat(".", 32)
+	}
+	return v
+}
+
+// written parses a value written by value.
+func written(v any) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	w, i, ok := strings.Cut(strings.TrimRight(s, "."), "/")
+	if !ok {
+		return false
+	}
+	_, errW := strconv.Atoi(w)
+	_, errI := strconv.Atoi(i)
+	return errW == nil && errI == nil
+}
+
+// TestStackedStoresConcurrent hammers the stacked stores with concurrent Get,
+// Set, Update, New, Delete, Len and Snapshot, to be run with -race: the
+// shared sessions only ever read the values written to them, their counters
+// count every update, the sessions of a worker are gone once deleted, and no
+// blob outlives its session.
+func TestStackedStoresConcurrent(t *testing.T) {
+	store, memory, blobs := stack()
+	shared := make([]string, 8)
+	for i := range shared {
+		shared[i] = store.New().Id()
+	}
+	var updates atomic.Int64
+	var wg sync.WaitGroup
+	for w := range *stressWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			random := rand.New(rand.NewPCG(uint64(w), 0))
+			var owned []string
+			for i := range *stressIterations {
+				id := shared[random.IntN(len(shared))]
+				switch op := random.IntN(10); op {
+				case 0, 1:
+					session, ok := store.Get(id)
+					if !ok {
+						t.Errorf("shared session %s missing", id)
+						return
+					}
+					session.Set("value", value(w, i, op == 1))
+					store.Set(id, session)
+				case 2:
+					session, _ := store.Get(id)
+					session.(Updater).Update("count", func(v any) any {
+						n, _ := v.(int)
+						return n + 1
+					})
+					updates.Add(1)
+				case 3:
+					session, _ := store.Get(id)
+					if v := session.Get("value"); v != nil && !written(v) {
+						t.Errorf("shared session %s reads %v", id, v)
+					}
+					for key, v := range session.(Snapshotter).Snapshot() {
+						if key == "value" && !written(v) {
+							t.Errorf("snapshot of the shared session %s reads %v", id, v)
+						}
+					}
+				case 4, 5:
+					session := store.New()
+					session.Set("value", value(w, i, op == 5))
+					owned = append(owned, session.Id())
+				case 6:
+					// The expiry of a session of the worker.
+					if len(owned) == 0 {
+						continue
+					}
+					n := random.IntN(len(owned))
+					id := owned[n]
+					owned = append(owned[:n], owned[n+1:]...)
+					store.Delete(id)
+					if _, ok := store.Get(id); ok {
+						t.Errorf("session %s found after Delete", id)
+					}
+				case 7:
+					if n := memory.Len(); n < len(shared) {
+						t.Errorf("%d sessions in the store, fewer than the %d shared ones", n, len(shared))
+					}
+				case 8:
+					for _, id := range owned {
+						session, ok := store.Get(id)
+						if !ok {
+							t.Errorf("session %s of worker %d missing", id, w)
+							continue
+						}
+						if v := session.Get("value"); !written(v) {
+							t.Errorf("session %s of worker %d reads %v", id, w, v)
+						}
+					}
+				case 9:
+					if w == 0 {
+						store.Probe()
+					}
+				}
+			}
+			for _, id := range owned {
+				store.Delete(id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stats := store.Stats(); stats.Failures != 0 {
+		t.Errorf("%d probes failed, the last with %q", stats.Failures, stats.LastError)
+	}
+	var counted int64
+	for _, id := range shared {
+		session, _ := store.Get(id)
+		n, _ := session.Get("count").(int)
+		counted += int64(n)
+		store.Delete(id)
+	}
+	if counted != updates.Load() {
+		t.Errorf("the counters sum to %d, want the %d updates", counted, updates.Load())
+	}
+	// The token of the probe is large enough to be offloaded too.
+	store.Delete(store.probeID)
+	if n := blobs.Len(); n != 0 {
+		t.Errorf("%d blobs left once every session is deleted", n)
+	}
+}
+
+// barrierSession holds the first two reads of "value" until both happened,
+// like two requests setting the value at the same time would.
+type barrierSession struct {
+	*MemorySession
+	reads   *atomic.Int32
+	arrived *sync.WaitGroup
+}
+
+func (s *barrierSession) Get(key string) any {
+	value := s.MemorySession.Get(key)
+	if key == "value" && s.reads.Add(1) <= 2 {
+		s.arrived.Done()
+		s.arrived.Wait()
+	}
+	return value
+}
+
+// TestOffloadingSetConcurrent overwrites a large value from two goroutines at
+// once: the blob of each value replaced is deleted.
+func TestOffloadingSetConcurrent(t *testing.T) {
+	blobs := NewMemoryBlobs()
+	store := NewOffloadingSessions(NewMemorySessions(), blobs, OffloadOptions{Threshold: 16})
+	barrier := &barrierSession{MemorySession: NewMemorySession("id"), reads: &atomic.Int32{}, arrived: &sync.WaitGroup{}}
+	session := store.wrap(barrier)
+	// The first value is set before the barrier is armed.
+	barrier.reads.Store(2)
+	session.Set("value", value(0, 0, true))
+	barrier.reads.Store(0)
+	barrier.arrived.Add(2)
+	var wg sync.WaitGroup
+	for w := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session.Set("value", value(w, 1, true))
+		}()
+	}
+	wg.Wait()
+	if n := blobs.Len(); n != 1 {
+		t.Errorf("%d blobs for a single offloaded value", n)
+	}
+}
@@ -0,0 +1,89 @@
+package serverlib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// SocketOptions configures the sockets of the TCP listeners, see
+// ServerConfig.SocketOptions. The options unsupported by the platform fail
+// the start with an error wrapping errors.ErrUnsupported.
+type SocketOptions struct {
+	// ReusePort sets SO_REUSEPORT before the bind, so that several processes
+	// listen on the same port, the kernel balancing the connections between
+	// them. Supported on Linux and the BSDs.
+	ReusePort bool
+	// DisableNoDelay clears TCP_NODELAY, set by Go on every connection, so
+	// that the kernel coalesces the small writes (Nagle's algorithm).
+	DisableNoDelay bool
+	// Backlog, if positive, is the length of the queue of the connections
+	// not accepted yet, instead of the default of the system, which still
+	// caps it (net.core.somaxconn on Linux). Supported on Linux and the BSDs.
+	Backlog int
+	// Control, if not nil, is called with the socket before the bind, after
+	// the options above, to set any other option.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// listen binds the TCP address with the options.
+func (o *SocketOptions) listen(address string) (net.Listener, error) {
+	config := net.ListenConfig{Control: o.control}
+	l, err := config.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if o.Backlog > 0 {
+		if err := setBacklog(l, o.Backlog); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("listen backlog: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// control sets the options of the socket before the bind.
+func (o *SocketOptions) control(network, address string, c syscall.RawConn) error {
+	if o.ReusePort {
+		if err := setReusePort(c); err != nil {
+			return fmt.Errorf("SO_REUSEPORT: %w", err)
+		}
+	}
+	if o.Control != nil {
+		return o.Control(network, address, c)
+	}
+	return nil
+}
+
+// wrap returns the listener applying the options to the connections.
+func (o *SocketOptions) wrap(l net.Listener) net.Listener {
+	if o == nil || !o.DisableNoDelay {
+		return l
+	}
+	return noDelayListener{Listener: l}
+}
+
+// noDelayListener clears TCP_NODELAY on the connections it accepts.
+type noDelayListener struct {
+	net.Listener
+}
+
+func (l noDelayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(false)
+	}
+	return conn, err
+}
+
+// rawControl runs fn with the file descriptor of the socket.
+func rawControl(c syscall.RawConn, fn func(fd uintptr) error) error {
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = fn(fd)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
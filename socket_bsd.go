@@ -0,0 +1,6 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serverlib
+
+// soReusePort is SO_REUSEPORT, missing from the syscall package.
+const soReusePort = 0x200
@@ -0,0 +1,4 @@
+package serverlib
+
+// soReusePort is SO_REUSEPORT, missing from the syscall package.
+const soReusePort = 0xf
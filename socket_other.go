@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package serverlib
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func setReusePort(syscall.RawConn) error {
+	return errors.ErrUnsupported
+}
+
+func setBacklog(net.Listener, int) error {
+	return errors.ErrUnsupported
+}
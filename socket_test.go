@@ -0,0 +1,53 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+// TestSocketOptions starts servers with SocketOptions: they serve with a
+// backlog and with Nagle's algorithm, and an error of Control fails the
+// start.
+func TestSocketOptions(t *testing.T) {
+	var controlled []string
+	failure := errors.New("option refused")
+	tests := []struct {
+		name    string
+		options *SocketOptions
+		wantErr error
+	}{
+		{"backlog", &SocketOptions{Backlog: 16}, nil},
+		{"Nagle's algorithm", &SocketOptions{DisableNoDelay: true}, nil},
+		{"Control", &SocketOptions{Control: func(network, address string, c syscall.RawConn) error {
+			controlled = append(controlled, network+" "+address)
+			return nil
+		}}, nil},
+		{"Control failing", &SocketOptions{Control: func(string, string, syscall.RawConn) error { return failure }}, failure},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", SocketOptions: tt.options})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+		if tt.wantErr != nil {
+			var bindErr *BindError
+			if err := s.Start(); !errors.Is(err, tt.wantErr) || !errors.As(err, &bindErr) {
+				t.Errorf("%s: %v, want a BindError of %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		done := startServer(s)
+		<-s.Ready()
+		resp, err := http.Get("http://" + s.Addr() + "/")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else {
+			resp.Body.Close()
+		}
+		s.Stop()
+		waitStart(t, done)
+	}
+	if len(controlled) != 1 || controlled[0] != "tcp4 127.0.0.1:0" {
+		t.Errorf("Control called with %v", controlled)
+	}
+}
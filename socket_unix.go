@@ -0,0 +1,31 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serverlib
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func setReusePort(c syscall.RawConn) error {
+	return rawControl(c, func(fd uintptr) error {
+		return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+}
+
+// setBacklog calls listen(2) again on the listening socket, which updates
+// its backlog.
+func setBacklog(l net.Listener, backlog int) error {
+	tcp, ok := l.(*net.TCPListener)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	c, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return rawControl(c, func(fd uintptr) error {
+		return syscall.Listen(int(fd), backlog)
+	})
+}
@@ -0,0 +1,90 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serverlib
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+// TestReusePort starts two servers on the same port: with ReusePort both
+// serve, without the second fails to bind.
+func TestReusePort(t *testing.T) {
+	options := &SocketOptions{ReusePort: true, Control: func(network, address string, c syscall.RawConn) error {
+		// Control runs after the options of SocketOptions.
+		return rawControl(c, func(fd uintptr) error {
+			if value, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort); err != nil || value == 0 {
+				return errors.New("SO_REUSEPORT not set")
+			}
+			return nil
+		})
+	}}
+	first := NewServer(ServerConfig{Address: "127.0.0.1:0", SocketOptions: options})
+	done := startServer(first)
+	<-first.Ready()
+	defer func() {
+		first.Stop()
+		waitStart(t, done)
+	}()
+	second := NewServer(ServerConfig{Address: first.Addr(), SocketOptions: options})
+	second.GET("/", func(w http.ResponseWriter, r *http.Request) {})
+	secondDone := startServer(second)
+	select {
+	case <-second.Ready():
+	case err := <-secondDone:
+		t.Fatalf("second server with ReusePort: %v", err)
+	}
+	second.Stop()
+	waitStart(t, secondDone)
+
+	var bindErr *BindError
+	if err := NewServer(ServerConfig{Address: first.Addr()}).Start(); !errors.As(err, &bindErr) {
+		t.Errorf("second server without ReusePort: %v", err)
+	}
+}
+
+// TestDisableNoDelay checks the connections accepted by the listener of
+// DisableNoDelay have TCP_NODELAY cleared, and the others set.
+func TestDisableNoDelay(t *testing.T) {
+	tests := []struct {
+		options *SocketOptions
+		want    int
+	}{
+		{nil, 1},
+		{&SocketOptions{}, 1},
+		{&SocketOptions{DisableNoDelay: true}, 0},
+	}
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapped := tt.options.wrap(l)
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := wrapped.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value int
+		rawControl(raw, func(fd uintptr) error {
+			value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+			return err
+		})
+		if err != nil || value != tt.want {
+			t.Errorf("%+v: TCP_NODELAY %d %v, want %d", tt.options, value, err, tt.want)
+		}
+		conn.Close()
+		client.Close()
+		wrapped.Close()
+	}
+}
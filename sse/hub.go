@@ -0,0 +1,324 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the hub options.
+const (
+	DefaultQueueSize     = 64
+	DefaultFlushInterval = 50 * time.Millisecond
+)
+
+// maxRetainedBatch is the largest write buffer a client keeps between its
+// ticks, so that a burst doesn't hold memory for every client.
+const maxRetainedBatch = 64 << 10
+
+var (
+	// ErrClientDropped is returned by Subscribe when the client was too slow
+	// to keep up with the events under the DropClient policy.
+	ErrClientDropped = errors.New("sse: client dropped, too slow")
+	// ErrHubClosed is returned by Subscribe when the hub is closed.
+	ErrHubClosed = errors.New("sse: hub closed")
+)
+
+// SlowClientPolicy selects what happens to a client whose queue is full.
+type SlowClientPolicy int
+
+const (
+	// DropClient ends the subscription of the client, which can reconnect.
+	DropClient SlowClientPolicy = iota
+	// SkipEvents discards the events that don't fit in the queue of the client.
+	SkipEvents
+)
+
+// HubOptions configures a Hub.
+type HubOptions struct {
+	// QueueSize is the number of events queued per client. Defaults to DefaultQueueSize.
+	QueueSize int
+	// Policy applies to the clients whose queue is full, DropClient by default.
+	Policy SlowClientPolicy
+	// FlushInterval is the tick batching the queued events of a client into a
+	// single write and flush. Defaults to DefaultFlushInterval; a negative
+	// interval writes the events as soon as they are queued.
+	FlushInterval time.Duration
+	// Context closes the hub when done, e.g. the background context of the
+	// server cancelled when it stops.
+	Context context.Context
+}
+
+// HubStats holds the counters of a Hub.
+type HubStats struct {
+	// Clients is the number of subscribed clients.
+	Clients int
+	// Dropped is the number of clients dropped by the DropClient policy.
+	Dropped uint64
+	// Skipped is the number of events discarded by the SkipEvents policy.
+	Skipped uint64
+	// Published is the number of published events.
+	Published uint64
+	// PublishLatency is the average delay between the publication of an event
+	// and its write to a client.
+	PublishLatency time.Duration
+	// MaxPublishLatency is the largest of these delays.
+	MaxPublishLatency time.Duration
+}
+
+// Hub broadcasts events to the streams subscribed to their topics. An event is
+// encoded once whatever the number of clients, and the events queued for a
+// client are written with a single write and flush per tick.
+type Hub struct {
+	opts   HubOptions
+	mut    *sync.RWMutex
+	topics map[string]map[*hubClient]struct{}
+	closed chan struct{}
+	close  sync.Once
+
+	clients      atomic.Int64
+	dropped      atomic.Uint64
+	skipped      atomic.Uint64
+	published    atomic.Uint64
+	latencySum   atomic.Int64
+	latencyCount atomic.Int64
+	latencyMax   atomic.Int64
+}
+
+// hubClient is the queue of a subscribed stream.
+type hubClient struct {
+	mut     sync.Mutex
+	queue   []queuedEvent
+	size    int
+	notify  chan struct{}
+	dropped chan struct{}
+	// spare and batch are reused by the writes of the client, spare
+	// becoming the queue of the next tick.
+	spare []queuedEvent
+	batch []byte
+}
+
+type queuedEvent struct {
+	data      []byte
+	published time.Time
+}
+
+// NewHub creates a Hub with the given options.
+func NewHub(opts ...HubOptions) *Hub {
+	var options HubOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.FlushInterval == 0 {
+		options.FlushInterval = DefaultFlushInterval
+	}
+	h := &Hub{
+		opts:   options,
+		mut:    &sync.RWMutex{},
+		topics: make(map[string]map[*hubClient]struct{}),
+		closed: make(chan struct{}),
+	}
+	if options.Context != nil {
+		go func() {
+			select {
+			case <-options.Context.Done():
+				h.Close()
+			case <-h.closed:
+			}
+		}()
+	}
+	return h
+}
+
+// Subscribe delivers the events published on the topics to the stream. It
+// blocks until the client goes away, is dropped or the hub is closed, so that
+// the handler keeps the response open:
+//
+//	stream, err := sse.NewSSEStream(w, r)
+//	...
+//	hub.Subscribe(stream, "news")
+//
+// Parameters:
+//   - stream: The stream of the client.
+//   - topics: The topics the client receives the events of.
+//
+// Returns:
+//   - error: nil when the client went away, ErrClientDropped, ErrHubClosed or
+//     the write error of the stream.
+func (h *Hub) Subscribe(stream *SSEStream, topics ...string) error {
+	client := &hubClient{
+		size:    h.opts.QueueSize,
+		notify:  make(chan struct{}, 1),
+		dropped: make(chan struct{}),
+	}
+	h.mut.Lock()
+	for _, topic := range topics {
+		subscribers, ok := h.topics[topic]
+		if !ok {
+			subscribers = make(map[*hubClient]struct{})
+			h.topics[topic] = subscribers
+		}
+		subscribers[client] = struct{}{}
+	}
+	h.mut.Unlock()
+	h.clients.Add(1)
+	defer h.unsubscribe(client, topics)
+
+	var tick <-chan time.Time
+	if h.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(h.opts.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	pending := false
+	for {
+		select {
+		case <-stream.Done():
+			return nil
+		case <-client.dropped:
+			return ErrClientDropped
+		case <-h.closed:
+			// The events already queued are delivered before leaving.
+			h.write(stream, client)
+			return ErrHubClosed
+		case <-client.notify:
+			if tick != nil {
+				pending = true
+				continue
+			}
+		case <-tick:
+			if !pending {
+				continue
+			}
+		}
+		pending = false
+		if err := h.write(stream, client); err != nil {
+			return err
+		}
+	}
+}
+
+// write sends the queued events of the client with a single write and flush.
+func (h *Hub) write(stream *SSEStream, client *hubClient) error {
+	client.mut.Lock()
+	queue := client.queue
+	if len(queue) == 0 {
+		client.mut.Unlock()
+		return nil
+	}
+	if client.size >= 0 {
+		client.queue, client.spare = client.spare, nil
+	}
+	client.mut.Unlock()
+	batch := client.batch[:0]
+	for _, event := range queue {
+		batch = append(batch, event.data...)
+	}
+	if _, err := stream.w.Write(batch); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	now := time.Now()
+	for _, event := range queue {
+		h.recordLatency(now.Sub(event.published))
+	}
+	// The events are released for the next tick to reuse the queue.
+	clear(queue)
+	client.spare = queue[:0]
+	if cap(batch) <= maxRetainedBatch {
+		client.batch = batch
+	}
+	return nil
+}
+
+func (h *Hub) recordLatency(latency time.Duration) {
+	h.latencySum.Add(int64(latency))
+	h.latencyCount.Add(1)
+	for {
+		current := h.latencyMax.Load()
+		if int64(latency) <= current || h.latencyMax.CompareAndSwap(current, int64(latency)) {
+			return
+		}
+	}
+}
+
+func (h *Hub) unsubscribe(client *hubClient, topics []string) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for _, topic := range topics {
+		subscribers := h.topics[topic]
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	h.clients.Add(-1)
+}
+
+// Publish queues the event for the clients subscribed to the topic. The event
+// is encoded once; Publish doesn't wait for the clients, which get the event
+// on their next tick.
+func (h *Hub) Publish(topic string, event Event) {
+	queued := queuedEvent{data: Encode(event), published: time.Now()}
+	h.published.Add(1)
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+	for client := range h.topics[topic] {
+		h.enqueue(client, queued)
+	}
+}
+
+// enqueue adds the event to the queue of the client, applying the slow client
+// policy when it is full.
+func (h *Hub) enqueue(client *hubClient, event queuedEvent) {
+	client.mut.Lock()
+	defer client.mut.Unlock()
+	if client.size < 0 {
+		// Already dropped.
+		return
+	}
+	if len(client.queue) >= client.size {
+		if h.opts.Policy == SkipEvents {
+			h.skipped.Add(1)
+			return
+		}
+		client.size = -1
+		client.queue = nil
+		close(client.dropped)
+		h.dropped.Add(1)
+		return
+	}
+	client.queue = append(client.queue, event)
+	select {
+	case client.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Close ends every subscription after delivering the events already queued.
+// Later subscriptions return ErrHubClosed right away.
+func (h *Hub) Close() {
+	h.close.Do(func() {
+		close(h.closed)
+	})
+}
+
+// Stats returns the counters of the hub.
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		Clients:           int(h.clients.Load()),
+		Dropped:           h.dropped.Load(),
+		Skipped:           h.skipped.Load(),
+		Published:         h.published.Load(),
+		MaxPublishLatency: time.Duration(h.latencyMax.Load()),
+	}
+	if count := h.latencyCount.Load(); count > 0 {
+		stats.PublishLatency = time.Duration(h.latencySum.Load() / count)
+	}
+	return stats
+}
@@ -0,0 +1,338 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamWriter records the writes and the flushes of a stream. With block,
+// the writes wait for it to be closed, signalling writing first.
+type streamWriter struct {
+	mut     sync.Mutex
+	header  http.Header
+	writes  [][]byte
+	flushes int
+	block   chan struct{}
+	writing chan struct{}
+}
+
+func (w *streamWriter) Header() http.Header {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *streamWriter) WriteHeader(int) {}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.block != nil {
+		select {
+		case w.writing <- struct{}{}:
+		default:
+		}
+		<-w.block
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.writes = append(w.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func (w *streamWriter) Flush() {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.flushes++
+}
+
+// body returns the bytes written and the number of writes.
+func (w *streamWriter) body() (string, int) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return string(bytes.Join(w.writes, nil)), len(w.writes)
+}
+
+// subscriber is a client of a hub.
+type subscriber struct {
+	w      *streamWriter
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// subscribe subscribes a client writing to w to the topics, waiting for the
+// hub to count it.
+func subscribe(t *testing.T, h *Hub, w *streamWriter, topics ...string) *subscriber {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream, err := NewSSEStream(w, httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := h.Stats().Clients
+	sub := &subscriber{w: w, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		sub.done <- h.Subscribe(stream, topics...)
+	}()
+	waitFor(t, func() bool { return h.Stats().Clients > clients })
+	return sub
+}
+
+// result returns the error of Subscribe.
+func (s *subscriber) result(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-s.done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Subscribe did not return")
+		return nil
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// encoded returns the wire format of the events numbered from first to last.
+func encoded(first, last int) string {
+	var b bytes.Buffer
+	for i := first; i <= last; i++ {
+		b.Write(Encode(numbered(i)))
+	}
+	return b.String()
+}
+
+func numbered(i int) Event {
+	return Event{ID: strconv.Itoa(i), Data: "event " + strconv.Itoa(i)}
+}
+
+// TestHubTopics publishes on several topics: each client gets the events of
+// its topics once, in order, with a single write per tick.
+func TestHubTopics(t *testing.T) {
+	news, sports, weather := Event{Event: "news", Data: "n"}, Event{Event: "sports", Data: "s"}, Event{Event: "weather", Data: "w"}
+	tests := []struct {
+		name       string
+		topics     []string
+		wantBody   string
+		wantWrites int
+	}{
+		{"two topics", []string{"news", "sports"}, string(Encode(news)) + string(Encode(sports)) + string(Encode(news)), 1},
+		{"one topic", []string{"news"}, string(Encode(news)) + string(Encode(news)), 1},
+		{"same topic twice", []string{"sports", "sports"}, string(Encode(sports)), 1},
+		{"other topic", []string{"traffic"}, "", 0},
+	}
+	// The tick never fires: Close writes the queued events.
+	h := NewHub(HubOptions{FlushInterval: time.Hour})
+	subscribers := make([]*subscriber, len(tests))
+	for i, tt := range tests {
+		subscribers[i] = subscribe(t, h, &streamWriter{}, tt.topics...)
+	}
+	h.Publish("news", news)
+	h.Publish("sports", sports)
+	h.Publish("weather", weather)
+	h.Publish("news", news)
+	h.Close()
+	for i, tt := range tests {
+		if err := subscribers[i].result(t); !errors.Is(err, ErrHubClosed) {
+			t.Errorf("%s: %v, want ErrHubClosed", tt.name, err)
+		}
+		if body, writes := subscribers[i].w.body(); body != tt.wantBody || writes != tt.wantWrites {
+			t.Errorf("%s: %d writes of %q, want %d of %q", tt.name, writes, body, tt.wantWrites, tt.wantBody)
+		}
+	}
+	stats := h.Stats()
+	if stats.Clients != 0 || stats.Published != 4 || stats.Dropped != 0 || stats.Skipped != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats.PublishLatency <= 0 || stats.MaxPublishLatency < stats.PublishLatency {
+		t.Errorf("latency %v, max %v", stats.PublishLatency, stats.MaxPublishLatency)
+	}
+}
+
+// TestHubFlushInterval checks the events are written without closing the
+// hub, on the tick or right away.
+func TestHubFlushInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"default tick", 0},
+		{"short tick", time.Millisecond},
+		{"immediate", -1},
+	}
+	for _, tt := range tests {
+		h := NewHub(HubOptions{FlushInterval: tt.interval})
+		sub := subscribe(t, h, &streamWriter{}, "t")
+		for i := range 3 {
+			h.Publish("t", numbered(i))
+		}
+		waitFor(t, func() bool { body, _ := sub.w.body(); return body == encoded(0, 2) })
+		if _, writes := sub.w.body(); writes > 3 {
+			t.Errorf("%s: %d writes for 3 events", tt.name, writes)
+		}
+		sub.cancel()
+		if err := sub.result(t); err != nil {
+			t.Errorf("%s: %v after the client went away, want nil", tt.name, err)
+		}
+		h.Close()
+	}
+}
+
+// TestHubSlowClient blocks a client in a write while the events are
+// published: its queue fills, and the policy drops the client or skips the
+// events, while a fast client gets every event.
+func TestHubSlowClient(t *testing.T) {
+	const queueSize = 4
+	tests := []struct {
+		name        string
+		policy      SlowClientPolicy
+		wantErr     error
+		wantBody    string
+		wantDropped uint64
+		wantSkipped uint64
+	}{
+		{"drop", DropClient, ErrClientDropped, encoded(0, 0), 1, 0},
+		{"skip", SkipEvents, ErrHubClosed, encoded(0, queueSize) + string(Encode(numbered(99))), 0, 2},
+	}
+	for _, tt := range tests {
+		h := NewHub(HubOptions{QueueSize: queueSize, Policy: tt.policy, FlushInterval: -1})
+		slow := subscribe(t, h, &streamWriter{block: make(chan struct{}), writing: make(chan struct{}, 1)}, "t")
+		fast := subscribe(t, h, &streamWriter{}, "t")
+
+		h.Publish("t", numbered(0))
+		<-slow.w.writing
+		for i := 1; i <= queueSize+2; i++ {
+			h.Publish("t", numbered(i))
+			// The fast client keeps up.
+			waitFor(t, func() bool { body, _ := fast.w.body(); return body == encoded(0, i) })
+		}
+		stats := h.Stats()
+		if stats.Dropped != tt.wantDropped || stats.Skipped != tt.wantSkipped {
+			t.Errorf("%s: %d dropped, %d skipped, want %d and %d", tt.name, stats.Dropped, stats.Skipped, tt.wantDropped, tt.wantSkipped)
+		}
+		close(slow.w.block)
+		var err error
+		if tt.policy == DropClient {
+			err = slow.result(t)
+		} else {
+			// The queue has room again.
+			waitFor(t, func() bool { body, _ := slow.w.body(); return body == encoded(0, queueSize) })
+		}
+		h.Publish("t", numbered(99))
+		h.Close()
+		if tt.policy == SkipEvents {
+			err = slow.result(t)
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if body, _ := slow.w.body(); body != tt.wantBody {
+			t.Errorf("%s: the slow client got %q, want %q", tt.name, body, tt.wantBody)
+		}
+		if err := fast.result(t); !errors.Is(err, ErrHubClosed) {
+			t.Errorf("%s: the fast client returned %v, want ErrHubClosed", tt.name, err)
+		}
+		if body, _ := fast.w.body(); body != encoded(0, queueSize+2)+string(Encode(numbered(99))) {
+			t.Errorf("%s: the fast client got %q", tt.name, body)
+		}
+		if clients := h.Stats().Clients; clients != 0 {
+			t.Errorf("%s: %d clients left", tt.name, clients)
+		}
+	}
+}
+
+func TestHubClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tests := []struct {
+		name  string
+		hub   *Hub
+		close func()
+	}{
+		{"closed", NewHub(), nil},
+		{"context", NewHub(HubOptions{Context: ctx}), cancel},
+	}
+	for _, tt := range tests {
+		if tt.close == nil {
+			tt.hub.Close()
+			tt.hub.Close()
+		} else {
+			sub := subscribe(t, tt.hub, &streamWriter{}, "t")
+			tt.close()
+			if err := sub.result(t); !errors.Is(err, ErrHubClosed) {
+				t.Errorf("%s: %v, want ErrHubClosed", tt.name, err)
+			}
+		}
+		stream, _ := NewSSEStream(&streamWriter{}, httptest.NewRequest(http.MethodGet, "/events", nil))
+		if err := tt.hub.Subscribe(stream, "t"); !errors.Is(err, ErrHubClosed) {
+			t.Errorf("%s: subscribed with %v, want ErrHubClosed", tt.name, err)
+		}
+	}
+}
+
+func TestNewSSEStreamUnsupported(t *testing.T) {
+	// The recorder is flushable; hide it behind a plain writer.
+	var w struct{ http.ResponseWriter }
+	w.ResponseWriter = httptest.NewRecorder()
+	if _, err := NewSSEStream(w, httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrStreamingUnsupported) {
+		t.Errorf("%v, want ErrStreamingUnsupported", err)
+	}
+}
+
+// discardStream is a flushable writer discarding the events.
+type discardStream struct {
+	header http.Header
+}
+
+func (d *discardStream) Header() http.Header         { return d.header }
+func (d *discardStream) WriteHeader(int)             {}
+func (d *discardStream) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardStream) Flush()                      {}
+
+// BenchmarkHubFanOut publishes to 5000 clients, the events being written on
+// the default tick.
+func BenchmarkHubFanOut(b *testing.B) {
+	const clientCount = 5000
+	h := NewHub(HubOptions{QueueSize: 1024, Policy: SkipEvents})
+	var wg sync.WaitGroup
+	for range clientCount {
+		stream, err := NewSSEStream(&discardStream{header: http.Header{}}, httptest.NewRequest(http.MethodGet, "/events", nil))
+		if err != nil {
+			b.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Subscribe(stream, "t")
+		}()
+	}
+	for h.Stats().Clients < clientCount {
+		time.Sleep(time.Millisecond)
+	}
+	event := Event{Event: "price", Data: `{"symbol":"ACME","price":42.5}`}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		h.Publish("t", event)
+	}
+	b.StopTimer()
+	h.Close()
+	wg.Wait()
+	b.ReportMetric(float64(h.Stats().Skipped)/float64(b.N), "skipped/op")
+}
@@ -0,0 +1,104 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrStreamingUnsupported is returned when the response writer can't be flushed.
+var ErrStreamingUnsupported = errors.New("sse: streaming unsupported by the response writer")
+
+// Event is a server-sent event.
+type Event struct {
+	// ID sets the last event ID of the client, if not empty.
+	ID string
+	// Event is the event type, "message" when empty.
+	Event string
+	// Data is the event payload. Multi-line data is sent as several data lines.
+	Data string
+	// Retry sets the reconnection delay of the client, if positive.
+	Retry time.Duration
+}
+
+// SSEStream is a server-sent events stream to a single client.
+type SSEStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	done    <-chan struct{}
+}
+
+// NewSSEStream starts a server-sent events response: it writes the event
+// stream headers and flushes them to the client.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request. The stream is done when its context is.
+//
+// Returns:
+//   - *SSEStream: The stream to send events on.
+//   - error: ErrStreamingUnsupported if w can't be flushed.
+func NewSSEStream(w http.ResponseWriter, r *http.Request) (*SSEStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEStream{w: w, flusher: flusher, done: r.Context().Done()}, nil
+}
+
+// Send writes the event to the client and flushes it.
+func (s *SSEStream) Send(event Event) error {
+	if _, err := s.w.Write(Encode(event)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Comment writes a comment line, commonly used as a keep-alive.
+func (s *SSEStream) Comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", strings.ReplaceAll(text, "\n", " ")); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Done returns a channel closed when the client goes away.
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// lineBreaks removes the line breaks of the single-line fields.
+var lineBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// Encode returns the wire format of an event. The clients split the lines on
+// CR, LF and CRLF alike: the line breaks are removed from the ID and the
+// type, and each line of the data is sent as a data line.
+func Encode(event Event) []byte {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + lineBreaks.Replace(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + lineBreaks.Replace(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", event.Retry.Milliseconds())
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,28 @@
+package sse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"data", Event{Data: "hello"}, "data: hello\n\n"},
+		{"empty", Event{}, "data: \n\n"},
+		{"every field", Event{ID: "7", Event: "update", Data: "hello", Retry: 3 * time.Second}, "id: 7\nevent: update\nretry: 3000\ndata: hello\n\n"},
+		{"multi-line data", Event{Data: "a\nb"}, "data: a\ndata: b\n\n"},
+		{"CRLF data", Event{Data: "a\r\nb"}, "data: a\ndata: b\n\n"},
+		{"CR data", Event{Data: "a\rid: 9\rb"}, "data: a\ndata: id: 9\ndata: b\n\n"},
+		{"line breaks in the ID and type", Event{ID: "1\r\nevent: x", Event: "a\rb\nc", Data: "d"}, "id: 1event: x\nevent: abc\ndata: d\n\n"},
+		{"sub-second retry", Event{Retry: 1500 * time.Microsecond, Data: "d"}, "retry: 1\ndata: d\n\n"},
+	}
+	for _, tt := range tests {
+		if got := string(Encode(tt.event)); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
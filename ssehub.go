@@ -0,0 +1,17 @@
+package serverlib
+
+import (
+	"github.com/Morditux/serverlib/sse"
+)
+
+// NewSSEHub creates a server-sent events hub closed when the server stops:
+// the subscribed clients get the events already queued, then their handlers
+// return so that the long-lived responses don't hold the shutdown.
+func (s *Server) NewSSEHub(opts ...sse.HubOptions) *sse.Hub {
+	var options sse.HubOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	options.Context = s.background
+	return sse.NewHub(options)
+}
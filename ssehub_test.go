@@ -0,0 +1,57 @@
+package serverlib
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/sse"
+)
+
+// TestSSEHubStop checks the subscriptions of a server hub end when the server
+// stops, the queued events delivered, so that Stop doesn't wait for the
+// long-lived responses.
+func TestSSEHubStop(t *testing.T) {
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", ShutdownTimeout: 10 * time.Second})
+	hub := s.NewSSEHub(sse.HubOptions{FlushInterval: time.Hour})
+	s.GET("/events", func(w http.ResponseWriter, r *http.Request) {
+		stream, err := sse.NewSSEStream(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		hub.Subscribe(stream, "news")
+	})
+	done := startServer(s)
+	<-s.Ready()
+	resp, err := http.Get("http://" + s.Addr() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type %q", got)
+	}
+	for hub.Stats().Clients == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Publish("news", sse.Event{Data: "closing"})
+
+	start := time.Now()
+	if err := s.Stop(); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	waitStart(t, done)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stopped in %v", elapsed)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "data: closing\n\n") {
+		t.Errorf("body %q, want the queued event", body)
+	}
+	if clients := hub.Stats().Clients; clients != 0 {
+		t.Errorf("%d clients after Stop", clients)
+	}
+}
@@ -0,0 +1,134 @@
+package serverlib
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// State is the state of the server, see Server.State.
+type State int
+
+const (
+	// StateCreated is the state of a server not started yet, or whose start
+	// failed before it served, e.g. the address being in use.
+	StateCreated State = iota
+	// StateRunning is the state of a server serving the requests.
+	StateRunning
+	// StateDraining is the state of a running server after Drain, or once
+	// Shutdown or Stop started.
+	StateDraining
+	// StateStopped is the state of a server stopped by Shutdown or Stop,
+	// before it started included, or whose listener failed.
+	StateStopped
+)
+
+// String returns the name of the state, e.g. "running".
+func (st State) String() string {
+	switch st {
+	case StateCreated:
+		return "created"
+	case StateRunning:
+		return "running"
+	case StateDraining:
+		return "draining"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// ErrAlreadyRunning is returned by Start, and the other ways to start the
+// server, when it is running already.
+var ErrAlreadyRunning = errors.New("server already running")
+
+// State returns the state of the server. It is safe to call concurrently
+// with Start, Shutdown and Stop.
+func (s *Server) State() State {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	if s.state == StateRunning && (s.draining.Load() || s.shuttingDown.Load()) {
+		return StateDraining
+	}
+	return s.state
+}
+
+// running runs serve, one of the ways to start the server, in the running
+// state.
+//
+// Returns:
+//   - error: ErrAlreadyRunning when the server runs already,
+//     http.ErrServerClosed once stopped, otherwise the error of serve.
+func (s *Server) running(serve func() error) error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	err := serve()
+	s.end()
+	return err
+}
+
+// begin moves a created server to the running state.
+func (s *Server) begin() error {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	switch s.state {
+	case StateRunning:
+		return ErrAlreadyRunning
+	case StateStopped:
+		return http.ErrServerClosed
+	}
+	s.state = StateRunning
+	return nil
+}
+
+// end is called once serve returned: a server that never served goes back
+// to the created state and can be started again, one whose listener failed
+// is stopped. A server being stopped is left to Shutdown or Stop.
+func (s *Server) end() {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	if s.state != StateRunning || s.shuttingDown.Load() {
+		return
+	}
+	select {
+	case <-s.components.ready:
+		slog.Info("Server stopped on a listener failure", "address", s.Addr())
+		s.state = StateStopped
+		s.unpublish()
+	default:
+		s.state = StateCreated
+	}
+}
+
+// beginStop claims the stop of a running server. A server not started yet is
+// stopped right away, so that a Start racing with the stop, e.g.
+// "go s.Start(); s.Shutdown(ctx)", returns http.ErrServerClosed instead of
+// serving on. Its components, started with Go before Start, are cancelled.
+//
+// Returns:
+//   - bool: false if the server is not running or is being stopped already,
+//     Shutdown and Stop then being no-ops.
+func (s *Server) beginStop() bool {
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	if s.state == StateCreated {
+		slog.Info("Server stopped before starting")
+		s.state = StateStopped
+		s.components.cancel()
+		return false
+	}
+	if s.state != StateRunning {
+		return false
+	}
+	return !s.shuttingDown.Swap(true)
+}
+
+// stopped moves the server to the stopped state, once Shutdown or Stop
+// completed.
+func (s *Server) stopped() {
+	s.unpublish()
+	s.stateMut.Lock()
+	defer s.stateMut.Unlock()
+	s.state = StateStopped
+}
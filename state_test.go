@@ -0,0 +1,320 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer() *Server {
+	return NewServer(ServerConfig{Address: "127.0.0.1:0"})
+}
+
+// startServer starts s in the background and returns the error of Start.
+func startServer(s *Server) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Start()
+	}()
+	return done
+}
+
+func waitStart(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start did not return")
+		return nil
+	}
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(s *Server) error
+	}{
+		{"Shutdown", func(s *Server) error { return s.Shutdown(context.Background()) }},
+		{"Stop", func(s *Server) error { return s.Stop() }},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		if err := tt.stop(s); err != nil {
+			t.Errorf("%s before Start: %v", tt.name, err)
+		}
+		if got := s.State(); got != StateStopped {
+			t.Errorf("%s before Start: state %s, want %s", tt.name, got, StateStopped)
+		}
+		if err := waitStart(t, startServer(s)); !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("Start after %s: %v, want http.ErrServerClosed", tt.name, err)
+		}
+	}
+}
+
+// TestShutdownRacingStart shuts the server down right after starting it in
+// a goroutine: Start returns whichever runs first.
+func TestShutdownRacingStart(t *testing.T) {
+	for range 20 {
+		s := newTestServer()
+		done := startServer(s)
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+		if err := waitStart(t, done); !errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("Start: %v, want http.ErrServerClosed", err)
+		}
+		if got := s.State(); got != StateStopped {
+			t.Fatalf("state %s, want %s", got, StateStopped)
+		}
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	s := newTestServer()
+	done := startServer(s)
+	<-s.Ready()
+	if err := s.Start(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("second Start: %v, want ErrAlreadyRunning", err)
+	}
+	if got := s.State(); got != StateRunning {
+		t.Errorf("state %s, want %s", got, StateRunning)
+	}
+	if err := s.Stop(); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+	if err := waitStart(t, done); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Start: %v, want http.ErrServerClosed", err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+}
+
+// TestConcurrentLifecycle hammers Start, Stop, Shutdown, Restart and State
+// from several goroutines, to be run with -race: every call returns, a
+// single Start serves and the server ends stopped. The Stops begin once the
+// other Starts returned.
+func TestConcurrentLifecycle(t *testing.T) {
+	for range 10 {
+		s := newTestServer()
+		const goroutines = 8
+		starts := make(chan error, goroutines)
+		stops := make(chan error, 3*goroutines)
+		var started, stopping sync.WaitGroup
+		for range goroutines {
+			started.Add(1)
+			go func() {
+				defer started.Done()
+				starts <- s.Start()
+			}()
+		}
+		<-s.Ready()
+		// Every Start but the serving one returns while the server runs:
+		// one reaching begin after a Stop would return ErrServerClosed too.
+		for range goroutines - 1 {
+			if err := <-starts; !errors.Is(err, ErrAlreadyRunning) {
+				t.Errorf("Start of a running server: %v", err)
+			}
+		}
+		for range goroutines {
+			stopping.Add(3)
+			go func() {
+				defer stopping.Done()
+				if err := s.Restart(context.Background()); err != nil && !errors.Is(err, ErrNotRunning) && !errors.Is(err, http.ErrServerClosed) {
+					stops <- err
+				}
+			}()
+			go func() {
+				defer stopping.Done()
+				s.State()
+				stops <- s.Shutdown(context.Background())
+			}()
+			go func() {
+				defer stopping.Done()
+				stops <- s.Stop()
+			}()
+		}
+		stopping.Wait()
+		started.Wait()
+		close(starts)
+		close(stops)
+
+		if err := <-starts; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("serving Start: %v, want http.ErrServerClosed", err)
+		}
+		for err := range stops {
+			if err != nil {
+				t.Errorf("stop: %v", err)
+			}
+		}
+		if got := s.State(); got != StateStopped {
+			t.Errorf("state %s, want %s", got, StateStopped)
+		}
+	}
+}
+
+// TestRestartKeepsServing restarts a running server concurrently with the
+// requests: each request is answered.
+func TestRestartKeepsServing(t *testing.T) {
+	s := newTestServer()
+	s.GET("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	addr := "http://" + s.Addr() + "/ping"
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				resp, err := client.Get(addr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	for range 3 {
+		if err := s.Restart(context.Background()); err != nil {
+			t.Fatalf("Restart: %v", err)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("request: %v", err)
+	}
+	if got := s.State(); got != StateRunning {
+		t.Errorf("state %s, want %s", got, StateRunning)
+	}
+}
+
+// TestStartContextShutdownTimeout ends StartContext while a request is in
+// progress: the request completes within a positive ShutdownTimeout, is cut
+// when it takes longer, and is cut right away with a negative one, like Stop.
+func TestStartContextShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// hold is how long the request takes once the shutdown started.
+		hold          time.Duration
+		wantTimeout   bool
+		wantCompleted bool
+	}{
+		{"completed", 5 * time.Second, 0, false, true},
+		{"timed out", 100 * time.Millisecond, time.Minute, true, false},
+		{"negative", -1, time.Minute, false, false},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", ShutdownTimeout: tt.timeout})
+		started := make(chan struct{})
+		s.GET("/slow", func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			select {
+			case <-time.After(tt.hold):
+			case <-r.Context().Done():
+			}
+			w.Write([]byte("completed"))
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.StartContext(ctx)
+		}()
+		<-s.Ready()
+		body := make(chan string, 1)
+		go func() {
+			resp, err := http.Get("http://" + s.Addr() + "/slow")
+			if err != nil {
+				body <- "cut"
+				return
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			body <- string(data)
+		}()
+		<-started
+		begin := time.Now()
+		cancel()
+		err := waitStart(t, done)
+		var timeoutErr *ShutdownTimeoutError
+		if errors.As(err, &timeoutErr) != tt.wantTimeout || (!tt.wantTimeout && err != nil) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if got := <-body; (got == "completed") != tt.wantCompleted {
+			t.Errorf("%s: request %q", tt.name, got)
+		}
+		if elapsed := time.Since(begin); elapsed > 5*time.Second {
+			t.Errorf("%s: stopped after %s", tt.name, elapsed)
+		}
+	}
+}
+
+// TestServe serves a listener bound by the caller: Addr is the configured
+// address before the start and the address of the listener once serving,
+// and the listener is closed when the server stops.
+func TestServe(t *testing.T) {
+	s := NewServer(ServerConfig{Address: "localhost:8080"})
+	if addr := s.Addr(); addr != "localhost:8080" {
+		t.Errorf("Addr before the start %q", addr)
+	}
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served"))
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	<-s.Ready()
+	if addr := s.Addr(); addr != l.Addr().String() {
+		t.Errorf("Addr %q, want %q", addr, l.Addr())
+	}
+	resp, err := http.Get("http://" + s.Addr() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "served" {
+		t.Errorf("body %q", body)
+	}
+	if err := s.Serve(l); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("Serve of a running server: %v", err)
+	}
+	s.Stop()
+	if err := waitStart(t, done); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve: %v", err)
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("listener open after Stop: %v", err)
+	}
+
+	// The actual port of an address on port 0.
+	s = newTestServer()
+	started := startServer(s)
+	<-s.Ready()
+	if host, port, _ := net.SplitHostPort(s.Addr()); host != "127.0.0.1" || port == "0" || port == "" {
+		t.Errorf("Addr %q once started on port 0", s.Addr())
+	}
+	s.Stop()
+	waitStart(t, started)
+}
@@ -0,0 +1,322 @@
+package serverlib
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// precompressedEncodings are the sidecar files looked for by FileServer, by
+// order of preference at equal quality.
+var precompressedEncodings = []struct {
+	coding    string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// FileServer returns a handler serving the files of fsys like
+// http.FileServerFS, along with their precompressed variants: when the client
+// accepts br or gzip and the file has a sidecar, e.g. "app.js.br" or
+// "app.js.gz" next to "app.js", the sidecar is sent with its Content-Encoding
+// and the Content-Type of the original file. Of two sidecars, the one of the
+// highest quality in Accept-Encoding is sent, br on a tie. The responses
+// carry an ETag of the original file, distinct per encoding, and Vary:
+// Accept-Encoding when the file has a sidecar. Range requests are always
+// served from the original file: a range of the compressed bytes is useless
+// to the client.
+//
+// Parameters:
+//   - fsys: The file system of the files, e.g. os.DirFS("public").
+//
+// Returns:
+//   - http.Handler: The file handler, to mount with http.StripPrefix.
+func FileServer(fsys fs.FS) http.Handler {
+	return &fileServer{fsys: fsys, files: http.FileServerFS(fsys), hashes: &sync.Map{}}
+}
+
+type fileServer struct {
+	fsys  fs.FS
+	files http.Handler
+	// hashes are the ETags of the files without a modification time, by name.
+	hashes *sync.Map
+}
+
+func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(f.fsys, name)
+	if err != nil || info.IsDir() {
+		f.files.ServeHTTP(w, r)
+		return
+	}
+	etag := fileETag(info)
+	if info.ModTime().IsZero() {
+		// e.g. embed.FS: the size alone would keep the ETag of a changed file.
+		etag = f.contentETag(name, etag)
+	}
+	variants := false
+	type candidate struct {
+		coding, sidecar string
+		quality         float64
+	}
+	var candidates []candidate
+	for _, encoding := range precompressedEncodings {
+		sidecar, err := fs.Stat(f.fsys, name+encoding.extension)
+		if err != nil || sidecar.IsDir() {
+			continue
+		}
+		variants = true
+		if r.Header.Get("Range") != "" {
+			continue
+		}
+		if quality := encodingQuality(r, encoding.coding); quality > 0 {
+			candidates = append(candidates, candidate{encoding.coding, name + encoding.extension, quality})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int { return cmp.Compare(b.quality, a.quality) })
+	for _, c := range candidates {
+		if f.serveSidecar(w, r, name, info, c.sidecar, c.coding, etag) {
+			return
+		}
+	}
+	if variants {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	f.files.ServeHTTP(w, r)
+}
+
+// serveSidecar serves the sidecar of name encoded with coding. It reports
+// false, writing nothing, when the sidecar can't be read.
+func (f *fileServer) serveSidecar(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo, sidecar, coding, etag string) bool {
+	file, err := f.fsys.Open(sidecar)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+	header := w.Header()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		// Not sniffed: the content is compressed.
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Encoding", coding)
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("ETag", `"`+etag+"-"+coding+`"`)
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return true
+}
+
+// fileETag returns the entity tag of a file, from its size and its
+// modification time.
+func fileETag(info fs.FileInfo) string {
+	return strconv.FormatInt(info.Size(), 36) + "-" + strconv.FormatUint(uint64(info.ModTime().UnixNano()), 36)
+}
+
+// contentETag returns the entity tag of the content of the file name, hashed
+// once, or def when it can't be read.
+func (f *fileServer) contentETag(name, def string) string {
+	if etag, ok := f.hashes.Load(name); ok {
+		return etag.(string)
+	}
+	content, err := fs.ReadFile(f.fsys, name)
+	if err != nil {
+		return def
+	}
+	sum := sha256.Sum256(content)
+	etag := base64.RawURLEncoding.EncodeToString(sum[:12])
+	f.hashes.Store(name, etag)
+	return etag
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of the request
+// accepts the content coding, explicitly or with "*", with a non-zero quality.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	return encodingQuality(r, coding) > 0
+}
+
+// encodingQuality returns the quality of the content coding in the
+// Accept-Encoding header of the request, explicit or of "*", 0 when not
+// accepted.
+func encodingQuality(r *http.Request, coding string) float64 {
+	quality := 0.0
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != coding && name != "*" {
+				continue
+			}
+			q := 1.0
+			if value, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+			if !(q > 0) {
+				// Refused, NaN included.
+				q = 0
+			}
+			if name == coding {
+				// An explicit entry overrides the wildcard.
+				return q
+			}
+			quality = q
+		}
+	}
+	return quality
+}
+
+// StaticOption configures the files served by Server.Static.
+type StaticOption func(*staticHandler)
+
+// StaticIndex serves the file name, e.g. "index.html", of the directories
+// requested, instead of a 404.
+func StaticIndex(name string) StaticOption {
+	return func(h *staticHandler) {
+		h.index = name
+	}
+}
+
+// StaticListing lists the directories requested without an index file, like
+// http.FileServer does. Disabled by default, not to expose the files not
+// linked.
+func StaticListing() StaticOption {
+	return func(h *staticHandler) {
+		h.listing = true
+	}
+}
+
+// StaticMaxAge sets the Cache-Control of the files to "public, max-age=" the
+// duration, e.g. a year for the assets whose names change with their content.
+func StaticMaxAge(maxAge time.Duration) StaticOption {
+	return func(h *staticHandler) {
+		h.maxAge = maxAge
+	}
+}
+
+// staticHandler serves the files of Server.Static.
+type staticHandler struct {
+	fsys    fs.FS
+	files   http.Handler
+	index   string
+	listing bool
+	maxAge  time.Duration
+	server  *Server
+}
+
+// Static serves the files of the directory dir under urlPrefix, e.g.
+// s.Static("/assets", "public"), with FileServer: the precompressed
+// variants, the ETags and the ranges. The directories are not listed, see
+// StaticIndex and StaticListing. The files missing are answered like the
+// requests matching no route, see NotFound, and the paths escaping the
+// directory with ".." with a 400.
+//
+// Parameters:
+//   - urlPrefix: The path prefix of the files, relative to the base path
+//     like MountHandler.
+//   - dir: The directory of the files.
+//   - opts: Optional static options.
+func (s *Server) Static(urlPrefix, dir string, opts ...StaticOption) {
+	slog.Info("Serving static directory", "prefix", urlPrefix, "dir", dir)
+	s.StaticFS(urlPrefix, os.DirFS(dir), opts...)
+}
+
+// StaticFS serves the files of fsys under urlPrefix, e.g. an embed.FS
+// shipped in the binary, like Static. The file systems without modification
+// times, such as embed.FS, are served without Last-Modified, with an ETag of
+// the content instead.
+//
+// Parameters:
+//   - urlPrefix: The path prefix of the files, see Static.
+//   - fsys: The file system of the files, e.g. a sub tree of an embed.FS,
+//     see fs.Sub.
+//   - opts: Optional static options.
+func (s *Server) StaticFS(urlPrefix string, fsys fs.FS, opts ...StaticOption) {
+	h := &staticHandler{fsys: fsys, files: FileServer(fsys), server: s}
+	for _, opt := range opts {
+		opt(h)
+	}
+	prefix := strings.TrimSuffix("/"+strings.Trim(urlPrefix, "/"), "/")
+	pattern := "GET " + prefix + "/"
+	slog.Info("Registred static files", "pattern", pattern)
+	var handler http.Handler = h
+	if prefix != "" {
+		handler = stripPrefix(prefix, h)
+	}
+	s.router.Handle(pattern, handler)
+	s.addRoute(pattern, handler)
+}
+
+// traverses reports whether the decoded path has a ".." segment.
+func traverses(p string) bool {
+	for _, segment := range strings.FieldsFunc(p, func(c rune) bool { return c == '/' || c == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if traverses(r.URL.Path) || strings.Contains(r.URL.Path, "\x00") {
+		h.server.Error(w, r, NewHTTPError(http.StatusBadRequest, "invalid path"))
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		h.server.routeMissed(w, r, http.StatusNotFound)
+		return
+	}
+	if info.IsDir() && !h.listing {
+		index := path.Join(name, h.index)
+		if h.index == "" {
+			h.server.routeMissed(w, r, http.StatusNotFound)
+			return
+		}
+		if indexInfo, err := fs.Stat(h.fsys, index); err != nil || indexInfo.IsDir() {
+			h.server.routeMissed(w, r, http.StatusNotFound)
+			return
+		}
+		if h.index != "index.html" && strings.HasSuffix(r.URL.Path, "/") {
+			// FileServer serves index.html itself, and redirects the
+			// directories without a trailing slash.
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path, r2.URL.RawPath = "/"+index, ""
+			r = r2
+		}
+	}
+	if h.maxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.maxAge.Seconds())))
+	}
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,319 @@
+package serverlib
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// precompressedFS returns a file system with an app.js compressed both ways,
+// a style.css with a gzip sidecar only and a plain.txt without sidecar.
+func precompressedFS() fstest.MapFS {
+	modTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content), ModTime: modTime}
+	}
+	return fstest.MapFS{
+		"app.js":         file("console.log('identity');"),
+		"app.js.gz":      file("gzip bytes"),
+		"app.js.br":      file("br bytes"),
+		"style.css":      file("body { margin: 0 }"),
+		"style.css.gz":   file("gzip css"),
+		"plain.txt":      file("plain text"),
+		"dir.js":         file("dir"),
+		"dir.js.br/x":    file("not a sidecar"),
+		"nested/a.js":    file("nested identity"),
+		"nested/a.js.gz": file("nested gzip"),
+	}
+}
+
+// TestFileServer requests the files with each Accept-Encoding and Range, and
+// checks the variant served and its headers.
+func TestFileServer(t *testing.T) {
+	fsys := precompressedFS()
+	h := FileServer(fsys)
+	// etag returns the ETag of the file name served with coding.
+	etag := func(name, coding string) string {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if coding != "" {
+			return `"` + fileETag(info) + "-" + coding + `"`
+		}
+		return `"` + fileETag(info) + `"`
+	}
+	tests := []struct {
+		name       string
+		path       string
+		accept     string
+		header     map[string]string
+		wantCode   int
+		wantBody   string
+		wantCoding string
+		wantVary   bool
+		wantETag   string
+		wantType   string
+	}{
+		{"identity", "/app.js", "", nil, http.StatusOK, "console.log('identity');", "", true, etag("app.js", ""), "text/javascript; charset=utf-8"},
+		{"gzip", "/app.js", "gzip", nil, http.StatusOK, "gzip bytes", "gzip", true, etag("app.js", "gzip"), "text/javascript; charset=utf-8"},
+		{"br", "/app.js", "br", nil, http.StatusOK, "br bytes", "br", true, etag("app.js", "br"), "text/javascript; charset=utf-8"},
+		{"br preferred", "/app.js", "gzip, deflate, br", nil, http.StatusOK, "br bytes", "br", true, "", ""},
+		{"higher quality", "/app.js", "gzip, br;q=0.5", nil, http.StatusOK, "gzip bytes", "gzip", true, "", ""},
+		{"br refused", "/app.js", "br;q=0, gzip", nil, http.StatusOK, "gzip bytes", "gzip", true, "", ""},
+		{"wildcard", "/app.js", "*", nil, http.StatusOK, "br bytes", "br", true, "", ""},
+		{"wildcard refused", "/app.js", "*;q=0", nil, http.StatusOK, "console.log('identity');", "", true, "", ""},
+		{"wildcard with gzip refused", "/app.js", "gzip;q=0, *", nil, http.StatusOK, "br bytes", "br", true, "", ""},
+		{"other coding", "/app.js", "deflate", nil, http.StatusOK, "console.log('identity');", "", true, "", ""},
+		{"gzip sidecar only", "/style.css", "br", nil, http.StatusOK, "body { margin: 0 }", "", true, etag("style.css", ""), "text/css; charset=utf-8"},
+		{"gzip sidecar only, accepted", "/style.css", "br, gzip", nil, http.StatusOK, "gzip css", "gzip", true, "", "text/css; charset=utf-8"},
+		{"no sidecar", "/plain.txt", "br, gzip", nil, http.StatusOK, "plain text", "", false, etag("plain.txt", ""), "text/plain; charset=utf-8"},
+		{"directory sidecar", "/dir.js", "br", nil, http.StatusOK, "dir", "", false, "", ""},
+		{"nested", "/nested/a.js", "gzip", nil, http.StatusOK, "nested gzip", "gzip", true, "", ""},
+		{"range", "/app.js", "br, gzip", map[string]string{"Range": "bytes=0-6"}, http.StatusPartialContent, "console", "", true, etag("app.js", ""), ""},
+		{"range without sidecar", "/plain.txt", "gzip", map[string]string{"Range": "bytes=6-"}, http.StatusPartialContent, "text", "", false, "", ""},
+		{"not modified", "/app.js", "br", map[string]string{"If-None-Match": etag("app.js", "br")}, http.StatusNotModified, "", "", true, "", ""},
+		{"other encoding modified", "/app.js", "br", map[string]string{"If-None-Match": etag("app.js", "")}, http.StatusOK, "br bytes", "br", true, "", ""},
+		{"identity not modified", "/app.js", "", map[string]string{"If-None-Match": etag("app.js", "")}, http.StatusNotModified, "", "", true, "", ""},
+		{"missing", "/missing.js", "br", nil, http.StatusNotFound, "404 page not found\n", "", false, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Encoding", tt.accept)
+		}
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.wantCoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, got, tt.wantCoding)
+		}
+		if vary := w.Header().Values("Vary"); (len(vary) == 1 && vary[0] == "Accept-Encoding") != tt.wantVary || len(vary) > 1 {
+			t.Errorf("%s: Vary %q", tt.name, vary)
+		}
+		if tt.wantETag != "" && w.Header().Get("ETag") != tt.wantETag {
+			t.Errorf("%s: ETag %q, want %q", tt.name, w.Header().Get("ETag"), tt.wantETag)
+		}
+		if tt.wantType != "" && w.Header().Get("Content-Type") != tt.wantType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.name, w.Header().Get("Content-Type"), tt.wantType)
+		}
+	}
+}
+
+// TestFileServerContentETag checks the files without a modification time,
+// such as those of an embed.FS, get an ETag of the identity content, kept
+// distinct per encoding.
+func TestFileServerContentETag(t *testing.T) {
+	serve := func(fsys fs.FS, accept string) string {
+		r := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		FileServer(fsys).ServeHTTP(w, r)
+		if w.Header().Get("Last-Modified") != "" {
+			t.Errorf("Last-Modified %q", w.Header().Get("Last-Modified"))
+		}
+		return w.Header().Get("ETag")
+	}
+	v1 := fstest.MapFS{"app.js": {Data: []byte("version 1")}, "app.js.br": {Data: []byte("br 1")}}
+	v2 := fstest.MapFS{"app.js": {Data: []byte("version 2")}, "app.js.br": {Data: []byte("br 1")}}
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"same content", serve(v1, ""), serve(v1, ""), true},
+		{"changed content of the same size", serve(v1, ""), serve(v2, ""), false},
+		{"identity and br", serve(v1, ""), serve(v1, "br"), false},
+		{"br of the identity hash", serve(v1, "br"), serve(v1, "")[:len(serve(v1, ""))-1] + `-br"`, true},
+		{"br of changed identities", serve(v1, "br"), serve(v2, "br"), false},
+	}
+	for _, tt := range tests {
+		if (tt.a == tt.b) != tt.same {
+			t.Errorf("%s: %s and %s", tt.name, tt.a, tt.b)
+		}
+	}
+}
+
+func TestEncodingQuality(t *testing.T) {
+	tests := []struct {
+		header string
+		coding string
+		want   float64
+	}{
+		{"", "gzip", 0},
+		{"gzip", "gzip", 1},
+		{"gzip;q=0.5", "gzip", 0.5},
+		{"GZIP ; q = 0.25", "gzip", 0.25},
+		{"br, gzip;q=0", "gzip", 0},
+		{"*;q=0.3", "br", 0.3},
+		{"*;q=0.3, br;q=0.8", "br", 0.8},
+		{"br;q=0.8, *;q=0.3", "br", 0.8},
+		{"gzip;q=NaN", "gzip", 0},
+		{"gzip;q=-1", "gzip", 0},
+		{"gzip;q=abc", "gzip", 1},
+		{"deflate", "gzip", 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got := encodingQuality(r, tt.coding); got != tt.want {
+			t.Errorf("%q %s: %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+// staticDir returns a directory of files served by the tests of Static.
+func staticDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.js":              "app",
+		"app.js.br":           "br app",
+		"docs/index.html":     "docs index",
+		"docs/guide.txt":      "guide",
+		"home/default.html":   "home default",
+		"empty/.keep":         "",
+		"..hidden/secret.txt": "dotted",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(dir), "outside.txt"), []byte("outside"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestStatic requests the files of Server.Static, the directories with and
+// without index and listing, the missing files and the traversal attempts.
+func TestStatic(t *testing.T) {
+	dir := staticDir(t)
+	tests := []struct {
+		name     string
+		opts     []StaticOption
+		target   string
+		header   map[string]string
+		wantCode int
+		wantBody string
+		// wantCache is the Cache-Control header.
+		wantCache string
+	}{
+		{"file", nil, "/assets/app.js", nil, http.StatusOK, "app", ""},
+		{"precompressed", nil, "/assets/app.js", map[string]string{"Accept-Encoding": "br"}, http.StatusOK, "br app", ""},
+		{"nested", nil, "/assets/docs/guide.txt", nil, http.StatusOK, "guide", ""},
+		{"max age", []StaticOption{StaticMaxAge(365 * 24 * time.Hour)}, "/assets/app.js", nil, http.StatusOK, "app", "public, max-age=31536000"},
+		{"max age not on a 404", []StaticOption{StaticMaxAge(time.Hour)}, "/assets/missing.js", nil, http.StatusNotFound, "custom not found", ""},
+		{"missing", nil, "/assets/missing.js", nil, http.StatusNotFound, "custom not found", ""},
+		{"directory without index", nil, "/assets/empty/", nil, http.StatusNotFound, "custom not found", ""},
+		{"root without index", nil, "/assets/", nil, http.StatusNotFound, "custom not found", ""},
+		{"directory with index.html not listed", nil, "/assets/docs/", nil, http.StatusNotFound, "custom not found", ""},
+		{"index.html", []StaticOption{StaticIndex("index.html")}, "/assets/docs/", nil, http.StatusOK, "docs index", ""},
+		{"other index", []StaticOption{StaticIndex("default.html")}, "/assets/home/", nil, http.StatusOK, "home default", ""},
+		{"index without trailing slash", []StaticOption{StaticIndex("default.html")}, "/assets/home", nil, http.StatusMovedPermanently, "", ""},
+		{"index missing", []StaticOption{StaticIndex("default.html")}, "/assets/docs/", nil, http.StatusNotFound, "custom not found", ""},
+		{"listing", []StaticOption{StaticListing()}, "/assets/empty/", nil, http.StatusOK, `<a href=".keep">.keep</a>`, ""},
+		{"dotted directory name", nil, "/assets/..hidden/secret.txt", nil, http.StatusOK, "dotted", ""},
+		{"encoded traversal", nil, "/assets/%2e%2e/outside.txt", nil, http.StatusBadRequest, "invalid path\n", ""},
+		{"encoded slash traversal", nil, "/assets/docs%2f..%2f..%2foutside.txt", nil, http.StatusBadRequest, "invalid path\n", ""},
+		{"backslash traversal", nil, "/assets/..%5coutside.txt", nil, http.StatusBadRequest, "invalid path\n", ""},
+		{"nested backslash traversal", nil, "/assets/docs\\..\\..\\outside.txt", nil, http.StatusBadRequest, "invalid path\n", ""},
+		{"null byte", nil, "/assets/app.js%00.txt", nil, http.StatusBadRequest, "invalid path\n", ""},
+		{"outside of the prefix", nil, "/other/app.js", nil, http.StatusNotFound, "custom not found", ""},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		s.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("custom not found"))
+		})
+		s.Static("/assets", dir, tt.opts...)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL, _ = url.Parse(tt.target)
+		r.RequestURI = tt.target
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		body := w.Body.String()
+		if tt.name == "listing" && strings.Contains(body, tt.wantBody) {
+			body = tt.wantBody
+		}
+		if w.Code != tt.wantCode || body != tt.wantBody {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.wantCache {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.name, got, tt.wantCache)
+		}
+	}
+}
+
+// TestStaticFS serves an fs.FS at the root and under the base path.
+func TestStaticFS(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ServerConfig
+		prefix   string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{"root", ServerConfig{}, "/", "/app.js", http.StatusOK, "console.log('identity');"},
+		{"prefix without slashes", ServerConfig{}, "static", "/static/style.css", http.StatusOK, "body { margin: 0 }"},
+		{"prefix with a trailing slash", ServerConfig{}, "/static/", "/static/style.css", http.StatusOK, "body { margin: 0 }"},
+		{"base path stripped", ServerConfig{BasePath: "/app", StripBasePath: true}, "/static", "/app/static/plain.txt", http.StatusOK, "plain text"},
+		{"method", ServerConfig{}, "/static", "/static/plain.txt", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		s := NewServer(tt.config)
+		s.StaticFS(tt.prefix, precompressedFS())
+		method := http.MethodGet
+		if tt.name == "method" {
+			method = http.MethodPost
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, tt.target, nil))
+		if w.Code != tt.wantCode || (tt.wantBody != "" && w.Body.String() != tt.wantBody) {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestTraverses(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/app.js", false},
+		{"/..hidden/a", false},
+		{"/a../b", false},
+		{"/..", true},
+		{"/a/../b", true},
+		{"a\\..\\b", true},
+		{"/a/..", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := traverses(tt.path); got != tt.want {
+			t.Errorf("%q: %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
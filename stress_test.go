@@ -0,0 +1,140 @@
+package serverlib
+
+import (
+	"crypto/tls"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/sessions"
+)
+
+var (
+	stressWorkers    = flag.Int("stress.workers", 16, "clients of the server stress test")
+	stressIterations = flag.Int("stress.iterations", 40, "requests of each client of the server stress test")
+)
+
+// startStressServer serves s over TLS with its session ticket keys served
+// by rotator.
+func startStressServer(t *testing.T, s *Server, rotator *ticketRotator) *httptest.Server {
+	t.Helper()
+	// The certificate of httptest, which only sets it on the servers it
+	// configures itself.
+	certificate := httptest.NewTLSServer(http.NotFoundHandler())
+	certificate.Close()
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.TLSConfig = &tls.Config{Certificates: certificate.TLS.Certificates}
+	rotator.apply(ts.Config)
+	ts.TLS = ts.Config.TLSConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestStress drives the whole stack of a server over TLS from concurrent
+// clients, each keeping its session, while the maintenance mode is toggled
+// and the session ticket keys are rotated, to be run with -race: the
+// requests are either served or refused for the maintenance, the allowed
+// path always served, the session counters never go back, and the
+// handshakes never fail, whether the ticket of the client is still decrypted
+// or not.
+func TestStress(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := NewServer(ServerConfig{})
+	s.GET("/count", func(w http.ResponseWriter, r *http.Request) {
+		session, _ := s.GetSession(w, r)
+		var n int
+		session.(sessions.Updater).Update("count", func(value any) any {
+			n, _ = value.(int)
+			n++
+			return n
+		})
+		w.Write([]byte(strconv.Itoa(n)))
+	})
+	s.GET("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	rotator := newTicketRotator(TicketRotationOptions{Keep: 1})
+	ts := startStressServer(t, s, rotator)
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if i%2 == 0 {
+				s.SetMaintenance(i%4 == 0, MaintenanceAllowPaths("/healthz"))
+			} else if i%10 == 1 {
+				rotator.rotate()
+			}
+		}
+	}()
+
+	var served, refused, resumed atomic.Int64
+	var wg sync.WaitGroup
+	for range *stressWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jar, _ := cookiejar.New(nil)
+			transport := ts.Client().Transport.(*http.Transport).Clone()
+			// A handshake per request, resuming the TLS session when the
+			// ticket is still decrypted.
+			transport.DisableKeepAlives = true
+			transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+			client := &http.Client{Transport: transport, Jar: jar, Timeout: 10 * time.Second}
+			last := 0
+			for i := range *stressIterations {
+				path := "/count"
+				if i%5 == 4 {
+					path = "/healthz"
+				}
+				resp, err := client.Get(ts.URL + path)
+				if err != nil {
+					t.Errorf("GET %s: %v", path, err)
+					return
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.TLS.DidResume {
+					resumed.Add(1)
+				}
+				switch {
+				case resp.StatusCode == http.StatusServiceUnavailable && path != "/healthz":
+					if resp.Header.Get("Retry-After") == "" {
+						t.Errorf("GET %s: refused without a Retry-After", path)
+					}
+					refused.Add(1)
+				case resp.StatusCode != http.StatusOK:
+					t.Errorf("GET %s: %s", path, resp.Status)
+				case path == "/count":
+					n, _ := strconv.Atoi(string(body))
+					if n <= last {
+						t.Errorf("session counter %d after %d", n, last)
+					}
+					last = n
+					served.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+	t.Logf("%d served, %d refused, %d TLS sessions resumed", served.Load(), refused.Load(), resumed.Load())
+}
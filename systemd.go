@@ -0,0 +1,67 @@
+package serverlib
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd, see
+// sd_listen_fds(3).
+const listenFdsStart = 3
+
+// systemdListener returns the listener of the socket passed by systemd with
+// an Accept=no socket unit, or nil when the process is not socket-activated.
+// The LISTEN_* variables are unset, so that the child processes don't take
+// the socket for theirs.
+func (s *Server) systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	name, _, _ := strings.Cut(os.Getenv("LISTEN_FDNAMES"), ":")
+	if name == "" {
+		name = "LISTEN_FD_" + strconv.Itoa(listenFdsStart)
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds > 1 {
+		s.LogInfo("Socket activation", fmt.Sprintf("%d sockets passed, serving on the first one, %s", fds, name))
+	}
+	file := os.NewFile(listenFdsStart, name)
+	defer file.Close()
+	l, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	return l, nil
+}
+
+// StartFromSystemd starts the server on the socket passed by systemd when the
+// process is socket-activated (LISTEN_FDS and LISTEN_PID set, Accept=no), so
+// that the socket stays open across restarts and no connection is refused
+// during a deploy. It falls back to Start otherwise.
+//
+//	# myapp.socket
+//	[Socket]
+//	ListenStream=8080
+//
+// Returns:
+//   - error: Like Start.
+func (s *Server) StartFromSystemd() error {
+	l, err := s.systemdListener()
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return s.Start()
+	}
+	return s.Serve(l)
+}
@@ -0,0 +1,101 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package serverlib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+// systemdChildEnv marks the process run by TestStartFromSystemd, the socket
+// passed as its file descriptor 3 like systemd does.
+const systemdChildEnv = "SERVERLIB_TEST_SYSTEMD_CHILD"
+
+// TestStartFromSystemd runs the test binary with a listening socket as its
+// file descriptor 3 and the LISTEN_* variables: the child process serves on
+// the socket, the parent closing its own copy, and unsets the variables.
+func TestStartFromSystemd(t *testing.T) {
+	if os.Getenv(systemdChildEnv) != "" {
+		systemdChild(t)
+		return
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestStartFromSystemd$")
+	cmd.Env = append(os.Environ(), systemdChildEnv+"=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=web")
+	cmd.ExtraFiles = []*os.File{file}
+	output := &syncBuffer{}
+	cmd.Stdout, cmd.Stderr = output, output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	l.Close()
+	resp, err := http.Get("http://" + l.Addr().String() + "/")
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("%v: %s", err, output.String())
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := l.Addr().String(); string(body) != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("child: %v: %s", err, output.String())
+	}
+}
+
+// systemdChild serves a request on the socket passed by TestStartFromSystemd,
+// answering the LISTEN_* variables left and the address, then stops.
+func systemdChild(t *testing.T) {
+	// systemd sets LISTEN_PID once the process is forked.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s := NewServer(ServerConfig{Address: "127.0.0.1:1"})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			if value, ok := os.LookupEnv(name); ok {
+				fmt.Fprintf(w, "%s=%s ", name, value)
+			}
+		}
+		w.Write([]byte(s.Addr()))
+		go s.Stop()
+	})
+	if err := s.StartFromSystemd(); err != http.ErrServerClosed {
+		t.Fatal(err)
+	}
+}
+
+// TestStartFromSystemdFallback checks StartFromSystemd starts on the
+// configured address when the sockets are passed to another process.
+func TestStartFromSystemdFallback(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	s := newTestServer()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StartFromSystemd()
+	}()
+	<-s.Ready()
+	if _, port, _ := net.SplitHostPort(s.Addr()); port == "0" {
+		t.Errorf("Addr %q", s.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Error("LISTEN_FDS of another process unset")
+	}
+	s.Stop()
+	waitStart(t, done)
+}
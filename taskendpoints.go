@@ -0,0 +1,51 @@
+package serverlib
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/tasks"
+)
+
+// Tasks returns the server's task runner.
+// It provides access to the runner executing the background tasks.
+func (s *Server) Tasks() *tasks.Runner {
+	return s.tasks
+}
+
+// EnableTaskEndpoints registers the task status endpoint GET <prefix>/{id}.
+// It returns the state of the task as JSON: status, progress and, once the task
+// is finished, its result or error. Unless the runner is shared, a task can
+// only be polled from the session that started it, other sessions get a 403.
+//
+// Parameters:
+//   - prefix: The URL prefix of the status endpoint, e.g. "/tasks".
+func (s *Server) EnableTaskEndpoints(prefix string) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	s.tasks.SetPrefix(s.Path(prefix))
+	pattern := "GET " + strings.TrimSuffix(prefix, "/") + "/{id}"
+	slog.Info("Registred task endpoints", "pattern", pattern)
+	s.router.HandleFunc(pattern, s.taskStatus)
+	s.addHandlerRoute(pattern, nil, s.taskStatus, nil)
+}
+
+// taskStatus serves the status of a task.
+func (s *Server) taskStatus(w http.ResponseWriter, r *http.Request) {
+	task, ok := s.tasks.Get(tasks.TaskID(r.PathValue("id")))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sessionID := ""
+	if session := reqctx.Session(r.Context()); session != nil {
+		sessionID = session.Id()
+	}
+	if !s.tasks.CanAccess(task, sessionID) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	JSON(w, http.StatusOK, task)
+}
@@ -0,0 +1,167 @@
+package serverlib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/tasks"
+)
+
+// taskServer returns a server with the task endpoints under /tasks, starting
+// fn as a task on POST /jobs.
+func taskServer(config ServerConfig, fn tasks.Func) *Server {
+	s := NewServer(config)
+	s.EnableTaskEndpoints("/tasks")
+	s.POST("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		s.Tasks().Accepted(w, s.Tasks().Start(r.Context(), fn))
+	})
+	return s
+}
+
+// startTask starts the task of s, returning its status URL and the session
+// cookie of the client.
+func startTask(t *testing.T, s *Server) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/jobs", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs: %d, want 202", w.Code)
+	}
+	var accepted map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &accepted); err != nil {
+		t.Fatal(err)
+	}
+	location := w.Header().Get("Location")
+	if location == "" || accepted["status_url"] != location || location != "/tasks/"+accepted["id"] {
+		t.Fatalf("Location %q, body %v", location, accepted)
+	}
+	return location, w.Result().Cookies()[0]
+}
+
+// pollTask polls the status URL with the cookie until the task is in one of
+// the statuses, returning the last answer.
+func pollTask(t *testing.T, s *Server, location string, cookie *http.Cookie, statuses ...tasks.Status) (int, tasks.Task) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		r := httptest.NewRequest(http.MethodGet, location, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		var task tasks.Task
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, status := range statuses {
+			if w.Code != http.StatusOK || task.Status == status {
+				return w.Code, task
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task still %s, want %v", task.Status, statuses)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTaskEndpoints(t *testing.T) {
+	tests := []struct {
+		name         string
+		fn           tasks.Func
+		wantStatus   tasks.Status
+		wantResult   any
+		wantError    string
+		wantProgress int
+	}{
+		{"completion", func(context.Context, func(int)) (any, error) {
+			return "report.pdf", nil
+		}, tasks.Done, "report.pdf", "", 100},
+		{"failure", func(_ context.Context, report func(int)) (any, error) {
+			report(30)
+			return nil, errors.New("disk full")
+		}, tasks.Failed, nil, "disk full", 30},
+		{"panic", func(context.Context, func(int)) (any, error) {
+			panic("boom")
+		}, tasks.Failed, nil, "tasks: task panicked: boom", 0},
+	}
+	for _, tt := range tests {
+		s := taskServer(ServerConfig{}, tt.fn)
+		location, cookie := startTask(t, s)
+		code, task := pollTask(t, s, location, cookie, tasks.Done, tasks.Failed)
+		if code != http.StatusOK || task.Status != tt.wantStatus || task.Result != tt.wantResult || task.Error != tt.wantError || task.Progress != tt.wantProgress {
+			t.Errorf("%s: %d %+v, want %s, result %v, error %q, progress %d", tt.name, code, task, tt.wantStatus, tt.wantResult, tt.wantError, tt.wantProgress)
+		}
+		if task.Finished == nil {
+			t.Errorf("%s: no finish time", tt.name)
+		}
+	}
+}
+
+func TestTaskProgress(t *testing.T) {
+	step := make(chan int)
+	reported := make(chan struct{})
+	s := taskServer(ServerConfig{}, func(ctx context.Context, report func(int)) (any, error) {
+		for progress := range step {
+			report(progress)
+			reported <- struct{}{}
+		}
+		return nil, nil
+	})
+	location, cookie := startTask(t, s)
+	for _, tt := range []struct{ report, want int }{{10, 10}, {55, 55}, {150, 100}, {-5, 0}} {
+		step <- tt.report
+		<-reported
+		code, task := pollTask(t, s, location, cookie, tasks.Running)
+		if code != http.StatusOK || task.Progress != tt.want {
+			t.Errorf("after report(%d): %d, progress %d, want %d", tt.report, code, task.Progress, tt.want)
+		}
+	}
+	close(step)
+	if _, task := pollTask(t, s, location, cookie, tasks.Done); task.Progress != 100 {
+		t.Errorf("done with progress %d, want 100", task.Progress)
+	}
+}
+
+func TestTaskAccess(t *testing.T) {
+	done := func(context.Context, func(int)) (any, error) { return "secret", nil }
+	other := func(t *testing.T, s *Server) *http.Cookie {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks/none", nil))
+		return w.Result().Cookies()[0]
+	}
+	tests := []struct {
+		name   string
+		config ServerConfig
+		cookie func(t *testing.T, s *Server, owner *http.Cookie) *http.Cookie
+		want   int
+	}{
+		{"owner", ServerConfig{}, func(_ *testing.T, _ *Server, owner *http.Cookie) *http.Cookie { return owner }, http.StatusOK},
+		{"other session", ServerConfig{}, func(t *testing.T, s *Server, _ *http.Cookie) *http.Cookie { return other(t, s) }, http.StatusForbidden},
+		{"no session", ServerConfig{}, func(*testing.T, *Server, *http.Cookie) *http.Cookie { return nil }, http.StatusForbidden},
+		{"shared runner", ServerConfig{Tasks: tasks.NewRunner(tasks.Options{Shared: true})}, func(t *testing.T, s *Server, _ *http.Cookie) *http.Cookie { return other(t, s) }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		s := taskServer(tt.config, done)
+		location, owner := startTask(t, s)
+		pollTask(t, s, location, owner, tasks.Done)
+		if code, _ := pollTask(t, s, location, tt.cookie(t, s, owner), tasks.Done); code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	s := taskServer(ServerConfig{}, done)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown task: %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package tasks
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is the minimum delay between two removals of the expired tasks.
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	task    Task
+	expires time.Time
+}
+
+// MemoryStore is an in-memory task store. Expired tasks are removed lazily,
+// when they are read and while saving other tasks.
+type MemoryStore struct {
+	tasks     map[TaskID]memoryEntry
+	mut       *sync.RWMutex
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates and returns a new instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tasks: make(map[TaskID]memoryEntry),
+		mut:   &sync.RWMutex{},
+	}
+}
+
+// Get retrieves a task by its ID, ignoring expired tasks.
+func (s *MemoryStore) Get(id TaskID) (Task, bool) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	entry, ok := s.tasks[id]
+	if !ok || entry.expired(time.Now()) {
+		return Task{}, false
+	}
+	return entry.task, true
+}
+
+// Save stores the task, and removes the expired tasks at most once per sweep interval.
+func (s *MemoryStore) Save(task Task, ttl time.Duration) {
+	now := time.Now()
+	entry := memoryEntry{task: task}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.tasks[task.ID] = entry
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.lastSweep = now
+		for id, entry := range s.tasks {
+			if entry.expired(now) {
+				delete(s.tasks, id)
+			}
+		}
+	}
+}
+
+// Delete removes a task from the store.
+func (s *MemoryStore) Delete(id TaskID) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	delete(s.tasks, id)
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
@@ -0,0 +1,306 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/scratch"
+	"github.com/google/uuid"
+)
+
+// TaskID is the unique identifier of a task.
+type TaskID string
+
+// Status is the state of a task.
+type Status string
+
+const (
+	Pending Status = "pending"
+	Running Status = "running"
+	Done    Status = "done"
+	Failed  Status = "failed"
+)
+
+// DefaultTTL is how long the result of a finished task is retained when no TTL is configured.
+const DefaultTTL = time.Hour
+
+// ErrClosed is the error of the tasks started after the runner was closed.
+var ErrClosed = errors.New("tasks: runner closed")
+
+// Func is the function run by a task. It receives a context cancelled when the
+// runner is closed and a report function to publish its progress (0 to 100).
+type Func func(ctx context.Context, report func(progress int)) (any, error)
+
+// Task represents the state of a task as seen by the status endpoint.
+type Task struct {
+	ID       TaskID     `json:"id"`
+	Owner    string     `json:"-"`
+	Status   Status     `json:"status"`
+	Progress int        `json:"progress"`
+	Result   any        `json:"result,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Store defines an interface for storing tasks.
+// Finished tasks are saved with the TTL after which the store may forget them.
+type Store interface {
+	// Get retrieves a task by its ID.
+	// Returns the task and a boolean indicating whether the task was found.
+	Get(id TaskID) (Task, bool)
+	// Save stores the task. A positive ttl means the task can be removed once it elapsed.
+	Save(task Task, ttl time.Duration)
+	// Delete deletes the task associated with the given ID.
+	Delete(id TaskID)
+}
+
+// Options configures a Runner.
+type Options struct {
+	// Store is where the tasks state is kept. Defaults to a MemoryStore.
+	Store Store
+	// TTL is how long finished tasks are retained. Defaults to DefaultTTL.
+	TTL time.Duration
+	// Workers is the maximum number of tasks running at the same time.
+	// Zero means no limit.
+	Workers int
+	// Shared allows any session to poll any task. By default only the session
+	// that started a task can read its status.
+	Shared bool
+	// TempDir is the options of the temporary directories of the tasks, see
+	// TempDir. Defaults to the ones set by scratch.SetDefaults.
+	TempDir *scratch.Options
+}
+
+// Runner runs tasks in the background and keeps track of their state.
+type Runner struct {
+	store   Store
+	ttl     time.Duration
+	shared  bool
+	tempDir *scratch.Options
+	workers chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mut     *sync.RWMutex
+	prefix  string
+}
+
+// Default is the runner used by the package level functions. The servers have
+// their own runner, unless given this one.
+var Default = NewRunner(Options{})
+
+// NewRunner creates a new Runner with the given options.
+func NewRunner(opts Options) *Runner {
+	if opts.Store == nil {
+		opts.Store = NewMemoryStore()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Runner{
+		store:   opts.Store,
+		ttl:     opts.TTL,
+		shared:  opts.Shared,
+		tempDir: opts.TempDir,
+		ctx:     ctx,
+		cancel:  cancel,
+		mut:     &sync.RWMutex{},
+		prefix:  "/tasks",
+	}
+	if opts.Workers > 0 {
+		r.workers = make(chan struct{}, opts.Workers)
+	}
+	return r
+}
+
+// Start runs fn in the background and returns the ID of the new task.
+// The task is owned by the session found in ctx, if any.
+//
+// Parameters:
+//   - ctx: The context of the request starting the task. Its values reach fn
+//     through reqctx.Detach: the task logs carry the correlation of the
+//     request, but the task is not cancelled when the request ends.
+//   - fn: The function to run.
+//
+// Returns:
+//   - TaskID: The ID to poll the task status with.
+func (r *Runner) Start(ctx context.Context, fn Func) TaskID {
+	task := Task{
+		ID:      TaskID(uuid.New().String()),
+		Owner:   ownerFromContext(ctx),
+		Status:  Pending,
+		Created: time.Now(),
+	}
+	ctx = reqctx.Detach(ctx)
+	if r.ctx.Err() != nil {
+		r.finish(ctx, task, nil, ErrClosed)
+		return task.ID
+	}
+	r.store.Save(task, 0)
+	r.wg.Add(1)
+	go r.run(ctx, task, fn)
+	return task.ID
+}
+
+func (r *Runner) run(ctx context.Context, task Task, fn Func) {
+	defer r.wg.Done()
+	// The task is cancelled with the runner.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(r.ctx, cancel)()
+	// The temporary directory of the task, see TempDir, is removed once it
+	// finished.
+	var scope *scratch.Scope
+	if r.tempDir != nil {
+		scope = scratch.NewScope("task", *r.tempDir)
+	} else {
+		scope = scratch.NewScope("task")
+	}
+	defer scope.Close()
+	ctx = scratch.WithScope(ctx, scope)
+	if r.workers != nil {
+		select {
+		case r.workers <- struct{}{}:
+			defer func() { <-r.workers }()
+		case <-ctx.Done():
+			r.finish(ctx, task, nil, ErrClosed)
+			return
+		}
+	}
+	var mut sync.Mutex
+	task.Status = Running
+	r.store.Save(task, 0)
+	report := func(progress int) {
+		mut.Lock()
+		defer mut.Unlock()
+		if task.Status != Running {
+			return
+		}
+		task.Progress = min(max(progress, 0), 100)
+		r.store.Save(task, 0)
+	}
+	result, err := r.call(ctx, fn, report)
+	mut.Lock()
+	defer mut.Unlock()
+	r.finish(ctx, task, result, err)
+}
+
+// call runs fn, converting a panic into an error.
+func (r *Runner) call(ctx context.Context, fn Func, report func(int)) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("tasks: task panicked: %v", p)
+		}
+	}()
+	return fn(ctx, report)
+}
+
+func (r *Runner) finish(ctx context.Context, task Task, result any, err error) {
+	finished := time.Now()
+	task.Finished = &finished
+	if err != nil {
+		reqctx.Logger(ctx).Error("Task failed", "id", task.ID, "error", err)
+		task.Status = Failed
+		task.Error = err.Error()
+	} else {
+		task.Status = Done
+		task.Progress = 100
+		task.Result = result
+	}
+	r.store.Save(task, r.ttl)
+}
+
+// Get returns the task with the given ID.
+func (r *Runner) Get(id TaskID) (Task, bool) {
+	return r.store.Get(id)
+}
+
+// CanAccess reports whether the session with the given ID is allowed to read the task.
+func (r *Runner) CanAccess(task Task, sessionID string) bool {
+	return r.shared || task.Owner == "" || task.Owner == sessionID
+}
+
+// SetPrefix sets the URL prefix the status endpoints are served under.
+func (r *Runner) SetPrefix(prefix string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.prefix = "/" + strings.Trim(prefix, "/")
+}
+
+// Location returns the URL of the status endpoint of the given task.
+func (r *Runner) Location(id TaskID) string {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return strings.TrimSuffix(r.prefix, "/") + "/" + string(id)
+}
+
+// Accepted answers the request with a 202 status and a Location header pointing
+// to the status endpoint of the given task.
+func (r *Runner) Accepted(w http.ResponseWriter, id TaskID) {
+	location := r.Location(id)
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": string(id), "status_url": location})
+}
+
+// Cancel cancels the context of the running tasks without waiting for them.
+// Tasks started after Cancel fail immediately with ErrClosed.
+func (r *Runner) Cancel() {
+	r.cancel()
+}
+
+// Close cancels the running tasks and waits for them to return, or for ctx to be done.
+// Tasks started after Close fail immediately with ErrClosed.
+func (r *Runner) Close(ctx context.Context) error {
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start runs fn on the Default runner.
+// shorthand for tasks.Default.Start(ctx, fn)
+func Start(ctx context.Context, fn Func) TaskID {
+	return Default.Start(ctx, fn)
+}
+
+// Accepted answers the request with a 202 pointing to a task of the Default runner.
+// shorthand for tasks.Default.Accepted(w, id)
+func Accepted(w http.ResponseWriter, id TaskID) {
+	Default.Accepted(w, id)
+}
+
+// TempDir returns the temporary directory of the task running with ctx,
+// created on the first call and removed once the task finished, see
+// scratch.Dir. Its root and quota are the ones of Options.TempDir, the
+// ServerConfig.TempDir for the runner of a server.
+func TempDir(ctx context.Context) (string, error) {
+	return scratch.Dir(ctx)
+}
+
+// ownerFromContext returns the ID of the session stored in the request context.
+func ownerFromContext(ctx context.Context) string {
+	session := reqctx.Session(ctx)
+	if session == nil {
+		return ""
+	}
+	return session.Id()
+}
@@ -0,0 +1,175 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/reqctx"
+	"github.com/Morditux/serverlib/scratch"
+)
+
+func TestMemoryStoreTTL(t *testing.T) {
+	store := NewMemoryStore()
+	tests := []struct {
+		id   TaskID
+		ttl  time.Duration
+		want bool
+	}{
+		{"running", 0, true},
+		{"retained", time.Hour, true},
+		{"expired", time.Nanosecond, false},
+	}
+	for _, tt := range tests {
+		store.Save(Task{ID: tt.id}, tt.ttl)
+	}
+	time.Sleep(time.Millisecond)
+	for _, tt := range tests {
+		if _, ok := store.Get(tt.id); ok != tt.want {
+			t.Errorf("Get(%q) found %v, want %v", tt.id, ok, tt.want)
+		}
+	}
+}
+
+// wait returns the task once finished.
+func wait(t *testing.T, r *Runner, id TaskID) Task {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if task, ok := r.Get(id); ok && task.Finished != nil {
+			return task
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s not finished", id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunnerClose(t *testing.T) {
+	r := NewRunner(Options{})
+	started := make(chan struct{})
+	running := r.Start(context.Background(), func(ctx context.Context, _ func(int)) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		id   TaskID
+		want string
+	}{
+		{"running", running, context.Canceled.Error()},
+		{"started after Close", r.Start(context.Background(), func(context.Context, func(int)) (any, error) {
+			return nil, nil
+		}), ErrClosed.Error()},
+	}
+	for _, tt := range tests {
+		if task := wait(t, r, tt.id); task.Status != Failed || task.Error != tt.want {
+			t.Errorf("%s: %s %q, want failed %q", tt.name, task.Status, task.Error, tt.want)
+		}
+	}
+}
+
+func TestRunnerWorkers(t *testing.T) {
+	r := NewRunner(Options{Workers: 1})
+	defer r.Close(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	first := r.Start(context.Background(), func(context.Context, func(int)) (any, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+	second := r.Start(context.Background(), func(context.Context, func(int)) (any, error) {
+		return 2, nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	if task, _ := r.Get(second); task.Status != Pending {
+		t.Errorf("second task %s while the only worker is busy, want pending", task.Status)
+	}
+	close(release)
+	for _, id := range []TaskID{first, second} {
+		if task := wait(t, r, id); task.Status != Done {
+			t.Errorf("task %s: %s, want done", id, task.Status)
+		}
+	}
+}
+
+// TestRunnerDetach checks a task outlives the request starting it, with the
+// values of the request.
+func TestRunnerDetach(t *testing.T) {
+	r := NewRunner(Options{})
+	defer r.Close(context.Background())
+	request, cancel := context.WithCancel(reqctx.WithRequestID(context.Background(), "request-1"))
+	release := make(chan struct{})
+	id := r.Start(request, func(ctx context.Context, _ func(int)) (any, error) {
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return reqctx.CorrelationOf(ctx).RequestID, nil
+	})
+	// The response is sent, the request context cancelled.
+	cancel()
+	close(release)
+	if task := wait(t, r, id); task.Status != Done || task.Result != "request-1" {
+		t.Errorf("task %s %v %q, want done with the request ID", task.Status, task.Result, task.Error)
+	}
+}
+
+// TestRunnerTempDir checks the temporary directory of a task is removed once
+// it finished, however it ends.
+func TestRunnerTempDir(t *testing.T) {
+	root := t.TempDir()
+	r := NewRunner(Options{TempDir: &scratch.Options{Root: root}})
+	defer r.Close(context.Background())
+	tests := []struct {
+		name       string
+		end        func() (any, error)
+		wantStatus Status
+	}{
+		{"done", func() (any, error) { return "converted", nil }, Done},
+		{"failed", func() (any, error) { return nil, errors.New("not a PDF") }, Failed},
+		{"panicked", func() (any, error) { panic("conversion crashed") }, Failed},
+	}
+	for _, tt := range tests {
+		dirs := make(chan string, 1)
+		id := r.Start(context.Background(), func(ctx context.Context, _ func(int)) (any, error) {
+			dir, err := TempDir(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(dir, "page.png"), []byte("png"), 0o600); err != nil {
+				return nil, err
+			}
+			dirs <- dir
+			return tt.end()
+		})
+		if task := wait(t, r, id); task.Status != tt.wantStatus {
+			t.Errorf("%s: %s %q, want %s", tt.name, task.Status, task.Error, tt.wantStatus)
+		}
+		dir := <-dirs
+		if filepath.Dir(dir) != root {
+			t.Errorf("%s: %s not under the root", tt.name, dir)
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist); _, err = os.Stat(dir) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: %s not removed", tt.name, dir)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, err := TempDir(context.Background()); !errors.Is(err, scratch.ErrNoScope) {
+		t.Errorf("outside a task: %v, want scratch.ErrNoScope", err)
+	}
+}
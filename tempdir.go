@@ -0,0 +1,46 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Morditux/serverlib/scratch"
+)
+
+// ErrNoTempDir is returned by TempDir when ServerConfig.TempDir is not set.
+var ErrNoTempDir = errors.New("serverlib: temporary directories are not enabled, see ServerConfig.TempDir")
+
+// TempDir returns the temporary directory of the request, created on the
+// first call under ServerConfig.TempDir.Root. It is removed once the
+// response is complete, panic included, along with its files: a file that
+// must outlive the request is moved out of it. The background tasks get
+// their own directory from tasks.TempDir.
+//
+// Parameters:
+//   - r: The request.
+//
+// Returns:
+//   - string: The path of the directory.
+//   - error: ErrNoTempDir, the error creating the directory, or a
+//     *scratch.QuotaError once its files grew over ServerConfig.TempDir.Quota.
+func TempDir(r *http.Request) (string, error) {
+	dir, err := scratch.Dir(r.Context())
+	if errors.Is(err, scratch.ErrNoScope) {
+		return "", ErrNoTempDir
+	}
+	return dir, err
+}
+
+// sweepTempDirs removes the temporary directories left behind by a previous
+// process, see scratch.Sweep.
+func (s *Server) sweepTempDirs(options scratch.Options) {
+	removed, err := scratch.Sweep(options)
+	if err != nil {
+		s.LogError("Sweeping the temporary directories", err.Error())
+		return
+	}
+	if removed > 0 {
+		s.LogInfo("Orphaned temporary directories removed", strconv.Itoa(removed))
+	}
+}
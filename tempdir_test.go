@@ -0,0 +1,130 @@
+package serverlib
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Morditux/serverlib/scratch"
+)
+
+// waitRemoved waits for the background removal of dir.
+func waitRemoved(t *testing.T, dir string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not removed", dir)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestTempDir checks the temporary directory of a request is removed once the
+// response is complete, however the handler ends.
+func TestTempDir(t *testing.T) {
+	root := t.TempDir()
+	s := NewServer(ServerConfig{TempDir: &scratch.Options{Root: root}})
+	var dirs []string
+	use := func(w http.ResponseWriter, r *http.Request) {
+		dir, err := TempDir(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := TempDir(r); again != dir {
+			t.Errorf("second TempDir %s, want %s", again, dir)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "upload.bin"), []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+	s.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		use(w, r)
+		w.Write([]byte("converted"))
+	})
+	s.HandleFunc("GET /error", func(w http.ResponseWriter, r *http.Request) {
+		use(w, r)
+		s.Error(w, r, NewHTTPError(http.StatusUnprocessableEntity, "not a PDF"))
+	})
+	s.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		use(w, r)
+		panic("conversion crashed")
+	})
+	s.HandleFunc("GET /unused", func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		path     string
+		wantCode int
+		wantDir  bool
+	}{
+		{"/ok", http.StatusOK, true},
+		{"/error", http.StatusUnprocessableEntity, true},
+		{"/panic", http.StatusInternalServerError, true},
+		{"/unused", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		dirs = nil
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: %d, want %d", tt.path, w.Code, tt.wantCode)
+		}
+		if (len(dirs) == 1) != tt.wantDir {
+			t.Errorf("%s: directories %v", tt.path, dirs)
+		}
+		for _, dir := range dirs {
+			if filepath.Dir(dir) != root {
+				t.Errorf("%s: %s not under the root", tt.path, dir)
+			}
+			waitRemoved(t, dir)
+		}
+	}
+	// Two requests get two directories.
+	dirs = nil
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if len(dirs) != 2 || dirs[0] == dirs[1] {
+		t.Errorf("directories %v, want one per request", dirs)
+	}
+}
+
+func TestTempDirDisabled(t *testing.T) {
+	if _, err := TempDir(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrNoTempDir) {
+		t.Errorf("%v, want ErrNoTempDir", err)
+	}
+}
+
+// TestTempDirSweep checks Start removes the orphaned directories of the root.
+func TestTempDirSweep(t *testing.T) {
+	root := t.TempDir()
+	orphan := filepath.Join(root, "scratch-request-123")
+	recent := filepath.Join(root, "scratch-request-456")
+	for _, dir := range []string{orphan, recent} {
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", TempDir: &scratch.Options{Root: root}})
+	done := startServer(s)
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	waitRemoved(t, orphan)
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent directory: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package templates
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// contentTypes maps template name extensions to the Content-Type of their output.
+var contentTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".xml":  "application/xml; charset=utf-8",
+	".svg":  "image/svg+xml; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".json": "application/json; charset=utf-8",
+}
+
+// ContentType returns the Content-Type of the output of the named template,
+// based on the extension of its name. Templates without a known extension
+// are assumed to produce HTML.
+func ContentType(name string) string {
+	if contentType, ok := contentTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		return contentType
+	}
+	return contentTypes[".html"]
+}
@@ -0,0 +1,254 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// FormState holds what a form is re-rendered with: the submitted values, the
+// errors of the rejected fields and the CSRF token.
+type FormState struct {
+	Values url.Values
+	Errors map[string][]string
+	// CSRFToken is the value of the hidden field written by csrfField.
+	CSRFToken string
+}
+
+// NewFormState creates a FormState with the submitted values, e.g. r.PostForm.
+func NewFormState(values url.Values) *FormState {
+	if values == nil {
+		values = url.Values{}
+	}
+	return &FormState{Values: values, Errors: map[string][]string{}}
+}
+
+// AddError records an error message for the named field.
+func (f *FormState) AddError(field, message string) {
+	if f.Errors == nil {
+		f.Errors = map[string][]string{}
+	}
+	f.Errors[field] = append(f.Errors[field], message)
+}
+
+// Value returns the submitted value of the named field.
+func (f *FormState) Value(name string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Values.Get(name)
+}
+
+// Invalid reports whether the named field has errors.
+func (f *FormState) Invalid(name string) bool {
+	return f != nil && len(f.Errors[name]) > 0
+}
+
+// CSRFFieldName is the name of the hidden field written by csrfField.
+const CSRFFieldName = "csrf_token"
+
+// FormClasses are the CSS classes of the markup of the form helpers, so that
+// it fits the CSS framework of the project. Empty classes are omitted.
+type FormClasses struct {
+	Input    string
+	Textarea string
+	Select   string
+	Checkbox string
+	Label    string
+	// Invalid is added to the fields having errors.
+	Invalid string
+	// Errors is the class of the error list written by fieldErrors.
+	Errors string
+}
+
+// BootstrapFormClasses are the classes of Bootstrap 5 forms.
+var BootstrapFormClasses = FormClasses{
+	Input:    "form-control",
+	Textarea: "form-control",
+	Select:   "form-select",
+	Checkbox: "form-check-input",
+	Label:    "form-label",
+	Invalid:  "is-invalid",
+	Errors:   "invalid-feedback d-block",
+}
+
+// SelectOption is an option of a select field.
+type SelectOption struct {
+	Value string
+	Label string
+}
+
+// FormHelpers returns the form helper functions, to be added with AddFuncs:
+//
+//	{{input "email" "email" .Form}}
+//	{{textarea "comment" .Form}}
+//	{{selectField "country" .Countries .Form}}
+//	{{checkbox "terms" .Form}}
+//	{{label "email" "E-mail address"}}
+//	{{fieldErrors "email" .Form}}
+//	{{csrfField .Form}}
+//
+// The fields get the name as id, are re-populated from the FormState, and
+// fields with errors are marked aria-invalid and described by their error
+// list. Every name, value and label is escaped. The options of selectField
+// are a []SelectOption, a []string (value and label alike) or a
+// map[string]string of values to labels, sorted by label; the selected value
+// is given as a string, or as the FormState also marking the errors.
+//
+// Parameters:
+//   - classes: Optional CSS classes of the markup, none by default.
+func FormHelpers(classes ...FormClasses) template.FuncMap {
+	var c FormClasses
+	if len(classes) > 0 {
+		c = classes[0]
+	}
+	return template.FuncMap{
+		"input": func(kind, name string, form *FormState) template.HTML {
+			var b strings.Builder
+			b.WriteString(`<input type="` + esc(kind) + `"`)
+			fieldAttributes(&b, name, c.Input, c.Invalid, form)
+			if kind != "password" && kind != "file" {
+				b.WriteString(` value="` + esc(form.Value(name)) + `"`)
+			}
+			b.WriteString(">")
+			return template.HTML(b.String())
+		},
+		"textarea": func(name string, form *FormState) template.HTML {
+			var b strings.Builder
+			b.WriteString("<textarea")
+			fieldAttributes(&b, name, c.Textarea, c.Invalid, form)
+			b.WriteString(">" + esc(form.Value(name)) + "</textarea>")
+			return template.HTML(b.String())
+		},
+		"selectField": func(name string, options any, selection any) (template.HTML, error) {
+			list, err := selectOptions(options)
+			if err != nil {
+				return "", err
+			}
+			var form *FormState
+			var selected string
+			switch selection := selection.(type) {
+			case string:
+				selected = selection
+			case *FormState:
+				form, selected = selection, selection.Value(name)
+			case nil:
+			default:
+				return "", fmt.Errorf("selectField: unsupported selection type %T", selection)
+			}
+			var b strings.Builder
+			b.WriteString("<select")
+			fieldAttributes(&b, name, c.Select, c.Invalid, form)
+			b.WriteString(">")
+			for _, option := range list {
+				b.WriteString(`<option value="` + esc(option.Value) + `"`)
+				if option.Value == selected {
+					b.WriteString(" selected")
+				}
+				b.WriteString(">" + esc(option.Label) + "</option>")
+			}
+			b.WriteString("</select>")
+			return template.HTML(b.String()), nil
+		},
+		"checkbox": func(name string, form *FormState) template.HTML {
+			var b strings.Builder
+			b.WriteString(`<input type="checkbox"`)
+			fieldAttributes(&b, name, c.Checkbox, c.Invalid, form)
+			b.WriteString(` value="on"`)
+			if value := form.Value(name); value != "" && value != "off" && value != "false" {
+				b.WriteString(" checked")
+			}
+			b.WriteString(">")
+			return template.HTML(b.String())
+		},
+		"label": func(name, text string) template.HTML {
+			return template.HTML(`<label for="` + esc(name) + `"` + classAttribute(c.Label) + `>` + esc(text) + `</label>`)
+		},
+		"fieldErrors": func(name string, form *FormState) template.HTML {
+			if !form.Invalid(name) {
+				return ""
+			}
+			var b strings.Builder
+			b.WriteString(`<ul id="` + esc(errorsID(name)) + `"` + classAttribute(c.Errors) + ` role="alert">`)
+			for _, message := range form.Errors[name] {
+				b.WriteString("<li>" + esc(message) + "</li>")
+			}
+			b.WriteString("</ul>")
+			return template.HTML(b.String())
+		},
+		"csrfField": func(form *FormState) template.HTML {
+			token := ""
+			if form != nil {
+				token = form.CSRFToken
+			}
+			return template.HTML(`<input type="hidden" name="` + CSRFFieldName + `" value="` + esc(token) + `">`)
+		},
+	}
+}
+
+// esc escapes s for HTML text and quoted attribute values.
+func esc(s string) string {
+	return template.HTMLEscapeString(s)
+}
+
+// errorsID returns the id of the error list of the named field.
+func errorsID(name string) string {
+	return name + "-errors"
+}
+
+// classAttribute returns the class attribute for classes, or nothing.
+func classAttribute(classes ...string) string {
+	var nonEmpty []string
+	for _, class := range classes {
+		if class != "" {
+			nonEmpty = append(nonEmpty, class)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return ` class="` + esc(strings.Join(nonEmpty, " ")) + `"`
+}
+
+// fieldAttributes writes the id, name, class and ARIA attributes of a field.
+func fieldAttributes(b *strings.Builder, name, class, invalidClass string, form *FormState) {
+	b.WriteString(` id="` + esc(name) + `" name="` + esc(name) + `"`)
+	if form.Invalid(name) {
+		b.WriteString(classAttribute(class, invalidClass))
+		b.WriteString(` aria-invalid="true" aria-describedby="` + esc(errorsID(name)) + `"`)
+		return
+	}
+	b.WriteString(classAttribute(class))
+}
+
+// selectOptions converts the options accepted by selectField.
+func selectOptions(options any) ([]SelectOption, error) {
+	switch options := options.(type) {
+	case nil:
+		return nil, nil
+	case []SelectOption:
+		return options, nil
+	case []string:
+		list := make([]SelectOption, len(options))
+		for i, option := range options {
+			list[i] = SelectOption{Value: option, Label: option}
+		}
+		return list, nil
+	case map[string]string:
+		list := make([]SelectOption, 0, len(options))
+		for value, label := range options {
+			list = append(list, SelectOption{Value: value, Label: label})
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Label != list[j].Label {
+				return list[i].Label < list[j].Label
+			}
+			return list[i].Value < list[j].Value
+		})
+		return list, nil
+	default:
+		return nil, fmt.Errorf("selectField: unsupported options type %T", options)
+	}
+}
@@ -0,0 +1,101 @@
+package templates
+
+import (
+	"flag"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the form helpers")
+
+// TestFormHelpers renders each form helper with a clean and a rejected form,
+// with and without classes, and compares the markup to testdata/forms. The
+// submitted values and the messages try to break out of their attributes.
+// Run with -update to rewrite the golden files.
+func TestFormHelpers(t *testing.T) {
+	hostile := `"><script>alert('x')</script>`
+	clean := NewFormState(url.Values{"country": {"fr"}, "email": {"ann@example.com"}, "comment": {"Hello"}, "terms": {"on"}, "password": {"secret"}})
+	rejected := NewFormState(url.Values{"country": {`"><x`}, "email": {hostile}, "comment": {"</textarea>" + hostile}, "terms": {"off"}, "password": {"secret"}})
+	rejected.CSRFToken = `tok"en`
+	for _, name := range []string{"email", "comment", "terms", "password", "country"} {
+		rejected.AddError(name, "is <b>invalid</b>")
+	}
+	rejected.AddError("email", "is taken & reserved")
+	data := map[string]any{
+		"Countries": []SelectOption{{Value: "fr", Label: "France"}, {Value: `"><x`, Label: "<script>"}},
+		"Strings":   []string{"a", "b&c"},
+		"Map":       map[string]string{"z": "Alpha", "a": "Beta"},
+	}
+	helpers := map[string]string{
+		"input":       `{{input "email" "email" .Form}}`,
+		"password":    `{{input "password" "password" .Form}}`,
+		"textarea":    `{{textarea "comment" .Form}}`,
+		"select":      `{{selectField "country" .Countries .Form}}{{selectField "letter" .Strings "b&c"}}{{selectField "map" .Map ""}}`,
+		"checkbox":    `{{checkbox "terms" .Form}}`,
+		"label":       `{{label "email" "E-mail <address>"}}`,
+		"fieldErrors": `{{fieldErrors "email" .Form}}|{{fieldErrors "missing" .Form}}`,
+		"csrfField":   `{{csrfField .Form}}`,
+	}
+	tests := []struct {
+		state   string
+		form    *FormState
+		classes []FormClasses
+	}{
+		{"clean", clean, nil},
+		{"error", rejected, nil},
+		{"clean-bootstrap", clean, []FormClasses{BootstrapFormClasses}},
+		{"error-bootstrap", rejected, []FormClasses{BootstrapFormClasses}},
+	}
+	for _, tt := range tests {
+		for helper, source := range helpers {
+			tmpl := template.Must(template.New(helper).Funcs(FormHelpers(tt.classes...)).Parse(source))
+			data["Form"] = tt.form
+			var b strings.Builder
+			if err := tmpl.Execute(&b, data); err != nil {
+				t.Errorf("%s %s: %v", helper, tt.state, err)
+				continue
+			}
+			got := b.String() + "\n"
+			if strings.Contains(got, "<script") || strings.Contains(got, "<b>") {
+				t.Errorf("%s %s: unescaped markup in %s", helper, tt.state, got)
+			}
+			golden := filepath.Join("testdata", "forms", helper+"-"+tt.state+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("%s %s:\n%s\nwant\n%s", helper, tt.state, got, want)
+			}
+		}
+	}
+}
+
+func TestFormHelpersErrors(t *testing.T) {
+	tests := []struct {
+		name, source string
+		data         any
+	}{
+		{"unsupported options", `{{selectField "n" . ""}}`, 42},
+		{"unsupported selection", `{{selectField "n" nil .}}`, 42},
+		{"nil form", `{{input "text" "n" .}}{{textarea "n" .}}{{checkbox "n" .}}{{selectField "n" nil .}}{{fieldErrors "n" .}}{{csrfField .}}`, (*FormState)(nil)},
+	}
+	for _, tt := range tests {
+		tmpl := template.Must(template.New(tt.name).Funcs(FormHelpers()).Parse(tt.source))
+		var b strings.Builder
+		err := tmpl.Execute(&b, tt.data)
+		if wantErr := strings.HasPrefix(tt.name, "unsupported"); (err != nil) != wantErr {
+			t.Errorf("%s: error %v, want an error %v", tt.name, err, wantErr)
+		}
+	}
+}
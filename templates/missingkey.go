@@ -0,0 +1,145 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"reflect"
+	"regexp"
+	ttemplate "text/template"
+)
+
+// MissingKeyMode selects how the templates handle references to keys absent
+// from map data, e.g. {{.Title}} rendered with a map without a "Title" entry.
+type MissingKeyMode int
+
+const (
+	// MissingKeysDefault behaves like MissingKeysIgnore. The server replaces it
+	// with the default of its profile.
+	MissingKeysDefault MissingKeyMode = iota
+	// MissingKeysIgnore renders nothing for missing keys, the html/template default.
+	MissingKeysIgnore
+	// MissingKeysError fails the rendering with a MissingKeyError.
+	MissingKeysError
+	// MissingKeysWarn logs the missing keys at Warn and renders like
+	// MissingKeysIgnore. Only map data is checked.
+	MissingKeysWarn
+)
+
+// maxMissingKeys is the number of missing keys reported for one rendering in
+// the MissingKeysWarn mode.
+const maxMissingKeys = 16
+
+// MissingKeyError reports a template referencing a key its data doesn't have.
+type MissingKeyError struct {
+	// Template is the name of the template executing the reference.
+	Template string
+	// Key is the missing key.
+	Key string
+	// Location is the position of the reference, e.g. "page.html:3:7".
+	Location string
+	// Reference is the expression referencing the key, e.g. ".User.Name".
+	Reference string
+	Err       error
+}
+
+// Error implements the error interface.
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("template %q references the missing key %q at %s in <%s>", e.Template, e.Key, e.Location, e.Reference)
+}
+
+// Unwrap returns the underlying execution error.
+func (e *MissingKeyError) Unwrap() error {
+	return e.Err
+}
+
+// missingKeyPattern matches the execution errors of missingkey=error.
+var missingKeyPattern = regexp.MustCompile(`^template: (.*?): executing "(.*?)" at <(.*?)>: map has no entry for key "(.*)"$`)
+
+// missingKey converts the missing key execution errors into a MissingKeyError,
+// the other errors are returned as is.
+func missingKey(err error) error {
+	var execError ttemplate.ExecError
+	if !errors.As(err, &execError) {
+		return err
+	}
+	match := missingKeyPattern.FindStringSubmatch(execError.Err.Error())
+	if match == nil {
+		return err
+	}
+	return &MissingKeyError{Template: match[2], Location: match[1], Reference: match[3], Key: match[4], Err: err}
+}
+
+// SetMissingKeys sets how missing map keys are handled, see MissingKeyMode.
+// It must be called before Parse.
+func (t *Templates) SetMissingKeys(mode MissingKeyMode) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.missingKeys = mode
+}
+
+// executeWarn renders map data with the strict set to find the missing keys,
+// logs them and renders the template like the lenient set would. The output is
+// buffered so that a failed strict rendering writes nothing; the template is
+// executed again only when keys are missing.
+func (t *Templates) executeWarn(ctx context.Context, strict *template.Template, wr io.Writer, name string, data interface{}) error {
+	var buf bytes.Buffer
+	cw := t.newContextWriter(ctx, &buf)
+	err := strict.ExecuteTemplate(cw, name, data)
+	var missing *MissingKeyError
+	if err == nil || !errors.As(missingKey(err), &missing) {
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteTo(wr)
+		return err
+	}
+	for _, key := range recordMissingKeys(ctx, strict, name, data, missing) {
+		slog.Warn("Template references a missing key", "template", name, "key", key.Key, "location", key.Location)
+	}
+	cw = t.newContextWriter(ctx, wr)
+	return t.current().ExecuteTemplate(cw, name, data)
+}
+
+// recordMissingKeys finds the missing keys past the first one: the template
+// is rendered again with a copy of the data where each missing top level key
+// is added with its zero value, until it renders or a key can't be added
+// (a nested map misses it).
+func recordMissingKeys(ctx context.Context, strict *template.Template, name string, data interface{}, first *MissingKeyError) []*MissingKeyError {
+	keys := []*MissingKeyError{first}
+	original := reflect.ValueOf(data)
+	recording := reflect.MakeMapWithSize(original.Type(), original.Len()+1)
+	iter := original.MapRange()
+	for iter.Next() {
+		recording.SetMapIndex(iter.Key(), iter.Value())
+	}
+	zero := reflect.Zero(original.Type().Elem())
+	for len(keys) < maxMissingKeys {
+		key := reflect.ValueOf(keys[len(keys)-1].Key).Convert(original.Type().Key())
+		if recording.MapIndex(key).IsValid() {
+			break
+		}
+		recording.SetMapIndex(key, zero)
+		cw := &contextWriter{ctx: ctx, w: io.Discard, interval: DefaultCheckInterval}
+		var missing *MissingKeyError
+		if !errors.As(missingKey(strict.ExecuteTemplate(cw, name, recording.Interface())), &missing) {
+			break
+		}
+		if last := keys[len(keys)-1]; missing.Key == last.Key && missing.Location == last.Location {
+			break
+		}
+		keys = append(keys, missing)
+	}
+	return keys
+}
+
+// isMapData reports whether data is a map with string keys, the data checked
+// by the MissingKeysWarn mode.
+func isMapData(data interface{}) bool {
+	v := reflect.ValueOf(data)
+	return v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String
+}
@@ -0,0 +1,109 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// page is the data of page.html as a struct.
+type page struct {
+	Title string
+	User  struct{ Name string }
+}
+
+func newMissingKeyTemplates(t *testing.T, mode MissingKeyMode) *Templates {
+	t.Helper()
+	tmpl := NewTemplates()
+	tmpl.AddFS(fstest.MapFS{
+		"page.html": {Data: []byte(`{{define "page.html"}}<h1>{{.Title}}</h1>{{template "user.html" .User}}{{end}}`)},
+		"user.html": {Data: []byte(`{{define "user.html"}}<p>{{.Name}}</p>{{end}}`)},
+		"body.html": {Data: []byte(`{{define "body.html"}}{{.Title}}|{{.Body}}|{{.Footer}}{{end}}`)},
+	}, "*.html")
+	tmpl.SetMissingKeys(mode)
+	if err := tmpl.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestMissingKeys(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	user := map[string]any{"Name": "alice"}
+	tests := []struct {
+		name     string
+		mode     MissingKeyMode
+		template string
+		data     any
+		want     string
+		// wantErr is the missing key of the MissingKeyError, "*" for another error.
+		wantErr  string
+		wantWarn []string
+	}{
+		{"complete", MissingKeysError, "page.html", map[string]any{"Title": "Home", "User": user}, "<h1>Home</h1><p>alice</p>", "", nil},
+		{"default", MissingKeysDefault, "page.html", map[string]any{"User": user}, "<h1></h1><p>alice</p>", "", nil},
+		{"ignored", MissingKeysIgnore, "page.html", map[string]any{"User": user}, "<h1></h1><p>alice</p>", "", nil},
+		{"error", MissingKeysError, "page.html", map[string]any{"User": user}, "", "Title", nil},
+		{"error in a nested template", MissingKeysError, "page.html", map[string]any{"Title": "Home", "User": map[string]any{}}, "", "Name", nil},
+		{"error with struct data", MissingKeysError, "body.html", page{Title: "Home"}, "", "*", nil},
+		{"struct data", MissingKeysError, "page.html", page{Title: "Home"}, "<h1>Home</h1><p></p>", "", nil},
+		{"warned", MissingKeysWarn, "body.html", map[string]any{"Title": "Home"}, "Home||", "", []string{"Body", "Footer"}},
+		{"warned in a nested template", MissingKeysWarn, "page.html", map[string]any{"Title": "Home", "User": map[string]any{}}, "<h1>Home</h1><p></p>", "", []string{"Name"}},
+		{"warned, typed map", MissingKeysWarn, "body.html", map[string]string{"Body": "text"}, "|text|", "", []string{"Title", "Footer"}},
+		{"nothing to warn", MissingKeysWarn, "page.html", map[string]any{"Title": "Home", "User": user}, "<h1>Home</h1><p>alice</p>", "", nil},
+		{"struct data unchecked", MissingKeysWarn, "page.html", page{Title: "Home"}, "<h1>Home</h1><p></p>", "", nil},
+	}
+	for _, tt := range tests {
+		tmpl := newMissingKeyTemplates(t, tt.mode)
+		logs.Reset()
+		var out strings.Builder
+		err := tmpl.ExecuteContext(context.Background(), &out, tt.template, tt.data)
+		var missing *MissingKeyError
+		switch {
+		case tt.wantErr == "":
+			if err != nil || out.String() != tt.want {
+				t.Errorf("%s: %q %v, want %q", tt.name, out.String(), err, tt.want)
+			}
+		case tt.wantErr == "*":
+			if err == nil || errors.As(err, &missing) {
+				t.Errorf("%s: error %v, want an execution error", tt.name, err)
+			}
+		case !errors.As(err, &missing) || missing.Key != tt.wantErr:
+			t.Errorf("%s: error %v, want the missing key %q", tt.name, err, tt.wantErr)
+		}
+		var warned []string
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if _, key, ok := strings.Cut(line, " key="); ok && strings.Contains(line, "level=WARN") {
+				warned = append(warned, strings.Fields(key)[0])
+			}
+		}
+		if strings.Join(warned, ",") != strings.Join(tt.wantWarn, ",") {
+			t.Errorf("%s: warned of %q, want %q", tt.name, warned, tt.wantWarn)
+		}
+	}
+}
+
+func TestMissingKeyError(t *testing.T) {
+	tmpl := newMissingKeyTemplates(t, MissingKeysError)
+	err := tmpl.Execute(&strings.Builder{}, "page.html", map[string]any{"Title": "Home", "User": map[string]any{}})
+	var missing *MissingKeyError
+	if !errors.As(err, &missing) {
+		t.Fatalf("error %v, want a MissingKeyError", err)
+	}
+	want := MissingKeyError{Template: "user.html", Key: "Name", Location: "user.html:1:27", Reference: ".Name"}
+	if missing.Template != want.Template || missing.Key != want.Key || missing.Location != want.Location || missing.Reference != want.Reference {
+		t.Errorf("%+v, want %+v", *missing, want)
+	}
+	if got := err.Error(); got != `template "user.html" references the missing key "Name" at user.html:1:27 in <.Name>` {
+		t.Errorf("message %q", got)
+	}
+	if missing.Unwrap() == nil {
+		t.Error("no underlying execution error")
+	}
+}
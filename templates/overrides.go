@@ -0,0 +1,122 @@
+package templates
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"log/slog"
+
+	"github.com/Morditux/serverlib/cache"
+)
+
+// DefaultOverrideCacheSize is the number of parsed tenant overrides kept in memory.
+const DefaultOverrideCacheSize = 1024
+
+// OverrideProvider supplies the tenant specific versions of templates, e.g.
+// from a database managed through an admin UI.
+type OverrideProvider interface {
+	// Lookup returns the content of the override of the named template for the
+	// tenant and its version, or ok false when the tenant has no override.
+	// The version identifies the content: a new version makes the templates
+	// parse the content again.
+	Lookup(ctx context.Context, tenant, name string) (content string, version string, ok bool, err error)
+}
+
+// override is a parsed tenant override. A nil template marks a broken override.
+type override struct {
+	version  string
+	template *template.Template
+	// base is the compiled set the override was parsed against.
+	base *template.Template
+}
+
+// SetOverrideProvider sets the provider consulted by ExecuteTenant.
+// It must be called before the templates are used.
+func (t *Templates) SetOverrideProvider(provider OverrideProvider) {
+	t.overrides = provider
+	t.overrideCache = cache.New(cache.Options[string, override]{MaxEntries: DefaultOverrideCacheSize})
+}
+
+// ExecuteTenant renders the named template like ExecuteContext, using the
+// override of the tenant when the provider has one. An override is parsed
+// with the same functions and sees the same associated templates as the
+// compiled set, it only replaces the named template. Parsed overrides are
+// cached until the provider reports a new version or the templates are parsed
+// again.
+//
+// Overrides are parsed with the option of the compiled set, the missing keys
+// referenced by their associated templates are ignored.
+//
+// Overrides must never break a page: when the provider fails or the override
+// does not parse, a warning is logged and the compiled template is rendered.
+//
+// Parameters:
+//   - ctx: The context controlling the lookup and the rendering.
+//   - wr: The writer receiving the rendered output.
+//   - tenant: The tenant the page is rendered for.
+//   - name: The name of the template to render.
+//   - data: The data passed to the template.
+//
+// Returns:
+//   - error: ctx.Err() if the context was cancelled, the rendering error otherwise.
+func (t *Templates) ExecuteTenant(ctx context.Context, wr io.Writer, tenant, name string, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tmpl := t.override(ctx, tenant, name)
+	if tmpl == nil {
+		return t.ExecuteContext(ctx, wr, name, data)
+	}
+	cw := t.newContextWriter(ctx, wr)
+	err := tmpl.ExecuteTemplate(cw, name, data)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return missingKey(err)
+}
+
+// override returns the template set with the override of the tenant, or nil to
+// render the compiled template.
+func (t *Templates) override(ctx context.Context, tenant, name string) *template.Template {
+	if t.overrides == nil || tenant == "" {
+		return nil
+	}
+	content, version, ok, err := t.overrides.Lookup(ctx, tenant, name)
+	if err != nil {
+		slog.Warn("Template override lookup failed", "tenant", tenant, "template", name, "error", err)
+		return nil
+	}
+	key := tenant + "\x00" + name
+	if !ok {
+		t.overrideCache.Delete(key)
+		return nil
+	}
+	t.mut.RLock()
+	base := t.pristine
+	t.mut.RUnlock()
+	if cached, found := t.overrideCache.Get(key); found && cached.version == version && cached.base == base {
+		return cached.template
+	}
+	parsed, err := parseOverride(base, name, content)
+	if err != nil {
+		slog.Warn("Invalid template override, using the default template", "tenant", tenant, "template", name, "version", version, "error", err)
+	}
+	// Broken overrides are cached too, so that they are reported once per version.
+	t.overrideCache.Set(key, override{version: version, template: parsed, base: base})
+	return parsed
+}
+
+// parseOverride parses content as the named template in a copy of the compiled set base.
+func parseOverride(base *template.Template, name, content string) (*template.Template, error) {
+	if base == nil {
+		return nil, ErrNotParsed
+	}
+	tmpl, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpl.New(name).Parse(content); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,136 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeProvider is an OverrideProvider serving the overrides of its map,
+// keyed by tenant and template name.
+type fakeProvider struct {
+	mut       sync.Mutex
+	overrides map[string]fakeOverride
+	err       error
+}
+
+type fakeOverride struct {
+	content, version string
+}
+
+func (p *fakeProvider) Lookup(_ context.Context, tenant, name string) (string, string, bool, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.err != nil {
+		return "", "", false, p.err
+	}
+	override, ok := p.overrides[tenant+"/"+name]
+	return override.content, override.version, ok, nil
+}
+
+func (p *fakeProvider) set(key, content, version string) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.overrides[key] = fakeOverride{content: content, version: version}
+}
+
+func newOverrideTemplates(t *testing.T, provider OverrideProvider) *Templates {
+	t.Helper()
+	tmpl := NewTemplates()
+	tmpl.AddFS(fstest.MapFS{
+		"invoice.html": {Data: []byte(`{{define "invoice.html"}}invoice {{.}} {{template "footer.html"}}{{end}}`)},
+		"footer.html":  {Data: []byte(`{{define "footer.html"}}default footer{{end}}`)},
+	}, "*.html")
+	tmpl.AddFuncs(map[string]any{"upper": strings.ToUpper})
+	tmpl.SetOverrideProvider(provider)
+	if err := tmpl.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestExecuteTenant(t *testing.T) {
+	provider := &fakeProvider{overrides: map[string]fakeOverride{}}
+	tmpl := newOverrideTemplates(t, provider)
+	render := func(tenant, name string) string {
+		var b strings.Builder
+		if err := tmpl.ExecuteTenant(context.Background(), &b, tenant, name, "42"); err != nil {
+			t.Errorf("ExecuteTenant(%q, %q): %v", tenant, name, err)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name   string
+		change func()
+		tenant string
+		want   string
+	}{
+		{"miss", nil, "acme", "invoice 42 default footer"},
+		{"no tenant", func() { provider.set("/invoice.html", "never", "1") }, "", "invoice 42 default footer"},
+		{"hit", func() { provider.set("acme/invoice.html", `acme {{upper .}} {{template "footer.html"}}`, "1") }, "acme", "acme 42 default footer"},
+		{"other tenant", nil, "globex", "invoice 42 default footer"},
+		{"same version cached", func() { provider.set("acme/invoice.html", "not parsed again", "1") }, "acme", "acme 42 default footer"},
+		{"new version", func() { provider.set("acme/invoice.html", "acme v2 {{.}}", "2") }, "acme", "acme v2 42"},
+		{"broken override", func() { provider.set("acme/invoice.html", "acme {{.Broken", "3") }, "acme", "invoice 42 default footer"},
+		{"fixed override", func() { provider.set("acme/invoice.html", "acme v4", "4") }, "acme", "acme v4"},
+		{"removed override", func() {
+			provider.mut.Lock()
+			delete(provider.overrides, "acme/invoice.html")
+			provider.mut.Unlock()
+		}, "acme", "invoice 42 default footer"},
+		{"provider failing", func() {
+			provider.set("acme/invoice.html", "acme v5", "5")
+			provider.mut.Lock()
+			provider.err = errors.New("database down")
+			provider.mut.Unlock()
+		}, "acme", "invoice 42 default footer"},
+		{"provider back", func() {
+			provider.mut.Lock()
+			provider.err = nil
+			provider.mut.Unlock()
+		}, "acme", "acme v5"},
+	}
+	for _, tt := range tests {
+		if tt.change != nil {
+			tt.change()
+		}
+		if got := render(tt.tenant, "invoice.html"); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestExecuteTenantReparse parses the templates again: the cached override is
+// dropped and parsed again against the new compiled set, with the same version.
+func TestExecuteTenantReparse(t *testing.T) {
+	provider := &fakeProvider{overrides: map[string]fakeOverride{}}
+	provider.set("acme/invoice.html", `acme {{template "footer.html"}}`, "1")
+	tmpl := newOverrideTemplates(t, provider)
+	first := tmpl.override(context.Background(), "acme", "invoice.html")
+	if again := tmpl.override(context.Background(), "acme", "invoice.html"); again != first {
+		t.Error("override parsed again for the same version")
+	}
+	if err := tmpl.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if n := tmpl.overrideCache.Len(); n != 0 {
+		t.Errorf("%d overrides of the previous compiled set cached after Parse", n)
+	}
+	if reparsed := tmpl.override(context.Background(), "acme", "invoice.html"); reparsed == first {
+		t.Error("override of the previous compiled set used after Parse")
+	}
+}
+
+func TestExecuteTenantCancelled(t *testing.T) {
+	provider := &fakeProvider{overrides: map[string]fakeOverride{}}
+	tmpl := newOverrideTemplates(t, provider)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var b strings.Builder
+	if err := tmpl.ExecuteTenant(ctx, &b, "acme", "invoice.html", nil); !errors.Is(err, context.Canceled) || b.Len() != 0 {
+		t.Errorf("%v %q, want context.Canceled and nothing", err, b.String())
+	}
+}
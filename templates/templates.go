@@ -1,41 +1,331 @@
 package templates
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
+	"path"
 	"path/filepath"
+	"sync"
+
+	"github.com/Morditux/serverlib/cache"
 )
 
+// DefaultCheckInterval is the number of bytes written between two context
+// checks in ExecuteContext when no interval has been configured.
+const DefaultCheckInterval = 4096
+
 type Templates struct {
-	sources  []string
-	template *template.Template
+	sources       []string
+	fsSources     []fsSource
+	funcs         template.FuncMap
+	template      *template.Template
+	checkInterval int
+	mut           *sync.RWMutex
+	// pristine is a never executed copy of template, the base of the tenant
+	// overrides: html/template can't clone a set once it has been executed.
+	pristine      *template.Template
+	overrides     OverrideProvider
+	overrideCache *cache.Cache[string, override]
+	missingKeys   MissingKeyMode
+	// strict is the set parsed with missingkey=error in the MissingKeysWarn
+	// mode: the option of a set is lost by the templates of its clones.
+	strict   *template.Template
+	patterns []string
+	watcher  *watcherState
+	defaults fs.FS
+	prefix   string
 }
 
+// DefaultPatterns are the file patterns of the templates of a source.
+var DefaultPatterns = []string{"*.html"}
+
+// ErrNotParsed is returned when the templates are executed before Parse succeeded.
+var ErrNotParsed = errors.New("templates not parsed")
+
 func NewTemplates() *Templates {
 	return &Templates{
-		sources:  []string{},
-		template: nil,
+		sources:       []string{},
+		funcs:         template.FuncMap{},
+		template:      nil,
+		checkInterval: DefaultCheckInterval,
+		mut:           &sync.RWMutex{},
+		patterns:      DefaultPatterns,
+		watcher:       &watcherState{mut: &sync.Mutex{}},
+	}
+}
+
+// AddFuncs adds functions to the template function map.
+// The functions must be added before Parse is called.
+func (t *Templates) AddFuncs(funcs template.FuncMap) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for name, fn := range funcs {
+		t.funcs[name] = fn
 	}
 }
 
 func (t *Templates) AddSource(source string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
 	t.sources = append(t.sources, source)
 }
 
-func (t *Templates) Parse() error {
-	if t.template == nil {
-		t.template = template.New("main")
+// fsSource is a source of AddFS.
+type fsSource struct {
+	fsys fs.FS
+	glob string
+}
+
+// AddFS adds the templates of fsys matching glob, e.g. "templates/*.html",
+// as a source, e.g. an embed.FS shipped in the binary. An empty glob matches
+// the patterns of SetPatterns at the root of fsys. The templates are named
+// after the base name of their file, like the ones of AddSource, and parsed
+// after them; the watcher ignores them.
+func (t *Templates) AddFS(fsys fs.FS, glob string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.fsSources = append(t.fsSources, fsSource{fsys: fsys, glob: glob})
+}
+
+// SetPatterns sets the file patterns of the templates of every source, e.g.
+// "*.html", "*.txt" and "*.xml". The patterns are matched by the parsing and
+// the watcher alike. Defaults to DefaultPatterns.
+func (t *Templates) SetPatterns(patterns ...string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.patterns = append([]string(nil), patterns...)
+}
+
+// files returns the template files of a source.
+func (t *Templates) files(source string) []string {
+	t.mut.RLock()
+	patterns := t.patterns
+	t.mut.RUnlock()
+	var files []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(source, pattern))
+		files = append(files, matches...)
 	}
-	for _, source := range t.sources {
-		path := filepath.Join(source, "*.html")
-		_, err := t.template.ParseGlob(path)
+	return files
+}
+
+// SetDefaults sets the default templates: every template file of fsys is
+// parsed before the sources, named after its path prefixed with prefix. A
+// source defining a template with the same name overrides the default.
+func (t *Templates) SetDefaults(fsys fs.FS, prefix string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.defaults = fsys
+	t.prefix = prefix
+}
+
+// Has reports whether the parsed templates define the named template.
+func (t *Templates) Has(name string) bool {
+	tmpl := t.current()
+	return tmpl != nil && tmpl.Lookup(name) != nil
+}
+
+// parseDefaults parses the default templates into tmpl.
+func (t *Templates) parseDefaults(tmpl *template.Template) error {
+	t.mut.RLock()
+	defaults, prefix := t.defaults, t.prefix
+	t.mut.RUnlock()
+	if defaults == nil {
+		return nil
+	}
+	return fs.WalkDir(defaults, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(name) != ".html" {
+			return err
+		}
+		content, err := fs.ReadFile(defaults, name)
 		if err != nil {
 			return err
 		}
+		_, err = tmpl.New(prefix + name).Parse(string(content))
+		return err
+	})
+}
+
+// sourceList returns a copy of the template sources.
+func (t *Templates) sourceList() []string {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+	return append([]string(nil), t.sources...)
+}
+
+// SetCheckInterval sets the number of bytes ExecuteContext writes between two
+// checks of the context. A value of zero or less checks the context on every write.
+func (t *Templates) SetCheckInterval(bytes int) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.checkInterval = bytes
+}
+
+// Parse parses the templates of every source into a new template set.
+// It can be called again to reload the templates: the new set replaces the
+// current one only if it parsed successfully, renders in progress finish with
+// the set they started with. The set is parsed with the missingkey option of
+// the mode set with SetMissingKeys.
+func (t *Templates) Parse() error {
+	t.mut.RLock()
+	mode := t.missingKeys
+	t.mut.RUnlock()
+	option := "missingkey=default"
+	if mode == MissingKeysError {
+		option = "missingkey=error"
+	}
+	tmpl, err := t.parseSet(option)
+	if err != nil {
+		return err
+	}
+	var strict *template.Template
+	if mode == MissingKeysWarn {
+		if strict, err = t.parseSet("missingkey=error"); err != nil {
+			return err
+		}
+	}
+	pristine, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	t.mut.Lock()
+	t.template = tmpl
+	t.pristine = pristine
+	t.strict = strict
+	t.mut.Unlock()
+	if t.overrideCache != nil {
+		// The cached overrides were parsed against the previous set, which
+		// they keep alive.
+		t.overrideCache.Clear()
 	}
 	return nil
 }
 
+// parseSet parses the templates of every source with the given option.
+func (t *Templates) parseSet(option string) (*template.Template, error) {
+	// Parse runs concurrently with the template watcher, sources and functions
+	// added meanwhile are picked up by the next parse.
+	t.mut.RLock()
+	tmpl := template.New("main").Option(option).Funcs(t.funcs)
+	t.mut.RUnlock()
+	if err := t.parseDefaults(tmpl); err != nil {
+		return nil, err
+	}
+	for _, source := range t.sourceList() {
+		files := t.files(source)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("templates: no template file in %s", source)
+		}
+		if _, err := tmpl.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+	t.mut.RLock()
+	fsSources := append([]fsSource(nil), t.fsSources...)
+	patterns := t.patterns
+	t.mut.RUnlock()
+	for _, source := range fsSources {
+		globs := patterns
+		if source.glob != "" {
+			globs = []string{source.glob}
+		}
+		var files []string
+		for _, glob := range globs {
+			matches, err := fs.Glob(source.fsys, glob)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("templates: no template file matching %v in the file system", globs)
+		}
+		if _, err := tmpl.ParseFS(source.fsys, files...); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// current returns the current template set.
+func (t *Templates) current() *template.Template {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+	return t.template
+}
+
+// currentStrict returns the strict set of the MissingKeysWarn mode, or nil.
+func (t *Templates) currentStrict() *template.Template {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+	return t.strict
+}
+
 func (t *Templates) Execute(wr io.Writer, name string, data interface{}) error {
-	return t.template.ExecuteTemplate(wr, name, data)
+	return t.ExecuteContext(context.Background(), wr, name, data)
+}
+
+// ExecuteContext renders the named template like Execute, but aborts the
+// rendering as soon as ctx is cancelled. The context is checked between writes,
+// once every check interval bytes (see SetCheckInterval).
+//
+// Parameters:
+//   - ctx: The context controlling the rendering, usually the request context.
+//   - wr: The writer receiving the rendered output.
+//   - name: The name of the template to render.
+//   - data: The data passed to the template.
+//
+// Returns:
+//   - error: ctx.Err() if the context was cancelled, a *MissingKeyError for a
+//     missing key in the MissingKeysError mode, the rendering error otherwise.
+func (t *Templates) ExecuteContext(ctx context.Context, wr io.Writer, name string, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if t.current() == nil {
+		return ErrNotParsed
+	}
+	var err error
+	if strict := t.currentStrict(); strict != nil && isMapData(data) {
+		err = t.executeWarn(ctx, strict, wr, name, data)
+	} else {
+		cw := t.newContextWriter(ctx, wr)
+		err = t.current().ExecuteTemplate(cw, name, data)
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return missingKey(err)
+}
+
+// newContextWriter returns a contextWriter of wr checking ctx every check
+// interval.
+func (t *Templates) newContextWriter(ctx context.Context, wr io.Writer) *contextWriter {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+	return &contextWriter{ctx: ctx, w: wr, interval: t.checkInterval}
+}
+
+// contextWriter wraps an io.Writer and fails the writes once its context is done.
+type contextWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	interval int
+	pending  int
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if w.pending >= w.interval {
+		if err := w.ctx.Err(); err != nil {
+			return 0, err
+		}
+		w.pending = 0
+	}
+	n, err := w.w.Write(p)
+	w.pending += n
+	return n, err
 }
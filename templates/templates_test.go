@@ -0,0 +1,152 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExecuteBeforeParse(t *testing.T) {
+	tmpl := NewTemplates()
+	tests := []struct {
+		name    string
+		execute func(w *bytes.Buffer) error
+	}{
+		{"Execute", func(w *bytes.Buffer) error { return tmpl.Execute(w, "page.html", nil) }},
+		{"ExecuteContext", func(w *bytes.Buffer) error {
+			return tmpl.ExecuteContext(context.Background(), w, "page.html", nil)
+		}},
+		{"ExecuteTenant", func(w *bytes.Buffer) error {
+			return tmpl.ExecuteTenant(context.Background(), w, "acme", "page.html", nil)
+		}},
+	}
+	for _, tt := range tests {
+		var w bytes.Buffer
+		if err := tt.execute(&w); !errors.Is(err, ErrNotParsed) || w.Len() != 0 {
+			t.Errorf("%s: %v %q, want ErrNotParsed", tt.name, err, w.String())
+		}
+	}
+}
+
+// cancellingWriter cancels its context on its first write.
+type cancellingWriter struct {
+	bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.Buffer.Write(p)
+}
+
+// TestSetCheckInterval cancels the context during the rendering: it is seen
+// on the next write with an interval of zero, not before the interval is
+// written otherwise. SetCheckInterval is called concurrently with the
+// renderings, see -race.
+func TestSetCheckInterval(t *testing.T) {
+	tmpl := NewTemplates()
+	tmpl.AddFS(fstest.MapFS{"list.html": {Data: []byte(`{{define "list.html"}}{{range .}}<li>{{.}}</li>{{end}}{{end}}`)}}, "*.html")
+	if err := tmpl.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	items := make([]int, 100)
+	tests := []struct {
+		interval int
+		wantErr  bool
+	}{
+		{0, true},
+		{-1, true},
+		{DefaultCheckInterval, false},
+	}
+	for _, tt := range tests {
+		tmpl.SetCheckInterval(tt.interval)
+		ctx, cancel := context.WithCancel(context.Background())
+		w := &cancellingWriter{cancel: cancel}
+		err := tmpl.ExecuteContext(ctx, w, "list.html", items)
+		cancel()
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, context.Canceled)) {
+			t.Errorf("interval %d: %v, want error %v", tt.interval, err, tt.wantErr)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if i == 0 {
+					tmpl.SetCheckInterval(16)
+					continue
+				}
+				if err := tmpl.ExecuteContext(context.Background(), io.Discard, "list.html", items); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestAddFS parses the templates of file system sources, named after the
+// base name of their file and parsed after the directory sources.
+func TestAddFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte("directory page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"views/page.html":  {Data: []byte("fs page")},
+		"views/mail.txt":   {Data: []byte("fs mail")},
+		"views/sub/x.html": {Data: []byte("nested")},
+		"layout.html":      {Data: []byte("root layout")},
+		"feed.xml":         {Data: []byte("root feed")},
+	}
+	tests := []struct {
+		name    string
+		setup   func(tmpl *Templates)
+		want    map[string]string
+		wantErr bool
+	}{
+		{"glob", func(tmpl *Templates) { tmpl.AddFS(fsys, "views/*.html") }, map[string]string{"page.html": "fs page", "mail.txt": "", "x.html": ""}, false},
+		{"patterns", func(tmpl *Templates) {
+			tmpl.SetPatterns("*.html", "*.xml")
+			tmpl.AddFS(fsys, "")
+		}, map[string]string{"layout.html": "root layout", "feed.xml": "root feed", "page.html": ""}, false},
+		{"after the directory sources", func(tmpl *Templates) {
+			tmpl.AddFS(fsys, "views/*.html")
+			tmpl.AddSource(dir)
+		}, map[string]string{"page.html": "fs page"}, false},
+		{"no match", func(tmpl *Templates) { tmpl.AddFS(fsys, "missing/*.html") }, nil, true},
+		{"invalid glob", func(tmpl *Templates) { tmpl.AddFS(fsys, "[") }, nil, true},
+	}
+	for _, tt := range tests {
+		tmpl := NewTemplates()
+		tt.setup(tmpl)
+		err := tmpl.Parse()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for name, want := range tt.want {
+			var w bytes.Buffer
+			err := tmpl.Execute(&w, name, nil)
+			if want == "" {
+				if err == nil {
+					t.Errorf("%s: %s parsed", tt.name, name)
+				}
+				continue
+			}
+			if err != nil || w.String() != want {
+				t.Errorf("%s: %s %q, %v, want %q", tt.name, name, w.String(), err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is the polling interval of Watch when none is given.
+const DefaultWatchInterval = 500 * time.Millisecond
+
+// fileState is what Watch compares to detect a change.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatcherStats describes the template watcher, see Templates.WatcherStats.
+type WatcherStats struct {
+	// Running is true while Watch runs.
+	Running bool `json:"running"`
+	// Alive is true when the watcher polled the sources recently: a running
+	// watcher that isn't alive is stuck.
+	Alive bool `json:"alive"`
+	// Files is the number of template files watched.
+	Files int `json:"files"`
+	// MissingSources lists the sources that don't exist anymore. They are
+	// watched again once recreated.
+	MissingSources []string `json:"missing_sources,omitempty"`
+	// Reloads is the number of reloads, Restarts the number of times the
+	// watcher recovered from a failure.
+	Reloads  int `json:"reloads"`
+	Restarts int `json:"restarts"`
+	// LastPoll and LastReload are the times of the latest poll and reload.
+	LastPoll   time.Time `json:"last_poll"`
+	LastReload time.Time `json:"last_reload"`
+	// LastError is the latest reload error, cleared by a successful reload.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// watcherState holds the WatcherStats of the Watch loop.
+type watcherState struct {
+	mut      *sync.Mutex
+	interval time.Duration
+	stats    WatcherStats
+}
+
+// Watch polls the template sources and reparses the templates whenever a
+// template file is added, removed or modified. Polling is used rather than file
+// system notifications so that editors saving through a rename, and sources
+// directories being removed and recreated, are handled like any other change.
+// A watcher failing, e.g. on a panic of onChange, is restarted. The state of
+// the watcher is reported by WatcherStats and its changes are logged.
+// onChange, if not nil, is called after every reload with the Parse error, if any.
+// Watch blocks until ctx is done.
+//
+// Parameters:
+//   - ctx: The context stopping the watcher.
+//   - interval: The polling interval. Defaults to DefaultWatchInterval.
+//   - onChange: The function called after each reload.
+func (t *Templates) Watch(ctx context.Context, interval time.Duration, onChange func(error)) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	t.watcher.update(func(stats *WatcherStats) {
+		stats.Running = true
+	})
+	t.watcher.mut.Lock()
+	t.watcher.interval = interval
+	t.watcher.mut.Unlock()
+	defer t.watcher.update(func(stats *WatcherStats) {
+		stats.Running = false
+	})
+	previous := t.snapshot()
+	for !t.watch(ctx, interval, onChange, &previous) {
+		t.watcher.update(func(stats *WatcherStats) {
+			stats.Restarts++
+		})
+		slog.Warn("Template watcher restarted")
+	}
+}
+
+// watch runs the polling loop until ctx is done, it returns true, or until it
+// fails, it returns false.
+func (t *Templates) watch(ctx context.Context, interval time.Duration, onChange func(error), previous *map[string]fileState) (done bool) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			slog.Error("Template watcher failed", "error", fmt.Sprint(recovered))
+			done = false
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-ticker.C:
+		}
+		current := t.snapshot()
+		if sameSnapshot(*previous, current) {
+			continue
+		}
+		*previous = current
+		err := t.Parse()
+		t.watcher.update(func(stats *WatcherStats) {
+			stats.Reloads++
+			stats.LastReload = time.Now()
+			stats.LastError = ""
+			if err != nil {
+				stats.LastError = err.Error()
+			}
+		})
+		if onChange != nil {
+			onChange(err)
+		}
+	}
+}
+
+// snapshot returns the state of every template file of the sources, and
+// records the poll in the watcher stats.
+func (t *Templates) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	var missing []string
+	for _, source := range t.sourceList() {
+		if _, err := os.Stat(source); err != nil {
+			missing = append(missing, source)
+			continue
+		}
+		for _, match := range t.files(source) {
+			info, err := os.Stat(match)
+			if err != nil {
+				continue
+			}
+			files[match] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	t.watcher.update(func(stats *WatcherStats) {
+		for _, source := range missing {
+			if !contains(stats.MissingSources, source) {
+				slog.Warn("Template source missing, waiting for it to be recreated", "source", source)
+			}
+		}
+		for _, source := range stats.MissingSources {
+			if !contains(missing, source) {
+				slog.Info("Template source restored", "source", source)
+			}
+		}
+		stats.MissingSources = missing
+		stats.Files = len(files)
+		stats.LastPoll = time.Now()
+	})
+	return files
+}
+
+// WatcherStats returns the state of the template watcher.
+func (t *Templates) WatcherStats() WatcherStats {
+	t.watcher.mut.Lock()
+	defer t.watcher.mut.Unlock()
+	stats := t.watcher.stats
+	stats.MissingSources = append([]string(nil), stats.MissingSources...)
+	// A poll is late after a few intervals without one.
+	stats.Alive = stats.Running && time.Since(stats.LastPoll) < 3*t.watcher.interval
+	return stats
+}
+
+func (w *watcherState) update(fn func(*WatcherStats)) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	fn(&w.stats)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSnapshot(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, state := range a {
+		if other, ok := b[path]; !ok || other != state {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package templates
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const watchInterval = 5 * time.Millisecond
+
+// watched parses the templates of dir and watches them, returning the
+// channel of their reloads.
+func watched(t *testing.T, dir string, onChange func(error)) (*Templates, <-chan error) {
+	t.Helper()
+	tmpl := NewTemplates()
+	tmpl.AddSource(dir)
+	tmpl.SetPatterns("*.html", "*.txt")
+	if err := tmpl.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan error, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tmpl.Watch(ctx, watchInterval, func(err error) {
+			if onChange != nil {
+				onChange(err)
+			}
+			reloads <- err
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	// Polled once, the changes of the test are seen.
+	for tmpl.WatcherStats().LastPoll.IsZero() {
+		time.Sleep(time.Millisecond)
+	}
+	return tmpl, reloads
+}
+
+func write(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func render(tmpl *Templates, name string) string {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, name, nil); err != nil {
+		return "error: " + err.Error()
+	}
+	return out.String()
+}
+
+// TestWatch changes the templates like editors and deployments do, and
+// checks each change reloads them.
+func TestWatch(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "templates")
+	os.Mkdir(dir, 0o755)
+	write(t, filepath.Join(dir, "page.html"), "v1")
+	tmpl, reloads := watched(t, dir, nil)
+	tests := []struct {
+		name   string
+		change func()
+		// reload is false when the change must not reload the templates.
+		reload   bool
+		wantErr  bool
+		template string
+		want     string
+	}{
+		{"modified", func() { write(t, filepath.Join(dir, "page.html"), "version 2") }, true, false, "page.html", "version 2"},
+		{"saved through a rename", func() {
+			write(t, filepath.Join(dir, ".page.html.swp"), "saved by rename")
+			os.Rename(filepath.Join(dir, ".page.html.swp"), filepath.Join(dir, "page.html"))
+		}, true, false, "page.html", "saved by rename"},
+		{"added", func() { write(t, filepath.Join(dir, "other.html"), "other") }, true, false, "other.html", "other"},
+		{"other pattern", func() { write(t, filepath.Join(dir, "mail.txt"), "text mail") }, true, false, "mail.txt", "text mail"},
+		{"not a template", func() { write(t, filepath.Join(dir, "notes.md"), "notes") }, false, false, "page.html", "saved by rename"},
+		{"removed", func() { os.Remove(filepath.Join(dir, "other.html")) }, true, false, "page.html", "saved by rename"},
+		{"directory removed", func() { os.RemoveAll(dir) }, true, true, "page.html", "saved by rename"},
+		{"directory recreated", func() {
+			os.Mkdir(dir, 0o755)
+			write(t, filepath.Join(dir, "page.html"), "recreated")
+		}, true, false, "page.html", "recreated"},
+		{"modified after the recreation", func() { write(t, filepath.Join(dir, "page.html"), "recreated, then edited") }, true, false, "page.html", "recreated, then edited"},
+	}
+	wantReloads := 0
+	for _, tt := range tests {
+		tt.change()
+		if !tt.reload {
+			select {
+			case err := <-reloads:
+				t.Errorf("%s: reloaded with %v", tt.name, err)
+			case <-time.After(20 * watchInterval):
+			}
+		} else {
+			// A poll in the middle of a write reloads a partial change first.
+			for done := false; !done; {
+				select {
+				case err := <-reloads:
+					wantReloads++
+					done = (err != nil) == tt.wantErr && (tt.wantErr || render(tmpl, tt.template) == tt.want)
+				case <-time.After(10 * time.Second):
+					t.Fatalf("%s: no reload", tt.name)
+				}
+			}
+		}
+		if got := render(tmpl, tt.template); got != tt.want {
+			t.Errorf("%s: %s rendered %q, want %q", tt.name, tt.template, got, tt.want)
+		}
+		stats := tmpl.WatcherStats()
+		if missing := len(stats.MissingSources) > 0; missing != (tt.name == "directory removed") {
+			t.Errorf("%s: missing sources %v", tt.name, stats.MissingSources)
+		}
+		if stats.Reloads != wantReloads {
+			t.Errorf("%s: %d reloads, want %d", tt.name, stats.Reloads, wantReloads)
+		}
+	}
+	stats := tmpl.WatcherStats()
+	if !stats.Running || !stats.Alive || stats.Files != 1 || stats.Restarts != 0 || stats.LastError != "" {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+// TestWatchRestart checks a watcher failing in onChange is restarted and
+// keeps reloading.
+func TestWatchRestart(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "page.html"), "v1")
+	panicked := false
+	tmpl, reloads := watched(t, dir, func(error) {
+		if !panicked {
+			panicked = true
+			panic("broken reload hook")
+		}
+	})
+	write(t, filepath.Join(dir, "page.html"), "version 2")
+	deadline := time.Now().Add(10 * time.Second)
+	for tmpl.WatcherStats().Restarts == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	write(t, filepath.Join(dir, "page.html"), "version three")
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no reload after the restart")
+	}
+	if got := render(tmpl, "page.html"); got != "version three" {
+		t.Errorf("rendered %q", got)
+	}
+	if stats := tmpl.WatcherStats(); !stats.Running || stats.Restarts != 1 || stats.Reloads != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestWatcherStopped(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "page.html"), "v1")
+	tmpl := NewTemplates()
+	tmpl.AddSource(dir)
+	if stats := tmpl.WatcherStats(); stats.Running || stats.Alive {
+		t.Errorf("before Watch: %+v", stats)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tmpl.Watch(ctx, watchInterval, nil)
+	if stats := tmpl.WatcherStats(); stats.Running || stats.Alive || stats.Files != 1 {
+		t.Errorf("after Watch: %+v", stats)
+	}
+}
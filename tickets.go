@@ -0,0 +1,158 @@
+package serverlib
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The defaults of TicketRotationOptions: a ticket resumes a session for
+// (DefaultTicketKeysKept+1) * DefaultTicketRotationInterval at most.
+const (
+	DefaultTicketRotationInterval = time.Hour
+	DefaultTicketKeysKept         = 2
+)
+
+// TicketRotationOptions configures the rotation of the TLS session ticket
+// keys, see ServerConfig.TicketRotation.
+type TicketRotationOptions struct {
+	// Interval is the interval a new key is generated at, encrypting the new
+	// tickets. Defaults to DefaultTicketRotationInterval.
+	Interval time.Duration
+	// Keep is the number of previous keys still decrypting the tickets they
+	// issued, so that the clients resume their sessions across a rotation.
+	// Defaults to DefaultTicketKeysKept; negative keeps none.
+	Keep int
+}
+
+// ticketRotator serves the session ticket keys of the server, rotated
+// in memory. http.Server.ServeTLS serves a clone of the TLS configuration,
+// so the keys are set on the configuration returned by GetConfigForClient.
+type ticketRotator struct {
+	interval time.Duration
+	keep     int
+	keys     [][32]byte
+	// base is the configuration served, without the keys.
+	base *tls.Config
+	// forClient is the GetConfigForClient of base, if any.
+	forClient func(*tls.ClientHelloInfo) (*tls.Config, error)
+	current   atomic.Pointer[tls.Config]
+	started   sync.Once
+	mut       *sync.Mutex
+}
+
+func newTicketRotator(options TicketRotationOptions) *ticketRotator {
+	r := &ticketRotator{interval: options.Interval, keep: options.Keep, mut: &sync.Mutex{}}
+	if r.interval <= 0 {
+		r.interval = DefaultTicketRotationInterval
+	}
+	if r.keep == 0 {
+		r.keep = DefaultTicketKeysKept
+	}
+	r.keep = max(r.keep, 0)
+	return r
+}
+
+// apply sets the TLS configuration of server to the one serving the rotated
+// keys, its current one serving the rest. The keys are kept across the
+// restarts.
+func (r *ticketRotator) apply(server *http.Server) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	base := &tls.Config{}
+	if server.TLSConfig != nil {
+		base = server.TLSConfig.Clone()
+	}
+	if len(base.NextProtos) == 0 {
+		// Set by ServeTLS on its own clone only.
+		base.NextProtos = []string{"h2", "http/1.1"}
+		if server.TLSNextProto != nil {
+			base.NextProtos = []string{"http/1.1"}
+		}
+	}
+	r.forClient, base.GetConfigForClient = base.GetConfigForClient, nil
+	r.base = base
+	if len(r.keys) == 0 {
+		r.keys = [][32]byte{newTicketKey()}
+	}
+	r.publish()
+	served := base.Clone()
+	served.GetConfigForClient = r.configForClient
+	server.TLSConfig = served
+}
+
+// rotate generates a new key, dropping the oldest one beyond keep.
+func (r *ticketRotator) rotate() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.keys = append([][32]byte{newTicketKey()}, r.keys...)
+	r.keys = r.keys[:min(len(r.keys), r.keep+1)]
+	r.publish()
+}
+
+// publish builds the configuration of the handshakes with the current keys.
+// It is called with the lock held.
+func (r *ticketRotator) publish() {
+	config := r.base.Clone()
+	config.SetSessionTicketKeys(r.keys)
+	r.current.Store(config)
+}
+
+func (r *ticketRotator) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if r.forClient != nil {
+		config, err := r.forClient(hello)
+		if err != nil || config != nil {
+			if config != nil {
+				config = config.Clone()
+				r.mut.Lock()
+				config.SetSessionTicketKeys(r.keys)
+				r.mut.Unlock()
+			}
+			return config, err
+		}
+	}
+	return r.current.Load(), nil
+}
+
+// run rotates the keys until ctx is done.
+func (r *ticketRotator) run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.rotate()
+			slog.Debug("TLS session ticket keys rotated")
+		}
+	}
+}
+
+func newTicketKey() [32]byte {
+	var key [32]byte
+	rand.Read(key[:])
+	return key
+}
+
+// useTicketRotation applies ServerConfig.TicketRotation to the TLS
+// configuration of gen, starting the rotation once.
+func (s *Server) useTicketRotation(gen *generation) {
+	if s.tickets == nil {
+		return
+	}
+	for _, l := range gen.listeners {
+		if l.tls {
+			s.tickets.apply(gen.server)
+			s.tickets.started.Do(func() {
+				s.Go("tls-ticket-rotation", s.tickets.run)
+			})
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package serverlib
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewTicketRotator(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      TicketRotationOptions
+		wantInterval time.Duration
+		wantKeep     int
+	}{
+		{"defaults", TicketRotationOptions{}, DefaultTicketRotationInterval, DefaultTicketKeysKept},
+		{"set", TicketRotationOptions{Interval: time.Minute, Keep: 5}, time.Minute, 5},
+		{"none kept", TicketRotationOptions{Keep: -1}, DefaultTicketRotationInterval, 0},
+		{"negative interval", TicketRotationOptions{Interval: -time.Second}, DefaultTicketRotationInterval, DefaultTicketKeysKept},
+	}
+	for _, tt := range tests {
+		r := newTicketRotator(tt.options)
+		if r.interval != tt.wantInterval || r.keep != tt.wantKeep {
+			t.Errorf("%s: interval %s keep %d, want %s %d", tt.name, r.interval, r.keep, tt.wantInterval, tt.wantKeep)
+		}
+	}
+}
+
+// TestTicketRotation resumes the TLS sessions of a running server across
+// the rotations of its ticket keys: a ticket is accepted while its key is
+// kept, and refused once it is dropped.
+func TestTicketRotation(t *testing.T) {
+	ca := newTestCA(t, "CA")
+	certFile, keyFile := ca.writeServerCert(t, t.TempDir(), 1)
+	tests := []struct {
+		name       string
+		keep       int
+		rotations  int
+		wantResume bool
+	}{
+		{"not rotated", 1, 0, true},
+		{"previous key kept", 1, 1, true},
+		{"key dropped", 1, 2, false},
+		{"none kept", -1, 1, false},
+		{"several kept", 3, 3, true},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", TicketRotation: &TicketRotationOptions{Interval: time.Hour, Keep: tt.keep}})
+		s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		done := make(chan error, 1)
+		go func() {
+			done <- s.StartTLS(certFile, keyFile)
+		}()
+		<-s.Ready()
+		client := &http.Client{Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				RootCAs:            ca.pool(),
+				ServerName:         "localhost",
+				ClientSessionCache: tls.NewLRUClientSessionCache(4),
+			},
+		}}
+		get := func() (bool, error) {
+			resp, err := client.Get("https://" + s.Addr() + "/")
+			if err != nil {
+				return false, err
+			}
+			resp.Body.Close()
+			return resp.TLS.DidResume, nil
+		}
+		if resumed, err := get(); err != nil || resumed {
+			t.Errorf("%s: first connection resumed %v, %v", tt.name, resumed, err)
+		}
+		for range tt.rotations {
+			s.tickets.rotate()
+		}
+		if len(s.tickets.keys) > max(tt.keep, 0)+1 {
+			t.Errorf("%s: %d keys kept", tt.name, len(s.tickets.keys))
+		}
+		if resumed, err := get(); err != nil || resumed != tt.wantResume {
+			t.Errorf("%s: resumed %v, %v, want %v", tt.name, resumed, err, tt.wantResume)
+		}
+		s.Stop()
+		waitStart(t, done)
+	}
+}
+
+// TestTicketRotationForClient resumes the sessions of a configuration
+// returned by a GetConfigForClient of ServerConfig.TLSConfig: a new one on
+// every handshake, it resumes the sessions with the rotated keys only.
+func TestTicketRotationForClient(t *testing.T) {
+	ca := newTestCA(t, "CA")
+	certFile, keyFile := ca.writeServerCert(t, t.TempDir(), 1)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTicketRotator(TicketRotationOptions{Keep: -1})
+	server := &http.Server{TLSConfig: &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if hello.ServerName == "tenant.example.com" {
+				return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+			}
+			return nil, nil
+		},
+		Certificates: []tls.Certificate{cert},
+	}}
+	r.apply(server)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server.TLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// The tickets are sent after the handshake, before the data.
+			conn.Write([]byte("x"))
+			conn.Close()
+		}
+	}()
+	dial := func(serverName string, cache tls.ClientSessionCache) bool {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			ClientSessionCache: cache,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1))
+		return conn.ConnectionState().DidResume
+	}
+	tests := []struct {
+		name       string
+		serverName string
+		rotate     bool
+		wantResume bool
+	}{
+		{"tenant", "tenant.example.com", false, true},
+		{"tenant, key dropped", "tenant.example.com", true, false},
+		{"default", "localhost", false, true},
+		{"default, key dropped", "localhost", true, false},
+	}
+	for _, tt := range tests {
+		cache := tls.NewLRUClientSessionCache(4)
+		dial(tt.serverName, cache)
+		if tt.rotate {
+			r.rotate()
+		}
+		if resumed := dial(tt.serverName, cache); resumed != tt.wantResume {
+			t.Errorf("%s: resumed %v, want %v", tt.name, resumed, tt.wantResume)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- r.run(ctx)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Error("run did not return once ctx was done")
+	}
+}
@@ -0,0 +1,113 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrNoCertificateFiles is returned by ReloadTLS when the server was not
+// started with StartTLS and certificate files.
+var ErrNoCertificateFiles = errors.New("no certificate files to reload")
+
+// certReloader serves the certificate of a pair of files, re-read on demand.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// load reads the files. On error, the current certificate is kept.
+func (c *certReloader) load() (bool, error) {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if current := c.cert.Load(); current != nil && bytes.Equal(current.Certificate[0], cert.Certificate[0]) {
+		return false, nil
+	}
+	c.cert.Store(&cert)
+	return true, nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// useCertificateFiles serves the certificate of the files through
+// GetCertificate, so that it can be reloaded, and starts the reloads of
+// ServerConfig.TLSReloadInterval and ServerConfig.TLSReloadOnSIGHUP.
+func (s *Server) useCertificateFiles(certFile, keyFile string) error {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.load(); err != nil {
+		return err
+	}
+	s.useTLS(func(config *tls.Config) *tls.Config {
+		tlsConfig := &tls.Config{}
+		if config != nil {
+			tlsConfig = config.Clone()
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = reloader.getCertificate
+		return tlsConfig
+	})
+	s.certs.Store(reloader)
+	if s.config.TLSReloadInterval <= 0 && !s.config.TLSReloadOnSIGHUP {
+		return nil
+	}
+	return s.Go("tls-reload", func(ctx context.Context) error {
+		var tick <-chan time.Time
+		if s.config.TLSReloadInterval > 0 {
+			ticker := time.NewTicker(s.config.TLSReloadInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		hangup := make(chan os.Signal, 1)
+		if s.config.TLSReloadOnSIGHUP {
+			signal.Notify(hangup, syscall.SIGHUP)
+			defer signal.Stop(hangup)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-tick:
+			case <-hangup:
+			}
+			s.ReloadTLS()
+		}
+	})
+}
+
+// ReloadTLS re-reads the certificate files given to StartTLS, e.g. after a
+// rotation; the new handshakes use the new certificate, the established
+// connections are kept. A certificate that fails to load is logged and the
+// current one is kept. See also ServerConfig.TLSReloadInterval and
+// ServerConfig.TLSReloadOnSIGHUP.
+//
+// Returns:
+//   - error: ErrNoCertificateFiles, or the error of the files.
+func (s *Server) ReloadTLS() error {
+	reloader := s.certs.Load()
+	if reloader == nil {
+		return ErrNoCertificateFiles
+	}
+	changed, err := reloader.load()
+	if err != nil {
+		err = fmt.Errorf("reloading %s: %w", reloader.certFile, err)
+		s.LogError("TLS certificate not reloaded, keeping the current one", err.Error())
+		return err
+	}
+	if changed {
+		slog.Info("TLS certificate reloaded", "file", reloader.certFile)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package serverlib
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// servedSerial returns the serial number of the certificate served on a new
+// connection to s.
+func servedSerial(t *testing.T, s *Server, ca *testCA) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Addr(), &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+// TestReloadTLS rotates the certificate files of a running server: ReloadTLS
+// serves the new certificate to the new connections, and keeps the current
+// one when the files fail to load.
+func TestReloadTLS(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	ca := newTestCA(t, "CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.writeServerCert(t, dir, 1)
+	s := newTestServer()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StartTLS(certFile, keyFile)
+	}()
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	if serial := servedSerial(t, s, ca); serial != 1 {
+		t.Fatalf("serial %d served, want 1", serial)
+	}
+	// Unchanged files are not reported as a reload.
+	if err := s.ReloadTLS(); err != nil || strings.Contains(logs.String(), "TLS certificate reloaded") {
+		t.Errorf("unchanged: %v, logs %s", err, logs.String())
+	}
+	ca.writeServerCert(t, dir, 2)
+	if serial := servedSerial(t, s, ca); serial != 1 {
+		t.Errorf("serial %d served before ReloadTLS, want 1", serial)
+	}
+	if err := s.ReloadTLS(); err != nil || !strings.Contains(logs.String(), "TLS certificate reloaded") {
+		t.Errorf("rotated: %v, logs %s", err, logs.String())
+	}
+	if serial := servedSerial(t, s, ca); serial != 2 {
+		t.Errorf("serial %d served after ReloadTLS, want 2", serial)
+	}
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadTLS(); err == nil || !strings.Contains(err.Error(), certFile) {
+		t.Errorf("invalid files: %v", err)
+	}
+	if serial := servedSerial(t, s, ca); serial != 2 {
+		t.Errorf("serial %d served after a failed reload, want 2", serial)
+	}
+}
+
+// TestReloadTLSWithoutFiles checks ReloadTLS fails on a server not started
+// with certificate files.
+func TestReloadTLSWithoutFiles(t *testing.T) {
+	if err := newTestServer().ReloadTLS(); !errors.Is(err, ErrNoCertificateFiles) {
+		t.Errorf("not started: %v", err)
+	}
+	s := newTestServer()
+	done := startServer(s)
+	<-s.Ready()
+	if err := s.ReloadTLS(); !errors.Is(err, ErrNoCertificateFiles) {
+		t.Errorf("started without TLS: %v", err)
+	}
+	s.Stop()
+	waitStart(t, done)
+}
+
+// TestTLSReloadInterval checks the certificate files are re-read every
+// TLSReloadInterval.
+func TestTLSReloadInterval(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ca := newTestCA(t, "CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.writeServerCert(t, dir, 1)
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", TLSReloadInterval: 10 * time.Millisecond})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StartTLS(certFile, keyFile)
+	}()
+	<-s.Ready()
+	// The key may be read before it is written, failing a reload.
+	ca.writeServerCert(t, dir, 2)
+	var serial int64
+	for deadline := time.Now().Add(10 * time.Second); serial != 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		serial = servedSerial(t, s, ca)
+	}
+	if serial != 2 {
+		t.Errorf("serial %d served after the interval, want 2", serial)
+	}
+	s.Stop()
+	waitStart(t, done)
+}
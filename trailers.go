@@ -0,0 +1,88 @@
+package serverlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/textproto"
+)
+
+// ChecksumTrailerName is the trailer set by ChecksumTrailer.
+const ChecksumTrailerName = "X-Content-SHA256"
+
+// DeclareTrailer announces the trailers of the response in its Trailer
+// header. It must be called before the first write; the values are set with
+// SetTrailer once the body is written. Declared trailers are sent by HTTP/1.1
+// chunked and HTTP/2 responses alike, clients not supporting them ignore them.
+func DeclareTrailer(w http.ResponseWriter, names ...string) {
+	for _, name := range names {
+		w.Header().Add("Trailer", textproto.CanonicalMIMEHeaderKey(name))
+	}
+}
+
+// SetTrailer sets the value of a trailer after the body has been written,
+// whether it was declared with DeclareTrailer or not. A response whose length
+// is known, e.g. with a Content-Length header, can't carry trailers over
+// HTTP/1.1: they are dropped. This is the case of the small responses written
+// at once, whose length net/http sets itself unless trailers are declared or
+// the response is flushed before the end.
+func SetTrailer(w http.ResponseWriter, name, value string) {
+	w.Header().Set(http.TrailerPrefix+textproto.CanonicalMIMEHeaderKey(name), value)
+}
+
+// ChecksumTrailer hashes the body written by the handler and sends its
+// hex-encoded SHA-256 in the X-Content-SHA256 trailer, for clients checking
+// the integrity of streamed downloads. The Content-Length header set by the
+// handler is removed, so that HTTP/1.1 responses are chunked and can carry
+// the trailer. ChecksumTrailer is a Middleware.
+func ChecksumTrailer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		DeclareTrailer(w, ChecksumTrailerName)
+		cw := &checksumWriter{ResponseWriter: w, hash: sha256.New()}
+		next.ServeHTTP(cw, r)
+		SetTrailer(w, ChecksumTrailerName, hex.EncodeToString(cw.hash.Sum(nil)))
+	})
+}
+
+// checksumWriter hashes the body written to its response writer.
+type checksumWriter struct {
+	http.ResponseWriter
+	hash        hash.Hash
+	wroteHeader bool
+}
+
+func (w *checksumWriter) WriteHeader(status int) {
+	// The informational responses, e.g. 103 Early Hints, precede the final one.
+	if !w.wroteHeader && status >= http.StatusOK {
+		w.wroteHeader = true
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// Flush sends the written data to the client, for streamed downloads.
+func (w *checksumWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped response writer, see http.ResponseController.
+func (w *checksumWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
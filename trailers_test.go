@@ -0,0 +1,188 @@
+package serverlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// streamed writes the chunks of a download, flushing each one, after setting
+// a stale Content-Length.
+func streamed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Length", "3")
+	for i := range 3 {
+		fmt.Fprintf(w, "chunk %d\n", i)
+		http.NewResponseController(w).Flush()
+	}
+}
+
+const streamedBody = "chunk 0\nchunk 1\nchunk 2\n"
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// trailerHandlers are the handlers of the trailer tests by path.
+func trailerHandlers() *Server {
+	s := NewServer(ServerConfig{})
+	s.Handle("/checksum", ChecksumTrailer(http.HandlerFunc(streamed)))
+	s.GET("/declared", func(w http.ResponseWriter, r *http.Request) {
+		DeclareTrailer(w, "x-row-count")
+		io.WriteString(w, "rows")
+		SetTrailer(w, "x-row-count", "3")
+	})
+	s.GET("/undeclared", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "rows")
+		// Flushed, the length of the response is not known.
+		http.NewResponseController(w).Flush()
+		SetTrailer(w, "X-Row-Count", "4")
+	})
+	s.Handle("/hints", ChecksumTrailer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Length", "5")
+		io.WriteString(w, "hints")
+	})))
+	s.Handle("/html", ChecksumTrailer(HTMLPostProcess(1<<20, func(document []byte) ([]byte, error) {
+		return []byte(strings.Replace(string(document), "</body>", "<b>!</b></body>", 1)), nil
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html><body>page</body></html>")
+	}))))
+	return s
+}
+
+var trailerTests = []struct {
+	path     string
+	wantBody string
+	trailer  string
+	want     string
+}{
+	{"/checksum", streamedBody, ChecksumTrailerName, sha256Hex(streamedBody)},
+	{"/declared", "rows", "X-Row-Count", "3"},
+	{"/undeclared", "rows", "X-Row-Count", "4"},
+	{"/hints", "hints", ChecksumTrailerName, sha256Hex("hints")},
+	// The checksum covers the transformed document.
+	{"/html", "<html><body>page<b>!</b></body></html>", ChecksumTrailerName, sha256Hex("<html><body>page<b>!</b></body></html>")},
+}
+
+// TestTrailersHTTP1 reads the trailers of chunked HTTP/1.1 responses with a
+// raw client.
+func TestTrailersHTTP1(t *testing.T) {
+	ts := httptest.NewServer(trailerHandlers())
+	defer ts.Close()
+	for _, tt := range trailerTests {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: example.com\r\nTE: trailers\r\nConnection: close\r\n\r\n", tt.path)
+		raw, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := string(raw)
+		if informational, final, ok := strings.Cut(response, "HTTP/1.1 200 OK"); ok && informational != "" {
+			response = final
+		}
+		head, body, _ := strings.Cut(response, "\r\n\r\n")
+		if !strings.Contains(head, "Transfer-Encoding: chunked") || strings.Contains(head, "Content-Length") {
+			t.Errorf("%s: head %q, want a chunked response", tt.path, head)
+		}
+		trailer := "0\r\n" + http.CanonicalHeaderKey(tt.trailer) + ": " + tt.want + "\r\n\r\n"
+		if !strings.HasSuffix(body, trailer) {
+			t.Errorf("%s: body %q, want the trailer %q", tt.path, body, trailer)
+		}
+	}
+}
+
+// TestTrailersDropped checks the undeclared trailers of a small response
+// written at once are dropped over HTTP/1.1, its length known.
+func TestTrailersDropped(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "rows")
+		SetTrailer(w, "X-Row-Count", "4")
+	}))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ContentLength != 4 || len(resp.Trailer) != 0 {
+		t.Errorf("length %d, trailers %v", resp.ContentLength, resp.Trailer)
+	}
+}
+
+// TestTrailersHTTP2 reads the trailers of HTTP/2 responses.
+func TestTrailersHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(trailerHandlers())
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	client := ts.Client()
+	for _, tt := range trailerTests {
+		resp, err := client.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.ProtoMajor != 2 || string(body) != tt.wantBody {
+			t.Errorf("%s: %s %q %v, want %q over HTTP/2", tt.path, resp.Proto, body, err, tt.wantBody)
+		}
+		if got := resp.Trailer.Get(tt.trailer); got != tt.want {
+			t.Errorf("%s: trailer %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestTrailersRecorder reads the trailers through an httptest recorder,
+// and checks HEAD requests get none.
+func TestTrailersRecorder(t *testing.T) {
+	s := trailerHandlers()
+	for _, tt := range trailerTests {
+		if tt.path == "/hints" {
+			// The recorder takes the informational responses for the final one.
+			continue
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		resp := w.Result()
+		if got := resp.Trailer.Get(tt.trailer); got != tt.want || w.Body.String() != tt.wantBody {
+			t.Errorf("%s: %q with the trailer %q, want %q", tt.path, w.Body.String(), got, tt.want)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/checksum", nil))
+	if trailer := w.Result().Trailer; len(trailer) != 0 || w.Header().Get("Trailer") != "" {
+		t.Errorf("HEAD: trailers %v", trailer)
+	}
+}
+
+// TestTrailersNotCached checks the response cache doesn't store the
+// responses declaring trailers.
+func TestTrailersNotCached(t *testing.T) {
+	s := cachedServer(ResponseCacheOptions{})
+	served := 0
+	s.Handle("/download", ChecksumTrailer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		io.WriteString(w, "version "+strconv.Itoa(served))
+	})))
+	for i := range 2 {
+		w := get(s, "/download", nil)
+		if want := "version " + strconv.Itoa(i+1); w.Body.String() != want || w.Result().Trailer.Get(ChecksumTrailerName) != sha256Hex(want) {
+			t.Errorf("request %d: %q %v, want %q", i, w.Body.String(), w.Result().Trailer, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrailingSlashPolicy is how the server answers a path matching no route
+// while the same path with, or without, a trailing slash does, see
+// ServerConfig.TrailingSlash. The paths matching a route as they are are
+// always served as they are.
+type TrailingSlashPolicy int
+
+const (
+	// TrailingSlashDefault keeps the behavior of http.ServeMux: "/foo" is
+	// redirected to "/foo/" when "/foo/" is a subtree pattern, the other
+	// variants are answered with a 404.
+	TrailingSlashDefault TrailingSlashPolicy = iota
+	// RedirectTrailingSlash redirects to the variant with a route, "/foo/"
+	// to "/foo" as "/foo" to "/foo/", with a 308 so that the clients repeat
+	// the method and the body. The query string is kept.
+	RedirectTrailingSlash
+	// StripTrailingSlash serves the variant with a route without
+	// redirecting, e.g. "/foo/" as "/foo": the handler sees the path of its
+	// route, the request, body included, being unchanged otherwise.
+	StripTrailingSlash
+	// StrictTrailingSlash matches the paths as they are: the variants,
+	// "/foo" for a "/foo/" subtree pattern included, are answered with a 404.
+	StrictTrailingSlash
+)
+
+// normalizeSlash applies the trailing slash policy to r, before the mux.
+//
+// Returns:
+//   - *http.Request: The request to route, rewritten by StripTrailingSlash.
+//   - bool: Whether the request was answered, redirected or refused.
+func (i *contextInjector) normalizeSlash(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	matched, redirected := i.lookup(r)
+	if matched && !redirected {
+		return r, false
+	}
+	variant := withSlashToggled(r)
+	if found, redirect := i.lookup(variant); found && !redirect {
+		switch i.trailingSlash {
+		case RedirectTrailingSlash:
+			target := (&url.URL{Path: i.server.Path(variant.URL.Path), RawPath: variant.URL.RawPath, RawQuery: r.URL.RawQuery}).String()
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			return r, true
+		case StripTrailingSlash:
+			return variant, false
+		}
+	}
+	if redirected && i.trailingSlash == StrictTrailingSlash {
+		if i.fallback != nil {
+			i.fallback.ServeHTTP(w, r)
+		} else {
+			i.server.routeMissed(w, r, http.StatusNotFound)
+		}
+		return r, true
+	}
+	return r, false
+}
+
+// lookup reports whether a route matches r, and whether the mux would
+// redirect it to the subtree pattern of its path with a trailing slash.
+func (i *contextInjector) lookup(r *http.Request) (matched, redirected bool) {
+	_, pattern := i.hostMux(r).Handler(r)
+	if pattern == "" {
+		return false, false
+	}
+	return true, redirectsToSubtree(pattern, r.URL.Path)
+}
+
+// redirectsToSubtree reports whether the pattern returned by the mux for
+// path is the one of its redirect to path with a trailing slash. The mux
+// returns the whole pattern of the route redirected to, method, host and
+// wildcards included, e.g. "GET example.com/users/{id}/" for
+// "/users/7": a pattern ending with a slash one segment deeper than a path
+// without one can't match it, hence is a redirect.
+func redirectsToSubtree(pattern, path string) bool {
+	if strings.HasSuffix(path, "/") {
+		return false
+	}
+	if _, rest, ok := strings.Cut(pattern, " "); ok {
+		pattern = strings.TrimLeft(rest, " \t")
+	}
+	if slash := strings.IndexByte(pattern, '/'); slash > 0 {
+		pattern = pattern[slash:]
+	}
+	pattern = strings.TrimSuffix(pattern, "{$}")
+	return strings.HasSuffix(pattern, "/") && strings.Count(pattern, "/") == strings.Count(path, "/")+1
+}
+
+// withSlashToggled returns a shallow copy of r whose path has a trailing
+// slash removed, or added.
+func withSlashToggled(r *http.Request) *http.Request {
+	toggle := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		if trimmed, ok := strings.CutSuffix(path, "/"); ok {
+			return trimmed
+		}
+		return path + "/"
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = toggle(r.URL.Path)
+	r2.URL.RawPath = toggle(r.URL.RawPath)
+	return r2
+}
@@ -0,0 +1,105 @@
+package serverlib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// trailingSlashServer returns a server with subtree routes of each form,
+// method-less, with a method, with a host and with a wildcard, and an exact
+// route, answering with the path they see.
+func trailingSlashServer(policy TrailingSlashPolicy) *Server {
+	s := NewServer(ServerConfig{TrailingSlash: policy})
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}
+	s.HandleFunc("/plain/", echo)
+	s.GET("/get/", echo)
+	s.HandleFunc("example.com/host/", echo)
+	s.Host("api.example.com").GET("/router/", echo)
+	s.GET("/users/{id}/", echo)
+	s.GET("/exact", echo)
+	return s
+}
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   TrailingSlashPolicy
+		target   string
+		wantCode int
+		// wantBody is the path served, or the Location of a redirect.
+		wantBody string
+	}{
+		{"default, method-less", TrailingSlashDefault, "/plain", http.StatusTemporaryRedirect, "/plain/"},
+		{"default, GET", TrailingSlashDefault, "/get", http.StatusTemporaryRedirect, "/get/"},
+		{"default, exact", TrailingSlashDefault, "/exact/", http.StatusNotFound, ""},
+
+		{"redirect, method-less", RedirectTrailingSlash, "/plain", http.StatusPermanentRedirect, "/plain/"},
+		{"redirect, GET", RedirectTrailingSlash, "/get?q=1", http.StatusPermanentRedirect, "/get/?q=1"},
+		{"redirect, host pattern", RedirectTrailingSlash, "http://example.com/host", http.StatusPermanentRedirect, "/host/"},
+		{"redirect, host router", RedirectTrailingSlash, "http://api.example.com/router", http.StatusPermanentRedirect, "/router/"},
+		{"redirect, wildcard", RedirectTrailingSlash, "/users/7", http.StatusPermanentRedirect, "/users/7/"},
+		{"redirect, exact", RedirectTrailingSlash, "/exact/", http.StatusPermanentRedirect, "/exact"},
+		{"redirect, served as is", RedirectTrailingSlash, "/get/", http.StatusOK, "/get/"},
+		{"redirect, subtree", RedirectTrailingSlash, "/get/deeper", http.StatusOK, "/get/deeper"},
+		{"redirect, other host", RedirectTrailingSlash, "http://other.example.com/host", http.StatusNotFound, ""},
+
+		{"strip, method-less", StripTrailingSlash, "/plain", http.StatusOK, "/plain/"},
+		{"strip, GET", StripTrailingSlash, "/get", http.StatusOK, "/get/"},
+		{"strip, host pattern", StripTrailingSlash, "http://example.com/host", http.StatusOK, "/host/"},
+		{"strip, host router", StripTrailingSlash, "http://api.example.com/router", http.StatusOK, "/router/"},
+		{"strip, exact", StripTrailingSlash, "/exact/", http.StatusOK, "/exact"},
+
+		{"strict, method-less", StrictTrailingSlash, "/plain", http.StatusNotFound, ""},
+		{"strict, GET", StrictTrailingSlash, "/get", http.StatusNotFound, ""},
+		{"strict, host pattern", StrictTrailingSlash, "http://example.com/host", http.StatusNotFound, ""},
+		{"strict, host router", StrictTrailingSlash, "http://api.example.com/router", http.StatusNotFound, ""},
+		{"strict, wildcard", StrictTrailingSlash, "/users/7", http.StatusNotFound, ""},
+		{"strict, exact", StrictTrailingSlash, "/exact/", http.StatusNotFound, ""},
+		{"strict, served as is", StrictTrailingSlash, "/users/7/", http.StatusOK, "/users/7/"},
+	}
+	for _, tt := range tests {
+		s := trailingSlashServer(tt.policy)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.wantCode)
+			continue
+		}
+		got := w.Body.String()
+		if w.Code/100 == 3 {
+			got = w.Header().Get("Location")
+		}
+		if tt.wantBody != "" && got != tt.wantBody {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.wantBody)
+		}
+	}
+}
+
+func TestRedirectsToSubtree(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/bar/", "/bar", true},
+		{"GET /bar/", "/bar", true},
+		{"GET  /bar/", "/bar", true},
+		{"example.com/bar/", "/bar", true},
+		{"GET example.com/bar/", "/bar", true},
+		{"GET /users/{id}/", "/users/7", true},
+		{"GET /bar/{$}", "/bar", true},
+		{"/bar/", "/bar/x", false},
+		{"/bar/", "/bar/", false},
+		{"GET /bar", "/bar", false},
+		{"/", "/bar", false},
+		{"GET /files/{path...}", "/files/a", false},
+	}
+	for _, tt := range tests {
+		if got := redirectsToSubtree(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("%q %q: %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package serverlib
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+)
+
+// TypedOption configures a handler registered with HandleTyped.
+type TypedOption func(*typedOptions)
+
+type typedOptions struct {
+	status      int
+	noContent   bool
+	middlewares []Middleware
+}
+
+// WithSuccessStatus sets the status code of successful responses, e.g. 201 for creations.
+// Defaults to 200.
+func WithSuccessStatus(status int) TypedOption {
+	return func(o *typedOptions) {
+		o.status = status
+	}
+}
+
+// NoContentOnEmpty answers with a 204 and no body when the handler returns the
+// zero value of its response type (a nil pointer, slice or map included).
+func NoContentOnEmpty() TypedOption {
+	return func(o *typedOptions) {
+		o.noContent = true
+	}
+}
+
+// WithMiddleware wraps the typed handler with the given middlewares, the first
+// one outermost. The security requirements they declare are recorded in the
+// route registry (see Server.Routes).
+func WithMiddleware(middlewares ...Middleware) TypedOption {
+	return func(o *typedOptions) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+// HandleTyped registers a typed JSON handler with the given pattern.
+// The request value is bound with Bind (JSON body, then query parameters,
+// then path parameters) and checked with Validate before fn is called with the
+// request context, which gives access to the session. The response is written
+// with JSON; errors, binding and validation failures included, are answered
+// by the central error handler (see Server.Error).
+//
+// Parameters:
+//   - s: The server to register the handler on.
+//   - pattern: The pattern of the route, e.g. "POST /users/{id}".
+//   - fn: The handler function.
+//   - opts: Optional typed handler options.
+func HandleTyped[Req, Resp any](s *Server, pattern string, fn func(context.Context, Req) (Resp, error), opts ...TypedOption) {
+	options := typedOptions{status: http.StatusOK}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	slog.Info("Registred typed handler", "pattern", pattern)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := Bind(r, &req); err != nil {
+			s.Error(w, r, err)
+			return
+		}
+		if err := Validate(&req); err != nil {
+			s.Error(w, r, err)
+			return
+		}
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			s.Error(w, r, err)
+			return
+		}
+		if options.noContent && isEmpty(resp) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var body any = resp
+		if streamer, ok := body.(Streamer); ok {
+			if !isEmpty(resp) {
+				streamTyped(s, w, r, options.status, streamer)
+				return
+			}
+			// A nil streamer function can't be encoded, answered as null.
+			body = nil
+		}
+		if err := JSON(w, options.status, body); err != nil {
+			s.LogError("Writing typed response", err.Error())
+		}
+	})
+	wrapped := wrapMiddlewares(handler, options.middlewares)
+	s.router.Handle(pattern, wrapped)
+	s.addHandlerRoute(pattern, wrapped, fn, options.middlewares)
+}
+
+// streamTyped streams the response of a typed handler. An error before the
+// first element, or a streamer writing nothing, is answered by the central
+// error handler; past it, or when the document is left incomplete, the
+// connection is aborted.
+func streamTyped(s *Server, w http.ResponseWriter, r *http.Request, status int, streamer Streamer) {
+	stream := JSONStream(w, status).WithContext(r.Context())
+	err := streamer.Stream(stream)
+	if err == nil && !stream.complete() {
+		err = fmt.Errorf("%w: incomplete document", ErrJSONStreamState)
+	}
+	if err != nil && !stream.started() {
+		s.Error(w, r, err)
+		return
+	}
+	if err != nil {
+		if r.Context().Err() == nil {
+			serverOf(r).LogError("Streaming typed response", err.Error())
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// isEmpty reports whether v is the zero value of its type.
+func isEmpty(v any) bool {
+	rv := reflect.ValueOf(v)
+	return !rv.IsValid() || rv.IsZero()
+}
@@ -0,0 +1,152 @@
+package serverlib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Morditux/serverlib/reqctx"
+)
+
+type typedRequest struct {
+	ID    string `json:"id" query:"id" path:"id"`
+	Page  int    `json:"page" query:"page,default=1"`
+	Name  string `json:"name" validate:"required"`
+	Query string `json:"-" query:"q"`
+}
+
+type typedResponse struct {
+	ID    string `json:"id"`
+	Page  int    `json:"page"`
+	Name  string `json:"name"`
+	Query string `json:"query,omitempty"`
+}
+
+func TestHandleTypedBinding(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	echoTyped := func(_ context.Context, req typedRequest) (typedResponse, error) {
+		return typedResponse{ID: req.ID, Page: req.Page, Name: req.Name, Query: req.Query}, nil
+	}
+	HandleTyped(s, "POST /items/{id}", echoTyped)
+	HandleTyped(s, "POST /items", echoTyped)
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+		want       typedResponse
+	}{
+		{"body", "/items", `{"id":"body","name":"a"}`, http.StatusOK, typedResponse{ID: "body", Page: 1, Name: "a"}},
+		{"query over body", "/items?id=query", `{"id":"body","name":"a"}`, http.StatusOK, typedResponse{ID: "query", Page: 1, Name: "a"}},
+		{"path over query and body", "/items/path?id=query", `{"id":"body","name":"a"}`, http.StatusOK, typedResponse{ID: "path", Page: 1, Name: "a"}},
+		{"default", "/items", `{"name":"a"}`, http.StatusOK, typedResponse{Page: 1, Name: "a"}},
+		{"body over default", "/items", `{"page":3,"name":"a"}`, http.StatusOK, typedResponse{Page: 3, Name: "a"}},
+		{"query over body and default", "/items?page=5", `{"page":3,"name":"a"}`, http.StatusOK, typedResponse{Page: 5, Name: "a"}},
+		{"query only field", "/items?q=term", `{"name":"a"}`, http.StatusOK, typedResponse{Page: 1, Name: "a", Query: "term"}},
+		{"invalid query", "/items?page=two", `{"name":"a"}`, http.StatusBadRequest, typedResponse{}},
+		{"invalid body", "/items", `{"name":`, http.StatusBadRequest, typedResponse{}},
+		{"unknown body field", "/items", `{"name":"a","extra":1}`, http.StatusBadRequest, typedResponse{}},
+		{"validation", "/items/path", `{}`, http.StatusBadRequest, typedResponse{}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body.String())
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		var got typedResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got != tt.want {
+			t.Errorf("%s: %+v (%v), want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestHandleTypedOptions(t *testing.T) {
+	type created struct {
+		ID string `json:"id"`
+	}
+	tests := []struct {
+		name       string
+		fn         func(context.Context, struct{}) (*created, error)
+		opts       []TypedOption
+		wantStatus int
+		wantBody   string
+	}{
+		{"default status", func(context.Context, struct{}) (*created, error) {
+			return &created{ID: "1"}, nil
+		}, nil, http.StatusOK, `{"id":"1"}`},
+		{"WithSuccessStatus", func(context.Context, struct{}) (*created, error) {
+			return &created{ID: "1"}, nil
+		}, []TypedOption{WithSuccessStatus(http.StatusCreated)}, http.StatusCreated, `{"id":"1"}`},
+		{"empty response", func(context.Context, struct{}) (*created, error) {
+			return nil, nil
+		}, nil, http.StatusOK, `null`},
+		{"NoContentOnEmpty", func(context.Context, struct{}) (*created, error) {
+			return nil, nil
+		}, []TypedOption{NoContentOnEmpty(), WithSuccessStatus(http.StatusCreated)}, http.StatusNoContent, ``},
+		{"NoContentOnEmpty, not empty", func(context.Context, struct{}) (*created, error) {
+			return &created{ID: "1"}, nil
+		}, []TypedOption{NoContentOnEmpty()}, http.StatusOK, `{"id":"1"}`},
+		{"HTTPError", func(context.Context, struct{}) (*created, error) {
+			return nil, NewHTTPError(http.StatusConflict, "already exists")
+		}, []TypedOption{WithSuccessStatus(http.StatusCreated)}, http.StatusConflict, ``},
+		{"error", func(context.Context, struct{}) (*created, error) {
+			return &created{ID: "1"}, errors.New("database down")
+		}, nil, http.StatusInternalServerError, ``},
+		{"WithMiddleware", func(context.Context, struct{}) (*created, error) {
+			return &created{ID: "1"}, nil
+		}, []TypedOption{WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "denied", http.StatusForbidden)
+			})
+		})}, http.StatusForbidden, "denied\n"},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{})
+		HandleTyped(s, "POST /items", tt.fn, tt.opts...)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/items", nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if tt.wantBody != "" || w.Code == http.StatusNoContent {
+			if got := strings.TrimSpace(w.Body.String()); got != strings.TrimSpace(tt.wantBody) {
+				t.Errorf("%s: body %q, want %q", tt.name, got, tt.wantBody)
+			}
+		}
+		if strings.Contains(w.Body.String(), "database down") {
+			t.Errorf("%s: internal error leaked: %q", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestHandleTypedSession(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	HandleTyped(s, "GET /me", func(ctx context.Context, _ struct{}) (string, error) {
+		session := reqctx.Session(ctx)
+		if session == nil {
+			return "", errors.New("no session")
+		}
+		return session.Id(), nil
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+	var id string
+	if err := json.Unmarshal(w.Body.Bytes(), &id); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != id {
+		t.Errorf("session %q of the context, cookies %v", id, cookies)
+	}
+}
@@ -0,0 +1,262 @@
+// Package units parses and formats the sizes and durations of the
+// configuration in human-friendly forms, such as "64MB" or "1d12h".
+package units
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSyntax is returned, wrapped, for the sizes and durations that can't be parsed.
+var ErrSyntax = errors.New("units: invalid syntax")
+
+// ErrRange is returned, wrapped, for the sizes and durations out of range.
+var ErrRange = errors.New("units: value out of range")
+
+// Binary multiples.
+const (
+	KiB int64 = 1 << (10 * (iota + 1))
+	MiB
+	GiB
+	TiB
+)
+
+// byteUnits are the units of ParseBytes, lower-cased.
+var byteUnits = map[string]int64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": KiB, "mib": MiB, "gib": GiB, "tib": TiB,
+	"k": KiB, "m": MiB, "g": GiB, "t": TiB,
+}
+
+// ParseBytes parses a size: a number of bytes, e.g. "512", or a decimal
+// number followed by a unit, e.g. "64MB", "1.5 GiB" or "512k". The units are
+// case-insensitive: B; KB, MB, GB and TB, multiples of 1000; KiB, MiB, GiB and
+// TiB, multiples of 1024; and the shorthands K, M, G and T, multiples of 1024
+// like in the nginx and JVM settings.
+//
+// Parameters:
+//   - s: The size.
+//
+// Returns:
+//   - int64: The size in bytes, rounded down.
+//   - error: ErrSyntax or ErrRange, wrapped.
+func ParseBytes(s string) (int64, error) {
+	text := strings.TrimSpace(s)
+	end := 0
+	for end < len(text) && (text[end] >= '0' && text[end] <= '9' || text[end] == '.') {
+		end++
+	}
+	number, unit := text[:end], strings.ToLower(strings.TrimSpace(text[end:]))
+	multiple, ok := byteUnits[unit]
+	if number == "" || !ok {
+		return 0, fmt.Errorf("%w: size %q", ErrSyntax, s)
+	}
+	if !strings.Contains(number, ".") {
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || n > math.MaxInt64/multiple {
+			return 0, fmt.Errorf("%w: size %q", ErrRange, s)
+		}
+		return n * multiple, nil
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: size %q", ErrSyntax, s)
+	}
+	size := f * float64(multiple)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("%w: size %q", ErrRange, s)
+	}
+	return int64(size), nil
+}
+
+// FormatBytes formats a size with the largest binary unit it has at least one
+// of, with one decimal at most, e.g. "512 B", "1.5 KiB" or "64 MiB". The
+// result is parsed back by ParseBytes, rounded.
+func FormatBytes(n int64) string {
+	sign, size := "", uint64(n)
+	if n < 0 {
+		// -n overflows for math.MinInt64, not its unsigned form.
+		sign, size = "-", -size
+	}
+	units := []struct {
+		name     string
+		multiple int64
+	}{{"TiB", TiB}, {"GiB", GiB}, {"MiB", MiB}, {"KiB", KiB}}
+	for _, unit := range units {
+		if size >= uint64(unit.multiple) {
+			value := strconv.FormatFloat(float64(size)/float64(unit.multiple), 'f', 1, 64)
+			return sign + strings.TrimSuffix(value, ".0") + " " + unit.name
+		}
+	}
+	return sign + strconv.FormatUint(size, 10) + " B"
+}
+
+// durationUnits are the units ParseDuration adds to time.ParseDuration.
+var durationUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// stdDurationUnits are the units of time.ParseDuration.
+var stdDurationUnits = map[string]bool{
+	"ns": true, "us": true, "µs": true, "μs": true, "ms": true, "s": true, "m": true, "h": true,
+}
+
+// validNumber reports whether number is a decimal number of ParseDuration.
+func validNumber(number string) bool {
+	_, err := strconv.ParseFloat(number, 64)
+	return err == nil
+}
+
+// ParseDuration parses a duration like time.ParseDuration, e.g. "2h30m" or
+// "1.5s", with the days and the weeks in addition: "1d", "1d12h", "2w". A day
+// is 24 hours. "m" stays the minute. The months and the years, of variable
+// lengths, are rejected.
+//
+// Parameters:
+//   - s: The duration.
+//
+// Returns:
+//   - time.Duration: The duration.
+//   - error: ErrSyntax or ErrRange, wrapped.
+func ParseDuration(s string) (time.Duration, error) {
+	text := strings.TrimSpace(s)
+	sign := time.Duration(1)
+	rest := text
+	if strings.HasPrefix(rest, "-") || strings.HasPrefix(rest, "+") {
+		if rest[0] == '-' {
+			sign = -1
+		}
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return 0, fmt.Errorf("%w: duration %q", ErrSyntax, s)
+	}
+	var total time.Duration
+	for rest != "" {
+		end := 0
+		for end < len(rest) && (rest[end] >= '0' && rest[end] <= '9' || rest[end] == '.') {
+			end++
+		}
+		unitEnd := end
+		for unitEnd < len(rest) && !(rest[unitEnd] >= '0' && rest[unitEnd] <= '9' || rest[unitEnd] == '.') {
+			unitEnd++
+		}
+		number, unit := rest[:end], rest[end:unitEnd]
+		rest = rest[unitEnd:]
+		if number == "" {
+			return 0, fmt.Errorf("%w: duration %q", ErrSyntax, s)
+		}
+		var part time.Duration
+		if multiple, ok := durationUnits[unit]; ok {
+			f, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("%w: duration %q", ErrSyntax, s)
+			}
+			if f*float64(multiple) >= math.MaxInt64 {
+				return 0, fmt.Errorf("%w: duration %q", ErrRange, s)
+			}
+			part = time.Duration(f * float64(multiple))
+		} else {
+			if unit == "" && number != "0" {
+				// time.ParseDuration rejects a number without a unit, but "0".
+				return 0, fmt.Errorf("%w: duration %q has no unit", ErrSyntax, s)
+			}
+			d, err := time.ParseDuration(number + unit)
+			if err != nil {
+				// time.ParseDuration doesn't tell the overflows apart.
+				if _, known := stdDurationUnits[unit]; known && validNumber(number) {
+					return 0, fmt.Errorf("%w: duration %q", ErrRange, s)
+				}
+				return 0, fmt.Errorf("%w: duration %q", ErrSyntax, s)
+			}
+			part = d
+		}
+		if total > math.MaxInt64-part {
+			return 0, fmt.Errorf("%w: duration %q", ErrRange, s)
+		}
+		total += part
+	}
+	return sign * total, nil
+}
+
+// Size is a size in bytes read from a configuration file or the environment:
+// it is unmarshaled from a number of bytes or from a string parsed by
+// ParseBytes, e.g. "64MB", and marshaled as a number.
+type Size int64
+
+// String formats the size with FormatBytes.
+func (s Size) String() string {
+	return FormatBytes(int64(s))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, for the loaders of
+// strings such as the environment variables.
+func (s *Size) UnmarshalText(text []byte) error {
+	n, err := ParseBytes(string(text))
+	if err != nil {
+		return err
+	}
+	*s = Size(n)
+	return nil
+}
+
+// UnmarshalJSON accepts a number of bytes or a string.
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("%w: size %s", ErrSyntax, data)
+	}
+	return s.UnmarshalText([]byte(text))
+}
+
+// Duration is a duration read from a configuration file or the environment:
+// it is unmarshaled from a number of nanoseconds, like a time.Duration, or
+// from a string parsed by ParseDuration, e.g. "1d12h", and marshaled as a
+// string.
+type Duration time.Duration
+
+// String formats the duration like time.Duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// UnmarshalJSON accepts a number of nanoseconds or a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*d = Duration(n)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("%w: duration %s", ErrSyntax, data)
+	}
+	return d.UnmarshalText([]byte(text))
+}
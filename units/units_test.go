@@ -0,0 +1,196 @@
+package units
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr error
+	}{
+		{"0", 0, nil},
+		{"512", 512, nil},
+		{"512B", 512, nil},
+		{" 512 b ", 512, nil},
+		{"1KB", 1000, nil},
+		{"64MB", 64e6, nil},
+		{"2GB", 2e9, nil},
+		{"1TB", 1e12, nil},
+		{"1KiB", 1024, nil},
+		{"64MiB", 64 << 20, nil},
+		{"1.5 GiB", 3 << 29, nil},
+		{"1TiB", 1 << 40, nil},
+		{"512k", 512 << 10, nil},
+		{"512K", 512 << 10, nil},
+		// "m" is the megabyte of nginx and the JVM for a size, see
+		// TestParseDuration for the minute.
+		{"1m", 1 << 20, nil},
+		{"1g", 1 << 30, nil},
+		{"1t", 1 << 40, nil},
+		{"64mb", 64e6, nil},
+		{"0.5KiB", 512, nil},
+		{"1.0009KB", 1000, nil},
+		{"9223372036854775807", math.MaxInt64, nil},
+
+		{"", 0, ErrSyntax},
+		{"   ", 0, ErrSyntax},
+		{"MB", 0, ErrSyntax},
+		{"-1", 0, ErrSyntax},
+		{"+1", 0, ErrSyntax},
+		{"1 PB", 0, ErrSyntax},
+		{"1 megabyte", 0, ErrSyntax},
+		{"1KB2", 0, ErrSyntax},
+		{"1.2.3MB", 0, ErrSyntax},
+		{".", 0, ErrSyntax},
+		{"1e3", 0, ErrSyntax},
+		{"0x10", 0, ErrSyntax},
+		{"9223372036854775808", 0, ErrRange},
+		{"8388608TiB", 0, ErrRange},
+		{"8388608.5TiB", 0, ErrRange},
+		{"99999999999999999999999KB", 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%q: %d %v, want %d %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1 KiB"},
+		{1536, "1.5 KiB"},
+		{64 << 20, "64 MiB"},
+		{3 << 29, "1.5 GiB"},
+		{5 << 40, "5 TiB"},
+		{-2048, "-2 KiB"},
+		{math.MaxInt64, "8388608 TiB"},
+		{math.MinInt64, "-8388608 TiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.in); got != tt.want {
+			t.Errorf("%d: %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	// The sizes formatted are parsed back, rounded.
+	for _, n := range []int64{0, 1023, 1536, 64 << 20, 3 << 29} {
+		if back, err := ParseBytes(FormatBytes(n)); err != nil || back != n {
+			t.Errorf("%d: parsed back %d %v", n, back, err)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr error
+	}{
+		{"0", 0, nil},
+		{"1.5s", 1500 * time.Millisecond, nil},
+		{"2h30m", 2*time.Hour + 30*time.Minute, nil},
+		{"300ms", 300 * time.Millisecond, nil},
+		{"1us", time.Microsecond, nil},
+		{"1µs", time.Microsecond, nil},
+		{"1ns", 1, nil},
+		// "m" is the minute for a duration, not a month nor a megabyte.
+		{"1m", time.Minute, nil},
+		{"1d", day, nil},
+		{"1.5d", 36 * time.Hour, nil},
+		{"1d12h", 36 * time.Hour, nil},
+		{"2w", 14 * day, nil},
+		{"1w2d3h4m5s", 9*day + 3*time.Hour + 4*time.Minute + 5*time.Second, nil},
+		{"-1d", -day, nil},
+		{"+1h", time.Hour, nil},
+		{" 1h ", time.Hour, nil},
+		{"2562047h", 2562047 * time.Hour, nil},
+
+		{"", 0, ErrSyntax},
+		{"-", 0, ErrSyntax},
+		{"10", 0, ErrSyntax},
+		{"1h10", 0, ErrSyntax},
+		{"d", 0, ErrSyntax},
+		{"1y", 0, ErrSyntax},
+		{"1mo", 0, ErrSyntax},
+		{"1M", 0, ErrSyntax},
+		{"1 h", 0, ErrSyntax},
+		{"1D", 0, ErrSyntax},
+		{"1dd", 0, ErrSyntax},
+		{"1..5d", 0, ErrSyntax},
+		{"--1s", 0, ErrSyntax},
+		{"1h-1m", 0, ErrSyntax},
+		{"3000000h", 0, ErrRange},
+		{"9223372036854775808ns", 0, ErrRange},
+		{"106752d", 0, ErrRange},
+		{"106751d106751d", 0, ErrRange},
+		{"15251w", 0, ErrRange},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%q: %v %v, want %v %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSizeJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Size
+		wantErr bool
+	}{
+		{`1024`, 1024, false},
+		{`"64MB"`, 64e6, false},
+		{`"1m"`, 1 << 20, false},
+		{`"64 furlongs"`, 0, true},
+		{`1.5`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		var got struct{ Size Size }
+		err := json.Unmarshal([]byte(`{"Size":`+tt.in+`}`), &got)
+		if got.Size != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: %d %v", tt.in, got.Size, err)
+		}
+	}
+	if data, _ := json.Marshal(Size(2048)); string(data) != "2048" {
+		t.Errorf("marshaled %s", data)
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Duration
+		wantErr bool
+	}{
+		{`1000000000`, Duration(time.Second), false},
+		{`"1d12h"`, Duration(36 * time.Hour), false},
+		{`"1m"`, Duration(time.Minute), false},
+		{`"1y"`, 0, true},
+		{`[]`, 0, true},
+	}
+	for _, tt := range tests {
+		var got struct{ TTL Duration }
+		err := json.Unmarshal([]byte(`{"TTL":`+tt.in+`}`), &got)
+		if got.TTL != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v %v", tt.in, got.TTL, err)
+		}
+	}
+	if data, _ := json.Marshal(Duration(90 * time.Minute)); string(data) != `"1h30m0s"` {
+		t.Errorf("marshaled %s", data)
+	}
+}
@@ -0,0 +1,60 @@
+package serverlib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// UnixAddressPrefix prefixes the ServerConfig.Address of a unix socket, e.g.
+// "unix:/run/myapp/http.sock" behind a reverse proxy on the same host.
+const UnixAddressPrefix = "unix:"
+
+// DefaultSocketMode is the file mode of the unix socket when
+// ServerConfig.SocketMode is not set: the owner and its group, e.g. the
+// reverse proxy, can connect.
+const DefaultSocketMode os.FileMode = 0o660
+
+// listenUnix listens on the unix socket path. A stale socket left by a
+// crashed server is removed first; a socket still in use is an error. The
+// socket file is removed when the listener is closed, by Stop or Shutdown.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes the socket file path when no server answers on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	slog.Info("Removing stale socket", "path", path)
+	return os.Remove(path)
+}
@@ -0,0 +1,157 @@
+package serverlib
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// staleSocket leaves a socket file nobody listens on at path, as a crashed
+// server does.
+func staleSocket(t *testing.T, path string) {
+	t.Helper()
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+}
+
+func TestListenUnix(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the path of the socket; it returns a cleanup func.
+		setup    func(t *testing.T, path string) func()
+		mode     os.FileMode
+		wantErr  bool
+		wantMode os.FileMode
+	}{
+		{"new", func(*testing.T, string) func() { return func() {} }, 0o600, false, 0o600},
+		{"default mode", func(*testing.T, string) func() { return func() {} }, 0, false, DefaultSocketMode},
+		{"stale socket", func(t *testing.T, path string) func() {
+			staleSocket(t, path)
+			return func() {}
+		}, 0o666, false, 0o666},
+		{"socket in use", func(t *testing.T, path string) func() {
+			l, err := net.Listen("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return func() { l.Close() }
+		}, 0o600, true, 0},
+		{"regular file", func(t *testing.T, path string) func() {
+			if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return func() {}
+		}, 0o600, true, 0},
+		{"directory", func(t *testing.T, path string) func() {
+			if err := os.Mkdir(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			return func() {}
+		}, 0o600, true, 0},
+		{"missing directory", func(t *testing.T, path string) func() {
+			os.Remove(filepath.Dir(path))
+			return func() {}
+		}, 0o600, true, 0},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "http.sock")
+		cleanup := tt.setup(t, path)
+		before, _ := os.Lstat(path)
+		l, err := listenUnix(path, tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			// The file refused, or the socket in use, is left as it was.
+			if after, _ := os.Lstat(path); before != nil && (after == nil || after.Mode() != before.Mode()) {
+				t.Errorf("%s: %v changed to %v", tt.name, before, after)
+			}
+			cleanup()
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != tt.wantMode {
+			t.Errorf("%s: socket file %v %v, want mode %v", tt.name, info, err, tt.wantMode)
+		}
+		// Closing the listener removes the socket file.
+		l.Close()
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: socket file left after Close: %v", tt.name, err)
+		}
+		cleanup()
+	}
+}
+
+// TestRemoveStaleSocket checks a socket still answering is kept.
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.sock")
+	l, err := net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stale := filepath.Join(dir, "stale.sock")
+	staleSocket(t, stale)
+	tests := []struct {
+		name     string
+		path     string
+		wantErr  bool
+		wantKept bool
+	}{
+		{"live", live, true, true},
+		{"stale", stale, false, false},
+		{"missing", filepath.Join(dir, "missing.sock"), false, false},
+	}
+	for _, tt := range tests {
+		err := removeStaleSocket(tt.path)
+		_, statErr := os.Lstat(tt.path)
+		if (err != nil) != tt.wantErr || (statErr == nil) != tt.wantKept {
+			t.Errorf("%s: %v, kept %v, want error %v kept %v", tt.name, err, statErr == nil, tt.wantErr, tt.wantKept)
+		}
+	}
+}
+
+// TestUnixServer serves a request on a unix socket left stale by a previous
+// server, and checks Stop removes the socket.
+func TestUnixServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+	staleSocket(t, path)
+	s := NewServer(ServerConfig{Address: UnixAddressPrefix + path, SocketMode: 0o600})
+	s.GET("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("unix"))
+	})
+	done := startServer(s)
+	<-s.Ready()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "unix" {
+		t.Errorf("body %q", body)
+	}
+	client.CloseIdleConnections()
+	if info, err := os.Lstat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket file %v %v", info, err)
+	}
+	s.Stop()
+	waitStart(t, done)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file left after Stop: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package serverlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUpgradeTimeout is how long Upgrade waits for the new process to be
+// ready when ServerConfig.UpgradeTimeout is not set.
+const DefaultUpgradeTimeout = 30 * time.Second
+
+// The environment variables passing the listeners to the new process of
+// Upgrade: the configured addresses of the listeners, as a JSON array, whose
+// file descriptors follow from 3, and the file descriptor of the pipe the new
+// process signals its readiness on.
+const (
+	inheritFdsEnv = "SERVERLIB_INHERIT_FDS"
+	readyFdEnv    = "SERVERLIB_READY_FD"
+)
+
+// inherited holds the listeners passed by the parent process of Upgrade, by
+// configured address.
+var inherited = struct {
+	once      sync.Once
+	listeners map[string]net.Listener
+	readyFd   int
+	mut       sync.Mutex
+}{}
+
+// loadInherited adopts the listeners passed by the parent, once. The
+// variables are unset, so that the child processes don't take the sockets for
+// theirs.
+func loadInherited() {
+	inherited.once.Do(func() {
+		inherited.listeners = map[string]net.Listener{}
+		value := os.Getenv(inheritFdsEnv)
+		readyFd, _ := strconv.Atoi(os.Getenv(readyFdEnv))
+		os.Unsetenv(inheritFdsEnv)
+		os.Unsetenv(readyFdEnv)
+		inherited.readyFd = readyFd
+		if value == "" {
+			return
+		}
+		var addresses []string
+		if err := json.Unmarshal([]byte(value), &addresses); err != nil {
+			slog.Error("Inherited listeners ignored", "error", err)
+			return
+		}
+		for i, address := range addresses {
+			file := os.NewFile(uintptr(listenFdsStart+i), address)
+			l, err := net.FileListener(file)
+			file.Close()
+			if err != nil {
+				slog.Error("Inherited listener ignored", "address", address, "error", err)
+				continue
+			}
+			inherited.listeners[address] = l
+		}
+	})
+}
+
+// takeInherited returns the listener of address passed by the parent, or nil.
+func takeInherited(address string) net.Listener {
+	loadInherited()
+	inherited.mut.Lock()
+	defer inherited.mut.Unlock()
+	l := inherited.listeners[address]
+	delete(inherited.listeners, address)
+	return l
+}
+
+// ListenInherited returns the listener of address passed by the parent
+// process of Upgrade, or else binds it, a TCP address or a unix socket
+// prefixed with UnixAddressPrefix. The servers call it themselves; it serves
+// the other listeners of the process, e.g. of a gRPC server, across the
+// upgrades. Call UpgradeReady once they are served. Its errors are
+// BindError.
+func ListenInherited(address string) (net.Listener, error) {
+	if l := takeInherited(address); l != nil {
+		return l, nil
+	}
+	var l net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(address, UnixAddressPrefix); ok {
+		l, err = listenUnix(path, DefaultSocketMode)
+	} else {
+		l, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, newBindError(address, err)
+	}
+	return l, nil
+}
+
+// UpgradeReady tells the parent process of Upgrade that the new process
+// serves, so that it stops. The servers call it once ready, see
+// Server.Ready; the listeners passed by the parent and not taken meanwhile
+// are closed. It does nothing in a process not started by Upgrade, and after
+// the first call.
+func UpgradeReady() {
+	loadInherited()
+	inherited.mut.Lock()
+	defer inherited.mut.Unlock()
+	for address, l := range inherited.listeners {
+		slog.Info("Inherited listener unused, closed", "address", address)
+		l.Close()
+		delete(inherited.listeners, address)
+	}
+	if inherited.readyFd == 0 {
+		return
+	}
+	ready := os.NewFile(uintptr(inherited.readyFd), "upgrade-ready")
+	inherited.readyFd = 0
+	defer ready.Close()
+	if _, err := ready.Write([]byte("ready\n")); err != nil {
+		slog.Error("Upgrade readiness not signaled", "error", err)
+	}
+}
+
+// Upgrade replaces the process by a new one running the current binary, with
+// the same arguments, without refusing a connection: the listening sockets,
+// TCP and unix, are passed to the new process, which serves them as soon as
+// it starts (see ListenInherited) and signals its readiness over a pipe. The
+// server then stops, see Stop, so that Start returns and the process exits.
+// Upgrade fails, the server serving on, when the new process exits or isn't
+// ready within ServerConfig.UpgradeTimeout. Upgrade and Restart wait for
+// each other, the listeners passed being the current ones.
+//
+//	signal.Notify(upgrade, syscall.SIGUSR2)
+//	go func() {
+//		for range upgrade {
+//			if err := server.Upgrade(); err != nil {
+//				slog.Error("Upgrade failed", "error", err)
+//			}
+//		}
+//	}()
+//
+// Returns:
+//   - error: ErrNotRunning when the server is not running, the error of the
+//     new process, otherwise the error of Stop.
+func (s *Server) Upgrade() error {
+	s.restartMut.Lock()
+	defer s.restartMut.Unlock()
+	s.stateMut.Lock()
+	current := s.current
+	running := s.state == StateRunning && !s.shuttingDown.Load() && current != nil
+	s.stateMut.Unlock()
+	if !running {
+		return ErrNotRunning
+	}
+
+	addresses := make([]string, 0, len(current.listeners))
+	files := make([]*os.File, 0, len(current.listeners)+1)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, l := range current.listeners {
+		filer, ok := l.Listener.(interface{ File() (*os.File, error) })
+		if l.address == "" || !ok {
+			return fmt.Errorf("upgrade: the listener %s cannot be passed", listenerAddr(l))
+		}
+		file, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("upgrade: %w", err)
+		}
+		addresses = append(addresses, l.address)
+		files = append(files, file)
+	}
+	encoded, err := json.Marshal(addresses)
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(upgradeEnv(),
+		inheritFdsEnv+"="+string(encoded),
+		readyFdEnv+"="+strconv.Itoa(listenFdsStart+len(addresses)),
+	)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	// Only the new process holds the writer: its exit ends the read.
+	readyWriter.Close()
+	files = files[:len(files)-1]
+	slog.Info("Server upgrading", "pid", cmd.Process.Pid)
+
+	timeout := s.config.UpgradeTimeout
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+	signaled := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := ready.Read(buf); err != nil {
+			signaled <- errors.New("upgrade: the new process exited before being ready")
+			return
+		}
+		signaled <- nil
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-signaled:
+	case <-timer.C:
+		err = fmt.Errorf("upgrade: the new process is not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return err
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	slog.Info("Server upgraded, stopping", "pid", pid)
+	for _, l := range current.listeners {
+		if unix, ok := l.Listener.(*net.UnixListener); ok {
+			// The socket file is the one of the new process now.
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	return s.Stop()
+}
+
+// upgradeEnv returns the environment of the process without the variables of
+// the socket activation and of a previous upgrade.
+func upgradeEnv() []string {
+	var env []string
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", inheritFdsEnv, readyFdEnv:
+			continue
+		}
+		env = append(env, variable)
+	}
+	return env
+}
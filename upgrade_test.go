@@ -0,0 +1,113 @@
+package serverlib
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUpgradeRefused checks Upgrade fails before starting a process when the
+// server is not running or a listener cannot be passed, the server serving on.
+func TestUpgradeRefused(t *testing.T) {
+	if err := newTestServer().Upgrade(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("not started: %v, want ErrNotRunning", err)
+	}
+	s := newTestServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	<-s.Ready()
+	// The listener of Serve has no configured address to take it by.
+	if err := s.Upgrade(); err == nil || !strings.Contains(err.Error(), "cannot be passed") {
+		t.Errorf("listener of Serve: %v", err)
+	}
+	if state := s.State(); state != StateRunning {
+		t.Errorf("state %v after a failed upgrade", state)
+	}
+	s.Stop()
+	waitStart(t, done)
+	if err := s.Upgrade(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("stopped: %v, want ErrNotRunning", err)
+	}
+}
+
+// TestUpgradeWaitsForRestart checks Upgrade reads the listeners once a
+// Restart in progress is done.
+func TestUpgradeWaitsForRestart(t *testing.T) {
+	s := newTestServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	<-s.Ready()
+	defer func() {
+		s.Stop()
+		waitStart(t, done)
+	}()
+	// A Restart in progress holds the lock.
+	s.restartMut.Lock()
+	upgraded := make(chan error, 1)
+	go func() {
+		upgraded <- s.Upgrade()
+	}()
+	select {
+	case err := <-upgraded:
+		t.Errorf("Upgrade returned %v during a Restart", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.restartMut.Unlock()
+	select {
+	case err := <-upgraded:
+		if err == nil || !strings.Contains(err.Error(), "cannot be passed") {
+			t.Errorf("Upgrade after the Restart: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Upgrade did not return after the Restart")
+	}
+}
+
+// TestListenInherited checks the addresses not passed by a parent process are
+// bound.
+func TestListenInherited(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		network string
+		wantErr bool
+	}{
+		{"tcp", "127.0.0.1:0", "tcp", false},
+		{"unix", UnixAddressPrefix + filepath.Join(t.TempDir(), "http.sock"), "unix", false},
+		{"invalid", "127.0.0.1:99999", "", true},
+		{"unix in a missing directory", UnixAddressPrefix + filepath.Join(t.TempDir(), "missing", "http.sock"), "", true},
+	}
+	for _, tt := range tests {
+		l, err := ListenInherited(tt.address)
+		if tt.wantErr {
+			var bindErr *BindError
+			if !errors.As(err, &bindErr) || bindErr.Address != tt.address {
+				t.Errorf("%s: %v, want a BindError of %s", tt.name, err, tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if network := l.Addr().Network(); network != tt.network {
+			t.Errorf("%s: network %s, want %s", tt.name, network, tt.network)
+		}
+		l.Close()
+	}
+}
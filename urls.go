@@ -0,0 +1,141 @@
+package serverlib
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrUnknownRoute is returned by URL for a name no route was given.
+var ErrUnknownRoute = errors.New("unknown route name")
+
+// Route is a registered route, returned by HandleFunc, Handle and the method
+// helpers to name it.
+type Route struct {
+	server *Server
+	index  int
+}
+
+// Name names the route, so that URL builds its URLs:
+//
+//	s.GET("/users/{id}", showUser).Name("user.show")
+//
+// It panics on a name given to another route already, like http.ServeMux
+// does for the conflicting patterns.
+//
+// Returns:
+//   - *Route: The route, for chaining.
+func (r *Route) Name(name string) *Route {
+	s := r.server
+	s.routesMut.Lock()
+	defer s.routesMut.Unlock()
+	if index, ok := s.routeNames[name]; ok && index != r.index {
+		panic(fmt.Sprintf("serverlib: route name %q given to %q already", name, s.routes[index].Pattern))
+	}
+	if previous := s.routes[r.index].Name; previous != "" {
+		delete(s.routeNames, previous)
+	}
+	s.routeNames[name] = r.index
+	s.routes[r.index].Name = name
+	return r
+}
+
+// update updates the description of the route, see Server.Routes.
+func (r *Route) update(fn func(*RouteInfo)) {
+	r.server.routesMut.Lock()
+	defer r.server.routesMut.Unlock()
+	fn(&r.server.routes[r.index])
+}
+
+// URL returns the path of the route named name, its wildcards replaced by the
+// parameters, prefixed with the base path (see Path). The templates call it as
+// url:
+//
+//	{{ url "user.show" "id" .User.ID }}
+//
+// Parameters:
+//   - name: The name of the route, see Route.Name.
+//   - params: The pairs of wildcard names and values, e.g. "id", 42. The
+//     values are formatted with fmt and escaped, but for the slashes of a
+//     remaining wildcard, e.g. {path...}.
+//
+// Returns:
+//   - string: The path, e.g. "/users/42".
+//   - error: ErrUnknownRoute, or the error of a parameter missing, extra or
+//     not named by a string.
+func (s *Server) URL(name string, params ...any) (string, error) {
+	s.routesMut.RLock()
+	index, ok := s.routeNames[name]
+	var path string
+	if ok {
+		path = s.routes[index].Path
+	}
+	s.routesMut.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownRoute, name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("route %q: parameter %v without a value", name, params[len(params)-1])
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("route %q: parameter name %v is not a string", name, params[i])
+		}
+		values[key] = fmt.Sprint(params[i+1])
+	}
+
+	// The host of the pattern, if any, is left out.
+	if slash := strings.IndexByte(path, '/'); slash > 0 {
+		path = path[slash:]
+	}
+	var b strings.Builder
+	used := make(map[string]bool, len(values))
+	for {
+		open := strings.IndexByte(path, '{')
+		if open < 0 {
+			b.WriteString(path)
+			break
+		}
+		end := strings.IndexByte(path[open:], '}')
+		if end < 0 {
+			b.WriteString(path)
+			break
+		}
+		b.WriteString(path[:open])
+		wildcard := path[open+1 : open+end]
+		path = path[open+end+1:]
+		if wildcard == "$" {
+			continue
+		}
+		key, remaining := strings.CutSuffix(wildcard, "...")
+		value, ok := values[key]
+		if !ok {
+			return "", fmt.Errorf("route %q: parameter %q missing", name, key)
+		}
+		used[key] = true
+		if remaining {
+			segments := strings.Split(value, "/")
+			for i, segment := range segments {
+				segments[i] = url.PathEscape(segment)
+			}
+			b.WriteString(strings.Join(segments, "/"))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+	}
+	if len(used) != len(values) {
+		var extra []string
+		for key := range values {
+			if !used[key] {
+				extra = append(extra, key)
+			}
+		}
+		slices.Sort(extra)
+		return "", fmt.Errorf("route %q: unexpected parameters %s", name, strings.Join(extra, ", "))
+	}
+	return s.Path(b.String()), nil
+}
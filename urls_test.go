@@ -0,0 +1,88 @@
+package serverlib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestURL builds the paths of the named routes.
+func TestURL(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	s.GET("/users/{id}", noop).Name("user.show")
+	s.GET("/users/{id}/posts/{post}", noop).Name("post.show")
+	s.GET("/files/{path...}", noop).Name("files")
+	s.GET("/exact/{$}", noop).Name("exact")
+	s.GET("admin.example.com/settings", noop).Name("settings")
+	s.HandleFunc("/about", noop).Name("about")
+	tests := []struct {
+		name    string
+		route   string
+		params  []any
+		want    string
+		wantErr string
+	}{
+		{"wildcard", "user.show", []any{"id", 42}, "/users/42", ""},
+		{"wildcards in any order", "post.show", []any{"post", "hello", "id", 1}, "/users/1/posts/hello", ""},
+		{"escaped", "user.show", []any{"id", "a/b c?"}, "/users/a%2Fb%20c%3F", ""},
+		{"remaining wildcard", "files", []any{"path", "docs/a b/index.html"}, "/files/docs/a%20b/index.html", ""},
+		{"end of path", "exact", nil, "/exact/", ""},
+		{"host left out", "settings", nil, "/settings", ""},
+		{"no wildcard", "about", nil, "/about", ""},
+		{"unknown route", "missing", nil, "", `unknown route name "missing"`},
+		{"missing parameter", "post.show", []any{"id", 1}, "", `parameter "post" missing`},
+		{"unexpected parameters", "user.show", []any{"id", 1, "page", 2, "sort", "x"}, "", "unexpected parameters page, sort"},
+		{"parameter without a value", "user.show", []any{"id"}, "", "parameter id without a value"},
+		{"parameter name not a string", "user.show", []any{1, 2}, "", "parameter name 1 is not a string"},
+	}
+	for _, tt := range tests {
+		got, err := s.URL(tt.route, tt.params...)
+		if got != tt.want || (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: %q, %v, want %q %q", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := s.URL("missing"); !errors.Is(err, ErrUnknownRoute) {
+		t.Errorf("unknown route: %v, want ErrUnknownRoute", err)
+	}
+}
+
+// TestRouteName names and renames routes: a name belongs to a single route.
+func TestRouteName(t *testing.T) {
+	s := NewServer(ServerConfig{})
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	route := s.GET("/users/{id}", noop).Name("user").Name("user")
+	if routes := s.Routes(); routes[0].Name != "user" {
+		t.Errorf("name %q in Routes", routes[0].Name)
+	}
+	route.Name("user.show")
+	if _, err := s.URL("user", "id", 1); !errors.Is(err, ErrUnknownRoute) {
+		t.Errorf("previous name kept: %v", err)
+	}
+	if url, err := s.URL("user.show", "id", 1); err != nil || url != "/users/1" {
+		t.Errorf("renamed: %q, %v", url, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("name given twice without a panic")
+		}
+	}()
+	s.GET("/people/{id}", noop).Name("user.show")
+}
+
+// TestURLTemplate builds the URLs of the routes in the templates.
+func TestURLTemplate(t *testing.T) {
+	s := newTemplateServer(t, map[string]string{
+		"link.html": `<a href="{{url "user.show" "id" .ID}}">user</a>`,
+	}, nil)
+	s.GET("/users/{id}", func(w http.ResponseWriter, r *http.Request) {}).Name("user.show")
+	w := httptest.NewRecorder()
+	if err := s.RenderRequest(w, httptest.NewRequest(http.MethodGet, "/", nil), "link.html", map[string]any{"ID": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<a href="/users/7">user</a>`; w.Body.String() != want {
+		t.Errorf("%q, want %q", w.Body.String(), want)
+	}
+}
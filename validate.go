@@ -0,0 +1,159 @@
+package serverlib
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by values checking themselves. Validate calls it
+// after the struct tag rules.
+type Validator interface {
+	Validate() error
+}
+
+// Validate checks the struct v (or pointer to struct) against the rules of
+// its `validate` struct tags, then calls its Validate method if it implements
+// Validator. The supported rules, separated by commas, are:
+//   - required: the value must not be the zero value.
+//   - min=n, max=n: the bounds of numbers, or of the length of strings, slices and maps.
+//   - email: the string must be an email address.
+//   - oneof=a b c: the value must be one of the space separated values.
+//
+// The fields are named after their json tag when they have one, and may have
+// a label tag: the key of their name for the users in the catalog of the
+// server, or a literal text, see Server.Localize. The messages of the rules
+// are the validation.<rule> keys of the catalog, e.g. "validation.required",
+// ".length" appended for the min and max rules on lengths. No server is known
+// here: the messages are set from the built-in catalog and translated with
+// the catalog of the server by Server.Localize and Server.Error.
+// All the failures are reported together as ValidationErrors.
+func Validate(v any) error {
+	var errs ValidationErrors
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Struct {
+		validateStruct(rv, &errs)
+	}
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			var fieldErrors ValidationErrors
+			if !errors.As(err, &fieldErrors) {
+				return err
+			}
+			errs = append(errs, fieldErrors...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, errs *ValidationErrors) {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := rv.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			validateStruct(value, errs)
+			continue
+		}
+		rules := field.Tag.Get("validate")
+		if rules == "" || rules == "-" {
+			continue
+		}
+		name := fieldName(field)
+		for _, rule := range strings.Split(rules, ",") {
+			if key, params := checkRule(value, rule); key != "" {
+				errs.addKey(builtinMessages, name, field.Tag.Get("label"), key, params)
+				break
+			}
+		}
+	}
+}
+
+// fieldName returns the name of a field as seen by the client.
+func fieldName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+// checkRule returns the message key and parameters of why value breaks the
+// rule, or an empty key.
+func checkRule(value reflect.Value, rule string) (string, map[string]any) {
+	rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			if rule == "required" {
+				return "validation.required", nil
+			}
+			return "", nil
+		}
+		value = value.Elem()
+	}
+	switch rule {
+	case "required":
+		if value.IsZero() {
+			return "validation.required", nil
+		}
+	case "min", "max":
+		bound, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "validation.rule", map[string]any{"rule": rule, "param": strconv.Quote(param)}
+		}
+		size, isLength, ok := measure(value)
+		if !ok {
+			return "", nil
+		}
+		if rule == "min" && size < bound || rule == "max" && size > bound {
+			key := "validation." + rule
+			if isLength {
+				key += ".length"
+			}
+			return key, map[string]any{rule: param}
+		}
+	case "email":
+		if value.Kind() == reflect.String && value.String() != "" {
+			if address, err := mail.ParseAddress(value.String()); err != nil || address.Address != value.String() {
+				return "validation.email", nil
+			}
+		}
+	case "oneof":
+		allowed := strings.Fields(param)
+		if !value.IsZero() && !slices.Contains(allowed, fmt.Sprint(value.Interface())) {
+			return "validation.oneof", map[string]any{"values": strings.Join(allowed, ", ")}
+		}
+	}
+	return "", nil
+}
+
+// measure returns the number the min and max rules compare: the value of
+// numbers, the length of strings, slices and maps.
+func measure(value reflect.Value) (size float64, isLength bool, ok bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), false, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,180 @@
+package serverlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultWarmupConcurrency is the number of warm-up tasks run at once when
+// ServerConfig.WarmupConcurrency is not set.
+const DefaultWarmupConcurrency = 4
+
+// WarmupOption configures a warm-up task registered with OnWarmup.
+type WarmupOption func(*warmupTask)
+
+// WarmupNonFatal logs the failure of the task instead of failing Warmup, for
+// the tasks merely saving time on the first requests, e.g. priming a cache.
+func WarmupNonFatal() WarmupOption {
+	return func(t *warmupTask) {
+		t.fatal = false
+	}
+}
+
+// warmupTask is a task of Warmup.
+type warmupTask struct {
+	name  string
+	fn    func(ctx context.Context) error
+	fatal bool
+}
+
+// WarmupResult is the outcome of a warm-up task, see WarmupResults.
+type WarmupResult struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	// Fatal reports whether the failure of the task fails Warmup.
+	Fatal bool
+}
+
+// warmups holds the warm-up tasks of the server.
+type warmups struct {
+	tasks   []*warmupTask
+	results []WarmupResult
+	warmed  bool
+	mut     *sync.Mutex
+}
+
+// OnWarmup registers a warm-up task, run by Warmup before the server accepts
+// traffic, such as loading a catalog or priming a cache. The server registers
+// the parsing of the templates as "templates". The tasks run concurrently, in
+// no particular order: a task depending on another one does both.
+//
+// Parameters:
+//   - name: The name of the task, in the logs and the results.
+//   - fn: The task; it must return once ctx is done.
+//   - opts: Optional options, e.g. WarmupNonFatal. A failing task fails
+//     Warmup by default.
+func (s *Server) OnWarmup(name string, fn func(ctx context.Context) error, opts ...WarmupOption) {
+	task := &warmupTask{name: name, fn: fn, fatal: true}
+	for _, opt := range opts {
+		opt(task)
+	}
+	s.warmups.mut.Lock()
+	defer s.warmups.mut.Unlock()
+	s.warmups.tasks = append(s.warmups.tasks, task)
+	slog.Info("Registred warm-up", "name", name, "fatal", task.fatal)
+}
+
+// Warmup runs the warm-up tasks registered with OnWarmup concurrently, at
+// most ServerConfig.WarmupConcurrency at once, and logs their durations, see
+// WarmupResults. Run and RunContext call it before binding the listeners,
+// or in the background with ServerConfig.WarmupInBackground, unless it
+// already succeeded.
+//
+// Parameters:
+//   - ctx: The context of the tasks; the tasks not started when it is done
+//     fail with its error.
+//
+// Returns:
+//   - error: The errors of the fatal tasks that failed, joined.
+func (s *Server) Warmup(ctx context.Context) error {
+	w := s.warmups
+	w.mut.Lock()
+	tasks := slices.Clone(w.tasks)
+	w.mut.Unlock()
+	limit := s.config.WarmupConcurrency
+	if limit <= 0 {
+		limit = DefaultWarmupConcurrency
+	}
+	start := time.Now()
+	results := make([]WarmupResult, len(tasks))
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := WarmupResult{Name: task.name, Fatal: task.fatal}
+			select {
+			case slots <- struct{}{}:
+				// The slot and the end of ctx may be ready together.
+				if result.Err = ctx.Err(); result.Err == nil {
+					taskStart := time.Now()
+					result.Err = runWarmup(ctx, task)
+					result.Duration = time.Since(taskStart)
+				}
+				<-slots
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	var errs []error
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			slog.Info("Warm-up done", "name", result.Name, "duration", result.Duration)
+		case result.Fatal:
+			slog.Error("Warm-up failed", "name", result.Name, "duration", result.Duration, "error", result.Err)
+			errs = append(errs, fmt.Errorf("warm-up %s: %w", result.Name, result.Err))
+		default:
+			slog.Warn("Warm-up failed", "name", result.Name, "duration", result.Duration, "error", result.Err)
+		}
+	}
+	err := errors.Join(errs...)
+	w.mut.Lock()
+	w.results = results
+	w.warmed = err == nil
+	w.mut.Unlock()
+	slog.Info("Warm-up completed", "tasks", len(tasks), "duration", time.Since(start), "failed", len(errs))
+	return err
+}
+
+// runWarmup runs a warm-up task, converting a panic into an error.
+func runWarmup(ctx context.Context, task *warmupTask) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("warm-up panicked: %v", p)
+		}
+	}()
+	return task.fn(ctx)
+}
+
+// WarmupResults returns the outcome of the tasks of the last Warmup, in the
+// order of their registration.
+func (s *Server) WarmupResults() []WarmupResult {
+	s.warmups.mut.Lock()
+	defer s.warmups.mut.Unlock()
+	return slices.Clone(s.warmups.results)
+}
+
+// warmupBeforeStart runs Warmup for Run, unless it already succeeded.
+func (s *Server) warmupBeforeStart(ctx context.Context) error {
+	s.warmups.mut.Lock()
+	warmed := s.warmups.warmed
+	s.warmups.mut.Unlock()
+	if warmed {
+		return nil
+	}
+	if s.config.WarmupInBackground {
+		go s.Warmup(ctx)
+		return nil
+	}
+	return s.Warmup(ctx)
+}
+
+// warmTemplates is the "templates" warm-up task.
+func (s *Server) warmTemplates(context.Context) error {
+	if err := s.t.Parse(); err != nil {
+		return err
+	}
+	s.templatesParsed.Store(true)
+	return nil
+}
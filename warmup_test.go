@@ -0,0 +1,209 @@
+package serverlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWarmupConcurrency runs more tasks than WarmupConcurrency: at most
+// WarmupConcurrency run at once, and each one runs.
+func TestWarmupConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		want        int32
+	}{
+		{"limited", 2, 2},
+		{"one at a time", 1, 1},
+		{"default", 0, DefaultWarmupConcurrency},
+	}
+	for _, tt := range tests {
+		s := NewServer(ServerConfig{Address: "127.0.0.1:0", WarmupConcurrency: tt.concurrency})
+		var running, peak, runs atomic.Int32
+		for range 8 {
+			s.OnWarmup("task", func(context.Context) error {
+				n := running.Add(1)
+				for old := peak.Load(); n > old && !peak.CompareAndSwap(old, n); old = peak.Load() {
+				}
+				time.Sleep(20 * time.Millisecond)
+				running.Add(-1)
+				runs.Add(1)
+				return nil
+			})
+		}
+		if err := s.Warmup(context.Background()); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if peak.Load() != tt.want || runs.Load() != 8 {
+			t.Errorf("%s: %d at once, %d runs, want %d at once", tt.name, peak.Load(), runs.Load(), tt.want)
+		}
+	}
+}
+
+// TestWarmupResults checks the results are recorded in the order of the
+// registration with their durations, the panics and the tasks not started
+// before the end of the context failing.
+func TestWarmupResults(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", WarmupConcurrency: 1})
+	failure := errors.New("catalog unavailable")
+	s.OnWarmup("slow", func(context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	s.OnWarmup("failing", func(context.Context) error { return failure })
+	s.OnWarmup("cache", func(context.Context) error { return failure }, WarmupNonFatal())
+	s.OnWarmup("panicking", func(context.Context) error { panic("boom") })
+	err := s.Warmup(context.Background())
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "warm-up failing") || !strings.Contains(err.Error(), "warm-up panicking") || strings.Contains(err.Error(), "cache") {
+		t.Errorf("Warmup: %v", err)
+	}
+	results := s.WarmupResults()
+	tests := []struct {
+		name    string
+		fatal   bool
+		wantErr error
+	}{
+		{"templates", true, nil},
+		{"slow", true, nil},
+		{"failing", true, failure},
+		{"cache", false, failure},
+		{"panicking", true, nil},
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("results %v", results)
+	}
+	for i, tt := range tests {
+		got := results[i]
+		if got.Name != tt.name || got.Fatal != tt.fatal || (tt.wantErr != nil && !errors.Is(got.Err, tt.wantErr)) {
+			t.Errorf("%d: %+v, want %s fatal %v error %v", i, got, tt.name, tt.fatal, tt.wantErr)
+		}
+	}
+	if results[1].Duration < 50*time.Millisecond || results[1].Err != nil {
+		t.Errorf("slow: %+v, want at least 50ms", results[1])
+	}
+	if err := results[4].Err; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("panicking: %v", err)
+	}
+	if !strings.Contains(logs.String(), `level=WARN msg="Warm-up failed" name=cache`) || !strings.Contains(logs.String(), `level=ERROR msg="Warm-up failed" name=failing`) {
+		t.Errorf("logs %s", logs.String())
+	}
+
+	// The tasks waiting for a slot when the context is done fail with its
+	// error, without running.
+	s = NewServer(ServerConfig{Address: "127.0.0.1:0", WarmupConcurrency: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran atomic.Int32
+	for range 3 {
+		s.OnWarmup("task", func(ctx context.Context) error {
+			ran.Add(1)
+			cancel()
+			return nil
+		})
+	}
+	err = s.Warmup(ctx)
+	if !errors.Is(err, context.Canceled) || ran.Load() != 1 {
+		t.Errorf("canceled: %v, %d tasks run", err, ran.Load())
+	}
+}
+
+// TestWarmupBeforeStart runs RunContext with a task blocked: the server is
+// not ready before the task returns, a fatal failure aborts the start, and
+// a non-fatal failure is only logged.
+func TestWarmupBeforeStart(t *testing.T) {
+	failure := errors.New("catalog unavailable")
+	tests := []struct {
+		name string
+		opts []WarmupOption
+		err  error
+		// wantStart tells whether the server starts.
+		wantStart bool
+	}{
+		{"succeeding", nil, nil, true},
+		{"fatal failure", nil, failure, false},
+		{"non-fatal failure", []WarmupOption{WarmupNonFatal()}, failure, true},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		release := make(chan struct{})
+		s.OnWarmup("catalog", func(context.Context) error {
+			<-release
+			return tt.err
+		}, tt.opts...)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- s.RunContext(ctx)
+		}()
+		select {
+		case <-s.Ready():
+			t.Errorf("%s: ready before the warm-up", tt.name)
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		if tt.wantStart {
+			select {
+			case <-s.Ready():
+			case <-time.After(10 * time.Second):
+				t.Errorf("%s: not ready after the warm-up", tt.name)
+			}
+		}
+		if !tt.wantStart {
+			err := waitStart(t, done)
+			if !errors.Is(err, failure) || s.State() != StateCreated {
+				t.Errorf("%s: %v, state %v", tt.name, err, s.State())
+			}
+			select {
+			case <-s.Ready():
+				t.Errorf("%s: ready after a fatal failure", tt.name)
+			default:
+			}
+			cancel()
+			continue
+		}
+		cancel()
+		if err := waitStart(t, done); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+// TestWarmupInBackground checks the server starts before the warm-up returns.
+func TestWarmupInBackground(t *testing.T) {
+	s := NewServer(ServerConfig{Address: "127.0.0.1:0", WarmupInBackground: true})
+	release := make(chan struct{})
+	var done atomic.Bool
+	s.OnWarmup("catalog", func(context.Context) error {
+		<-release
+		done.Store(true)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.RunContext(ctx)
+	}()
+	select {
+	case <-s.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("not ready before the warm-up")
+	}
+	if done.Load() {
+		t.Error("warm-up done before it was released")
+	}
+	close(release)
+	cancel()
+	if err := waitStart(t, served); err != nil {
+		t.Error(err)
+	}
+}